@@ -0,0 +1,406 @@
+// Command billmail receives vendor bills by email and keeps the queue of
+// draft AP documents they become.
+//
+// Usage:
+//
+//	billmail migrate
+//	billmail serve -config billmail.json
+//	billmail queue -org <organizationId> [-status draft] [-limit 50]
+//	billmail show -org <organizationId> -id <documentId>
+//	billmail approve -org <organizationId> -id <documentId> [-vendor id] [-total 1250] [-tax 50] \
+//	    [-number INV-1] [-issued 2024-07-01] [-due 2024-07-31] [-account expenseAccountId] [-dim cost_center=HQ] [-by user]
+//	billmail reject -org <organizationId> -id <documentId> -note "duplicate" [-by user]
+//	billmail attachment -id <attachmentId> -config billmail.json [-o file]
+//	billmail send -addr localhost:2525 -from ap@vendor.com -to bills@example.com \
+//	    -subject "Invoice INV-1 USD 125.00" [-eml message.eml | attachments...]
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/attachments"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/billmail"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/dimensions"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openCashflow(ctx)
+		tables := append(append([]schema.Table{}, attachments.Tables...), billmail.Tables...)
+		if err := schema.Ensure(ctx, conn, tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("attachments, inbound_emails and ap_documents are up to date")
+	case "serve":
+		runServe(ctx, args)
+	case "queue":
+		runQueue(ctx, args)
+	case "show":
+		runShow(ctx, args)
+	case "approve", "reject":
+		runReview(ctx, cmd, args)
+	case "attachment":
+		runAttachment(ctx, args)
+	case "send":
+		runSend(args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: billmail migrate|serve|queue|show|approve|reject|attachment|send [flags]")
+	os.Exit(2)
+}
+
+func runServe(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	cfgPath := fs.String("config", "billmail.json", "receiver configuration file")
+	fs.Parse(args)
+
+	cfg, err := billmail.LoadConfig(*cfgPath)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	logger := log.New(os.Stdout, "billmail ", log.LstdFlags)
+	srv := &billmail.Server{
+		Addr:            cfg.Listen,
+		Hostname:        cfg.Hostname,
+		MaxMessageBytes: cfg.MaxMessageBytes,
+		Logger:          logger,
+		Delivery: &billmail.Receiver{
+			Config: cfg,
+			DB:     conn,
+			Store:  attachments.Dir(cfg.AttachmentDir),
+			Logger: logger,
+		},
+	}
+
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		<-stop
+		srv.Shutdown()
+	}()
+
+	logger.Printf("listening on %s for %d mailbox(es)", cfg.Listen, len(cfg.Mailboxes))
+	if err := srv.ListenAndServe(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runQueue(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("queue", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	status := fs.String("status", billmail.StatusDraft, "draft, approved or rejected; empty for all")
+	limit := fs.Int("limit", 50, "maximum rows")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("queue: -org is required")
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	docs, err := billmail.Documents(ctx, conn, *org, *status, *limit)
+	if err != nil {
+		log.Fatalf("queue: %v", err)
+	}
+	if len(docs) == 0 {
+		fmt.Println("No documents")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tRECEIVED\tSTATUS\tSENDER\tVENDOR\tBILL\tDUE\tTOTAL\tHINTS")
+	for _, d := range docs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s %s\t%s\n", d.ID, d.CreatedAt.Format("2006-01-02 15:04"),
+			d.Status, d.Sender, vendorLabel(d), d.BillNumber, d.DueDate, d.Total, d.Currency, d.HintSource)
+	}
+	w.Flush()
+}
+
+func vendorLabel(d *billmail.Document) string {
+	if d.VendorID == "" {
+		return "-"
+	}
+	return fmt.Sprintf("%s (%s)", d.VendorName, d.VendorMatch)
+}
+
+func runShow(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	id := fs.String("id", "", "document id")
+	fs.Parse(args)
+	if *org == "" || *id == "" {
+		log.Fatal("show: -org and -id are required")
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	d, err := billmail.GetDocument(ctx, conn, *org, *id, false)
+	if err != nil {
+		log.Fatalf("show: %v", err)
+	}
+	files, err := attachments.List(ctx, conn, billmail.OwnerDocument, d.ID)
+	if err != nil {
+		log.Fatalf("show: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Document\t%s (%s)\n", d.ID, d.Status)
+	fmt.Fprintf(w, "From\t%s\n", d.Sender)
+	fmt.Fprintf(w, "Subject\t%s\n", d.Subject)
+	fmt.Fprintf(w, "Vendor\t%s\n", vendorLabel(d))
+	fmt.Fprintf(w, "Bill number\t%s\n", d.BillNumber)
+	fmt.Fprintf(w, "Issued / due\t%s / %s\n", d.IssueDate, d.DueDate)
+	fmt.Fprintf(w, "Total (tax)\t%s %s (%s)\n", d.Total, d.Currency, d.Tax)
+	fmt.Fprintf(w, "Hints\t%s %s\n", d.HintSource, d.Hints)
+	if d.ReviewedAt != nil {
+		fmt.Fprintf(w, "Reviewed\t%s by %s %s\n", d.ReviewedAt.Format("2006-01-02 15:04"), d.ReviewedBy, d.ReviewNote)
+	}
+	if d.JournalID != "" {
+		fmt.Fprintf(w, "Journal\t%s\n", d.JournalID)
+	}
+	for _, f := range files {
+		fmt.Fprintf(w, "Attachment\t%s  %s  %s  %d bytes\n", f.ID, f.FileName, f.ContentType, f.Size)
+	}
+	w.Flush()
+}
+
+func runReview(ctx context.Context, cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	id := fs.String("id", "", "document id")
+	vendor := fs.String("vendor", "", "vendor id, when none was matched or the match is wrong")
+	number := fs.String("number", "", "bill number")
+	issued := fs.String("issued", "", "bill date, YYYY-MM-DD")
+	due := fs.String("due", "", "due date, YYYY-MM-DD")
+	total := fs.String("total", "", "bill total including tax")
+	tax := fs.String("tax", "", "tax included in the total")
+	account := fs.String("account", "", "expense ledger account id (default: first expense account)")
+	dims := fs.String("dim", "", "dimension values for the posted bill, e.g. cost_center=HQ,project=P-12")
+	note := fs.String("note", "", "review note")
+	by := fs.String("by", os.Getenv("USER"), "reviewer")
+	fs.Parse(args)
+	if *org == "" || *id == "" {
+		log.Fatalf("%s: -org and -id are required", cmd)
+	}
+	values, err := dimensions.ParseTags(*dims)
+	if err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+	if cmd == "reject" && *note == "" {
+		log.Fatal("reject: -note is required")
+	}
+
+	rv := &billmail.Review{
+		VendorID:         *vendor,
+		BillNumber:       *number,
+		IssueDate:        *issued,
+		DueDate:          *due,
+		ExpenseAccountID: *account,
+		Dimensions:       values,
+		ReviewedBy:       *by,
+		Note:             *note,
+	}
+	rv.Total = optionalAmount(cmd, *total)
+	rv.Tax = optionalAmount(cmd, *tax)
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	if cmd == "reject" {
+		if _, err := billmail.Reject(ctx, conn, *org, *id, rv); err != nil {
+			log.Fatalf("reject: %v", err)
+		}
+		fmt.Printf("Document %s rejected\n", *id)
+		return
+	}
+	d, err := billmail.Approve(ctx, conn, *org, *id, rv)
+	if err != nil {
+		log.Fatalf("approve: %v", err)
+	}
+	fmt.Printf("Posted bill %s from %s for %s %s (journal %s)\n", d.BillNumber, d.VendorName, d.Total, d.Currency, d.JournalID)
+}
+
+func optionalAmount(cmd, s string) *money.Amount {
+	if s == "" {
+		return nil
+	}
+	a, err := money.Parse(s)
+	if err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+	return &a
+}
+
+func runAttachment(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("attachment", flag.ExitOnError)
+	cfgPath := fs.String("config", "billmail.json", "receiver configuration file")
+	id := fs.String("id", "", "attachment id")
+	out := fs.String("o", "", "output file (default: the attachment's name)")
+	fs.Parse(args)
+	if *id == "" {
+		log.Fatal("attachment: -id is required")
+	}
+
+	cfg, err := billmail.LoadConfig(*cfgPath)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	a, err := attachments.Get(ctx, conn, *id)
+	if err != nil {
+		log.Fatalf("attachment: %v", err)
+	}
+	r, err := attachments.Dir(cfg.AttachmentDir).Open(ctx, a.StorageKey)
+	if err != nil {
+		log.Fatalf("attachment: %v", err)
+	}
+	defer r.Close()
+
+	if *out == "" {
+		*out = a.FileName
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Wrote %s (%d bytes)\n", *out, a.Size)
+}
+
+// runSend is the local stand-in for a vendor's mail server: it delivers a
+// raw .eml, or a message built from a subject and attachment files, to the
+// receiver over SMTP.
+func runSend(args []string) {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:2525", "receiver SMTP address")
+	from := fs.String("from", "", "sender address")
+	to := fs.String("to", "", "inbound mailbox address")
+	subject := fs.String("subject", "", "subject line")
+	body := fs.String("body", "Please find our bill attached.", "text body")
+	eml := fs.String("eml", "", "send this raw message instead of building one")
+	fs.Parse(args)
+	if *from == "" || *to == "" {
+		log.Fatal("send: -from and -to are required")
+	}
+
+	var msg []byte
+	var err error
+	if *eml != "" {
+		msg, err = os.ReadFile(*eml)
+	} else {
+		msg, err = buildMessage(*from, *to, *subject, *body, fs.Args())
+	}
+	if err != nil {
+		log.Fatalf("send: %v", err)
+	}
+	if err := smtp.SendMail(*addr, nil, *from, []string{*to}, msg); err != nil {
+		log.Fatalf("send: %v", err)
+	}
+	fmt.Printf("Sent %d bytes to %s via %s\n", len(msg), *to, *addr)
+}
+
+func buildMessage(from, to, subject, body string, files []string) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMessage-ID: <%s@billmail.local>\r\n",
+		from, to, mime.QEncoding.Encode("utf-8", subject), time.Now().Format(time.RFC1123Z), cashflow.NewID("send"))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	text, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	io.WriteString(text, body+"\r\n")
+
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Base(path)
+		ctype := mime.TypeByExtension(filepath.Ext(name))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {ctype},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64(part, data)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeBase64 writes data base64-encoded in 76-character lines.
+func writeBase64(w io.Writer, data []byte) {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 76 {
+		io.WriteString(w, enc[:76]+"\r\n")
+		enc = enc[76:]
+	}
+	io.WriteString(w, enc+"\r\n")
+}
+
+func openCashflow(ctx context.Context) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	conn, err := db.Open(ctx, "cashflow", cfg.CashflowDSN)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
@@ -0,0 +1,268 @@
+// Command books keeps adjustment-only ledger books (tax, management) over
+// an organization's main OA ledger and reports any book or combination of
+// books, including a deferred tax reconciliation between two views.
+//
+// Usage:
+//
+//	books migrate
+//	books define -org <organizationId> -code tax -name "Tax book" [-oa-org <oaOrgId>] [-rate 0.25] [-deferred-tax-account "Liabilities:Deferred Tax"]
+//	books list -org <organizationId>
+//	books tag -org <organizationId> -book tax -transaction <oaTransactionId>
+//	books untag -org <organizationId> -transaction <oaTransactionId>
+//	books report -org <organizationId> -books main,tax [-kind tb|pl] [-from 2024-01-01] [-to 2024-12-31]
+//	books deferred-tax -org <organizationId> -book tax [-management main] [-rate 0.25] -from 2024-01-01 -to 2024-12-31
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/books"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openDB(ctx, "cashflow")
+		if err := schema.Ensure(ctx, conn, books.Tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("books and book_transactions are up to date")
+	case "define":
+		runDefine(ctx, args)
+	case "list":
+		runList(ctx, args)
+	case "tag":
+		runTag(ctx, args)
+	case "untag":
+		runUntag(ctx, args)
+	case "report":
+		runReport(ctx, args)
+	case "deferred-tax":
+		runDeferredTax(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: books migrate|define|list|tag|untag|report|deferred-tax [flags]")
+	os.Exit(2)
+}
+
+func runDefine(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("define", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	code := fs.String("code", "", "book code, e.g. tax")
+	name := fs.String("name", "", "display name")
+	oaOrg := fs.String("oa-org", "", "OA org holding the book's adjustments (default: tagged transactions in the main org)")
+	rate := fs.Float64("rate", 0, "tax rate for deferred tax, e.g. 0.25")
+	account := fs.String("deferred-tax-account", "", "OA account path of the deferred tax balance")
+	fs.Parse(args)
+	if *org == "" || *code == "" {
+		log.Fatal("define: -org and -code are required")
+	}
+
+	b := &books.Book{
+		OrganizationID: *org, Code: *code, Name: *name, Kind: books.KindTagged, OAOrgID: *oaOrg,
+		TaxRate: *rate, DeferredTaxAccount: *account,
+	}
+	if *oaOrg != "" {
+		b.Kind = books.KindOrg
+	}
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+	if err := books.Define(ctx, cf, oadb, b); err != nil {
+		log.Fatalf("define: %v", err)
+	}
+	fmt.Printf("Book %s saved (%s)\n", b.Code, b.Kind)
+}
+
+func runList(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("list: -org is required")
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+	list, err := books.List(ctx, conn, *org)
+	if err != nil {
+		log.Fatalf("list: %v", err)
+	}
+	if len(list) == 0 {
+		fmt.Println("No books besides main")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CODE\tNAME\tKIND\tOA ORG\tTAX RATE\tDEFERRED TAX ACCOUNT")
+	for _, b := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%g\t%s\n", b.Code, b.Name, b.Kind, b.OAOrgID, b.TaxRate, b.DeferredTaxAccount)
+	}
+	w.Flush()
+}
+
+func runTag(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("tag", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	book := fs.String("book", "", "book code")
+	tx := fs.String("transaction", "", "OA transaction id in the main org")
+	by := fs.String("by", os.Getenv("USER"), "who tagged it")
+	fs.Parse(args)
+	if *org == "" || *book == "" || *tx == "" {
+		log.Fatal("tag: -org, -book and -transaction are required")
+	}
+
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+	b, err := books.Get(ctx, cf, *org, *book)
+	if err != nil {
+		log.Fatalf("tag: %v", err)
+	}
+	if err := books.Tag(ctx, cf, oadb, b, *tx, *by); err != nil {
+		log.Fatalf("tag: %v", err)
+	}
+	fmt.Printf("Transaction %s moved to book %s\n", *tx, *book)
+}
+
+func runUntag(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("untag", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	tx := fs.String("transaction", "", "OA transaction id")
+	fs.Parse(args)
+	if *org == "" || *tx == "" {
+		log.Fatal("untag: -org and -transaction are required")
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+	if err := books.Untag(ctx, conn, *org, *tx); err != nil {
+		log.Fatalf("untag: %v", err)
+	}
+	fmt.Printf("Transaction %s is back in the main book\n", *tx)
+}
+
+func runReport(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	list := fs.String("books", books.Main, "comma-separated books to show and combine")
+	kind := fs.String("kind", "tb", "tb (trial balance) or pl (profit and loss)")
+	from := fs.String("from", "", "first day of the P&L period")
+	to := fs.String("to", time.Now().Format(time.DateOnly), "last day of the period")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("report: -org is required")
+	}
+	reportKind := books.TrialBalance
+	switch *kind {
+	case "tb":
+	case "pl":
+		reportKind = books.ProfitAndLoss
+		if *from == "" {
+			log.Fatal("report: -from is required for a P&L")
+		}
+	default:
+		log.Fatalf("report: unknown kind %q", *kind)
+	}
+
+	l := load(ctx, *org, *from, *to)
+	codes, err := l.Codes(*list)
+	if err != nil {
+		log.Fatalf("report: %v", err)
+	}
+	l.Print(os.Stdout, reportKind, codes)
+}
+
+func runDeferredTax(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("deferred-tax", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	book := fs.String("book", "", "tax book; the tax view is main plus this book")
+	management := fs.String("management", books.Main, "comma-separated books making the management view")
+	rate := fs.Float64("rate", 0, "tax rate (default: the book's)")
+	account := fs.String("account", "", "deferred tax account path (default: the book's)")
+	from := fs.String("from", "", "first day of the period")
+	to := fs.String("to", time.Now().Format(time.DateOnly), "last day of the period")
+	fs.Parse(args)
+	if *org == "" || *book == "" || *from == "" {
+		log.Fatal("deferred-tax: -org, -book and -from are required")
+	}
+
+	l := load(ctx, *org, *from, *to)
+	b := l.Books[*book]
+	if b == nil || b.Code == books.Main {
+		log.Fatalf("deferred-tax: unknown tax book %q", *book)
+	}
+	mgmt, err := l.Codes(*management)
+	if err != nil {
+		log.Fatalf("deferred-tax: %v", err)
+	}
+	if *rate == 0 {
+		*rate = b.TaxRate
+	}
+	if *rate <= 0 {
+		log.Fatal("deferred-tax: the book has no tax rate; pass -rate")
+	}
+	if *account == "" {
+		*account = b.DeferredTaxAccount
+	}
+	l.DeferredTax(mgmt, []string{books.Main, b.Code}, *rate, *account).Print(os.Stdout)
+}
+
+func load(ctx context.Context, org, from, to string) *books.Ledger {
+	var start time.Time
+	if from != "" {
+		start = parseDay(from)
+	}
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+	l, err := books.Load(ctx, cf, oadb, org, start, parseDay(to).AddDate(0, 0, 1))
+	if err != nil {
+		log.Fatalf("load books: %v", err)
+	}
+	return l
+}
+
+func parseDay(s string) time.Time {
+	t, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+	if err != nil {
+		log.Fatalf("invalid date %q; use YYYY-MM-DD", s)
+	}
+	return t
+}
+
+func openDB(ctx context.Context, name string) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	dsn := cfg.CashflowDSN
+	if name == "oa" {
+		dsn = cfg.OADSN
+	}
+	conn, err := db.Open(ctx, name, dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
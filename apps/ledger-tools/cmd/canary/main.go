@@ -0,0 +1,210 @@
+// Command canary runs a synthetic sale through the live stack against a
+// dedicated canary organization: a customer, a confirmed invoice and its
+// payment through the BFF, the sale posted to OA, both trial balances
+// checked, and everything reversed again. Step latencies and outcomes are
+// served as metrics and failures are alerted.
+//
+// Usage:
+//
+//	canary migrate
+//	canary run -config canary.json
+//	canary once -config canary.json
+//	canary history -org <organizationId> [-failed] [-limit 20]
+//
+// once runs the canary a single time and exits 1 if it failed.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/canary"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openDB(ctx, "cashflow")
+		if err := schema.Ensure(ctx, conn, canary.Tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("canary_runs is up to date")
+	case "run", "once":
+		runMonitor(ctx, cmd, args)
+	case "history":
+		runHistory(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: canary migrate|run|once|history [flags]")
+	os.Exit(2)
+}
+
+func runMonitor(ctx context.Context, cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	cfgPath := fs.String("config", "canary.json", "monitor configuration file")
+	fs.Parse(args)
+
+	cfg, err := canary.LoadConfig(*cfgPath)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	env, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	client, err := oa.NewClient(env)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	m := &canary.Monitor{
+		Config:  cfg,
+		DB:      openDB(ctx, "cashflow"),
+		OA:      openDB(ctx, "oa"),
+		Poster:  client,
+		Metrics: &canary.Metrics{},
+		Logger:  log.New(os.Stdout, "canary ", log.LstdFlags),
+	}
+	defer m.DB.Close()
+	defer m.OA.Close()
+	if cfg.Alert.URL != "" {
+		m.Alerter = &canary.Alerter{Config: cfg.Alert}
+	}
+	if cmd == "once" {
+		r := m.RunOnce(ctx, time.Now())
+		printSteps(r)
+		if !r.OK {
+			os.Exit(1)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if cfg.MetricsListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m.Metrics)
+		mux.HandleFunc("/healthz", m.Metrics.Healthz)
+		srv := &http.Server{Addr: cfg.MetricsListen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				m.Logger.Printf("metrics: %v", err)
+			}
+		}()
+		defer srv.Close()
+		m.Logger.Printf("serving metrics on %s/metrics", cfg.MetricsListen)
+	}
+	m.Logger.Printf("running against %s every %s", cfg.OrganizationID, time.Duration(cfg.Interval))
+	if err := m.Run(ctx); err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+}
+
+func printSteps(r *canary.RunRecord) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tRESULT\tSECONDS\tERROR")
+	for _, s := range r.Steps {
+		fmt.Fprintf(w, "%s\t%s\t%.3f\t%s\n", s.Name, result(s.OK), s.Seconds, s.Error)
+	}
+	w.Flush()
+	fmt.Printf("\nRun %s %s (trace %s)\n", r.ID, result(r.OK), r.TraceID)
+}
+
+func runHistory(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	org := fs.String("org", "", "canary organization id")
+	failed := fs.Bool("failed", false, "only failed runs")
+	limit := fs.Int("limit", 20, "maximum rows")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("history: -org is required")
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+
+	runs, err := canary.Runs(ctx, conn, *org, *failed, *limit)
+	if err != nil {
+		log.Fatalf("history: %v", err)
+	}
+	if len(runs) == 0 {
+		fmt.Println("No runs")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := []string{"ID", "STARTED", "RESULT"}
+	for _, s := range canary.Steps {
+		header = append(header, strings.ToUpper(s))
+	}
+	fmt.Fprintln(w, strings.Join(append(header, "ERROR"), "\t"))
+	for _, r := range runs {
+		seconds := map[string]string{}
+		for _, s := range r.Steps {
+			seconds[s.Name] = fmt.Sprintf("%.2fs", s.Seconds)
+			if !s.OK {
+				seconds[s.Name] = "FAIL"
+			}
+		}
+		cols := []string{r.ID, r.StartedAt.Format("2006-01-02 15:04"), result(r.OK)}
+		for _, s := range canary.Steps {
+			v := seconds[s]
+			if v == "" {
+				v = "-"
+			}
+			cols = append(cols, v)
+		}
+		msg := r.Error
+		if r.FailedStep != "" {
+			msg = r.FailedStep + ": " + msg
+		}
+		fmt.Fprintln(w, strings.Join(append(cols, msg), "\t"))
+	}
+	w.Flush()
+}
+
+func result(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "failed"
+}
+
+func openDB(ctx context.Context, name string) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	dsn := cfg.CashflowDSN
+	if name == "oa" {
+		dsn = cfg.OADSN
+	}
+	conn, err := db.Open(ctx, name, dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
@@ -0,0 +1,149 @@
+// Command cashbasis reports an organization's books on the cash basis,
+// converting the accrual ledger as cash settles receivables and payables.
+//
+// Usage:
+//
+//	cashbasis report -org <organizationId> -from 2024-01-01 -to 2024-06-30 [-kind pl|tb|reconcile|all] [-basis cash|accrual]
+//	cashbasis basis -org <organizationId> [-set cash|accrual]
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashbasis"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "report":
+		runReport(ctx, args)
+	case "basis":
+		runBasis(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: cashbasis report|basis [flags]")
+	os.Exit(2)
+}
+
+func runReport(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	from := fs.String("from", "", "first day of the period")
+	to := fs.String("to", time.Now().Format(time.DateOnly), "last day of the period")
+	kind := fs.String("kind", "all", "pl, tb, reconcile or all")
+	basis := fs.String("basis", "", "cash or accrual (default: the organization's report_basis)")
+	fs.Parse(args)
+	if *org == "" || *from == "" {
+		log.Fatal("report: -org and -from are required")
+	}
+	start, end := parseDay(*from), parseDay(*to).AddDate(0, 0, 1)
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	if *basis == "" {
+		b, err := cashbasis.ReportBasis(ctx, conn, *org)
+		if err != nil {
+			log.Fatalf("report: %v", err)
+		}
+		*basis = b
+	}
+	if *basis != cashbasis.BasisCash && *basis != cashbasis.BasisAccrual {
+		log.Fatalf("report: unknown basis %q", *basis)
+	}
+
+	res, err := cashbasis.Convert(ctx, conn, *org, start, end)
+	if err != nil {
+		log.Fatalf("report: %v", err)
+	}
+	switch *kind {
+	case "pl":
+		res.PrintProfitAndLoss(os.Stdout, *basis)
+	case "tb":
+		res.PrintTrialBalance(os.Stdout, *basis)
+	case "reconcile":
+		res.PrintReconciliation(os.Stdout)
+	case "all":
+		res.PrintProfitAndLoss(os.Stdout, *basis)
+		fmt.Println()
+		res.PrintTrialBalance(os.Stdout, *basis)
+		fmt.Println()
+		res.PrintReconciliation(os.Stdout)
+	default:
+		log.Fatalf("report: unknown kind %q", *kind)
+	}
+}
+
+func runBasis(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("basis", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	set := fs.String("set", "", "cash or accrual")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("basis: -org is required")
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	if *set == "" {
+		b, err := cashbasis.ReportBasis(ctx, conn, *org)
+		if err != nil {
+			log.Fatalf("basis: %v", err)
+		}
+		fmt.Println(b)
+		return
+	}
+	if *set != cashbasis.BasisCash && *set != cashbasis.BasisAccrual {
+		log.Fatalf("basis: unknown basis %q", *set)
+	}
+	res, err := conn.ExecContext(ctx, `
+		UPDATE organization_profiles SET report_basis = ?, updated_at = ? WHERE organization_id = ?`,
+		*set, time.Now(), *org)
+	if err != nil {
+		log.Fatalf("basis: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		log.Fatalf("basis: organization %s has no profile", *org)
+	}
+	fmt.Printf("Organization %s now reports on the %s basis\n", *org, *set)
+}
+
+func parseDay(s string) time.Time {
+	t, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+	if err != nil {
+		log.Fatalf("invalid date %q; use YYYY-MM-DD", s)
+	}
+	return t
+}
+
+func openCashflow(ctx context.Context) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	conn, err := db.Open(ctx, "cashflow", cfg.CashflowDSN)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
@@ -0,0 +1,313 @@
+// Command dimensions manages analysis dimensions (cost center, project,
+// department), tags ledger splits with them and reports trial balances and
+// profit and loss by dimension value.
+//
+// Usage:
+//
+//	dimensions migrate
+//	dimensions define -org <organizationId> -code cost_center -name "Cost center" [-default warehouse.costCenter] [-inactive]
+//	dimensions list -org <organizationId>
+//	dimensions value -org <organizationId> -dim project -code P-12 -name "Harbour fit-out" [-inactive]
+//	dimensions values -org <organizationId> -dim project
+//	dimensions tag -org <organizationId> -journal <journalId> -set project=P-12
+//	dimensions tag -org <organizationId> -ledger oa -transaction <oaTransactionId> -set project=P-12
+//	dimensions tag -org <organizationId> -ledger oa -splits 1041,1042 -set cost_center=
+//	dimensions report -org <organizationId> -dim cost_center -kind tb [-as-of 2024-06-30] [-ledger cashflow]
+//	dimensions report -org <organizationId> -dim project -kind pl -from 2024-01-01 -to 2024-06-30
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/dimensions"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openDB(ctx, "cashflow")
+		if err := schema.Ensure(ctx, conn, dimensions.Tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("dimensions, dimension_values and split_dimensions are up to date")
+	case "define":
+		runDefine(ctx, args)
+	case "list":
+		runList(ctx, args)
+	case "value":
+		runValue(ctx, args)
+	case "values":
+		runValues(ctx, args)
+	case "tag":
+		runTag(ctx, args)
+	case "report":
+		runReport(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dimensions migrate|define|list|value|values|tag|report [flags]")
+	os.Exit(2)
+}
+
+func runDefine(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("define", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	code := fs.String("code", "", "dimension code, e.g. cost_center")
+	name := fs.String("name", "", "display name")
+	def := fs.String("default", "", "default source: warehouse.costCenter, warehouse, branch or salesperson")
+	inactive := fs.Bool("inactive", false, "stop tagging new postings with this dimension")
+	fs.Parse(args)
+	if *org == "" || *code == "" {
+		log.Fatal("define: -org and -code are required")
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+
+	d := &dimensions.Dimension{OrganizationID: *org, Code: *code, Name: *name, DefaultSource: *def, Active: !*inactive}
+	if err := dimensions.Define(ctx, conn, d); err != nil {
+		log.Fatalf("define: %v", err)
+	}
+	fmt.Printf("Dimension %s saved\n", d.Code)
+}
+
+func runList(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("list: -org is required")
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+
+	dims, err := dimensions.List(ctx, conn, *org, false)
+	if err != nil {
+		log.Fatalf("list: %v", err)
+	}
+	if len(dims) == 0 {
+		fmt.Println("No dimensions")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CODE\tNAME\tDEFAULT FROM\tACTIVE")
+	for _, d := range dims {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", d.Code, d.Name, d.DefaultSource, d.Active)
+	}
+	w.Flush()
+}
+
+func runValue(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("value", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	dim := fs.String("dim", "", "dimension code")
+	code := fs.String("code", "", "value code")
+	name := fs.String("name", "", "display name")
+	inactive := fs.Bool("inactive", false, "refuse new tags with this value")
+	fs.Parse(args)
+	if *org == "" || *dim == "" || *code == "" {
+		log.Fatal("value: -org, -dim and -code are required")
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+
+	if _, err := dimensions.Get(ctx, conn, *org, *dim); err != nil {
+		log.Fatalf("value: %v", err)
+	}
+	v := &dimensions.Value{Dimension: *dim, Code: *code, Name: *name, Active: !*inactive}
+	if err := dimensions.SetValue(ctx, conn, *org, v); err != nil {
+		log.Fatalf("value: %v", err)
+	}
+	fmt.Printf("%s value %s saved\n", *dim, v.Code)
+}
+
+func runValues(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("values", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	dim := fs.String("dim", "", "dimension code")
+	fs.Parse(args)
+	if *org == "" || *dim == "" {
+		log.Fatal("values: -org and -dim are required")
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+
+	values, err := dimensions.Values(ctx, conn, *org, *dim)
+	if err != nil {
+		log.Fatalf("values: %v", err)
+	}
+	if len(values) == 0 {
+		fmt.Println("No values")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CODE\tNAME\tACTIVE")
+	for _, v := range values {
+		fmt.Fprintf(w, "%s\t%s\t%t\n", v.Code, v.Name, v.Active)
+	}
+	w.Flush()
+}
+
+func runTag(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("tag", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	ledger := fs.String("ledger", dimensions.LedgerCashflow, "cashflow or oa")
+	journal := fs.String("journal", "", "cashflowdb journal id: tag all its entries")
+	transaction := fs.String("transaction", "", "OA transaction id: tag all its splits")
+	splits := fs.String("splits", "", "comma-separated split ids (journal_entries ids or OA split ids)")
+	set := fs.String("set", "", "values, e.g. cost_center=HQ,project=P-12; an empty value removes the tag")
+	fs.Parse(args)
+	if *org == "" || *set == "" {
+		log.Fatal("tag: -org and -set are required")
+	}
+	values, err := dimensions.ParseTags(*set)
+	if err != nil {
+		log.Fatalf("tag: %v", err)
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+
+	var ids []string
+	switch {
+	case *journal != "":
+		*ledger = dimensions.LedgerCashflow
+		ids, err = dimensions.JournalEntries(ctx, conn, *journal)
+	case *transaction != "":
+		*ledger = dimensions.LedgerOA
+		oaConn := openDB(ctx, "oa")
+		defer oaConn.Close()
+		var splitIDs []int64
+		splitIDs, err = oa.TransactionSplits(ctx, oaConn, *transaction)
+		for _, id := range splitIDs {
+			ids = append(ids, strconv.FormatInt(id, 10))
+		}
+	case *splits != "":
+		for _, id := range strings.Split(*splits, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	default:
+		log.Fatal("tag: one of -journal, -transaction or -splits is required")
+	}
+	if err != nil {
+		log.Fatalf("tag: %v", err)
+	}
+	if len(ids) == 0 {
+		log.Fatal("tag: no splits found")
+	}
+
+	err = db.InTx(ctx, conn, func(tx *sql.Tx) error {
+		return dimensions.Tag(ctx, tx, *org, *ledger, ids, values)
+	})
+	if err != nil {
+		log.Fatalf("tag: %v", err)
+	}
+	fmt.Printf("Tagged %d %s split(s)\n", len(ids), *ledger)
+}
+
+func runReport(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	dim := fs.String("dim", "", "dimension code")
+	kind := fs.String("kind", "tb", "tb (trial balance) or pl (profit and loss)")
+	ledger := fs.String("ledger", dimensions.LedgerCashflow, "cashflow or oa")
+	asOf := fs.String("as-of", time.Now().Format(time.DateOnly), "trial balance date")
+	from := fs.String("from", "", "first day of the P&L period")
+	to := fs.String("to", time.Now().Format(time.DateOnly), "last day of the P&L period")
+	fs.Parse(args)
+	if *org == "" || *dim == "" {
+		log.Fatal("report: -org and -dim are required")
+	}
+
+	var start, end time.Time
+	var reportKind string
+	switch *kind {
+	case "tb":
+		reportKind = dimensions.TrialBalance
+		end = nextDay(*asOf)
+	case "pl":
+		reportKind = dimensions.ProfitAndLoss
+		if *from == "" {
+			log.Fatal("report: -from is required for a P&L")
+		}
+		start, end = parseDay(*from), nextDay(*to)
+	default:
+		log.Fatalf("report: unknown kind %q", *kind)
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+
+	var rep *dimensions.Report
+	var err error
+	switch *ledger {
+	case dimensions.LedgerCashflow:
+		rep, err = dimensions.CashflowReport(ctx, conn, *org, reportKind, *dim, start, end)
+	case dimensions.LedgerOA:
+		oaConn := openDB(ctx, "oa")
+		defer oaConn.Close()
+		rep, err = dimensions.OAReport(ctx, conn, oaConn, *org, reportKind, *dim, start, end)
+	default:
+		log.Fatalf("report: unknown ledger %q", *ledger)
+	}
+	if err != nil {
+		log.Fatalf("report: %v", err)
+	}
+	rep.Print(os.Stdout)
+}
+
+func parseDay(s string) time.Time {
+	t, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+	if err != nil {
+		log.Fatalf("invalid date %q; use YYYY-MM-DD", s)
+	}
+	return t
+}
+
+func nextDay(s string) time.Time {
+	return parseDay(s).AddDate(0, 0, 1)
+}
+
+func openDB(ctx context.Context, name string) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	dsn := cfg.CashflowDSN
+	if name == "oa" {
+		dsn = cfg.OADSN
+	}
+	conn, err := db.Open(ctx, name, dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
@@ -0,0 +1,101 @@
+// Command equity reports the statement of changes in equity from the OA
+// equity accounts and checks the retained earnings roll-forward against the
+// year-end closes in year_end_closing_runs.
+//
+// Usage:
+//
+//	equity statement -org <organizationId> -from 2022 [-to 2024] [-retained "Equity:Retained Earnings"]
+//	equity check -org <organizationId> -from 2022 [-to 2024] [-retained "Equity:Retained Earnings"] [-json]
+//
+// check exits 1 when it finds unexplained equity movements.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/equity"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "statement":
+		s, _ := build(ctx, "statement", args)
+		s.Print(os.Stdout)
+	case "check":
+		s, asJSON := build(ctx, "check", args)
+		findings := s.Findings()
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(findings); err != nil {
+				log.Fatalf("check: %v", err)
+			}
+		} else {
+			s.PrintRollForward(os.Stdout)
+		}
+		if len(findings) > 0 {
+			os.Exit(1)
+		}
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: equity statement|check [flags]")
+	os.Exit(2)
+}
+
+func build(ctx context.Context, name string, args []string) (*equity.Statement, bool) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	from := fs.Int("from", 0, "first fiscal year")
+	to := fs.Int("to", time.Now().Year(), "last fiscal year")
+	retained := fs.String("retained", "", "OA retained earnings account path (default: from the organization profile)")
+	asJSON := fs.Bool("json", false, "print findings as JSON (check only)")
+	fs.Parse(args)
+	if *org == "" || *from == 0 {
+		log.Fatalf("%s: -org and -from are required", name)
+	}
+
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+	s, err := equity.Build(ctx, cf, oadb, *org, *from, *to, *retained)
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	return s, *asJSON
+}
+
+func openDB(ctx context.Context, name string) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	dsn := cfg.CashflowDSN
+	if name == "oa" {
+		dsn = cfg.OADSN
+	}
+	conn, err := db.Open(ctx, name, dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
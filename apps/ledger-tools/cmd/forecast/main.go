@@ -0,0 +1,315 @@
+// Command forecast drafts an OA budget from an organization's history,
+// keeps it for review and publishes approved figures into budgetitem.
+//
+// Usage:
+//
+//	forecast migrate
+//	forecast methods
+//	forecast generate -org <organizationId> -start 2026-01 [-method growth|average|seasonal] [-growth 0.05] [-history 36]
+//	forecast list -org <organizationId>
+//	forecast show -id <forecastId> [-months]
+//	forecast set -id <forecastId> -account <oaAccountId> -amount 120000.00 | -clear
+//	forecast approve -id <forecastId>
+//	forecast publish -id <forecastId>
+//
+// Amounts are annual and signed like OA splits: income is negative.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/forecast"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+var methodDocs = map[string]string{
+	forecast.MethodGrowth:   "the last 12 months, each scaled by -growth",
+	forecast.MethodAverage:  "each calendar month's average over the history",
+	forecast.MethodSeasonal: "linear trend plus monthly seasonal indices; needs 24 months",
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openDB(ctx, "cashflow")
+		if err := schema.Ensure(ctx, conn, forecast.Tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("budget_forecasts and budget_forecast_lines are up to date")
+	case "methods":
+		for _, m := range forecast.Methods {
+			fmt.Printf("%-9s %s\n", m, methodDocs[m])
+		}
+	case "generate":
+		runGenerate(ctx, args)
+	case "list":
+		runList(ctx, args)
+	case "show":
+		runShow(ctx, args)
+	case "set":
+		runSet(ctx, args)
+	case "approve":
+		runApprove(ctx, args)
+	case "publish":
+		runPublish(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: forecast migrate|methods|generate|list|show|set|approve|publish [flags]")
+	os.Exit(2)
+}
+
+func runGenerate(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	next := time.Date(time.Now().Year()+1, time.January, 1, 0, 0, 0, 0, time.UTC)
+	p := forecast.Params{}
+	fs.StringVar(&p.Start, "start", next.Format(forecast.MonthLayout), "first month of the forecast year")
+	fs.StringVar(&p.Method, "method", forecast.MethodGrowth, "growth, average or seasonal")
+	fs.Float64Var(&p.Growth, "growth", 0, "growth rate for the growth method, 0.05 for 5%")
+	fs.IntVar(&p.HistoryMonths, "history", 36, "months of history to read")
+	fs.StringVar(&p.CreatedBy, "by", os.Getenv("USER"), "who is drafting the forecast")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("generate: -org is required")
+	}
+
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+
+	f, skipped, err := forecast.Generate(ctx, cf, oadb, *org, p, time.Now())
+	for account, reason := range skipped {
+		log.Printf("skipped %s: %s", account, reason)
+	}
+	if err != nil {
+		log.Fatalf("generate: %v", err)
+	}
+	fmt.Printf("Drafted %s: %d accounts from %s, %d months of history\n", f.ID, len(f.Lines), f.HistoryFrom, f.HistoryMonths)
+}
+
+func runList(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("list: -org is required")
+	}
+
+	cf := openDB(ctx, "cashflow")
+	defer cf.Close()
+
+	forecasts, err := forecast.List(ctx, cf, *org)
+	if err != nil {
+		log.Fatalf("list: %v", err)
+	}
+	if len(forecasts) == 0 {
+		fmt.Println("No forecasts")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTART\tMETHOD\tHISTORY\tSTATUS\tCREATED\tBY\tAPPROVED BY")
+	for _, f := range forecasts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s +%dm\t%s\t%s\t%s\t%s\n", f.ID, f.Start, method(f), f.HistoryFrom, f.HistoryMonths,
+			f.Status, f.CreatedAt.Format("2006-01-02 15:04"), f.CreatedBy, f.ApprovedBy)
+	}
+	w.Flush()
+}
+
+func runShow(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	id := fs.String("id", "", "forecast id")
+	months := fs.Bool("months", false, "show the monthly figures")
+	fs.Parse(args)
+	if *id == "" {
+		log.Fatal("show: -id is required")
+	}
+
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+
+	f, err := forecast.Get(ctx, cf, *id)
+	if err != nil {
+		log.Fatalf("show: %v", err)
+	}
+	chart, err := oa.LoadChart(ctx, oadb, f.OAOrgID)
+	if err != nil {
+		log.Fatalf("show: %v", err)
+	}
+	fmt.Printf("%s: %s from %s, %s\n\n", f.ID, method(f), f.Start, f.Status)
+
+	sort.Slice(f.Lines, func(i, j int) bool {
+		return chart.FullName(f.Lines[i].AccountID) < chart.FullName(f.Lines[j].AccountID)
+	})
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "ACCOUNT\tID\tLAST 12M\tFORECAST\tCHANGE\tBUDGET\tNOTE"
+	if *months {
+		start, _ := time.Parse(forecast.MonthLayout, f.Start)
+		header = "ACCOUNT"
+		for k := 0; k < 12; k++ {
+			header += "\t" + start.AddDate(0, k, 0).Format("Jan")
+		}
+	}
+	fmt.Fprintln(w, header)
+	for _, l := range f.Lines {
+		name := chart.FullName(l.AccountID)
+		if *months {
+			cols := []string{name}
+			for _, v := range l.Months {
+				cols = append(cols, amount(chart, l.AccountID, v))
+			}
+			fmt.Fprintln(w, strings.Join(cols, "\t"))
+			continue
+		}
+		note := l.Note
+		if l.Override != nil {
+			note = strings.Trim(note+"; set by "+l.OverrideBy, "; ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", name, l.AccountID, amount(chart, l.AccountID, l.History),
+			amount(chart, l.AccountID, l.Amount), change(l.History, l.Amount), amount(chart, l.AccountID, l.Budget()), note)
+	}
+	w.Flush()
+}
+
+func runSet(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("set", flag.ExitOnError)
+	id := fs.String("id", "", "forecast id")
+	account := fs.String("account", "", "OA account id")
+	value := fs.String("amount", "", "annual budget, signed like splits")
+	drop := fs.Bool("clear", false, "drop the override and use the forecast")
+	by := fs.String("by", os.Getenv("USER"), "who is reviewing")
+	fs.Parse(args)
+	if *id == "" || *account == "" || (*value == "") == !*drop {
+		log.Fatal("set: -id, -account and one of -amount or -clear are required")
+	}
+
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+
+	accountID := oa.NormalizeID(*account)
+	var override *int64
+	if !*drop {
+		f, err := forecast.Get(ctx, cf, *id)
+		if err != nil {
+			log.Fatalf("set: %v", err)
+		}
+		chart, err := oa.LoadChart(ctx, oadb, f.OAOrgID)
+		if err != nil {
+			log.Fatalf("set: %v", err)
+		}
+		a, err := money.Parse(*value)
+		if err != nil {
+			log.Fatalf("set: %v", err)
+		}
+		precision := 2
+		if acct := chart.Accounts[accountID]; acct != nil {
+			precision = acct.Precision
+		}
+		minor := a.Minor(precision)
+		override = &minor
+	}
+	if err := forecast.SetOverride(ctx, cf, *id, accountID, override, *by); err != nil {
+		log.Fatalf("set: %v", err)
+	}
+	fmt.Println("Updated")
+}
+
+func runApprove(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("approve", flag.ExitOnError)
+	id := fs.String("id", "", "forecast id")
+	by := fs.String("by", os.Getenv("USER"), "who is approving")
+	fs.Parse(args)
+	if *id == "" {
+		log.Fatal("approve: -id is required")
+	}
+
+	cf := openDB(ctx, "cashflow")
+	defer cf.Close()
+
+	if err := forecast.Approve(ctx, cf, *id, *by); err != nil {
+		log.Fatalf("approve: %v", err)
+	}
+	fmt.Printf("Approved %s\n", *id)
+}
+
+func runPublish(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("publish", flag.ExitOnError)
+	id := fs.String("id", "", "forecast id")
+	fs.Parse(args)
+	if *id == "" {
+		log.Fatal("publish: -id is required")
+	}
+
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+
+	inserted, err := forecast.Publish(ctx, cf, oadb, *id, time.Now())
+	if err != nil {
+		log.Fatalf("publish: %v", err)
+	}
+	fmt.Printf("Published %s as budget set %d\n", *id, inserted)
+}
+
+func method(f *forecast.Forecast) string {
+	if f.Method == forecast.MethodGrowth {
+		return fmt.Sprintf("%s %+.1f%%", f.Method, f.Growth*100)
+	}
+	return f.Method
+}
+
+func change(history, projected int64) string {
+	if history == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", (float64(projected)/float64(history)-1)*100)
+}
+
+func amount(chart *oa.Chart, accountID string, minor int64) string {
+	precision := 2
+	if a := chart.Accounts[accountID]; a != nil {
+		precision = a.Precision
+	}
+	return money.FromMinor(minor, precision).String()
+}
+
+func openDB(ctx context.Context, name string) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	dsn := cfg.CashflowDSN
+	if name == "oa" {
+		dsn = cfg.OADSN
+	}
+	conn, err := db.Open(ctx, name, dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
@@ -0,0 +1,155 @@
+// Command ingestd watches per-organization drop folders and routes bank
+// statements, POS exports, price sheets and master data files to their
+// importers.
+//
+// Usage:
+//
+//	ingestd migrate
+//	ingestd run -config ingest.json
+//	ingestd once -config ingest.json
+//	ingestd list -org <organizationId> [-status failed] [-limit 50]
+//	ingestd retry -config ingest.json -id <fileId>
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/ingest"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/pos"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openCashflow(ctx)
+		// POS files are imported through posimport's tables.
+		tables := append(append([]schema.Table{}, ingest.Tables...), pos.Tables...)
+		if err := schema.Ensure(ctx, conn, tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("ingest_files and pos_import_batches are up to date")
+	case "run", "once":
+		runWatch(ctx, cmd, args)
+	case "list":
+		runList(ctx, args)
+	case "retry":
+		runRetry(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: ingestd migrate|run|once|list|retry [flags]")
+	os.Exit(2)
+}
+
+func runWatch(ctx context.Context, cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	cfgPath := fs.String("config", "ingest.json", "daemon configuration file")
+	fs.Parse(args)
+
+	cfg, err := ingest.LoadConfig(*cfgPath)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	w := &ingest.Watcher{Config: cfg, DB: conn, Logger: log.New(os.Stdout, "ingestd ", log.LstdFlags)}
+	if cmd == "once" {
+		w.Scan(ctx, true)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	w.Logger.Printf("watching %d organization(s) under %s for %v", len(cfg.Orgs), cfg.Root, ingest.Kinds())
+	if err := w.Run(ctx); err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+}
+
+func runList(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	status := fs.String("status", "", "processing, done or failed")
+	limit := fs.Int("limit", 50, "maximum rows")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("list: -org is required")
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	files, err := ingest.Files(ctx, conn, *org, *status, *limit)
+	if err != nil {
+		log.Fatalf("list: %v", err)
+	}
+	if len(files) == 0 {
+		fmt.Println("No files")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tRECEIVED\tKIND\tSTATUS\tFILE\tERROR")
+	for _, f := range files {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", f.ID, f.ReceivedAt.Format("2006-01-02 15:04"),
+			f.Kind, f.Status, f.FileName, f.Error)
+	}
+	w.Flush()
+}
+
+func runRetry(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("retry", flag.ExitOnError)
+	cfgPath := fs.String("config", "ingest.json", "daemon configuration file")
+	id := fs.String("id", "", "failed file id")
+	fs.Parse(args)
+	if *id == "" {
+		log.Fatal("retry: -id is required")
+	}
+
+	cfg, err := ingest.LoadConfig(*cfgPath)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	dest, err := ingest.Retry(ctx, conn, cfg, *id)
+	if err != nil {
+		log.Fatalf("retry: %v", err)
+	}
+	fmt.Printf("Moved back to %s; it will be imported on the next scan\n", dest)
+}
+
+func openCashflow(ctx context.Context) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	conn, err := db.Open(ctx, "cashflow", cfg.CashflowDSN)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
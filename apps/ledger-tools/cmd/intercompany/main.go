@@ -0,0 +1,270 @@
+// Command intercompany reconciles intercompany accounts kept in two OA orgs
+// of one group and posts the missing side of unmatched transactions.
+//
+// Usage:
+//
+//	intercompany migrate
+//	intercompany define -name alpha-beta-loan -org-a <organizationId> -account-a "Assets:Loan to Beta" [-mirror-a <account>]
+//	                    -org-b <organizationId> -account-b "Liabilities:Loan from Alpha" [-mirror-b <account>] [-tolerance 3]
+//	intercompany list [-org <organizationId>]
+//	intercompany match -pair alpha-beta-loan -from 2025-07-01 -to 2025-09-30
+//	intercompany mirror -pair alpha-beta-loan -from 2025-07-01 -to 2025-09-30 [-side a|b] [-post]
+//	intercompany mirrors -pair alpha-beta-loan [-limit 50]
+//
+// Periods are inclusive UTC days. match exits 1 unless the pair is fully
+// matched and balances; mirror only lists what it would post unless -post
+// is given.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/intercompany"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openDB(ctx, "cashflow")
+		if err := schema.Ensure(ctx, conn, intercompany.Tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("intercompany_pairs and intercompany_mirrors are up to date")
+	case "define":
+		runDefine(ctx, args)
+	case "list":
+		runList(ctx, args)
+	case "match":
+		runMatch(ctx, args)
+	case "mirror":
+		runMirror(ctx, args)
+	case "mirrors":
+		runMirrors(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: intercompany migrate|define|list|match|mirror|mirrors [flags]")
+	os.Exit(2)
+}
+
+func runDefine(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("define", flag.ExitOnError)
+	p := &intercompany.Pair{}
+	fs.StringVar(&p.Name, "name", "", "pair name")
+	fs.StringVar(&p.OrganizationA, "org-a", "", "first organization id")
+	fs.StringVar(&p.AccountA, "account-a", "", "its intercompany account: OA id or full name")
+	fs.StringVar(&p.MirrorAccountA, "mirror-a", "", "account taking the other leg of mirrors posted into org a")
+	fs.StringVar(&p.OrganizationB, "org-b", "", "second organization id")
+	fs.StringVar(&p.AccountB, "account-b", "", "its intercompany account: OA id or full name")
+	fs.StringVar(&p.MirrorAccountB, "mirror-b", "", "account taking the other leg of mirrors posted into org b")
+	fs.IntVar(&p.DateTolerance, "tolerance", 3, "days the two sides may be dated apart")
+	fs.StringVar(&p.CreatedBy, "by", os.Getenv("USER"), "who is defining the pair")
+	fs.Parse(args)
+	if p.Name == "" || p.OrganizationA == "" || p.OrganizationB == "" {
+		log.Fatal("define: -name, -org-a and -org-b are required")
+	}
+
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+
+	if err := intercompany.Define(ctx, cf, oadb, p); err != nil {
+		log.Fatalf("define: %v", err)
+	}
+	fmt.Printf("Defined %s: %s in %s against %s in %s\n", p.Name, p.AccountA, p.OrganizationA, p.AccountB, p.OrganizationB)
+}
+
+func runList(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	org := fs.String("org", "", "only pairs involving this organization")
+	fs.Parse(args)
+
+	cf := openDB(ctx, "cashflow")
+	defer cf.Close()
+
+	pairs, err := intercompany.Pairs(ctx, cf, *org)
+	if err != nil {
+		log.Fatalf("list: %v", err)
+	}
+	if len(pairs) == 0 {
+		fmt.Println("No pairs")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tORG A\tACCOUNT A\tORG B\tACCOUNT B\tTOLERANCE")
+	for _, p := range pairs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%dd\n", p.Name, p.OrganizationA, p.AccountA, p.OrganizationB, p.AccountB, p.DateTolerance)
+	}
+	w.Flush()
+}
+
+func runMatch(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("match", flag.ExitOnError)
+	pair := fs.String("pair", "", "pair name")
+	from := fs.String("from", "", "first day of the period")
+	to := fs.String("to", "", "last day of the period")
+	fs.Parse(args)
+
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+
+	_, report := reconcile(ctx, cf, oadb, "match", *pair, *from, *to)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	if !report.Clean() {
+		os.Exit(1)
+	}
+}
+
+func runMirror(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("mirror", flag.ExitOnError)
+	pair := fs.String("pair", "", "pair name")
+	from := fs.String("from", "", "first day of the period")
+	to := fs.String("to", "", "last day of the period")
+	side := fs.String("side", "", "only mirror unmatched postings of side a or b")
+	post := fs.Bool("post", false, "post the mirrors (default: only list them)")
+	by := fs.String("by", os.Getenv("USER"), "who is posting")
+	fs.Parse(args)
+	if *side != "" && *side != "a" && *side != "b" {
+		log.Fatal("mirror: -side must be a or b")
+	}
+
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+
+	p, report := reconcile(ctx, cf, oadb, "mirror", *pair, *from, *to)
+	var poster intercompany.Poster
+	if *post {
+		cfg, err := config.Load()
+		if err != nil {
+			log.Fatalf("config: %v", err)
+		}
+		client, err := oa.NewClient(cfg)
+		if err != nil {
+			log.Fatalf("mirror: %v", err)
+		}
+		poster = client
+	}
+	results, err := intercompany.Mirror(ctx, cf, oadb, poster, p, report, *side, *by)
+	printResults(results)
+	if err != nil {
+		log.Fatalf("mirror: %v", err)
+	}
+	if !*post && len(results) > 0 {
+		fmt.Println("\nDry run; pass -post to post these mirrors")
+	}
+	for _, r := range results {
+		if r.Status == intercompany.MirrorFailed {
+			os.Exit(1)
+		}
+	}
+}
+
+func printResults(results []*intercompany.MirrorResult) {
+	if len(results) == 0 {
+		fmt.Println("Nothing to mirror")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SIDE\tSOURCE\tDATE\tAMOUNT\tINTO\tMIRROR\tSTATUS\tNOTE")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", r.Source.Side, r.Source.TransactionID,
+			r.Source.Date.Format(time.DateOnly), r.Source.Amount, r.MirrorOrganization, r.MirrorTransactionID, r.Status, r.Note)
+	}
+	w.Flush()
+}
+
+func runMirrors(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("mirrors", flag.ExitOnError)
+	pair := fs.String("pair", "", "pair name")
+	limit := fs.Int("limit", 50, "most recent mirrors to show")
+	fs.Parse(args)
+	if *pair == "" {
+		log.Fatal("mirrors: -pair is required")
+	}
+
+	cf := openDB(ctx, "cashflow")
+	defer cf.Close()
+
+	mirrors, err := intercompany.Mirrors(ctx, cf, *pair, *limit)
+	if err != nil {
+		log.Fatalf("mirrors: %v", err)
+	}
+	if len(mirrors) == 0 {
+		fmt.Println("No mirrors")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CREATED\tSOURCE\tMIRROR\tINTO\tAMOUNT\tSTATUS\tBY\tERROR")
+	for _, m := range mirrors {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", m.CreatedAt.Format("2006-01-02 15:04"), m.SourceTransactionID,
+			m.MirrorTransactionID, m.MirrorOrganization, m.Amount, m.Status, m.CreatedBy, m.Error)
+	}
+	w.Flush()
+}
+
+// reconcile loads a pair and matches it over an inclusive period of UTC
+// days.
+func reconcile(ctx context.Context, cf, oadb *sql.DB, cmd, name, from, to string) (*intercompany.Pair, *intercompany.Report) {
+	if name == "" || from == "" || to == "" {
+		log.Fatalf("%s: -pair, -from and -to are required", cmd)
+	}
+	start, err := time.Parse(time.DateOnly, from)
+	if err != nil {
+		log.Fatalf("%s: -from: %v", cmd, err)
+	}
+	end, err := time.Parse(time.DateOnly, to)
+	if err != nil {
+		log.Fatalf("%s: -to: %v", cmd, err)
+	}
+	p, err := intercompany.GetPair(ctx, cf, name)
+	if err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+	report, err := intercompany.Reconcile(ctx, cf, oadb, p, start, end.AddDate(0, 0, 1))
+	if err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+	return p, report
+}
+
+func openDB(ctx context.Context, name string) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	dsn := cfg.CashflowDSN
+	if name == "oa" {
+		dsn = cfg.OADSN
+	}
+	conn, err := db.Open(ctx, name, dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
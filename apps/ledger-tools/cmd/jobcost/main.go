@@ -0,0 +1,463 @@
+// Command jobcost tracks the cost and revenue of customer projects: stock
+// issued to a job, time logged against it, bills and other postings tagged
+// with its project code, and the invoices that bill it.
+//
+// Usage:
+//
+//	jobcost migrate
+//	jobcost create -org <organizationId> -code P-12 -name "Harbour fit-out" [-customer id] [-contract 50000] [-cost-account id]
+//	jobcost list -org <organizationId> [-status open]
+//	jobcost budget -org <organizationId> -project P-12 [-labor 12000] [-materials 20000] [-bills 5000] [-other 1000] [-contract 50000]
+//	jobcost issue -org <organizationId> -project P-12 -item <sku|productId> -warehouse <code|id> -qty 10 [-date 2024-06-03] [-ref DN-1]
+//	jobcost time -org <organizationId> -project P-12 -worker "Aung" -hours 7.5 -cost-rate 20 -bill-rate 45 -credit-account <accountId> [-date 2024-06-03] [-note "..."]
+//	jobcost times -org <organizationId> -project P-12 [-unbilled]
+//	jobcost bill -org <organizationId> -project P-12 [-time] [-amount 10000 -description "Progress claim 1"] [-number P-12-1] [-date 2024-06-30] [-due 2024-07-30]
+//	jobcost link -org <organizationId> -project P-12 -invoice <invoiceId>
+//	jobcost report -org <organizationId> [-project P-12] [-as-of 2024-06-30]
+//	jobcost close -org <organizationId> -project P-12
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/dimensions"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/jobcost"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openCashflow(ctx)
+		tables := append(append([]schema.Table{}, dimensions.Tables...), jobcost.Tables...)
+		if err := schema.Ensure(ctx, conn, tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("projects, project_budgets, project_invoices, time_entries and the dimension tables are up to date")
+	case "create":
+		runCreate(ctx, args)
+	case "list":
+		runList(ctx, args)
+	case "budget":
+		runBudget(ctx, args)
+	case "issue":
+		runIssue(ctx, args)
+	case "time":
+		runTime(ctx, args)
+	case "times":
+		runTimes(ctx, args)
+	case "bill":
+		runBill(ctx, args)
+	case "link":
+		runLink(ctx, args)
+	case "report":
+		runReport(ctx, args)
+	case "close":
+		runClose(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: jobcost migrate|create|list|budget|issue|time|times|bill|link|report|close [flags]")
+	os.Exit(2)
+}
+
+func runCreate(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	code := fs.String("code", "", "project code, also its project dimension value")
+	name := fs.String("name", "", "project name")
+	customer := fs.String("customer", "", "customer id to bill")
+	contract := fs.String("contract", "0", "agreed revenue")
+	costAccount := fs.String("cost-account", "", "ledger account for labor and issued stock (default: first cost of goods sold or expense account)")
+	fs.Parse(args)
+	if *org == "" || *code == "" || *name == "" {
+		log.Fatal("create: -org, -code and -name are required")
+	}
+
+	p := &jobcost.Project{
+		OrganizationID: *org,
+		Code:           *code,
+		Name:           *name,
+		CustomerID:     *customer,
+		Contract:       mustAmount("create", *contract),
+		CostAccountID:  *costAccount,
+	}
+	conn := openCashflow(ctx)
+	defer conn.Close()
+	err := db.InTx(ctx, conn, func(tx *sql.Tx) error {
+		return jobcost.Create(ctx, tx, p)
+	})
+	if err != nil {
+		log.Fatalf("create: %v", err)
+	}
+	fmt.Printf("Project %s created (%s)\n", p.Code, p.ID)
+}
+
+func runList(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	status := fs.String("status", "", "open or closed (default: all)")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("list: -org is required")
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+	projects, err := jobcost.List(ctx, conn, *org, *status)
+	if err != nil {
+		log.Fatalf("list: %v", err)
+	}
+	if len(projects) == 0 {
+		fmt.Println("No projects")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CODE\tNAME\tCUSTOMER\tSTATUS\tCONTRACT\tCREATED")
+	for _, p := range projects {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", p.Code, p.Name, p.CustomerID, p.Status, p.Contract,
+			p.CreatedAt.Format(time.DateOnly))
+	}
+	w.Flush()
+}
+
+func runBudget(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("budget", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	code := fs.String("project", "", "project code or id")
+	contract := fs.String("contract", "", "agreed revenue")
+	budgets := map[string]*string{}
+	for _, c := range jobcost.Categories {
+		budgets[c] = fs.String(c, "", "budgeted "+c+" cost")
+	}
+	fs.Parse(args)
+	if *org == "" || *code == "" {
+		log.Fatal("budget: -org and -project are required")
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+	err := db.InTx(ctx, conn, func(tx *sql.Tx) error {
+		p, err := jobcost.Get(ctx, tx, *org, *code)
+		if err != nil {
+			return err
+		}
+		if *contract != "" {
+			if err := jobcost.SetContract(ctx, tx, p, mustAmount("budget", *contract)); err != nil {
+				return err
+			}
+		}
+		for _, c := range jobcost.Categories {
+			if *budgets[c] == "" {
+				continue
+			}
+			if err := jobcost.SetBudget(ctx, tx, p, c, mustAmount("budget", *budgets[c])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("budget: %v", err)
+	}
+	fmt.Printf("Budget of %s updated\n", *code)
+}
+
+func runIssue(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("issue", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	code := fs.String("project", "", "project code or id")
+	item := fs.String("item", "", "product SKU or id")
+	warehouse := fs.String("warehouse", "", "warehouse code or id")
+	qty := fs.Float64("qty", 0, "quantity issued")
+	date := fs.String("date", time.Now().Format(time.DateOnly), "issue date")
+	ref := fs.String("ref", "", "reference, e.g. a delivery note")
+	fs.Parse(args)
+	if *org == "" || *code == "" || *item == "" || *warehouse == "" || *qty <= 0 {
+		log.Fatal("issue: -org, -project, -item, -warehouse and a positive -qty are required")
+	}
+	// Layers received during the day are available to issues dated that day.
+	day := endOfDay(*date)
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+	var res *jobcost.IssueResult
+	err := db.InTx(ctx, conn, func(tx *sql.Tx) error {
+		p, err := jobcost.Get(ctx, tx, *org, *code)
+		if err != nil {
+			return err
+		}
+		res, err = jobcost.IssueStock(ctx, tx, p, jobcost.Issue{
+			Item: *item, Warehouse: *warehouse, Quantity: *qty, Date: day, Reference: *ref,
+		})
+		return err
+	})
+	if err != nil {
+		log.Fatalf("issue: %v", err)
+	}
+	fmt.Printf("Issued %g x %s to %s at cost %s", res.Quantity, res.ItemName, *code, res.Cost)
+	if res.JournalID != "" {
+		fmt.Printf(" (journal %s)", res.JournalID)
+	}
+	fmt.Println()
+}
+
+func runTime(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("time", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	code := fs.String("project", "", "project code or id")
+	worker := fs.String("worker", "", "who did the work")
+	hours := fs.Float64("hours", 0, "hours worked")
+	costRate := fs.String("cost-rate", "0", "hourly cost")
+	billRate := fs.String("bill-rate", "0", "hourly rate billed to the customer")
+	credit := fs.String("credit-account", "", "ledger account the labor cost is moved from (payroll expense or accrued wages)")
+	date := fs.String("date", time.Now().Format(time.DateOnly), "work date")
+	note := fs.String("note", "", "description")
+	fs.Parse(args)
+	if *org == "" || *code == "" || *worker == "" || *hours <= 0 {
+		log.Fatal("time: -org, -project, -worker and positive -hours are required")
+	}
+
+	e := &jobcost.TimeEntry{
+		Date:        parseDay(*date),
+		Worker:      *worker,
+		Hours:       *hours,
+		CostRate:    mustAmount("time", *costRate),
+		BillRate:    mustAmount("time", *billRate),
+		Description: *note,
+	}
+	conn := openCashflow(ctx)
+	defer conn.Close()
+	err := db.InTx(ctx, conn, func(tx *sql.Tx) error {
+		p, err := jobcost.Get(ctx, tx, *org, *code)
+		if err != nil {
+			return err
+		}
+		return jobcost.LogTime(ctx, tx, p, e, *credit)
+	})
+	if err != nil {
+		log.Fatalf("time: %v", err)
+	}
+	fmt.Printf("Logged %g h for %s on %s, cost %s, billable %s\n", e.Hours, e.Worker, *code, e.Cost(), e.Value())
+}
+
+func runTimes(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("times", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	code := fs.String("project", "", "project code or id")
+	unbilled := fs.Bool("unbilled", false, "only entries not yet invoiced")
+	fs.Parse(args)
+	if *org == "" || *code == "" {
+		log.Fatal("times: -org and -project are required")
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+	p, err := jobcost.Get(ctx, conn, *org, *code)
+	if err != nil {
+		log.Fatalf("times: %v", err)
+	}
+	entries, err := jobcost.TimeEntries(ctx, conn, p.ID, false)
+	if err != nil {
+		log.Fatalf("times: %v", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATE\tWORKER\tHOURS\tCOST\tBILLABLE\tINVOICE\tDESCRIPTION")
+	for _, e := range entries {
+		if *unbilled && e.InvoiceID != "" {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%g\t%s\t%s\t%s\t%s\n", e.Date.Format(time.DateOnly), e.Worker, e.Hours,
+			e.Cost(), e.Value(), e.InvoiceID, e.Description)
+	}
+	w.Flush()
+}
+
+func runBill(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("bill", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	code := fs.String("project", "", "project code or id")
+	withTime := fs.Bool("time", false, "bill unbilled time entries at their bill rates")
+	amount := fs.String("amount", "0", "fixed or progress amount to bill")
+	description := fs.String("description", "", "line text for -amount")
+	number := fs.String("number", "", "invoice number (default: <project code>-<n>)")
+	date := fs.String("date", time.Now().Format(time.DateOnly), "invoice date")
+	due := fs.String("due", "", "due date (default: the invoice date)")
+	revenue := fs.String("revenue-account", "", "income ledger account id (default: first income account)")
+	fs.Parse(args)
+	if *org == "" || *code == "" {
+		log.Fatal("bill: -org and -project are required")
+	}
+
+	b := jobcost.Billing{
+		Number:           *number,
+		Date:             parseDay(*date),
+		Amount:           mustAmount("bill", *amount),
+		Description:      *description,
+		IncludeTime:      *withTime,
+		RevenueAccountID: *revenue,
+	}
+	if *due != "" {
+		b.DueDate = parseDay(*due)
+	}
+	conn := openCashflow(ctx)
+	defer conn.Close()
+	var inv *cashflow.Invoice
+	err := db.InTx(ctx, conn, func(tx *sql.Tx) error {
+		p, err := jobcost.Get(ctx, tx, *org, *code)
+		if err != nil {
+			return err
+		}
+		inv, err = jobcost.Bill(ctx, tx, p, b)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("bill: %v", err)
+	}
+	fmt.Printf("Invoice %s raised for %s %s (%s)\n", inv.InvoiceNumber, inv.TotalAmount, inv.Currency, inv.ID)
+}
+
+func runLink(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("link", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	code := fs.String("project", "", "project code or id")
+	invoice := fs.String("invoice", "", "invoice id")
+	fs.Parse(args)
+	if *org == "" || *code == "" || *invoice == "" {
+		log.Fatal("link: -org, -project and -invoice are required")
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+	var inv *cashflow.Invoice
+	err := db.InTx(ctx, conn, func(tx *sql.Tx) error {
+		p, err := jobcost.Get(ctx, tx, *org, *code)
+		if err != nil {
+			return err
+		}
+		inv, err = jobcost.LinkInvoice(ctx, tx, p, *invoice)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("link: %v", err)
+	}
+	fmt.Printf("Invoice %s linked to %s\n", inv.InvoiceNumber, *code)
+}
+
+func runReport(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	code := fs.String("project", "", "project code or id (default: every project)")
+	asOf := fs.String("as-of", time.Now().Format(time.DateOnly), "report date")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("report: -org is required")
+	}
+	end := parseDay(*asOf).AddDate(0, 0, 1)
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+	if *code != "" {
+		p, err := jobcost.Get(ctx, conn, *org, *code)
+		if err != nil {
+			log.Fatalf("report: %v", err)
+		}
+		s, err := jobcost.Summarize(ctx, conn, p, end)
+		if err != nil {
+			log.Fatalf("report: %v", err)
+		}
+		s.Print(os.Stdout)
+		return
+	}
+
+	projects, err := jobcost.List(ctx, conn, *org, "")
+	if err != nil {
+		log.Fatalf("report: %v", err)
+	}
+	var summaries []*jobcost.Summary
+	for _, p := range projects {
+		s, err := jobcost.Summarize(ctx, conn, p, end)
+		if err != nil {
+			log.Fatalf("report %s: %v", p.Code, err)
+		}
+		summaries = append(summaries, s)
+	}
+	jobcost.PrintSummaries(os.Stdout, summaries)
+}
+
+func runClose(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("close", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	code := fs.String("project", "", "project code or id")
+	fs.Parse(args)
+	if *org == "" || *code == "" {
+		log.Fatal("close: -org and -project are required")
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+	err := db.InTx(ctx, conn, func(tx *sql.Tx) error {
+		p, err := jobcost.Get(ctx, tx, *org, *code)
+		if err != nil {
+			return err
+		}
+		return jobcost.Close(ctx, tx, p)
+	})
+	if err != nil {
+		log.Fatalf("close: %v", err)
+	}
+	fmt.Printf("Project %s closed\n", *code)
+}
+
+func mustAmount(cmd, s string) money.Amount {
+	a, err := money.Parse(s)
+	if err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+	return a
+}
+
+func parseDay(s string) time.Time {
+	t, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+	if err != nil {
+		log.Fatalf("invalid date %q; use YYYY-MM-DD", s)
+	}
+	return t
+}
+
+func endOfDay(s string) time.Time {
+	return parseDay(s).AddDate(0, 0, 1).Add(-time.Millisecond)
+}
+
+func openCashflow(ctx context.Context) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	conn, err := db.Open(ctx, "cashflow", cfg.CashflowDSN)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
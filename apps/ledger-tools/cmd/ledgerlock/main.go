@@ -0,0 +1,246 @@
+// Command ledgerlock installs and manages the database triggers that enforce
+// lock dates, closed accounting periods and immutability of posted rows.
+//
+// Usage:
+//
+//	ledgerlock install [-cashflow-schema name] [-skip-oa]
+//	ledgerlock uninstall [-skip-oa]
+//	ledgerlock status
+//	ledgerlock set-lock-date -org <organizationId> -date YYYY-MM-DD [-by user]
+//	ledgerlock clear-lock-date -org <organizationId>
+//	ledgerlock bypass -db oa|cashflow -reason "..." -file reversal.sql
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/ledgerlock"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "install", "uninstall", "status":
+		runTriggers(ctx, cfg, cmd, args)
+	case "set-lock-date":
+		runSetLockDate(ctx, cfg, args)
+	case "clear-lock-date":
+		runClearLockDate(ctx, cfg, args)
+	case "bypass":
+		runBypass(ctx, cfg, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: ledgerlock install|uninstall|status|set-lock-date|clear-lock-date|bypass [flags]")
+	os.Exit(2)
+}
+
+func runTriggers(ctx context.Context, cfg *config.Config, cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	schema := fs.String("cashflow-schema", config.DatabaseName(cfg.CashflowDSN), "schema holding cashflow tables, as seen from the OA database")
+	skipOA := fs.Bool("skip-oa", false, "only manage the cashflow triggers")
+	fs.Parse(args)
+
+	targets := openTargets(ctx, cfg, *skipOA)
+	targets.CashflowSchema = *schema
+
+	switch cmd {
+	case "install":
+		if err := ledgerlock.Install(ctx, targets); err != nil {
+			log.Fatalf("install: %v", err)
+		}
+		fmt.Println("Ledger lock triggers installed")
+		printStatus(ctx, targets)
+	case "uninstall":
+		if err := ledgerlock.Uninstall(ctx, targets); err != nil {
+			log.Fatalf("uninstall: %v", err)
+		}
+		fmt.Println("Ledger lock triggers removed; lock dates and bypass log kept")
+	case "status":
+		printStatus(ctx, targets)
+	}
+}
+
+func printStatus(ctx context.Context, targets ledgerlock.Targets) {
+	report, err := ledgerlock.Status(ctx, targets)
+	if err != nil {
+		log.Fatalf("status: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATABASE\tKIND\tNAME\tTABLE\tSTATE")
+	healthy := true
+	for _, s := range report {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.Database, s.Kind, s.Name, s.Table, s.State)
+		if s.State != "installed" {
+			healthy = false
+		}
+	}
+	w.Flush()
+
+	dates, err := ledgerlock.LockDates(ctx, targets.Cashflow)
+	if err != nil {
+		log.Printf("lock dates unavailable: %v", err)
+	} else if len(dates) > 0 {
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ORGANIZATION\tLOCKED BEFORE\tUPDATED\tBY")
+		for _, d := range dates {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.OrganizationID, d.LockDate.Format("2006-01-02"), d.UpdatedAt.Format(time.RFC3339), d.UpdatedBy)
+		}
+		w.Flush()
+	}
+
+	if !healthy {
+		os.Exit(1)
+	}
+}
+
+func runSetLockDate(ctx context.Context, cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("set-lock-date", flag.ExitOnError)
+	org := fs.String("org", "", "cashflow organization id")
+	date := fs.String("date", "", "lock date (YYYY-MM-DD); entries dated before it are locked")
+	by := fs.String("by", os.Getenv("USER"), "who is setting the lock date")
+	fs.Parse(args)
+
+	if *org == "" || *date == "" {
+		log.Fatal("set-lock-date: -org and -date are required")
+	}
+	lockDate, err := time.Parse("2006-01-02", *date)
+	if err != nil {
+		log.Fatalf("set-lock-date: invalid -date: %v", err)
+	}
+
+	cashflow := mustOpen(ctx, "cashflow", cfg.CashflowDSN)
+	defer cashflow.Close()
+
+	if err := ledgerlock.SetLockDate(ctx, cashflow, *org, lockDate, *by); err != nil {
+		log.Fatalf("set-lock-date: %v", err)
+	}
+	fmt.Printf("Organization %s locked before %s\n", *org, lockDate.Format("2006-01-02"))
+}
+
+func runClearLockDate(ctx context.Context, cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("clear-lock-date", flag.ExitOnError)
+	org := fs.String("org", "", "cashflow organization id")
+	fs.Parse(args)
+
+	if *org == "" {
+		log.Fatal("clear-lock-date: -org is required")
+	}
+
+	cashflow := mustOpen(ctx, "cashflow", cfg.CashflowDSN)
+	defer cashflow.Close()
+
+	if err := ledgerlock.ClearLockDate(ctx, cashflow, *org); err != nil {
+		log.Fatalf("clear-lock-date: %v", err)
+	}
+	fmt.Printf("Lock date cleared for organization %s\n", *org)
+}
+
+// runBypass applies a reviewed SQL script, typically a reversal, with the
+// triggers relaxed. Statements are separated by lines ending in ';'.
+func runBypass(ctx context.Context, cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("bypass", flag.ExitOnError)
+	target := fs.String("db", "cashflow", "database to run against: oa or cashflow")
+	reason := fs.String("reason", "", "why the lock is bypassed; recorded in ledger_lock_bypass_log")
+	file := fs.String("file", "", "SQL script to run")
+	fs.Parse(args)
+
+	if *file == "" {
+		log.Fatal("bypass: -file is required")
+	}
+	script, err := os.ReadFile(*file)
+	if err != nil {
+		log.Fatalf("bypass: %v", err)
+	}
+
+	dsn := cfg.CashflowDSN
+	if *target == "oa" {
+		dsn = cfg.OADSN
+	}
+	conn := mustOpen(ctx, *target, dsn)
+	defer conn.Close()
+
+	statements := splitStatements(string(script))
+	err = ledgerlock.Bypass(ctx, conn, *reason, func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("%s: %w", firstLine(stmt), err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("bypass: %v", err)
+	}
+	fmt.Printf("Applied %d statements with ledger lock bypassed\n", len(statements))
+}
+
+func openTargets(ctx context.Context, cfg *config.Config, skipOA bool) ledgerlock.Targets {
+	targets := ledgerlock.Targets{Cashflow: mustOpen(ctx, "cashflow", cfg.CashflowDSN)}
+	if !skipOA {
+		targets.OA = mustOpen(ctx, "oa", cfg.OADSN)
+	}
+	return targets
+}
+
+func mustOpen(ctx context.Context, name, dsn string) *sql.DB {
+	conn, err := db.Open(ctx, name, dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
+
+func splitStatements(script string) []string {
+	var out []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			out = append(out, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		out = append(out, rest)
+	}
+	return out
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
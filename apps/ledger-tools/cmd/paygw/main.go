@@ -0,0 +1,258 @@
+// Command paygw receives payment gateway callbacks and records them as
+// invoice payments, and manages the queue of payments it could not match.
+//
+// Usage:
+//
+//	paygw migrate
+//	paygw serve -config paygw.json
+//	paygw queue [-org <organizationId>]
+//	paygw assign -event <id> -invoice <invoiceNumber> [-by user]
+//	paygw dismiss -event <id> -note "refunded at gateway" [-by user]
+//	paygw simulate -url http://localhost:8090/callbacks/<endpoint> -provider kbzpay -secret ... \
+//	    -reference INV-0001 -amount 25000 [-txn id] [-status success]
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/correlation"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/paygw"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openCashflow(ctx)
+		// Callback log lines are kept by correlation id.
+		tables := append(append([]schema.Table{}, paygw.Tables...), correlation.Tables...)
+		if err := schema.Ensure(ctx, conn, tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("payment_gateway_events and job_logs are up to date")
+	case "serve":
+		runServe(ctx, args)
+	case "queue":
+		runQueue(ctx, args)
+	case "assign":
+		runAssign(ctx, args)
+	case "dismiss":
+		runDismiss(ctx, args)
+	case "simulate":
+		runSimulate(args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: paygw migrate|serve|queue|assign|dismiss|simulate [flags]")
+	os.Exit(2)
+}
+
+func runServe(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	cfgPath := fs.String("config", "paygw.json", "receiver configuration file")
+	fs.Parse(args)
+
+	cfg, err := paygw.LoadConfig(*cfgPath)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	logger := log.New(os.Stdout, "paygw ", log.LstdFlags)
+	srv := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           paygw.Handler(cfg, &paygw.Service{DB: conn}, logger),
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		<-stop
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	logger.Printf("listening on %s with %d endpoint(s)", cfg.Listen, len(cfg.Endpoints))
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+func runQueue(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("queue", flag.ExitOnError)
+	org := fs.String("org", "", "only this organization")
+	fs.Parse(args)
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	events, err := paygw.Unmatched(ctx, conn, *org)
+	if err != nil {
+		log.Fatalf("queue: %v", err)
+	}
+	if len(events) == 0 {
+		fmt.Println("No payments waiting for review")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "EVENT\tRECEIVED\tPROVIDER\tTXN\tREFERENCE\tAMOUNT\tREASON")
+	for _, e := range events {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s %s\t%s\n", e.ID, e.ReceivedAt.Format("2006-01-02 15:04"),
+			e.Provider, e.ProviderTxnID, e.Reference, e.Amount, e.Currency, e.Reason)
+	}
+	w.Flush()
+}
+
+func runAssign(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("assign", flag.ExitOnError)
+	eventID := fs.String("event", "", "queued event id")
+	invoice := fs.String("invoice", "", "invoice number to apply the payment to")
+	by := fs.String("by", os.Getenv("USER"), "reviewer")
+	fs.Parse(args)
+	if *eventID == "" || *invoice == "" {
+		log.Fatal("assign: -event and -invoice are required")
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	outcome, err := (&paygw.Service{DB: conn}).Assign(ctx, *eventID, *invoice, *by)
+	if err != nil {
+		log.Fatalf("assign: %v", err)
+	}
+	fmt.Printf("Recorded payment %s against invoice %s (journal %s)\n", outcome.PaymentID, outcome.InvoiceNumber, outcome.JournalID)
+}
+
+func runDismiss(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("dismiss", flag.ExitOnError)
+	eventID := fs.String("event", "", "queued event id")
+	note := fs.String("note", "", "why the payment is not posted")
+	by := fs.String("by", os.Getenv("USER"), "reviewer")
+	fs.Parse(args)
+	if *eventID == "" || *note == "" {
+		log.Fatal("dismiss: -event and -note are required")
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	if err := (&paygw.Service{DB: conn}).Dismiss(ctx, *eventID, *by, *note); err != nil {
+		log.Fatalf("dismiss: %v", err)
+	}
+	fmt.Printf("Event %s dismissed\n", *eventID)
+}
+
+// runSimulate is the local stand-in for a gateway: it signs a callback with
+// the provider adapter and posts it to the receiver.
+func runSimulate(args []string) {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	url := fs.String("url", "http://localhost:8090/callbacks/test", "receiver endpoint URL")
+	providerName := fs.String("provider", "hmac", "provider adapter: "+fmt.Sprint(paygw.Providers()))
+	secret := fs.String("secret", "", "merchant secret shared with the receiver")
+	reference := fs.String("reference", "", "merchant reference (invoice number)")
+	amount := fs.String("amount", "", "amount paid")
+	fee := fs.String("fee", "", "gateway fee withheld")
+	currency := fs.String("currency", "MMK", "currency")
+	status := fs.String("status", paygw.StatusSuccess, "success, failed or pending")
+	txn := fs.String("txn", "", "provider transaction id (random if empty; reuse one to test idempotency)")
+	payer := fs.String("payer", "09123456789", "payer account or phone")
+	tamper := fs.Bool("tamper", false, "corrupt the signature to test rejection")
+	fs.Parse(args)
+
+	provider, err := paygw.Lookup(*providerName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	amt, err := money.Parse(*amount)
+	if err != nil || amt <= 0 {
+		log.Fatal("simulate: -amount must be a positive number")
+	}
+	feeAmt, err := money.Parse(*fee)
+	if err != nil {
+		log.Fatalf("simulate: -fee: %v", err)
+	}
+	if *txn == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		*txn = "SIM" + hex.EncodeToString(b)
+	}
+
+	header, body, err := provider.Sign(&paygw.Callback{
+		TransactionID: *txn,
+		Reference:     *reference,
+		Amount:        amt,
+		Fee:           feeAmt,
+		Currency:      *currency,
+		Status:        *status,
+		PaidAt:        time.Now(),
+		Payer:         *payer,
+	}, *secret)
+	if err != nil {
+		log.Fatalf("simulate: sign: %v", err)
+	}
+	if *tamper {
+		body = bytes.Replace(body, []byte(amt.String()), []byte((amt + 100).String()), 1)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, *url, bytes.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header = header
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("simulate: %v", err)
+	}
+	defer resp.Body.Close()
+	reply, _ := io.ReadAll(resp.Body)
+
+	fmt.Printf("txn %s -> %s %s\n", *txn, resp.Status, bytes.TrimSpace(reply))
+	if resp.StatusCode >= 300 {
+		os.Exit(1)
+	}
+}
+
+func openCashflow(ctx context.Context) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	conn, err := db.Open(ctx, "cashflow", cfg.CashflowDSN)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
@@ -0,0 +1,299 @@
+// Command perftrack benchmarks a fixed set of OA and cashflow queries and
+// postings against a seeded local database, keeps the timings per git
+// revision in a results file, and flags significant regressions between
+// revisions with the EXPLAIN plan diffs of the slower queries.
+//
+// Usage:
+//
+//	perftrack workloads
+//	perftrack run -dataset year-of-history [-runs 30] [-warmup 3] [-only cf.trial_balance,...] [-results perf/results.jsonl] [-rev <commit>]
+//	perftrack history [-results perf/results.jsonl] [-workload cf.trial_balance]
+//	perftrack compare [-results perf/results.jsonl] [-base <rev>] [-head <rev>] [-alpha 0.01] [-min-change 0.10] [-json]
+//
+// Apply the dataset with seed apply first. run compares with the previous
+// revision's latest run when there is one; compare exits 1 on regressions.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/perftrack"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/seed"
+)
+
+const defaultResults = "perf/results.jsonl"
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "workloads":
+		runWorkloads()
+	case "run":
+		runBench(ctx, args)
+	case "history":
+		runHistory(args)
+	case "compare":
+		runCompare(args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: perftrack workloads|run|history|compare [flags]")
+	os.Exit(2)
+}
+
+func runWorkloads() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDATABASE\tKIND\tDESCRIPTION")
+	for _, wl := range perftrack.Workloads() {
+		kind := "read"
+		if wl.Post != nil {
+			kind = "posting"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", wl.Name, wl.DB, kind, wl.Description)
+	}
+	w.Flush()
+}
+
+func runBench(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	dataset := fs.String("dataset", "year-of-history", "seed dataset the databases hold")
+	runs := fs.Int("runs", 30, "recorded iterations per workload")
+	warmup := fs.Int("warmup", 3, "unrecorded iterations per workload first")
+	only := fs.String("only", "", "comma-separated workloads (default: all)")
+	results := fs.String("results", defaultResults, "results file")
+	rev := fs.String("rev", "", "revision to record (default: the checked-out git commit)")
+	force := fs.Bool("force", false, "run even when NODE_ENV is production")
+	fs.Parse(args)
+	if os.Getenv("NODE_ENV") == "production" && !*force {
+		log.Fatal("run: NODE_ENV is production; benchmarks post and roll back, pass -force to run anyway")
+	}
+	if *runs < 2 {
+		log.Fatal("run: -runs must be at least 2")
+	}
+
+	workloads := perftrack.Workloads()
+	if *only != "" {
+		workloads = nil
+		for _, name := range strings.Split(*only, ",") {
+			w, err := perftrack.Lookup(strings.TrimSpace(name))
+			if err != nil {
+				log.Fatalf("run: %v", err)
+			}
+			workloads = append(workloads, w)
+		}
+	}
+
+	run := &perftrack.Run{Revision: *rev, Dataset: *dataset, StartedAt: time.Now().UTC(),
+		Options: perftrack.Options{Warmup: *warmup, Runs: *runs}}
+	if run.Revision == "" {
+		var err error
+		if run.Revision, run.Dirty, err = perftrack.Revision("."); err != nil {
+			log.Fatalf("run: %v (pass -rev outside a git checkout)", err)
+		}
+	}
+	run.Host, _ = os.Hostname()
+
+	d, err := seed.Get(*dataset)
+	if err != nil {
+		log.Fatalf("run: %v", err)
+	}
+	conns := map[string]*sql.DB{
+		perftrack.DBCashflow: openDB(ctx, perftrack.DBCashflow),
+		perftrack.DBOA:       openDB(ctx, perftrack.DBOA),
+	}
+	defer conns[perftrack.DBCashflow].Close()
+	defer conns[perftrack.DBOA].Close()
+	// Ids do not depend on the through date.
+	target, err := perftrack.NewTarget(ctx, conns[perftrack.DBCashflow], d.Build(time.Now()))
+	if err != nil {
+		log.Fatalf("run: %v", err)
+	}
+
+	for _, w := range workloads {
+		r := perftrack.Measure(ctx, conns[w.DB], w, target, run.Options)
+		if r.Error != "" {
+			log.Printf("%s: %s", w.Name, r.Error)
+		} else {
+			log.Printf("%s: median %.2fms over %d runs, %d rows", w.Name, perftrack.Median(r.Samples), len(r.Samples), r.Rows)
+		}
+		run.Results = append(run.Results, r)
+	}
+
+	previous, err := perftrack.Load(*results)
+	if err != nil {
+		log.Fatalf("run: %v", err)
+	}
+	if err := perftrack.Append(*results, run); err != nil {
+		log.Fatalf("run: %v", err)
+	}
+	fmt.Printf("Recorded %s in %s\n", run.Label(), *results)
+	if base := perftrack.Previous(previous, run); base != nil {
+		fmt.Println()
+		printChanges(base, run, perftrack.Compare(base, run, perftrack.DefaultThresholds))
+	}
+}
+
+func runHistory(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	results := fs.String("results", defaultResults, "results file")
+	workload := fs.String("workload", "", "show this workload's median per run")
+	fs.Parse(args)
+
+	runs, err := perftrack.Load(*results)
+	if err != nil {
+		log.Fatalf("history: %v", err)
+	}
+	if len(runs) == 0 {
+		fmt.Println("No runs")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if *workload == "" {
+		fmt.Fprintln(w, "STARTED\tREVISION\tDATASET\tHOST\tWORKLOADS\tFAILED")
+	} else {
+		fmt.Fprintln(w, "STARTED\tREVISION\tDATASET\tHOST\tMEDIAN MS\tRUNS\tROWS")
+	}
+	for _, r := range runs {
+		prefix := fmt.Sprintf("%s\t%s\t%s\t%s", r.StartedAt.Format("2006-01-02 15:04"), shortRev(r), r.Dataset, r.Host)
+		if *workload == "" {
+			failed := 0
+			for _, res := range r.Results {
+				if res.Error != "" {
+					failed++
+				}
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\n", prefix, len(r.Results), failed)
+			continue
+		}
+		res := r.Result(*workload)
+		switch {
+		case res == nil:
+			fmt.Fprintf(w, "%s\t-\t-\t-\n", prefix)
+		case res.Error != "":
+			fmt.Fprintf(w, "%s\tfailed\t-\t-\n", prefix)
+		default:
+			fmt.Fprintf(w, "%s\t%.2f\t%d\t%d\n", prefix, perftrack.Median(res.Samples), len(res.Samples), res.Rows)
+		}
+	}
+	w.Flush()
+}
+
+func runCompare(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	results := fs.String("results", defaultResults, "results file")
+	baseRev := fs.String("base", "", "baseline revision (default: the newest run of another revision than head)")
+	headRev := fs.String("head", "", "revision to check (default: the newest run)")
+	alpha := fs.Float64("alpha", perftrack.DefaultThresholds.Alpha, "significance level")
+	minChange := fs.Float64("min-change", perftrack.DefaultThresholds.MinChange, "smallest median change to report, as a fraction")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args)
+
+	runs, err := perftrack.Load(*results)
+	if err != nil {
+		log.Fatalf("compare: %v", err)
+	}
+	head := perftrack.Latest(runs, *headRev)
+	if head == nil {
+		log.Fatalf("compare: no run of %q in %s", *headRev, *results)
+	}
+	var base *perftrack.Run
+	if *baseRev != "" {
+		base = perftrack.Latest(runs, *baseRev)
+	} else {
+		base = perftrack.Previous(runs, head)
+	}
+	if base == nil {
+		log.Fatalf("compare: no baseline run in %s", *results)
+	}
+
+	changes := perftrack.Compare(base, head, perftrack.Thresholds{Alpha: *alpha, MinChange: *minChange})
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]any{"base": base.Label(), "head": head.Label(), "changes": changes})
+	} else {
+		printChanges(base, head, changes)
+	}
+	for _, c := range changes {
+		if c.Verdict == perftrack.VerdictRegression {
+			os.Exit(1)
+		}
+	}
+}
+
+// printChanges prints the comparison table, then the plan diffs of the
+// workloads whose plan changed.
+func printChanges(base, head *perftrack.Run, changes []*perftrack.Change) {
+	fmt.Printf("%s -> %s\n", shortRev(base), shortRev(head))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "WORKLOAD\tBASE MS\tHEAD MS\tCHANGE\tP\tVERDICT\tPLAN")
+	for _, c := range changes {
+		plan := ""
+		if c.PlanChanged {
+			plan = "changed"
+		}
+		switch c.Verdict {
+		case perftrack.VerdictNew, perftrack.VerdictMissing, perftrack.VerdictFailed:
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t%s\t%s\n", c.Workload, c.Verdict, c.Error)
+		default:
+			fmt.Fprintf(w, "%s\t%.2f\t%.2f\t%+.1f%%\t%.3g\t%s\t%s\n", c.Workload, c.BaseMedian, c.HeadMedian,
+				(c.Ratio-1)*100, c.P, c.Verdict, plan)
+		}
+	}
+	w.Flush()
+
+	for _, c := range changes {
+		if !c.PlanChanged {
+			continue
+		}
+		fmt.Printf("\n%s plan (%s):\n", c.Workload, c.Verdict)
+		for _, line := range c.PlanDiff {
+			fmt.Println("  " + line)
+		}
+	}
+}
+
+func shortRev(r *perftrack.Run) string {
+	label := r.Label()
+	if len(r.Revision) > 12 {
+		label = r.Revision[:12] + strings.TrimPrefix(label, r.Revision)
+	}
+	return label
+}
+
+func openDB(ctx context.Context, name string) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	dsn := cfg.CashflowDSN
+	if name == "oa" {
+		dsn = cfg.OADSN
+	}
+	conn, err := db.Open(ctx, name, dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
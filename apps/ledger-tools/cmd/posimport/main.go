@@ -0,0 +1,212 @@
+// Command posimport posts end-of-day POS exports as summarized sales journals
+// or invoices, consumes the sold stock FIFO and reports variances.
+//
+// Usage:
+//
+//	posimport migrate
+//	posimport import -mapping pos-mapping.json [-format receipt-csv|summary-csv|json] \
+//	    [-dry-run] [-allow-shortfall] [-report report.json] file...
+//	posimport history -org <organizationId> [-from 2025-01-01] [-to 2025-01-31]
+//	posimport show -org <organizationId> -batch <batchId>
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/pos"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openCashflow(ctx)
+		if err := schema.Ensure(ctx, conn, pos.Tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("pos_import_batches is up to date")
+	case "import":
+		runImport(ctx, args)
+	case "history":
+		runHistory(ctx, args)
+	case "show":
+		runShow(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: posimport migrate|import|history|show [flags]")
+	os.Exit(2)
+}
+
+func runImport(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	mappingPath := fs.String("mapping", "pos-mapping.json", "organization mapping file")
+	format := fs.String("format", "", "receipt-csv, summary-csv or json (detected when empty)")
+	dryRun := fs.Bool("dry-run", false, "post and roll back, printing the variance report")
+	allowShortfall := fs.Bool("allow-shortfall", false, "post sales of stock the warehouse does not hold")
+	reportPath := fs.String("report", "", "also write the reports as JSON to this file")
+	by := fs.String("by", os.Getenv("USER"), "who is importing")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		log.Fatal("import: no files given")
+	}
+
+	mapping, err := pos.LoadMapping(*mappingPath)
+	if err != nil {
+		log.Fatalf("mapping: %v", err)
+	}
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	var reports []*pos.Report
+	failed := 0
+	for _, path := range fs.Args() {
+		batches, err := readBatches(path, *format)
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+			failed++
+			continue
+		}
+		im := &pos.Importer{
+			DB:             conn,
+			Mapping:        mapping,
+			AllowShortfall: *allowShortfall,
+			DryRun:         *dryRun,
+			SourceFile:     path,
+			ImportedBy:     *by,
+		}
+		for _, b := range batches {
+			rep, err := im.Import(ctx, b)
+			if err != nil {
+				failed++
+			}
+			rep.Print(os.Stdout)
+			fmt.Println()
+			reports = append(reports, rep)
+		}
+	}
+
+	if *reportPath != "" {
+		out, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*reportPath, out, 0o644); err != nil {
+			log.Fatalf("report: %v", err)
+		}
+	}
+	if failed > 0 {
+		log.Fatalf("%d batch(es) or file(s) not imported", failed)
+	}
+}
+
+func readBatches(path, format string) ([]*pos.Batch, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	if format == "" {
+		head, _ := r.Peek(512)
+		if format, err = pos.DetectFormat(path, head); err != nil {
+			return nil, err
+		}
+	}
+	return pos.Parse(format, r)
+}
+
+func runHistory(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	from := fs.String("from", time.Now().AddDate(0, 0, -30).Format(time.DateOnly), "first business date")
+	to := fs.String("to", time.Now().Format(time.DateOnly), "last business date")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("history: -org is required")
+	}
+	fromDate, err := time.Parse(time.DateOnly, *from)
+	if err != nil {
+		log.Fatalf("history: -from: %v", err)
+	}
+	toDate, err := time.Parse(time.DateOnly, *to)
+	if err != nil {
+		log.Fatalf("history: -to: %v", err)
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	records, err := pos.Records(ctx, conn, *org, fromDate, toDate)
+	if err != nil {
+		log.Fatalf("history: %v", err)
+	}
+	if len(records) == 0 {
+		fmt.Println("No POS batches imported in that range")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATE\tBRANCH\tBATCH\tSALES\tTAX\tPAYMENTS\tOVER/SHORT\tCOGS\tVARIANCES\tIMPORTED")
+	for _, rec := range records {
+		r := rec.Report
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", rec.BusinessDate.Format(time.DateOnly), rec.BranchCode,
+			rec.BatchID, r.Sales, r.Tax, r.Payments, r.OverShort, r.COGS, len(r.Variances),
+			rec.ImportedAt.Format("2006-01-02 15:04"))
+	}
+	w.Flush()
+}
+
+func runShow(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	batch := fs.String("batch", "", "POS batch id")
+	fs.Parse(args)
+	if *org == "" || *batch == "" {
+		log.Fatal("show: -org and -batch are required")
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	rec, err := pos.GetRecord(ctx, conn, *org, *batch)
+	if err != nil {
+		log.Fatalf("show: batch %s: %v", *batch, err)
+	}
+	fmt.Printf("Imported %s by %s from %s\n", rec.ImportedAt.Format(time.DateTime), rec.ImportedBy, rec.SourceFile)
+	rec.Report.Print(os.Stdout)
+}
+
+func openCashflow(ctx context.Context) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	conn, err := db.Open(ctx, "cashflow", cfg.CashflowDSN)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
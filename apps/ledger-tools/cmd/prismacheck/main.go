@@ -0,0 +1,241 @@
+// Command prismacheck verifies that cashflowdb matches the BFF's Prisma
+// migration history. history compares apps/bff/prisma/migrations with
+// _prisma_migrations; verify also replays the recorded migrations into a
+// throwaway database on a scratch MySQL server and diffs the schema they
+// produce against the live INFORMATION_SCHEMA. Both exit 1 when they find
+// anything.
+//
+// Usage:
+//
+//	prismacheck history [-dir apps/bff/prisma/migrations] [-json]
+//	prismacheck verify -scratch mysql://root:pw@localhost:3307/ [-dir ...] [-keep] [-json]
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/attachments"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/billmail"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/books"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/canary"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/correlation"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/dimensions"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/forecast"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/ingest"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/intercompany"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/jobcost"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/ledgerlock"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/paygw"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/pos"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/prismacheck"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/reopen"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/reports"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schedule"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/snapshots"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/stockrecon"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/warehouse"
+)
+
+// ownedTables are the cashflowdb side tables the ledger tools create
+// themselves; they are expected outside the Prisma migrations.
+var ownedTables = [][]schema.Table{
+	attachments.Tables, billmail.Tables, books.Tables, canary.Tables, correlation.Tables, dimensions.Tables,
+	forecast.Tables, ingest.Tables, intercompany.Tables, jobcost.Tables, paygw.Tables, pos.Tables,
+	reopen.Tables, reports.Tables, schedule.Tables, snapshots.Tables, stockrecon.Tables, warehouse.Tables,
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "history":
+		runHistory(ctx, args)
+	case "verify":
+		runVerify(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: prismacheck history|verify [flags]")
+	os.Exit(2)
+}
+
+func runHistory(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	dir := fs.String("dir", "apps/bff/prisma/migrations", "Prisma migrations directory")
+	asJSON := fs.Bool("json", false, "print findings as JSON")
+	fs.Parse(args)
+
+	_, conn := openCashflow(ctx)
+	defer conn.Close()
+	h := history(ctx, conn, *dir)
+	if !*asJSON {
+		printHistory(h)
+	}
+	report(h.Findings, *asJSON)
+}
+
+func runVerify(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	dir := fs.String("dir", "apps/bff/prisma/migrations", "Prisma migrations directory")
+	scratch := fs.String("scratch", os.Getenv("SCRATCH_DATABASE_URL"), "throwaway MySQL server to replay migrations on (mysql:// URL or DSN)")
+	keep := fs.Bool("keep", false, "keep the scratch database for inspection")
+	asJSON := fs.Bool("json", false, "print findings as JSON")
+	fs.Parse(args)
+	if *scratch == "" {
+		log.Fatal("verify: -scratch or SCRATCH_DATABASE_URL is required")
+	}
+	scratchDSN := *scratch
+	if strings.HasPrefix(scratchDSN, "mysql://") {
+		dsn, err := config.DSNFromURL(scratchDSN)
+		if err != nil {
+			log.Fatalf("verify: -scratch: %v", err)
+		}
+		scratchDSN = dsn
+	}
+
+	cfg, conn := openCashflow(ctx)
+	defer conn.Close()
+	if sameServer(cfg.CashflowDSN, scratchDSN) {
+		log.Fatal("verify: the scratch server is the production server; use a throwaway MySQL instance")
+	}
+
+	h := history(ctx, conn, *dir)
+	expected, name, err := prismacheck.Replay(ctx, scratchDSN, h.Recorded, *keep)
+	if err != nil {
+		log.Fatalf("verify: %v", err)
+	}
+	actual, err := prismacheck.Load(ctx, conn)
+	if err != nil {
+		log.Fatalf("verify: %v", err)
+	}
+
+	known := map[string]bool{"_prisma_migrations": true}
+	for _, tables := range ownedTables {
+		for _, t := range tables {
+			known[t.Name] = true
+		}
+	}
+	knownTriggers := map[string]bool{}
+	for _, o := range ledgerlock.Objects("") {
+		if o.Database != ledgerlock.Cashflow {
+			continue
+		}
+		switch o.Kind {
+		case "TABLE":
+			known[o.Name] = true
+		case "TRIGGER":
+			knownTriggers[o.Name] = true
+		}
+	}
+	findings := append(h.Findings, prismacheck.Diff(expected, actual, known, knownTriggers)...)
+
+	if !*asJSON {
+		printHistory(h)
+		fmt.Printf("\nReplayed %d recorded migrations", len(h.Recorded))
+		if *keep {
+			fmt.Printf(" into %s (kept)", name)
+		}
+		fmt.Printf(": %d tables expected, %d in the database\n", len(expected.Tables), len(actual.Tables))
+	}
+	report(findings, *asJSON)
+}
+
+func history(ctx context.Context, conn *sql.DB, dir string) *prismacheck.History {
+	migrations, err := prismacheck.ReadDir(dir)
+	if err != nil {
+		log.Fatalf("migrations: %v", err)
+	}
+	applied, err := prismacheck.LoadApplied(ctx, conn)
+	if err != nil {
+		log.Fatalf("_prisma_migrations: %v", err)
+	}
+	return prismacheck.Check(migrations, applied)
+}
+
+func printHistory(h *prismacheck.History) {
+	recorded := map[string]bool{}
+	for _, m := range h.Recorded {
+		recorded[m.Name] = true
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MIGRATION\tCHECKSUM\tRECORDED")
+	for _, m := range h.Migrations {
+		fmt.Fprintf(w, "%s\t%s\t%v\n", m.Name, m.Checksum[:12], recorded[m.Name])
+	}
+	w.Flush()
+	fmt.Printf("%d migrations in the directory, %d rows in _prisma_migrations\n", len(h.Migrations), len(h.Applied))
+}
+
+// report prints the findings and exits 1 when there are any.
+func report(findings []prismacheck.Finding, asJSON bool) {
+	if asJSON {
+		if findings == nil {
+			findings = []prismacheck.Finding{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(findings); err != nil {
+			log.Fatal(err)
+		}
+	} else if len(findings) == 0 {
+		fmt.Println("\nNo differences found")
+	} else {
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tOBJECT\tDETAIL")
+		for _, f := range findings {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", f.Kind, f.Object, f.Detail)
+		}
+		w.Flush()
+		fmt.Printf("%d findings\n", len(findings))
+	}
+	if len(findings) > 0 {
+		os.Exit(1)
+	}
+}
+
+// sameServer reports whether two DSNs point at the same MySQL server.
+func sameServer(a, b string) bool {
+	ca, err := mysql.ParseDSN(a)
+	if err != nil {
+		return false
+	}
+	cb, err := mysql.ParseDSN(b)
+	if err != nil {
+		return false
+	}
+	return ca.Net == cb.Net && ca.Addr == cb.Addr
+}
+
+func openCashflow(ctx context.Context) (*config.Config, *sql.DB) {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	conn, err := db.Open(ctx, "cashflow", cfg.CashflowDSN)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cfg, conn
+}
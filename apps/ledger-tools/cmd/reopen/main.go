@@ -0,0 +1,307 @@
+// Command reopen governs reopening closed accounting periods: a reopen
+// needs a reason and an approver other than the requester, the period's
+// journals are snapshotted when it opens, and closing it again reports
+// every journal inserted, edited or deleted meanwhile with the net change
+// per account, and the same for the organization's OA transactions and
+// accounts.
+//
+// Usage:
+//
+//	reopen migrate
+//	reopen request -period <periodId> -reason "Late supplier invoices" [-by <user>]
+//	reopen approve -id <reopenId> [-by <user>] [-note "..."]
+//	reopen reject -id <reopenId> [-by <user>] [-note "..."]
+//	reopen close -id <reopenId> [-by <user>] [-soft] [-oa=false]
+//	reopen report -id <reopenId> [-json] [-oa=false]
+//	reopen list -org <organizationId> [-status requested|open|closed|rejected]
+//
+// report previews the impact of a reopen that is still open. With
+// -oa=false the OA database is not opened and the report says OA was not
+// checked.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/reopen"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openDB(ctx, "cashflow")
+		if err := schema.Ensure(ctx, conn, reopen.Tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("period_reopens, period_reopen_journals and period_reopen_entries are up to date")
+	case "request":
+		runRequest(ctx, args)
+	case "approve", "reject":
+		runDecide(ctx, cmd, args)
+	case "close":
+		runClose(ctx, args)
+	case "report":
+		runReport(ctx, args)
+	case "list":
+		runList(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: reopen migrate|request|approve|reject|close|report|list [flags]")
+	os.Exit(2)
+}
+
+func runRequest(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("request", flag.ExitOnError)
+	period := fs.String("period", "", "accounting period id")
+	reason := fs.String("reason", "", "why the period must be reopened")
+	by := fs.String("by", os.Getenv("USER"), "who is requesting")
+	fs.Parse(args)
+	if *period == "" || *reason == "" {
+		log.Fatal("request: -period and -reason are required")
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+
+	r, err := reopen.Request(ctx, conn, *period, *reason, *by)
+	if err != nil {
+		log.Fatalf("request: %v", err)
+	}
+	fmt.Printf("Requested %s to reopen %s (%s to %s); it needs approval by someone other than %s\n",
+		r.ID, r.PeriodName, r.Start, r.End, r.RequestedBy)
+}
+
+func runDecide(ctx context.Context, cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	id := fs.String("id", "", "reopen id")
+	by := fs.String("by", os.Getenv("USER"), "who is deciding")
+	note := fs.String("note", "", "note kept with the decision")
+	fs.Parse(args)
+	if *id == "" {
+		log.Fatalf("%s: -id is required", cmd)
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+
+	if cmd == "reject" {
+		if err := reopen.Reject(ctx, conn, *id, *by, *note); err != nil {
+			log.Fatalf("reject: %v", err)
+		}
+		fmt.Printf("Rejected %s\n", *id)
+		return
+	}
+	n, err := reopen.Approve(ctx, conn, *id, *by, *note)
+	if err != nil {
+		log.Fatalf("approve: %v", err)
+	}
+	fmt.Printf("Approved %s; the period is open and %d journals were snapshotted\n", *id, n)
+}
+
+func runClose(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("close", flag.ExitOnError)
+	id := fs.String("id", "", "reopen id")
+	by := fs.String("by", os.Getenv("USER"), "who is closing the period")
+	soft := fs.Bool("soft", false, "soft close the period")
+	withOA := fs.Bool("oa", true, "include the OA transactions in the report")
+	fs.Parse(args)
+	if *id == "" {
+		log.Fatal("close: -id is required")
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+	var oadb cashflow.Querier
+	if *withOA {
+		oaConn := openDB(ctx, "oa")
+		defer oaConn.Close()
+		oadb = oaConn
+	}
+
+	report, err := reopen.Close(ctx, conn, oadb, *id, *by, *soft)
+	if err != nil {
+		log.Fatalf("close: %v", err)
+	}
+	printReport(report)
+}
+
+func runReport(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	id := fs.String("id", "", "reopen id")
+	asJSON := fs.Bool("json", false, "print JSON")
+	withOA := fs.Bool("oa", true, "include the OA transactions in a preview")
+	fs.Parse(args)
+	if *id == "" {
+		log.Fatal("report: -id is required")
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+
+	r, err := reopen.Get(ctx, conn, *id)
+	if err != nil {
+		log.Fatalf("report: %v", err)
+	}
+	report := r.Report
+	switch r.Status {
+	case reopen.StatusOpen:
+		var oadb cashflow.Querier
+		if *withOA {
+			oaConn := openDB(ctx, "oa")
+			defer oaConn.Close()
+			oadb = oaConn
+		}
+		if report, err = reopen.Preview(ctx, conn, oadb, *id); err != nil {
+			log.Fatalf("report: %v", err)
+		}
+	case reopen.StatusClosed:
+	default:
+		log.Fatalf("report: reopen %s is %s; there is nothing to report", *id, r.Status)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+	if r.Status == reopen.StatusOpen {
+		fmt.Println("Preview: the period is still open")
+	}
+	printReport(report)
+}
+
+func printReport(r *reopen.Report) {
+	inserted, edited, deleted := r.Counts()
+	fmt.Printf("%s (%s to %s), reopened %s: %s\n", r.Period, r.Start, r.End, r.OpenedAt.Format("2006-01-02 15:04"), r.Reason)
+	fmt.Printf("%d inserted, %d edited, %d deleted\n\n", inserted, edited, deleted)
+
+	if len(r.Journals) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CHANGE\tJOURNAL\tDATE\tSTATUS\tTOTAL\tDETAILS")
+		for _, c := range r.Journals {
+			j := c.After
+			if j == nil {
+				j = c.Before
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Change, j.Number, j.Date, j.Status, j.Total(),
+				strings.Join(c.Details, "; "))
+		}
+		w.Flush()
+		fmt.Println()
+	}
+
+	if len(r.Accounts) == 0 {
+		fmt.Println("No account balances changed")
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CODE\tACCOUNT\tBEFORE\tAFTER\tCHANGE")
+		for _, a := range r.Accounts {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", a.Code, a.Name, a.Before, a.After, a.Change)
+		}
+		w.Flush()
+	}
+
+	fmt.Println()
+	switch {
+	case !r.OAChecked:
+		fmt.Println("OA: not checked; transactions posted to OA in the period are not in this report")
+		return
+	case r.OAOrgID == "":
+		fmt.Println("OA: the organization has no OA org")
+		return
+	case len(r.OATransactions) == 0:
+		fmt.Printf("OA org %s: no transactions changed\n", r.OAOrgID)
+		return
+	}
+	fmt.Printf("OA org %s:\n", r.OAOrgID)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANGE\tTRANSACTION\tDATE\tTOTAL\tDESCRIPTION\tDETAILS")
+	for _, c := range r.OATransactions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Change, c.TransactionID, c.Date, c.Total, c.Description,
+			strings.Join(c.Details, "; "))
+	}
+	w.Flush()
+	if len(r.OAAccounts) == 0 {
+		return
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OA ACCOUNT\tCHANGE")
+	for _, a := range r.OAAccounts {
+		fmt.Fprintf(w, "%s\t%s\n", a.Name, a.Change)
+	}
+	w.Flush()
+}
+
+func runList(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	status := fs.String("status", "", "only reopens in this status")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("list: -org is required")
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+
+	reopens, err := reopen.List(ctx, conn, *org, *status)
+	if err != nil {
+		log.Fatalf("list: %v", err)
+	}
+	if len(reopens) == 0 {
+		fmt.Println("No reopens")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPERIOD\tSTATUS\tREQUESTED\tBY\tAPPROVER\tCLOSED\tREASON")
+	for _, r := range reopens {
+		closed := "-"
+		if r.ClosedAt.Valid {
+			closed = r.ClosedAt.Time.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.PeriodName, r.Status,
+			r.RequestedAt.Format("2006-01-02 15:04"), r.RequestedBy, r.ApprovedBy, closed, r.Reason)
+	}
+	w.Flush()
+}
+
+func openDB(ctx context.Context, name string) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	dsn := cfg.CashflowDSN
+	if name == "oa" {
+		dsn = cfg.OADSN
+	}
+	conn, err := db.Open(ctx, name, dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
@@ -0,0 +1,373 @@
+// Command reportd generates stored reports on a schedule and delivers them
+// by email, webhook or file drop, keeping a delivery history with retries.
+//
+// Usage:
+//
+//	reportd migrate
+//	reportd subscribe -org <organizationId> -name weekly-pl -report mgmt-pl -cadence "weekly mon 08:00" -channel smtp -to owner@example.com [-format pdf] [-as-of yesterday] [-version N] [-secret env:VAR]
+//	reportd list -org <organizationId>
+//	reportd pause -org <organizationId> -name weekly-pl
+//	reportd resume -org <organizationId> -name weekly-pl
+//	reportd history -org <organizationId> [-name weekly-pl] [-status failed] [-limit 50]
+//	reportd send -config reportd.json -org <organizationId> -name weekly-pl
+//	reportd retry -config reportd.json -delivery <deliveryId>
+//	reportd run -config reportd.json
+//	reportd once -config reportd.json
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/attachments"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/correlation"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/reports"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schedule"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/tenantlimit"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openDB(ctx, "cashflow")
+		// Generated reports are kept in the attachment store and attempt
+		// log lines in job_logs.
+		tables := append(append(append([]schema.Table{}, schedule.Tables...), attachments.Tables...), correlation.Tables...)
+		if err := schema.Ensure(ctx, conn, tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("report_subscriptions, report_deliveries, attachments and job_logs are up to date")
+	case "subscribe":
+		runSubscribe(ctx, args)
+	case "list":
+		runList(ctx, args)
+	case "pause", "resume":
+		runSetActive(ctx, cmd, args)
+	case "history":
+		runHistory(ctx, args)
+	case "send":
+		runSend(ctx, args)
+	case "retry":
+		runRetry(ctx, args)
+	case "run", "once":
+		runScheduler(ctx, cmd, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: reportd migrate|subscribe|list|pause|resume|history|send|retry|run|once [flags]")
+	os.Exit(2)
+}
+
+func runSubscribe(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("subscribe", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	name := fs.String("name", "", "subscription name, unique per organization")
+	report := fs.String("report", "", "stored report code")
+	version := fs.Int("version", 0, "report version (default: latest at each run)")
+	cadence := fs.String("cadence", "", `"daily HH:MM", "weekly mon,thu HH:MM" or "monthly 1|last HH:MM" in the org's timezone`)
+	asOf := fs.String("as-of", schedule.AsOfYesterday, "report date: today, yesterday, week-end or month-end")
+	format := fs.String("format", reports.OutputPDF, "text, csv, xlsx or pdf")
+	channel := fs.String("channel", schedule.ChannelSMTP, "smtp, webhook or file")
+	to := fs.String("to", "", "comma-separated emails, a webhook URL, or a directory under the file root")
+	secret := fs.String("secret", "", "webhook signing secret, literal or env:VAR_NAME")
+	by := fs.String("by", os.Getenv("USER"), "who created it")
+	fs.Parse(args)
+	if *org == "" || *name == "" || *report == "" || *cadence == "" || *to == "" {
+		log.Fatal("subscribe: -org, -name, -report, -cadence and -to are required")
+	}
+
+	sub := &schedule.Subscription{
+		OrganizationID: *org, Name: *name, ReportCode: *report,
+		Params:  schedule.Params{AsOf: *asOf, Version: *version},
+		Cadence: *cadence, Format: *format, Channel: *channel, Recipients: strings.Split(*to, ","),
+		Secret: *secret, CreatedBy: *by,
+	}
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+	loc, err := schedule.Location(ctx, cf, oadb, *org)
+	if err != nil {
+		log.Fatalf("subscribe: %v", err)
+	}
+	if err := schedule.Subscribe(ctx, cf, sub, loc, time.Now()); err != nil {
+		log.Fatalf("subscribe: %v", err)
+	}
+	fmt.Printf("Subscription %s (%s) first runs %s\n", sub.Name, sub.Cadence, sub.NextRunAt.In(loc).Format("Mon 2 Jan 2006 15:04 MST"))
+}
+
+func runList(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("list: -org is required")
+	}
+
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+	subs, err := schedule.List(ctx, cf, *org)
+	if err != nil {
+		log.Fatalf("list: %v", err)
+	}
+	if len(subs) == 0 {
+		fmt.Println("No subscriptions")
+		return
+	}
+	loc, err := schedule.Location(ctx, cf, oadb, *org)
+	if err != nil {
+		log.Fatalf("list: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tREPORT\tCADENCE\tAS OF\tFORMAT\tCHANNEL\tTO\tNEXT RUN")
+	for _, s := range subs {
+		report := s.ReportCode
+		if s.Params.Version > 0 {
+			report += fmt.Sprintf(" v%d", s.Params.Version)
+		}
+		next := "paused"
+		if s.Active && s.NextRunAt != nil {
+			next = s.NextRunAt.In(loc).Format("2006-01-02 15:04 MST")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, report, s.Cadence, s.Params.AsOf, s.Format,
+			s.Channel, strings.Join(s.Recipients, ","), next)
+	}
+	w.Flush()
+}
+
+func runSetActive(ctx context.Context, cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	name := fs.String("name", "", "subscription name")
+	fs.Parse(args)
+	if *org == "" || *name == "" {
+		log.Fatalf("%s: -org and -name are required", cmd)
+	}
+
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+	sub, err := schedule.ByName(ctx, cf, *org, *name)
+	if err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+	loc, err := schedule.Location(ctx, cf, oadb, *org)
+	if err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+	if err := schedule.SetActive(ctx, cf, sub, cmd == "resume", loc, time.Now()); err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+	if sub.Active {
+		fmt.Printf("Subscription %s resumed; next run %s\n", sub.Name, sub.NextRunAt.In(loc).Format("Mon 2 Jan 2006 15:04 MST"))
+		return
+	}
+	fmt.Printf("Subscription %s paused\n", sub.Name)
+}
+
+func runHistory(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	name := fs.String("name", "", "only this subscription")
+	status := fs.String("status", "", "pending, retrying, delivered or failed")
+	limit := fs.Int("limit", 50, "maximum rows")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("history: -org is required")
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+	subID := ""
+	if *name != "" {
+		sub, err := schedule.ByName(ctx, conn, *org, *name)
+		if err != nil {
+			log.Fatalf("history: %v", err)
+		}
+		subID = sub.ID
+	}
+	list, err := schedule.History(ctx, conn, *org, subID, *status, *limit)
+	if err != nil {
+		log.Fatalf("history: %v", err)
+	}
+	if len(list) == 0 {
+		fmt.Println("No deliveries")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSUBSCRIPTION\tSCHEDULED (UTC)\tAS OF\tSTATUS\tATTEMPTS\tNEXT ATTEMPT\tERROR")
+	for _, d := range list {
+		asOf, next := "", ""
+		if d.AsOf != nil {
+			asOf = d.AsOf.Format(time.DateOnly)
+		}
+		if d.NextAttemptAt != nil && d.Status == schedule.DeliveryRetrying {
+			next = d.NextAttemptAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", d.ID, d.Subscription, d.ScheduledFor.Format("2006-01-02 15:04"),
+			asOf, d.Status, d.Attempts, next, d.Error)
+	}
+	w.Flush()
+}
+
+func runSend(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	cfgPath := fs.String("config", "reportd.json", "scheduler configuration file")
+	org := fs.String("org", "", "organization id")
+	name := fs.String("name", "", "subscription name")
+	fs.Parse(args)
+	if *org == "" || *name == "" {
+		log.Fatal("send: -org and -name are required")
+	}
+
+	s, _ := newScheduler(ctx, *cfgPath)
+	defer s.DB.Close()
+	defer s.OA.Close()
+	sub, err := schedule.ByName(ctx, s.DB, *org, *name)
+	if err != nil {
+		log.Fatalf("send: %v", err)
+	}
+	now := time.Now()
+	d, err := schedule.SendNow(ctx, s.DB, sub, now)
+	if err != nil {
+		log.Fatalf("send: %v", err)
+	}
+	ctx, trace := correlation.Ensure(ctx)
+	if err := s.Attempt(ctx, d, now); err != nil {
+		log.Fatalf("send: delivery %s (trace %s): %v", d.ID, trace, err)
+	}
+	fmt.Printf("Delivery %s sent (trace %s)\n", d.ID, trace)
+}
+
+func runRetry(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("retry", flag.ExitOnError)
+	cfgPath := fs.String("config", "reportd.json", "scheduler configuration file")
+	id := fs.String("delivery", "", "failed delivery id")
+	fs.Parse(args)
+	if *id == "" {
+		log.Fatal("retry: -delivery is required")
+	}
+
+	s, _ := newScheduler(ctx, *cfgPath)
+	defer s.DB.Close()
+	defer s.OA.Close()
+	now := time.Now()
+	if err := schedule.Retry(ctx, s.DB, *id, now); err != nil {
+		log.Fatalf("retry: %v", err)
+	}
+	d, err := schedule.GetDelivery(ctx, s.DB, *id)
+	if err != nil {
+		log.Fatalf("retry: %v", err)
+	}
+	ctx, trace := correlation.Ensure(ctx)
+	if err := s.Attempt(ctx, d, now); err != nil {
+		log.Fatalf("retry: %v (trace %s); it will be retried by the scheduler", err, trace)
+	}
+	fmt.Printf("Delivery %s sent (trace %s)\n", d.ID, trace)
+}
+
+func runScheduler(ctx context.Context, cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	cfgPath := fs.String("config", "reportd.json", "scheduler configuration file")
+	fs.Parse(args)
+
+	s, limiter := newScheduler(ctx, *cfgPath)
+	defer s.DB.Close()
+	defer s.OA.Close()
+	if cmd == "once" {
+		s.Tick(ctx, time.Now())
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if limiter != nil && s.Config.MetricsListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", limiter)
+		srv := &http.Server{Addr: s.Config.MetricsListen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				s.Logger.Printf("metrics: %v", err)
+			}
+		}()
+		defer srv.Close()
+		s.Logger.Printf("serving tenant limiter metrics on %s/metrics", s.Config.MetricsListen)
+	}
+	s.Logger.Printf("checking subscriptions every %s", time.Duration(s.Config.Interval))
+	if err := s.Run(ctx); err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+}
+
+// newScheduler returns the scheduler and, when the configuration sets
+// tenant limits, the limiter both its connections run under.
+func newScheduler(ctx context.Context, path string) (*schedule.Scheduler, *tenantlimit.Limiter) {
+	cfg, err := schedule.LoadConfig(path)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	s := &schedule.Scheduler{
+		Config: cfg,
+		Store:  attachments.Dir(cfg.AttachmentDir),
+		Logger: log.New(os.Stdout, "reportd ", log.LstdFlags),
+	}
+	if cfg.TenantLimits == nil {
+		s.DB, s.OA = openDB(ctx, "cashflow"), openDB(ctx, "oa")
+		return s, nil
+	}
+	limiter := tenantlimit.New(cfg.TenantLimits)
+	s.DB, s.OA = openLimited(ctx, "cashflow", limiter), openLimited(ctx, "oa", limiter)
+	return s, limiter
+}
+
+func openDB(ctx context.Context, name string) *sql.DB {
+	return openLimited(ctx, name, nil)
+}
+
+// openLimited opens a database whose queries run under the limiter, or
+// unlimited when it is nil.
+func openLimited(ctx context.Context, name string, limiter *tenantlimit.Limiter) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	dsn := cfg.CashflowDSN
+	if name == "oa" {
+		dsn = cfg.OADSN
+	}
+	var conn *sql.DB
+	if limiter == nil {
+		conn, err = db.Open(ctx, name, dsn)
+	} else {
+		conn, err = db.OpenLimited(ctx, name, dsn, limiter)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
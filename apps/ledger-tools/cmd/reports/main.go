@@ -0,0 +1,272 @@
+// Command reports builds custom financial reports from declarative
+// definitions in YAML or JSON: rows of account selectors, formulas and
+// subtotals against columns of periods, budgets, dimension breakdowns and
+// variances. Definitions are stored per organization with every version
+// kept.
+//
+// Usage:
+//
+//	reports migrate
+//	reports validate -file definition.yaml
+//	reports save -org <organizationId> -file definition.yaml [-by <user>]
+//	reports list -org <organizationId>
+//	reports versions -org <organizationId> -code <code>
+//	reports show -org <organizationId> -code <code> [-version N]
+//	reports run -org <organizationId> (-code <code> [-version N] | -file definition.yaml) [-as-of 2024-06-30] [-format text|csv|xlsx|pdf] [-out report.pdf]
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/reports"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openDB(ctx, "cashflow")
+		if err := schema.Ensure(ctx, conn, reports.Tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("report_definitions is up to date")
+	case "validate":
+		runValidate(args)
+	case "save":
+		runSave(ctx, args)
+	case "list":
+		runList(ctx, args)
+	case "versions":
+		runVersions(ctx, args)
+	case "show":
+		runShow(ctx, args)
+	case "run":
+		runRun(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: reports migrate|validate|save|list|versions|show|run [flags]")
+	os.Exit(2)
+}
+
+func runValidate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	file := fs.String("file", "", "definition file (.yaml or .json)")
+	fs.Parse(args)
+	if *file == "" {
+		log.Fatal("validate: -file is required")
+	}
+
+	d, err := readDefinition(*file)
+	if err != nil {
+		log.Fatalf("validate: %v", err)
+	}
+	fmt.Printf("%s (%s): %d columns, %d rows\n", d.Code, d.Title, len(d.Columns), len(d.Rows))
+}
+
+func runSave(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("save", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	file := fs.String("file", "", "definition file (.yaml or .json)")
+	by := fs.String("by", os.Getenv("USER"), "who saved it")
+	fs.Parse(args)
+	if *org == "" || *file == "" {
+		log.Fatal("save: -org and -file are required")
+	}
+	source, err := os.ReadFile(*file)
+	if err != nil {
+		log.Fatalf("save: %v", err)
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+	var s *reports.Stored
+	err = db.InTx(ctx, conn, func(tx *sql.Tx) error {
+		var err error
+		s, err = reports.Save(ctx, tx, *org, string(source), reports.FormatOf(*file), *by)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("save: %v", err)
+	}
+	fmt.Printf("Report %s saved as version %d\n", s.Code, s.Version)
+}
+
+func runList(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("list: -org is required")
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+	list, err := reports.List(ctx, conn, *org)
+	if err != nil {
+		log.Fatalf("list: %v", err)
+	}
+	if len(list) == 0 {
+		fmt.Println("No report definitions")
+		return
+	}
+	printStored(list)
+}
+
+func runVersions(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("versions", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	code := fs.String("code", "", "report code")
+	fs.Parse(args)
+	if *org == "" || *code == "" {
+		log.Fatal("versions: -org and -code are required")
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+	list, err := reports.Versions(ctx, conn, *org, *code)
+	if err != nil {
+		log.Fatalf("versions: %v", err)
+	}
+	printStored(list)
+}
+
+func printStored(list []*reports.Stored) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CODE\tVERSION\tTITLE\tFORMAT\tSAVED\tBY")
+	for _, s := range list {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", s.Code, s.Version, s.Title, s.Format,
+			s.CreatedAt.Format("2006-01-02 15:04"), s.CreatedBy)
+	}
+	w.Flush()
+}
+
+func runShow(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	code := fs.String("code", "", "report code")
+	version := fs.Int("version", 0, "version (default: latest)")
+	fs.Parse(args)
+	if *org == "" || *code == "" {
+		log.Fatal("show: -org and -code are required")
+	}
+
+	conn := openDB(ctx, "cashflow")
+	defer conn.Close()
+	s, err := reports.Get(ctx, conn, *org, *code, *version)
+	if err != nil {
+		log.Fatalf("show: %v", err)
+	}
+	fmt.Print(s.Source)
+}
+
+func runRun(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	code := fs.String("code", "", "stored report code")
+	version := fs.Int("version", 0, "stored version (default: latest)")
+	file := fs.String("file", "", "run a definition file instead of a stored report")
+	asOf := fs.String("as-of", time.Now().Format(time.DateOnly), "report date (YYYY-MM-DD)")
+	format := fs.String("format", reports.OutputText, "output: text, csv, xlsx or pdf")
+	out := fs.String("out", "", "output file (required for xlsx and pdf)")
+	fs.Parse(args)
+	if *org == "" || (*code == "") == (*file == "") {
+		log.Fatal("run: -org and one of -code or -file are required")
+	}
+	if *out == "" && (*format == reports.OutputXLSX || *format == reports.OutputPDF) {
+		log.Fatalf("run: -out is required for %s", *format)
+	}
+	day, err := parseDay(*asOf)
+	if err != nil {
+		log.Fatalf("run: -as-of: %v", err)
+	}
+
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+
+	var d *reports.Definition
+	stored := 0
+	if *file != "" {
+		d, err = readDefinition(*file)
+	} else {
+		var s *reports.Stored
+		if s, err = reports.Get(ctx, cf, *org, *code, *version); err == nil {
+			d, err = s.Definition()
+			stored = s.Version
+		}
+	}
+	if err != nil {
+		log.Fatalf("run: %v", err)
+	}
+
+	t, err := reports.Evaluate(ctx, cf, oadb, *org, d, day)
+	if err != nil {
+		log.Fatalf("run: %v", err)
+	}
+	t.Version = stored
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("run: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := t.Render(w, *format); err != nil {
+		log.Fatalf("run: %v", err)
+	}
+	if *out != "" {
+		fmt.Printf("Report %s written to %s\n", d.Code, *out)
+	}
+}
+
+func readDefinition(path string) (*reports.Definition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return reports.Parse(data, reports.FormatOf(path))
+}
+
+func parseDay(s string) (time.Time, error) {
+	return time.ParseInLocation(time.DateOnly, s, time.Local)
+}
+
+func openDB(ctx context.Context, name string) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	dsn := cfg.CashflowDSN
+	if name == "oa" {
+		dsn = cfg.OADSN
+	}
+	conn, err := db.Open(ctx, name, dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
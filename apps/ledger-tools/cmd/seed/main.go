@@ -0,0 +1,177 @@
+// Command seed writes named, deterministic development datasets to the OA
+// and cashflow databases, replacing the BFF's prisma/seed*.js scripts.
+// apply removes whatever a previous apply of the dataset left and writes it
+// again, so it can be rerun at will; remove takes it out.
+//
+// Usage:
+//
+//	seed list
+//	seed apply -dataset demo-retail [-through 2026-09-30]
+//	seed remove -dataset demo-retail
+//	seed ids -dataset demo-retail
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/seed"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "list":
+		runList()
+	case "apply":
+		runApply(ctx, args)
+	case "remove":
+		runRemove(ctx, args)
+	case "ids":
+		runIDs(args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: seed list|apply|remove|ids [flags]")
+	os.Exit(2)
+}
+
+func runList() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATASET\tDESCRIPTION")
+	for _, d := range seed.Datasets() {
+		fmt.Fprintf(w, "%s\t%s\n", d.Name, d.Description)
+	}
+	w.Flush()
+}
+
+func runApply(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	name := fs.String("dataset", "", "dataset to write (see seed list)")
+	through := fs.String("through", "", "last day of history, YYYY-MM-DD (default: end of last month)")
+	force := fs.Bool("force", false, "allow seeding when NODE_ENV=production")
+	fs.Parse(args)
+	s := build("apply", *name, *through)
+	guard("apply", *force)
+
+	oadb, cf := openDB(ctx, "oa"), openDB(ctx, "cashflow")
+	defer oadb.Close()
+	defer cf.Close()
+	if err := s.Apply(ctx, cf, oadb); err != nil {
+		log.Fatalf("apply: %v", err)
+	}
+
+	counts := s.Counts()
+	tables := make([]string, 0, len(counts))
+	for t := range counts {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tROWS")
+	for _, t := range tables {
+		fmt.Fprintf(w, "%s\t%d\n", t, counts[t])
+	}
+	w.Flush()
+	fmt.Printf("Seeded %s through %s\n\n", s.Dataset.Name, s.Through.Format("2006-01-02"))
+	printIDs(s)
+}
+
+func runRemove(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("remove", flag.ExitOnError)
+	name := fs.String("dataset", "", "dataset to remove")
+	force := fs.Bool("force", false, "allow removing when NODE_ENV=production")
+	fs.Parse(args)
+	s := build("remove", *name, "")
+	guard("remove", *force)
+
+	oadb, cf := openDB(ctx, "oa"), openDB(ctx, "cashflow")
+	defer oadb.Close()
+	defer cf.Close()
+	if err := s.Remove(ctx, cf, oadb); err != nil {
+		log.Fatalf("remove: %v", err)
+	}
+	fmt.Printf("Removed %s (organization %s, OA org %s)\n", s.Dataset.Name, s.OrganizationID, s.OAOrgID)
+}
+
+func runIDs(args []string) {
+	fs := flag.NewFlagSet("ids", flag.ExitOnError)
+	name := fs.String("dataset", "", "dataset to describe")
+	fs.Parse(args)
+	printIDs(build("ids", *name, ""))
+}
+
+// build generates a dataset. Ids do not depend on the through date, so
+// remove and ids build with the default.
+func build(cmd, name, through string) *seed.Seed {
+	if name == "" {
+		log.Fatalf("%s: -dataset is required", cmd)
+	}
+	d, err := seed.Get(name)
+	if err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+	now := time.Now().UTC()
+	day := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+	if through != "" {
+		day, err = time.Parse("2006-01-02", through)
+		if err != nil {
+			log.Fatalf("%s: -through: %v", cmd, err)
+		}
+	}
+	return d.Build(day)
+}
+
+// guard refuses to touch a production environment without -force.
+func guard(cmd string, force bool) {
+	if os.Getenv("NODE_ENV") == "production" && !force {
+		log.Fatalf("%s: NODE_ENV is production; pass -force to seed anyway", cmd)
+	}
+}
+
+func printIDs(s *seed.Seed) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tCASHFLOW ID\tOA ID")
+	fmt.Fprintf(w, "organization\t%s\t%s\t%s\n", s.Dataset.Name, s.OrganizationID, s.OAOrgID)
+	for _, u := range s.Users {
+		fmt.Fprintf(w, "user\t%s (%s)\t%s\t%s\n", u.Email, u.Role, u.ID, u.OAID)
+	}
+	for _, a := range s.Accounts {
+		fmt.Fprintf(w, "account\t%s %s\t%s\t%s\n", a.Code, a.Name, a.ID, a.OAID)
+	}
+	w.Flush()
+}
+
+func openDB(ctx context.Context, name string) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	dsn := cfg.CashflowDSN
+	if name == "oa" {
+		dsn = cfg.OADSN
+	}
+	conn, err := db.Open(ctx, name, dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
@@ -0,0 +1,286 @@
+// Command snapshots maintains per-account, per-day running balances of the
+// OA ledger for dashboards and answers balance-as-of and period-movement
+// queries from them.
+//
+// Usage:
+//
+//	snapshots migrate
+//	snapshots run [-interval 1m] [-listen :9103] [-org <organizationId>,...]
+//	snapshots sync -org <organizationId>
+//	snapshots rebuild -org <organizationId>
+//	snapshots asof -org <organizationId> -date 2025-09-30 [-account <oaAccountId>]
+//	snapshots movement -org <organizationId> -from 2025-09-01 -to 2025-09-30
+//	snapshots check -org <organizationId> [-sync=false]
+//
+// check exits 1 when the snapshots disagree with the splits.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/snapshots"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openDB(ctx, "cashflow")
+		if err := schema.Ensure(ctx, conn, snapshots.Tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("balance_snapshots and balance_snapshot_state are up to date")
+	case "run":
+		runService(ctx, args)
+	case "sync", "rebuild":
+		runSync(ctx, cmd, args)
+	case "asof":
+		runAsOf(ctx, args)
+	case "movement":
+		runMovement(ctx, args)
+	case "check":
+		runCheck(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: snapshots migrate|run|sync|rebuild|asof|movement|check [flags]")
+	os.Exit(2)
+}
+
+func runService(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	interval := fs.Duration("interval", time.Minute, "how often every organization is synced")
+	listen := fs.String("listen", ":9103", "address of the query and change-notification API; empty to disable")
+	orgs := fs.String("org", "", "comma-separated organization ids (default: every organization linked to OA)")
+	fs.Parse(args)
+
+	s := &snapshots.Service{
+		DB: openDB(ctx, "cashflow"), OA: openDB(ctx, "oa"),
+		Interval: *interval,
+		Logger:   log.New(os.Stdout, "snapshots ", log.LstdFlags),
+	}
+	defer s.DB.Close()
+	defer s.OA.Close()
+	if *orgs != "" {
+		s.Organizations = strings.Split(*orgs, ",")
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if *listen != "" {
+		srv := &http.Server{Addr: *listen, Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				s.Logger.Printf("listen: %v", err)
+			}
+		}()
+		defer srv.Close()
+		s.Logger.Printf("serving balances on %s", *listen)
+	}
+	s.Logger.Printf("syncing every %s", *interval)
+	if err := s.Run(ctx); err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+}
+
+func runSync(ctx context.Context, cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatalf("%s: -org is required", cmd)
+	}
+
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+
+	sync := snapshots.Sync
+	if cmd == "rebuild" {
+		sync = snapshots.Rebuild
+	}
+	res, err := sync(ctx, cf, oadb, *org, time.Now())
+	if err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+	if res.Rebuilt {
+		fmt.Printf("Rebuilt %d account-days from %d splits\n", res.Days, res.Splits)
+		return
+	}
+	fmt.Printf("Recounted %d account-days from %d splits; %d changed\n", res.Days, res.Splits, res.Changed)
+}
+
+func runAsOf(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("asof", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	date := fs.String("date", time.Now().Format(snapshots.DayLayout), "day whose closing balances to show")
+	account := fs.String("account", "", "only this OA account id")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("asof: -org is required")
+	}
+	if _, err := time.Parse(snapshots.DayLayout, *date); err != nil {
+		log.Fatalf("asof: -date: %v", err)
+	}
+
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+
+	var balances []snapshots.Balance
+	var err error
+	if *account != "" {
+		id := oa.NormalizeID(*account)
+		var balance int64
+		balance, err = snapshots.AccountBalance(ctx, cf, *org, id, *date)
+		balances = []snapshots.Balance{{AccountID: id, Balance: balance}}
+	} else {
+		balances, err = snapshots.AsOf(ctx, cf, *org, *date)
+	}
+	if err != nil {
+		log.Fatalf("asof: %v", err)
+	}
+
+	chart := loadChart(ctx, cf, oadb, *org)
+	rows := make([][]string, 0, len(balances))
+	for _, b := range balances {
+		rows = append(rows, []string{chart.FullName(b.AccountID), b.AccountID, amount(chart, b.AccountID, b.Balance)})
+	}
+	printRows("ACCOUNT\tID\tBALANCE", rows)
+}
+
+func runMovement(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("movement", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	from := fs.String("from", "", "first day of the period")
+	to := fs.String("to", "", "last day of the period")
+	fs.Parse(args)
+	if *org == "" || *from == "" || *to == "" {
+		log.Fatal("movement: -org, -from and -to are required")
+	}
+
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+
+	movements, err := snapshots.Movements(ctx, cf, *org, *from, *to)
+	if err != nil {
+		log.Fatalf("movement: %v", err)
+	}
+	chart := loadChart(ctx, cf, oadb, *org)
+	rows := make([][]string, 0, len(movements))
+	for _, m := range movements {
+		rows = append(rows, []string{chart.FullName(m.AccountID), amount(chart, m.AccountID, m.Opening),
+			amount(chart, m.AccountID, m.Movement), amount(chart, m.AccountID, m.Closing)})
+	}
+	printRows("ACCOUNT\tOPENING\tMOVEMENT\tCLOSING", rows)
+}
+
+func runCheck(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	syncFirst := fs.Bool("sync", true, "sync before checking, so recent changes are not reported")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("check: -org is required")
+	}
+
+	cf, oadb := openDB(ctx, "cashflow"), openDB(ctx, "oa")
+	defer cf.Close()
+	defer oadb.Close()
+
+	if *syncFirst {
+		if _, err := snapshots.Sync(ctx, cf, oadb, *org, time.Now()); err != nil {
+			log.Fatalf("check: sync: %v", err)
+		}
+	}
+	report, err := snapshots.Check(ctx, cf, oadb, *org, time.Now())
+	if err != nil {
+		log.Fatalf("check: %v", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	if len(report.Mismatches) > 0 {
+		os.Exit(1)
+	}
+}
+
+// loadChart loads the organization's OA accounts for names and precision.
+func loadChart(ctx context.Context, cf, oadb *sql.DB, organizationID string) *oa.Chart {
+	orgID, err := oa.OrgForOrganization(ctx, cf, organizationID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	chart, err := oa.LoadChart(ctx, oadb, orgID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return chart
+}
+
+func amount(chart *oa.Chart, accountID string, minor int64) string {
+	precision := 2
+	if a := chart.Accounts[accountID]; a != nil {
+		precision = a.Precision
+	}
+	return money.FromMinor(minor, precision).String()
+}
+
+// printRows prints rows sorted by their first column.
+func printRows(header string, rows [][]string) {
+	if len(rows) == 0 {
+		fmt.Println("No balances")
+		return
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, header)
+	for _, r := range rows {
+		fmt.Fprintln(w, strings.Join(r, "\t"))
+	}
+	w.Flush()
+}
+
+func openDB(ctx context.Context, name string) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	dsn := cfg.CashflowDSN
+	if name == "oa" {
+		dsn = cfg.OADSN
+	}
+	conn, err := db.Open(ctx, name, dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
@@ -0,0 +1,337 @@
+// Command stockrecon reconciles inventory to the Inventory GL by item,
+// warehouse and source document, matching each inventory movement to its
+// journal through inventory_movements.journalId.
+//
+// Usage:
+//
+//	stockrecon migrate
+//	stockrecon check -org <organizationId> [-as-of 2025-03-31] [-tolerance 0.01] [-limit 20] [-save] [-json]
+//	stockrecon watch -org <organizationId>[,<organizationId>...] [-interval 1h] [-tolerance 0.01]
+//	stockrecon history -org <organizationId> [-limit 20]
+//
+// check exits 1 when something other than timing or rounding is left. watch
+// reconciles every interval, saves each run and logs when a result changes.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/stockrecon"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openCashflow(ctx)
+		if err := schema.Ensure(ctx, conn, stockrecon.Tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("stockrecon_runs is up to date")
+	case "check":
+		runCheck(ctx, args)
+	case "watch":
+		runWatch(ctx, args)
+	case "history":
+		runHistory(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: stockrecon migrate|check|watch|history [flags]")
+	os.Exit(2)
+}
+
+func runCheck(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	asOf := fs.String("as-of", "", "reconcile up to the end of this date (default: now, which also checks the layers)")
+	tolerance := fs.String("tolerance", stockrecon.DefaultTolerance.String(), "per-journal difference treated as rounding")
+	limit := fs.Int("limit", 20, "maximum rows per list")
+	save := fs.Bool("save", false, "record the run in stockrecon_runs")
+	asJSON := fs.Bool("json", false, "print the full report as JSON")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("check: -org is required")
+	}
+	p := stockrecon.Params{OrganizationID: *org, Layers: *asOf == "", Tolerance: parseTolerance("check", *tolerance)}
+	if *asOf != "" {
+		// cashflowdb DATETIMEs are read and compared as UTC, like the
+		// date flags of the other commands.
+		day, err := time.Parse(time.DateOnly, *asOf)
+		if err != nil {
+			log.Fatalf("check: -as-of: %v", err)
+		}
+		p.AsOf = day.AddDate(0, 0, 1).Add(-time.Millisecond)
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	r, err := stockrecon.Reconcile(ctx, conn, p, time.Now())
+	if err != nil {
+		log.Fatalf("check: %v", err)
+	}
+	if *save {
+		if _, err := stockrecon.Save(ctx, conn, r); err != nil {
+			log.Fatalf("check: save: %v", err)
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(r)
+	} else {
+		printReport(r, *limit)
+	}
+	if !r.Summary.Reconciled {
+		os.Exit(1)
+	}
+}
+
+func printReport(r *stockrecon.Report, limit int) {
+	s := r.Summary
+	var accounts []string
+	for _, a := range r.Accounts {
+		accounts = append(accounts, a.Code+" "+a.Name)
+	}
+	fmt.Printf("Inventory as of %s (accounts: %s)\n\n", r.AsOf.Format("2006-01-02 15:04"), strings.Join(accounts, ", "))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	if r.LayersChecked {
+		fmt.Fprintf(w, "FIFO layers\t%s\t\n", r.LayerValue)
+	}
+	fmt.Fprintf(w, "Movements\t%s\t\n", r.MovementValue)
+	fmt.Fprintf(w, "Ledger\t%s\t\n", r.LedgerValue)
+	if r.LayersChecked {
+		fmt.Fprintf(w, "Layers - ledger\t%s\t\n", s.LayerDifference)
+	}
+	fmt.Fprintf(w, "Movements - ledger\t%s\t\n", s.Difference)
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CAUSE\tCOUNT\tAMOUNT")
+	if r.LayersChecked {
+		fmt.Fprintf(w, "layers without movements\t%d\t%s\n", len(r.Drift), s.Drift)
+	}
+	fmt.Fprintf(w, "movements without journal\t%d\t%s\n", len(r.Unlinked), s.Unlinked)
+	fmt.Fprintf(w, "journals without movements\t%d\t%s\n", len(r.NoMovements), s.NoMovements)
+	fmt.Fprintf(w, "value mismatches\t%d\t%s\n", len(r.Mismatches), s.Mismatches)
+	fmt.Fprintf(w, "dated across the cut-off\t%d\t%s\n", len(r.Timing), s.Timing)
+	fmt.Fprintf(w, "rounding\t\t%s\n", s.Rounding)
+	w.Flush()
+
+	printAttribution("ITEM", r.Items, limit)
+	printAttribution("WAREHOUSE", r.Warehouses, limit)
+	printAttribution("SOURCE", r.Sources, limit)
+
+	if len(r.Unlinked) > 0 {
+		fmt.Println("\nMovements without journal:")
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DATE\tITEM\tWAREHOUSE\tTYPE\tSOURCE\tVALUE\tREASON")
+		for i, m := range r.Unlinked {
+			if i == limit {
+				fmt.Fprintf(w, "... %d more\n", len(r.Unlinked)-limit)
+				break
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s %s\t%s\t%s\n", m.Date.Format("2006-01-02 15:04"), m.Item, m.Warehouse,
+				m.Type, m.SourceType, m.SourceID, m.Value, m.Reason)
+		}
+		w.Flush()
+	}
+	printJournals("Journals without movements:", r.NoMovements, limit)
+	printJournals("Value mismatches:", r.Mismatches, limit)
+	printJournals("Dated across the cut-off:", r.Timing, limit)
+	if len(r.Drift) > 0 {
+		fmt.Println("\nLayers without movements:")
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ITEM\tSKU\tWAREHOUSE\tLAYERS\tMOVEMENTS\tDIFFERENCE")
+		for i, d := range r.Drift {
+			if i == limit {
+				fmt.Fprintf(w, "... %d more\n", len(r.Drift)-limit)
+				break
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", d.Item, d.SKU, d.Warehouse, d.LayerValue, d.MovementValue, d.Difference)
+		}
+		w.Flush()
+	}
+
+	if s.Reconciled {
+		fmt.Println("\nReconciled")
+	}
+}
+
+func printAttribution(title string, rows []*stockrecon.Attribution, limit int) {
+	if len(rows) == 0 {
+		return
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tLEDGER\tLAYERS\tTOTAL\n", title)
+	for i, a := range rows {
+		if i == limit {
+			fmt.Fprintf(w, "... %d more\n", len(rows)-limit)
+			break
+		}
+		name := a.Name
+		if a.Key == "" {
+			name = "(several)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, a.Ledger, a.Layers, a.Total())
+	}
+	w.Flush()
+}
+
+func printJournals(title string, rows []*stockrecon.JournalDiff, limit int) {
+	if len(rows) == 0 {
+		return
+	}
+	fmt.Println("\n" + title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOURNAL\tDATE\tACCOUNT\tMOVEMENTS\tMOVEMENT VALUE\tLEDGER\tDIFFERENCE\tSOURCE\tITEMS")
+	for i, d := range rows {
+		if i == limit {
+			fmt.Fprintf(w, "... %d more\n", len(rows)-limit)
+			break
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", d.Number, d.Date.Format(time.DateOnly), d.Account,
+			d.Movements, d.MovementValue, d.LedgerValue, d.Difference, d.Source, strings.Join(d.Items, ", "))
+	}
+	w.Flush()
+}
+
+func runWatch(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	orgs := fs.String("org", "", "comma-separated organization ids")
+	interval := fs.Duration("interval", time.Hour, "time between reconciliations")
+	tolerance := fs.String("tolerance", stockrecon.DefaultTolerance.String(), "per-journal difference treated as rounding")
+	fs.Parse(args)
+	if *orgs == "" {
+		log.Fatal("watch: -org is required")
+	}
+	tol := parseTolerance("watch", *tolerance)
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	conn := openCashflow(ctx)
+	defer conn.Close()
+	logger := log.New(os.Stdout, "stockrecon ", log.LstdFlags)
+
+	last := map[string]stockrecon.Summary{}
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		for _, org := range strings.Split(*orgs, ",") {
+			org = strings.TrimSpace(org)
+			r, err := stockrecon.Reconcile(ctx, conn, stockrecon.Params{OrganizationID: org, Layers: true, Tolerance: tol}, time.Now())
+			if err == nil {
+				_, err = stockrecon.Save(ctx, conn, r)
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				logger.Printf("%s: %v", org, err)
+				continue
+			}
+			s := r.Summary
+			prev, seen := last[org]
+			last[org] = s
+			switch {
+			case s.Reconciled && (!seen || !prev.Reconciled):
+				logger.Printf("%s: reconciled (movements - ledger %s, timing %s)", org, s.Difference, s.Timing)
+			case !s.Reconciled && (!seen || prev != s):
+				logger.Printf("%s: NOT reconciled: layers - ledger %s; drift %s (%d), no journal %s (%d), no movements %s (%d), mismatches %s (%d)",
+					org, s.LayerDifference, s.Drift, len(r.Drift), s.Unlinked, len(r.Unlinked),
+					s.NoMovements, len(r.NoMovements), s.Mismatches, len(r.Mismatches))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func runHistory(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	limit := fs.Int("limit", 20, "maximum rows")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("history: -org is required")
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	runs, err := stockrecon.Runs(ctx, conn, *org, *limit)
+	if err != nil {
+		log.Fatalf("history: %v", err)
+	}
+	if len(runs) == 0 {
+		fmt.Println("No runs")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RAN\tAS OF\tRESULT\tLAYERS\tMOVEMENTS\tLEDGER\tDRIFT\tNO JOURNAL\tNO MOVEMENTS\tMISMATCHES\tTIMING")
+	for _, r := range runs {
+		result, layers := "reconciled", "-"
+		if !r.Summary.Reconciled {
+			result = "difference"
+		}
+		if r.LayerValue != nil {
+			layers = r.LayerValue.String()
+		}
+		s := r.Summary
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s (%d)\t%s (%d)\t%s (%d)\t%s (%d)\t%s\n",
+			r.RanAt.Format("2006-01-02 15:04"), r.AsOf.Format("2006-01-02 15:04"), result, layers, r.MovementValue, r.LedgerValue,
+			s.Drift, r.Drift, s.Unlinked, r.Unlinked, s.NoMovements, r.NoMovements, s.Mismatches, r.Mismatches, s.Timing)
+	}
+	w.Flush()
+}
+
+func parseTolerance(cmd, s string) money.Amount {
+	t, err := money.Parse(s)
+	if err != nil || t < 0 {
+		log.Fatalf("%s: -tolerance must be a non-negative amount", cmd)
+	}
+	return t
+}
+
+func openCashflow(ctx context.Context) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	conn, err := db.Open(ctx, "cashflow", cfg.CashflowDSN)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
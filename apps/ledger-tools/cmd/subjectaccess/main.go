@@ -0,0 +1,116 @@
+// Command subjectaccess answers subject access requests: it collects
+// everything linked to one email address in the OA and cashflow databases
+// and writes it as a zip archive of readable JSON with a README.
+//
+// Usage:
+//
+//	subjectaccess find -email person@example.com
+//	subjectaccess export -email person@example.com -out person.zip
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/subjectaccess"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "find":
+		runFind(ctx, args)
+	case "export":
+		runExport(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: subjectaccess find|export [flags]")
+	os.Exit(2)
+}
+
+func runFind(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("find", flag.ExitOnError)
+	email := fs.String("email", "", "email address of the data subject")
+	fs.Parse(args)
+	if *email == "" {
+		log.Fatal("find: -email is required")
+	}
+
+	e := collect(ctx, *email)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATABASE\tTABLE\tRECORDS")
+	for _, s := range e.Sections {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", s.Database, s.Table, len(s.Rows))
+	}
+	w.Flush()
+	fmt.Printf("%d records for %s\n", e.Rows(), e.Email)
+}
+
+func runExport(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	email := fs.String("email", "", "email address of the data subject")
+	out := fs.String("out", "", "archive to write (.zip)")
+	fs.Parse(args)
+	if *email == "" || *out == "" {
+		log.Fatal("export: -email and -out are required")
+	}
+
+	e := collect(ctx, *email)
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		log.Fatalf("export: %v", err)
+	}
+	if err := e.WriteArchive(f); err != nil {
+		f.Close()
+		os.Remove(*out)
+		log.Fatalf("export: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("export: %v", err)
+	}
+	fmt.Printf("%d records for %s written to %s\n", e.Rows(), e.Email, *out)
+}
+
+func collect(ctx context.Context, email string) *subjectaccess.Export {
+	oadb, cf := openDB(ctx, "oa"), openDB(ctx, "cashflow")
+	defer oadb.Close()
+	defer cf.Close()
+	e, err := subjectaccess.Collect(ctx, oadb, cf, email)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return e
+}
+
+func openDB(ctx context.Context, name string) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	dsn := cfg.CashflowDSN
+	if name == "oa" {
+		dsn = cfg.OADSN
+	}
+	conn, err := db.Open(ctx, name, dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
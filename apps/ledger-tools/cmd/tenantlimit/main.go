@@ -0,0 +1,155 @@
+// Command tenantlimit inspects the per-organization query limiter: limits
+// prints the limits a configuration gives an organization, and top shows
+// a running service's per-organization use from its metrics endpoint.
+//
+// Usage:
+//
+//	tenantlimit limits -config tenantlimits.json [-org <organizationId>]
+//	tenantlimit top -url http://localhost:9102/metrics [-watch 5s]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/tenantlimit"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "limits":
+		runLimits(args)
+	case "top":
+		runTop(args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: tenantlimit limits|top [flags]")
+	os.Exit(2)
+}
+
+func runLimits(args []string) {
+	fs := flag.NewFlagSet("limits", flag.ExitOnError)
+	path := fs.String("config", "tenantlimits.json", "limiter configuration: a file with default and tenants")
+	org := fs.String("org", "", "only this organization")
+	fs.Parse(args)
+
+	cfg, err := tenantlimit.LoadConfig(*path)
+	if err != nil {
+		log.Fatalf("limits: %v", err)
+	}
+	tenants := []string{""}
+	if *org != "" {
+		tenants = []string{*org}
+	} else {
+		for id := range cfg.Tenants {
+			tenants = append(tenants, id)
+		}
+		sort.Strings(tenants[1:])
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ORGANIZATION\tCONCURRENT\tINTERACTIVE RESERVE\tQUEUE\tMAX WAIT\tROWS/S\tROW BURST\tROWS/QUERY")
+	for _, id := range tenants {
+		l := cfg.For(id)
+		name := id
+		if name == "" {
+			name = "(default)"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n", name, l.MaxConcurrent, l.InteractiveReserve, l.MaxQueued,
+			time.Duration(l.MaxWait), unlimited(l.RowsPerSecond), unlimited(float64(l.RowBurst)), unlimited(float64(l.MaxRowsPerQuery)))
+	}
+	w.Flush()
+}
+
+func runTop(args []string) {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	endpoint := fs.String("url", "http://localhost:9102/metrics", "metrics endpoint of a tenant limited service")
+	watch := fs.Duration("watch", 0, "refresh at this interval instead of printing once")
+	fs.Parse(args)
+
+	u, err := url.Parse(*endpoint)
+	if err != nil {
+		log.Fatalf("top: -url: %v", err)
+	}
+	q := u.Query()
+	q.Set("format", "json")
+	u.RawQuery = q.Encode()
+
+	for {
+		stats, err := fetch(u.String())
+		if err != nil {
+			log.Fatalf("top: %v", err)
+		}
+		printTop(stats)
+		if *watch <= 0 {
+			return
+		}
+		time.Sleep(*watch)
+		fmt.Println()
+	}
+}
+
+func fetch(u string) ([]tenantlimit.Stats, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	var stats []tenantlimit.Stats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("%s: %w", u, err)
+	}
+	return stats, nil
+}
+
+// printTop lists the busiest organizations first.
+func printTop(stats []tenantlimit.Stats) {
+	sort.SliceStable(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if a.Saturation != b.Saturation {
+			return a.Saturation > b.Saturation
+		}
+		return a.QueuedInteractive+a.QueuedBatch > b.QueuedInteractive+b.QueuedBatch
+	})
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ORGANIZATION\tIN FLIGHT\tSATURATION\tQUEUED (I/B)\tQUERIES\tROWS\tROW BUDGET\tREJECTED\tTIMED OUT\tROW LIMITED\tWAITED")
+	for _, s := range stats {
+		budget := fmt.Sprintf("%.0f", s.RowBudget)
+		if s.RowBudgetExhausted {
+			budget += " (exhausted)"
+		}
+		fmt.Fprintf(w, "%s\t%d/%d\t%.0f%%\t%d/%d\t%d\t%d\t%s\t%d\t%d\t%d\t%s\n", s.Tenant, s.InFlight, s.MaxConcurrent,
+			s.Saturation*100, s.QueuedInteractive, s.QueuedBatch, s.Queries, s.Rows, budget, s.Rejected, s.TimedOut,
+			s.RowLimited, time.Duration(s.WaitSeconds*float64(time.Second)).Round(time.Millisecond))
+	}
+	w.Flush()
+}
+
+func unlimited(v float64) string {
+	if v <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%g", v)
+}
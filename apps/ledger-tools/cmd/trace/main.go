@@ -0,0 +1,125 @@
+// Command trace shows everything tied to one correlation id: the BFF audit
+// log rows for the request, the Go services' job log lines and the OA
+// transactions stamped with it, in time order.
+//
+// Usage:
+//
+//	trace migrate
+//	trace show -id <X-Request-ID> [-since 2024-05-01] [-oa=false] [-json]
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/correlation"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openDB(ctx, "cashflow")
+		if err := schema.Ensure(ctx, conn, correlation.Tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("job_logs is up to date")
+	case "show":
+		runShow(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: trace migrate|show [flags]")
+	os.Exit(2)
+}
+
+func runShow(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	id := fs.String("id", "", "correlation id (X-Request-ID)")
+	sinceFlag := fs.String("since", "", "only look at records from this date (YYYY-MM-DD, default 30 days ago)")
+	withOA := fs.Bool("oa", true, "include OA postings")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	fs.Parse(args)
+	if !correlation.Valid(*id) {
+		log.Fatal("show: -id is required")
+	}
+	since := time.Now().AddDate(0, 0, -30)
+	if *sinceFlag != "" {
+		t, err := time.Parse(time.DateOnly, *sinceFlag)
+		if err != nil {
+			log.Fatalf("show: -since: %v", err)
+		}
+		since = t
+	}
+
+	cf := openDB(ctx, "cashflow")
+	defer cf.Close()
+	var oadb cashflow.Querier
+	if *withOA {
+		conn := openDB(ctx, "oa")
+		defer conn.Close()
+		oadb = conn
+	}
+	entries, err := correlation.Trace(ctx, cf, oadb, *id, since)
+	if err != nil {
+		log.Fatalf("show: %v", err)
+	}
+
+	if *asJSON {
+		if entries == nil {
+			entries = []*correlation.Entry{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entries); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Printf("Nothing recorded for %s since %s\n", *id, since.Format(time.DateOnly))
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tSOURCE\tREF\tDETAIL")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.At.Local().Format("2006-01-02 15:04:05.000"), e.Source, e.Ref, e.Detail)
+	}
+	w.Flush()
+}
+
+func openDB(ctx context.Context, name string) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	dsn := cfg.CashflowDSN
+	if name == "oa" {
+		dsn = cfg.OADSN
+	}
+	conn, err := db.Open(ctx, name, dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
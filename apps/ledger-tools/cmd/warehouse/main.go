@@ -0,0 +1,261 @@
+// Command warehouse checks what depends on a warehouse before it is
+// deleted, recommends deleting, deactivating or merging it, and merges one
+// warehouse's stock and history into another.
+//
+// Usage:
+//
+//	warehouse migrate
+//	warehouse analyze -org <organizationId> -id <warehouseId> [-json]
+//	warehouse merge -org <organizationId> -from <warehouseId> -into <warehouseId> -reason "Closing the Yangon annex" [-by <user>] [-apply]
+//	warehouse merges -org <organizationId>
+//
+// merge only rehearses the merge and rolls it back unless -apply is given.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/warehouse"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "migrate":
+		conn := openCashflow(ctx)
+		if err := schema.Ensure(ctx, conn, warehouse.Tables); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		fmt.Println("warehouse_merges is up to date")
+	case "analyze":
+		runAnalyze(ctx, args)
+	case "merge":
+		runMerge(ctx, args)
+	case "merges":
+		runMerges(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: warehouse migrate|analyze|merge|merges [flags]")
+	os.Exit(2)
+}
+
+func runAnalyze(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	id := fs.String("id", "", "warehouse id")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args)
+	if *org == "" || *id == "" {
+		log.Fatal("analyze: -org and -id are required")
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	a, err := warehouse.Analyze(ctx, conn, *org, *id)
+	if err != nil {
+		log.Fatalf("analyze: %v", err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(a)
+		return
+	}
+
+	wh := a.Warehouse
+	var flags []string
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{{wh.IsDefault, "default"}, {wh.IsPrimary, "primary"}, {!wh.IsActive, "inactive"}} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	fmt.Printf("%s (%s)", wh.Name, wh.ID)
+	if len(flags) > 0 {
+		fmt.Printf(" [%s]", strings.Join(flags, ", "))
+	}
+	fmt.Print("\n\n")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DEPENDENCY\tCOUNT\tDETAIL")
+	fmt.Fprintf(w, "stock\t%d items\t%d layers, %s\n", len(a.Stock), layers(a), a.StockValue)
+	fmt.Fprintf(w, "consumed layers\t%d\t\n", a.EmptyLayers)
+	movements := ""
+	if a.Movements.First != nil {
+		movements = fmt.Sprintf("%d posted, %s to %s", a.Movements.Posted,
+			a.Movements.First.Format(time.DateOnly), a.Movements.Last.Format(time.DateOnly))
+	}
+	fmt.Fprintf(w, "movements\t%d\t%s\n", a.Movements.Count, movements)
+	fmt.Fprintf(w, "opening balances\t%d\t\n", a.OpeningBalances)
+	fmt.Fprintf(w, "open transfers\t%d\t\n", len(a.OpenTransfers))
+	fmt.Fprintf(w, "closed transfers\t%d\t\n", a.ClosedTransfers)
+	fmt.Fprintf(w, "permissions\t%d\t%s\n", len(a.Permissions), users(a))
+	fmt.Fprintf(w, "invoices\t%d\t%d open %s\n", a.Invoices.Count, a.Invoices.Open, strings.Join(a.Invoices.Examples, " "))
+	w.Flush()
+
+	if len(a.Stock) > 0 {
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ITEM\tSKU\tLAYERS\tQUANTITY\tVALUE")
+		for _, s := range a.Stock {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%g\t%s\n", s.Name, s.SKU, s.Layers, s.Quantity, s.Value)
+		}
+		w.Flush()
+	}
+	if len(a.OpenTransfers) > 0 {
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TRANSFER\tDIRECTION\tOTHER WAREHOUSE\tSTATUS\tDATE")
+		for _, t := range a.OpenTransfers {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", t.Number, t.Direction, t.Other, t.Status, t.Date.Format(time.DateOnly))
+		}
+		w.Flush()
+	}
+
+	r := a.Recommendation
+	fmt.Printf("\nRecommendation: %s\n", r.Action)
+	for _, reason := range r.Reasons {
+		fmt.Printf("  - %s\n", reason)
+	}
+	if len(r.Blockers) > 0 {
+		fmt.Println("First:")
+		for _, b := range r.Blockers {
+			fmt.Printf("  - %s\n", b)
+		}
+	}
+}
+
+func layers(a *warehouse.Analysis) int {
+	n := 0
+	for _, s := range a.Stock {
+		n += s.Layers
+	}
+	return n
+}
+
+func users(a *warehouse.Analysis) string {
+	seen := map[string]bool{}
+	var out []string
+	for _, p := range a.Permissions {
+		name := p.Email
+		if name == "" {
+			name = p.UserID
+		}
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func runMerge(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	p := warehouse.MergeParams{}
+	fs.StringVar(&p.OrganizationID, "org", "", "organization id")
+	fs.StringVar(&p.SourceID, "from", "", "warehouse to merge and deactivate")
+	fs.StringVar(&p.TargetID, "into", "", "warehouse receiving the stock and history")
+	fs.StringVar(&p.Reason, "reason", "", "why; kept with the merge and the ledger lock bypass log")
+	fs.StringVar(&p.By, "by", os.Getenv("USER"), "who is merging")
+	apply := fs.Bool("apply", false, "commit the merge (default: rehearse and roll back)")
+	fs.Parse(args)
+	if p.OrganizationID == "" || p.SourceID == "" || p.TargetID == "" || p.Reason == "" {
+		log.Fatal("merge: -org, -from, -into and -reason are required")
+	}
+	p.DryRun = !*apply
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	m, err := warehouse.MergeWarehouses(ctx, conn, p, time.Now())
+	if err != nil {
+		log.Fatalf("merge: %v", err)
+	}
+	s := m.Summary
+	if m.DryRun {
+		fmt.Printf("Dry run: merging %s into %s would\n", m.Source.Name, m.Target.Name)
+	} else {
+		fmt.Printf("Merged %s into %s (%s); %s is now inactive\n", m.Source.Name, m.Target.Name, m.ID, m.Source.Name)
+	}
+	if m.TransferNumber != "" {
+		fmt.Printf("  transfer %d items, %g units worth %s in %d layers (%s)\n", s.Items, s.Quantity, s.Value, s.Layers, m.TransferNumber)
+	}
+	fmt.Printf("  re-point %d movements, %d layers, %d opening balances and %d invoices\n",
+		s.Movements, s.EmptyLayers, s.OpeningBalances, s.Invoices)
+	if s.OpeningBalancesKept > 0 {
+		fmt.Printf("  keep %d opening balances on %s: %s already has them for those items\n",
+			s.OpeningBalancesKept, m.Source.Name, m.Target.Name)
+	}
+	fmt.Printf("  copy %d permissions\n", s.Permissions)
+	if s.DefaultMoved {
+		fmt.Printf("  make %s the default warehouse\n", m.Target.Name)
+	}
+	if m.DryRun {
+		fmt.Println("Nothing was changed; run again with -apply")
+	}
+}
+
+func runMerges(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("merges", flag.ExitOnError)
+	org := fs.String("org", "", "organization id")
+	fs.Parse(args)
+	if *org == "" {
+		log.Fatal("merges: -org is required")
+	}
+
+	conn := openCashflow(ctx)
+	defer conn.Close()
+
+	merges, err := warehouse.Merges(ctx, conn, *org)
+	if err != nil {
+		log.Fatalf("merges: %v", err)
+	}
+	if len(merges) == 0 {
+		fmt.Println("No merges")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tMERGED\tBY\tFROM\tINTO\tVALUE\tMOVEMENTS\tJOURNAL\tREASON")
+	for _, m := range merges {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", m.ID, m.MergedAt.Format("2006-01-02 15:04"), m.MergedBy,
+			m.Source.Name, m.Target.Name, m.Summary.Value, m.Summary.Movements, m.JournalID, m.Reason)
+	}
+	w.Flush()
+}
+
+func openCashflow(ctx context.Context) *sql.DB {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	conn, err := db.Open(ctx, "cashflow", cfg.CashflowDSN)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
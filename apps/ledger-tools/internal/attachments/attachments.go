@@ -0,0 +1,149 @@
+// Package attachments stores uploaded and received files. Content lives in a
+// content-addressed Store (a directory by default); the attachments table
+// records which organization and document each file belongs to.
+package attachments
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Tables are the cashflowdb tables owned by the attachment store.
+var Tables = []schema.Table{
+	{
+		Name: "attachments",
+		Create: `CREATE TABLE IF NOT EXISTS attachments (
+  id VARCHAR(191) NOT NULL,
+  organization_id VARCHAR(191) NOT NULL,
+  owner_type VARCHAR(50) NOT NULL,
+  owner_id VARCHAR(191) NOT NULL,
+  file_name VARCHAR(500) NOT NULL,
+  content_type VARCHAR(191) NOT NULL,
+  size BIGINT NOT NULL,
+  sha256 CHAR(64) NOT NULL,
+  storage_key VARCHAR(500) NOT NULL,
+  created_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (id),
+  INDEX attachments_owner_idx (owner_type, owner_id),
+  INDEX attachments_org_idx (organization_id, created_at)
+) ENGINE=InnoDB`,
+	},
+}
+
+// Store holds attachment content by key.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// Dir is a Store on the local filesystem (or a mounted bucket).
+type Dir string
+
+// Put writes data under key, atomically. Existing content is kept since keys
+// are content hashes.
+func (d Dir) Put(_ context.Context, key string, data []byte) error {
+	path := filepath.Join(string(d), filepath.FromSlash(key))
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Open reads the content stored under key.
+func (d Dir) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), filepath.FromSlash(key)))
+}
+
+// Attachment is an attachments row.
+type Attachment struct {
+	ID             string
+	OrganizationID string
+	OwnerType      string
+	OwnerID        string
+	FileName       string
+	ContentType    string
+	Size           int64
+	SHA256         string
+	StorageKey     string
+	CreatedAt      time.Time
+}
+
+// Save stores data and records it against its owner.
+func Save(ctx context.Context, q cashflow.Querier, store Store, a *Attachment, data []byte) error {
+	sum := sha256.Sum256(data)
+	a.SHA256 = hex.EncodeToString(sum[:])
+	a.StorageKey = fmt.Sprintf("%s/%s/%s", a.OrganizationID, a.SHA256[:2], a.SHA256)
+	a.Size = int64(len(data))
+	if a.ID == "" {
+		a.ID = cashflow.NewID("att")
+	}
+	if a.ContentType == "" {
+		a.ContentType = "application/octet-stream"
+	}
+	a.CreatedAt = time.Now()
+
+	if err := store.Put(ctx, a.StorageKey, data); err != nil {
+		return fmt.Errorf("store %s: %w", a.FileName, err)
+	}
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO attachments (id, organization_id, owner_type, owner_id, file_name, content_type, size,
+		  sha256, storage_key, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.ID, a.OrganizationID, a.OwnerType, a.OwnerID, a.FileName, a.ContentType, a.Size,
+		a.SHA256, a.StorageKey, a.CreatedAt)
+	return err
+}
+
+// List returns the attachments of one owner, oldest first.
+func List(ctx context.Context, q cashflow.Querier, ownerType, ownerID string) ([]*Attachment, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, organization_id, owner_type, owner_id, file_name, content_type, size, sha256, storage_key, created_at
+		FROM attachments WHERE owner_type = ? AND owner_id = ? ORDER BY created_at, id`, ownerType, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Attachment
+	for rows.Next() {
+		a := &Attachment{}
+		if err := rows.Scan(&a.ID, &a.OrganizationID, &a.OwnerType, &a.OwnerID, &a.FileName, &a.ContentType,
+			&a.Size, &a.SHA256, &a.StorageKey, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// Get loads one attachment.
+func Get(ctx context.Context, q cashflow.Querier, id string) (*Attachment, error) {
+	a := &Attachment{}
+	err := q.QueryRowContext(ctx, `
+		SELECT id, organization_id, owner_type, owner_id, file_name, content_type, size, sha256, storage_key, created_at
+		FROM attachments WHERE id = ?`, id).
+		Scan(&a.ID, &a.OrganizationID, &a.OwnerType, &a.OwnerID, &a.FileName, &a.ContentType,
+			&a.Size, &a.SHA256, &a.StorageKey, &a.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("attachment %s: %w", id, cashflow.ErrNotFound)
+	}
+	return a, err
+}
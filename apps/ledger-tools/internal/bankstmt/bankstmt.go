@@ -0,0 +1,402 @@
+// Package bankstmt imports bank statements into cashflowdb's
+// bank_transactions. Statements come as CSV exports with loosely named
+// columns or as OFX/QFX downloads; each line is stored once per bank account,
+// keyed by the bank's transaction id or a fingerprint of the line.
+package bankstmt
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Statement is a parsed statement file.
+type Statement struct {
+	AccountNumber  string // from the file, when it names the account
+	Currency       string
+	Lines          []Line
+	ClosingBalance *money.Amount
+}
+
+// Line is one statement line. Amount is positive for money in.
+type Line struct {
+	Date        time.Time
+	Description string
+	Reference   string
+	Amount      money.Amount
+	Balance     *money.Amount
+	BankID      string // FITID or bank reference, if the bank gives one
+}
+
+// Key identifies the line within its account: the bank's id when present,
+// otherwise a fingerprint of date, amount, description and balance.
+func (l Line) Key() string {
+	if l.BankID != "" {
+		return l.BankID
+	}
+	balance := ""
+	if l.Balance != nil {
+		balance = l.Balance.String()
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%s|%s",
+		l.Date.Format(time.DateOnly), l.Amount, strings.ToUpper(l.Description), l.Reference, balance)))
+	return "sha:" + hex.EncodeToString(sum[:12])
+}
+
+// Detect reports whether a file looks like a bank statement.
+func Detect(name string, head []byte) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".ofx" || ext == ".qfx" || strings.Contains(strings.ToUpper(string(head)), "<OFX>") {
+		return true
+	}
+	if ext != ".csv" && ext != ".txt" {
+		return false
+	}
+	header := strings.ToLower(firstLine(head))
+	hasDate := strings.Contains(header, "date")
+	hasMoney := strings.Contains(header, "debit") || strings.Contains(header, "withdrawal") ||
+		strings.Contains(header, "deposit") || strings.Contains(header, "balance")
+	return hasDate && hasMoney && !strings.Contains(header, "sku")
+}
+
+// Parse reads a CSV or OFX statement.
+func Parse(name string, r io.Reader) (*Statement, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".ofx" || ext == ".qfx" || strings.Contains(strings.ToUpper(string(raw[:min(len(raw), 4096)])), "<OFX>") {
+		return parseOFX(string(raw))
+	}
+	return parseCSV(raw)
+}
+
+// columns maps the header names banks use to the fields we need.
+var columns = map[string][]string{
+	"date":        {"date", "transaction date", "txn date", "posting date", "value date", "booking date"},
+	"description": {"description", "details", "narrative", "particulars", "memo", "transaction details"},
+	"reference":   {"reference", "ref", "ref no", "cheque no", "check number", "reference no"},
+	"amount":      {"amount", "transaction amount"},
+	"debit":       {"debit", "withdrawal", "withdrawals", "money out", "paid out", "dr"},
+	"credit":      {"credit", "deposit", "deposits", "money in", "paid in", "cr"},
+	"balance":     {"balance", "running balance", "closing balance"},
+	"id":          {"transaction id", "fitid", "bank reference", "id"},
+}
+
+func parseCSV(raw []byte) (*Statement, error) {
+	cr := csv.NewReader(strings.NewReader(strings.TrimPrefix(string(raw), "\ufeff")))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, errors.New("statement has no lines")
+	}
+
+	idx := map[string]int{}
+	for i, h := range records[0] {
+		h = strings.ToLower(strings.TrimSpace(h))
+		for field, names := range columns {
+			if _, done := idx[field]; done {
+				continue
+			}
+			for _, n := range names {
+				if h == n {
+					idx[field] = i
+				}
+			}
+		}
+	}
+	if _, ok := idx["date"]; !ok {
+		return nil, errors.New("no date column")
+	}
+	_, hasAmount := idx["amount"]
+	_, hasDebit := idx["debit"]
+	_, hasCredit := idx["credit"]
+	if !hasAmount && !(hasDebit || hasCredit) {
+		return nil, errors.New("no amount or debit/credit columns")
+	}
+
+	get := func(rec []string, field string) string {
+		i, ok := idx[field]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	st := &Statement{}
+	for n, rec := range records[1:] {
+		line := n + 2
+		if strings.TrimSpace(strings.Join(rec, "")) == "" {
+			continue
+		}
+		date, err := parseDate(get(rec, "date"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		l := Line{
+			Date:        date,
+			Description: get(rec, "description"),
+			Reference:   get(rec, "reference"),
+			BankID:      get(rec, "id"),
+		}
+		if hasAmount {
+			if l.Amount, err = parseAmount(get(rec, "amount")); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		} else {
+			debit, err := parseAmount(get(rec, "debit"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			credit, err := parseAmount(get(rec, "credit"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			l.Amount = credit.Abs() - debit.Abs()
+		}
+		if b := get(rec, "balance"); b != "" {
+			bal, err := parseAmount(b)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			l.Balance = &bal
+			st.ClosingBalance = &bal
+		}
+		if l.Description == "" {
+			l.Description = l.Reference
+		}
+		st.Lines = append(st.Lines, l)
+	}
+	return st, nil
+}
+
+var (
+	ofxTag       = regexp.MustCompile(`(?is)<(\w+)>([^<\r\n]*)`)
+	ofxAccount   = regexp.MustCompile(`(?i)<ACCTID>([^<\r\n]+)`)
+	ofxCurrency  = regexp.MustCompile(`(?i)<CURDEF>([^<\r\n]+)`)
+	ofxLedgerBal = regexp.MustCompile(`(?is)<LEDGERBAL>.*?<BALAMT>([^<\r\n]+)`)
+	ofxTxnStart  = regexp.MustCompile(`<STMTTRN>`)
+)
+
+func parseOFX(raw string) (*Statement, error) {
+	st := &Statement{}
+	if m := ofxAccount.FindStringSubmatch(raw); m != nil {
+		st.AccountNumber = strings.TrimSpace(m[1])
+	}
+	if m := ofxCurrency.FindStringSubmatch(raw); m != nil {
+		st.Currency = strings.TrimSpace(m[1])
+	}
+	if m := ofxLedgerBal.FindStringSubmatch(raw); m != nil {
+		if bal, err := money.Parse(m[1]); err == nil {
+			st.ClosingBalance = &bal
+		}
+	}
+
+	// SGML OFX leaves tags unclosed, so a transaction runs to its closing
+	// tag or the next <STMTTRN>, whichever comes first.
+	upper := strings.ToUpper(raw)
+	starts := ofxTxnStart.FindAllStringIndex(upper, -1)
+	for i, loc := range starts {
+		end := len(raw)
+		if i+1 < len(starts) {
+			end = starts[i+1][0]
+		}
+		if close := strings.Index(upper[loc[1]:end], "</STMTTRN>"); close >= 0 {
+			end = loc[1] + close
+		}
+		fields := map[string]string{}
+		for _, m := range ofxTag.FindAllStringSubmatch(raw[loc[1]:end], -1) {
+			fields[strings.ToUpper(m[1])] = strings.TrimSpace(m[2])
+		}
+
+		posted := fields["DTPOSTED"]
+		if len(posted) < 8 {
+			return nil, fmt.Errorf("transaction %s has no DTPOSTED", fields["FITID"])
+		}
+		date, err := time.Parse("20060102", posted[:8])
+		if err != nil {
+			return nil, fmt.Errorf("transaction %s: %w", fields["FITID"], err)
+		}
+		amount, err := money.Parse(fields["TRNAMT"])
+		if err != nil {
+			return nil, fmt.Errorf("transaction %s: %w", fields["FITID"], err)
+		}
+		desc := fields["NAME"]
+		if memo := fields["MEMO"]; memo != "" {
+			desc = strings.TrimSpace(desc + " " + memo)
+		}
+		st.Lines = append(st.Lines, Line{
+			Date:        date,
+			Description: desc,
+			Reference:   fields["CHECKNUM"],
+			Amount:      amount,
+			BankID:      fields["FITID"],
+		})
+	}
+	if len(st.Lines) == 0 {
+		return nil, errors.New("no <STMTTRN> entries")
+	}
+	return st, nil
+}
+
+// Result summarizes an import.
+type Result struct {
+	BankAccountID  string        `json:"bankAccountId"`
+	Lines          int           `json:"lines"`
+	Inserted       int           `json:"inserted"`
+	Skipped        int           `json:"skipped"` // already imported
+	MoneyIn        money.Amount  `json:"moneyIn"`
+	MoneyOut       money.Amount  `json:"moneyOut"`
+	From           string        `json:"from,omitempty"`
+	To             string        `json:"to,omitempty"`
+	ClosingBalance *money.Amount `json:"closingBalance,omitempty"`
+}
+
+// ResolveAccount finds the bank account a statement belongs to: the given
+// id if any, otherwise the organization's account whose number matches the
+// statement's.
+func ResolveAccount(ctx context.Context, q cashflow.Querier, organizationID, bankAccountID, accountNumber string) (string, error) {
+	var id string
+	var err error
+	switch {
+	case bankAccountID != "":
+		err = q.QueryRowContext(ctx, `SELECT id FROM bank_accounts WHERE id = ? AND organizationId = ?`,
+			bankAccountID, organizationID).Scan(&id)
+	case accountNumber != "":
+		err = q.QueryRowContext(ctx, `
+			SELECT id FROM bank_accounts
+			WHERE organizationId = ? AND isActive = true
+			  AND REPLACE(REPLACE(accountNumber, ' ', ''), '-', '') = ?`,
+			organizationID, strings.NewReplacer(" ", "", "-", "").Replace(accountNumber)).Scan(&id)
+	default:
+		return "", errors.New("statement does not name its account; configure a bank account id")
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("bank account %s%s: %w", bankAccountID, accountNumber, cashflow.ErrNotFound)
+	}
+	return id, err
+}
+
+// Import stores the statement's lines as bank_transactions of one account
+// in a single transaction. Lines already stored, matched on bankReference,
+// are skipped, so overlapping statements can be dropped in safely.
+func Import(ctx context.Context, conn *sql.DB, organizationID, bankAccountID string, st *Statement) (*Result, error) {
+	res := &Result{BankAccountID: bankAccountID, Lines: len(st.Lines), ClosingBalance: st.ClosingBalance}
+	err := db.InTx(ctx, conn, func(tx *sql.Tx) error {
+		var running money.Amount
+		if err := tx.QueryRowContext(ctx, `
+			SELECT COALESCE((SELECT runningBalance FROM bank_transactions WHERE bankAccountId = ?
+			                 ORDER BY transactionDate DESC, createdAt DESC LIMIT 1), 0)`,
+			bankAccountID).Scan(&running); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, l := range st.Lines {
+			key := l.Key()
+			var exists int
+			err := tx.QueryRowContext(ctx, `
+				SELECT COUNT(*) FROM bank_transactions WHERE bankAccountId = ? AND bankReference = ?`,
+				bankAccountID, key).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if exists > 0 {
+				res.Skipped++
+				continue
+			}
+
+			txnType := "deposit"
+			if l.Amount < 0 {
+				txnType = "withdrawal"
+				res.MoneyOut += -l.Amount
+			} else {
+				res.MoneyIn += l.Amount
+			}
+			running += l.Amount
+			if l.Balance != nil {
+				running = *l.Balance
+			}
+			description := l.Description
+			if description == "" {
+				description = "Statement line"
+			}
+
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO bank_transactions (id, bankAccountId, organizationId, transactionDate, transactionType,
+				  amount, runningBalance, description, reference, bankReference, createdAt, updatedAt)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				cashflow.NewID("banktxn"), bankAccountID, organizationID, l.Date, txnType, l.Amount.Abs(), running,
+				description, cashflow.NullString(l.Reference), key, now, now)
+			if err != nil {
+				return fmt.Errorf("insert statement line %s: %w", l.Date.Format(time.DateOnly), err)
+			}
+			res.Inserted++
+			if res.From == "" || l.Date.Format(time.DateOnly) < res.From {
+				res.From = l.Date.Format(time.DateOnly)
+			}
+			if l.Date.Format(time.DateOnly) > res.To {
+				res.To = l.Date.Format(time.DateOnly)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func parseAmount(s string) (money.Amount, error) {
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	s = strings.Trim(s, "()")
+	switch upper := strings.ToUpper(s); {
+	case strings.HasSuffix(upper, "DR"):
+		neg = true
+		s = s[:len(s)-2]
+	case strings.HasSuffix(upper, "CR"):
+		s = s[:len(s)-2]
+	}
+	a, err := money.Parse(s)
+	if err != nil {
+		return 0, err
+	}
+	if neg {
+		a = -a.Abs()
+	}
+	return a, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{time.DateOnly, "02/01/2006", "2/1/2006", "02-01-2006", "02 Jan 2006", "2 Jan 2006", "02-Jan-2006", "20060102", "2006/01/02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+func firstLine(b []byte) string {
+	s := strings.TrimPrefix(string(b), "\ufeff")
+	line, _, _ := strings.Cut(s, "\n")
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,173 @@
+package bankstmt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+func amount(s string) *money.Amount {
+	a := money.MustParse(s)
+	return &a
+}
+
+func TestParseCSV(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 3, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name  string
+		input string
+		want  *Statement
+	}{
+		{
+			name: "signed amount column",
+			input: "\ufeffTransaction Date,Details,Ref No,Amount,Running Balance\n" +
+				"2025-03-03,Salary,PAY1,\"1,500.00\",2500.00\n" +
+				"\n" +
+				"04/03/2025,,CHQ7,-200,2300\n",
+			want: &Statement{
+				Lines: []Line{
+					{Date: day(3), Description: "Salary", Reference: "PAY1", Amount: money.MustParse("1500"), Balance: amount("2500")},
+					{Date: day(4), Description: "CHQ7", Reference: "CHQ7", Amount: money.MustParse("-200"), Balance: amount("2300")},
+				},
+				ClosingBalance: amount("2300"),
+			},
+		},
+		{
+			name: "debit and credit columns",
+			input: "Date,Description,Withdrawal,Deposit,Transaction ID\n" +
+				"5 Mar 2025,ATM,50.00,,T1\n" +
+				"06-Mar-2025,Transfer in,,125.5,T2\n" +
+				"20250307,Fee,(3.00),,T3\n",
+			want: &Statement{
+				Lines: []Line{
+					{Date: day(5), Description: "ATM", Amount: money.MustParse("-50"), BankID: "T1"},
+					{Date: day(6), Description: "Transfer in", Amount: money.MustParse("125.50"), BankID: "T2"},
+					{Date: day(7), Description: "Fee", Amount: money.MustParse("-3"), BankID: "T3"},
+				},
+			},
+		},
+		{
+			name: "DR and CR suffixes",
+			input: "Value Date,Narrative,Amount\n" +
+				"2025/03/08,Card,12.00 DR\n" +
+				"2025/03/09,Refund,12.00CR\n",
+			want: &Statement{
+				Lines: []Line{
+					{Date: day(8), Description: "Card", Amount: money.MustParse("-12")},
+					{Date: day(9), Description: "Refund", Amount: money.MustParse("12")},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse("statement.csv", strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOFX(t *testing.T) {
+	const ofx = `OFXHEADER:100
+<OFX><BANKMSGSRSV1><STMTTRNRS><STMTRS><CURDEF>MMK
+<BANKACCTFROM><ACCTID>0012345678</BANKACCTFROM>
+<BANKTRANLIST>
+<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20250310120000<TRNAMT>-45000.00<FITID>F1<NAME>Electric<MEMO>March bill
+<STMTTRN><TRNTYPE>CREDIT<DTPOSTED>20250311<TRNAMT>150000<FITID>F2<NAME>Customer<CHECKNUM>991</STMTTRN>
+</BANKTRANLIST>
+<LEDGERBAL><BALAMT>1105000.00<DTASOF>20250311</LEDGERBAL>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1></OFX>`
+
+	got, err := Parse("export.txt", strings.NewReader(ofx))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := &Statement{
+		AccountNumber: "0012345678",
+		Currency:      "MMK",
+		Lines: []Line{
+			{Date: time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC), Description: "Electric March bill",
+				Amount: money.MustParse("-45000"), BankID: "F1"},
+			{Date: time.Date(2025, 3, 11, 0, 0, 0, 0, time.UTC), Description: "Customer", Reference: "991",
+				Amount: money.MustParse("150000"), BankID: "F2"},
+		},
+		ClosingBalance: amount("1105000"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		input string
+		want  string
+	}{
+		{name: "header only", file: "s.csv", input: "Date,Amount\n", want: "statement has no lines"},
+		{name: "no date", file: "s.csv", input: "Details,Amount\nx,1\n", want: "no date column"},
+		{name: "no amount", file: "s.csv", input: "Date,Details\n2025-03-01,x\n", want: "no amount or debit/credit columns"},
+		{name: "bad date", file: "s.csv", input: "Date,Amount\n31/31/2025,1\n", want: `line 2: invalid date "31/31/2025"`},
+		{name: "bad amount", file: "s.csv", input: "Date,Amount\n2025-03-01,ten\n", want: "line 2: invalid amount"},
+		{name: "no transactions", file: "s.ofx", input: "<OFX></OFX>", want: "no <STMTTRN> entries"},
+		{name: "no posting date", file: "s.ofx", input: "<OFX><STMTTRN><FITID>F9<TRNAMT>1</STMTTRN>",
+			want: "transaction F9 has no DTPOSTED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.file, strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want bool
+	}{
+		{name: "statement.ofx", want: true},
+		{name: "statement.QFX", want: true},
+		{name: "download", head: "OFXHEADER:100\n<OFX>", want: true},
+		{name: "statement.csv", head: "Date,Description,Debit,Credit,Balance\n", want: true},
+		{name: "statement.txt", head: "\ufeffPosting Date,Details,Withdrawal,Deposit\n", want: true},
+		{name: "statement.csv", head: "Date,Description,Amount\n", want: false},
+		{name: "sales.csv", head: "date,sku,quantity,balance\n", want: false},
+		{name: "statement.xlsx", head: "Date,Debit\n", want: false},
+	}
+	for _, tt := range tests {
+		if got := Detect(tt.name, []byte(tt.head)); got != tt.want {
+			t.Errorf("Detect(%q, %q) = %v, want %v", tt.name, tt.head, got, tt.want)
+		}
+	}
+}
+
+func TestLineKey(t *testing.T) {
+	l := Line{Date: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), Description: "ATM", Amount: money.MustParse("-50")}
+	if got := (Line{BankID: "F1"}).Key(); got != "F1" {
+		t.Errorf("Key with a bank id = %q, want F1", got)
+	}
+	lower := l
+	lower.Description = "atm"
+	if l.Key() != lower.Key() {
+		t.Errorf("Key depends on the description's case")
+	}
+	withBalance := l
+	withBalance.Balance = amount("100")
+	if l.Key() == withBalance.Key() || !strings.HasPrefix(l.Key(), "sha:") {
+		t.Errorf("Key(%+v) = %q, with a balance %q", l, l.Key(), withBalance.Key())
+	}
+}
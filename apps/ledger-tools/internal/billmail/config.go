@@ -0,0 +1,84 @@
+package billmail
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+)
+
+// Config is the receiver configuration file.
+type Config struct {
+	Listen          string `json:"listen"`
+	Hostname        string `json:"hostname"`
+	MaxMessageBytes int64  `json:"maxMessageBytes"`
+	// AttachmentDir is the root of the attachment store.
+	AttachmentDir string    `json:"attachmentDir"`
+	Mailboxes     []Mailbox `json:"mailboxes"`
+}
+
+// Mailbox binds one inbound address to an organization. Sub-addresses
+// (bills+anything@) reach the same mailbox.
+type Mailbox struct {
+	Address        string `json:"address"`
+	OrganizationID string `json:"organizationId"`
+	// Currency is assumed when a bill does not state one.
+	Currency string `json:"currency,omitempty"`
+}
+
+// LoadConfig reads and validates a configuration file.
+func LoadConfig(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{Listen: ":2525", Hostname: "localhost", MaxMessageBytes: 25 << 20}
+	if err := json.Unmarshal(raw, cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if cfg.AttachmentDir == "" {
+		return nil, fmt.Errorf("%s: attachmentDir is required", path)
+	}
+
+	seen := map[string]bool{}
+	for i := range cfg.Mailboxes {
+		mb := &cfg.Mailboxes[i]
+		if mb.Address == "" || mb.OrganizationID == "" {
+			return nil, fmt.Errorf("mailbox %d: address and organizationId are required", i)
+		}
+		if _, err := mail.ParseAddress(mb.Address); err != nil {
+			return nil, fmt.Errorf("mailbox %d: %w", i, err)
+		}
+		mb.Address = strings.ToLower(mb.Address)
+		if seen[mb.Address] {
+			return nil, fmt.Errorf("mailbox %q is defined twice", mb.Address)
+		}
+		seen[mb.Address] = true
+	}
+	return cfg, nil
+}
+
+// Mailbox returns the mailbox an address delivers to: an exact match, or
+// else the address without its +tag.
+func (c *Config) Mailbox(address string) (*Mailbox, bool) {
+	for _, a := range []string{strings.ToLower(address), baseAddress(address)} {
+		for i := range c.Mailboxes {
+			if c.Mailboxes[i].Address == a {
+				return &c.Mailboxes[i], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// baseAddress lowercases an address and drops its +tag.
+func baseAddress(address string) string {
+	address = strings.ToLower(address)
+	local, domain, ok := strings.Cut(address, "@")
+	if !ok {
+		return address
+	}
+	local, _, _ = strings.Cut(local, "+")
+	return local + "@" + domain
+}
@@ -0,0 +1,221 @@
+package billmail
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Hint sources, best first.
+const (
+	SourceUBL     = "ubl"
+	SourceSubject = "subject"
+	SourceNone    = "none"
+)
+
+// Hints is what could be read off a bill before anyone looked at it.
+type Hints struct {
+	Source      string       `json:"source"`
+	Attachment  string       `json:"attachment,omitempty"` // the UBL file the hints came from
+	VendorName  string       `json:"vendorName,omitempty"`
+	VendorTaxID string       `json:"vendorTaxId,omitempty"`
+	VendorEmail string       `json:"vendorEmail,omitempty"`
+	BillNumber  string       `json:"billNumber,omitempty"`
+	IssueDate   string       `json:"issueDate,omitempty"` // YYYY-MM-DD
+	DueDate     string       `json:"dueDate,omitempty"`
+	Currency    string       `json:"currency,omitempty"`
+	Total       money.Amount `json:"total"`
+	Tax         money.Amount `json:"tax"`
+}
+
+// ExtractHints prefers a UBL invoice among the attachments and falls back to
+// the subject line.
+func ExtractHints(msg *Message) *Hints {
+	for _, p := range msg.Attachments {
+		if !isXML(p) {
+			continue
+		}
+		if h, err := ParseUBL(p.Data); err == nil {
+			h.Attachment = p.FileName
+			return h
+		}
+	}
+	if h := parseSubject(msg.Subject); h != nil {
+		return h
+	}
+	return &Hints{Source: SourceNone}
+}
+
+func isXML(p *Part) bool {
+	return strings.HasSuffix(p.ContentType, "/xml") || strings.HasSuffix(p.ContentType, "+xml") ||
+		strings.EqualFold(filepath.Ext(p.FileName), ".xml")
+}
+
+// UBL 2.x Invoice and CreditNote, matched by local name so any namespace
+// prefixes work.
+type ublDocument struct {
+	XMLName        xml.Name
+	ID             string      `xml:"ID"`
+	IssueDate      string      `xml:"IssueDate"`
+	DueDate        string      `xml:"DueDate"`
+	PaymentDueDate string      `xml:"PaymentMeans>PaymentDueDate"`
+	Currency       string      `xml:"DocumentCurrencyCode"`
+	Supplier       ublParty    `xml:"AccountingSupplierParty>Party"`
+	TaxAmounts     []ublAmount `xml:"TaxTotal>TaxAmount"`
+	Payable        ublAmount   `xml:"LegalMonetaryTotal>PayableAmount"`
+	TaxInclusive   ublAmount   `xml:"LegalMonetaryTotal>TaxInclusiveAmount"`
+}
+
+type ublParty struct {
+	Name      string `xml:"PartyName>Name"`
+	LegalName string `xml:"PartyLegalEntity>RegistrationName"`
+	TaxID     string `xml:"PartyTaxScheme>CompanyID"`
+	Email     string `xml:"Contact>ElectronicMail"`
+}
+
+type ublAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"currencyID,attr"`
+}
+
+// ParseUBL reads the hints from a UBL Invoice or CreditNote.
+func ParseUBL(data []byte) (*Hints, error) {
+	root, err := rootElement(data)
+	if err != nil {
+		return nil, err
+	}
+	if root != "Invoice" && root != "CreditNote" {
+		return nil, errors.New("not a UBL invoice: root element is " + root)
+	}
+	var doc ublDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	h := &Hints{
+		Source:      SourceUBL,
+		VendorName:  strings.TrimSpace(doc.Supplier.Name),
+		VendorTaxID: strings.TrimSpace(doc.Supplier.TaxID),
+		VendorEmail: strings.ToLower(strings.TrimSpace(doc.Supplier.Email)),
+		BillNumber:  strings.TrimSpace(doc.ID),
+		IssueDate:   strings.TrimSpace(doc.IssueDate),
+		DueDate:     strings.TrimSpace(doc.DueDate),
+		Currency:    strings.TrimSpace(doc.Currency),
+	}
+	if h.VendorName == "" {
+		h.VendorName = strings.TrimSpace(doc.Supplier.LegalName)
+	}
+	if h.DueDate == "" {
+		h.DueDate = strings.TrimSpace(doc.PaymentDueDate)
+	}
+	total := doc.Payable
+	if strings.TrimSpace(total.Value) == "" {
+		total = doc.TaxInclusive
+	}
+	if h.Total, err = money.Parse(total.Value); err != nil {
+		return nil, err
+	}
+	if h.Currency == "" {
+		h.Currency = total.Currency
+	}
+	// A TaxTotal may be repeated in the tax currency; use the document's.
+	for _, t := range doc.TaxAmounts {
+		if t.Currency == "" || t.Currency == h.Currency {
+			if h.Tax, err = money.Parse(t.Value); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if root == "CreditNote" {
+		h.Total, h.Tax = -h.Total, -h.Tax
+	}
+	return h, nil
+}
+
+func rootElement(data []byte) (string, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			return "", errors.New("empty XML document")
+		}
+		if err != nil {
+			return "", err
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			return se.Name.Local, nil
+		}
+	}
+}
+
+var (
+	subjectNumber = regexp.MustCompile(`(?i)\b(?:invoice|inv|bill|receipt)\b\.?\s*(?:no\.?|number|#)?\s*[:#]?\s*([A-Z0-9][A-Z0-9\-/]*\d[A-Z0-9\-/]*)`)
+	// "USD 1,234.50", "1,234.50 USD" or "$1,234.50".
+	subjectCodeFirst   = regexp.MustCompile(`\b([A-Z]{3})\s?(\d[\d,]*(?:\.\d{1,2})?)\b`)
+	subjectCodeLast    = regexp.MustCompile(`\b(\d[\d,]*(?:\.\d{1,2})?)\s?([A-Z]{3})\b`)
+	subjectSymbol      = regexp.MustCompile(`([$€£¥])\s?(\d[\d,]*(?:\.\d{1,2})?)`)
+	subjectAmountLabel = regexp.MustCompile(`(?i)\b(?:amount|total)(?:\s+due)?\s*[:=]?\s*(\d[\d,]*(?:\.\d{1,2})?)`)
+	subjectDue         = regexp.MustCompile(`(?i)\bdue(?:\s+(?:date|on|by))?\s*[:=]?\s*(\d{4}-\d{2}-\d{2})`)
+)
+
+var currencyCodes = map[string]bool{
+	"AUD": true, "CAD": true, "CHF": true, "CNY": true, "EUR": true, "GBP": true, "HKD": true,
+	"IDR": true, "INR": true, "JPY": true, "KRW": true, "MMK": true, "MYR": true, "NZD": true,
+	"PHP": true, "SGD": true, "THB": true, "USD": true, "VND": true,
+}
+
+var currencySymbols = map[string]string{"$": "USD", "€": "EUR", "£": "GBP", "¥": "JPY"}
+
+// parseSubject reads a bill number, amount and due date from subjects like
+// "Invoice INV-2041 - USD 1,250.00 due 2024-07-31". It returns nil when the
+// subject carries none of them.
+func parseSubject(subject string) *Hints {
+	h := &Hints{Source: SourceSubject}
+	if m := subjectNumber.FindStringSubmatch(subject); m != nil {
+		h.BillNumber = m[1]
+	}
+	if m := subjectDue.FindStringSubmatch(subject); m != nil {
+		h.DueDate = m[1]
+	}
+
+	amount := ""
+	for _, m := range subjectCodeFirst.FindAllStringSubmatch(subject, -1) {
+		if currencyCodes[m[1]] {
+			h.Currency, amount = m[1], m[2]
+			break
+		}
+	}
+	if amount == "" {
+		for _, m := range subjectCodeLast.FindAllStringSubmatch(subject, -1) {
+			if currencyCodes[m[2]] {
+				h.Currency, amount = m[2], m[1]
+				break
+			}
+		}
+	}
+	if amount == "" {
+		if m := subjectSymbol.FindStringSubmatch(subject); m != nil {
+			h.Currency, amount = currencySymbols[m[1]], m[2]
+		}
+	}
+	if amount == "" {
+		if m := subjectAmountLabel.FindStringSubmatch(subject); m != nil {
+			amount = m[1]
+		}
+	}
+	if amount != "" {
+		h.Total, _ = money.Parse(amount)
+	}
+
+	if h.BillNumber == "" && h.Total == 0 && h.DueDate == "" {
+		return nil
+	}
+	return h
+}
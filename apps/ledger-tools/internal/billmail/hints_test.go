@@ -0,0 +1,167 @@
+package billmail
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+const ublInvoice = `<?xml version="1.0" encoding="UTF-8"?>
+<Invoice xmlns="urn:oasis:names:specification:ubl:schema:xsd:Invoice-2"
+  xmlns:cac="urn:oasis:names:specification:ubl:schema:xsd:CommonAggregateComponents-2"
+  xmlns:cbc="urn:oasis:names:specification:ubl:schema:xsd:CommonBasicComponents-2">
+  <cbc:ID>INV-2041</cbc:ID>
+  <cbc:IssueDate>2025-03-01</cbc:IssueDate>
+  <cbc:DueDate>2025-03-31</cbc:DueDate>
+  <cbc:DocumentCurrencyCode>USD</cbc:DocumentCurrencyCode>
+  <cac:AccountingSupplierParty><cac:Party>
+    <cac:PartyName><cbc:Name> Acme Supplies </cbc:Name></cac:PartyName>
+    <cac:PartyTaxScheme><cbc:CompanyID>MM-1234</cbc:CompanyID></cac:PartyTaxScheme>
+    <cac:Contact><cbc:ElectronicMail>Billing@Acme.example</cbc:ElectronicMail></cac:Contact>
+  </cac:Party></cac:AccountingSupplierParty>
+  <cac:TaxTotal><cbc:TaxAmount currencyID="MMK">210000</cbc:TaxAmount></cac:TaxTotal>
+  <cac:TaxTotal><cbc:TaxAmount currencyID="USD">100.00</cbc:TaxAmount></cac:TaxTotal>
+  <cac:LegalMonetaryTotal>
+    <cbc:TaxInclusiveAmount currencyID="USD">1100.00</cbc:TaxInclusiveAmount>
+    <cbc:PayableAmount currencyID="USD">1,050.00</cbc:PayableAmount>
+  </cac:LegalMonetaryTotal>
+</Invoice>`
+
+const ublCreditNote = `<CreditNote xmlns:cbc="x" xmlns:cac="y">
+  <cbc:ID>CN-7</cbc:ID>
+  <cbc:IssueDate>2025-03-05</cbc:IssueDate>
+  <cac:PaymentMeans><cbc:PaymentDueDate>2025-04-05</cbc:PaymentDueDate></cac:PaymentMeans>
+  <cac:AccountingSupplierParty><cac:Party>
+    <cac:PartyLegalEntity><cbc:RegistrationName>Acme Supplies Ltd</cbc:RegistrationName></cac:PartyLegalEntity>
+  </cac:Party></cac:AccountingSupplierParty>
+  <cac:TaxTotal><cbc:TaxAmount>5</cbc:TaxAmount></cac:TaxTotal>
+  <cac:LegalMonetaryTotal><cbc:TaxInclusiveAmount currencyID="EUR">55.00</cbc:TaxInclusiveAmount></cac:LegalMonetaryTotal>
+</CreditNote>`
+
+func TestParseUBL(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want *Hints
+	}{
+		{
+			name: "invoice",
+			doc:  ublInvoice,
+			want: &Hints{Source: SourceUBL, VendorName: "Acme Supplies", VendorTaxID: "MM-1234", VendorEmail: "billing@acme.example",
+				BillNumber: "INV-2041", IssueDate: "2025-03-01", DueDate: "2025-03-31", Currency: "USD",
+				Total: money.MustParse("1050"), Tax: money.MustParse("100")},
+		},
+		{
+			name: "credit note",
+			doc:  ublCreditNote,
+			want: &Hints{Source: SourceUBL, VendorName: "Acme Supplies Ltd", BillNumber: "CN-7", IssueDate: "2025-03-05",
+				DueDate: "2025-04-05", Currency: "EUR", Total: money.MustParse("-55"), Tax: money.MustParse("-5")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseUBL([]byte(tt.doc))
+			if err != nil {
+				t.Fatalf("ParseUBL: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseUBL =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+
+	for doc, want := range map[string]string{
+		"":                          "empty XML document",
+		"<Order><ID>1</ID></Order>": "root element is Order",
+		"<Invoice><LegalMonetaryTotal><PayableAmount>ten</PayableAmount></LegalMonetaryTotal></Invoice>": "invalid amount",
+	} {
+		if _, err := ParseUBL([]byte(doc)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseUBL(%q) error = %v, want %q", doc, err, want)
+		}
+	}
+}
+
+func TestParseSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    *Hints
+	}{
+		{subject: "Invoice INV-2041 - USD 1,250.00 due 2024-07-31",
+			want: &Hints{BillNumber: "INV-2041", Currency: "USD", Total: money.MustParse("1250"), DueDate: "2024-07-31"}},
+		{subject: "Your bill no. 88812 for March: 45,000 MMK",
+			want: &Hints{BillNumber: "88812", Currency: "MMK", Total: money.MustParse("45000")}},
+		{subject: "Receipt #R/2025/014 – €19.99",
+			want: &Hints{BillNumber: "R/2025/014", Currency: "EUR", Total: money.MustParse("19.99")}},
+		{subject: "Statement: amount due 300.5, due by 2025-04-01",
+			want: &Hints{Total: money.MustParse("300.50"), DueDate: "2025-04-01"}},
+		{subject: "FWD: ABC 100 and INV 77",
+			want: &Hints{BillNumber: "77"}},
+		{subject: "Lunch on Friday?"},
+		{subject: "Invoice attached"},
+	}
+	for _, tt := range tests {
+		got := parseSubject(tt.subject)
+		if tt.want != nil {
+			tt.want.Source = SourceSubject
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSubject(%q) = %+v, want %+v", tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestExtractHints(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    *Message
+		source string
+		number string
+	}{
+		{
+			name: "UBL attachment wins over the subject",
+			msg: &Message{Subject: "Invoice 999", Attachments: []*Part{
+				{FileName: "invoice.pdf", ContentType: "application/pdf", Data: []byte("%PDF")},
+				{FileName: "order.xml", ContentType: "application/xml", Data: []byte("<Order/>")},
+				{FileName: "INV-2041.XML", ContentType: "application/octet-stream", Data: []byte(ublInvoice)},
+			}},
+			source: SourceUBL, number: "INV-2041",
+		},
+		{
+			name: "subject when no XML parses",
+			msg: &Message{Subject: "Invoice 999", Attachments: []*Part{
+				{FileName: "broken.xml", ContentType: "text/xml", Data: []byte("<Invoice>")},
+			}},
+			source: SourceSubject, number: "999",
+		},
+		{name: "nothing", msg: &Message{Subject: "hello"}, source: SourceNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := ExtractHints(tt.msg)
+			if h.Source != tt.source || h.BillNumber != tt.number {
+				t.Errorf("ExtractHints = %s %q, want %s %q", h.Source, h.BillNumber, tt.source, tt.number)
+			}
+			if h.Source == SourceUBL && h.Attachment != "INV-2041.XML" {
+				t.Errorf("attachment = %q", h.Attachment)
+			}
+		})
+	}
+}
+
+func TestDueFromTerms(t *testing.T) {
+	tests := []struct{ issued, terms, want string }{
+		{issued: "2025-03-01", terms: "net30", want: "2025-03-31"},
+		{issued: "2025-01-31", terms: "Net_45", want: "2025-03-17"},
+		{issued: "2025-03-01", terms: "due_on_receipt", want: "2025-03-01"},
+		{issued: "2025-03-01", terms: "net0", want: ""},
+		{issued: "2025-03-01", terms: "eom", want: ""},
+		{issued: "", terms: "net30", want: ""},
+	}
+	for _, tt := range tests {
+		if got := dueFromTerms(tt.issued, tt.terms); got != tt.want {
+			t.Errorf("dueFromTerms(%q, %q) = %q, want %q", tt.issued, tt.terms, got, tt.want)
+		}
+	}
+}
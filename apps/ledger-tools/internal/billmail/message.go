@@ -0,0 +1,141 @@
+package billmail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path"
+	"strings"
+	"time"
+)
+
+// Message is the part of a received email the bill inbox cares about.
+type Message struct {
+	MessageID   string
+	From        string // address, lowercased
+	FromName    string
+	Subject     string
+	Date        time.Time
+	Text        string // first text/plain body
+	Attachments []*Part
+}
+
+// Part is one attachment.
+type Part struct {
+	FileName    string
+	ContentType string
+	Data        []byte
+}
+
+const maxDepth = 8
+
+var wordDecoder = &mime.WordDecoder{}
+
+// ParseMessage parses a raw RFC 5322 message and collects its attachments.
+func ParseMessage(raw []byte) (*Message, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{
+		MessageID: strings.Trim(strings.TrimSpace(m.Header.Get("Message-Id")), "<>"),
+		Subject:   decodeHeader(m.Header.Get("Subject")),
+	}
+	if from, err := m.Header.AddressList("From"); err == nil && len(from) > 0 {
+		msg.From = strings.ToLower(from[0].Address)
+		msg.FromName = from[0].Name
+	}
+	if d, err := m.Header.Date(); err == nil {
+		msg.Date = d
+	}
+	header := textproto.MIMEHeader(m.Header)
+	if err := msg.walk(header, m.Body, 0); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (msg *Message) walk(h textproto.MIMEHeader, body io.Reader, depth int) error {
+	if depth > maxDepth {
+		return errors.New("MIME parts nested too deeply")
+	}
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", mediaType, err)
+			}
+			if err := msg.walk(p.Header, p, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransfer(h.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("%s body: %w", mediaType, err)
+	}
+	disposition, dparams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	name := decodeHeader(dparams["filename"])
+	if name == "" {
+		name = decodeHeader(params["name"])
+	}
+
+	switch {
+	case name != "" || disposition == "attachment" || mediaType == "message/rfc822":
+		if name == "" {
+			name = "attachment"
+			if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+				name += exts[0]
+			}
+		}
+		msg.Attachments = append(msg.Attachments, &Part{
+			FileName:    path.Base(strings.ReplaceAll(name, `\`, "/")),
+			ContentType: mediaType,
+			Data:        data,
+		})
+	case mediaType == "text/plain" && msg.Text == "":
+		msg.Text = string(data)
+	}
+	return nil
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		// The decoder skips the line breaks base64 bodies are wrapped with.
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+func decodeHeader(s string) string {
+	if d, err := wordDecoder.DecodeHeader(s); err == nil {
+		return d
+	}
+	return s
+}
+
+// Domain returns the domain of the sender's address.
+func (msg *Message) Domain() string {
+	_, domain, _ := strings.Cut(msg.From, "@")
+	return domain
+}
@@ -0,0 +1,65 @@
+package billmail
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMessage(t *testing.T) {
+	raw := strings.ReplaceAll(`Message-Id: <abc@mail.example>
+From: "Acme Billing" <Billing@Acme.example>
+Subject: =?UTF-8?Q?Invoice_INV-2041_=E2=80=93_USD_10?=
+Date: Sat, 01 Mar 2025 09:00:00 +0630
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: multipart/alternative; boundary="inner"
+
+--inner
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+Please find the invoice attached.=20
+--inner
+Content-Type: text/html
+
+<p>html</p>
+--inner--
+--outer
+Content-Type: application/pdf; name="=?UTF-8?Q?INV=2D2041.pdf?="
+Content-Transfer-Encoding: base64
+
+JVBE
+Rg==
+--outer
+Content-Type: application/xml
+Content-Disposition: attachment; filename="..\..\ubl.xml"
+
+<Invoice/>
+--outer--
+`, "\n", "\r\n")
+
+	msg, err := ParseMessage([]byte(raw))
+	if err != nil {
+		t.Fatalf("ParseMessage: %v", err)
+	}
+	if msg.MessageID != "abc@mail.example" || msg.From != "billing@acme.example" || msg.FromName != "Acme Billing" {
+		t.Errorf("header = %q %q %q", msg.MessageID, msg.From, msg.FromName)
+	}
+	if msg.Subject != "Invoice INV-2041 – USD 10" || msg.Date.IsZero() || msg.Domain() != "acme.example" {
+		t.Errorf("subject %q, date %v, domain %q", msg.Subject, msg.Date, msg.Domain())
+	}
+	if msg.Text != "Please find the invoice attached. " {
+		t.Errorf("text = %q", msg.Text)
+	}
+	if len(msg.Attachments) != 2 {
+		t.Fatalf("attachments = %+v", msg.Attachments)
+	}
+	if a := msg.Attachments[0]; a.FileName != "INV-2041.pdf" || a.ContentType != "application/pdf" || string(a.Data) != "%PDF" {
+		t.Errorf("first attachment = %s %s %q", a.FileName, a.ContentType, a.Data)
+	}
+	if a := msg.Attachments[1]; a.FileName != "ubl.xml" || string(a.Data) != "<Invoice/>" {
+		t.Errorf("second attachment = %s %q", a.FileName, a.Data)
+	}
+}
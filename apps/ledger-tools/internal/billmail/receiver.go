@@ -0,0 +1,232 @@
+// Package billmail turns vendor bills sent by email into draft AP documents.
+// A small SMTP server accepts mail for per-organization inbound addresses;
+// each message is stored with its attachments, vendor and amount hints are
+// read from a UBL e-invoice attachment or the subject line, the sender is
+// linked to a vendor, and an ap_documents draft waits for review. Approving
+// a draft posts the bill to accounts payable.
+package billmail
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/attachments"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+)
+
+// Receiver files messages delivered to the configured mailboxes.
+type Receiver struct {
+	Config *Config
+	DB     *sql.DB
+	Store  attachments.Store
+	Logger *log.Logger
+}
+
+// Result is the outcome of filing one message for one mailbox.
+type Result struct {
+	Email     *Email
+	Document  *Document
+	Duplicate bool
+}
+
+// Accept implements Delivery.
+func (r *Receiver) Accept(address string) bool {
+	_, ok := r.Config.Mailbox(address)
+	return ok
+}
+
+// Deliver implements Delivery. A message addressed to several mailboxes is
+// filed once per mailbox.
+func (r *Receiver) Deliver(ctx context.Context, env *Envelope) error {
+	done := map[*Mailbox]bool{}
+	for _, to := range env.To {
+		mb, ok := r.Config.Mailbox(to)
+		if !ok || done[mb] {
+			continue
+		}
+		done[mb] = true
+		res, err := r.Receive(ctx, mb, to, env)
+		if err != nil {
+			return fmt.Errorf("%s: %w", to, err)
+		}
+		if res.Duplicate {
+			r.Logger.Printf("%s: %s already received as %s", mb.Address, res.Email.MessageID, res.Email.ID)
+			continue
+		}
+		d := res.Document
+		r.Logger.Printf("%s: %s from %s -> %s (vendor %q by %s, %s %s, hints from %s)", mb.Address, res.Email.ID,
+			res.Email.Sender, d.ID, d.VendorName, orNone(d.VendorMatch), d.Currency, d.Total, d.HintSource)
+	}
+	return nil
+}
+
+// Receive stores one message for a mailbox and creates its draft document.
+// The same Message-ID is filed once per organization.
+func (r *Receiver) Receive(ctx context.Context, mb *Mailbox, recipient string, env *Envelope) (*Result, error) {
+	sum := sha256.Sum256(env.Data)
+	email := &Email{
+		ID:             cashflow.NewID("mail"),
+		OrganizationID: mb.OrganizationID,
+		EnvelopeFrom:   env.From,
+		Recipient:      strings.ToLower(recipient),
+		Size:           int64(len(env.Data)),
+		RemoteAddr:     env.RemoteAddr,
+		ReceivedAt:     time.Now(),
+	}
+
+	msg, err := ParseMessage(env.Data)
+	if err != nil {
+		// Keep unreadable mail for a person to look at instead of bouncing it.
+		email.ParseError = err.Error()
+		msg = &Message{}
+	}
+	email.MessageID = msg.MessageID
+	if email.MessageID == "" {
+		email.MessageID = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	email.Sender = msg.From
+	if email.Sender == "" {
+		email.Sender = env.From
+	}
+	email.Subject = truncate(msg.Subject, 1000)
+
+	res := &Result{Email: email}
+	err = db.InTx(ctx, r.DB, func(tx *sql.Tx) error {
+		var myErr *mysql.MySQLError
+		if err := insertEmail(ctx, tx, email); errors.As(err, &myErr) && myErr.Number == 1062 {
+			res.Duplicate = true
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("record email: %w", err)
+		}
+		if err := attachments.Save(ctx, tx, r.Store, &attachments.Attachment{
+			OrganizationID: mb.OrganizationID,
+			OwnerType:      OwnerEmail,
+			OwnerID:        email.ID,
+			FileName:       "message.eml",
+			ContentType:    "message/rfc822",
+		}, env.Data); err != nil {
+			return err
+		}
+
+		doc, err := r.draft(ctx, tx, mb, email, msg)
+		if err != nil {
+			return err
+		}
+		res.Document = doc
+		return linkEmail(ctx, tx, email.ID, doc.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (r *Receiver) draft(ctx context.Context, tx *sql.Tx, mb *Mailbox, email *Email, msg *Message) (*Document, error) {
+	hints := ExtractHints(msg)
+	vendors, err := loadVendors(ctx, tx, mb.OrganizationID)
+	if err != nil {
+		return nil, fmt.Errorf("load vendors: %w", err)
+	}
+	vendor, how := matchVendor(vendors, email.Sender, hints)
+
+	doc := &Document{
+		ID:             cashflow.NewID("apdoc"),
+		OrganizationID: mb.OrganizationID,
+		Status:         StatusDraft,
+		EmailID:        email.ID,
+		VendorMatch:    how,
+		Sender:         email.Sender,
+		Subject:        email.Subject,
+		BillNumber:     truncate(hints.BillNumber, 191),
+		IssueDate:      validDate(hints.IssueDate),
+		DueDate:        validDate(hints.DueDate),
+		Currency:       strings.ToUpper(hints.Currency),
+		Total:          hints.Total,
+		Tax:            hints.Tax,
+		HintSource:     hints.Source,
+	}
+	if doc.Currency == "" {
+		doc.Currency = mb.Currency
+	}
+	if doc.IssueDate == "" && !msg.Date.IsZero() {
+		doc.IssueDate = msg.Date.Format(time.DateOnly)
+	}
+	if vendor != nil {
+		doc.VendorID, doc.VendorName = vendor.ID, vendor.Name
+		if doc.DueDate == "" {
+			doc.DueDate = dueFromTerms(doc.IssueDate, vendor.PaymentTerms)
+		}
+	}
+	raw, err := json.Marshal(hints)
+	if err != nil {
+		return nil, err
+	}
+	doc.Hints = string(raw)
+
+	if err := insertDocument(ctx, tx, doc); err != nil {
+		return nil, fmt.Errorf("create draft: %w", err)
+	}
+	for _, p := range msg.Attachments {
+		if err := attachments.Save(ctx, tx, r.Store, &attachments.Attachment{
+			OrganizationID: mb.OrganizationID,
+			OwnerType:      OwnerDocument,
+			OwnerID:        doc.ID,
+			FileName:       truncate(p.FileName, 500),
+			ContentType:    p.ContentType,
+		}, p.Data); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// dueFromTerms applies vendor payment terms such as "net30" or
+// "due_on_receipt" to the issue date.
+func dueFromTerms(issueDate, terms string) string {
+	issued, err := time.Parse(time.DateOnly, issueDate)
+	if err != nil {
+		return ""
+	}
+	terms = strings.ToLower(strings.TrimSpace(terms))
+	if terms == "due_on_receipt" || terms == "immediate" {
+		return issueDate
+	}
+	var days int
+	if _, err := fmt.Sscanf(strings.TrimPrefix(strings.ReplaceAll(terms, "_", ""), "net"), "%d", &days); err != nil || days <= 0 {
+		return ""
+	}
+	return issued.AddDate(0, 0, days).Format(time.DateOnly)
+}
+
+func validDate(s string) string {
+	if _, err := time.Parse(time.DateOnly, s); err != nil {
+		return ""
+	}
+	return s
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
@@ -0,0 +1,184 @@
+package billmail
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/dimensions"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Review holds a reviewer's corrections; empty fields keep the draft's
+// values.
+type Review struct {
+	VendorID         string
+	BillNumber       string
+	IssueDate        string
+	DueDate          string
+	Total            *money.Amount
+	Tax              *money.Amount
+	ExpenseAccountID string
+	// Dimensions tags the posted bill, e.g. cost_center=HQ.
+	Dimensions dimensions.Tags
+	ReviewedBy string
+	Note       string
+}
+
+func (rv *Review) apply(d *Document) {
+	if rv.VendorID != "" {
+		d.VendorID = rv.VendorID
+	}
+	if rv.BillNumber != "" {
+		d.BillNumber = rv.BillNumber
+	}
+	if rv.IssueDate != "" {
+		d.IssueDate = rv.IssueDate
+	}
+	if rv.DueDate != "" {
+		d.DueDate = rv.DueDate
+	}
+	if rv.ExpenseAccountID != "" {
+		d.ExpenseAccountID = rv.ExpenseAccountID
+	}
+	if rv.Total != nil {
+		d.Total = *rv.Total
+	}
+	if rv.Tax != nil {
+		d.Tax = *rv.Tax
+	}
+	d.ReviewedBy = rv.ReviewedBy
+	d.ReviewNote = rv.Note
+}
+
+// Approve posts a draft to accounts payable: the net amount is debited to
+// the expense account, tax to input tax, and the total credited to
+// accounts payable.
+func Approve(ctx context.Context, conn *sql.DB, organizationID, id string, rv *Review) (*Document, error) {
+	var doc *Document
+	err := db.InTx(ctx, conn, func(tx *sql.Tx) error {
+		d, err := GetDocument(ctx, tx, organizationID, id, true)
+		if err != nil {
+			return err
+		}
+		if d.Status != StatusDraft {
+			return fmt.Errorf("document %s is %s; only drafts can be approved", id, d.Status)
+		}
+		rv.apply(d)
+		if d.IssueDate == "" {
+			d.IssueDate = time.Now().Format(time.DateOnly)
+		}
+		if err := checkApproval(ctx, tx, d); err != nil {
+			return err
+		}
+
+		j, err := billJournal(ctx, tx, d)
+		if err != nil {
+			return err
+		}
+		if err := cashflow.PostJournal(ctx, tx, j); err != nil {
+			return err
+		}
+		if err := dimensions.TagJournal(ctx, tx, organizationID, j.ID, dimensions.Origin{}, rv.Dimensions); err != nil {
+			return err
+		}
+		d.JournalID = j.ID
+		d.Status = StatusApproved
+		doc = d
+		return reviewDocument(ctx, tx, d)
+	})
+	return doc, err
+}
+
+func checkApproval(ctx context.Context, q cashflow.Querier, d *Document) error {
+	if d.VendorID == "" {
+		return errors.New("no vendor is linked; pass one with -vendor")
+	}
+	var name string
+	err := q.QueryRowContext(ctx, `SELECT name FROM vendors WHERE id = ? AND organizationId = ?`,
+		d.VendorID, d.OrganizationID).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("vendor %s: %w", d.VendorID, cashflow.ErrNotFound)
+	} else if err != nil {
+		return err
+	}
+	d.VendorName = name
+
+	if d.Total <= 0 {
+		return errors.New("total must be positive; pass it with -total")
+	}
+	if d.Tax < 0 || d.Tax >= d.Total {
+		return fmt.Errorf("tax %s must be between zero and the total %s", d.Tax, d.Total)
+	}
+	for _, date := range []string{d.IssueDate, d.DueDate} {
+		if date != "" && validDate(date) == "" {
+			return fmt.Errorf("invalid date %q; use YYYY-MM-DD", date)
+		}
+	}
+	return nil
+}
+
+func billJournal(ctx context.Context, q cashflow.Querier, d *Document) (*cashflow.Journal, error) {
+	if d.ExpenseAccountID == "" {
+		id, err := cashflow.AccountByType(ctx, q, d.OrganizationID, "expense")
+		if err != nil {
+			return nil, err
+		}
+		d.ExpenseAccountID = id
+	} else if _, err := cashflow.GetLedgerAccount(ctx, q, d.OrganizationID, d.ExpenseAccountID); err != nil {
+		return nil, err
+	}
+	payable, err := cashflow.AccountByType(ctx, q, d.OrganizationID, "accounts_payable")
+	if err != nil {
+		return nil, err
+	}
+	date, _ := time.Parse(time.DateOnly, d.IssueDate)
+
+	desc := "Bill from " + d.VendorName
+	if d.BillNumber != "" {
+		desc += " " + d.BillNumber
+	}
+	j := &cashflow.Journal{
+		OrganizationID: d.OrganizationID,
+		Number:         "BILL-" + d.ID,
+		Date:           date,
+		Reference:      d.BillNumber,
+		Notes:          fmt.Sprintf("Emailed bill %s from %s", d.ID, d.Sender),
+		Lines: []cashflow.JournalLine{
+			{AccountID: d.ExpenseAccountID, Description: desc, Debit: d.Total - d.Tax},
+		},
+	}
+	if d.Tax > 0 {
+		tax, err := cashflow.AccountByType(ctx, q, d.OrganizationID, "input_tax")
+		if err != nil {
+			return nil, err
+		}
+		j.Lines = append(j.Lines, cashflow.JournalLine{AccountID: tax, Description: desc + " (tax)", Debit: d.Tax})
+	}
+	j.Lines = append(j.Lines, cashflow.JournalLine{AccountID: payable, Description: desc, Credit: d.Total})
+	return j, nil
+}
+
+// Reject closes a draft without posting it.
+func Reject(ctx context.Context, conn *sql.DB, organizationID, id string, rv *Review) (*Document, error) {
+	var doc *Document
+	err := db.InTx(ctx, conn, func(tx *sql.Tx) error {
+		d, err := GetDocument(ctx, tx, organizationID, id, true)
+		if err != nil {
+			return err
+		}
+		if d.Status != StatusDraft {
+			return fmt.Errorf("document %s is %s; only drafts can be rejected", id, d.Status)
+		}
+		d.Status = StatusRejected
+		d.ReviewedBy = rv.ReviewedBy
+		d.ReviewNote = rv.Note
+		doc = d
+		return reviewDocument(ctx, tx, d)
+	})
+	return doc, err
+}
@@ -0,0 +1,294 @@
+package billmail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Envelope is a message as received over SMTP.
+type Envelope struct {
+	RemoteAddr string
+	From       string
+	To         []string
+	Data       []byte
+}
+
+// Delivery accepts or rejects recipients and stores accepted messages.
+type Delivery interface {
+	// Accept reports whether mail for the address is wanted.
+	Accept(address string) bool
+	// Deliver stores a message; an error is returned to the sender as a
+	// temporary failure so it is retried.
+	Deliver(ctx context.Context, env *Envelope) error
+}
+
+// Server is a minimal receive-only SMTP server (RFC 5321 without AUTH or
+// relaying). Put it behind the MX host or a TLS-terminating proxy.
+type Server struct {
+	Addr            string
+	Hostname        string
+	MaxMessageBytes int64
+	Delivery        Delivery
+	Logger          *log.Logger
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    sync.WaitGroup
+}
+
+const (
+	readTimeout  = 5 * time.Minute
+	maxRecipient = 50
+	// maxLine bounds a command line including CRLF; RFC 5321 allows 512
+	// octets, the rest is room for extensions such as SIZE.
+	maxLine = 1000
+)
+
+var errLineTooLong = errors.New("line too long")
+
+// ListenAndServe accepts connections until Shutdown.
+func (s *Server) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.conns.Add(1)
+		go func() {
+			defer s.conns.Done()
+			s.serve(c)
+		}()
+	}
+}
+
+// Shutdown stops accepting and waits for open sessions to finish.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mu.Unlock()
+	s.conns.Wait()
+}
+
+type session struct {
+	s    *Server
+	conn net.Conn
+	tp   *textproto.Conn
+	env  *Envelope
+	helo bool
+}
+
+func (s *Server) serve(c net.Conn) {
+	defer c.Close()
+	ss := &session{s: s, conn: c, tp: textproto.NewConn(c)}
+	ss.reply(220, "%s ESMTP billmail ready", s.Hostname)
+
+	for {
+		c.SetReadDeadline(time.Now().Add(readTimeout))
+		line, err := ss.readLine()
+		if err == errLineTooLong {
+			ss.reply(500, "Line too long")
+			continue
+		}
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			ss.helo = true
+			ss.reply(250, "%s", s.Hostname)
+		case "EHLO":
+			ss.helo = true
+			ss.tp.PrintfLine("250-%s", s.Hostname)
+			ss.tp.PrintfLine("250-SIZE %d", s.MaxMessageBytes)
+			ss.tp.PrintfLine("250-8BITMIME")
+			ss.tp.PrintfLine("250 PIPELINING")
+		case "MAIL":
+			ss.mail(arg)
+		case "RCPT":
+			ss.rcpt(arg)
+		case "DATA":
+			if !ss.data() {
+				return
+			}
+		case "RSET":
+			ss.env = nil
+			ss.reply(250, "OK")
+		case "NOOP":
+			ss.reply(250, "OK")
+		case "VRFY":
+			ss.reply(252, "Cannot verify")
+		case "QUIT":
+			ss.reply(221, "Bye")
+			return
+		default:
+			ss.reply(502, "Command not implemented")
+		}
+	}
+}
+
+// readLine reads a command line of at most maxLine bytes. A longer line
+// is discarded up to its end, without holding it in memory.
+func (ss *session) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := ss.tp.R.ReadSlice('\n')
+		if len(line)+len(chunk) > maxLine {
+			for err == bufio.ErrBufferFull {
+				_, err = ss.tp.R.ReadSlice('\n')
+			}
+			if err != nil {
+				return "", err
+			}
+			return "", errLineTooLong
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+func (ss *session) reply(code int, format string, args ...any) {
+	ss.tp.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+func (ss *session) mail(arg string) {
+	if !ss.helo {
+		ss.reply(503, "Say HELO first")
+		return
+	}
+	addr, ok := pathArg(arg, "FROM:")
+	if !ok {
+		ss.reply(501, "Syntax: MAIL FROM:<address>")
+		return
+	}
+	if size := declaredSize(arg); size > ss.s.MaxMessageBytes {
+		ss.reply(552, "Message exceeds %d bytes", ss.s.MaxMessageBytes)
+		return
+	}
+	ss.env = &Envelope{RemoteAddr: ss.conn.RemoteAddr().String(), From: addr}
+	ss.reply(250, "OK")
+}
+
+func (ss *session) rcpt(arg string) {
+	if ss.env == nil {
+		ss.reply(503, "MAIL first")
+		return
+	}
+	addr, ok := pathArg(arg, "TO:")
+	if !ok || addr == "" {
+		ss.reply(501, "Syntax: RCPT TO:<address>")
+		return
+	}
+	if len(ss.env.To) >= maxRecipient {
+		ss.reply(452, "Too many recipients")
+		return
+	}
+	if !ss.s.Delivery.Accept(addr) {
+		ss.reply(550, "No such mailbox")
+		return
+	}
+	ss.env.To = append(ss.env.To, addr)
+	ss.reply(250, "OK")
+}
+
+// data reads and delivers a message. It returns false when the session
+// must end: the connection failed, or a message too large to skip was sent.
+func (ss *session) data() bool {
+	if ss.env == nil || len(ss.env.To) == 0 {
+		ss.reply(503, "RCPT first")
+		return true
+	}
+	ss.reply(354, "End data with <CR><LF>.<CR><LF>")
+
+	dot := ss.tp.DotReader()
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(io.LimitReader(dot, ss.s.MaxMessageBytes+1)); err != nil {
+		ss.env = nil
+		return false
+	}
+	env := ss.env
+	ss.env = nil
+	if int64(buf.Len()) > ss.s.MaxMessageBytes {
+		// Skip the rest so the session stays in sync, but only up to as
+		// much again; past that the sender is cut off.
+		n, err := io.Copy(io.Discard, io.LimitReader(dot, ss.s.MaxMessageBytes+1))
+		ss.reply(552, "Message exceeds %d bytes", ss.s.MaxMessageBytes)
+		return err == nil && n <= ss.s.MaxMessageBytes
+	}
+	env.Data = buf.Bytes()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := ss.s.Delivery.Deliver(ctx, env); err != nil {
+		ss.s.Logger.Printf("deliver from %s to %v: %v", env.From, env.To, err)
+		ss.reply(451, "Temporary failure, try again later")
+		return true
+	}
+	ss.reply(250, "OK queued")
+	return true
+}
+
+// declaredSize returns the SIZE= parameter of MAIL FROM (RFC 1870), or 0.
+func declaredSize(arg string) int64 {
+	for _, f := range strings.Fields(arg) {
+		if k, v, ok := strings.Cut(f, "="); ok && strings.EqualFold(k, "SIZE") {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// pathArg parses "FROM:<a@b> SIZE=123" style arguments.
+func pathArg(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(rest, "<") {
+		return "", false
+	}
+	end := strings.Index(rest, ">")
+	if end < 0 {
+		return "", false
+	}
+	addr := rest[1:end]
+	if addr == "" {
+		return "", true // null reverse-path
+	}
+	if _, err := mail.ParseAddress(addr); err != nil {
+		return "", false
+	}
+	return strings.ToLower(addr), true
+}
@@ -0,0 +1,141 @@
+package billmail
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+type memDelivery struct{ got []*Envelope }
+
+func (d *memDelivery) Accept(address string) bool {
+	return strings.HasSuffix(address, "@bills.example")
+}
+
+func (d *memDelivery) Deliver(_ context.Context, env *Envelope) error {
+	d.got = append(d.got, env)
+	return nil
+}
+
+func TestServe(t *testing.T) {
+	const envelope = "EHLO vendor.example\r\nMAIL FROM:<ap@vendor.example>\r\nRCPT TO:<acme@bills.example>\r\nDATA\r\n"
+	message := func(n int) string {
+		return "Subject: bill\r\n\r\n" + strings.Repeat("x", n) + "\r\n.\r\n"
+	}
+
+	tests := []struct {
+		name      string
+		send      []string // each is written before reading one reply
+		codes     []int
+		delivered int
+		closed    bool
+	}{
+		{
+			name:      "delivers",
+			send:      append(strings.SplitAfter(envelope, "\r\n")[:4], message(10), "QUIT\r\n"),
+			codes:     []int{250, 250, 250, 354, 250, 221},
+			delivered: 1,
+			closed:    true,
+		},
+		{
+			name:  "unknown mailbox",
+			send:  []string{"HELO vendor.example\r\n", "MAIL FROM:<ap@vendor.example>\r\n", "RCPT TO:<x@other.example>\r\n"},
+			codes: []int{250, 250, 550},
+		},
+		{
+			name:  "command line too long",
+			send:  []string{"NOOP " + strings.Repeat("x", 5000) + "\r\n", "NOOP\r\n"},
+			codes: []int{500, 250},
+		},
+		{
+			name:  "command line at the limit",
+			send:  []string{"NOOP " + strings.Repeat("x", maxLine-7) + "\r\n"},
+			codes: []int{250},
+		},
+		{
+			name:  "declared size too large",
+			send:  []string{"HELO vendor.example\r\n", "MAIL FROM:<ap@vendor.example> SIZE=1000\r\n", "RCPT TO:<acme@bills.example>\r\n"},
+			codes: []int{250, 552, 503},
+		},
+		{
+			name:  "message too large is skipped",
+			send:  append(strings.SplitAfter(envelope, "\r\n")[:4], message(150), "NOOP\r\n"),
+			codes: []int{250, 250, 250, 354, 552, 250},
+		},
+		{
+			name:   "message far too large ends the session",
+			send:   append(strings.SplitAfter(envelope, "\r\n")[:4], message(5000)),
+			codes:  []int{250, 250, 250, 354, 552},
+			closed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &memDelivery{}
+			s := &Server{Hostname: "mx.test", MaxMessageBytes: 100, Delivery: d, Logger: log.New(io.Discard, "", 0)}
+			server, client := net.Pipe()
+			done := make(chan struct{})
+			go func() {
+				s.serve(server)
+				close(done)
+			}()
+			defer func() {
+				client.Close()
+				<-done
+			}()
+
+			tp := textproto.NewConn(client)
+			if _, _, err := tp.ReadResponse(220); err != nil {
+				t.Fatalf("greeting: %v", err)
+			}
+			for i, line := range tt.send {
+				// The server may reply before it has read everything, so
+				// the write must not hold up the read.
+				go io.WriteString(client, line)
+				code, msg, err := tp.ReadResponse(0)
+				if err != nil && code == 0 {
+					t.Fatalf("reply to %.40q: %v", line, err)
+				}
+				if code != tt.codes[i] {
+					t.Errorf("reply to %.40q = %d %s, want %d", line, code, msg, tt.codes[i])
+				}
+			}
+			if tt.closed {
+				if _, err := tp.ReadLine(); err != io.EOF {
+					t.Errorf("after the last reply: %v, want the connection closed", err)
+				}
+			}
+			if len(d.got) != tt.delivered {
+				t.Fatalf("delivered %d messages, want %d", len(d.got), tt.delivered)
+			}
+			if tt.delivered > 0 {
+				env := d.got[0]
+				if env.From != "ap@vendor.example" || len(env.To) != 1 || env.To[0] != "acme@bills.example" ||
+					string(env.Data) != "Subject: bill\n\nxxxxxxxxxx\n" {
+					t.Errorf("delivered %+v (%q)", env, env.Data)
+				}
+			}
+		})
+	}
+}
+
+func TestDeclaredSize(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want int64
+	}{
+		{arg: "FROM:<a@b.example>", want: 0},
+		{arg: "FROM:<a@b.example> SIZE=2048", want: 2048},
+		{arg: "FROM:<a@b.example> BODY=8BITMIME size=10", want: 10},
+		{arg: "FROM:<a@b.example> SIZE=lots", want: 0},
+	}
+	for _, tt := range tests {
+		if got := declaredSize(tt.arg); got != tt.want {
+			t.Errorf("declaredSize(%q) = %d, want %d", tt.arg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package billmail
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Document statuses.
+const (
+	StatusDraft    = "draft"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+// Attachment owner types.
+const (
+	OwnerEmail    = "inbound_email"
+	OwnerDocument = "ap_document"
+)
+
+// Tables are the cashflowdb tables owned by the bill inbox. The unique key
+// on (organization_id, message_id) makes redelivery after a temporary
+// failure harmless.
+var Tables = []schema.Table{
+	{
+		Name: "inbound_emails",
+		Create: `CREATE TABLE IF NOT EXISTS inbound_emails (
+  id VARCHAR(191) NOT NULL,
+  organization_id VARCHAR(191) NOT NULL,
+  message_id VARCHAR(500) NOT NULL,
+  envelope_from VARCHAR(320) NOT NULL,
+  recipient VARCHAR(320) NOT NULL,
+  sender VARCHAR(320) NULL,
+  subject VARCHAR(1000) NULL,
+  size BIGINT NOT NULL,
+  remote_addr VARCHAR(100) NULL,
+  parse_error TEXT NULL,
+  document_id VARCHAR(191) NULL,
+  received_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY inbound_emails_org_message_unique (organization_id, message_id(191)),
+  INDEX inbound_emails_org_received_idx (organization_id, received_at)
+) ENGINE=InnoDB`,
+	},
+	{
+		Name: "ap_documents",
+		Create: `CREATE TABLE IF NOT EXISTS ap_documents (
+  id VARCHAR(191) NOT NULL,
+  organization_id VARCHAR(191) NOT NULL,
+  status VARCHAR(20) NOT NULL,
+  email_id VARCHAR(191) NULL,
+  vendor_id VARCHAR(191) NULL,
+  vendor_match VARCHAR(20) NULL,
+  sender VARCHAR(320) NULL,
+  subject VARCHAR(1000) NULL,
+  bill_number VARCHAR(191) NULL,
+  issue_date DATE NULL,
+  due_date DATE NULL,
+  currency VARCHAR(3) NULL,
+  total_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+  tax_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+  hint_source VARCHAR(20) NOT NULL,
+  hints TEXT NULL,
+  expense_account_id VARCHAR(191) NULL,
+  journal_id VARCHAR(191) NULL,
+  reviewed_by VARCHAR(191) NULL,
+  reviewed_at DATETIME(3) NULL,
+  review_note TEXT NULL,
+  created_at DATETIME(3) NOT NULL,
+  updated_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (id),
+  INDEX ap_documents_org_status_idx (organization_id, status, created_at),
+  INDEX ap_documents_vendor_idx (vendor_id)
+) ENGINE=InnoDB`,
+	},
+}
+
+// Email is an inbound_emails row.
+type Email struct {
+	ID             string
+	OrganizationID string
+	MessageID      string
+	EnvelopeFrom   string
+	Recipient      string
+	Sender         string
+	Subject        string
+	Size           int64
+	RemoteAddr     string
+	ParseError     string
+	DocumentID     string
+	ReceivedAt     time.Time
+}
+
+func insertEmail(ctx context.Context, q cashflow.Querier, e *Email) error {
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO inbound_emails (id, organization_id, message_id, envelope_from, recipient, sender, subject,
+		  size, remote_addr, parse_error, received_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.OrganizationID, e.MessageID, e.EnvelopeFrom, e.Recipient, cashflow.NullString(e.Sender),
+		cashflow.NullString(e.Subject), e.Size, cashflow.NullString(e.RemoteAddr),
+		cashflow.NullString(e.ParseError), e.ReceivedAt)
+	return err
+}
+
+func linkEmail(ctx context.Context, q cashflow.Querier, emailID, documentID string) error {
+	_, err := q.ExecContext(ctx, `UPDATE inbound_emails SET document_id = ? WHERE id = ?`, documentID, emailID)
+	return err
+}
+
+// Document is a draft AP document (a vendor bill awaiting review).
+type Document struct {
+	ID               string
+	OrganizationID   string
+	Status           string
+	EmailID          string
+	VendorID         string
+	VendorName       string // joined from vendors
+	VendorMatch      string
+	Sender           string
+	Subject          string
+	BillNumber       string
+	IssueDate        string
+	DueDate          string
+	Currency         string
+	Total            money.Amount
+	Tax              money.Amount
+	HintSource       string
+	Hints            string // JSON
+	ExpenseAccountID string
+	JournalID        string
+	ReviewedBy       string
+	ReviewedAt       *time.Time
+	ReviewNote       string
+	CreatedAt        time.Time
+}
+
+func insertDocument(ctx context.Context, q cashflow.Querier, d *Document) error {
+	now := time.Now()
+	d.CreatedAt = now
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO ap_documents (id, organization_id, status, email_id, vendor_id, vendor_match, sender, subject,
+		  bill_number, issue_date, due_date, currency, total_amount, tax_amount, hint_source, hints,
+		  created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.ID, d.OrganizationID, d.Status, cashflow.NullString(d.EmailID), cashflow.NullString(d.VendorID),
+		cashflow.NullString(d.VendorMatch), cashflow.NullString(d.Sender), cashflow.NullString(d.Subject),
+		cashflow.NullString(d.BillNumber), cashflow.NullString(d.IssueDate), cashflow.NullString(d.DueDate),
+		cashflow.NullString(d.Currency), d.Total, d.Tax, d.HintSource, cashflow.NullString(d.Hints), now, now)
+	return err
+}
+
+const documentColumns = `d.id, d.organization_id, d.status, COALESCE(d.email_id, ''), COALESCE(d.vendor_id, ''),
+	COALESCE(v.name, ''), COALESCE(d.vendor_match, ''), COALESCE(d.sender, ''), COALESCE(d.subject, ''),
+	COALESCE(d.bill_number, ''), COALESCE(DATE_FORMAT(d.issue_date, '%Y-%m-%d'), ''),
+	COALESCE(DATE_FORMAT(d.due_date, '%Y-%m-%d'), ''), COALESCE(d.currency, ''), d.total_amount, d.tax_amount,
+	d.hint_source, COALESCE(d.hints, ''), COALESCE(d.expense_account_id, ''), COALESCE(d.journal_id, ''),
+	COALESCE(d.reviewed_by, ''), d.reviewed_at, COALESCE(d.review_note, ''), d.created_at
+	FROM ap_documents d LEFT JOIN vendors v ON v.id = d.vendor_id`
+
+func scanDocument(row interface{ Scan(...any) error }) (*Document, error) {
+	d := &Document{}
+	var reviewed sql.NullTime
+	err := row.Scan(&d.ID, &d.OrganizationID, &d.Status, &d.EmailID, &d.VendorID, &d.VendorName, &d.VendorMatch,
+		&d.Sender, &d.Subject, &d.BillNumber, &d.IssueDate, &d.DueDate, &d.Currency, &d.Total, &d.Tax,
+		&d.HintSource, &d.Hints, &d.ExpenseAccountID, &d.JournalID, &d.ReviewedBy, &reviewed, &d.ReviewNote,
+		&d.CreatedAt)
+	if reviewed.Valid {
+		d.ReviewedAt = &reviewed.Time
+	}
+	return d, err
+}
+
+// GetDocument loads a document scoped to an organization. With lock set the
+// row is locked for update.
+func GetDocument(ctx context.Context, q cashflow.Querier, organizationID, id string, lock bool) (*Document, error) {
+	query := `SELECT ` + documentColumns + ` WHERE d.id = ? AND d.organization_id = ?`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	d, err := scanDocument(q.QueryRowContext(ctx, query, id, organizationID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("document %s: %w", id, cashflow.ErrNotFound)
+	}
+	return d, err
+}
+
+// Documents lists an organization's documents, newest first. An empty
+// status lists every status.
+func Documents(ctx context.Context, q cashflow.Querier, organizationID, status string, limit int) ([]*Document, error) {
+	rows, err := q.QueryContext(ctx, `SELECT `+documentColumns+`
+		WHERE d.organization_id = ? AND (? = '' OR d.status = ?)
+		ORDER BY d.created_at DESC LIMIT ?`, organizationID, status, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Document
+	for rows.Next() {
+		d, err := scanDocument(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func reviewDocument(ctx context.Context, q cashflow.Querier, d *Document) error {
+	now := time.Now()
+	d.ReviewedAt = &now
+	_, err := q.ExecContext(ctx, `
+		UPDATE ap_documents SET status = ?, vendor_id = ?, bill_number = ?, issue_date = ?, due_date = ?,
+		  currency = ?, total_amount = ?, tax_amount = ?, expense_account_id = ?, journal_id = ?,
+		  reviewed_by = ?, reviewed_at = ?, review_note = ?, updated_at = ?
+		WHERE id = ?`,
+		d.Status, cashflow.NullString(d.VendorID), cashflow.NullString(d.BillNumber),
+		cashflow.NullString(d.IssueDate), cashflow.NullString(d.DueDate), cashflow.NullString(d.Currency),
+		d.Total, d.Tax, cashflow.NullString(d.ExpenseAccountID), cashflow.NullString(d.JournalID),
+		cashflow.NullString(d.ReviewedBy), now, cashflow.NullString(d.ReviewNote), now, d.ID)
+	return err
+}
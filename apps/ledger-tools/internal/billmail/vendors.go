@@ -0,0 +1,146 @@
+package billmail
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+)
+
+// How a document was linked to its vendor.
+const (
+	MatchTaxID  = "tax_id"
+	MatchEmail  = "email"
+	MatchDomain = "domain"
+	MatchName   = "name"
+)
+
+// Vendor is the part of a vendors row used for matching.
+type Vendor struct {
+	ID           string
+	Name         string
+	Email        string
+	Website      string
+	TaxID        string
+	PaymentTerms string
+}
+
+// freeMail domains are shared by unrelated senders, so they only match a
+// vendor by exact address.
+var freeMail = map[string]bool{
+	"gmail.com": true, "googlemail.com": true, "yahoo.com": true, "outlook.com": true, "hotmail.com": true,
+	"live.com": true, "icloud.com": true, "me.com": true, "aol.com": true, "proton.me": true,
+	"protonmail.com": true, "gmx.com": true, "mail.com": true, "yandex.com": true, "zoho.com": true,
+}
+
+func loadVendors(ctx context.Context, q cashflow.Querier, organizationID string) ([]*Vendor, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, name, COALESCE(email, ''), COALESCE(website, ''), COALESCE(taxId, ''), COALESCE(paymentTerms, '')
+		FROM vendors WHERE organizationId = ? AND isActive = true`, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Vendor
+	for rows.Next() {
+		v := &Vendor{}
+		if err := rows.Scan(&v.ID, &v.Name, &v.Email, &v.Website, &v.TaxID, &v.PaymentTerms); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// matchVendor links a bill to a vendor by, in order, the tax id on a UBL
+// invoice, the exact sender address, the sender's domain against vendor
+// email and website domains, and the UBL supplier name. A step that matches
+// more than one vendor is skipped rather than guessed.
+func matchVendor(vendors []*Vendor, sender string, h *Hints) (*Vendor, string) {
+	senders := []string{strings.ToLower(sender)}
+	if h.VendorEmail != "" {
+		senders = append(senders, h.VendorEmail)
+	}
+
+	steps := []struct {
+		how   string
+		match func(v *Vendor) bool
+	}{
+		{MatchTaxID, func(v *Vendor) bool {
+			return h.VendorTaxID != "" && normalizeTaxID(v.TaxID) == normalizeTaxID(h.VendorTaxID)
+		}},
+		{MatchEmail, func(v *Vendor) bool {
+			for _, s := range senders {
+				if s != "" && strings.EqualFold(strings.TrimSpace(v.Email), s) {
+					return true
+				}
+			}
+			return false
+		}},
+		{MatchDomain, func(v *Vendor) bool {
+			for _, s := range senders {
+				d := emailDomain(s)
+				if d == "" || freeMail[d] {
+					continue
+				}
+				if sameDomain(d, emailDomain(v.Email)) || sameDomain(d, websiteDomain(v.Website)) {
+					return true
+				}
+			}
+			return false
+		}},
+		{MatchName, func(v *Vendor) bool {
+			return h.VendorName != "" && strings.EqualFold(strings.TrimSpace(v.Name), h.VendorName)
+		}},
+	}
+
+	for _, step := range steps {
+		var found []*Vendor
+		for _, v := range vendors {
+			if step.match(v) {
+				found = append(found, v)
+			}
+		}
+		if len(found) == 1 {
+			return found[0], step.how
+		}
+	}
+	return nil, ""
+}
+
+func normalizeTaxID(s string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "", ".", "").Replace(s))
+}
+
+func emailDomain(address string) string {
+	_, domain, ok := strings.Cut(strings.ToLower(strings.TrimSpace(address)), "@")
+	if !ok {
+		return ""
+	}
+	return strings.TrimPrefix(domain, "www.")
+}
+
+// sameDomain reports whether a sender domain is the vendor's domain or one
+// of its subdomains (billing.acme.com for acme.com).
+func sameDomain(sender, vendor string) bool {
+	return vendor != "" && (sender == vendor || strings.HasSuffix(sender, "."+vendor))
+}
+
+// websiteDomain turns "https://www.acme.com/contact" or "acme.com" into
+// "acme.com".
+func websiteDomain(website string) string {
+	website = strings.ToLower(strings.TrimSpace(website))
+	if website == "" {
+		return ""
+	}
+	if !strings.Contains(website, "://") {
+		website = "http://" + website
+	}
+	u, err := url.Parse(website)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Hostname(), "www.")
+}
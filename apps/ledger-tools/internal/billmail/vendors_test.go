@@ -0,0 +1,67 @@
+package billmail
+
+import "testing"
+
+func TestMatchVendor(t *testing.T) {
+	vendors := []*Vendor{
+		{ID: "acme", Name: "Acme Supplies", Email: "accounts@acme.example", TaxID: "MM-12 34"},
+		{ID: "beta", Name: "Beta Trading", Email: "beta.trading@gmail.com", Website: "https://www.beta.example/contact"},
+		{ID: "gamma", Name: "Gamma", Email: "gamma@gmail.com"},
+		{ID: "twin1", Name: "Twin", Website: "twin.example"},
+		{ID: "twin2", Name: "Twin", Email: "sales@twin.example"},
+	}
+	tests := []struct {
+		name   string
+		sender string
+		hints  *Hints
+		want   string
+		how    string
+	}{
+		{name: "tax id", sender: "noreply@portal.example", hints: &Hints{VendorTaxID: "mm1234"}, want: "acme", how: MatchTaxID},
+		{name: "exact address", sender: "Beta.Trading@gmail.com", hints: &Hints{}, want: "beta", how: MatchEmail},
+		{name: "UBL contact address", sender: "noreply@portal.example", hints: &Hints{VendorEmail: "accounts@acme.example"},
+			want: "acme", how: MatchEmail},
+		{name: "subdomain", sender: "billing@mail.acme.example", hints: &Hints{}, want: "acme", how: MatchDomain},
+		{name: "website domain", sender: "ar@beta.example", hints: &Hints{}, want: "beta", how: MatchDomain},
+		{name: "free mail domain is not a match", sender: "someone@gmail.com", hints: &Hints{}},
+		{name: "ambiguous domain falls through to name", sender: "x@twin.example", hints: &Hints{VendorName: "gamma"},
+			want: "gamma", how: MatchName},
+		{name: "ambiguous name", sender: "x@twin.example", hints: &Hints{VendorName: "Twin"}},
+		{name: "lookalike domain", sender: "billing@notacme.example", hints: &Hints{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, how := matchVendor(vendors, tt.sender, tt.hints)
+			id := ""
+			if v != nil {
+				id = v.ID
+			}
+			if id != tt.want || how != tt.how {
+				t.Errorf("matchVendor = %q by %q, want %q by %q", id, how, tt.want, tt.how)
+			}
+		})
+	}
+}
+
+func TestMailbox(t *testing.T) {
+	cfg := &Config{Mailboxes: []Mailbox{{Address: "bills@books.example"}, {Address: "bills+shop@books.example"}}}
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{address: "Bills@Books.example", want: "bills@books.example"},
+		{address: "bills+march@books.example", want: "bills@books.example"},
+		{address: "bills+shop@books.example", want: "bills+shop@books.example"},
+		{address: "other@books.example"},
+	}
+	for _, tt := range tests {
+		mb, ok := cfg.Mailbox(tt.address)
+		got := ""
+		if ok {
+			got = mb.Address
+		}
+		if got != tt.want {
+			t.Errorf("Mailbox(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Package books keeps adjustment-only ledger books layered over an
+// organization's main OA ledger, such as a tax book with tax depreciation
+// or a management book with provisions the statutory accounts leave out.
+//
+// A book is either a separate OA org holding only the adjustments, or a set
+// of transactions in the main OA org tagged as belonging to the book. Tagged
+// transactions are left out of the main book. Reports add books together by
+// account path (Assets:Fixed Assets:Accumulated Depreciation), so a tax view
+// is the main book plus the tax book.
+package books
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Main is the code of the main ledger: the organization's OA org without
+// the transactions tagged to other books.
+const Main = "main"
+
+// Book kinds.
+const (
+	KindOrg    = "org"    // adjustments live in their own OA org
+	KindTagged = "tagged" // adjustments are tagged transactions in the main org
+)
+
+// Tables are the cashflowdb tables owned by the books subsystem.
+var Tables = []schema.Table{
+	{
+		Name: "books",
+		Create: `CREATE TABLE IF NOT EXISTS books (
+  organization_id VARCHAR(191) NOT NULL,
+  code VARCHAR(50) NOT NULL,
+  name VARCHAR(191) NOT NULL,
+  kind VARCHAR(10) NOT NULL,
+  oa_org_id CHAR(32) NULL,
+  tax_rate DECIMAL(6,4) NULL,
+  deferred_tax_account VARCHAR(500) NULL,
+  created_at DATETIME(3) NOT NULL,
+  updated_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (organization_id, code)
+) ENGINE=InnoDB`,
+	},
+	{
+		Name: "book_transactions",
+		Create: `CREATE TABLE IF NOT EXISTS book_transactions (
+  organization_id VARCHAR(191) NOT NULL,
+  transaction_id CHAR(32) NOT NULL,
+  book VARCHAR(50) NOT NULL,
+  tagged_by VARCHAR(191) NULL,
+  tagged_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (organization_id, transaction_id),
+  INDEX book_transactions_book_idx (organization_id, book)
+) ENGINE=InnoDB`,
+	},
+}
+
+// Book is a books row.
+type Book struct {
+	OrganizationID string
+	Code           string
+	Name           string
+	Kind           string
+	OAOrgID        string // KindOrg only
+	// TaxRate and DeferredTaxAccount are used when the book is the tax side
+	// of a deferred tax reconciliation. The account is an OA account path in
+	// the main chart.
+	TaxRate            float64
+	DeferredTaxAccount string
+}
+
+var codePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+
+// Define creates or updates a book. An org book's OA org must exist and
+// keep its books in the main org's currency.
+func Define(ctx context.Context, cf, oadb cashflow.Querier, b *Book) error {
+	if !codePattern.MatchString(b.Code) || b.Code == Main {
+		return fmt.Errorf("book code %q must be lower_snake_case and not %q", b.Code, Main)
+	}
+	if b.Name == "" {
+		b.Name = b.Code
+	}
+	if b.TaxRate < 0 || b.TaxRate >= 1 {
+		return fmt.Errorf("tax rate %g must be a fraction, e.g. 0.25", b.TaxRate)
+	}
+	switch b.Kind {
+	case KindTagged:
+		b.OAOrgID = ""
+	case KindOrg:
+		if b.OAOrgID == "" {
+			return errors.New("an org book needs its OA org id")
+		}
+		b.OAOrgID = oa.NormalizeID(b.OAOrgID)
+		mainID, err := oa.OrgForOrganization(ctx, cf, b.OrganizationID)
+		if err != nil {
+			return err
+		}
+		if mainID == b.OAOrgID {
+			return errors.New("a book cannot be the main OA org itself")
+		}
+		mainOrg, err := oa.GetOrg(ctx, oadb, mainID)
+		if err != nil {
+			return err
+		}
+		bookOrg, err := oa.GetOrg(ctx, oadb, b.OAOrgID)
+		if err != nil {
+			return err
+		}
+		if bookOrg.Currency != mainOrg.Currency {
+			return fmt.Errorf("OA org %s keeps %s books; the main org keeps %s", bookOrg.Name, bookOrg.Currency, mainOrg.Currency)
+		}
+	default:
+		return fmt.Errorf("unknown book kind %q", b.Kind)
+	}
+
+	now := time.Now()
+	_, err := cf.ExecContext(ctx, `
+		INSERT INTO books (organization_id, code, name, kind, oa_org_id, tax_rate, deferred_tax_account, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE name = VALUES(name), kind = VALUES(kind), oa_org_id = VALUES(oa_org_id),
+		  tax_rate = VALUES(tax_rate), deferred_tax_account = VALUES(deferred_tax_account), updated_at = VALUES(updated_at)`,
+		b.OrganizationID, b.Code, b.Name, b.Kind, cashflow.NullString(b.OAOrgID), nullRate(b.TaxRate),
+		cashflow.NullString(b.DeferredTaxAccount), now, now)
+	return err
+}
+
+func nullRate(r float64) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: r, Valid: r != 0}
+}
+
+const bookColumns = `organization_id, code, name, kind, COALESCE(oa_org_id, ''), COALESCE(tax_rate, 0),
+	COALESCE(deferred_tax_account, '') FROM books`
+
+func scanBook(row interface{ Scan(...any) error }) (*Book, error) {
+	b := &Book{}
+	err := row.Scan(&b.OrganizationID, &b.Code, &b.Name, &b.Kind, &b.OAOrgID, &b.TaxRate, &b.DeferredTaxAccount)
+	return b, err
+}
+
+// List returns an organization's books by code.
+func List(ctx context.Context, q cashflow.Querier, organizationID string) ([]*Book, error) {
+	rows, err := q.QueryContext(ctx, `SELECT `+bookColumns+` WHERE organization_id = ? ORDER BY code`, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Book
+	for rows.Next() {
+		b, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// Get loads one book.
+func Get(ctx context.Context, q cashflow.Querier, organizationID, code string) (*Book, error) {
+	b, err := scanBook(q.QueryRowContext(ctx, `SELECT `+bookColumns+` WHERE organization_id = ? AND code = ?`,
+		organizationID, code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("book %s: %w", code, cashflow.ErrNotFound)
+	}
+	return b, err
+}
+
+// Tag moves a transaction of the main OA org into a tagged book. A
+// transaction belongs to at most one book.
+func Tag(ctx context.Context, cf, oadb cashflow.Querier, b *Book, transactionID, by string) error {
+	if b.Kind != KindTagged {
+		return fmt.Errorf("book %s keeps its adjustments in its own OA org; post them there", b.Code)
+	}
+	transactionID = oa.NormalizeID(transactionID)
+	mainID, err := oa.OrgForOrganization(ctx, cf, b.OrganizationID)
+	if err != nil {
+		return err
+	}
+	var orgID string
+	err = oadb.QueryRowContext(ctx, `
+		SELECT LOWER(HEX(orgId)) FROM transaction WHERE id = UNHEX(?) AND deleted = false`, transactionID).Scan(&orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("OA transaction %s: %w", transactionID, cashflow.ErrNotFound)
+	} else if err != nil {
+		return err
+	}
+	if orgID != mainID {
+		return fmt.Errorf("OA transaction %s belongs to another org", transactionID)
+	}
+
+	_, err = cf.ExecContext(ctx, `
+		INSERT INTO book_transactions (organization_id, transaction_id, book, tagged_by, tagged_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		b.OrganizationID, transactionID, b.Code, cashflow.NullString(by), time.Now())
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) && myErr.Number == 1062 {
+		var other string
+		cf.QueryRowContext(ctx, `SELECT book FROM book_transactions WHERE organization_id = ? AND transaction_id = ?`,
+			b.OrganizationID, transactionID).Scan(&other)
+		return fmt.Errorf("OA transaction %s is already in book %s", transactionID, other)
+	}
+	return err
+}
+
+// Untag returns a transaction to the main book.
+func Untag(ctx context.Context, q cashflow.Querier, organizationID, transactionID string) error {
+	res, err := q.ExecContext(ctx, `
+		DELETE FROM book_transactions WHERE organization_id = ? AND transaction_id = ?`,
+		organizationID, oa.NormalizeID(transactionID))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("OA transaction %s is not in a book: %w", transactionID, cashflow.ErrNotFound)
+	}
+	return nil
+}
+
+// tagged maps the organization's tagged transactions to their book.
+func tagged(ctx context.Context, q cashflow.Querier, organizationID string) (map[string]string, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT transaction_id, book FROM book_transactions WHERE organization_id = ?`, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]string{}
+	for rows.Next() {
+		var tx, book string
+		if err := rows.Scan(&tx, &book); err != nil {
+			return nil, err
+		}
+		out[tx] = book
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,143 @@
+package books
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Difference is one account's amount in the management and tax views, both
+// signed debit positive.
+type Difference struct {
+	Path       string
+	Class      string
+	Management money.Amount
+	Tax        money.Amount
+}
+
+// Gap is management less tax. For assets and liabilities it is the
+// temporary difference: positive gaps are taxable (deferred tax
+// liability), negative gaps deductible (deferred tax asset).
+func (d *Difference) Gap() money.Amount { return d.Management - d.Tax }
+
+// Reconciliation explains the deferred tax balance by the differences
+// between a management view and a tax view of the same ledger.
+type Reconciliation struct {
+	Management []string
+	Tax        []string
+	Rate       float64
+	Account    string // deferred tax account path, may be empty
+	From       time.Time
+	To         time.Time
+
+	Temporary []*Difference // assets and liabilities at the end of the period
+	Profit    []*Difference // income and expenses for the period
+	Equity    []*Difference // equity differences, which carry no deferred tax
+
+	OpeningTemporary money.Amount
+	ClosingTemporary money.Amount
+	Recorded         money.Amount // deferred tax account, signed debit positive
+}
+
+// Required is the net deferred tax liability the closing differences call
+// for; a negative value is a net asset.
+func (r *Reconciliation) Required() money.Amount { return r.ClosingTemporary.Mul(r.Rate) }
+
+// Opening is the net liability at the start of the period.
+func (r *Reconciliation) Opening() money.Amount { return r.OpeningTemporary.Mul(r.Rate) }
+
+// Adjustment is the entry that brings the recorded balance to the required
+// one, as the credit to post to the deferred tax account (negative: debit).
+func (r *Reconciliation) Adjustment() money.Amount { return r.Required() + r.Recorded }
+
+// DeferredTax compares the management view (e.g. main) with the tax view
+// (e.g. main + tax) at the end of the period. The deferred tax account
+// itself is not a temporary difference and is left out.
+func (l *Ledger) DeferredTax(management, tax []string, rate float64, account string) *Reconciliation {
+	r := &Reconciliation{Management: management, Tax: tax, Rate: rate, Account: account, From: l.From, To: l.To}
+	mgmtBal, taxBal := l.Combined(TrialBalance, management), l.Combined(TrialBalance, tax)
+	mgmtAct, taxAct := l.Combined(ProfitAndLoss, management), l.Combined(ProfitAndLoss, tax)
+	r.Recorded = mgmtBal[account]
+
+	for path, a := range l.Accounts {
+		if path == account {
+			continue
+		}
+		switch a.Class {
+		case cashflow.ClassAsset, cashflow.ClassLiability:
+			d := &Difference{Path: path, Class: a.Class, Management: mgmtBal[path], Tax: taxBal[path]}
+			// Balances at the start are the closing ones less the period.
+			r.OpeningTemporary += (d.Management - mgmtAct[path]) - (d.Tax - taxAct[path])
+			if d.Gap() != 0 {
+				r.ClosingTemporary += d.Gap()
+				r.Temporary = append(r.Temporary, d)
+			}
+		case cashflow.ClassIncome, cashflow.ClassExpense:
+			if d := (&Difference{Path: path, Class: a.Class, Management: mgmtAct[path], Tax: taxAct[path]}); d.Gap() != 0 {
+				r.Profit = append(r.Profit, d)
+			}
+		case cashflow.ClassEquity:
+			if d := (&Difference{Path: path, Class: a.Class, Management: mgmtBal[path], Tax: taxBal[path]}); d.Gap() != 0 {
+				r.Equity = append(r.Equity, d)
+			}
+		}
+	}
+	for _, ds := range [][]*Difference{r.Temporary, r.Profit, r.Equity} {
+		sort.Slice(ds, func(i, j int) bool { return ds[i].Path < ds[j].Path })
+	}
+	return r
+}
+
+// Print writes the reconciliation.
+func (r *Reconciliation) Print(w io.Writer) {
+	fmt.Fprintf(w, "Deferred tax: %s against %s, %s to %s, rate %.2f%%\n",
+		strings.Join(r.Management, " + "), strings.Join(r.Tax, " + "),
+		r.From.Format(time.DateOnly), r.To.AddDate(0, 0, -1).Format(time.DateOnly), 100*r.Rate)
+
+	fmt.Fprintln(w, "\nTemporary differences (debit positive)")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACCOUNT\tCARRYING AMOUNT\tTAX BASE\tDIFFERENCE\tDEFERRED TAX\t")
+	for _, d := range r.Temporary {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t\n", d.Path, d.Management, d.Tax, d.Gap(), d.Gap().Mul(r.Rate))
+	}
+	fmt.Fprintf(tw, "Total\t\t\t%s\t%s\t\n", r.ClosingTemporary, r.Required())
+	tw.Flush()
+
+	fmt.Fprintln(w, "\nDeferred tax (liability positive)")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Required at start\t%s\t\n", r.Opening())
+	fmt.Fprintf(tw, "Movement for the period\t%s\t\n", r.Required()-r.Opening())
+	fmt.Fprintf(tw, "Required at end\t%s\t\n", r.Required())
+	if r.Account != "" {
+		fmt.Fprintf(tw, "Recorded in %s\t%s\t\n", r.Account, -r.Recorded)
+		fmt.Fprintf(tw, "Adjustment to post\t%s\t\n", r.Adjustment())
+	}
+	tw.Flush()
+
+	if len(r.Profit) > 0 {
+		fmt.Fprintln(w, "\nProfit differences for the period (debit positive)")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ACCOUNT\tMANAGEMENT\tTAX\tDIFFERENCE\t")
+		var total money.Amount
+		for _, d := range r.Profit {
+			total += d.Gap()
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", d.Path, d.Management, d.Tax, d.Gap())
+		}
+		fmt.Fprintf(tw, "Management profit less taxable profit\t\t\t%s\t\n", -total)
+		tw.Flush()
+	}
+	if len(r.Equity) > 0 {
+		fmt.Fprintln(w, "\nEquity differences (no deferred tax)")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, d := range r.Equity {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", d.Path, d.Management, d.Tax, d.Gap())
+		}
+		tw.Flush()
+	}
+}
@@ -0,0 +1,237 @@
+package books
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+)
+
+// Report kinds.
+const (
+	TrialBalance  = "trial-balance"
+	ProfitAndLoss = "profit-and-loss"
+)
+
+// Account is an account path as it appears in any book.
+type Account struct {
+	Path  string
+	Class string
+}
+
+// Ledger holds every book's activity for a period and balances at its end,
+// keyed by book code and account path. Amounts are signed, debit positive.
+type Ledger struct {
+	OrganizationID string
+	Currency       string
+	From           time.Time
+	To             time.Time // exclusive
+	Books          map[string]*Book
+	Accounts       map[string]*Account
+	Activity       map[string]map[string]money.Amount
+	Balance        map[string]map[string]money.Amount
+}
+
+// Load reads the main OA org and every book of an organization.
+func Load(ctx context.Context, cf, oadb cashflow.Querier, organizationID string, from, to time.Time) (*Ledger, error) {
+	mainID, err := oa.OrgForOrganization(ctx, cf, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	mainOrg, err := oa.GetOrg(ctx, oadb, mainID)
+	if err != nil {
+		return nil, err
+	}
+	defined, err := List(ctx, cf, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := tagged(ctx, cf, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Ledger{
+		OrganizationID: organizationID,
+		Currency:       mainOrg.Currency,
+		From:           from,
+		To:             to,
+		Books:          map[string]*Book{Main: {OrganizationID: organizationID, Code: Main, Name: mainOrg.Name, OAOrgID: mainID}},
+		Accounts:       map[string]*Account{},
+		Activity:       map[string]map[string]money.Amount{Main: {}},
+		Balance:        map[string]map[string]money.Amount{Main: {}},
+	}
+	for _, b := range defined {
+		l.Books[b.Code] = b
+		l.Activity[b.Code] = map[string]money.Amount{}
+		l.Balance[b.Code] = map[string]money.Amount{}
+	}
+
+	// The main org carries the main book and every tagged book.
+	err = l.read(ctx, oadb, mainOrg, func(s *oa.Split) string {
+		if book, ok := tags[s.TransactionID]; ok && l.Books[book] != nil {
+			return book
+		}
+		return Main
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range defined {
+		if b.Kind != KindOrg {
+			continue
+		}
+		org, err := oa.GetOrg(ctx, oadb, b.OAOrgID)
+		if err != nil {
+			return nil, fmt.Errorf("book %s: %w", b.Code, err)
+		}
+		code := b.Code
+		if err := l.read(ctx, oadb, org, func(*oa.Split) string { return code }); err != nil {
+			return nil, fmt.Errorf("book %s: %w", b.Code, err)
+		}
+	}
+	return l, nil
+}
+
+func (l *Ledger) read(ctx context.Context, oadb cashflow.Querier, org *oa.Org, book func(*oa.Split) string) error {
+	chart, err := oa.LoadChart(ctx, oadb, org.ID)
+	if err != nil {
+		return err
+	}
+	return oa.Splits(ctx, oadb, org.ID, time.Time{}, l.To, func(s *oa.Split) error {
+		path := chart.FullName(s.AccountID)
+		if _, ok := l.Accounts[path]; !ok {
+			l.Accounts[path] = &Account{Path: path, Class: chart.Class(s.AccountID)}
+		}
+		amount := money.FromMinor(s.Amount, org.Precision)
+		code := book(s)
+		l.Balance[code][path] += amount
+		if !s.Date.Before(l.From) {
+			l.Activity[code][path] += amount
+		}
+		return nil
+	})
+}
+
+// Codes checks a comma-separated book list, e.g. "main,tax".
+func (l *Ledger) Codes(list string) ([]string, error) {
+	var out []string
+	for _, c := range strings.Split(list, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if l.Books[c] == nil {
+			return nil, fmt.Errorf("book %s: %w", c, cashflow.ErrNotFound)
+		}
+		out = append(out, c)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no books selected")
+	}
+	return out, nil
+}
+
+// Combined adds the balances (or, for a P&L, the period activity) of books.
+func (l *Ledger) Combined(kind string, codes []string) map[string]money.Amount {
+	out := map[string]money.Amount{}
+	for _, c := range codes {
+		for path, amount := range l.amounts(kind, c) {
+			out[path] += amount
+		}
+	}
+	return out
+}
+
+func (l *Ledger) amounts(kind, code string) map[string]money.Amount {
+	if kind == ProfitAndLoss {
+		return l.Activity[code]
+	}
+	return l.Balance[code]
+}
+
+// paths returns the accounts a report shows, in statement order.
+func (l *Ledger) paths(kind string, codes []string) []string {
+	var out []string
+	for path, a := range l.Accounts {
+		if kind == ProfitAndLoss && a.Class != cashflow.ClassIncome && a.Class != cashflow.ClassExpense {
+			continue
+		}
+		for _, c := range codes {
+			if l.amounts(kind, c)[path] != 0 {
+				out = append(out, path)
+				break
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		ci, cj := classOrder[l.Accounts[out[i]].Class], classOrder[l.Accounts[out[j]].Class]
+		if ci != cj {
+			return ci < cj
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
+
+var classOrder = map[string]int{
+	cashflow.ClassAsset: 1, cashflow.ClassLiability: 2, cashflow.ClassEquity: 3,
+	cashflow.ClassIncome: 4, cashflow.ClassExpense: 5,
+}
+
+// Print writes a trial balance or profit and loss with one column per book
+// and, for more than one book, their combination.
+func (l *Ledger) Print(w io.Writer, kind string, codes []string) {
+	period := "as of " + l.To.AddDate(0, 0, -1).Format(time.DateOnly)
+	if kind == ProfitAndLoss {
+		period = l.From.Format(time.DateOnly) + " to " + l.To.AddDate(0, 0, -1).Format(time.DateOnly)
+	}
+	fmt.Fprintf(w, "%s, books %s, %s (%s, debit positive)\n\n", kind, strings.Join(codes, " + "), period, l.Currency)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := "ACCOUNT\tCLASS\t"
+	for _, c := range codes {
+		header += strings.ToUpper(c) + "\t"
+	}
+	if len(codes) > 1 {
+		header += "COMBINED\t"
+	}
+	fmt.Fprintln(tw, header)
+
+	combined := l.Combined(kind, codes)
+	totals := make([]money.Amount, len(codes))
+	var grand money.Amount
+	for _, path := range l.paths(kind, codes) {
+		row := path + "\t" + l.Accounts[path].Class + "\t"
+		for i, c := range codes {
+			v := l.amounts(kind, c)[path]
+			totals[i] += v
+			row += v.String() + "\t"
+		}
+		if len(codes) > 1 {
+			grand += combined[path]
+			row += combined[path].String() + "\t"
+		}
+		fmt.Fprintln(tw, row)
+	}
+	label := "Total"
+	if kind == ProfitAndLoss {
+		label = "Net (negative is profit)"
+	}
+	row := label + "\t\t"
+	for _, t := range totals {
+		row += t.String() + "\t"
+	}
+	if len(codes) > 1 {
+		row += grand.String() + "\t"
+	}
+	fmt.Fprintln(tw, row)
+	tw.Flush()
+}
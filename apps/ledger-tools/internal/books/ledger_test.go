@@ -0,0 +1,126 @@
+package books
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+const (
+	equipment    = "Assets:Equipment"
+	deferredTax  = "Liabilities:Deferred tax"
+	depreciation = "Expenses:Depreciation"
+	sales        = "Income:Sales"
+	opening      = "Equity:Opening balances"
+)
+
+// testLedger is a main book with a tax book that depreciates equipment
+// faster: 40 in earlier periods and 60 in this one.
+func testLedger() *Ledger {
+	mm := money.MustParse
+	return &Ledger{
+		Books: map[string]*Book{Main: {Code: Main}, "tax": {Code: "tax"}},
+		Accounts: map[string]*Account{
+			equipment:    {Path: equipment, Class: cashflow.ClassAsset},
+			deferredTax:  {Path: deferredTax, Class: cashflow.ClassLiability},
+			depreciation: {Path: depreciation, Class: cashflow.ClassExpense},
+			sales:        {Path: sales, Class: cashflow.ClassIncome},
+			opening:      {Path: opening, Class: cashflow.ClassEquity},
+		},
+		Activity: map[string]map[string]money.Amount{
+			Main:  {equipment: mm("-200"), depreciation: mm("200"), sales: mm("-500")},
+			"tax": {equipment: mm("-60"), depreciation: mm("60")},
+		},
+		Balance: map[string]map[string]money.Amount{
+			Main:  {equipment: mm("800"), deferredTax: mm("-10"), depreciation: mm("200"), sales: mm("-500"), opening: mm("-490")},
+			"tax": {equipment: mm("-100"), depreciation: mm("60"), opening: mm("40")},
+		},
+	}
+}
+
+func TestCodes(t *testing.T) {
+	l := testLedger()
+	tests := []struct {
+		list string
+		want []string
+		err  string
+	}{
+		{list: "main", want: []string{"main"}},
+		{list: " main , tax,", want: []string{"main", "tax"}},
+		{list: "main,budget", err: "book budget: not found"},
+		{list: " , ", err: "no books selected"},
+	}
+	for _, tt := range tests {
+		got, err := l.Codes(tt.list)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Codes(%q) error = %v, want %q", tt.list, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Codes(%q) = %v, %v; want %v", tt.list, got, err, tt.want)
+		}
+	}
+}
+
+func TestCombined(t *testing.T) {
+	mm := money.MustParse
+	l := testLedger()
+	tests := []struct {
+		kind  string
+		codes []string
+		want  map[string]money.Amount
+		paths []string
+	}{
+		{kind: TrialBalance, codes: []string{Main, "tax"},
+			want: map[string]money.Amount{equipment: mm("700"), deferredTax: mm("-10"), depreciation: mm("260"),
+				sales: mm("-500"), opening: mm("-450")},
+			paths: []string{equipment, deferredTax, opening, sales, depreciation}},
+		{kind: ProfitAndLoss, codes: []string{"tax"},
+			want:  map[string]money.Amount{equipment: mm("-60"), depreciation: mm("60")},
+			paths: []string{depreciation}},
+		{kind: ProfitAndLoss, codes: []string{Main, "tax"},
+			want:  map[string]money.Amount{equipment: mm("-260"), depreciation: mm("260"), sales: mm("-500")},
+			paths: []string{sales, depreciation}},
+	}
+	for _, tt := range tests {
+		if got := l.Combined(tt.kind, tt.codes); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Combined(%s, %v) = %v, want %v", tt.kind, tt.codes, got, tt.want)
+		}
+		if got := l.paths(tt.kind, tt.codes); !reflect.DeepEqual(got, tt.paths) {
+			t.Errorf("paths(%s, %v) = %v, want %v", tt.kind, tt.codes, got, tt.paths)
+		}
+	}
+}
+
+func TestDeferredTax(t *testing.T) {
+	mm := money.MustParse
+	r := testLedger().DeferredTax([]string{Main}, []string{Main, "tax"}, 0.25, deferredTax)
+
+	gaps := func(ds []*Difference) map[string]money.Amount {
+		out := map[string]money.Amount{}
+		for _, d := range ds {
+			out[d.Path] = d.Gap()
+		}
+		return out
+	}
+	if got, want := gaps(r.Temporary), map[string]money.Amount{equipment: mm("100")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("temporary = %v, want %v", got, want)
+	}
+	if got, want := gaps(r.Profit), map[string]money.Amount{depreciation: mm("-60")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("profit = %v, want %v", got, want)
+	}
+	if got, want := gaps(r.Equity), map[string]money.Amount{opening: mm("-40")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("equity = %v, want %v", got, want)
+	}
+	if r.OpeningTemporary != mm("40") || r.ClosingTemporary != mm("100") {
+		t.Errorf("temporary differences %s to %s, want 40.00 to 100.00", r.OpeningTemporary, r.ClosingTemporary)
+	}
+	if r.Opening() != mm("10") || r.Required() != mm("25") || r.Recorded != mm("-10") || r.Adjustment() != mm("15") {
+		t.Errorf("opening %s, required %s, recorded %s, adjustment %s", r.Opening(), r.Required(), r.Recorded, r.Adjustment())
+	}
+}
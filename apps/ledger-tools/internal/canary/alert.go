@@ -0,0 +1,109 @@
+package canary
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/correlation"
+)
+
+// Alert states.
+const (
+	AlertFailing   = "failing"
+	AlertRecovered = "recovered"
+)
+
+// Alerter posts to the alert webhook when runs start failing, every
+// Realert while they keep failing, and once when they recover.
+type Alerter struct {
+	Config Alert
+
+	mu        sync.Mutex
+	failing   bool
+	lastAlert time.Time
+}
+
+// alertBody is the webhook body; text is what a Slack incoming webhook
+// shows.
+type alertBody struct {
+	Text   string     `json:"text"`
+	Status string     `json:"status"`
+	Run    *RunRecord `json:"run"`
+}
+
+// Notify sends the alert a run calls for, if any.
+func (a *Alerter) Notify(ctx context.Context, r *RunRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var body *alertBody
+	switch {
+	case !r.OK && (!a.failing || r.FinishedAt.Sub(a.lastAlert) >= time.Duration(a.Config.Realert)):
+		text := fmt.Sprintf("Canary failing for %s: step %s: %s (run %s, trace %s)",
+			r.OrganizationID, r.FailedStep, r.Error, r.ID, r.TraceID)
+		if !r.Reversed() {
+			created, _ := json.Marshal(r.Created)
+			text += "; the reversal is incomplete, the run created " + string(created)
+		}
+		body = &alertBody{Text: text, Status: AlertFailing, Run: r}
+	case r.OK && a.failing:
+		body = &alertBody{Text: fmt.Sprintf("Canary recovered for %s (run %s)", r.OrganizationID, r.ID),
+			Status: AlertRecovered, Run: r}
+	}
+	// A run that needs no alert still updates the state; one whose alert
+	// cannot be sent is retried with the next run.
+	if body == nil {
+		a.failing = !r.OK
+		return nil
+	}
+	if err := a.send(ctx, body); err != nil {
+		return err
+	}
+	a.failing, a.lastAlert = !r.OK, r.FinishedAt
+	return nil
+}
+
+// send posts the alert. With a secret, the body is signed like report
+// webhooks: X-Signature is sha256= and the HMAC-SHA256 of
+// "<X-Timestamp>.<body>".
+func (a *Alerter) send(ctx context.Context, body *alertBody) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.Config.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.Config.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(a.Config.Secret))
+		mac.Write([]byte(ts + "."))
+		mac.Write(payload)
+		req.Header.Set("X-Timestamp", ts)
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := correlation.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("alert webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
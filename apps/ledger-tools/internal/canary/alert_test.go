@@ -0,0 +1,84 @@
+package canary
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+)
+
+func TestAlerterNotify(t *testing.T) {
+	var sent []alertBody
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write([]byte(r.Header.Get("X-Timestamp") + "."))
+		mac.Write(payload)
+		if r.Header.Get("X-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("bad signature %q", r.Header.Get("X-Signature"))
+		}
+		if fail {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		var b alertBody
+		json.Unmarshal(payload, &b)
+		sent = append(sent, b)
+	}))
+	defer srv.Close()
+
+	a := &Alerter{Config: Alert{URL: srv.URL, Secret: "s3cret", Realert: config.Duration(time.Hour)}}
+	start := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	failed := func(at time.Duration, reversed bool) *RunRecord {
+		steps := []*StepResult{{Name: StepInvoice, OK: false, Error: "timeout"}, {Name: StepReverse, OK: reversed}}
+		r := record(false, start.Add(at), steps...)
+		r.Created = Created{InvoiceID: "inv_1"}
+		return r
+	}
+	ok := func(at time.Duration) *RunRecord { return record(true, start.Add(at)) }
+
+	tests := []struct {
+		name string
+		run  *RunRecord
+		down bool
+		want string // status of the alert sent, "" for none
+		err  bool
+	}{
+		{name: "healthy", run: ok(0)},
+		{name: "starts failing", run: failed(5*time.Minute, true), want: AlertFailing},
+		{name: "still failing", run: failed(10*time.Minute, true)},
+		{name: "failing an hour later", run: failed(65*time.Minute, false), want: AlertFailing},
+		{name: "recovery that cannot be sent", run: ok(70 * time.Minute), down: true, err: true},
+		{name: "recovered", run: ok(75 * time.Minute), want: AlertRecovered},
+		{name: "healthy again", run: ok(80 * time.Minute)},
+	}
+	for _, tt := range tests {
+		sent, fail = nil, tt.down
+		err := a.Notify(context.Background(), tt.run)
+		if (err != nil) != tt.err {
+			t.Fatalf("%s: Notify error = %v", tt.name, err)
+		}
+		var got string
+		if len(sent) == 1 {
+			got = sent[0].Status
+		} else if len(sent) > 1 {
+			t.Fatalf("%s: %d alerts sent", tt.name, len(sent))
+		}
+		if got != tt.want {
+			t.Errorf("%s: alert %q, want %q", tt.name, got, tt.want)
+		}
+		if tt.name == "failing an hour later" && !strings.Contains(sent[0].Text, `the reversal is incomplete, the run created {"invoiceId":"inv_1"}`) {
+			t.Errorf("alert text = %q", sent[0].Text)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package canary
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/correlation"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// bff calls the BFF API as the canary user.
+type bff struct {
+	url   string
+	token string
+}
+
+// envelope is the BFF's response body.
+type envelope struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Error   string          `json:"error"`
+}
+
+// bffInvoice is the part of a BFF invoice the canary checks.
+type bffInvoice struct {
+	ID          string       `json:"id"`
+	Status      string       `json:"status"`
+	TotalAmount money.Amount `json:"totalAmount"`
+	BalanceDue  money.Amount `json:"balanceDue"`
+}
+
+// login signs in and returns the organizations the token may post to; the
+// token is bound to the first.
+func (c *bff) login(ctx context.Context, email, password string) ([]string, error) {
+	var out struct {
+		Token         string `json:"token"`
+		Organizations []struct {
+			ID string `json:"id"`
+		} `json:"organizations"`
+	}
+	if err := c.post(ctx, "/auth/login", "", map[string]string{"email": email, "password": password}, &out); err != nil {
+		return nil, err
+	}
+	if out.Token == "" {
+		return nil, fmt.Errorf("login returned no token")
+	}
+	c.token = out.Token
+	orgs := make([]string, len(out.Organizations))
+	for i, o := range out.Organizations {
+		orgs[i] = o.ID
+	}
+	return orgs, nil
+}
+
+// post sends body as JSON and decodes the envelope's data into out. A
+// non-empty key is sent as the Idempotency-Key, so a retried request is
+// answered from the BFF's cache instead of posting twice.
+func (c *bff) post(ctx context.Context, path, key string, body, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+
+	resp, err := correlation.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil || resp.StatusCode/100 != 2 || !env.Success {
+		msg := env.Error
+		if msg == "" {
+			if len(raw) > 512 {
+				raw = raw[:512]
+			}
+			msg = strings.TrimSpace(string(raw))
+		}
+		return fmt.Errorf("BFF POST %s: %s: %s", path, resp.Status, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(env.Data, out); err != nil {
+		return fmt.Errorf("BFF POST %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,402 @@
+// Package canary runs a synthetic transaction through the live stack.
+//
+// The BFF and OA health endpoints only say the processes are up; a posting
+// can still fail behind them. A canary run logs in to the BFF as a user of
+// a dedicated canary organization, creates a customer, creates and
+// confirms an invoice, records its payment, posts the sale to the
+// organization's OA org and checks that both ledgers still balance. Then
+// it reverses everything it created, whether or not the run got that far,
+// so the canary books stay at zero. Every run is kept in canary_runs with
+// the latency and outcome of each step.
+package canary
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/correlation"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+)
+
+// Steps of a run, in order. reverse runs after any of the others fails.
+const (
+	StepLogin        = "login"
+	StepCustomer     = "customer"
+	StepInvoice      = "invoice"
+	StepPayment      = "payment"
+	StepOAPost       = "oa_post"
+	StepTrialBalance = "trial_balance"
+	StepReverse      = "reverse"
+)
+
+// Steps lists the steps in the order they run.
+var Steps = []string{StepLogin, StepCustomer, StepInvoice, StepPayment, StepOAPost, StepTrialBalance, StepReverse}
+
+// Poster posts OA transactions; *oa.Client is one.
+type Poster interface {
+	PostTransaction(ctx context.Context, orgID string, t *oa.Transaction) error
+}
+
+// Monitor runs the canary.
+type Monitor struct {
+	Config  *Config
+	DB      *sql.DB // cashflowdb
+	OA      *sql.DB
+	Poster  Poster
+	Metrics *Metrics
+	Alerter *Alerter // optional
+	Logger  *log.Logger
+}
+
+// Run starts a run every Interval until ctx is cancelled.
+func (m *Monitor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(m.Config.Interval))
+	defer ticker.Stop()
+	for {
+		m.RunOnce(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce runs the canary once, records the run and its metrics and sends
+// any alert due.
+func (m *Monitor) RunOnce(ctx context.Context, now time.Time) *RunRecord {
+	ctx, trace := correlation.Ensure(ctx)
+	r := &RunRecord{ID: cashflow.NewID("cnr"), OrganizationID: m.Config.OrganizationID, TraceID: trace, StartedAt: now}
+	runCtx, cancel := context.WithTimeout(ctx, time.Duration(m.Config.Timeout))
+	m.execute(runCtx, r)
+	cancel()
+	r.FinishedAt = time.Now()
+
+	if err := insertRun(ctx, m.DB, r); err != nil {
+		m.Logger.Printf("record run %s: %v", r.ID, err)
+	}
+	if m.Metrics != nil {
+		m.Metrics.Observe(r)
+	}
+	if r.OK {
+		m.Logger.Printf("run %s ok in %s (trace %s)", r.ID, r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond), trace)
+	} else {
+		m.Logger.Printf("run %s failed at %s: %s (trace %s)", r.ID, r.FailedStep, r.Error, trace)
+	}
+	if m.Alerter != nil {
+		if err := m.Alerter.Notify(ctx, r); err != nil {
+			m.Logger.Printf("alert: %v", err)
+		}
+	}
+	return r
+}
+
+// run is the state of one run: what it created, so it can be reversed.
+type run struct {
+	m         *Monitor
+	record    *RunRecord
+	bff       *bff
+	oaOrg     string
+	customer  string
+	invoice   string
+	journals  []string // cashflow journals posted by the BFF
+	oaTx      string
+	oaSplits  []*oa.TxSplit
+	reversals []string // cashflow reversal journals
+}
+
+func (m *Monitor) execute(ctx context.Context, r *RunRecord) {
+	cr := &run{m: m, record: r, bff: &bff{url: m.Config.BFF.URL}}
+	steps := []struct {
+		name string
+		fn   func(context.Context) error
+	}{
+		{StepLogin, cr.login},
+		{StepCustomer, cr.createCustomer},
+		{StepInvoice, cr.createInvoice},
+		{StepPayment, cr.recordPayment},
+		{StepOAPost, cr.postOA},
+		{StepTrialBalance, cr.checkBalances},
+	}
+	for _, s := range steps {
+		if !cr.step(ctx, s.name, s.fn) {
+			break
+		}
+	}
+	if cr.created() {
+		// The reversal gets its own deadline: a run that timed out must
+		// still clean up after itself.
+		rctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Duration(m.Config.Timeout))
+		defer cancel()
+		cr.step(rctx, StepReverse, cr.reverse)
+	}
+	r.OK = r.FailedStep == ""
+	r.Created = Created{CustomerID: cr.customer, InvoiceID: cr.invoice, Journals: cr.journals,
+		OATransactionID: cr.oaTx, Reversals: cr.reversals}
+}
+
+// step times fn and records its outcome; the first failure is the run's.
+func (cr *run) step(ctx context.Context, name string, fn func(context.Context) error) bool {
+	start := time.Now()
+	err := fn(ctx)
+	s := &StepResult{Name: name, OK: err == nil, Seconds: time.Since(start).Seconds()}
+	if err != nil {
+		s.Error = err.Error()
+		if cr.record.FailedStep == "" {
+			cr.record.FailedStep, cr.record.Error = name, s.Error
+		}
+	}
+	cr.record.Steps = append(cr.record.Steps, s)
+	return err == nil
+}
+
+func (cr *run) created() bool {
+	return cr.customer != "" || cr.invoice != "" || len(cr.journals) > 0 || cr.oaTx != ""
+}
+
+func (cr *run) login(ctx context.Context) error {
+	cfg := cr.m.Config
+	orgs, err := cr.bff.login(ctx, cfg.BFF.Email, cfg.BFF.Password)
+	if err != nil {
+		return err
+	}
+	// The BFF posts into the token's organization, which is the user's
+	// first; anything else would put canary postings in real books.
+	if len(orgs) == 0 || orgs[0] != cfg.OrganizationID {
+		return fmt.Errorf("%s would post to %v, not the canary organization %s", cfg.BFF.Email, orgs, cfg.OrganizationID)
+	}
+	cr.oaOrg, err = oa.OrgForOrganization(ctx, cr.m.DB, cfg.OrganizationID)
+	return err
+}
+
+func (cr *run) createCustomer(ctx context.Context) error {
+	var out struct {
+		ID string `json:"id"`
+	}
+	body := map[string]any{
+		"name":        "Canary " + cr.record.ID,
+		"displayName": "Canary " + cr.record.ID,
+		"notes":       "Synthetic monitor run " + cr.record.ID + "; reversed by the run",
+	}
+	if err := cr.bff.post(ctx, "/api/customers", "", body, &out); err != nil {
+		return err
+	}
+	if out.ID == "" {
+		return errors.New("customer created without an id")
+	}
+	cr.customer = out.ID
+	return nil
+}
+
+func (cr *run) createInvoice(ctx context.Context) error {
+	amount := cr.m.Config.Amount
+	var inv bffInvoice
+	body := map[string]any{
+		"invoiceNumber": "CANARY-" + cr.record.ID,
+		"customerId":    cr.customer,
+		"issueDate":     cr.record.StartedAt.Format(time.DateOnly),
+		"items":         []map[string]any{{"itemName": "Canary check", "quantity": 1, "rate": amount.Float()}},
+	}
+	if err := cr.bff.post(ctx, "/api/invoices", cr.record.ID+"-invoice", body, &inv); err != nil {
+		return err
+	}
+	if inv.ID == "" {
+		return errors.New("invoice created without an id")
+	}
+	cr.invoice = inv.ID
+	if inv.TotalAmount != amount {
+		return fmt.Errorf("invoice %s totals %s, want %s", inv.ID, inv.TotalAmount, amount)
+	}
+
+	var confirmed struct {
+		Invoice   bffInvoice `json:"invoice"`
+		JournalID string     `json:"journalId"`
+	}
+	if err := cr.bff.post(ctx, "/api/invoices/"+inv.ID+"/confirm", cr.record.ID+"-confirm", struct{}{}, &confirmed); err != nil {
+		return err
+	}
+	if confirmed.JournalID == "" {
+		return fmt.Errorf("invoice %s confirmed without a journal", inv.ID)
+	}
+	cr.journals = append(cr.journals, confirmed.JournalID)
+	return nil
+}
+
+func (cr *run) recordPayment(ctx context.Context) error {
+	depositTo := cr.m.Config.DepositTo
+	if depositTo == "" {
+		var err error
+		if depositTo, err = cashflow.AccountByType(ctx, cr.m.DB, cr.m.Config.OrganizationID, "bank", "cash"); err != nil {
+			return err
+		}
+	}
+	var out struct {
+		Invoice   bffInvoice `json:"invoice"`
+		JournalID string     `json:"journalId"`
+	}
+	body := map[string]any{"invoiceId": cr.invoice, "amount": cr.m.Config.Amount.Float(), "depositTo": depositTo}
+	if err := cr.bff.post(ctx, "/api/payments", cr.record.ID+"-payment", body, &out); err != nil {
+		return err
+	}
+	if out.JournalID == "" {
+		return fmt.Errorf("payment on %s recorded without a journal", cr.invoice)
+	}
+	cr.journals = append(cr.journals, out.JournalID)
+	if out.Invoice.Status != "paid" {
+		return fmt.Errorf("invoice %s is %s after paying it in full", cr.invoice, out.Invoice.Status)
+	}
+	return nil
+}
+
+// postOA posts the sale to the OA org; the BFF does not, so this is what
+// exercises the OA API.
+func (cr *run) postOA(ctx context.Context) error {
+	splits, err := cr.splits(ctx)
+	if err != nil {
+		return err
+	}
+	t := &oa.Transaction{
+		ID:          oa.NewTransactionID(),
+		Date:        cr.record.StartedAt,
+		Description: "Canary " + cr.record.ID,
+		Splits:      splits,
+	}
+	// Keep the id before posting: a post that times out may still land.
+	cr.oaTx, cr.oaSplits = t.ID, splits
+	return cr.m.Poster.PostTransaction(ctx, cr.oaOrg, t)
+}
+
+// splits returns the sale's OA splits: the amount debited to one account
+// and credited to the other, in each account's precision.
+func (cr *run) splits(ctx context.Context) ([]*oa.TxSplit, error) {
+	chart, err := oa.LoadChart(ctx, cr.m.OA, cr.oaOrg)
+	if err != nil {
+		return nil, err
+	}
+	accounts := cr.m.Config.OA
+	var out []*oa.TxSplit
+	for i, id := range []string{accounts.DebitAccount, accounts.CreditAccount} {
+		id = oa.NormalizeID(id)
+		a := chart.Accounts[id]
+		if a == nil {
+			return nil, fmt.Errorf("OA account %s is not in org %s", id, cr.oaOrg)
+		}
+		minor := cr.m.Config.Amount.Minor(a.Precision)
+		if i == 1 {
+			minor = -minor
+		}
+		out = append(out, &oa.TxSplit{AccountID: id, Amount: minor, NativeAmount: minor})
+	}
+	return out, nil
+}
+
+// checkBalances checks the run's postings and both trial balances.
+func (cr *run) checkBalances(ctx context.Context) error {
+	for _, id := range cr.journals {
+		if err := checkJournal(ctx, cr.m.DB, id); err != nil {
+			return err
+		}
+	}
+	var status string
+	var due money.Amount
+	if err := cr.m.DB.QueryRowContext(ctx, `SELECT status, balanceDue FROM invoices WHERE id = ?`, cr.invoice).
+		Scan(&status, &due); err != nil {
+		return fmt.Errorf("invoice %s: %w", cr.invoice, err)
+	}
+	if status != "paid" || due != 0 {
+		return fmt.Errorf("invoice %s is %s with %s due", cr.invoice, status, due)
+	}
+	if d, c, err := cashflowTrialBalance(ctx, cr.m.DB, cr.m.Config.OrganizationID); err != nil {
+		return err
+	} else if d != c {
+		return fmt.Errorf("cashflow trial balance is off: DR %s, CR %s", d, c)
+	}
+
+	ids, err := oa.TransactionSplits(ctx, cr.m.OA, cr.oaTx)
+	if err != nil {
+		return err
+	}
+	if len(ids) != len(cr.oaSplits) {
+		return fmt.Errorf("OA transaction %s has %d live splits, want %d", cr.oaTx, len(ids), len(cr.oaSplits))
+	}
+	sum, err := oaTrialBalance(ctx, cr.m.OA, cr.oaOrg)
+	if err != nil {
+		return err
+	}
+	if sum != 0 {
+		return fmt.Errorf("OA trial balance of org %s is off by %d", cr.oaOrg, sum)
+	}
+	return nil
+}
+
+// reverse undoes what the run created: the OA transaction by an opposite
+// one, the BFF journals by reversal journals, and the invoice and customer
+// are voided and deactivated. A failed OA reversal does not stop the
+// cashflow one; both errors are reported.
+func (cr *run) reverse(ctx context.Context) error {
+	var errs []error
+	if cr.oaTx != "" {
+		if err := cr.reverseOA(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("OA transaction %s: %w", cr.oaTx, err))
+		}
+	}
+	err := db.InTx(ctx, cr.m.DB, func(tx *sql.Tx) error {
+		for i := len(cr.journals) - 1; i >= 0; i-- {
+			id, err := reverseJournal(ctx, tx, cr.journals[i], cr.record.ID, cr.record.StartedAt)
+			if err != nil {
+				return err
+			}
+			cr.reversals = append(cr.reversals, id)
+		}
+		if cr.invoice != "" {
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE invoices SET status = 'void', balanceDue = 0, updatedAt = ? WHERE id = ?`, time.Now(), cr.invoice); err != nil {
+				return err
+			}
+		}
+		if cr.customer != "" {
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE customers SET isActive = false, updatedAt = ? WHERE id = ?`, time.Now(), cr.customer); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		cr.reversals = nil
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+func (cr *run) reverseOA(ctx context.Context) error {
+	if cr.oaSplits == nil {
+		return nil
+	}
+	// The post may have failed; only reverse what OA has.
+	ids, err := oa.TransactionSplits(ctx, cr.m.OA, cr.oaTx)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	splits := make([]*oa.TxSplit, len(cr.oaSplits))
+	for i, s := range cr.oaSplits {
+		splits[i] = &oa.TxSplit{AccountID: s.AccountID, Amount: -s.Amount, NativeAmount: -s.NativeAmount}
+	}
+	return cr.m.Poster.PostTransaction(ctx, cr.oaOrg, &oa.Transaction{
+		ID:          oa.NewTransactionID(),
+		Date:        cr.record.StartedAt,
+		Description: "Reversal of canary " + cr.record.ID,
+		Data:        `{"reverses":"` + cr.oaTx + `"}`,
+		Splits:      splits,
+	})
+}
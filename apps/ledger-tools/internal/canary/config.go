@@ -0,0 +1,96 @@
+package canary
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Config is the monitor configuration file.
+type Config struct {
+	// Interval is how often a run starts; Timeout bounds one run, reversal
+	// included.
+	Interval config.Duration `json:"interval"`
+	Timeout  config.Duration `json:"timeout"`
+	BFF      BFF             `json:"bff"`
+	// OrganizationID is the canary organization. The BFF user must belong
+	// to it first, since the BFF posts into the token's first organization;
+	// a run stops at login otherwise.
+	OrganizationID string `json:"organizationId"`
+	// DepositTo is the ledger account payments are deposited to, by
+	// default the organization's first bank or cash account.
+	DepositTo string       `json:"depositTo,omitempty"`
+	Amount    money.Amount `json:"amount"`
+	// OA is where the sale is posted in the organization's OA org.
+	OA OAAccounts `json:"oa"`
+	// MetricsListen is where run serves /metrics and /healthz.
+	MetricsListen string `json:"metricsListen,omitempty"`
+	Alert         Alert  `json:"alert"`
+}
+
+// BFF is the API the canary drives and the user it logs in as.
+type BFF struct {
+	URL      string `json:"url"`
+	Email    string `json:"email"`
+	Password string `json:"password"` // literal, or env:VAR_NAME
+}
+
+// OAAccounts are the OA accounts debited and credited with the sale.
+type OAAccounts struct {
+	DebitAccount  string `json:"debitAccount"`
+	CreditAccount string `json:"creditAccount"`
+}
+
+// Alert is the webhook told when runs start failing and when they recover.
+type Alert struct {
+	// URL receives a Slack-style {"text": ...} JSON body.
+	URL    string `json:"url,omitempty"`
+	Secret string `json:"secret,omitempty"` // literal, or env:VAR_NAME
+	// Realert repeats the failing alert while runs keep failing.
+	Realert config.Duration `json:"realert"`
+}
+
+// LoadConfig reads and validates a configuration file.
+func LoadConfig(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{
+		Interval: config.Duration(5 * time.Minute), Timeout: config.Duration(time.Minute),
+		BFF: BFF{URL: "http://localhost:3001"}, Amount: money.MustParse("1.00"),
+		Alert: Alert{Realert: config.Duration(time.Hour)},
+	}
+	if err := json.Unmarshal(raw, cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if cfg.OrganizationID == "" || cfg.BFF.Email == "" {
+		return nil, fmt.Errorf("%s: organizationId and bff.email are required", path)
+	}
+	if cfg.OA.DebitAccount == "" || cfg.OA.CreditAccount == "" {
+		return nil, fmt.Errorf("%s: oa.debitAccount and oa.creditAccount are required", path)
+	}
+	if cfg.Amount <= 0 {
+		return nil, fmt.Errorf("%s: amount must be positive", path)
+	}
+	cfg.BFF.URL = strings.TrimRight(cfg.BFF.URL, "/")
+	cfg.BFF.Password = secret(cfg.BFF.Password)
+	if cfg.BFF.Password == "" {
+		return nil, fmt.Errorf("%s: bff.password is empty", path)
+	}
+	cfg.Alert.Secret = secret(cfg.Alert.Secret)
+	return cfg, nil
+}
+
+// secret resolves an env:VAR_NAME reference.
+func secret(s string) string {
+	if name, ok := strings.CutPrefix(s, "env:"); ok {
+		return os.Getenv(name)
+	}
+	return s
+}
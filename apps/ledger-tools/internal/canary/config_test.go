@@ -0,0 +1,54 @@
+package canary
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("CANARY_TEST_PASSWORD", "from-env")
+	valid := `"organizationId": "org_canary", "bff": {"email": "canary@example.com", "password": "env:CANARY_TEST_PASSWORD"},
+		"oa": {"debitAccount": "Assets:Bank", "creditAccount": "Income:Sales"}`
+	tests := []struct {
+		name string
+		json string
+		err  string
+	}{
+		{name: "defaults", json: `{` + valid + `}`},
+		{name: "missing organization", json: `{"bff": {"email": "a@b.c", "password": "x"}}`, err: "organizationId and bff.email are required"},
+		{name: "missing OA accounts", json: `{"organizationId": "o", "bff": {"email": "a@b.c", "password": "x"}}`,
+			err: "oa.debitAccount and oa.creditAccount are required"},
+		{name: "zero amount", json: `{` + valid + `, "amount": "0"}`, err: "amount must be positive"},
+		{name: "empty password", json: `{"organizationId": "o", "bff": {"email": "a@b.c", "password": "env:CANARY_UNSET_VAR"},
+			"oa": {"debitAccount": "a", "creditAccount": "b"}}`, err: "bff.password is empty"},
+		{name: "bad duration", json: `{` + valid + `, "interval": "often"}`, err: "invalid duration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "canary.json")
+			if err := os.WriteFile(path, []byte(tt.json), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := LoadConfig(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("LoadConfig error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if time.Duration(cfg.Interval) != 5*time.Minute || time.Duration(cfg.Timeout) != time.Minute ||
+				time.Duration(cfg.Alert.Realert) != time.Hour || cfg.Amount != money.MustParse("1.00") ||
+				cfg.BFF.URL != "http://localhost:3001" || cfg.BFF.Password != "from-env" {
+				t.Errorf("config = %+v", cfg)
+			}
+		})
+	}
+}
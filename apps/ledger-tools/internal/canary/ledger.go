@@ -0,0 +1,103 @@
+package canary
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+)
+
+// checkJournal checks that a journal is live, that its entries balance
+// and that its header totals match them.
+func checkJournal(ctx context.Context, q cashflow.Querier, id string) error {
+	var status string
+	var totalDebit, totalCredit, debit, credit money.Amount
+	var entries int
+	err := q.QueryRowContext(ctx, `
+		SELECT j.status, j.totalDebit, j.totalCredit, COUNT(e.id),
+		       COALESCE(SUM(e.debitAmount), 0), COALESCE(SUM(e.creditAmount), 0)
+		FROM journals j LEFT JOIN journal_entries e ON e.journalId = j.id
+		WHERE j.id = ?
+		GROUP BY j.id, j.status, j.totalDebit, j.totalCredit`, id).
+		Scan(&status, &totalDebit, &totalCredit, &entries, &debit, &credit)
+	if err != nil {
+		return fmt.Errorf("journal %s: %w", id, err)
+	}
+	switch {
+	case !strings.Contains(cashflow.LiveJournalStatuses, "'"+status+"'"):
+		return fmt.Errorf("journal %s is %s", id, status)
+	case entries == 0:
+		return fmt.Errorf("journal %s has no entries", id)
+	case debit != credit:
+		return fmt.Errorf("journal %s out of balance: DR %s, CR %s", id, debit, credit)
+	case totalDebit != debit || totalCredit != credit:
+		return fmt.Errorf("journal %s totals %s/%s but its entries %s/%s", id, totalDebit, totalCredit, debit, credit)
+	}
+	return nil
+}
+
+// cashflowTrialBalance sums the debits and credits of the organization's
+// live journals.
+func cashflowTrialBalance(ctx context.Context, q cashflow.Querier, organizationID string) (debit, credit money.Amount, err error) {
+	err = q.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(e.debitAmount), 0), COALESCE(SUM(e.creditAmount), 0)
+		FROM journal_entries e JOIN journals j ON j.id = e.journalId
+		WHERE j.organizationId = ? AND j.status IN `+cashflow.LiveJournalStatuses, organizationID).Scan(&debit, &credit)
+	return debit, credit, err
+}
+
+// oaTrialBalance sums the org's live splits, which is zero when its books
+// balance.
+func oaTrialBalance(ctx context.Context, q cashflow.Querier, orgID string) (int64, error) {
+	var sum int64
+	err := q.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(s.nativeAmount), 0)
+		FROM split s JOIN transaction t ON t.id = s.transactionId
+		WHERE t.orgId = UNHEX(?) AND t.deleted = false AND s.deleted = false`, oa.NormalizeID(orgID)).Scan(&sum)
+	return sum, err
+}
+
+// reverseJournal posts a journal with the debits and credits of id swapped
+// and returns its id. Posted entries are immutable, so this is how a
+// journal is undone.
+func reverseJournal(ctx context.Context, q cashflow.Querier, id, runID string, date time.Time) (string, error) {
+	var organizationID, number string
+	if err := q.QueryRowContext(ctx, `SELECT organizationId, journalNumber FROM journals WHERE id = ?`, id).
+		Scan(&organizationID, &number); err != nil {
+		return "", fmt.Errorf("journal %s: %w", id, err)
+	}
+	rows, err := q.QueryContext(ctx, `
+		SELECT accountId, debitAmount, creditAmount FROM journal_entries WHERE journalId = ? ORDER BY id`, id)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	j := &cashflow.Journal{
+		OrganizationID: organizationID,
+		Number:         "REV-" + number,
+		Date:           date,
+		Reference:      id,
+		Notes:          "Reversal by canary run " + runID,
+	}
+	for rows.Next() {
+		var l cashflow.JournalLine
+		if err := rows.Scan(&l.AccountID, &l.Credit, &l.Debit); err != nil {
+			return "", err
+		}
+		l.Description = "Reversal of " + number
+		j.Lines = append(j.Lines, l)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	rows.Close()
+	if err := cashflow.PostJournal(ctx, q, j); err != nil {
+		return "", err
+	}
+	return j.ID, nil
+}
@@ -0,0 +1,154 @@
+package canary
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metrics keeps the outcome of the latest runs for /metrics and /healthz.
+type Metrics struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+// Stats are the counters and gauges Metrics exposes.
+type Stats struct {
+	Runs        int64                 `json:"runs"`
+	FailedRuns  int64                 `json:"failedRuns"`
+	LastRunOK   bool                  `json:"lastRunOk"`
+	LastRun     float64               `json:"lastRunTimestamp"`     // Unix seconds
+	LastSuccess float64               `json:"lastSuccessTimestamp"` // Unix seconds
+	Steps       map[string]*StepStats `json:"steps"`
+}
+
+// StepStats are one step's latest latency and outcome and its totals.
+type StepStats struct {
+	Seconds  float64 `json:"seconds"`
+	OK       bool    `json:"ok"`
+	Runs     int64   `json:"runs"`
+	Failures int64   `json:"failures"`
+}
+
+// Observe records a run.
+func (m *Metrics) Observe(r *RunRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := &m.stats
+	if s.Steps == nil {
+		s.Steps = map[string]*StepStats{}
+	}
+	s.Runs++
+	s.LastRunOK = r.OK
+	s.LastRun = float64(r.FinishedAt.UnixMilli()) / 1000
+	if r.OK {
+		s.LastSuccess = s.LastRun
+	} else {
+		s.FailedRuns++
+	}
+	for _, step := range r.Steps {
+		st := s.Steps[step.Name]
+		if st == nil {
+			st = &StepStats{}
+			s.Steps[step.Name] = st
+		}
+		st.Seconds, st.OK = step.Seconds, step.OK
+		st.Runs++
+		if !step.OK {
+			st.Failures++
+		}
+	}
+}
+
+// Stats returns a copy of the current stats.
+func (m *Metrics) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := m.stats
+	out.Steps = map[string]*StepStats{}
+	for name, st := range m.stats.Steps {
+		c := *st
+		out.Steps[name] = &c
+	}
+	return out
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format, or as JSON
+// with ?format=json.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stats := m.Stats()
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	WriteMetrics(w, stats)
+}
+
+// Healthz answers 200 while the latest run succeeded and 503 after a
+// failed one; before the first run it answers 200.
+func (m *Metrics) Healthz(w http.ResponseWriter, r *http.Request) {
+	stats := m.Stats()
+	if stats.Runs > 0 && !stats.LastRunOK {
+		http.Error(w, "canary failing", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// runMetrics are the series of the whole canary.
+var runMetrics = []struct {
+	name, kind, help string
+	value            func(s Stats) float64
+}{
+	{"canary_runs_total", "counter", "Canary runs.", func(s Stats) float64 { return float64(s.Runs) }},
+	{"canary_failed_runs_total", "counter", "Canary runs with a failed step.", func(s Stats) float64 { return float64(s.FailedRuns) }},
+	{"canary_up", "gauge", "Whether the latest run succeeded.", func(s Stats) float64 { return boolValue(s.LastRunOK) }},
+	{"canary_last_run_timestamp_seconds", "gauge", "When the latest run finished.", func(s Stats) float64 { return s.LastRun }},
+	{"canary_last_success_timestamp_seconds", "gauge", "When the latest successful run finished.", func(s Stats) float64 { return s.LastSuccess }},
+}
+
+// stepMetrics are the per-step series.
+var stepMetrics = []struct {
+	name, kind, help string
+	value            func(s *StepStats) float64
+}{
+	{"canary_step_duration_seconds", "gauge", "How long the step took in the latest run that reached it.", func(s *StepStats) float64 { return s.Seconds }},
+	{"canary_step_success", "gauge", "Whether the step succeeded in the latest run that reached it.", func(s *StepStats) float64 { return boolValue(s.OK) }},
+	{"canary_step_runs_total", "counter", "Runs that reached the step.", func(s *StepStats) float64 { return float64(s.Runs) }},
+	{"canary_step_failures_total", "counter", "Runs in which the step failed.", func(s *StepStats) float64 { return float64(s.Failures) }},
+}
+
+// WriteMetrics writes stats in the Prometheus text format.
+func WriteMetrics(w io.Writer, stats Stats) {
+	for _, m := range runMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		fmt.Fprintf(w, "%s %s\n", m.name, strconv.FormatFloat(m.value(stats), 'g', -1, 64))
+	}
+	for _, m := range stepMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, name := range Steps {
+			if st := stats.Steps[name]; st != nil {
+				fmt.Fprintf(w, "%s{step=%s} %s\n", m.name, quote(name), strconv.FormatFloat(m.value(st), 'g', -1, 64))
+			}
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// quote escapes a label value.
+func quote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,111 @@
+package canary
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func record(ok bool, finished time.Time, steps ...*StepResult) *RunRecord {
+	r := &RunRecord{ID: "run1", OrganizationID: "org_canary", TraceID: "trace1", FinishedAt: finished, OK: ok, Steps: steps}
+	for _, s := range steps {
+		if !s.OK && r.FailedStep == "" {
+			r.FailedStep, r.Error = s.Name, s.Error
+		}
+	}
+	return r
+}
+
+func TestMetrics(t *testing.T) {
+	at := time.Unix(1700000000, 500e6)
+	m := &Metrics{}
+
+	healthz := func() int {
+		w := httptest.NewRecorder()
+		m.Healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		return w.Code
+	}
+	if code := healthz(); code != http.StatusOK {
+		t.Errorf("healthz before any run = %d", code)
+	}
+
+	m.Observe(record(true, at, &StepResult{Name: StepLogin, OK: true, Seconds: 0.25},
+		&StepResult{Name: StepReverse, OK: true, Seconds: 1}))
+	m.Observe(record(false, at.Add(time.Minute), &StepResult{Name: StepLogin, OK: true, Seconds: 0.5},
+		&StepResult{Name: StepCustomer, OK: false, Seconds: 2, Error: "502 Bad Gateway"}))
+
+	s := m.Stats()
+	if s.Runs != 2 || s.FailedRuns != 1 || s.LastRunOK || s.LastRun != 1700000060.5 || s.LastSuccess != 1700000000.5 {
+		t.Errorf("stats = %+v", s)
+	}
+	if st := s.Steps[StepLogin]; st.Runs != 2 || st.Failures != 0 || st.Seconds != 0.5 || !st.OK {
+		t.Errorf("login = %+v", st)
+	}
+	if st := s.Steps[StepCustomer]; st.Runs != 1 || st.Failures != 1 || st.OK {
+		t.Errorf("customer = %+v", st)
+	}
+	// Stats is a copy.
+	s.Steps[StepLogin].Runs = 99
+	if m.Stats().Steps[StepLogin].Runs != 2 {
+		t.Errorf("Stats shares its step counters")
+	}
+	if code := healthz(); code != http.StatusServiceUnavailable {
+		t.Errorf("healthz after a failed run = %d", code)
+	}
+
+	var b strings.Builder
+	WriteMetrics(&b, m.Stats())
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE canary_runs_total counter\ncanary_runs_total 2\n",
+		"canary_failed_runs_total 1\n",
+		"canary_up 0\n",
+		"canary_last_run_timestamp_seconds 1.7000000605e+09\n",
+		`canary_step_duration_seconds{step="login"} 0.5` + "\n",
+		`canary_step_success{step="customer"} 0` + "\n",
+		`canary_step_failures_total{step="customer"} 1` + "\n",
+		`canary_step_runs_total{step="reverse"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `step="payment"`) {
+		t.Errorf("metrics list a step no run reached")
+	}
+	// Steps are written in run order.
+	if strings.Index(out, `canary_step_runs_total{step="login"}`) > strings.Index(out, `canary_step_runs_total{step="reverse"}`) {
+		t.Errorf("steps are out of order:\n%s", out)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{in: "login", want: `"login"`},
+		{in: `a"b\c` + "\n", want: `"a\"b\\c\n"`},
+	}
+	for _, tt := range tests {
+		if got := quote(tt.in); got != tt.want {
+			t.Errorf("quote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReversed(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []*StepResult
+		want  bool
+	}{
+		{name: "nothing to reverse", steps: []*StepResult{{Name: StepLogin, OK: false}}, want: true},
+		{name: "reversed", steps: []*StepResult{{Name: StepInvoice, OK: false}, {Name: StepReverse, OK: true}}, want: true},
+		{name: "reversal failed", steps: []*StepResult{{Name: StepInvoice, OK: true}, {Name: StepReverse, OK: false}}},
+	}
+	for _, tt := range tests {
+		if got := (&RunRecord{Steps: tt.steps}).Reversed(); got != tt.want {
+			t.Errorf("%s: Reversed = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package canary
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Tables are the cashflowdb tables owned by the canary module.
+var Tables = []schema.Table{
+	{
+		Name: "canary_runs",
+		Create: `CREATE TABLE IF NOT EXISTS canary_runs (
+  id VARCHAR(191) NOT NULL,
+  organization_id VARCHAR(191) NOT NULL,
+  trace_id VARCHAR(64) NOT NULL,
+  started_at DATETIME(3) NOT NULL,
+  finished_at DATETIME(3) NOT NULL,
+  ok BOOLEAN NOT NULL,
+  failed_step VARCHAR(32) NULL,
+  error TEXT NULL,
+  steps TEXT NOT NULL,
+  created TEXT NOT NULL,
+  PRIMARY KEY (id),
+  INDEX canary_runs_org_idx (organization_id, started_at)
+) ENGINE=InnoDB`,
+	},
+}
+
+// RunRecord is one canary run.
+type RunRecord struct {
+	ID             string        `json:"id"`
+	OrganizationID string        `json:"organizationId"`
+	TraceID        string        `json:"traceId"`
+	StartedAt      time.Time     `json:"startedAt"`
+	FinishedAt     time.Time     `json:"finishedAt"`
+	OK             bool          `json:"ok"`
+	FailedStep     string        `json:"failedStep,omitempty"`
+	Error          string        `json:"error,omitempty"`
+	Steps          []*StepResult `json:"steps"`
+	Created        Created       `json:"created"`
+}
+
+// StepResult is the outcome of one step of a run.
+type StepResult struct {
+	Name    string  `json:"name"`
+	OK      bool    `json:"ok"`
+	Seconds float64 `json:"seconds"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// Created is what a run created and what reversed it, so anything a failed
+// reversal left behind can be found.
+type Created struct {
+	CustomerID      string   `json:"customerId,omitempty"`
+	InvoiceID       string   `json:"invoiceId,omitempty"`
+	Journals        []string `json:"journals,omitempty"`
+	OATransactionID string   `json:"oaTransactionId,omitempty"`
+	Reversals       []string `json:"reversals,omitempty"`
+}
+
+// Reversed reports whether everything the run created was reversed.
+func (r *RunRecord) Reversed() bool {
+	for _, s := range r.Steps {
+		if s.Name == StepReverse {
+			return s.OK
+		}
+	}
+	return true
+}
+
+func insertRun(ctx context.Context, q cashflow.Querier, r *RunRecord) error {
+	steps, err := json.Marshal(r.Steps)
+	if err != nil {
+		return err
+	}
+	created, err := json.Marshal(r.Created)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, `
+		INSERT INTO canary_runs (id, organization_id, trace_id, started_at, finished_at, ok, failed_step, error, steps, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.ID, r.OrganizationID, r.TraceID, r.StartedAt, r.FinishedAt, r.OK,
+		cashflow.NullString(r.FailedStep), cashflow.NullString(r.Error), steps, created)
+	return err
+}
+
+// Runs returns an organization's latest runs, newest first; failed limits
+// them to failed runs.
+func Runs(ctx context.Context, q cashflow.Querier, organizationID string, failed bool, limit int) ([]*RunRecord, error) {
+	query := `
+		SELECT id, organization_id, trace_id, started_at, finished_at, ok, failed_step, error, steps, created
+		FROM canary_runs WHERE organization_id = ?`
+	if failed {
+		query += ` AND ok = false`
+	}
+	rows, err := q.QueryContext(ctx, query+` ORDER BY started_at DESC LIMIT ?`, organizationID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*RunRecord
+	for rows.Next() {
+		r := &RunRecord{}
+		var failedStep, msg sql.NullString
+		var steps, created []byte
+		if err := rows.Scan(&r.ID, &r.OrganizationID, &r.TraceID, &r.StartedAt, &r.FinishedAt, &r.OK,
+			&failedStep, &msg, &steps, &created); err != nil {
+			return nil, err
+		}
+		r.FailedStep, r.Error = failedStep.String, msg.String
+		if err := json.Unmarshal(steps, &r.Steps); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(created, &r.Created); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,509 @@
+// Package cashbasis converts the accrual cashflowdb ledger to the cash basis
+// organizations choose with organization_profiles.report_basis.
+//
+// Journals are replayed in date order. A journal that raises receivables or
+// payables is a document: its lines other than cash and the control account
+// are deferred in proportion to the amount left on account. A journal that
+// reduces the control account settles documents and recognizes their
+// deferred lines pro rata, on the settlement date, in place of the control
+// line. Invoice payments settle the invoice they were recorded against
+// (invoice_payments.invoiceId); other settlements, such as payments to
+// vendors, settle the oldest open document of their control account.
+// Settlements with nothing left to settle are held as unapplied credits and
+// taken up by the next document.
+package cashbasis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Bases an organization can report on.
+const (
+	BasisAccrual = "accrual"
+	BasisCash    = "cash"
+)
+
+// Control account types and the sign of an increase (debit positive).
+var controls = []struct {
+	accountType string
+	increase    money.Amount
+}{
+	{"accounts_receivable", 1},
+	{"accounts_payable", -1},
+}
+
+// cashTypes are accounts whose lines are cash movements and never deferred.
+var cashTypes = map[string]bool{
+	"cash": true, "bank": true, "credit_card": true, "payment_clearing_account": true,
+}
+
+// Account is a ledger account as the reports need it.
+type Account struct {
+	ID    string
+	Code  string
+	Name  string
+	Type  string
+	Class string
+}
+
+// line is a signed journal line: debits positive, credits negative.
+type line struct {
+	accountID string
+	amount    money.Amount
+}
+
+type journal struct {
+	id     string
+	number string
+	date   time.Time
+	lines  []line
+}
+
+// document is a journal whose deferred lines wait for settlement.
+type document struct {
+	journalID string
+	number    string
+	date      time.Time
+	remaining money.Amount // still on account, positive
+	lines     []line       // deferred lines not yet recognized
+}
+
+// pool holds the open documents and unapplied credits of one control type.
+type pool struct {
+	accountType string
+	increase    money.Amount
+	open        []*document
+	byJournal   map[string]*document
+	credits     money.Amount // settlements not matched to a document, positive
+}
+
+// Result holds both views of the ledger for one period.
+type Result struct {
+	OrganizationID string
+	From           time.Time
+	To             time.Time // exclusive
+	Accounts       map[string]*Account
+
+	// Period activity and closing balances per account, signed debit
+	// positive.
+	AccrualActivity map[string]money.Amount
+	AccrualBalance  map[string]money.Amount
+	CashActivity    map[string]money.Amount
+	CashBalance     map[string]money.Amount
+
+	// Profit held back in open documents at the start and end of the
+	// period, per control account type.
+	OpeningDeferred map[string]money.Amount
+	ClosingDeferred map[string]money.Amount
+	// Open documents at the end of the period.
+	Open map[string][]*OpenDocument
+	// Control movements that could not be converted, e.g. a receivable
+	// raised against cash only.
+	Unconverted []Unconverted
+}
+
+// OpenDocument is a document not fully settled at the end of the period.
+type OpenDocument struct {
+	Number   string
+	Date     time.Time
+	Balance  money.Amount
+	Deferred money.Amount // profit not yet recognized
+}
+
+// Unconverted is a journal whose control line is kept as posted.
+type Unconverted struct {
+	Number string
+	Date   time.Time
+	Amount money.Amount
+	Reason string
+}
+
+type engine struct {
+	res      *Result
+	pools    []*pool
+	byType   map[string]*pool
+	payments map[string]string // payment journal id -> invoice journal id
+	opened   bool
+}
+
+// Convert replays an organization's live journals dated before to and
+// returns accrual and cash activity for [from, to) and balances at to.
+func Convert(ctx context.Context, q cashflow.Querier, organizationID string, from, to time.Time) (*Result, error) {
+	e := newEngine(organizationID, from, to)
+	var err error
+	if e.res.Accounts, err = loadAccounts(ctx, q, organizationID); err != nil {
+		return nil, err
+	}
+	if e.payments, err = paymentLinks(ctx, q, organizationID); err != nil {
+		return nil, err
+	}
+	if err := journals(ctx, q, organizationID, to, e.add); err != nil {
+		return nil, err
+	}
+	return e.finish(), nil
+}
+
+func newEngine(organizationID string, from, to time.Time) *engine {
+	e := &engine{
+		res: &Result{
+			OrganizationID:  organizationID,
+			From:            from,
+			To:              to,
+			AccrualActivity: map[string]money.Amount{},
+			AccrualBalance:  map[string]money.Amount{},
+			CashActivity:    map[string]money.Amount{},
+			CashBalance:     map[string]money.Amount{},
+			OpeningDeferred: map[string]money.Amount{},
+			ClosingDeferred: map[string]money.Amount{},
+			Open:            map[string][]*OpenDocument{},
+		},
+		byType: map[string]*pool{},
+	}
+	for _, c := range controls {
+		p := &pool{accountType: c.accountType, increase: c.increase, byJournal: map[string]*document{}}
+		e.pools = append(e.pools, p)
+		e.byType[c.accountType] = p
+	}
+	return e
+}
+
+// add replays the next journal, taking the opening snapshot when the
+// journals reach the period.
+func (e *engine) add(j *journal) error {
+	if !e.opened && !j.date.Before(e.res.From) {
+		e.snapshot(e.res.OpeningDeferred)
+		e.opened = true
+	}
+	return e.replay(j)
+}
+
+// finish takes the closing snapshot and lists the open documents.
+func (e *engine) finish() *Result {
+	if !e.opened {
+		e.snapshot(e.res.OpeningDeferred)
+	}
+	e.snapshot(e.res.ClosingDeferred)
+	for _, p := range e.pools {
+		for _, d := range p.open {
+			e.res.Open[p.accountType] = append(e.res.Open[p.accountType], &OpenDocument{
+				Number: d.number, Date: d.date, Balance: d.remaining, Deferred: e.profit(d.lines),
+			})
+		}
+	}
+	return e.res
+}
+
+func loadAccounts(ctx context.Context, q cashflow.Querier, organizationID string) (map[string]*Account, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, code, name, type FROM ledger_accounts WHERE organizationId = ?`, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]*Account{}
+	for rows.Next() {
+		a := &Account{}
+		if err := rows.Scan(&a.ID, &a.Code, &a.Name, &a.Type); err != nil {
+			return nil, err
+		}
+		a.Class = cashflow.AccountClass(a.Type)
+		out[a.ID] = a
+	}
+	return out, rows.Err()
+}
+
+func paymentLinks(ctx context.Context, q cashflow.Querier, organizationID string) (map[string]string, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT ip.journalId, i.journalId FROM invoice_payments ip JOIN invoices i ON i.id = ip.invoiceId
+		WHERE i.organizationId = ? AND ip.journalId IS NOT NULL AND i.journalId IS NOT NULL`, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]string{}
+	for rows.Next() {
+		var payment, invoice string
+		if err := rows.Scan(&payment, &invoice); err != nil {
+			return nil, err
+		}
+		out[payment] = invoice
+	}
+	return out, rows.Err()
+}
+
+// journals calls fn for each live journal dated before to, oldest first.
+func journals(ctx context.Context, q cashflow.Querier, organizationID string, to time.Time, fn func(*journal) error) error {
+	rows, err := q.QueryContext(ctx, `
+		SELECT j.id, j.journalNumber, j.journalDate, je.accountId, je.debitAmount - je.creditAmount
+		FROM journals j JOIN journal_entries je ON je.journalId = j.id
+		WHERE j.organizationId = ? AND j.status IN `+cashflow.LiveJournalStatuses+` AND j.journalDate < ?
+		ORDER BY j.journalDate, j.createdAt, j.id, je.id`, organizationID, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var cur *journal
+	for rows.Next() {
+		var id, number, accountID string
+		var date time.Time
+		var amount money.Amount
+		if err := rows.Scan(&id, &number, &date, &accountID, &amount); err != nil {
+			return err
+		}
+		if cur == nil || cur.id != id {
+			if cur != nil {
+				if err := fn(cur); err != nil {
+					return err
+				}
+			}
+			cur = &journal{id: id, number: number, date: date}
+		}
+		cur.lines = append(cur.lines, line{accountID: accountID, amount: amount})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if cur != nil {
+		return fn(cur)
+	}
+	return nil
+}
+
+func (e *engine) accountType(id string) string {
+	if a, ok := e.res.Accounts[id]; ok {
+		return a.Type
+	}
+	return ""
+}
+
+// replay posts one journal to both views.
+func (e *engine) replay(j *journal) error {
+	for _, l := range j.lines {
+		e.post(e.res.AccrualActivity, e.res.AccrualBalance, j.date, l)
+	}
+
+	// Split the journal into control nets, cash lines and other lines.
+	nets := map[*pool]money.Amount{}
+	var controlLines, cashLines, others []line
+	for _, l := range j.lines {
+		t := e.accountType(l.accountID)
+		switch {
+		case e.byType[t] != nil:
+			nets[e.byType[t]] += l.amount
+			controlLines = append(controlLines, l)
+		case cashTypes[t]:
+			cashLines = append(cashLines, l)
+		default:
+			others = append(others, l)
+		}
+	}
+	if len(controlLines) == 0 {
+		return e.emit(j, j.lines)
+	}
+
+	var increases []*pool
+	var raised money.Amount // signed
+	for _, p := range e.pools {
+		if n := nets[p]; n != 0 && n*p.increase > 0 {
+			increases = append(increases, p)
+			raised += n
+		}
+	}
+
+	out := append([]line{}, cashLines...)
+	var deferred []line
+	switch {
+	case len(increases) == 0:
+		out = append(out, others...)
+	case len(increases) > 1:
+		return e.unconvertible(j, append(out, append(others, controlLines...)...), raised, "raises receivables and payables together")
+	default:
+		var sum money.Amount
+		for _, l := range others {
+			sum += l.amount
+		}
+		// The deferred share of the other lines must offset the amount
+		// raised, so it has to have the opposite sign and be no larger.
+		if sum == 0 || sum*raised > 0 || sum.Abs() < raised.Abs() {
+			p := increases[0]
+			keep := append(out, others...)
+			keep = append(keep, line{accountID: e.controlAccount(controlLines, p), amount: nets[p]})
+			if err := e.settleAll(j, &keep, nets, p); err != nil {
+				return err
+			}
+			return e.unconvertible(j, keep, raised, "no revenue or expense lines to defer")
+		}
+		deferred = scale(others, -raised)
+		for i, l := range others {
+			if rest := l.amount - deferred[i].amount; rest != 0 {
+				out = append(out, line{accountID: l.accountID, amount: rest})
+			}
+		}
+	}
+
+	if len(increases) == 1 {
+		p := increases[0]
+		d := &document{journalID: j.id, number: j.number, date: j.date, remaining: raised.Abs(), lines: deferred}
+		// Unapplied credits are taken up first; the recognized lines and the
+		// matching control line replace the credit held on account.
+		if p.credits > 0 {
+			take := min(p.credits, d.remaining)
+			p.credits -= take
+			out = append(out, d.settle(take)...)
+			out = append(out, line{accountID: e.controlAccount(controlLines, p), amount: take * p.increase})
+		}
+		if d.remaining > 0 {
+			p.open = append(p.open, d)
+			p.byJournal[j.id] = d
+		}
+	}
+	if err := e.settleAll(j, &out, nets, nil); err != nil {
+		return err
+	}
+	return e.emit(j, out)
+}
+
+// settleAll applies the journal's decreases of every pool except skip,
+// appending the recognized lines to out.
+func (e *engine) settleAll(j *journal, out *[]line, nets map[*pool]money.Amount, skip *pool) error {
+	for _, p := range e.pools {
+		n := nets[p]
+		if p == skip || n == 0 || n*p.increase > 0 {
+			continue
+		}
+		amount := n.Abs()
+		if docID, ok := e.payments[j.id]; ok {
+			if d := p.byJournal[docID]; d != nil {
+				take := min(amount, d.remaining)
+				*out = append(*out, d.settle(take)...)
+				amount -= take
+				p.close(d)
+			}
+		}
+		for amount > 0 && len(p.open) > 0 {
+			d := p.open[0]
+			take := min(amount, d.remaining)
+			*out = append(*out, d.settle(take)...)
+			amount -= take
+			p.close(d)
+		}
+		if amount > 0 {
+			// Nothing left to settle: keep the control line for the rest.
+			p.credits += amount
+			*out = append(*out, line{accountID: e.controlAccount(j.lines, p), amount: -amount * p.increase})
+		}
+	}
+	return nil
+}
+
+// controlAccount returns the first of the lines on the pool's control type.
+func (e *engine) controlAccount(lines []line, p *pool) string {
+	for _, l := range lines {
+		if e.accountType(l.accountID) == p.accountType {
+			return l.accountID
+		}
+	}
+	return ""
+}
+
+func (e *engine) unconvertible(j *journal, lines []line, amount money.Amount, reason string) error {
+	e.res.Unconverted = append(e.res.Unconverted, Unconverted{Number: j.number, Date: j.date, Amount: amount, Reason: reason})
+	return e.emit(j, lines)
+}
+
+// emit posts the cash view of a journal, which must balance.
+func (e *engine) emit(j *journal, lines []line) error {
+	var total money.Amount
+	for _, l := range lines {
+		total += l.amount
+		e.post(e.res.CashActivity, e.res.CashBalance, j.date, l)
+	}
+	if total != 0 {
+		return fmt.Errorf("cash view of journal %s is out of balance by %s", j.number, total)
+	}
+	return nil
+}
+
+func (e *engine) post(activity, balance map[string]money.Amount, date time.Time, l line) {
+	balance[l.accountID] += l.amount
+	if !date.Before(e.res.From) {
+		activity[l.accountID] += l.amount
+	}
+}
+
+// profit is the profit effect of lines: income and expense, credit positive.
+func (e *engine) profit(lines []line) money.Amount {
+	var p money.Amount
+	for _, l := range lines {
+		if c := e.res.Accounts[l.accountID]; c != nil && (c.Class == cashflow.ClassIncome || c.Class == cashflow.ClassExpense) {
+			p -= l.amount
+		}
+	}
+	return p
+}
+
+func (e *engine) snapshot(into map[string]money.Amount) {
+	for _, p := range e.pools {
+		var total money.Amount
+		for _, d := range p.open {
+			total += e.profit(d.lines)
+		}
+		into[p.accountType] = total
+	}
+}
+
+// settle recognizes the share of the deferred lines for amount settled.
+func (d *document) settle(amount money.Amount) []line {
+	if amount >= d.remaining {
+		out := d.lines
+		d.lines, d.remaining = nil, 0
+		return out
+	}
+	var total money.Amount
+	for _, l := range d.lines {
+		total += l.amount
+	}
+	// The deferred lines always sum to the remaining balance with the
+	// opposite sign of the control line, so the share keeps that ratio.
+	share := scale(d.lines, total.Mul(float64(amount)/float64(d.remaining)))
+	for i := range d.lines {
+		d.lines[i].amount -= share[i].amount
+	}
+	d.remaining -= amount
+	return share
+}
+
+func (p *pool) close(d *document) {
+	if d.remaining > 0 {
+		return
+	}
+	delete(p.byJournal, d.journalID)
+	for i, o := range p.open {
+		if o == d {
+			p.open = append(p.open[:i], p.open[i+1:]...)
+			return
+		}
+	}
+}
+
+// scale returns lines proportional to ls that sum exactly to total.
+func scale(ls []line, total money.Amount) []line {
+	weights := make([]float64, len(ls))
+	for i, l := range ls {
+		weights[i] = float64(l.amount)
+	}
+	parts := total.Allocate(weights)
+	out := make([]line, len(ls))
+	for i, l := range ls {
+		out[i] = line{accountID: l.accountID, amount: parts[i]}
+	}
+	return out
+}
@@ -0,0 +1,209 @@
+package cashbasis
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+var testAccounts = map[string]*Account{}
+
+func init() {
+	for id, typ := range map[string]string{
+		"ar": "accounts_receivable", "ap": "accounts_payable", "bank": "bank", "sales": "income",
+		"tax": "other_current_liability", "rent": "expense",
+	} {
+		testAccounts[id] = &Account{ID: id, Code: id, Name: id, Type: typ, Class: cashflow.AccountClass(typ)}
+	}
+}
+
+func day(d int) time.Time { return time.Date(2025, 3, d, 0, 0, 0, 0, time.UTC) }
+
+// jnl builds a journal from "account amount" lines, debits positive.
+func jnl(number string, d int, lines ...string) *journal {
+	j := &journal{id: number, number: number, date: day(d)}
+	for _, l := range lines {
+		acct, amount, _ := strings.Cut(l, " ")
+		j.lines = append(j.lines, line{accountID: acct, amount: money.MustParse(amount)})
+	}
+	return j
+}
+
+func amounts(kv ...string) map[string]money.Amount {
+	out := map[string]money.Amount{}
+	for i := 0; i < len(kv); i += 2 {
+		out[kv[i]] = money.MustParse(kv[i+1])
+	}
+	return out
+}
+
+func nonZero(m map[string]money.Amount) map[string]money.Amount {
+	out := map[string]money.Amount{}
+	for k, v := range m {
+		if v != 0 {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func TestConvert(t *testing.T) {
+	invoice := jnl("INV1", 1, "ar 1100", "sales -1000", "tax -100")
+
+	tests := []struct {
+		name        string
+		from        int
+		payments    map[string]string
+		journals    []*journal
+		cash        map[string]money.Amount
+		open        map[string][]string // number:balance:deferred
+		opening     money.Amount        // receivable profit deferred at from
+		closing     money.Amount
+		unconverted []string
+	}{
+		{
+			name:     "invoice is deferred until paid",
+			journals: []*journal{invoice},
+			cash:     amounts(),
+			open:     map[string][]string{"accounts_receivable": {"INV1:1100.00:1000.00"}},
+			closing:  money.MustParse("1000"),
+		},
+		{
+			name:     "partial payment recognizes pro rata",
+			journals: []*journal{invoice, jnl("PAY1", 2, "bank 550", "ar -550")},
+			cash:     amounts("bank", "550", "sales", "-500", "tax", "-50"),
+			open:     map[string][]string{"accounts_receivable": {"INV1:550.00:500.00"}},
+			closing:  money.MustParse("500"),
+		},
+		{
+			name:     "thirds recognize everything once settled",
+			journals: []*journal{invoice, jnl("PAY1", 2, "bank 366.67", "ar -366.67"), jnl("PAY2", 3, "bank 366.67", "ar -366.67"), jnl("PAY3", 4, "bank 366.66", "ar -366.66")},
+			cash:     amounts("bank", "1100", "sales", "-1000", "tax", "-100"),
+		},
+		{
+			name:     "payments settle the oldest invoice",
+			journals: []*journal{jnl("INV1", 1, "ar 100", "sales -100"), jnl("INV2", 2, "ar 200", "sales -200"), jnl("PAY", 3, "bank 200", "ar -200")},
+			cash:     amounts("bank", "200", "sales", "-200"),
+			open:     map[string][]string{"accounts_receivable": {"INV2:100.00:100.00"}},
+			closing:  money.MustParse("100"),
+		},
+		{
+			name:     "invoice payments settle their invoice",
+			payments: map[string]string{"PAY": "INV2"},
+			journals: []*journal{jnl("INV1", 1, "ar 100", "sales -100"), jnl("INV2", 2, "ar 200", "sales -200"), jnl("PAY", 3, "bank 200", "ar -200")},
+			cash:     amounts("bank", "200", "sales", "-200"),
+			open:     map[string][]string{"accounts_receivable": {"INV1:100.00:100.00"}},
+			closing:  money.MustParse("100"),
+		},
+		{
+			name:     "vendor bills defer expenses",
+			journals: []*journal{jnl("BILL1", 1, "rent 300", "ap -300"), jnl("PAYB", 2, "ap 120", "bank -120")},
+			cash:     amounts("rent", "120", "bank", "-120"),
+			open:     map[string][]string{"accounts_payable": {"BILL1:180.00:-180.00"}},
+		},
+		{
+			name:     "unapplied credit is taken up by the next invoice",
+			journals: []*journal{jnl("PAY0", 1, "bank 50", "ar -50"), jnl("INV1", 2, "ar 100", "sales -100")},
+			cash:     amounts("bank", "50", "sales", "-50"),
+			open:     map[string][]string{"accounts_receivable": {"INV1:50.00:50.00"}},
+			closing:  money.MustParse("50"),
+		},
+		{
+			name:     "cash sales pass through",
+			journals: []*journal{jnl("CS1", 1, "bank 10", "sales -10")},
+			cash:     amounts("bank", "10", "sales", "-10"),
+		},
+		{
+			name:        "receivable raised against cash",
+			journals:    []*journal{jnl("X1", 1, "ar 100", "bank -100")},
+			cash:        amounts("ar", "100", "bank", "-100"),
+			unconverted: []string{"X1: no revenue or expense lines to defer"},
+		},
+		{
+			name:        "receivables and payables together",
+			journals:    []*journal{jnl("X2", 1, "ar 100", "ap -100")},
+			cash:        amounts("ar", "100", "ap", "-100"),
+			unconverted: []string{"X2: raises receivables and payables together"},
+		},
+		{
+			name:     "period starts after the invoice",
+			from:     2,
+			journals: []*journal{invoice, jnl("PAY1", 3, "bank 550", "ar -550")},
+			cash:     amounts("bank", "550", "sales", "-500", "tax", "-50"),
+			open:     map[string][]string{"accounts_receivable": {"INV1:550.00:500.00"}},
+			opening:  money.MustParse("1000"),
+			closing:  money.MustParse("500"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEngine("org", day(max(tt.from, 1)), day(31))
+			e.res.Accounts = testAccounts
+			e.payments = tt.payments
+			for _, j := range tt.journals {
+				copied := *j
+				copied.lines = append([]line{}, j.lines...)
+				if err := e.add(&copied); err != nil {
+					t.Fatal(err)
+				}
+			}
+			res := e.finish()
+
+			if got := nonZero(res.CashActivity); !reflect.DeepEqual(got, tt.cash) {
+				t.Errorf("cash activity = %v, want %v", got, tt.cash)
+			}
+			open := map[string][]string{}
+			for kind, docs := range res.Open {
+				for _, d := range docs {
+					open[kind] = append(open[kind], d.Number+":"+d.Balance.String()+":"+d.Deferred.String())
+				}
+			}
+			if tt.open == nil {
+				tt.open = map[string][]string{}
+			}
+			if !reflect.DeepEqual(open, tt.open) {
+				t.Errorf("open = %v, want %v", open, tt.open)
+			}
+			if got := res.OpeningDeferred["accounts_receivable"]; got != tt.opening {
+				t.Errorf("opening deferred = %s, want %s", got, tt.opening)
+			}
+			if got := res.ClosingDeferred["accounts_receivable"]; got != tt.closing {
+				t.Errorf("closing deferred = %s, want %s", got, tt.closing)
+			}
+			var unconverted []string
+			for _, u := range res.Unconverted {
+				unconverted = append(unconverted, u.Number+": "+u.Reason)
+			}
+			if !reflect.DeepEqual(unconverted, tt.unconverted) {
+				t.Errorf("unconverted = %q, want %q", unconverted, tt.unconverted)
+			}
+
+			// Both views move the same cash and net to the same retained
+			// profit once everything is settled.
+			accrual := nonZero(res.AccrualBalance)
+			if res.CashBalance["bank"] != accrual["bank"] {
+				t.Errorf("cash view bank %s, accrual %s", res.CashBalance["bank"], accrual["bank"])
+			}
+		})
+	}
+}
+
+func TestSettle(t *testing.T) {
+	d := &document{remaining: money.MustParse("100"), lines: []line{
+		{accountID: "sales", amount: money.MustParse("-60")},
+		{accountID: "other", amount: money.MustParse("-40")},
+	}}
+	var recognized money.Amount
+	for _, pay := range []string{"33.33", "33.33", "33.34"} {
+		for _, l := range d.settle(money.MustParse(pay)) {
+			recognized += l.amount
+		}
+	}
+	if recognized != money.MustParse("-100") || d.remaining != 0 || d.lines != nil {
+		t.Errorf("recognized %s, remaining %s, lines %v", recognized, d.remaining, d.lines)
+	}
+}
@@ -0,0 +1,186 @@
+package cashbasis
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// ReportBasis returns the organization's report basis from
+// organization_profiles, accrual when it has no profile.
+func ReportBasis(ctx context.Context, q cashflow.Querier, organizationID string) (string, error) {
+	var basis string
+	err := q.QueryRowContext(ctx, `
+		SELECT report_basis FROM organization_profiles WHERE organization_id = ?`, organizationID).Scan(&basis)
+	if errors.Is(err, sql.ErrNoRows) {
+		return BasisAccrual, nil
+	}
+	return basis, err
+}
+
+// Row is one account in a statement, with its balance on its normal side.
+type Row struct {
+	Account *Account
+	Amount  money.Amount // signed, debit positive
+}
+
+// Balance is the amount on the account's normal side.
+func (r Row) Balance() money.Amount {
+	if cashflow.DebitNormal(r.Account.Class) {
+		return r.Amount
+	}
+	return -r.Amount
+}
+
+func (r *Result) views(basis string) (activity, balance map[string]money.Amount) {
+	if basis == BasisCash {
+		return r.CashActivity, r.CashBalance
+	}
+	return r.AccrualActivity, r.AccrualBalance
+}
+
+func (r *Result) rows(amounts map[string]money.Amount, keep func(*Account) bool) []Row {
+	var out []Row
+	for id, amount := range amounts {
+		a := r.Accounts[id]
+		if a == nil {
+			a = &Account{ID: id, Code: id, Name: "(unknown account)"}
+		}
+		if amount != 0 && keep(a) {
+			out = append(out, Row{Account: a, Amount: amount})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Account.Class != out[j].Account.Class {
+			return classOrder[out[i].Account.Class] < classOrder[out[j].Account.Class]
+		}
+		return out[i].Account.Code < out[j].Account.Code
+	})
+	return out
+}
+
+var classOrder = map[string]int{
+	cashflow.ClassAsset: 1, cashflow.ClassLiability: 2, cashflow.ClassEquity: 3,
+	cashflow.ClassIncome: 4, cashflow.ClassExpense: 5,
+}
+
+func profitAndLoss(a *Account) bool {
+	return a.Class == cashflow.ClassIncome || a.Class == cashflow.ClassExpense
+}
+
+// ProfitAndLoss returns the income and expense rows of the period.
+func (r *Result) ProfitAndLoss(basis string) []Row {
+	activity, _ := r.views(basis)
+	return r.rows(activity, profitAndLoss)
+}
+
+// TrialBalance returns every account's balance at the end of the period.
+func (r *Result) TrialBalance(basis string) []Row {
+	_, balance := r.views(basis)
+	return r.rows(balance, func(*Account) bool { return true })
+}
+
+// NetProfit is income less expenses for the period.
+func (r *Result) NetProfit(basis string) money.Amount {
+	var net money.Amount
+	for _, row := range r.ProfitAndLoss(basis) {
+		net -= row.Amount
+	}
+	return net
+}
+
+func (r *Result) period() string {
+	return r.From.Format(time.DateOnly) + " to " + r.To.AddDate(0, 0, -1).Format(time.DateOnly)
+}
+
+// PrintProfitAndLoss writes the period's profit and loss on a basis.
+func (r *Result) PrintProfitAndLoss(w io.Writer, basis string) {
+	fmt.Fprintf(w, "Profit and loss (%s basis), %s\n\n", basis, r.period())
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	var income, expense money.Amount
+	for _, row := range r.ProfitAndLoss(basis) {
+		if row.Account.Class == cashflow.ClassIncome {
+			income += row.Balance()
+		} else {
+			expense += row.Balance()
+		}
+		fmt.Fprintf(tw, "%s %s\t%s\t%s\t\n", row.Account.Code, row.Account.Name, row.Account.Class, row.Balance())
+	}
+	fmt.Fprintf(tw, "Income\t\t%s\t\n", income)
+	fmt.Fprintf(tw, "Expenses\t\t%s\t\n", expense)
+	fmt.Fprintf(tw, "Net profit\t\t%s\t\n", income-expense)
+	tw.Flush()
+}
+
+// PrintTrialBalance writes balances at the end of the period on a basis.
+func (r *Result) PrintTrialBalance(w io.Writer, basis string) {
+	fmt.Fprintf(w, "Trial balance (%s basis), as of %s\n\n", basis, r.To.AddDate(0, 0, -1).Format(time.DateOnly))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACCOUNT\tCLASS\tDEBIT\tCREDIT\t")
+	var debit, credit money.Amount
+	for _, row := range r.TrialBalance(basis) {
+		d, c := "", ""
+		if row.Amount > 0 {
+			debit += row.Amount
+			d = row.Amount.String()
+		} else {
+			credit -= row.Amount
+			c = (-row.Amount).String()
+		}
+		fmt.Fprintf(tw, "%s %s\t%s\t%s\t%s\t\n", row.Account.Code, row.Account.Name, row.Account.Class, d, c)
+	}
+	fmt.Fprintf(tw, "Total\t\t%s\t%s\t\n", debit, credit)
+	tw.Flush()
+}
+
+// PrintReconciliation explains the difference between accrual and cash net
+// profit by the profit deferred in open receivables and payables.
+func (r *Result) PrintReconciliation(w io.Writer) {
+	fmt.Fprintf(w, "Accrual to cash reconciliation, %s\n\n", r.period())
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	accrual := r.NetProfit(BasisAccrual)
+	fmt.Fprintf(tw, "Net profit, accrual basis\t%s\t\n", accrual)
+	explained := accrual
+	for _, c := range controls {
+		opening, closing := r.OpeningDeferred[c.accountType], r.ClosingDeferred[c.accountType]
+		fmt.Fprintf(tw, "Less profit deferred in open %s at end\t%s\t\n", c.accountType, -closing)
+		fmt.Fprintf(tw, "Add profit deferred in open %s at start\t%s\t\n", c.accountType, opening)
+		explained += opening - closing
+	}
+	cash := r.NetProfit(BasisCash)
+	fmt.Fprintf(tw, "Net profit, cash basis\t%s\t\n", cash)
+	if diff := cash - explained; diff != 0 {
+		fmt.Fprintf(tw, "Unexplained difference\t%s\t\n", diff)
+	}
+	tw.Flush()
+
+	for _, c := range controls {
+		docs := r.Open[c.accountType]
+		if len(docs) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\nOpen %s documents\n", c.accountType)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "JOURNAL\tDATE\tBALANCE\tDEFERRED PROFIT\t")
+		for _, d := range docs {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", d.Number, d.Date.Format(time.DateOnly), d.Balance, d.Deferred)
+		}
+		tw.Flush()
+	}
+	if len(r.Unconverted) > 0 {
+		fmt.Fprintf(w, "\nKept on account as posted\n")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, u := range r.Unconverted {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", u.Number, u.Date.Format(time.DateOnly), u.Amount, u.Reason)
+		}
+		tw.Flush()
+	}
+}
@@ -0,0 +1,56 @@
+package cashflow
+
+// Account classes, the statement sections ledger account types roll up to.
+const (
+	ClassAsset     = "asset"
+	ClassLiability = "liability"
+	ClassEquity    = "equity"
+	ClassIncome    = "income"
+	ClassExpense   = "expense"
+)
+
+// accountClasses maps the BFF's ledger account types (see
+// apps/bff/src/data/chartOfAccounts.ts) to their class.
+var accountClasses = map[string]string{
+	"other_asset":              ClassAsset,
+	"other_current_asset":      ClassAsset,
+	"cash":                     ClassAsset,
+	"bank":                     ClassAsset,
+	"fixed_asset":              ClassAsset,
+	"accounts_receivable":      ClassAsset,
+	"stock":                    ClassAsset,
+	"payment_clearing_account": ClassAsset,
+	"input_tax":                ClassAsset,
+	"intangible_asset":         ClassAsset,
+	"non_current_asset":        ClassAsset,
+	"deferred_tax_asset":       ClassAsset,
+	"other_current_liability":  ClassLiability,
+	"credit_card":              ClassLiability,
+	"non_current_liability":    ClassLiability,
+	"other_liability":          ClassLiability,
+	"accounts_payable":         ClassLiability,
+	"overseas_tax_payable":     ClassLiability,
+	"output_tax":               ClassLiability,
+	"deferred_tax_liability":   ClassLiability,
+	"equity":                   ClassEquity,
+	"income":                   ClassIncome,
+	"other_income":             ClassIncome,
+	"expense":                  ClassExpense,
+	"cost_of_goods_sold":       ClassExpense,
+	"other_expense":            ClassExpense,
+}
+
+// AccountClass returns the class of a ledger account type, or "" for an
+// unknown type.
+func AccountClass(accountType string) string {
+	return accountClasses[accountType]
+}
+
+// DebitNormal reports whether accounts of a class carry debit balances.
+func DebitNormal(class string) bool {
+	return class == ClassAsset || class == ClassExpense
+}
+
+// LiveJournalStatuses are the journal statuses that count towards balances:
+// the BFF creates journals as 'active', the ledger tools as 'posted'.
+const LiveJournalStatuses = `('active', 'posted')`
@@ -0,0 +1,156 @@
+// Package cashflow reads and writes the BFF's cashflowdb ledger the same way
+// the Node services do: journals with balanced journal_entries, invoice
+// payments that debit the deposit account and credit receivables, and
+// ledger accounts resolved by type.
+package cashflow
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Querier is satisfied by *sql.DB, *sql.Conn and *sql.Tx.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// ErrNotFound is returned when a looked-up row does not exist.
+var ErrNotFound = errors.New("not found")
+
+// NewID returns an id in the style the BFF uses: prefix, millisecond
+// timestamp and a random suffix.
+func NewID(prefix string) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s_%d_%s", prefix, time.Now().UnixMilli(), hex.EncodeToString(b))
+}
+
+// AccountByType returns the first active ledger account of one of the given
+// types, preferring the lowest code.
+func AccountByType(ctx context.Context, q Querier, organizationID string, types ...string) (string, error) {
+	for _, t := range types {
+		var id string
+		err := q.QueryRowContext(ctx, `
+			SELECT id FROM ledger_accounts
+			WHERE organizationId = ? AND type = ? AND isActive = true
+			ORDER BY code LIMIT 1`, organizationID, t).Scan(&id)
+		if err == nil {
+			return id, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no active %v ledger account for organization %s: %w", types, organizationID, ErrNotFound)
+}
+
+// LedgerAccount is a row of ledger_accounts.
+type LedgerAccount struct {
+	ID             string
+	OrganizationID string
+	Code           string
+	Name           string
+	Type           string
+}
+
+// GetLedgerAccount loads a ledger account scoped to an organization.
+func GetLedgerAccount(ctx context.Context, q Querier, organizationID, id string) (*LedgerAccount, error) {
+	a := &LedgerAccount{}
+	err := q.QueryRowContext(ctx, `
+		SELECT id, organizationId, code, name, type FROM ledger_accounts
+		WHERE id = ? AND organizationId = ?`, id, organizationID).
+		Scan(&a.ID, &a.OrganizationID, &a.Code, &a.Name, &a.Type)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("ledger account %s: %w", id, ErrNotFound)
+	}
+	return a, err
+}
+
+// JournalLine is one journal_entries row.
+type JournalLine struct {
+	ID          string
+	AccountID   string
+	Description string
+	Debit       money.Amount
+	Credit      money.Amount
+}
+
+// Journal is a journals row with its entries.
+type Journal struct {
+	ID             string
+	OrganizationID string
+	Number         string
+	Date           time.Time
+	Reference      string
+	Notes          string
+	Status         string
+	Lines          []JournalLine
+}
+
+// Totals returns the debit and credit totals of the journal's lines.
+func (j *Journal) Totals() (debit, credit money.Amount) {
+	for _, l := range j.Lines {
+		debit += l.Debit
+		credit += l.Credit
+	}
+	return debit, credit
+}
+
+// PostJournal inserts a balanced journal and its entries. The journal is
+// rejected if debits and credits differ, mirroring checkLedgerBalance.
+func PostJournal(ctx context.Context, q Querier, j *Journal) error {
+	if len(j.Lines) == 0 {
+		return errors.New("journal has no entries")
+	}
+	debit, credit := j.Totals()
+	if debit != credit {
+		return fmt.Errorf("journal %s out of balance (DR %s != CR %s)", j.Number, debit, credit)
+	}
+
+	if j.ID == "" {
+		j.ID = NewID("jr")
+	}
+	if j.Status == "" {
+		j.Status = "posted"
+	}
+	now := time.Now()
+
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO journals (id, organizationId, journalNumber, journalDate, reference, notes,
+		                      totalDebit, totalCredit, status, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		j.ID, j.OrganizationID, j.Number, j.Date, NullString(j.Reference), NullString(j.Notes),
+		debit, credit, j.Status, now, now)
+	if err != nil {
+		return fmt.Errorf("insert journal %s: %w", j.Number, err)
+	}
+
+	for i := range j.Lines {
+		l := &j.Lines[i]
+		if l.ID == "" {
+			l.ID = NewID("je")
+		}
+		_, err := q.ExecContext(ctx, `
+			INSERT INTO journal_entries (id, journalId, accountId, description, debitAmount, creditAmount, createdAt, updatedAt)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			l.ID, j.ID, l.AccountID, NullString(l.Description), l.Debit, l.Credit, now, now)
+		if err != nil {
+			return fmt.Errorf("insert journal entry for %s: %w", l.AccountID, err)
+		}
+	}
+	return nil
+}
+
+// NullString maps "" to SQL NULL.
+func NullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,196 @@
+package cashflow
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Invoice holds the invoice columns the tools need.
+type Invoice struct {
+	ID             string
+	OrganizationID string
+	InvoiceNumber  string
+	CustomerID     string
+	Status         string
+	Currency       string
+	IssueDate      time.Time
+	TotalAmount    money.Amount
+	PaidAmount     money.Amount
+	BalanceDue     money.Amount
+	BranchID       string
+	SalespersonID  string
+	Warehouse      string
+}
+
+const invoiceColumns = `id, organizationId, invoiceNumber, customerId, status, currency, issueDate,
+	totalAmount, paidAmount, balanceDue, COALESCE(branchId, ''), COALESCE(salespersonId, ''), COALESCE(warehouse, '')`
+
+func scanInvoice(row interface{ Scan(...any) error }) (*Invoice, error) {
+	inv := &Invoice{}
+	err := row.Scan(&inv.ID, &inv.OrganizationID, &inv.InvoiceNumber, &inv.CustomerID, &inv.Status,
+		&inv.Currency, &inv.IssueDate, &inv.TotalAmount, &inv.PaidAmount, &inv.BalanceDue,
+		&inv.BranchID, &inv.SalespersonID, &inv.Warehouse)
+	return inv, err
+}
+
+// GetInvoice loads an invoice by id within an organization and locks it for
+// update when q is a transaction.
+func GetInvoice(ctx context.Context, q Querier, organizationID, id string) (*Invoice, error) {
+	inv, err := scanInvoice(q.QueryRowContext(ctx, `SELECT `+invoiceColumns+` FROM invoices
+		WHERE id = ? AND organizationId = ? FOR UPDATE`, id, organizationID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("invoice %s: %w", id, ErrNotFound)
+	}
+	return inv, err
+}
+
+// FindInvoicesByReference returns the invoices of an organization whose
+// number matches a payment reference. An exact match wins; otherwise the
+// comparison ignores case, spaces, dashes and a leading '#'.
+func FindInvoicesByReference(ctx context.Context, q Querier, organizationID, reference string) ([]*Invoice, error) {
+	reference = strings.TrimSpace(reference)
+	if reference == "" {
+		return nil, nil
+	}
+
+	exact, err := queryInvoices(ctx, q, `SELECT `+invoiceColumns+` FROM invoices
+		WHERE organizationId = ? AND invoiceNumber = ? FOR UPDATE`, organizationID, reference)
+	if err != nil || len(exact) > 0 {
+		return exact, err
+	}
+
+	normalized := NormalizeReference(reference)
+	return queryInvoices(ctx, q, `SELECT `+invoiceColumns+` FROM invoices
+		WHERE organizationId = ?
+		  AND UPPER(REPLACE(REPLACE(REPLACE(invoiceNumber, ' ', ''), '-', ''), '#', '')) = ?
+		FOR UPDATE`, organizationID, normalized)
+}
+
+// NormalizeReference canonicalizes a document number for fuzzy matching.
+func NormalizeReference(s string) string {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	return strings.NewReplacer(" ", "", "-", "", "#", "").Replace(s)
+}
+
+func queryInvoices(ctx context.Context, q Querier, query string, args ...any) ([]*Invoice, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Invoice
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, inv)
+	}
+	return out, rows.Err()
+}
+
+// Payment describes an invoice payment to record.
+type Payment struct {
+	ID            string
+	PaymentNumber string
+	Date          time.Time
+	Amount        money.Amount
+	BankCharges   money.Amount
+	Mode          string
+	DepositTo     string // ledger account id
+	ChargesTo     string // ledger account id for bank charges
+	Reference     string
+	Notes         string
+}
+
+// PaymentResult is what RecordInvoicePayment created.
+type PaymentResult struct {
+	PaymentID string
+	JournalID string
+	Invoice   *Invoice
+}
+
+// RecordInvoicePayment records a payment against an invoice the way
+// POST /api/payments does: DR deposit account, CR accounts receivable, then
+// update the invoice's paid amount and balance. Bank charges, when given, are
+// debited to ChargesTo and reduce the amount deposited. q must be a
+// transaction that has locked the invoice row.
+func RecordInvoicePayment(ctx context.Context, q Querier, inv *Invoice, p Payment) (*PaymentResult, error) {
+	if p.Amount <= 0 {
+		return nil, errors.New("payment amount must be positive")
+	}
+	if p.Amount > inv.BalanceDue {
+		return nil, fmt.Errorf("payment %s exceeds balance due %s on invoice %s", p.Amount, inv.BalanceDue, inv.InvoiceNumber)
+	}
+	if p.BankCharges > 0 && p.ChargesTo == "" {
+		return nil, errors.New("bank charges need an account to post to")
+	}
+
+	arAccount, err := AccountByType(ctx, q, inv.OrganizationID, "accounts_receivable")
+	if err != nil {
+		return nil, err
+	}
+
+	if p.ID == "" {
+		p.ID = NewID("pay")
+	}
+	if p.PaymentNumber == "" {
+		p.PaymentNumber = fmt.Sprintf("P-%d", time.Now().UnixMilli())
+	}
+	if p.Mode == "" {
+		p.Mode = "cash"
+	}
+
+	journal := &Journal{
+		OrganizationID: inv.OrganizationID,
+		Number:         "J-" + p.PaymentNumber,
+		Date:           p.Date,
+		Reference:      p.Reference,
+		Notes:          fmt.Sprintf("Payment %s for invoice %s", p.PaymentNumber, inv.InvoiceNumber),
+		Lines: []JournalLine{
+			{AccountID: p.DepositTo, Description: "Payment received - " + inv.InvoiceNumber, Debit: p.Amount - p.BankCharges},
+			{AccountID: arAccount, Description: "Payment applied - " + inv.InvoiceNumber, Credit: p.Amount},
+		},
+	}
+	if p.BankCharges > 0 {
+		journal.Lines = append(journal.Lines, JournalLine{
+			AccountID: p.ChargesTo, Description: "Payment charges - " + inv.InvoiceNumber, Debit: p.BankCharges,
+		})
+	}
+	if err := PostJournal(ctx, q, journal); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	_, err = q.ExecContext(ctx, `
+		INSERT INTO invoice_payments (id, invoiceId, paymentNumber, paymentDate, amountReceived, bankCharges,
+		                              paymentMode, depositTo, reference, notes, journalId, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.ID, inv.ID, p.PaymentNumber, p.Date, p.Amount, p.BankCharges, p.Mode, p.DepositTo,
+		NullString(p.Reference), NullString(p.Notes), journal.ID, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("insert invoice payment: %w", err)
+	}
+
+	inv.PaidAmount += p.Amount
+	inv.BalanceDue -= p.Amount
+	if inv.BalanceDue <= 0 {
+		inv.BalanceDue = 0
+		inv.Status = "paid"
+	}
+	_, err = q.ExecContext(ctx, `
+		UPDATE invoices SET paidAmount = ?, balanceDue = ?, status = ?, updatedAt = ? WHERE id = ?`,
+		inv.PaidAmount, inv.BalanceDue, inv.Status, now, inv.ID)
+	if err != nil {
+		return nil, fmt.Errorf("update invoice %s: %w", inv.InvoiceNumber, err)
+	}
+
+	return &PaymentResult{PaymentID: p.ID, JournalID: journal.ID, Invoice: inv}, nil
+}
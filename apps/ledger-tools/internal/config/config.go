@@ -0,0 +1,138 @@
+// Package config reads the environment shared by the ledger tools.
+//
+// The variable names match the ones the BFF (packages/config) and the OA
+// server entrypoint already use, so the tools can run with the same .env:
+// DB_URL or DB_HOST/DB_PORT/DB_USER/DB_PASSWORD/DB_NAME for the Open
+// Accounting database and BFF_DATABASE_URL for cashflowdb.
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Config holds connection settings for both databases and the OA API.
+type Config struct {
+	OADSN           string
+	CashflowDSN     string
+	OABaseURL       string
+	OAAPIKey        string
+	OAAcceptVersion string
+}
+
+// Load builds a Config from the process environment. Missing databases are
+// left empty; commands that need them report the error when opening.
+func Load() (*Config, error) {
+	cfg := &Config{
+		OABaseURL:       os.Getenv("OA_BASE_URL"),
+		OAAPIKey:        os.Getenv("OA_API_KEY"),
+		OAAcceptVersion: getenv("OA_ACCEPT_VERSION", "1.4.0"),
+	}
+
+	oaDSN, err := oaDSNFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	cfg.OADSN = oaDSN
+
+	if raw := os.Getenv("BFF_DATABASE_URL"); raw != "" {
+		dsn, err := DSNFromURL(raw)
+		if err != nil {
+			return nil, fmt.Errorf("BFF_DATABASE_URL: %w", err)
+		}
+		cfg.CashflowDSN = dsn
+	}
+
+	return cfg, nil
+}
+
+// oaDSNFromEnv mirrors apps/oa-server-deploy/entrypoint.sh: a /cloudsql/
+// host means a unix socket, anything else is TCP.
+func oaDSNFromEnv() (string, error) {
+	if raw := os.Getenv("DB_URL"); raw != "" {
+		if strings.HasPrefix(raw, "mysql://") {
+			return DSNFromURL(raw)
+		}
+		return withDefaults(raw)
+	}
+
+	host := os.Getenv("DB_HOST")
+	if host == "" {
+		return "", nil
+	}
+
+	mc := mysql.NewConfig()
+	mc.User = getenv("DB_USER", "root")
+	mc.Passwd = os.Getenv("DB_PASSWORD")
+	mc.DBName = getenv("DB_NAME", "openaccounting")
+	if strings.HasPrefix(host, "/cloudsql/") {
+		mc.Net = "unix"
+		mc.Addr = host
+	} else {
+		mc.Net = "tcp"
+		mc.Addr = net.JoinHostPort(host, getenv("DB_PORT", "3306"))
+	}
+	mc.ParseTime = true
+	return mc.FormatDSN(), nil
+}
+
+// DSNFromURL converts a Prisma style mysql:// URL into a go-sql-driver DSN.
+func DSNFromURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "mysql" {
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	mc := mysql.NewConfig()
+	mc.User = u.User.Username()
+	mc.Passwd, _ = u.User.Password()
+	mc.DBName = strings.TrimPrefix(u.Path, "/")
+
+	if socket := u.Query().Get("socket"); socket != "" {
+		mc.Net = "unix"
+		mc.Addr = socket
+	} else {
+		host := u.Hostname()
+		port := u.Port()
+		if port == "" {
+			port = "3306"
+		}
+		mc.Net = "tcp"
+		mc.Addr = net.JoinHostPort(host, port)
+	}
+	mc.ParseTime = true
+	return mc.FormatDSN(), nil
+}
+
+// DatabaseName returns the schema name a DSN points at.
+func DatabaseName(dsn string) string {
+	mc, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return ""
+	}
+	return mc.DBName
+}
+
+func withDefaults(dsn string) (string, error) {
+	mc, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	mc.ParseTime = true
+	return mc.FormatDSN(), nil
+}
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		out  string
+		err  string
+	}{
+		{in: `"30s"`, want: 30 * time.Second, out: `"30s"`},
+		{in: `"1h30m"`, want: 90 * time.Minute, out: `"1h30m0s"`},
+		{in: `"250ms"`, want: 250 * time.Millisecond, out: `"250ms"`},
+		{in: `"0s"`, want: 0, out: `"0s"`},
+		{in: `"30"`, err: "missing unit"},
+		{in: `30`, err: "cannot unmarshal number"},
+	}
+	for _, tt := range tests {
+		var d Duration
+		err := json.Unmarshal([]byte(tt.in), &d)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Unmarshal(%s) error = %v, want %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || time.Duration(d) != tt.want {
+			t.Errorf("Unmarshal(%s) = %v, %v; want %v", tt.in, time.Duration(d), err, tt.want)
+			continue
+		}
+		if out, err := json.Marshal(d); err != nil || string(out) != tt.out {
+			t.Errorf("Marshal(%v) = %s, %v; want %s", time.Duration(d), out, err, tt.out)
+		}
+	}
+}
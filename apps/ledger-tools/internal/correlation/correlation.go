@@ -0,0 +1,136 @@
+// Package correlation carries the BFF's request id through the Go services.
+// The BFF tags every request with X-Request-ID (src/middleware/correlationId.ts)
+// and stores it in audit_logs.metadata as requestId; the same id is accepted
+// and echoed by Middleware, sent on outgoing calls by Transport, stamped into
+// OA transaction data by Stamp and written to job_logs by Log, so Trace can
+// put everything one request caused back in order.
+package correlation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Header is the correlation header the BFF reads and writes.
+const Header = "X-Request-ID"
+
+// DataKey is the key the id is stored under in OA transaction data.
+const DataKey = "correlationId"
+
+// maxLen bounds an accepted id; the BFF's are 36-character UUIDs.
+const maxLen = 128
+
+type ctxKey struct{}
+
+// NewID returns a random UUID v4, the form the BFF generates.
+func NewID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// WithID returns ctx carrying id.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the id carried by ctx, or "".
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// Ensure returns ctx with an id, keeping one that is already there.
+func Ensure(ctx context.Context) (context.Context, string) {
+	if id := FromContext(ctx); id != "" {
+		return ctx, id
+	}
+	id := NewID()
+	return WithID(ctx, id), id
+}
+
+// Valid reports whether an incoming id is safe to log and store: non-empty,
+// at most 128 characters and printable ASCII without spaces.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware takes the id from the request header, or makes one when it is
+// missing or unusable, puts it in the request context and echoes it on the
+// response.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !Valid(id) {
+			id = NewID()
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(WithID(r.Context(), id)))
+	})
+}
+
+// Transport adds the request context's id to outgoing requests that do not
+// already carry one.
+type Transport struct {
+	Base http.RoundTripper // http.DefaultTransport when nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if id := FromContext(r.Context()); id != "" && r.Header.Get(Header) == "" {
+		r = r.Clone(r.Context())
+		r.Header.Set(Header, id)
+	}
+	return base.RoundTrip(r)
+}
+
+// Client is an http.Client that sends the context's id. Its timeout bounds
+// calls made with a context that has no deadline of its own.
+var Client = &http.Client{Transport: &Transport{}, Timeout: 30 * time.Second}
+
+// Stamp records id in OA transaction data. Data that is a JSON object keeps
+// its fields, whose values are copied verbatim so large numbers survive;
+// anything else is kept as a string under "data".
+func Stamp(data, id string) string {
+	if id == "" {
+		return data
+	}
+	fields := map[string]json.RawMessage{}
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), &fields); err != nil || fields == nil {
+			raw, _ := json.Marshal(data)
+			fields = map[string]json.RawMessage{"data": raw}
+		}
+	}
+	fields[DataKey], _ = json.Marshal(id)
+	out, _ := json.Marshal(fields)
+	return string(out)
+}
+
+// FromData returns the id stamped into OA transaction data, or "".
+func FromData(data string) string {
+	var fields map[string]any
+	if json.Unmarshal([]byte(data), &fields) != nil {
+		return ""
+	}
+	id, _ := fields[DataKey].(string)
+	return id
+}
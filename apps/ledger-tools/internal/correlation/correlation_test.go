@@ -0,0 +1,196 @@
+package correlation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNewID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id := NewID()
+		if !uuid.MatchString(id) {
+			t.Fatalf("NewID = %q, want a UUID v4", id)
+		}
+		if seen[id] {
+			t.Fatalf("NewID repeated %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{id: "0b6c8a2e-4f1d-4c3b-9a57-2f3e8d1c0a9b", want: true},
+		{id: "job:reportd/42", want: true},
+		{id: strings.Repeat("a", 128), want: true},
+		{id: "", want: false},
+		{id: strings.Repeat("a", 129), want: false},
+		{id: "has space", want: false},
+		{id: "line\nbreak", want: false},
+		{id: "café", want: false},
+		{id: "del\x7f", want: false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.id); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestEnsure(t *testing.T) {
+	ctx, id := Ensure(context.Background())
+	if id == "" || FromContext(ctx) != id {
+		t.Fatalf("Ensure on an empty context = %q, context has %q", id, FromContext(ctx))
+	}
+	if again, kept := Ensure(ctx); kept != id || FromContext(again) != id {
+		t.Errorf("Ensure replaced %q with %q", id, kept)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{name: "incoming id", header: "req-123", keep: true},
+		{name: "missing id", header: ""},
+		{name: "unusable id", header: "bad id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = FromContext(r.Context())
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(Header, tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			echoed := w.Header().Get(Header)
+			if seen == "" || echoed != seen {
+				t.Fatalf("handler saw %q, response echoed %q", seen, echoed)
+			}
+			if tt.keep != (seen == tt.header) {
+				t.Errorf("id = %q for header %q, keep %v", seen, tt.header, tt.keep)
+			}
+		})
+	}
+}
+
+type recordTransport struct{ got string }
+
+func (rt *recordTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rt.got = r.Header.Get(Header)
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+}
+
+func TestTransport(t *testing.T) {
+	tests := []struct {
+		name   string
+		ctxID  string
+		header string
+		want   string
+	}{
+		{name: "from context", ctxID: "ctx-1", want: "ctx-1"},
+		{name: "header wins", ctxID: "ctx-1", header: "hdr-1", want: "hdr-1"},
+		{name: "no id", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.ctxID != "" {
+				ctx = WithID(ctx, tt.ctxID)
+			}
+			r := httptest.NewRequest(http.MethodPost, "http://oa.internal/tx", nil).WithContext(ctx)
+			r.RequestURI = ""
+			if tt.header != "" {
+				r.Header.Set(Header, tt.header)
+			}
+			rt := &recordTransport{}
+			if _, err := (&Transport{Base: rt}).RoundTrip(r); err != nil {
+				t.Fatal(err)
+			}
+			if rt.got != tt.want {
+				t.Errorf("sent %q, want %q", rt.got, tt.want)
+			}
+			if tt.header == "" && r.Header.Get(Header) != "" {
+				t.Errorf("the caller's request was modified")
+			}
+		})
+	}
+}
+
+func TestStamp(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		id   string
+		want string
+	}{
+		{name: "no id", data: `{"a":1}`, id: "", want: `{"a":1}`},
+		{name: "empty data", data: "", id: "r1", want: `{"correlationId":"r1"}`},
+		{name: "object keeps its fields", data: `{"invoice":"INV-1","n":2}`, id: "r1",
+			want: `{"correlationId":"r1","invoice":"INV-1","n":2}`},
+		{name: "keeps large numbers and nested values verbatim",
+			data: `{"amount":12345678901234567890,"rate":1.10,"meta":{"z":1,"a":[2,1]}}`, id: "r1",
+			want: `{"amount":12345678901234567890,"correlationId":"r1","meta":{"z":1,"a":[2,1]},"rate":1.10}`},
+		{name: "replaces an earlier id", data: `{"correlationId":"old"}`, id: "r1", want: `{"correlationId":"r1"}`},
+		{name: "plain text", data: "pos import", id: "r1", want: `{"correlationId":"r1","data":"pos import"}`},
+		{name: "JSON array", data: `[1,2]`, id: "r1", want: `{"correlationId":"r1","data":"[1,2]"}`},
+		{name: "JSON null", data: `null`, id: "r1", want: `{"correlationId":"r1","data":"null"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Stamp(tt.data, tt.id)
+			if got != tt.want {
+				t.Errorf("Stamp(%q, %q) = %s, want %s", tt.data, tt.id, got, tt.want)
+			}
+			if tt.id != "" && FromData(got) != tt.id {
+				t.Errorf("FromData(%s) = %q, want %q", got, FromData(got), tt.id)
+			}
+		})
+	}
+}
+
+func TestFromData(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{data: `{"correlationId":"r1"}`, want: "r1"},
+		{data: `{"correlationId":7}`, want: ""},
+		{data: `{"other":"r1"}`, want: ""},
+		{data: `not json`, want: ""},
+		{data: ``, want: ""},
+	}
+	for _, tt := range tests {
+		if got := FromData(tt.data); got != tt.want {
+			t.Errorf("FromData(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestLike(t *testing.T) {
+	tests := []struct{ id, want string }{
+		{id: "abc-123", want: "%abc-123%"},
+		{id: `a_b%c\d`, want: `%a\_b\%c\\d%`},
+	}
+	for _, tt := range tests {
+		if got := like(tt.id); got != tt.want {
+			t.Errorf("like(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package correlation
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Tables are the cashflowdb tables owned by the correlation log.
+var Tables = []schema.Table{
+	{
+		Name: "job_logs",
+		Create: `CREATE TABLE IF NOT EXISTS job_logs (
+  id BIGINT NOT NULL AUTO_INCREMENT,
+  correlation_id VARCHAR(128) NOT NULL,
+  job VARCHAR(100) NOT NULL,
+  message TEXT NOT NULL,
+  created_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (id),
+  INDEX job_logs_correlation_idx (correlation_id, created_at),
+  INDEX job_logs_job_idx (job, created_at)
+) ENGINE=InnoDB`,
+	},
+}
+
+// Log writes a job's log lines prefixed with the context's correlation id
+// and, when DB is set, keeps the lines that have one in job_logs.
+type Log struct {
+	DB     *sql.DB // cashflowdb; nil logs to Logger only
+	Job    string
+	Logger *log.Logger
+}
+
+// Printf logs one line for the work running under ctx.
+func (l *Log) Printf(ctx context.Context, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	id := FromContext(ctx)
+	if id == "" {
+		l.Logger.Print(msg)
+		return
+	}
+	l.Logger.Printf("[%s] %s", id, msg)
+	if l.DB == nil {
+		return
+	}
+	// The line is kept even when the work's own context was cancelled.
+	_, err := l.DB.ExecContext(context.Background(), `
+		INSERT INTO job_logs (correlation_id, job, message, created_at) VALUES (?, ?, ?, ?)`,
+		id, l.Job, msg, time.Now())
+	if err != nil {
+		l.Logger.Printf("[%s] job log: %v", id, err)
+	}
+}
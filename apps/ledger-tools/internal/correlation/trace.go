@@ -0,0 +1,170 @@
+package correlation
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Trace sources.
+const (
+	SourceAudit   = "audit"   // BFF audit_logs: API actions and the rows they changed
+	SourceJob     = "job"     // job_logs lines from the Go services
+	SourcePosting = "posting" // OA transactions stamped with the id
+)
+
+// Entry is one thing that happened under a correlation id.
+type Entry struct {
+	At     time.Time `json:"at"`
+	Source string    `json:"source"`
+	Ref    string    `json:"ref"`
+	Detail string    `json:"detail"`
+}
+
+// Trace collects the audit log rows, job log lines and OA postings tagged
+// with id since the given time, oldest first. oadb may be nil to leave out
+// postings.
+func Trace(ctx context.Context, cf, oadb cashflow.Querier, id string, since time.Time) ([]*Entry, error) {
+	var out []*Entry
+	for _, fn := range []func(context.Context, cashflow.Querier, string, time.Time) ([]*Entry, error){auditEntries, jobEntries} {
+		entries, err := fn(ctx, cf, id, since)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, entries...)
+	}
+	if oadb != nil {
+		entries, err := postingEntries(ctx, oadb, id, since)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, entries...)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].At.Before(out[j].At) })
+	return out, nil
+}
+
+// like matches id anywhere in a column.
+func like(id string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + r.Replace(id) + "%"
+}
+
+// auditEntries reads audit_logs whose metadata carries the id as requestId.
+// The BFF stores metadata both as an object and as a JSON-encoded string,
+// so the id is matched as text.
+func auditEntries(ctx context.Context, q cashflow.Querier, id string, since time.Time) ([]*Entry, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, createdAt, action, resource, resourceId, userId, method, url, oldValues IS NOT NULL, newValues IS NOT NULL
+		FROM audit_logs WHERE createdAt >= ? AND CAST(metadata AS CHAR) LIKE ? ORDER BY createdAt`, since, like(id))
+	if err != nil {
+		return nil, fmt.Errorf("audit_logs: %w", err)
+	}
+	defer rows.Close()
+
+	var out []*Entry
+	for rows.Next() {
+		var (
+			e                             = &Entry{Source: SourceAudit}
+			action, resource              string
+			resourceID, user, method, url sql.NullString
+			hasOld, hasNew                bool
+		)
+		if err := rows.Scan(&e.Ref, &e.At, &action, &resource, &resourceID, &user, &method, &url, &hasOld, &hasNew); err != nil {
+			return nil, err
+		}
+		d := action + " " + resource
+		if resourceID.Valid {
+			d += " " + resourceID.String
+		}
+		if method.Valid && url.Valid {
+			d += fmt.Sprintf(" (%s %s)", method.String, url.String)
+		}
+		if user.Valid {
+			d += " by " + user.String
+		}
+		switch {
+		case hasOld && hasNew:
+			d += "; row updated"
+		case hasNew:
+			d += "; row created"
+		case hasOld:
+			d += "; row deleted"
+		}
+		e.Detail = d
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func jobEntries(ctx context.Context, q cashflow.Querier, id string, since time.Time) ([]*Entry, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT job, message, created_at FROM job_logs
+		WHERE correlation_id = ? AND created_at >= ? ORDER BY created_at, id`, id, since)
+	if err != nil {
+		return nil, fmt.Errorf("job_logs: %w", err)
+	}
+	defer rows.Close()
+
+	var out []*Entry
+	for rows.Next() {
+		e := &Entry{Source: SourceJob}
+		if err := rows.Scan(&e.Ref, &e.Detail, &e.At); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// postingEntries reads OA transactions stamped with the id, with their live
+// split count and total debits. OA has no index on data, so since keeps the
+// scan to recent rows.
+func postingEntries(ctx context.Context, q cashflow.Querier, id string, since time.Time) ([]*Entry, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT LOWER(HEX(t.id)), t.inserted, t.updated, t.date, t.description, t.data, t.deleted, o.name, o.`+"`precision`"+`,
+		  COUNT(s.id), COALESCE(SUM(CASE WHEN s.amount > 0 THEN s.amount ELSE 0 END), 0)
+		FROM transaction t
+		JOIN org o ON o.id = t.orgId
+		LEFT JOIN split s ON s.transactionId = t.id AND s.deleted = false
+		WHERE t.updated >= ? AND t.data LIKE ?
+		GROUP BY t.id, t.inserted, t.updated, t.date, t.description, t.data, t.deleted, o.name, o.`+"`precision`"+`
+		ORDER BY t.inserted`, since.UnixMilli(), like(id))
+	if err != nil {
+		return nil, fmt.Errorf("OA transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var out []*Entry
+	for rows.Next() {
+		var (
+			txID, description, data, org string
+			inserted, updated, date      int64
+			deleted                      bool
+			precision, splits            int
+			debits                       int64
+		)
+		if err := rows.Scan(&txID, &inserted, &updated, &date, &description, &data, &deleted, &org, &precision, &splits, &debits); err != nil {
+			return nil, err
+		}
+		if FromData(data) != id {
+			continue
+		}
+		d := fmt.Sprintf("%s: %q dated %s, %d splits, debits %s",
+			org, description, time.UnixMilli(date).UTC().Format(time.DateOnly), splits, money.FromMinor(debits, precision))
+		out = append(out, &Entry{At: time.UnixMilli(inserted), Source: SourcePosting, Ref: txID, Detail: "posted " + d})
+		switch {
+		case deleted:
+			out = append(out, &Entry{At: time.UnixMilli(updated), Source: SourcePosting, Ref: txID, Detail: "deleted " + d})
+		case updated > inserted:
+			out = append(out, &Entry{At: time.UnixMilli(updated), Source: SourcePosting, Ref: txID, Detail: "last updated " + d})
+		}
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,75 @@
+// Package db opens the MySQL connections used by the ledger tools.
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/tenantlimit"
+)
+
+// Open connects to a MySQL DSN and verifies the connection.
+func Open(ctx context.Context, name, dsn string) (*sql.DB, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("%s database is not configured", name)
+	}
+
+	conn, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open %s database: %w", name, err)
+	}
+	return configure(ctx, name, conn, 10)
+}
+
+// OpenLimited is Open with every query counted against its context's
+// tenant in l. A query waiting for its tenant's slot already holds a pool
+// connection, idle on the server, so the pool is larger than Open's to
+// leave room for other tenants while one is queued.
+func OpenLimited(ctx context.Context, name, dsn string, l *tenantlimit.Limiter) (*sql.DB, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("%s database is not configured", name)
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open %s database: %w", name, err)
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("open %s database: %w", name, err)
+	}
+	return configure(ctx, name, sql.OpenDB(tenantlimit.NewConnector(connector, l)), 50)
+}
+
+func configure(ctx context.Context, name string, conn *sql.DB, maxOpen int) (*sql.DB, error) {
+	conn.SetMaxOpenConns(maxOpen)
+	conn.SetMaxIdleConns(5)
+	conn.SetConnMaxLifetime(5 * time.Minute)
+
+	pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := conn.PingContext(pingCtx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connect to %s database: %w", name, err)
+	}
+	return conn, nil
+}
+
+// InTx runs fn inside a transaction, committing on success.
+func InTx(ctx context.Context, conn *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			return fmt.Errorf("%w (rollback: %v)", err, rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,214 @@
+// Package dimensions tags ledger splits with analysis dimensions such as cost
+// center, project or department. Neither OA split rows nor cashflowdb
+// journal_entries carry dimensions, so tags live in a side table keyed by
+// ledger and split id: OA split.id for the "oa" ledger, journal_entries.id
+// for the "cashflow" ledger.
+//
+// Each organization defines its own dimensions. A dimension may take its
+// default value from the warehouse, branch or salesperson a posting came
+// from; Go posting paths call TagJournal so those defaults are applied as
+// journals are written.
+package dimensions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Ledgers a split can belong to.
+const (
+	LedgerCashflow = "cashflow"
+	LedgerOA       = "oa"
+)
+
+// Default sources: where a dimension's value comes from when a posting does
+// not set it.
+const (
+	FromWarehouseCostCenter = "warehouse.costCenter"
+	FromWarehouse           = "warehouse"
+	FromBranch              = "branch"
+	FromSalesperson         = "salesperson"
+)
+
+var sources = map[string]bool{
+	FromWarehouseCostCenter: true, FromWarehouse: true, FromBranch: true, FromSalesperson: true,
+}
+
+// Tag sources.
+const (
+	SourceDefault = "default"
+	SourceManual  = "manual"
+)
+
+// Tables are the cashflowdb tables owned by the dimensions subsystem.
+var Tables = []schema.Table{
+	{
+		Name: "dimensions",
+		Create: `CREATE TABLE IF NOT EXISTS dimensions (
+  organization_id VARCHAR(191) NOT NULL,
+  code VARCHAR(50) NOT NULL,
+  name VARCHAR(191) NOT NULL,
+  default_source VARCHAR(50) NULL,
+  is_active BOOLEAN NOT NULL DEFAULT true,
+  created_at DATETIME(3) NOT NULL,
+  updated_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (organization_id, code)
+) ENGINE=InnoDB`,
+	},
+	{
+		Name: "dimension_values",
+		Create: `CREATE TABLE IF NOT EXISTS dimension_values (
+  organization_id VARCHAR(191) NOT NULL,
+  dimension VARCHAR(50) NOT NULL,
+  code VARCHAR(191) NOT NULL,
+  name VARCHAR(191) NOT NULL,
+  is_active BOOLEAN NOT NULL DEFAULT true,
+  created_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (organization_id, dimension, code)
+) ENGINE=InnoDB`,
+	},
+	{
+		Name: "split_dimensions",
+		Create: `CREATE TABLE IF NOT EXISTS split_dimensions (
+  ledger VARCHAR(10) NOT NULL,
+  split_id VARCHAR(191) NOT NULL,
+  dimension VARCHAR(50) NOT NULL,
+  organization_id VARCHAR(191) NOT NULL,
+  value VARCHAR(191) NOT NULL,
+  source VARCHAR(20) NOT NULL,
+  tagged_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (ledger, split_id, dimension),
+  INDEX split_dimensions_org_value_idx (organization_id, dimension, value)
+) ENGINE=InnoDB`,
+	},
+}
+
+// Dimension is a dimensions row.
+type Dimension struct {
+	OrganizationID string
+	Code           string
+	Name           string
+	DefaultSource  string
+	Active         bool
+}
+
+// Value is a dimension_values row.
+type Value struct {
+	Dimension string
+	Code      string
+	Name      string
+	Active    bool
+}
+
+var codePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+
+// Define creates or updates a dimension.
+func Define(ctx context.Context, q cashflow.Querier, d *Dimension) error {
+	if !codePattern.MatchString(d.Code) {
+		return fmt.Errorf("dimension code %q must be lower_snake_case", d.Code)
+	}
+	if d.DefaultSource != "" && !sources[d.DefaultSource] {
+		return fmt.Errorf("unknown default source %q", d.DefaultSource)
+	}
+	if d.Name == "" {
+		d.Name = d.Code
+	}
+	now := time.Now()
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO dimensions (organization_id, code, name, default_source, is_active, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE name = VALUES(name), default_source = VALUES(default_source),
+		  is_active = VALUES(is_active), updated_at = VALUES(updated_at)`,
+		d.OrganizationID, d.Code, d.Name, cashflow.NullString(d.DefaultSource), d.Active, now, now)
+	return err
+}
+
+// List returns an organization's dimensions. With activeOnly set, inactive
+// dimensions are left out.
+func List(ctx context.Context, q cashflow.Querier, organizationID string, activeOnly bool) ([]*Dimension, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT organization_id, code, name, COALESCE(default_source, ''), is_active
+		FROM dimensions WHERE organization_id = ? AND (? = false OR is_active = true)
+		ORDER BY code`, organizationID, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Dimension
+	for rows.Next() {
+		d := &Dimension{}
+		if err := rows.Scan(&d.OrganizationID, &d.Code, &d.Name, &d.DefaultSource, &d.Active); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// Get loads one dimension.
+func Get(ctx context.Context, q cashflow.Querier, organizationID, code string) (*Dimension, error) {
+	d := &Dimension{}
+	err := q.QueryRowContext(ctx, `
+		SELECT organization_id, code, name, COALESCE(default_source, ''), is_active
+		FROM dimensions WHERE organization_id = ? AND code = ?`, organizationID, code).
+		Scan(&d.OrganizationID, &d.Code, &d.Name, &d.DefaultSource, &d.Active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("dimension %s: %w", code, cashflow.ErrNotFound)
+	}
+	return d, err
+}
+
+// SetValue creates or updates a value of a dimension.
+func SetValue(ctx context.Context, q cashflow.Querier, organizationID string, v *Value) error {
+	if v.Code == "" {
+		return errors.New("value code is required")
+	}
+	if v.Name == "" {
+		v.Name = v.Code
+	}
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO dimension_values (organization_id, dimension, code, name, is_active, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE name = VALUES(name), is_active = VALUES(is_active)`,
+		organizationID, v.Dimension, v.Code, v.Name, v.Active, time.Now())
+	return err
+}
+
+// ensureValue records a value propagated from master data unless it is
+// already known, keeping any name a user gave it.
+func ensureValue(ctx context.Context, q cashflow.Querier, organizationID string, v *Value) error {
+	_, err := q.ExecContext(ctx, `
+		INSERT IGNORE INTO dimension_values (organization_id, dimension, code, name, is_active, created_at)
+		VALUES (?, ?, ?, ?, true, ?)`,
+		organizationID, v.Dimension, v.Code, v.Name, time.Now())
+	return err
+}
+
+// Values returns the values of a dimension.
+func Values(ctx context.Context, q cashflow.Querier, organizationID, dimension string) ([]*Value, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT dimension, code, name, is_active FROM dimension_values
+		WHERE organization_id = ? AND dimension = ? ORDER BY code`, organizationID, dimension)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Value
+	for rows.Next() {
+		v := &Value{}
+		if err := rows.Scan(&v.Dimension, &v.Code, &v.Name, &v.Active); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,326 @@
+package dimensions
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+)
+
+// Report kinds.
+const (
+	TrialBalance  = "trial-balance"
+	ProfitAndLoss = "profit-and-loss"
+)
+
+// Untagged is the section for splits without a value of the dimension.
+const Untagged = "(untagged)"
+
+// Line is the activity of one account under one dimension value.
+type Line struct {
+	AccountID   string
+	AccountCode string
+	AccountName string
+	Class       string
+	Debit       money.Amount
+	Credit      money.Amount
+}
+
+// Balance is the line's balance on its normal side.
+func (l *Line) Balance() money.Amount {
+	if cashflow.DebitNormal(l.Class) {
+		return l.Debit - l.Credit
+	}
+	return l.Credit - l.Debit
+}
+
+// Section groups the lines of one dimension value.
+type Section struct {
+	Value string
+	Name  string
+	Lines []*Line
+}
+
+// Totals returns the section's debit and credit totals.
+func (s *Section) Totals() (debit, credit money.Amount) {
+	for _, l := range s.Lines {
+		if b := l.Debit - l.Credit; b > 0 {
+			debit += b
+		} else {
+			credit -= b
+		}
+	}
+	return debit, credit
+}
+
+// Profit returns income, expenses and their difference.
+func (s *Section) Profit() (income, expense, net money.Amount) {
+	for _, l := range s.Lines {
+		switch l.Class {
+		case cashflow.ClassIncome:
+			income += l.Balance()
+		case cashflow.ClassExpense:
+			expense += l.Balance()
+		}
+	}
+	return income, expense, income - expense
+}
+
+// Report is a trial balance or profit and loss split by one dimension.
+type Report struct {
+	Kind      string
+	Ledger    string
+	Dimension string
+	From      time.Time // zero for a trial balance
+	To        time.Time // exclusive
+	Sections  []*Section
+}
+
+// accumulator sums activity per (value, account).
+type accumulator struct {
+	kind     string
+	sections map[string]*Section
+	lines    map[[2]string]*Line
+}
+
+func newAccumulator(kind string) *accumulator {
+	return &accumulator{kind: kind, sections: map[string]*Section{}, lines: map[[2]string]*Line{}}
+}
+
+func (a *accumulator) add(value string, acct Line, debit, credit money.Amount) {
+	if a.kind == ProfitAndLoss && acct.Class != cashflow.ClassIncome && acct.Class != cashflow.ClassExpense {
+		return
+	}
+	if value == "" {
+		value = Untagged
+	}
+	key := [2]string{value, acct.AccountID}
+	l, ok := a.lines[key]
+	if !ok {
+		s, ok := a.sections[value]
+		if !ok {
+			s = &Section{Value: value, Name: value}
+			a.sections[value] = s
+		}
+		l = &acct
+		a.lines[key] = l
+		s.Lines = append(s.Lines, l)
+	}
+	l.Debit += debit
+	l.Credit += credit
+}
+
+func (a *accumulator) report(ctx context.Context, cf cashflow.Querier, r *Report, organizationID string) (*Report, error) {
+	values, err := Values(ctx, cf, organizationID, r.Dimension)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, v := range values {
+		names[v.Code] = v.Name
+	}
+	r.Sections = a.sorted(names)
+	return r, nil
+}
+
+// sorted names the sections and orders them by name, untagged last, with
+// each section's lines in chart order.
+func (a *accumulator) sorted(names map[string]string) []*Section {
+	var out []*Section
+	for _, s := range a.sections {
+		if n, ok := names[s.Value]; ok {
+			s.Name = n
+		}
+		sort.Slice(s.Lines, func(i, j int) bool {
+			if s.Lines[i].Class != s.Lines[j].Class {
+				return classOrder[s.Lines[i].Class] < classOrder[s.Lines[j].Class]
+			}
+			return s.Lines[i].AccountCode < s.Lines[j].AccountCode
+		})
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if (out[i].Value == Untagged) != (out[j].Value == Untagged) {
+			return out[j].Value == Untagged
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+var classOrder = map[string]int{
+	cashflow.ClassAsset: 1, cashflow.ClassLiability: 2, cashflow.ClassEquity: 3,
+	cashflow.ClassIncome: 4, cashflow.ClassExpense: 5,
+}
+
+// CashflowReport builds a report from cashflowdb journals dated in
+// [from, to). A zero from covers all history.
+func CashflowReport(ctx context.Context, q cashflow.Querier, organizationID, kind, dimension string, from, to time.Time) (*Report, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT COALESCE(sd.value, ''), la.id, la.code, la.name, la.type,
+		       SUM(je.debitAmount), SUM(je.creditAmount)
+		FROM journal_entries je
+		JOIN journals j ON j.id = je.journalId
+		JOIN ledger_accounts la ON la.id = je.accountId
+		LEFT JOIN split_dimensions sd
+		  ON sd.ledger = ? AND sd.split_id = je.id AND sd.dimension = ?
+		WHERE j.organizationId = ? AND j.status IN `+cashflow.LiveJournalStatuses+`
+		  AND j.journalDate >= ? AND j.journalDate < ?
+		GROUP BY sd.value, la.id, la.code, la.name, la.type`,
+		LedgerCashflow, dimension, organizationID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	acc := newAccumulator(kind)
+	for rows.Next() {
+		var value, accountType string
+		var l Line
+		var debit, credit money.Amount
+		if err := rows.Scan(&value, &l.AccountID, &l.AccountCode, &l.AccountName, &accountType, &debit, &credit); err != nil {
+			return nil, err
+		}
+		l.Class = cashflow.AccountClass(accountType)
+		acc.add(value, l, debit, credit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return acc.report(ctx, q, &Report{Kind: kind, Ledger: LedgerCashflow, Dimension: dimension, From: from, To: to}, organizationID)
+}
+
+// OAReport builds a report from the OA splits of the organization's OA org
+// dated in [from, to). Tags are read from cashflowdb and joined in memory.
+func OAReport(ctx context.Context, cf, oadb cashflow.Querier, organizationID, kind, dimension string, from, to time.Time) (*Report, error) {
+	orgID, err := oa.OrgForOrganization(ctx, cf, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	org, err := oa.GetOrg(ctx, oadb, orgID)
+	if err != nil {
+		return nil, err
+	}
+	chart, err := oa.LoadChart(ctx, oadb, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := SplitValues(ctx, cf, organizationID, LedgerOA, dimension)
+	if err != nil {
+		return nil, err
+	}
+
+	acc := newAccumulator(kind)
+	err = oa.Splits(ctx, oadb, orgID, from, to, func(s *oa.Split) error {
+		amount := money.FromMinor(s.Amount, org.Precision)
+		var debit, credit money.Amount
+		if amount > 0 {
+			debit = amount
+		} else {
+			credit = -amount
+		}
+		acc.add(tags[strconv.FormatInt(s.ID, 10)], Line{
+			AccountID:   s.AccountID,
+			AccountCode: chart.FullName(s.AccountID),
+			AccountName: chart.FullName(s.AccountID),
+			Class:       chart.Class(s.AccountID),
+		}, debit, credit)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acc.report(ctx, cf, &Report{Kind: kind, Ledger: LedgerOA, Dimension: dimension, From: from, To: to}, organizationID)
+}
+
+// SplitValues maps the split ids of a ledger tagged with a dimension to
+// their value.
+func SplitValues(ctx context.Context, q cashflow.Querier, organizationID, ledger, dimension string) (map[string]string, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT split_id, value FROM split_dimensions WHERE organization_id = ? AND ledger = ? AND dimension = ?`,
+		organizationID, ledger, dimension)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]string{}
+	for rows.Next() {
+		var split, value string
+		if err := rows.Scan(&split, &value); err != nil {
+			return nil, err
+		}
+		out[split] = value
+	}
+	return out, rows.Err()
+}
+
+// Print writes the report as text.
+func (r *Report) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	period := "as of " + r.To.AddDate(0, 0, -1).Format(time.DateOnly)
+	if r.Kind == ProfitAndLoss {
+		period = r.From.Format(time.DateOnly) + " to " + r.To.AddDate(0, 0, -1).Format(time.DateOnly)
+	}
+	fmt.Fprintf(w, "%s by %s (%s ledger), %s\n", r.Kind, r.Dimension, r.Ledger, period)
+
+	var grandDebit, grandCredit, grandNet money.Amount
+	for _, s := range r.Sections {
+		fmt.Fprintf(tw, "\n%s\t\t\t\n", sectionTitle(s))
+		if r.Kind == TrialBalance {
+			fmt.Fprintln(tw, "ACCOUNT\tCLASS\tDEBIT\tCREDIT\t")
+			for _, l := range s.Lines {
+				debit, credit := "", ""
+				if b := l.Debit - l.Credit; b > 0 {
+					debit = b.String()
+				} else if b < 0 {
+					credit = (-b).String()
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", accountLabel(l), l.Class, debit, credit)
+			}
+			debit, credit := s.Totals()
+			grandDebit += debit
+			grandCredit += credit
+			fmt.Fprintf(tw, "Total\t\t%s\t%s\t\n", debit, credit)
+			continue
+		}
+
+		fmt.Fprintln(tw, "ACCOUNT\tCLASS\tAMOUNT\t")
+		for _, l := range s.Lines {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t\n", accountLabel(l), l.Class, l.Balance())
+		}
+		income, expense, net := s.Profit()
+		grandNet += net
+		fmt.Fprintf(tw, "Income\t\t%s\t\n", income)
+		fmt.Fprintf(tw, "Expenses\t\t%s\t\n", expense)
+		fmt.Fprintf(tw, "Net profit\t\t%s\t\n", net)
+	}
+	if r.Kind == TrialBalance {
+		fmt.Fprintf(tw, "\nAll values\t\t%s\t%s\t\n", grandDebit, grandCredit)
+	} else {
+		fmt.Fprintf(tw, "\nNet profit, all values\t\t%s\t\n", grandNet)
+	}
+	tw.Flush()
+}
+
+func sectionTitle(s *Section) string {
+	if s.Name != s.Value {
+		return fmt.Sprintf("%s (%s)", s.Name, s.Value)
+	}
+	return s.Value
+}
+
+func accountLabel(l *Line) string {
+	if l.AccountCode == l.AccountName {
+		return l.AccountName
+	}
+	return l.AccountCode + " " + l.AccountName
+}
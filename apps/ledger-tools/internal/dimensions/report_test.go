@@ -0,0 +1,117 @@
+package dimensions
+
+import (
+	"testing"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+var (
+	cash     = Line{AccountID: "a1", AccountCode: "1000", AccountName: "Cash", Class: cashflow.ClassAsset}
+	payable  = Line{AccountID: "a2", AccountCode: "2000", AccountName: "Payables", Class: cashflow.ClassLiability}
+	sales    = Line{AccountID: "a4", AccountCode: "4000", AccountName: "Sales", Class: cashflow.ClassIncome}
+	rent     = Line{AccountID: "a6", AccountCode: "6100", AccountName: "Rent", Class: cashflow.ClassExpense}
+	supplies = Line{AccountID: "a5", AccountCode: "6000", AccountName: "Supplies", Class: cashflow.ClassExpense}
+)
+
+func TestAccumulator(t *testing.T) {
+	mm := money.MustParse
+	tests := []struct {
+		name     string
+		kind     string
+		sections []string // value and account code of each line, in order
+		totals   map[string][2]money.Amount
+		profit   map[string][3]money.Amount
+	}{
+		{
+			name:     "trial balance",
+			kind:     TrialBalance,
+			sections: []string{"Head office: 1000 2000 4000 6100", "YGN: 1000 4000 6000 6100", "(untagged): 1000 6000"},
+			totals: map[string][2]money.Amount{
+				"HQ":     {mm("1000"), mm("1500")},
+				"YGN":    {mm("600"), mm("500")},
+				Untagged: {mm("50"), mm("50")},
+			},
+			profit: map[string][3]money.Amount{
+				"HQ":     {mm("900"), mm("400"), mm("500")},
+				"YGN":    {mm("500"), mm("600"), mm("-100")},
+				Untagged: {0, mm("50"), mm("-50")},
+			},
+		},
+		{
+			name:     "profit and loss drops balance sheet accounts",
+			kind:     ProfitAndLoss,
+			sections: []string{"Head office: 4000 6100", "YGN: 4000 6000 6100", "(untagged): 6000"},
+			totals: map[string][2]money.Amount{
+				"HQ":     {mm("400"), mm("900")},
+				"YGN":    {mm("600"), mm("500")},
+				Untagged: {mm("50"), 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAccumulator(tt.kind)
+			a.add("YGN", cash, mm("500"), 0)
+			a.add("HQ", rent, mm("400"), 0)
+			a.add("HQ", sales, 0, mm("1000"))
+			a.add("YGN", rent, mm("200"), 0)
+			a.add("HQ", cash, mm("1000"), mm("400"))
+			a.add("HQ", sales, mm("100"), 0)
+			a.add("", supplies, mm("50"), 0)
+			a.add("YGN", supplies, mm("400"), 0)
+			a.add("HQ", payable, 0, mm("600"))
+			a.add("YGN", sales, 0, mm("500"))
+			a.add("YGN", cash, 0, mm("600"))
+			a.add("", cash, 0, mm("50"))
+			a.add("YGN", cash, mm("100"), 0)
+
+			sections := a.sorted(map[string]string{"HQ": "Head office", "YGN": "YGN"})
+			var got []string
+			for _, s := range sections {
+				line := s.Name + ":"
+				for _, l := range s.Lines {
+					line += " " + l.AccountCode
+				}
+				got = append(got, line)
+				if want, ok := tt.totals[s.Value]; ok {
+					if debit, credit := s.Totals(); debit != want[0] || credit != want[1] {
+						t.Errorf("%s totals = %s/%s, want %s/%s", s.Value, debit, credit, want[0], want[1])
+					}
+				}
+				if want, ok := tt.profit[s.Value]; ok {
+					if income, expense, net := s.Profit(); income != want[0] || expense != want[1] || net != want[2] {
+						t.Errorf("%s profit = %s %s %s, want %v", s.Value, income, expense, net, want)
+					}
+				}
+			}
+			if len(got) != len(tt.sections) {
+				t.Fatalf("sections = %q, want %q", got, tt.sections)
+			}
+			for i := range got {
+				if got[i] != tt.sections[i] {
+					t.Errorf("section %d = %q, want %q", i, got[i], tt.sections[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLineBalance(t *testing.T) {
+	mm := money.MustParse
+	tests := []struct {
+		line Line
+		want money.Amount
+	}{
+		{line: Line{Class: cashflow.ClassAsset, Debit: mm("100"), Credit: mm("30")}, want: mm("70")},
+		{line: Line{Class: cashflow.ClassExpense, Debit: mm("10"), Credit: mm("30")}, want: mm("-20")},
+		{line: Line{Class: cashflow.ClassIncome, Debit: mm("10"), Credit: mm("30")}, want: mm("20")},
+		{line: Line{Class: cashflow.ClassEquity, Credit: mm("5")}, want: mm("5")},
+	}
+	for _, tt := range tests {
+		if got := tt.line.Balance(); got != tt.want {
+			t.Errorf("%s balance = %s, want %s", tt.line.Class, got, tt.want)
+		}
+	}
+}
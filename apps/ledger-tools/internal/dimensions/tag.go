@@ -0,0 +1,310 @@
+package dimensions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+)
+
+// Origin is the master data a posting came from. Empty fields are unknown.
+// A warehouse implies its branch.
+type Origin struct {
+	WarehouseID   string
+	BranchID      string
+	SalespersonID string
+}
+
+// Tags maps dimension codes to value codes.
+type Tags map[string]string
+
+// ParseTags reads "cost_center=HQ,project=P-12" style assignments.
+func ParseTags(s string) (Tags, error) {
+	out := Tags{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid dimension assignment %q; use code=value", part)
+		}
+		out[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return out, nil
+}
+
+// TagJournal tags every entry of a cashflowdb journal. Explicit values win;
+// other active dimensions take their default from the origin. Explicit
+// values must already exist; defaults are added to dimension_values as they
+// are first seen. Organizations that have not run the dimensions migration
+// are left alone.
+func TagJournal(ctx context.Context, q cashflow.Querier, organizationID, journalID string, o Origin, explicit Tags) error {
+	dims, err := List(ctx, q, organizationID, true)
+	if missingTable(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("load dimensions: %w", err)
+	}
+	if len(dims) == 0 {
+		return nil
+	}
+
+	values, err := resolve(ctx, q, organizationID, dims, o, explicit)
+	if err != nil || len(values) == 0 {
+		return err
+	}
+
+	entries, err := JournalEntries(ctx, q, journalID)
+	if err != nil {
+		return err
+	}
+	return write(ctx, q, organizationID, LedgerCashflow, entries, values)
+}
+
+// JournalEntries returns the ids of a journal's entries, its splits in the
+// cashflow ledger.
+func JournalEntries(ctx context.Context, q cashflow.Querier, journalID string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT id FROM journal_entries WHERE journalId = ? ORDER BY id`, journalID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// tagged is a resolved value with how it was chosen.
+type tagged struct {
+	value  string
+	source string
+}
+
+func resolve(ctx context.Context, q cashflow.Querier, organizationID string, dims []*Dimension, o Origin, explicit Tags) (map[string]tagged, error) {
+	known := map[string]bool{}
+	for _, d := range dims {
+		known[d.Code] = true
+	}
+	for code := range explicit {
+		if !known[code] {
+			return nil, fmt.Errorf("dimension %q is not defined or not active", code)
+		}
+	}
+
+	m := &masterData{q: q, organizationID: organizationID, origin: o}
+	out := map[string]tagged{}
+	for _, d := range dims {
+		if v, ok := explicit[d.Code]; ok {
+			if err := checkValue(ctx, q, organizationID, d.Code, v); err != nil {
+				return nil, err
+			}
+			out[d.Code] = tagged{value: v, source: SourceManual}
+			continue
+		}
+		if d.DefaultSource == "" {
+			continue
+		}
+		v, err := m.value(ctx, d.DefaultSource)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		v.Dimension = d.Code
+		if err := ensureValue(ctx, q, organizationID, v); err != nil {
+			return nil, err
+		}
+		out[d.Code] = tagged{value: v.Code, source: SourceDefault}
+	}
+	return out, nil
+}
+
+func checkValue(ctx context.Context, q cashflow.Querier, organizationID, dimension, value string) error {
+	if value == "" {
+		return nil // clears the tag
+	}
+	var active bool
+	err := q.QueryRowContext(ctx, `
+		SELECT is_active FROM dimension_values WHERE organization_id = ? AND dimension = ? AND code = ?`,
+		organizationID, dimension, value).Scan(&active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s value %q: %w", dimension, value, cashflow.ErrNotFound)
+	} else if err != nil {
+		return err
+	}
+	if !active {
+		return fmt.Errorf("%s value %q is inactive", dimension, value)
+	}
+	return nil
+}
+
+func write(ctx context.Context, q cashflow.Querier, organizationID, ledger string, splits []string, values map[string]tagged) error {
+	now := time.Now()
+	for _, split := range splits {
+		for dim, t := range values {
+			if t.value == "" {
+				if _, err := q.ExecContext(ctx, `
+					DELETE FROM split_dimensions WHERE ledger = ? AND split_id = ? AND dimension = ?`,
+					ledger, split, dim); err != nil {
+					return err
+				}
+				continue
+			}
+			_, err := q.ExecContext(ctx, `
+				INSERT INTO split_dimensions (ledger, split_id, dimension, organization_id, value, source, tagged_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+				ON DUPLICATE KEY UPDATE value = VALUES(value), source = VALUES(source), tagged_at = VALUES(tagged_at)`,
+				ledger, split, dim, organizationID, t.value, t.source, now)
+			if err != nil {
+				return fmt.Errorf("tag %s split %s: %w", ledger, split, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Tag sets dimension values on splits by hand. An empty value removes the
+// tag. Values must exist in dimension_values.
+func Tag(ctx context.Context, q cashflow.Querier, organizationID, ledger string, splits []string, values Tags) error {
+	if ledger != LedgerCashflow && ledger != LedgerOA {
+		return fmt.Errorf("unknown ledger %q", ledger)
+	}
+	dims, err := List(ctx, q, organizationID, true)
+	if err != nil {
+		return err
+	}
+	// Without an origin no defaults apply, so only the given values are set.
+	resolved, err := resolve(ctx, q, organizationID, dims, Origin{}, values)
+	if err != nil {
+		return err
+	}
+	return write(ctx, q, organizationID, ledger, splits, resolved)
+}
+
+// SplitTags returns the dimension values of one split.
+func SplitTags(ctx context.Context, q cashflow.Querier, ledger, split string) (Tags, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT dimension, value FROM split_dimensions WHERE ledger = ? AND split_id = ?`, ledger, split)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := Tags{}
+	for rows.Next() {
+		var d, v string
+		if err := rows.Scan(&d, &v); err != nil {
+			return nil, err
+		}
+		out[d] = v
+	}
+	return out, rows.Err()
+}
+
+// masterData looks up the origin's warehouse, branch and salesperson once.
+type masterData struct {
+	q              cashflow.Querier
+	organizationID string
+	origin         Origin
+
+	warehouse *warehouse
+	loaded    bool
+}
+
+type warehouse struct {
+	id, code, name, branchID, costCenter string
+}
+
+func (m *masterData) loadWarehouse(ctx context.Context) (*warehouse, error) {
+	if m.loaded || m.origin.WarehouseID == "" {
+		return m.warehouse, nil
+	}
+	m.loaded = true
+	w := &warehouse{}
+	err := m.q.QueryRowContext(ctx, `
+		SELECT id, COALESCE(code, ''), name, branchId, COALESCE(costCenter, '') FROM warehouses
+		WHERE organizationId = ? AND (id = ? OR code = ? OR name = ?) ORDER BY id = ? DESC LIMIT 1`,
+		m.organizationID, m.origin.WarehouseID, m.origin.WarehouseID, m.origin.WarehouseID, m.origin.WarehouseID).
+		Scan(&w.id, &w.code, &w.name, &w.branchID, &w.costCenter)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	m.warehouse = w
+	return w, nil
+}
+
+// value returns the default value a source gives, or nil when the origin
+// does not determine one.
+func (m *masterData) value(ctx context.Context, source string) (*Value, error) {
+	switch source {
+	case FromWarehouseCostCenter, FromWarehouse:
+		w, err := m.loadWarehouse(ctx)
+		if err != nil || w == nil {
+			return nil, err
+		}
+		if source == FromWarehouse {
+			code := w.code
+			if code == "" {
+				code = w.id
+			}
+			return &Value{Code: code, Name: w.name}, nil
+		}
+		if w.costCenter == "" {
+			return nil, nil
+		}
+		return &Value{Code: w.costCenter, Name: w.costCenter}, nil
+
+	case FromBranch:
+		branchID := m.origin.BranchID
+		if branchID == "" {
+			w, err := m.loadWarehouse(ctx)
+			if err != nil || w == nil {
+				return nil, err
+			}
+			branchID = w.branchID
+		}
+		return m.named(ctx, `SELECT name FROM branches WHERE id = ? AND organizationId = ?`, branchID)
+
+	case FromSalesperson:
+		return m.named(ctx, `SELECT name FROM salespersons WHERE id = ? AND organizationId = ?`, m.origin.SalespersonID)
+	}
+	return nil, fmt.Errorf("unknown default source %q", source)
+}
+
+func (m *masterData) named(ctx context.Context, query, id string) (*Value, error) {
+	if id == "" {
+		return nil, nil
+	}
+	var name string
+	err := m.q.QueryRowContext(ctx, query, id, m.organizationID).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &Value{Code: id, Name: name}, nil
+}
+
+// missingTable reports MySQL error 1146, table doesn't exist.
+func missingTable(err error) bool {
+	var myErr *mysql.MySQLError
+	return errors.As(err, &myErr) && myErr.Number == 1146
+}
@@ -0,0 +1,36 @@
+package dimensions
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Tags
+	}{
+		{in: "", want: Tags{}},
+		{in: "cost_center=HQ", want: Tags{"cost_center": "HQ"}},
+		{in: " cost_center = HQ , project=P-12,", want: Tags{"cost_center": "HQ", "project": "P-12"}},
+		{in: "project=", want: Tags{"project": ""}},
+		{in: "project=P-1,project=P-2", want: Tags{"project": "P-2"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseTags(tt.in)
+		if err != nil {
+			t.Errorf("ParseTags(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseTags(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"HQ", "=HQ", "cost_center=HQ,project"} {
+		if _, err := ParseTags(in); err == nil || !strings.Contains(err.Error(), "use code=value") {
+			t.Errorf("ParseTags(%q) error = %v", in, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Package equity builds the statement of changes in equity from the OA
+// equity accounts and checks the retained earnings roll-forward against the
+// year-end closes recorded in year_end_closing_runs.
+//
+// Opening retained earnings of a year should equal the previous opening
+// plus the net income the close recorded. Anything else that moved retained
+// earnings (direct postings, P&L posted into a closed year after its close,
+// a close that disagrees with the ledger) is reported as a finding.
+package equity
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+)
+
+// Year is a fiscal year. Fiscal year N starts in calendar year N, as the
+// BFF numbers them.
+type Year struct {
+	FiscalYear int
+	Start      time.Time
+	End        time.Time // exclusive
+}
+
+// Years returns fiscal years first..last, taking their dates from
+// accounting_periods and falling back to the organization profile's fiscal
+// year start (1 January without a profile).
+func Years(ctx context.Context, q cashflow.Querier, organizationID string, first, last int) ([]Year, error) {
+	month, dayOfMonth := 1, 1
+	err := q.QueryRowContext(ctx, `
+		SELECT fiscal_year_start_month, fiscal_year_start_day FROM organization_profiles WHERE organization_id = ?`,
+		organizationID).Scan(&month, &dayOfMonth)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	periods := map[int]Year{}
+	rows, err := q.QueryContext(ctx, `
+		SELECT fiscal_year, MIN(start_date), MAX(end_date) FROM accounting_periods
+		WHERE organization_id = ? AND fiscal_year BETWEEN ? AND ?
+		GROUP BY fiscal_year`, organizationID, first, last)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var y Year
+		if err := rows.Scan(&y.FiscalYear, &y.Start, &y.End); err != nil {
+			return nil, err
+		}
+		y.Start, y.End = day(y.Start), day(y.End).AddDate(0, 0, 1)
+		periods[y.FiscalYear] = y
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var out []Year
+	for n := first; n <= last; n++ {
+		y, ok := periods[n]
+		if !ok {
+			start := time.Date(n, time.Month(month), dayOfMonth, 0, 0, 0, 0, time.Local)
+			y = Year{FiscalYear: n, Start: start, End: start.AddDate(1, 0, 0)}
+		}
+		if len(out) > 0 && y.Start.Before(out[len(out)-1].End) {
+			return nil, fmt.Errorf("fiscal year %d starts %s, before fiscal year %d ends on %s",
+				n, y.Start.Format(time.DateOnly), n-1, out[len(out)-1].End.AddDate(0, 0, -1).Format(time.DateOnly))
+		}
+		out = append(out, y)
+	}
+	return out, nil
+}
+
+// day turns a DATE column, read as midnight UTC, into local midnight.
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// Closing run statuses the BFF writes.
+const (
+	RunCompleted = "completed"
+	RunFailed    = "failed"
+)
+
+// Run is a year_end_closing_runs row.
+type Run struct {
+	ID                        string
+	FiscalYear                int
+	ClosingDate               time.Time
+	Status                    string
+	ClosingJournalID          string
+	OATransactionID           string
+	TotalIncome               money.Amount
+	TotalExpenses             money.Amount
+	NetIncome                 money.Amount
+	RetainedEarningsAccountID string
+	CompletedAt               *time.Time
+}
+
+// Runs returns the organization's closing runs by fiscal year.
+func Runs(ctx context.Context, q cashflow.Querier, organizationID string) (map[int]*Run, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, fiscal_year, closing_date, status, COALESCE(closing_journal_id, ''),
+		  COALESCE(oa_closing_transaction_id, ''), total_income, total_expenses, net_income,
+		  retained_earnings_account_id, completed_at
+		FROM year_end_closing_runs WHERE organization_id = ?`, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[int]*Run{}
+	for rows.Next() {
+		r := &Run{}
+		var completed sql.NullTime
+		if err := rows.Scan(&r.ID, &r.FiscalYear, &r.ClosingDate, &r.Status, &r.ClosingJournalID, &r.OATransactionID,
+			&r.TotalIncome, &r.TotalExpenses, &r.NetIncome, &r.RetainedEarningsAccountID, &completed); err != nil {
+			return nil, err
+		}
+		r.ClosingDate = day(r.ClosingDate)
+		r.OATransactionID = oa.NormalizeID(r.OATransactionID)
+		if completed.Valid {
+			r.CompletedAt = &completed.Time
+		}
+		out[r.FiscalYear] = r
+	}
+	return out, rows.Err()
+}
+
+// RetainedEarnings finds the OA retained earnings account: the equity leaf
+// at path when given, else the one named like the organization's
+// retained_earnings_account_id ledger account, else the equity leaf called
+// "Retained Earnings".
+func RetainedEarnings(ctx context.Context, cf cashflow.Querier, chart *oa.Chart, organizationID, path string) (string, error) {
+	var leaves []string
+	for id := range chart.Accounts {
+		if chart.Leaf(id) && chart.Class(id) == cashflow.ClassEquity {
+			leaves = append(leaves, id)
+		}
+	}
+	find := func(match func(id string) bool) string {
+		for _, id := range leaves {
+			if match(id) {
+				return id
+			}
+		}
+		return ""
+	}
+
+	if path != "" {
+		if id := find(func(id string) bool { return strings.EqualFold(chart.FullName(id), path) }); id != "" {
+			return id, nil
+		}
+		return "", fmt.Errorf("OA equity account %s: %w", path, cashflow.ErrNotFound)
+	}
+	var name string
+	err := cf.QueryRowContext(ctx, `
+		SELECT la.name FROM organization_profiles p JOIN ledger_accounts la ON la.id = p.retained_earnings_account_id
+		WHERE p.organization_id = ?`, organizationID).Scan(&name)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	for _, want := range []string{name, "Retained Earnings"} {
+		if want == "" {
+			continue
+		}
+		if id := find(func(id string) bool { return strings.EqualFold(chart.Accounts[id].Name, want) }); id != "" {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("no OA retained earnings account; pass its path: %w", cashflow.ErrNotFound)
+}
@@ -0,0 +1,243 @@
+package equity
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+)
+
+// Finding kinds.
+const (
+	KindDirectPosting  = "direct-posting"   // retained earnings moved outside a close
+	KindProfitToEquity = "profit-to-equity" // income or expense booked straight against equity
+	KindPostClose      = "post-close"       // P&L posted into a year after its close completed
+	KindNetIncome      = "net-income"       // the close's net income disagrees with OA profit
+	KindCloseAmount    = "close-amount"     // the OA closing transaction moved a different amount
+	KindCloseMissing   = "close-missing"    // the OA closing transaction is not in the year
+	KindNotCompleted   = "not-completed"    // the close failed or never finished
+	KindNotClosed      = "not-closed"       // a past year without a close
+)
+
+// Finding is an equity movement the closes do not explain.
+type Finding struct {
+	FiscalYear    int
+	Kind          string
+	TransactionID string
+	Date          time.Time
+	Description   string
+	Amount        money.Amount // credit positive
+	Detail        string
+}
+
+// RollForward is the retained earnings check for one year: accumulated
+// earnings (retained earnings plus unclosed profit) should move by exactly
+// the net income the close recorded.
+type RollForward struct {
+	Opening   money.Amount
+	NetIncome money.Amount // from year_end_closing_runs
+	Closing   money.Amount // opening of the next year
+	// The difference explained: profit posted after the close, other
+	// profit differences, direct postings and a close that did not net to
+	// zero within accumulated earnings.
+	PostClose     money.Amount
+	ProfitGap     money.Amount
+	DirectPosting money.Amount
+	CloseNet      money.Amount
+}
+
+// Expected is the opening plus the recorded net income.
+func (r *RollForward) Expected() money.Amount { return r.Opening + r.NetIncome }
+
+// Difference is the unexplained change, zero when the roll-forward holds.
+func (r *RollForward) Difference() money.Amount { return r.Closing - r.Expected() }
+
+// RollForward returns the check for a year; nil without a completed close.
+func (s *Statement) RollForward(ys *YearStatement) *RollForward {
+	if ys.Run == nil || ys.Run.Status != RunCompleted {
+		return nil
+	}
+	r := &RollForward{
+		Opening:       s.Accumulated(ys.Opening),
+		NetIncome:     ys.Run.NetIncome,
+		Closing:       s.Accumulated(ys.Closing),
+		DirectPosting: ys.Other[s.RetainedEarnings] + ys.Other[Unclosed],
+		CloseNet:      s.Accumulated(ys.Transfer),
+	}
+	for _, f := range ys.Findings {
+		if f.Kind == KindPostClose {
+			r.PostClose += f.Amount
+		}
+	}
+	r.ProfitGap = ys.Profit - ys.Run.NetIncome - r.PostClose
+	return r
+}
+
+// Findings returns every year's findings in order.
+func (s *Statement) Findings() []*Finding {
+	var out []*Finding
+	for _, ys := range s.Years {
+		out = append(out, ys.Findings...)
+	}
+	return out
+}
+
+func (s *Statement) check(ctx context.Context, oadb cashflow.Querier, txns map[string]*txn, closings map[string]int) error {
+	ids := s.classify(txns, closings, time.Now())
+	descriptions, err := describe(ctx, oadb, ids)
+	if err != nil {
+		return err
+	}
+	for _, ys := range s.Years {
+		for _, f := range ys.Findings {
+			f.Description = descriptions[f.TransactionID]
+		}
+	}
+	return nil
+}
+
+// classify records the findings of each transaction and year, in date
+// order, and returns the transactions they name.
+func (s *Statement) classify(txns map[string]*txn, closings map[string]int, now time.Time) []string {
+	var ids []string
+	for _, t := range txns {
+		add := func(kind string, amount money.Amount, detail string) {
+			t.year.Findings = append(t.year.Findings, &Finding{
+				FiscalYear: t.year.Year.FiscalYear, Kind: kind, TransactionID: t.id, Date: t.date, Amount: amount, Detail: detail,
+			})
+			ids = append(ids, t.id)
+		}
+		if t.closing {
+			if fy := closings[t.id]; fy != t.year.Year.FiscalYear {
+				add(KindCloseMissing, t.retained, fmt.Sprintf("closing transaction of fiscal year %d is dated in %d", fy, t.year.Year.FiscalYear))
+			}
+			continue
+		}
+		switch {
+		case t.retained != 0:
+			add(KindDirectPosting, t.retained, "posted to retained earnings outside a year-end close")
+		case t.equity != 0 && t.profit != 0:
+			add(KindProfitToEquity, t.equity, "income or expense booked against an equity account")
+		}
+		if t.postClose && t.profit != 0 {
+			add(KindPostClose, t.profit, fmt.Sprintf("entered %s, after the close completed", t.inserted.Format(time.DateTime)))
+		}
+	}
+
+	for _, ys := range s.Years {
+		fy := ys.Year.FiscalYear
+		add := func(kind string, amount money.Amount, detail string) {
+			ys.Findings = append(ys.Findings, &Finding{FiscalYear: fy, Kind: kind, Date: ys.Year.End.AddDate(0, 0, -1), Amount: amount, Detail: detail})
+		}
+		switch {
+		case ys.Run == nil:
+			if ys.Year.End.Before(now) {
+				add(KindNotClosed, ys.Profit, "fiscal year ended without a year-end close")
+			}
+			continue
+		case ys.Run.Status != RunCompleted:
+			add(KindNotCompleted, ys.Run.NetIncome, "year-end close is "+ys.Run.Status)
+			continue
+		}
+		if r := s.RollForward(ys); r.ProfitGap != 0 {
+			add(KindNetIncome, r.ProfitGap, fmt.Sprintf("OA profit %s, close recorded %s", ys.Profit, ys.Run.NetIncome))
+		}
+		if ys.Run.OATransactionID == "" {
+			continue
+		}
+		if _, ok := txns[ys.Run.OATransactionID]; !ok {
+			add(KindCloseMissing, 0, "OA closing transaction "+ys.Run.OATransactionID+" has no live equity or P&L splits")
+		} else if moved := ys.Transfer[s.RetainedEarnings]; moved != ys.Run.NetIncome {
+			add(KindCloseAmount, moved-ys.Run.NetIncome,
+				fmt.Sprintf("closing transaction moved %s to retained earnings, close recorded %s", moved, ys.Run.NetIncome))
+		}
+	}
+
+	for _, ys := range s.Years {
+		sort.SliceStable(ys.Findings, func(i, j int) bool {
+			a, b := ys.Findings[i], ys.Findings[j]
+			if !a.Date.Equal(b.Date) {
+				return a.Date.Before(b.Date)
+			}
+			return a.TransactionID < b.TransactionID
+		})
+	}
+	return ids
+}
+
+// describe loads OA transaction descriptions.
+func describe(ctx context.Context, q cashflow.Querier, ids []string) (map[string]string, error) {
+	out := map[string]string{}
+	for len(ids) > 0 {
+		n := min(len(ids), 500)
+		batch := ids[:n]
+		ids = ids[n:]
+
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			args[i] = oa.NormalizeID(id)
+		}
+		rows, err := q.QueryContext(ctx, `
+			SELECT LOWER(HEX(id)), description FROM transaction
+			WHERE id IN (`+strings.TrimSuffix(strings.Repeat("UNHEX(?), ", n), ", ")+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id, desc string
+			if err := rows.Scan(&id, &desc); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			out[id] = desc
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// PrintRollForward writes the retained earnings roll-forward and the
+// findings of every year.
+func (s *Statement) PrintRollForward(w io.Writer) {
+	fmt.Fprintf(w, "Retained earnings roll-forward (%s; retained earnings plus unclosed profit)\n\n", s.Currency)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FISCAL YEAR\tOPENING\tNET INCOME (CLOSE)\tEXPECTED\tCLOSING\tDIFFERENCE\tPOST-CLOSE\tOTHER PROFIT\tDIRECT POSTINGS\tCLOSE NET\t")
+	for _, ys := range s.Years {
+		r := s.RollForward(ys)
+		if r == nil {
+			status := "not closed"
+			if ys.Run != nil {
+				status = "close " + ys.Run.Status
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t\t%s\t\t\t\t\t\t\n", ys.Year.FiscalYear, s.Accumulated(ys.Opening), status, s.Accumulated(ys.Closing))
+			continue
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", ys.Year.FiscalYear, r.Opening, r.NetIncome, r.Expected(),
+			r.Closing, r.Difference(), r.PostClose, r.ProfitGap, r.DirectPosting, r.CloseNet)
+	}
+	tw.Flush()
+
+	findings := s.Findings()
+	if len(findings) == 0 {
+		fmt.Fprintln(w, "\nNo unexplained equity movements")
+		return
+	}
+	fmt.Fprintf(w, "\n%d unexplained equity movements\n", len(findings))
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FY\tKIND\tDATE\tTRANSACTION\tAMOUNT\tDESCRIPTION\tDETAIL\t")
+	for _, f := range findings {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t\n", f.FiscalYear, f.Kind, f.Date.Format(time.DateOnly),
+			f.TransactionID, f.Amount, f.Description, f.Detail)
+	}
+	tw.Flush()
+}
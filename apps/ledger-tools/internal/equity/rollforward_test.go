@@ -0,0 +1,108 @@
+package equity
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+func fiscalYear(fy int) Year {
+	return Year{FiscalYear: fy, Start: time.Date(fy, 1, 1, 0, 0, 0, 0, time.UTC), End: time.Date(fy+1, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// columns builds a column set from retained earnings and unclosed profit.
+func columns(retained, unclosed string) map[string]money.Amount {
+	return map[string]money.Amount{"re": money.MustParse(retained), Unclosed: money.MustParse(unclosed)}
+}
+
+func TestRollForward(t *testing.T) {
+	mm := money.MustParse
+	y2023 := &YearStatement{
+		Year: fiscalYear(2023), Run: &Run{Status: RunCompleted, NetIncome: mm("1000"), OATransactionID: "close23"},
+		Opening: columns("5000", "0"), Profit: mm("1000"), Transfer: columns("1000", "-1000"),
+		Other: map[string]money.Amount{}, Closing: columns("6000", "0"),
+	}
+	// 2024 closed at 800 although OA shows 900 of profit, 50 of it entered
+	// after the close, and 200 was posted straight to retained earnings.
+	y2024 := &YearStatement{
+		Year: fiscalYear(2024), Run: &Run{Status: RunCompleted, NetIncome: mm("800"), OATransactionID: "close24"},
+		Opening: columns("6000", "0"), Profit: mm("900"), Transfer: columns("790", "-800"),
+		Other: columns("200", "0"), Closing: columns("6990", "100"),
+	}
+	y2025 := &YearStatement{Year: fiscalYear(2025), Opening: columns("6990", "100"), Profit: mm("300"),
+		Closing: columns("6990", "400")}
+	y2026 := &YearStatement{Year: fiscalYear(2026), Run: &Run{Status: "failed", NetIncome: mm("75")},
+		Opening: columns("6990", "400"), Closing: columns("6990", "400")}
+	s := &Statement{RetainedEarnings: "re", Years: []*YearStatement{y2023, y2024, y2025, y2026}}
+
+	on := func(y, m, d int) time.Time { return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	txns := map[string]*txn{
+		"close23": {id: "close23", year: y2023, date: on(2023, 12, 31), retained: mm("1000"), profit: mm("-1000"), closing: true},
+		"close24": {id: "close24", year: y2024, date: on(2024, 12, 31), retained: mm("790"), profit: mm("-800"), closing: true},
+		"old":     {id: "old", year: y2024, date: on(2024, 1, 5), retained: mm("20"), closing: true},
+		"direct":  {id: "direct", year: y2024, date: on(2024, 6, 1), retained: mm("200")},
+		"capital": {id: "capital", year: y2024, date: on(2024, 3, 1), equity: mm("300"), profit: mm("-300")},
+		"late":    {id: "late", year: y2024, date: on(2024, 11, 30), inserted: on(2025, 2, 1), profit: mm("50"), postClose: true},
+		"owner":   {id: "owner", year: y2024, date: on(2024, 4, 1), equity: mm("500")},
+	}
+	closings := map[string]int{"close23": 2023, "close24": 2024, "old": 2022}
+
+	ids := s.classify(txns, closings, on(2026, 6, 1))
+
+	type finding struct {
+		kind   string
+		txn    string
+		amount money.Amount
+	}
+	want := map[int][]finding{
+		2024: {
+			{KindCloseMissing, "old", mm("20")},
+			{KindProfitToEquity, "capital", mm("300")},
+			{KindDirectPosting, "direct", mm("200")},
+			{KindPostClose, "late", mm("50")},
+			{KindNetIncome, "", mm("50")},
+			{KindCloseAmount, "", mm("-10")},
+		},
+		2025: {{KindNotClosed, "", mm("300")}},
+		2026: {{KindNotCompleted, "", mm("75")}},
+	}
+	for _, ys := range s.Years {
+		var got []finding
+		for _, f := range ys.Findings {
+			got = append(got, finding{f.Kind, f.TransactionID, f.Amount})
+		}
+		if !reflect.DeepEqual(got, want[ys.Year.FiscalYear]) {
+			t.Errorf("%d findings =\n%v\nwant\n%v", ys.Year.FiscalYear, got, want[ys.Year.FiscalYear])
+		}
+	}
+	if len(ids) != 4 {
+		t.Errorf("transactions to describe = %v, want the four with findings", ids)
+	}
+
+	tests := []struct {
+		year *YearStatement
+		want *RollForward
+	}{
+		{year: y2023, want: &RollForward{Opening: mm("5000"), NetIncome: mm("1000"), Closing: mm("6000")}},
+		{year: y2024, want: &RollForward{Opening: mm("6000"), NetIncome: mm("800"), Closing: mm("7090"),
+			PostClose: mm("50"), ProfitGap: mm("50"), DirectPosting: mm("200"), CloseNet: mm("-10")}},
+		{year: y2025},
+		{year: y2026},
+	}
+	for _, tt := range tests {
+		got := s.RollForward(tt.year)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d roll-forward = %+v, want %+v", tt.year.Year.FiscalYear, got, tt.want)
+			continue
+		}
+		if got == nil {
+			continue
+		}
+		explained := got.PostClose + got.ProfitGap + got.DirectPosting + got.CloseNet
+		if got.Difference() != explained {
+			t.Errorf("%d difference %s, explained %s", tt.year.Year.FiscalYear, got.Difference(), explained)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package equity
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+)
+
+// Unclosed is the statement column for income and expenses not yet closed
+// to retained earnings. OA keeps them in the P&L accounts until a closing
+// transaction moves them.
+const Unclosed = ""
+
+// Column is an equity account, or Unclosed.
+type Column struct {
+	Key  string // OA account id
+	Name string
+}
+
+// YearStatement is one fiscal year of the statement. Amounts are keyed by
+// column and shown credit positive, as equity is.
+type YearStatement struct {
+	Year     Year
+	Run      *Run // nil when the year was never closed
+	Opening  map[string]money.Amount
+	Profit   money.Amount            // income less expenses, in Unclosed
+	Transfer map[string]money.Amount // the close's OA transaction
+	Other    map[string]money.Amount // contributions, drawings, dividends, direct postings
+	Closing  map[string]money.Amount
+	Findings []*Finding
+}
+
+// Statement is the statement of changes in equity over several years.
+type Statement struct {
+	OrganizationID   string
+	Currency         string
+	RetainedEarnings string // column key of the retained earnings account
+	Columns          []Column
+	Years            []*YearStatement
+}
+
+// Accumulated is retained earnings plus unclosed profit in a column set.
+func (s *Statement) Accumulated(amounts map[string]money.Amount) money.Amount {
+	return amounts[s.RetainedEarnings] + amounts[Unclosed]
+}
+
+// txn is what one OA transaction did to equity and profit in a year.
+type txn struct {
+	id        string
+	year      *YearStatement
+	date      time.Time
+	inserted  time.Time
+	retained  money.Amount
+	equity    money.Amount // equity accounts other than retained earnings
+	profit    money.Amount
+	closing   bool
+	postClose bool
+}
+
+// Build reads OA equity and P&L splits up to the end of fiscal year last
+// and builds the statement for fiscal years first..last. retainedPath
+// overrides the retained earnings account (see RetainedEarnings).
+func Build(ctx context.Context, cf, oadb cashflow.Querier, organizationID string, first, last int, retainedPath string) (*Statement, error) {
+	if last < first {
+		return nil, fmt.Errorf("fiscal year %d is before %d", last, first)
+	}
+	years, err := Years(ctx, cf, organizationID, first, last)
+	if err != nil {
+		return nil, err
+	}
+	runs, err := Runs(ctx, cf, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	orgID, err := oa.OrgForOrganization(ctx, cf, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	org, err := oa.GetOrg(ctx, oadb, orgID)
+	if err != nil {
+		return nil, err
+	}
+	chart, err := oa.LoadChart(ctx, oadb, orgID)
+	if err != nil {
+		return nil, err
+	}
+	retained, err := RetainedEarnings(ctx, cf, chart, organizationID, retainedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Statement{OrganizationID: organizationID, Currency: org.Currency, RetainedEarnings: retained}
+	closings := map[string]int{}
+	for _, y := range years {
+		ys := &YearStatement{
+			Year: y, Run: runs[y.FiscalYear], Opening: map[string]money.Amount{},
+			Transfer: map[string]money.Amount{}, Other: map[string]money.Amount{}, Closing: map[string]money.Amount{},
+		}
+		if ys.Run != nil && ys.Run.OATransactionID != "" {
+			closings[ys.Run.OATransactionID] = y.FiscalYear
+		}
+		s.Years = append(s.Years, ys)
+	}
+
+	used := map[string]bool{retained: true, Unclosed: true}
+	txns := map[string]*txn{}
+	opening := map[string]money.Amount{}
+	i := 0
+	err = oa.Splits(ctx, oadb, orgID, time.Time{}, years[len(years)-1].End, func(sp *oa.Split) error {
+		key := sp.AccountID
+		switch chart.Class(sp.AccountID) {
+		case cashflow.ClassEquity:
+		case cashflow.ClassIncome, cashflow.ClassExpense:
+			key = Unclosed
+		default:
+			return nil
+		}
+		used[key] = true
+		amount := -money.FromMinor(sp.Amount, org.Precision)
+		if sp.Date.Before(years[0].Start) {
+			opening[key] += amount
+			return nil
+		}
+		for !sp.Date.Before(s.Years[i].Year.End) {
+			i++
+		}
+		ys := s.Years[i]
+
+		t := txns[sp.TransactionID]
+		if t == nil {
+			t = &txn{id: sp.TransactionID, year: ys, date: sp.Date, inserted: sp.Inserted}
+			txns[sp.TransactionID] = t
+		}
+		_, t.closing = closings[sp.TransactionID]
+		if ys.Run != nil && ys.Run.CompletedAt != nil && sp.Inserted.After(*ys.Run.CompletedAt) {
+			t.postClose = true
+		}
+		switch {
+		case key == Unclosed:
+			t.profit += amount
+		case key == retained:
+			t.retained += amount
+		default:
+			t.equity += amount
+		}
+
+		switch {
+		case t.closing:
+			ys.Transfer[key] += amount
+		case key == Unclosed:
+			ys.Profit += amount
+		default:
+			ys.Other[key] += amount
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ys := range s.Years {
+		for key, amount := range opening {
+			ys.Opening[key] = amount
+		}
+		for key := range used {
+			opening[key] += ys.Transfer[key] + ys.Other[key]
+		}
+		opening[Unclosed] += ys.Profit
+		for key, amount := range opening {
+			ys.Closing[key] = amount
+		}
+	}
+
+	for key := range used {
+		if key != Unclosed {
+			s.Columns = append(s.Columns, Column{Key: key, Name: chart.FullName(key)})
+		}
+	}
+	sort.Slice(s.Columns, func(i, j int) bool {
+		if (s.Columns[i].Key == retained) != (s.Columns[j].Key == retained) {
+			return s.Columns[j].Key == retained
+		}
+		return s.Columns[i].Name < s.Columns[j].Name
+	})
+	s.Columns = append(s.Columns, Column{Key: Unclosed, Name: "Unclosed profit"})
+
+	if err := s.check(ctx, oadb, txns, closings); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Print writes the statement, one block per fiscal year.
+func (s *Statement) Print(w io.Writer) {
+	fmt.Fprintf(w, "Statement of changes in equity (%s)\n", s.Currency)
+	for _, ys := range s.Years {
+		fmt.Fprintf(w, "\nFiscal year %d: %s to %s", ys.Year.FiscalYear,
+			ys.Year.Start.Format(time.DateOnly), ys.Year.End.AddDate(0, 0, -1).Format(time.DateOnly))
+		if ys.Run != nil {
+			fmt.Fprintf(w, ", close %s", ys.Run.Status)
+		}
+		fmt.Fprintln(w)
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		header := "\t"
+		for _, c := range s.Columns {
+			header += strings.ToUpper(c.Name) + "\t"
+		}
+		fmt.Fprintln(tw, header+"TOTAL\t")
+		row := func(label string, amounts map[string]money.Amount) {
+			line, total := label+"\t", money.Amount(0)
+			for _, c := range s.Columns {
+				total += amounts[c.Key]
+				line += amounts[c.Key].String() + "\t"
+			}
+			fmt.Fprintln(tw, line+total.String()+"\t")
+		}
+		row("Opening balance", ys.Opening)
+		row("Profit for the year", map[string]money.Amount{Unclosed: ys.Profit})
+		row("Closed to retained earnings", ys.Transfer)
+		row("Other movements", ys.Other)
+		row("Closing balance", ys.Closing)
+		tw.Flush()
+	}
+}
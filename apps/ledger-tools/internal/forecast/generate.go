@@ -0,0 +1,252 @@
+package forecast
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+)
+
+// Params configure a forecast.
+type Params struct {
+	// Start is the first forecast month, YYYY-MM.
+	Start  string
+	Method string
+	// Growth is MethodGrowth's rate, 0.05 for 5%.
+	Growth float64
+	// HistoryMonths is how many whole months of history to read, ending
+	// before Start or before the current month, whichever is earlier.
+	HistoryMonths int
+	CreatedBy     string
+}
+
+// Generate drafts a forecast for an organization's income and expense
+// accounts from their OA splits. Accounts with no history are left out;
+// so are accounts the method cannot project, which are listed in the
+// returned skipped map with the reason.
+func Generate(ctx context.Context, cf *sql.DB, oadb cashflow.Querier, organizationID string, p Params, now time.Time) (*Forecast, map[string]string, error) {
+	if !validMethod(p.Method) {
+		return nil, nil, fmt.Errorf("unknown method %q", p.Method)
+	}
+	if p.HistoryMonths < 12 {
+		return nil, nil, fmt.Errorf("at least 12 months of history are needed, not %d", p.HistoryMonths)
+	}
+	org, loc, err := orgOf(ctx, cf, oadb, organizationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	startMonth, err := time.ParseInLocation(MonthLayout, p.Start, loc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("start month: %w", err)
+	}
+	chart, err := oa.LoadChart(ctx, oadb, org.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	local := now.In(loc)
+	historyEnd := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, loc)
+	if startMonth.Before(historyEnd) {
+		historyEnd = startMonth
+	}
+	historyFrom := historyEnd.AddDate(0, -p.HistoryMonths, 0)
+
+	series := map[string][]int64{}
+	err = oa.Splits(ctx, oadb, org.ID, historyFrom, historyEnd, func(s *oa.Split) error {
+		class := chart.Class(s.AccountID)
+		if class != cashflow.ClassIncome && class != cashflow.ClassExpense {
+			return nil
+		}
+		months := series[s.AccountID]
+		if months == nil {
+			months = make([]int64, p.HistoryMonths)
+			series[s.AccountID] = months
+		}
+		months[monthsBetween(historyFrom, s.Date.In(loc))] += s.Amount
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f := &Forecast{
+		ID: cashflow.NewID("forecast"), OrganizationID: organizationID, OAOrgID: org.ID,
+		Start: startMonth.Format(MonthLayout), Method: p.Method, Growth: p.Growth,
+		HistoryFrom: historyFrom.Format(MonthLayout), HistoryMonths: p.HistoryMonths,
+		Status: StatusDraft, CreatedBy: p.CreatedBy, CreatedAt: now,
+	}
+	skipped := map[string]string{}
+	first, start := int(historyFrom.Month())-1, monthsBetween(historyFrom, startMonth)
+	for account, months := range series {
+		projected, err := project(p.Method, months, first, start, p.Growth)
+		if err != nil {
+			skipped[account] = err.Error()
+			continue
+		}
+		l := &Line{AccountID: account, Months: projected}
+		for _, v := range months[len(months)-12:] {
+			l.History += v
+		}
+		for _, v := range projected {
+			l.Amount += v
+		}
+		if p.Method == MethodGrowth && historyEnd.Before(startMonth) {
+			l.Note = fmt.Sprintf("history ends %s", historyEnd.AddDate(0, -1, 0).Format(MonthLayout))
+		}
+		f.Lines = append(f.Lines, l)
+	}
+	if len(f.Lines) == 0 {
+		return nil, skipped, fmt.Errorf("no income or expense history between %s and %s",
+			f.HistoryFrom, historyEnd.Format(MonthLayout))
+	}
+
+	if err := db.InTx(ctx, cf, func(tx *sql.Tx) error { return insert(ctx, tx, f) }); err != nil {
+		return nil, nil, err
+	}
+	return f, skipped, nil
+}
+
+// monthsBetween counts the calendar months from a to b.
+func monthsBetween(a, b time.Time) int {
+	return (b.Year()-a.Year())*12 + int(b.Month()) - int(a.Month())
+}
+
+// Publish writes an approved forecast into OA as the org's new budget: a
+// new budgetitem set stamped later than the current one, which is the set
+// OA and the reports read. Accounts the forecast does not cover keep their
+// current budget in the new set, so publishing a P&L forecast does not
+// drop budgets kept for other accounts. It returns the set's inserted
+// stamp.
+func Publish(ctx context.Context, cf, oadb *sql.DB, id string, now time.Time) (int64, error) {
+	f, err := Get(ctx, cf, id)
+	if err != nil {
+		return 0, err
+	}
+	if f.Status != StatusApproved {
+		return 0, fmt.Errorf("forecast %s is %s; only approved forecasts are published", id, f.Status)
+	}
+	// Claim the forecast first, so two publishers cannot both write a set.
+	res, err := cf.ExecContext(ctx, `
+		UPDATE budget_forecasts SET status = ?, published_at = ? WHERE id = ? AND status = ?`,
+		StatusPublished, now, id, StatusApproved)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = errors.New("forecast " + id + " was published concurrently")
+		}
+		return 0, err
+	}
+
+	var inserted int64
+	err = db.InTx(ctx, oadb, func(tx *sql.Tx) error {
+		var err error
+		inserted, err = writeBudget(ctx, tx, f, now)
+		return err
+	})
+	if err != nil {
+		if _, rerr := cf.ExecContext(ctx, `
+			UPDATE budget_forecasts SET status = ?, published_at = NULL WHERE id = ?`, StatusApproved, id); rerr != nil {
+			return 0, fmt.Errorf("%w (and reverting the forecast to approved: %v)", err, rerr)
+		}
+		return 0, err
+	}
+	_, err = cf.ExecContext(ctx, `UPDATE budget_forecasts SET published_inserted = ? WHERE id = ?`, inserted, id)
+	return inserted, err
+}
+
+func writeBudget(ctx context.Context, tx *sql.Tx, f *Forecast, now time.Time) (int64, error) {
+	var current int64
+	// Lock the org's budget rows so a concurrent save gets a later stamp.
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(inserted), 0) FROM budgetitem WHERE orgId = UNHEX(?) FOR UPDATE`, f.OAOrgID).Scan(&current); err != nil {
+		return 0, err
+	}
+	inserted := oa.Millis(now)
+	if inserted <= current {
+		inserted = current + 1
+	}
+
+	amounts := map[string]int64{}
+	covered := map[string]bool{}
+	for _, l := range f.Lines {
+		covered[l.AccountID] = true
+		amounts[l.AccountID] = l.Budget()
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT LOWER(HEX(accountId)), amount FROM budgetitem WHERE orgId = UNHEX(?) AND inserted = ?`, f.OAOrgID, current)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var account string
+		var amount int64
+		if err := rows.Scan(&account, &amount); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !covered[account] {
+			amounts[account] += amount
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, account := range sortedAccounts(amounts) {
+		if amounts[account] == 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO budgetitem (orgId, accountId, inserted, amount) VALUES (UNHEX(?), UNHEX(?), ?, ?)`,
+			f.OAOrgID, account, inserted, amounts[account]); err != nil {
+			return 0, err
+		}
+	}
+	return inserted, nil
+}
+
+func orgOf(ctx context.Context, cf, oadb cashflow.Querier, organizationID string) (*oa.Org, *time.Location, error) {
+	orgID, err := oa.OrgForOrganization(ctx, cf, organizationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	org, err := oa.GetOrg(ctx, oadb, orgID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if org.Timezone == "" {
+		return org, time.UTC, nil
+	}
+	loc, err := time.LoadLocation(org.Timezone)
+	if err != nil {
+		return nil, nil, fmt.Errorf("org %s timezone: %w", org.Name, err)
+	}
+	return org, loc, nil
+}
+
+func validMethod(m string) bool {
+	for _, known := range Methods {
+		if m == known {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedAccounts(m map[string]int64) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,129 @@
+package forecast
+
+import (
+	"fmt"
+	"math"
+)
+
+// Forecasting methods.
+const (
+	// MethodGrowth repeats the last twelve months of history, each
+	// scaled by the growth rate.
+	MethodGrowth = "growth"
+	// MethodAverage takes each calendar month's average over the history
+	// years.
+	MethodAverage = "average"
+	// MethodSeasonal decomposes the history into a linear trend and
+	// additive monthly seasonal indices, and extends both.
+	MethodSeasonal = "seasonal"
+)
+
+// Methods lists the methods in the order they are documented.
+var Methods = []string{MethodGrowth, MethodAverage, MethodSeasonal}
+
+// project forecasts the twelve months of a series at indexes start to
+// start+11. The series holds monthly movements, oldest first, and its
+// index 0 falls in calendar month first (0 for January). Amounts are
+// rounded to whole minor units.
+func project(method string, series []int64, first, start int, growth float64) ([12]int64, error) {
+	var out [12]int64
+	n := len(series)
+	if n < 12 {
+		return out, fmt.Errorf("%s needs at least 12 months of history, have %d", method, n)
+	}
+	month := func(i int) int { return (first + i) % 12 }
+
+	switch method {
+	case MethodGrowth:
+		for i := n - 12; i < n; i++ {
+			k := slot(month(i), month(start))
+			out[k] = round(float64(series[i]) * (1 + growth))
+		}
+	case MethodAverage:
+		var sums [12]float64
+		var counts [12]int
+		for i, v := range series {
+			sums[month(i)] += float64(v)
+			counts[month(i)]++
+		}
+		for m := 0; m < 12; m++ {
+			k := slot(m, month(start))
+			if counts[m] > 0 {
+				out[k] = round(sums[m] / float64(counts[m]))
+			}
+		}
+	case MethodSeasonal:
+		if n < 24 {
+			return out, fmt.Errorf("seasonal needs at least 24 months of history, have %d", n)
+		}
+		a, b, seasonal := decompose(series, first)
+		for k := 0; k < 12; k++ {
+			t := start + k
+			out[k] = round(a + b*float64(t) + seasonal[month(t)])
+		}
+	default:
+		return out, fmt.Errorf("unknown method %q", method)
+	}
+	return out, nil
+}
+
+// slot is the position of calendar month m in a forecast year starting at
+// calendar month start.
+func slot(m, start int) int {
+	return (m - start + 12) % 12
+}
+
+// decompose fits y[t] = a + b*t + s[month(t)]. The trend is the centred
+// twelve-month moving average, which cancels the seasonal swing, fitted
+// with a least-squares line; the seasonal index of a calendar month is its
+// average distance from that moving average, adjusted to sum to zero.
+func decompose(y []int64, first int) (a, b float64, seasonal [12]float64) {
+	n := len(y)
+	var ts, mas []float64
+	var sums [12]float64
+	var counts [12]int
+	for t := 6; t+6 < n; t++ {
+		ma := (float64(y[t-6]) + float64(y[t+6])) / 2
+		for i := t - 5; i <= t+5; i++ {
+			ma += float64(y[i])
+		}
+		ma /= 12
+		ts, mas = append(ts, float64(t)), append(mas, ma)
+		m := (first + t) % 12
+		sums[m] += float64(y[t]) - ma
+		counts[m]++
+	}
+
+	var meanT, meanY float64
+	for i := range ts {
+		meanT += ts[i]
+		meanY += mas[i]
+	}
+	meanT /= float64(len(ts))
+	meanY /= float64(len(ts))
+	var sxy, sxx float64
+	for i := range ts {
+		sxy += (ts[i] - meanT) * (mas[i] - meanY)
+		sxx += (ts[i] - meanT) * (ts[i] - meanT)
+	}
+	if sxx > 0 {
+		b = sxy / sxx
+	}
+	a = meanY - b*meanT
+
+	var total float64
+	for m := 0; m < 12; m++ {
+		if counts[m] > 0 {
+			seasonal[m] = sums[m] / float64(counts[m])
+		}
+		total += seasonal[m]
+	}
+	for m := range seasonal {
+		seasonal[m] -= total / 12
+	}
+	return a, b, seasonal
+}
+
+func round(f float64) int64 {
+	return int64(math.Round(f))
+}
@@ -0,0 +1,121 @@
+package forecast
+
+import (
+	"strings"
+	"testing"
+)
+
+// monthly builds n months of history from f, given the month index t
+// and its calendar month m.
+func monthly(n, first int, f func(t, m int) int64) []int64 {
+	out := make([]int64, n)
+	for t := range out {
+		out[t] = f(t, (first+t)%12)
+	}
+	return out
+}
+
+func TestProject(t *testing.T) {
+	seasonal := [12]int64{-300, -200, -100, 0, 100, 200, 300, 200, 100, 0, -100, -200}
+
+	tests := []struct {
+		name   string
+		method string
+		series []int64
+		first  int
+		start  int
+		growth float64
+		want   [12]int64
+	}{
+		{
+			name: "growth repeats the last year", method: MethodGrowth, first: 0, start: 12, growth: 0.1,
+			series: monthly(12, 0, func(t, m int) int64 { return int64(100 * (m + 1)) }),
+			want:   [12]int64{110, 220, 330, 440, 550, 660, 770, 880, 990, 1100, 1210, 1320},
+		},
+		{
+			name: "growth from the month after the history", method: MethodGrowth, first: 0, start: 14,
+			series: monthly(14, 0, func(t, m int) int64 { return int64(t) }),
+			want:   [12]int64{2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13},
+		},
+		{
+			name: "growth shrinks and rounds", method: MethodGrowth, first: 6, start: 12, growth: -0.25,
+			series: monthly(12, 6, func(t, m int) int64 { return -10 }),
+			want:   [12]int64{-8, -8, -8, -8, -8, -8, -8, -8, -8, -8, -8, -8},
+		},
+		{
+			name: "average of each calendar month", method: MethodAverage, first: 0, start: 24,
+			series: monthly(24, 0, func(t, m int) int64 { return int64(100*(m+1) + 10*(t/12)) }),
+			want:   [12]int64{105, 205, 305, 405, 505, 605, 705, 805, 905, 1005, 1105, 1205},
+		},
+		{
+			name: "average with a year starting in April", method: MethodAverage, first: 0, start: 27,
+			series: monthly(24, 0, func(t, m int) int64 { return int64(100*(m+1) + 10*(t/12)) }),
+			want:   [12]int64{405, 505, 605, 705, 805, 905, 1005, 1105, 1205, 105, 205, 305},
+		},
+		{
+			name: "average over a partial year", method: MethodAverage, first: 0, start: 12,
+			series: monthly(13, 0, func(t, m int) int64 { return int64(t) }),
+			want:   [12]int64{6, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+		},
+		{
+			name: "seasonal extends trend and season", method: MethodSeasonal, first: 3, start: 30,
+			series: monthly(30, 3, func(t, m int) int64 { return 1000 + 10*int64(t) + seasonal[m] }),
+			want: func() (out [12]int64) {
+				for k := range out {
+					out[k] = 1000 + 10*int64(30+k) + seasonal[(3+30+k)%12]
+				}
+				return out
+			}(),
+		},
+		{
+			name: "seasonal flat series", method: MethodSeasonal, first: 0, start: 24,
+			series: monthly(24, 0, func(t, m int) int64 { return 500 }),
+			want:   [12]int64{500, 500, 500, 500, 500, 500, 500, 500, 500, 500, 500, 500},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := project(tt.method, tt.series, tt.first, tt.start, tt.growth)
+			if err != nil {
+				t.Fatalf("project: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("project =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectErrors(t *testing.T) {
+	tests := []struct {
+		method string
+		months int
+		want   string
+	}{
+		{method: MethodGrowth, months: 11, want: "growth needs at least 12 months of history, have 11"},
+		{method: MethodAverage, months: 0, want: "average needs at least 12 months of history, have 0"},
+		{method: MethodSeasonal, months: 23, want: "seasonal needs at least 24 months of history, have 23"},
+		{method: "naive", months: 24, want: `unknown method "naive"`},
+	}
+	for _, tt := range tests {
+		_, err := project(tt.method, make([]int64, tt.months), 0, tt.months, 0)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("project(%s, %d months) error = %v, want %q", tt.method, tt.months, err, tt.want)
+		}
+	}
+}
+
+func TestSlot(t *testing.T) {
+	tests := []struct{ m, start, want int }{
+		{m: 0, start: 0, want: 0},
+		{m: 3, start: 3, want: 0},
+		{m: 2, start: 3, want: 11},
+		{m: 11, start: 0, want: 11},
+		{m: 0, start: 6, want: 6},
+	}
+	for _, tt := range tests {
+		if got := slot(tt.m, tt.start); got != tt.want {
+			t.Errorf("slot(%d, %d) = %d, want %d", tt.m, tt.start, got, tt.want)
+		}
+	}
+}
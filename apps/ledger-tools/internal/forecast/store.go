@@ -0,0 +1,260 @@
+// Package forecast drafts next year's OA budget from an org's history.
+//
+// A forecast projects the monthly movements of every income and expense
+// account over a twelve-month period with one of the methods in Methods,
+// and is kept in cashflowdb for review: figures can be overridden per
+// account while it is a draft, then it is approved and published into OA's
+// budgetitem table as the org's new budget.
+package forecast
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Tables are the cashflowdb tables owned by the forecast module.
+var Tables = []schema.Table{
+	{
+		Name: "budget_forecasts",
+		Create: `CREATE TABLE IF NOT EXISTS budget_forecasts (
+  id VARCHAR(191) NOT NULL,
+  organization_id VARCHAR(191) NOT NULL,
+  oa_org_id CHAR(32) NOT NULL,
+  start_month CHAR(7) NOT NULL,
+  method VARCHAR(20) NOT NULL,
+  growth DOUBLE NOT NULL,
+  history_from CHAR(7) NOT NULL,
+  history_months INT NOT NULL,
+  status VARCHAR(20) NOT NULL,
+  created_by VARCHAR(191) NULL,
+  created_at DATETIME(3) NOT NULL,
+  approved_by VARCHAR(191) NULL,
+  approved_at DATETIME(3) NULL,
+  published_at DATETIME(3) NULL,
+  published_inserted BIGINT NULL,
+  PRIMARY KEY (id),
+  INDEX budget_forecasts_org_idx (organization_id, created_at)
+) ENGINE=InnoDB`,
+	},
+	{
+		Name: "budget_forecast_lines",
+		Create: `CREATE TABLE IF NOT EXISTS budget_forecast_lines (
+  forecast_id VARCHAR(191) NOT NULL,
+  account_id CHAR(32) NOT NULL,
+  history BIGINT NOT NULL,
+  months TEXT NOT NULL,
+  amount BIGINT NOT NULL,
+  override_amount BIGINT NULL,
+  override_by VARCHAR(191) NULL,
+  note VARCHAR(255) NULL,
+  PRIMARY KEY (forecast_id, account_id)
+) ENGINE=InnoDB`,
+	},
+}
+
+// Forecast statuses. A draft can be edited; approving freezes it and
+// publishing writes it to OA.
+const (
+	StatusDraft     = "draft"
+	StatusApproved  = "approved"
+	StatusPublished = "published"
+)
+
+// MonthLayout formats forecast and history months.
+const MonthLayout = "2006-01"
+
+// Forecast is a budget_forecasts row with its lines.
+type Forecast struct {
+	ID             string
+	OrganizationID string
+	OAOrgID        string
+	Start          string // first forecast month, YYYY-MM in the org's timezone
+	Method         string
+	Growth         float64
+	HistoryFrom    string // first history month
+	HistoryMonths  int
+	Status         string
+	CreatedBy      string
+	CreatedAt      time.Time
+	ApprovedBy     string
+	ApprovedAt     sql.NullTime
+	PublishedAt    sql.NullTime
+	// PublishedInserted is the budgetitem.inserted stamp of the published set.
+	PublishedInserted int64
+	Lines             []*Line
+}
+
+// Line is one account's forecast. Amounts are minor units of the account
+// currency signed like splits, so income is negative.
+type Line struct {
+	AccountID string
+	History   int64     // the last twelve months of history
+	Months    [12]int64 // from the forecast's start month
+	Amount    int64     // sum of Months
+	// Override replaces Amount when set during review.
+	Override   *int64
+	OverrideBy string
+	Note       string
+}
+
+// Budget returns the amount to publish.
+func (l *Line) Budget() int64 {
+	if l.Override != nil {
+		return *l.Override
+	}
+	return l.Amount
+}
+
+func insert(ctx context.Context, tx *sql.Tx, f *Forecast) error {
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO budget_forecasts (id, organization_id, oa_org_id, start_month, method, growth, history_from,
+		  history_months, status, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		f.ID, f.OrganizationID, f.OAOrgID, f.Start, f.Method, f.Growth, f.HistoryFrom, f.HistoryMonths, f.Status,
+		cashflow.NullString(f.CreatedBy), f.CreatedAt); err != nil {
+		return err
+	}
+	for _, l := range f.Lines {
+		months, _ := json.Marshal(l.Months)
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO budget_forecast_lines (forecast_id, account_id, history, months, amount, note)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			f.ID, l.AccountID, l.History, string(months), l.Amount, cashflow.NullString(l.Note)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const forecastColumns = `id, organization_id, oa_org_id, start_month, method, growth, history_from, history_months, status,
+	COALESCE(created_by, ''), created_at, COALESCE(approved_by, ''), approved_at, published_at, COALESCE(published_inserted, 0)`
+
+func scanForecast(row interface{ Scan(...any) error }) (*Forecast, error) {
+	f := &Forecast{}
+	err := row.Scan(&f.ID, &f.OrganizationID, &f.OAOrgID, &f.Start, &f.Method, &f.Growth, &f.HistoryFrom,
+		&f.HistoryMonths, &f.Status, &f.CreatedBy, &f.CreatedAt, &f.ApprovedBy, &f.ApprovedAt, &f.PublishedAt,
+		&f.PublishedInserted)
+	return f, err
+}
+
+// Get loads a forecast with its lines.
+func Get(ctx context.Context, q cashflow.Querier, id string) (*Forecast, error) {
+	f, err := scanForecast(q.QueryRowContext(ctx, `SELECT `+forecastColumns+` FROM budget_forecasts WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("forecast %s: %w", id, cashflow.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT account_id, history, months, amount, override_amount, COALESCE(override_by, ''), COALESCE(note, '')
+		FROM budget_forecast_lines WHERE forecast_id = ? ORDER BY account_id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		l := &Line{}
+		var months string
+		var override sql.NullInt64
+		if err := rows.Scan(&l.AccountID, &l.History, &months, &l.Amount, &override, &l.OverrideBy, &l.Note); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(months), &l.Months); err != nil {
+			return nil, fmt.Errorf("forecast %s account %s months: %w", id, l.AccountID, err)
+		}
+		if override.Valid {
+			l.Override = &override.Int64
+		}
+		f.Lines = append(f.Lines, l)
+	}
+	return f, rows.Err()
+}
+
+// List returns an organization's forecasts without lines, newest first.
+func List(ctx context.Context, q cashflow.Querier, organizationID string) ([]*Forecast, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+forecastColumns+` FROM budget_forecasts WHERE organization_id = ? ORDER BY created_at DESC`, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Forecast
+	for rows.Next() {
+		f, err := scanForecast(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+// SetOverride overrides, or with a nil amount clears the override of, an
+// account's figure in a draft forecast.
+func SetOverride(ctx context.Context, q cashflow.Querier, id, accountID string, amount *int64, by string) error {
+	var override any
+	if amount != nil {
+		override = *amount
+	}
+	res, err := q.ExecContext(ctx, `
+		UPDATE budget_forecast_lines l JOIN budget_forecasts f ON f.id = l.forecast_id
+		SET l.override_amount = ?, l.override_by = ?
+		WHERE l.forecast_id = ? AND l.account_id = ? AND f.status = ?`,
+		override, cashflow.NullString(by), id, accountID, StatusDraft)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	// Nothing changed: no such line, a forecast past review, or the same
+	// override again.
+	var lines int
+	if err := q.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM budget_forecast_lines WHERE forecast_id = ? AND account_id = ?`, id, accountID).Scan(&lines); err != nil {
+		return err
+	}
+	if lines == 0 {
+		return fmt.Errorf("account %s in forecast %s: %w", accountID, id, cashflow.ErrNotFound)
+	}
+	return inStatus(ctx, q, id, StatusDraft)
+}
+
+// Approve freezes a draft forecast for publishing.
+func Approve(ctx context.Context, q cashflow.Querier, id, by string) error {
+	res, err := q.ExecContext(ctx, `
+		UPDATE budget_forecasts SET status = ?, approved_by = ?, approved_at = ? WHERE id = ? AND status = ?`,
+		StatusApproved, cashflow.NullString(by), time.Now(), id, StatusDraft)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	return inStatus(ctx, q, id, StatusDraft)
+}
+
+// inStatus returns an error unless a forecast exists in the given status.
+func inStatus(ctx context.Context, q cashflow.Querier, id, want string) error {
+	var status string
+	err := q.QueryRowContext(ctx, `SELECT status FROM budget_forecasts WHERE id = ?`, id).Scan(&status)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("forecast %s: %w", id, cashflow.ErrNotFound)
+	case err != nil:
+		return err
+	case status != want:
+		return fmt.Errorf("forecast %s is %s, not %s", id, status, want)
+	}
+	return nil
+}
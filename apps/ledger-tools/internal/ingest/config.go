@@ -0,0 +1,91 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+)
+
+// Config is the daemon configuration file.
+type Config struct {
+	// Root holds one directory per organization.
+	Root string `json:"root"`
+	// Interval is how often the inboxes are scanned.
+	Interval config.Duration `json:"interval"`
+	// Settle is how long a file must stay unchanged before it is picked up,
+	// so half-uploaded files are left alone.
+	Settle config.Duration `json:"settle"`
+	Orgs   []Org           `json:"orgs"`
+}
+
+// Org is one organization's drop folder.
+type Org struct {
+	Dir            string `json:"dir"` // relative to Root
+	OrganizationID string `json:"organizationId"`
+	// POSMapping is the posimport mapping file, relative to the org's
+	// directory unless absolute.
+	POSMapping     string `json:"posMapping,omitempty"`
+	AllowShortfall bool   `json:"allowShortfall,omitempty"`
+	// BankAccounts maps a folder under inbox/bank to a bank_accounts id.
+	// Statements outside such a folder are matched by the account number
+	// they carry, then fall back to BankAccountID.
+	BankAccounts  map[string]string `json:"bankAccounts,omitempty"`
+	BankAccountID string            `json:"bankAccountId,omitempty"`
+
+	path string
+}
+
+// Path returns the org's directory.
+func (o *Org) Path() string { return o.path }
+
+// Folder returns one of the org's standard folders.
+func (o *Org) Folder(name string) string { return filepath.Join(o.path, name) }
+
+// Folders under each org directory.
+const (
+	FolderInbox      = "inbox"
+	FolderProcessing = "processing"
+	FolderArchive    = "archive"
+	FolderError      = "error"
+)
+
+// LoadConfig reads a configuration file and creates missing folders.
+func LoadConfig(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{Interval: config.Duration(30 * time.Second), Settle: config.Duration(10 * time.Second)}
+	if err := json.Unmarshal(raw, cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if cfg.Root == "" {
+		return nil, fmt.Errorf("%s: root is required", path)
+	}
+
+	seen := map[string]bool{}
+	for i := range cfg.Orgs {
+		o := &cfg.Orgs[i]
+		if o.Dir == "" || o.OrganizationID == "" {
+			return nil, fmt.Errorf("org %d: dir and organizationId are required", i)
+		}
+		if seen[o.Dir] {
+			return nil, fmt.Errorf("org dir %q is configured twice", o.Dir)
+		}
+		seen[o.Dir] = true
+		o.path = filepath.Join(cfg.Root, o.Dir)
+		if o.POSMapping != "" && !filepath.IsAbs(o.POSMapping) {
+			o.POSMapping = filepath.Join(o.path, o.POSMapping)
+		}
+		for _, f := range []string{FolderInbox, FolderProcessing, FolderArchive, FolderError} {
+			if err := os.MkdirAll(o.Folder(f), 0o755); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,225 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/bankstmt"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/masterdata"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/pos"
+)
+
+// Job is one file handed to a handler.
+type Job struct {
+	DB   *sql.DB
+	Org  *Org
+	Path string // file in the processing folder
+	Name string // original file name
+	// Sub is the folder path below the kind folder in the inbox, e.g. the
+	// bank account folder of inbox/bank/<account>/statement.csv.
+	Sub string
+}
+
+// Handler imports one kind of file. Handle returns a result that is written
+// into the file's report; an error moves the file to the error folder.
+type Handler interface {
+	Kind() string
+	Detect(name string, head []byte) bool
+	Handle(ctx context.Context, job *Job) (any, error)
+}
+
+var handlers []Handler
+
+// Register adds a handler. Handlers are tried in registration order when a
+// file's folder does not name its kind.
+func Register(h Handler) {
+	handlers = append(handlers, h)
+}
+
+// Kinds lists the registered kinds.
+func Kinds() []string {
+	out := make([]string, 0, len(handlers))
+	for _, h := range handlers {
+		out = append(out, h.Kind())
+	}
+	sort.Strings(out)
+	return out
+}
+
+func handlerFor(kind string) Handler {
+	for _, h := range handlers {
+		if h.Kind() == kind {
+			return h
+		}
+	}
+	return nil
+}
+
+// detect picks the handler for a file: the folder it was dropped in wins,
+// then the first handler whose Detect accepts it.
+func detect(folderKind, name string, head []byte) (Handler, error) {
+	if folderKind != "" {
+		if h := handlerFor(folderKind); h != nil {
+			return h, nil
+		}
+	}
+	for _, h := range handlers {
+		if h.Detect(name, head) {
+			return h, nil
+		}
+	}
+	return nil, fmt.Errorf("unrecognized file; drop it under inbox/<%s>/ to choose an importer", strings.Join(Kinds(), "|"))
+}
+
+func init() {
+	Register(posHandler{})
+	Register(bankHandler{})
+	Register(masterDataHandler{})
+	Register(priceHandler{})
+}
+
+type posHandler struct{}
+
+func (posHandler) Kind() string { return "pos" }
+
+func (posHandler) Detect(name string, head []byte) bool {
+	if !bytes.Contains(bytes.ToLower(head), []byte("batch")) {
+		return false
+	}
+	_, err := pos.DetectFormat(name, head)
+	return err == nil
+}
+
+func (posHandler) Handle(ctx context.Context, job *Job) (any, error) {
+	if job.Org.POSMapping == "" {
+		return nil, errors.New("organization has no posMapping configured")
+	}
+	mapping, err := pos.LoadMapping(job.Org.POSMapping)
+	if err != nil {
+		return nil, err
+	}
+	if mapping.OrganizationID != job.Org.OrganizationID {
+		return nil, fmt.Errorf("posMapping is for organization %s", mapping.OrganizationID)
+	}
+
+	f, err := os.Open(job.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := f.Read(head)
+	format, err := pos.DetectFormat(job.Name, head[:n])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	batches, err := pos.Parse(format, f)
+	if err != nil {
+		return nil, err
+	}
+
+	im := &pos.Importer{
+		DB:             job.DB,
+		Mapping:        mapping,
+		AllowShortfall: job.Org.AllowShortfall,
+		SourceFile:     job.Name,
+		ImportedBy:     "ingestd",
+	}
+	var reports []*pos.Report
+	failed := 0
+	for _, b := range batches {
+		rep, err := im.Import(ctx, b)
+		if err != nil {
+			failed++
+		}
+		reports = append(reports, rep)
+	}
+	if failed > 0 {
+		return reports, fmt.Errorf("%d of %d batch(es) not imported", failed, len(batches))
+	}
+	return reports, nil
+}
+
+type bankHandler struct{}
+
+func (bankHandler) Kind() string { return "bank" }
+
+func (bankHandler) Detect(name string, head []byte) bool { return bankstmt.Detect(name, head) }
+
+func (bankHandler) Handle(ctx context.Context, job *Job) (any, error) {
+	f, err := os.Open(job.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := bankstmt.Parse(job.Name, f)
+	if err != nil {
+		return nil, err
+	}
+
+	accountID := ""
+	if job.Sub != "" {
+		folder := strings.Split(filepath.ToSlash(job.Sub), "/")[0]
+		id, ok := job.Org.BankAccounts[folder]
+		if !ok {
+			return nil, fmt.Errorf("bank folder %q is not mapped to a bank account", folder)
+		}
+		accountID = id
+	}
+	if accountID == "" && st.AccountNumber == "" {
+		accountID = job.Org.BankAccountID
+	}
+	accountID, err = bankstmt.ResolveAccount(ctx, job.DB, job.Org.OrganizationID, accountID, st.AccountNumber)
+	if err != nil {
+		return nil, err
+	}
+	return bankstmt.Import(ctx, job.DB, job.Org.OrganizationID, accountID, st)
+}
+
+type masterDataHandler struct{}
+
+func (masterDataHandler) Kind() string { return "masterdata" }
+
+func (masterDataHandler) Detect(_ string, head []byte) bool { return masterdata.DetectRecords(head) }
+
+func (masterDataHandler) Handle(ctx context.Context, job *Job) (any, error) {
+	f, err := os.Open(job.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := masterdata.ParseRecords(f)
+	if err != nil {
+		return nil, err
+	}
+	return masterdata.LoadRecords(ctx, job.DB, job.Org.OrganizationID, records)
+}
+
+type priceHandler struct{}
+
+func (priceHandler) Kind() string { return "prices" }
+
+func (priceHandler) Detect(_ string, head []byte) bool { return masterdata.DetectPrices(head) }
+
+func (priceHandler) Handle(ctx context.Context, job *Job) (any, error) {
+	f, err := os.Open(job.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows, err := masterdata.ParsePrices(f)
+	if err != nil {
+		return nil, err
+	}
+	return masterdata.ApplyPrices(ctx, job.DB, job.Org.OrganizationID, rows)
+}
@@ -0,0 +1,58 @@
+package ingest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		folder string
+		name   string
+		head   string
+		want   string
+	}{
+		{folder: "bank", name: "anything.bin", want: "bank"},
+		{folder: "unknown", name: "close.csv", head: "batch_id,branch,date,receipt,sku,quantity,amount,payment_method\n", want: "pos"},
+		{name: "close.json", head: `{"batchId": "B1"}`, want: "pos"},
+		{name: "statement.ofx", head: "<OFX>", want: "bank"},
+		{name: "statement.csv", head: "Date,Details,Debit,Credit,Balance\n", want: "bank"},
+		{name: "customers.csv", head: "entity,name,email\n", want: "masterdata"},
+		{name: "prices.csv", head: "sku,selling_price\n", want: "prices"},
+	}
+	for _, tt := range tests {
+		h, err := detect(tt.folder, tt.name, []byte(tt.head))
+		if err != nil {
+			t.Errorf("detect(%q, %q): %v", tt.folder, tt.name, err)
+			continue
+		}
+		if h.Kind() != tt.want {
+			t.Errorf("detect(%q, %q) = %s, want %s", tt.folder, tt.name, h.Kind(), tt.want)
+		}
+	}
+
+	_, err := detect("", "notes.txt", []byte("hello"))
+	if err == nil || !strings.Contains(err.Error(), "drop it under inbox/<bank|masterdata|pos|prices>/") {
+		t.Errorf("detect of an unknown file error = %v", err)
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "statement.csv", want: false},
+		{name: ".DS_Store", want: true},
+		{name: "~$prices.xlsx", want: true},
+		{name: "close.csv.part", want: true},
+		{name: "close.CRDOWNLOAD", want: true},
+		{name: "close.csv.result.json", want: true},
+		{name: "close.json", want: false},
+	}
+	for _, tt := range tests {
+		if got := ignored(tt.name); got != tt.want {
+			t.Errorf("ignored(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// File statuses.
+const (
+	FileProcessing = "processing" // claimed; stays here if the daemon died mid-import
+	FileDone       = "done"
+	FileFailed     = "failed"
+	// FileDuplicate only appears in reports: a duplicate is never claimed.
+	FileDuplicate = "duplicate"
+)
+
+// Tables are the cashflowdb tables owned by the daemon. The unique key on
+// (organization_id, content_hash) is the at-most-once guarantee: a file is
+// claimed before it is imported, and the same content is never claimed
+// twice.
+var Tables = []schema.Table{
+	{
+		Name: "ingest_files",
+		Create: `CREATE TABLE IF NOT EXISTS ingest_files (
+  id VARCHAR(191) NOT NULL,
+  organization_id VARCHAR(191) NOT NULL,
+  content_hash CHAR(64) NOT NULL,
+  file_name VARCHAR(500) NOT NULL,
+  size BIGINT NOT NULL,
+  kind VARCHAR(50) NULL,
+  status VARCHAR(20) NOT NULL,
+  error TEXT NULL,
+  stored_path VARCHAR(1000) NULL,
+  received_at DATETIME(3) NOT NULL,
+  finished_at DATETIME(3) NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY ingest_files_org_hash_unique (organization_id, content_hash),
+  INDEX ingest_files_org_status_idx (organization_id, status, received_at)
+) ENGINE=InnoDB`,
+	},
+}
+
+// File is a claimed file.
+type File struct {
+	ID             string     `json:"id"`
+	OrganizationID string     `json:"organizationId"`
+	ContentHash    string     `json:"contentHash"`
+	FileName       string     `json:"fileName"`
+	Size           int64      `json:"size"`
+	Kind           string     `json:"kind,omitempty"`
+	Status         string     `json:"status"`
+	Error          string     `json:"error,omitempty"`
+	StoredPath     string     `json:"storedPath,omitempty"`
+	ReceivedAt     time.Time  `json:"receivedAt"`
+	FinishedAt     *time.Time `json:"finishedAt,omitempty"`
+}
+
+const fileColumns = `id, organization_id, content_hash, file_name, size, COALESCE(kind, ''), status,
+	COALESCE(error, ''), COALESCE(stored_path, ''), received_at, finished_at`
+
+func scanFile(row interface{ Scan(...any) error }) (*File, error) {
+	f := &File{}
+	var finished sql.NullTime
+	err := row.Scan(&f.ID, &f.OrganizationID, &f.ContentHash, &f.FileName, &f.Size, &f.Kind, &f.Status,
+		&f.Error, &f.StoredPath, &f.ReceivedAt, &finished)
+	if finished.Valid {
+		f.FinishedAt = &finished.Time
+	}
+	return f, err
+}
+
+func insertFile(ctx context.Context, q cashflow.Querier, f *File) error {
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO ingest_files (id, organization_id, content_hash, file_name, size, kind, status, received_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		f.ID, f.OrganizationID, f.ContentHash, f.FileName, f.Size, cashflow.NullString(f.Kind), f.Status, f.ReceivedAt)
+	return err
+}
+
+func finishFile(ctx context.Context, q cashflow.Querier, f *File) error {
+	_, err := q.ExecContext(ctx, `
+		UPDATE ingest_files SET kind = ?, status = ?, error = ?, stored_path = ?, finished_at = ? WHERE id = ?`,
+		cashflow.NullString(f.Kind), f.Status, cashflow.NullString(f.Error), cashflow.NullString(f.StoredPath),
+		f.FinishedAt, f.ID)
+	return err
+}
+
+func fileByHash(ctx context.Context, q cashflow.Querier, organizationID, hash string) (*File, error) {
+	return scanFile(q.QueryRowContext(ctx, `SELECT `+fileColumns+` FROM ingest_files
+		WHERE organization_id = ? AND content_hash = ?`, organizationID, hash))
+}
+
+// GetFile loads a file by id.
+func GetFile(ctx context.Context, q cashflow.Querier, id string) (*File, error) {
+	f, err := scanFile(q.QueryRowContext(ctx, `SELECT `+fileColumns+` FROM ingest_files WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, cashflow.ErrNotFound
+	}
+	return f, err
+}
+
+// Files lists an organization's files, newest first. An empty status lists
+// every status.
+func Files(ctx context.Context, q cashflow.Querier, organizationID, status string, limit int) ([]*File, error) {
+	rows, err := q.QueryContext(ctx, `SELECT `+fileColumns+` FROM ingest_files
+		WHERE organization_id = ? AND (? = '' OR status = ?)
+		ORDER BY received_at DESC LIMIT ?`, organizationID, status, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*File
+	for rows.Next() {
+		f, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+// Release forgets a failed or stuck file so the same content can be
+// processed again. Done files cannot be released.
+func Release(ctx context.Context, q cashflow.Querier, id string) error {
+	res, err := q.ExecContext(ctx, `DELETE FROM ingest_files WHERE id = ? AND status <> ?`, id, FileDone)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("file not found or already imported")
+	}
+	return nil
+}
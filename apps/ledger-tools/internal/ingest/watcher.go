@@ -0,0 +1,325 @@
+// Package ingest is the drop-folder daemon: it watches an inbox per
+// organization, routes each settled file to the importer for its kind, and
+// files it under archive or error with a JSON result report next to it.
+//
+// Each organization directory looks like
+//
+//	<root>/<org>/inbox/[<kind>/[<sub>/]]file   dropped files
+//	<root>/<org>/processing/                   files being imported
+//	<root>/<org>/archive/<yyyy-mm-dd>/         imported files and duplicates
+//	<root>/<org>/error/                        files that failed
+//
+// Files are claimed by content hash in ingest_files before they are
+// imported, so the same content is processed at most once.
+package ingest
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+)
+
+// Report is written next to every processed file as <name>.result.json.
+type Report struct {
+	File     *File  `json:"file"`
+	Original *File  `json:"original,omitempty"` // for duplicates
+	Result   any    `json:"result,omitempty"`
+	Note     string `json:"note,omitempty"`
+}
+
+// Watcher scans the configured inboxes.
+type Watcher struct {
+	Config *Config
+	DB     *sql.DB
+	Logger *log.Logger
+
+	seen map[string]fileState
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// Run scans every Interval until ctx is cancelled.
+func (w *Watcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(w.Config.Interval))
+	defer ticker.Stop()
+	for {
+		w.Scan(ctx, false)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan processes the settled files of every organization once. With now
+// set, files are processed without waiting for them to settle.
+func (w *Watcher) Scan(ctx context.Context, now bool) {
+	if w.seen == nil {
+		w.seen = map[string]fileState{}
+	}
+	for i := range w.Config.Orgs {
+		if ctx.Err() != nil {
+			return
+		}
+		org := &w.Config.Orgs[i]
+		for _, path := range w.settled(org, now) {
+			if err := w.Process(ctx, org, path); err != nil {
+				w.Logger.Printf("%s: %s: %v", org.Dir, path, err)
+			}
+		}
+	}
+}
+
+// settled returns the inbox files that have not changed for Settle and were
+// already seen unchanged on the previous scan.
+func (w *Watcher) settled(org *Org, now bool) []string {
+	var out []string
+	inbox := org.Folder(FolderInbox)
+	current := map[string]bool{}
+	filepath.WalkDir(inbox, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			w.Logger.Printf("%s: %v", org.Dir, err)
+			return nil
+		}
+		if d.IsDir() || ignored(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		current[path] = true
+		state := fileState{size: info.Size(), modTime: info.ModTime()}
+		prev, ok := w.seen[path]
+		w.seen[path] = state
+		if now || (ok && prev == state && time.Since(state.modTime) >= time.Duration(w.Config.Settle)) {
+			out = append(out, path)
+		}
+		return nil
+	})
+	for path := range w.seen {
+		if strings.HasPrefix(path, inbox+string(filepath.Separator)) && !current[path] {
+			delete(w.seen, path)
+		}
+	}
+	return out
+}
+
+// ignored skips hidden files, partial uploads and our own reports.
+func ignored(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "~") || strings.HasSuffix(name, ".result.json") {
+		return true
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".tmp", ".part", ".partial", ".crdownload", ".filepart":
+		return true
+	}
+	return false
+}
+
+// Process claims, imports and files away one inbox file.
+func (w *Watcher) Process(ctx context.Context, org *Org, path string) error {
+	rel, err := filepath.Rel(org.Folder(FolderInbox), path)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(path)
+	folderKind, sub := "", ""
+	if parts := strings.Split(filepath.ToSlash(filepath.Dir(rel)), "/"); parts[0] != "." {
+		folderKind = parts[0]
+		sub = strings.Join(parts[1:], "/")
+	}
+
+	hash, size, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	delete(w.seen, path)
+
+	// Move out of the inbox first so a crash never re-offers the file.
+	working := filepath.Join(org.Folder(FolderProcessing), hash[:12]+"-"+name)
+	if err := os.Rename(path, working); err != nil {
+		return err
+	}
+
+	file := &File{
+		ID:             cashflow.NewID("ingest"),
+		OrganizationID: org.OrganizationID,
+		ContentHash:    hash,
+		FileName:       rel,
+		Size:           size,
+		Status:         FileProcessing,
+		ReceivedAt:     time.Now(),
+	}
+	head, _ := readHead(working, 4096)
+	handler, detectErr := detect(folderKind, name, head)
+	if handler != nil {
+		file.Kind = handler.Kind()
+	}
+
+	var myErr *mysql.MySQLError
+	if err := insertFile(ctx, w.DB, file); errors.As(err, &myErr) && myErr.Number == 1062 {
+		original, lookupErr := fileByHash(ctx, w.DB, org.OrganizationID, hash)
+		if lookupErr != nil {
+			return lookupErr
+		}
+		file.Status = FileDuplicate
+		finished := time.Now()
+		file.FinishedAt = &finished
+		// Keep duplicates apart so they never overwrite the original's archive.
+		dup := filepath.Join(org.Folder(FolderProcessing), fmt.Sprintf("%d-%s", finished.UnixMilli(), filepath.Base(working)))
+		if err := os.Rename(working, dup); err != nil {
+			return err
+		}
+		dest, err := w.file(org, dup, FolderArchive, "duplicates", &Report{
+			File: file, Original: original,
+			Note: "same content as " + original.FileName + "; not processed again",
+		})
+		w.Logger.Printf("%s: %s duplicates %s (%s), archived as %s", org.Dir, rel, original.FileName, original.ID, dest)
+		return err
+	} else if err != nil {
+		// Not claimed: put it back for the next scan.
+		os.Rename(working, path)
+		return fmt.Errorf("claim: %w", err)
+	}
+
+	var result any
+	if detectErr != nil {
+		err = detectErr
+	} else {
+		result, err = runHandler(ctx, handler, &Job{DB: w.DB, Org: org, Path: working, Name: name, Sub: sub})
+	}
+
+	finished := time.Now()
+	file.FinishedAt = &finished
+	folder := FolderArchive
+	file.Status = FileDone
+	if err != nil {
+		folder = FolderError
+		file.Status = FileFailed
+		file.Error = err.Error()
+	}
+	dest, moveErr := w.file(org, working, folder, "", &Report{File: file, Result: result})
+	if moveErr != nil {
+		w.Logger.Printf("%s: %s: %v", org.Dir, rel, moveErr)
+	}
+	file.StoredPath = dest
+	if err := finishFile(ctx, w.DB, file); err != nil {
+		return fmt.Errorf("record outcome of %s: %w", file.ID, err)
+	}
+	w.Logger.Printf("%s: %s [%s] %s -> %s", org.Dir, rel, file.Kind, file.Status, dest)
+	return nil
+}
+
+func runHandler(ctx context.Context, h Handler, job *Job) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("importer panicked: %v", r)
+		}
+	}()
+	return h.Handle(ctx, job)
+}
+
+// file moves a processed file into archive/<date>/[sub] or error/ and writes
+// its report alongside.
+func (w *Watcher) file(org *Org, working, folder, sub string, rep *Report) (string, error) {
+	dir := org.Folder(folder)
+	if folder == FolderArchive {
+		dir = filepath.Join(dir, time.Now().Format(time.DateOnly), sub)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	dest := filepath.Join(dir, filepath.Base(working))
+	rep.File.StoredPath = dest
+	if err := os.Rename(working, dest); err != nil {
+		return "", err
+	}
+	out, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return dest, err
+	}
+	return dest, os.WriteFile(dest+".result.json", out, 0o644)
+}
+
+// Retry releases a failed file and moves it from the error folder back into
+// the inbox, under its original folder, so the next scan imports it again.
+func Retry(ctx context.Context, conn *sql.DB, cfg *Config, id string) (string, error) {
+	f, err := GetFile(ctx, conn, id)
+	if err != nil {
+		return "", err
+	}
+	var org *Org
+	for i := range cfg.Orgs {
+		if cfg.Orgs[i].OrganizationID == f.OrganizationID {
+			org = &cfg.Orgs[i]
+		}
+	}
+	if org == nil {
+		return "", fmt.Errorf("organization %s is not configured", f.OrganizationID)
+	}
+	if f.Status != FileFailed || f.StoredPath == "" {
+		return "", fmt.Errorf("file %s is %s; only failed files can be retried", id, f.Status)
+	}
+
+	dest := filepath.Join(org.Folder(FolderInbox), f.FileName)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.StoredPath, dest); err != nil {
+		return "", err
+	}
+	if err := Release(ctx, conn, id); err != nil {
+		os.Rename(dest, f.StoredPath)
+		return "", err
+	}
+	os.Remove(f.StoredPath + ".result.json")
+	return dest, nil
+}
+
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+func readHead(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, n)
+	m, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf[:m], nil
+}
@@ -0,0 +1,236 @@
+package intercompany
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+)
+
+// Match bases, strongest first.
+const (
+	BasisMirror    = "mirror"    // one side was posted as the other's mirror
+	BasisReference = "reference" // same reference, opposite amount, dates within tolerance
+	BasisAmount    = "amount"    // opposite amount, dates within tolerance
+)
+
+// Posting is one OA transaction's net amount on a pair account, in minor
+// units of the account currency, debits positive.
+type Posting struct {
+	Side          string    `json:"side"`
+	TransactionID string    `json:"transactionId"`
+	Date          time.Time `json:"date"`
+	Description   string    `json:"description"`
+	Reference     string    `json:"reference,omitempty"`
+	Amount        int64     `json:"amount"`
+	// MirrorOf is the transaction this one was posted to mirror.
+	MirrorOf string `json:"mirrorOf,omitempty"`
+}
+
+// Match pairs a posting on each side. Issues explain a mismatch.
+type Match struct {
+	A      *Posting `json:"a"`
+	B      *Posting `json:"b"`
+	Basis  string   `json:"basis"`
+	Issues []string `json:"issues,omitempty"`
+}
+
+// Report is the reconciliation of a pair over a period.
+type Report struct {
+	Pair       string     `json:"pair"`
+	From       time.Time  `json:"from"`
+	To         time.Time  `json:"to"` // exclusive
+	Matched    []*Match   `json:"matched"`
+	Mismatched []*Match   `json:"mismatched"`
+	UnmatchedA []*Posting `json:"unmatchedA"`
+	UnmatchedB []*Posting `json:"unmatchedB"`
+	// BalanceA and BalanceB are the accounts' balances before To. The
+	// two sides agree when they cancel out.
+	BalanceA int64 `json:"balanceA"`
+	BalanceB int64 `json:"balanceB"`
+}
+
+// Clean reports whether every posting matched and the balances cancel.
+func (r *Report) Clean() bool {
+	return len(r.Mismatched) == 0 && len(r.UnmatchedA) == 0 && len(r.UnmatchedB) == 0 && r.BalanceA+r.BalanceB == 0
+}
+
+// Reconcile matches the pair's postings dated in [from, to):
+//
+//  1. a posting made as the other's mirror matches it;
+//  2. then postings with the same reference and opposite amounts, dated
+//     within the tolerance, closest dates first;
+//  3. then postings with opposite amounts within the tolerance;
+//  4. mirrors and postings sharing a reference that are still left are
+//     mismatched, with the amount or date difference as issues.
+//
+// The rest are unmatched.
+func Reconcile(ctx context.Context, cf, oadb cashflow.Querier, p *Pair, from, to time.Time) (*Report, error) {
+	a, b := p.Sides()
+	as, balanceA, err := load(ctx, cf, oadb, a, from, to)
+	if err != nil {
+		return nil, err
+	}
+	bs, balanceB, err := load(ctx, cf, oadb, b, from, to)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{Pair: p.Name, From: from, To: to, BalanceA: balanceA, BalanceB: balanceB,
+		Matched: []*Match{}, Mismatched: []*Match{}}
+	r.match(as, bs, p.DateTolerance)
+	return r, nil
+}
+
+// match fills the report's matches and unmatched postings from the two
+// sides' postings, in the order Reconcile describes.
+func (r *Report) match(as, bs []*Posting, tolerance int) {
+	usedA, usedB := make([]bool, len(as)), make([]bool, len(bs))
+	pass := func(match func(x, y *Posting) bool, basis string, matched bool) {
+		for i, x := range as {
+			if usedA[i] {
+				continue
+			}
+			best := -1
+			for j, y := range bs {
+				if usedB[j] || !match(x, y) {
+					continue
+				}
+				if best < 0 || daysApart(x, y) < daysApart(x, bs[best]) {
+					best = j
+				}
+			}
+			if best < 0 {
+				continue
+			}
+			usedA[i], usedB[best] = true, true
+			m := &Match{A: x, B: bs[best], Basis: basis}
+			if matched {
+				r.Matched = append(r.Matched, m)
+				continue
+			}
+			m.Issues = issues(x, bs[best], tolerance)
+			r.Mismatched = append(r.Mismatched, m)
+		}
+	}
+	within := func(x, y *Posting) bool { return daysApart(x, y) <= tolerance }
+	sameRef := func(x, y *Posting) bool {
+		return x.Reference != "" && normalizeReference(x.Reference) == normalizeReference(y.Reference)
+	}
+	mirrored := func(x, y *Posting) bool { return x.MirrorOf == y.TransactionID || y.MirrorOf == x.TransactionID }
+
+	pass(func(x, y *Posting) bool { return mirrored(x, y) && x.Amount == -y.Amount }, BasisMirror, true)
+	pass(func(x, y *Posting) bool { return sameRef(x, y) && x.Amount == -y.Amount && within(x, y) }, BasisReference, true)
+	pass(func(x, y *Posting) bool { return x.Amount == -y.Amount && within(x, y) }, BasisAmount, true)
+	pass(mirrored, BasisMirror, false)
+	pass(sameRef, BasisReference, false)
+
+	for i, x := range as {
+		if !usedA[i] {
+			r.UnmatchedA = append(r.UnmatchedA, x)
+		}
+	}
+	for j, y := range bs {
+		if !usedB[j] {
+			r.UnmatchedB = append(r.UnmatchedB, y)
+		}
+	}
+}
+
+func issues(a, b *Posting, tolerance int) []string {
+	var out []string
+	if d := a.Amount + b.Amount; d != 0 {
+		out = append(out, fmt.Sprintf("amounts differ by %d", d))
+	}
+	if d := daysApart(a, b); d > tolerance {
+		out = append(out, fmt.Sprintf("dated %d days apart", d))
+	}
+	return out
+}
+
+func daysApart(a, b *Posting) int {
+	d := a.Date.Sub(b.Date)
+	if d < 0 {
+		d = -d
+	}
+	return int(d / (24 * time.Hour))
+}
+
+// load returns a side's postings in [from, to), oldest first, and the
+// account's balance before to.
+func load(ctx context.Context, cf, oadb cashflow.Querier, s Side, from, to time.Time) ([]*Posting, int64, error) {
+	orgID, err := oa.OrgForOrganization(ctx, cf, s.OrganizationID)
+	if err != nil {
+		return nil, 0, err
+	}
+	var balance int64
+	if err := oadb.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(s.amount), 0)
+		FROM split s JOIN transaction t ON t.id = s.transactionId
+		WHERE t.orgId = UNHEX(?) AND s.accountId = UNHEX(?) AND t.deleted = false AND s.deleted = false AND s.date < ?`,
+		orgID, s.AccountID, oa.Millis(to)).Scan(&balance); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := oadb.QueryContext(ctx, `
+		SELECT LOWER(HEX(t.id)), t.date, t.description, t.data, SUM(s.amount)
+		FROM split s JOIN transaction t ON t.id = s.transactionId
+		WHERE t.orgId = UNHEX(?) AND s.accountId = UNHEX(?) AND t.deleted = false AND s.deleted = false
+		  AND s.date >= ? AND s.date < ?
+		GROUP BY t.id, t.date, t.description, t.data
+		HAVING SUM(s.amount) <> 0
+		ORDER BY t.date, t.id`, orgID, s.AccountID, oa.Millis(from), oa.Millis(to))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var out []*Posting
+	for rows.Next() {
+		p := &Posting{Side: s.Label}
+		var ms int64
+		var data string
+		if err := rows.Scan(&p.TransactionID, &ms, &p.Description, &data, &p.Amount); err != nil {
+			return nil, 0, err
+		}
+		p.Date = time.UnixMilli(ms).UTC()
+		p.Reference, p.MirrorOf = parseData(data)
+		out = append(out, p)
+	}
+	return out, balance, rows.Err()
+}
+
+// mirrorData is the part of an OA transaction's data this module reads and
+// writes. Reference is shared by both sides of an intercompany document,
+// such as a loan agreement or the selling company's invoice number.
+type mirrorData struct {
+	Reference    string     `json:"reference,omitempty"`
+	Intercompany *mirrorRef `json:"intercompany,omitempty"`
+}
+
+type mirrorRef struct {
+	Pair               string `json:"pair"`
+	SourceOrganization string `json:"sourceOrganization"`
+	SourceTransaction  string `json:"sourceTransaction"`
+}
+
+// parseData returns a transaction's reference and the
+// transaction it mirrors. Descriptions are not used as references: ones
+// like "Loan repayment" recur and would pair unrelated postings.
+func parseData(data string) (reference, mirrorOf string) {
+	var d mirrorData
+	if strings.HasPrefix(strings.TrimSpace(data), "{") {
+		json.Unmarshal([]byte(data), &d)
+	}
+	if d.Intercompany != nil {
+		mirrorOf = d.Intercompany.SourceTransaction
+	}
+	return strings.TrimSpace(d.Reference), mirrorOf
+}
+
+func normalizeReference(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
@@ -0,0 +1,180 @@
+package intercompany
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func posting(id string, day int, amount int64, ref, mirrorOf string) *Posting {
+	return &Posting{TransactionID: id, Date: time.Date(2025, 5, day, 0, 0, 0, 0, time.UTC), Amount: amount,
+		Reference: ref, MirrorOf: mirrorOf}
+}
+
+func describe(ms []*Match) []string {
+	var out []string
+	for _, m := range ms {
+		s := fmt.Sprintf("%s-%s %s", m.A.TransactionID, m.B.TransactionID, m.Basis)
+		if len(m.Issues) > 0 {
+			s += ": " + strings.Join(m.Issues, "; ")
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func ids(ps []*Posting) []string {
+	var out []string
+	for _, p := range ps {
+		out = append(out, p.TransactionID)
+	}
+	return out
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		as, bs     []*Posting
+		tolerance  int
+		matched    []string
+		mismatched []string
+		unmatchedA []string
+		unmatchedB []string
+	}{
+		{
+			name:    "mirror regardless of dates",
+			as:      []*Posting{posting("a1", 1, 5000, "", "")},
+			bs:      []*Posting{posting("b1", 20, -5000, "", "a1")},
+			matched: []string{"a1-b1 mirror"},
+		},
+		{
+			name:       "reference before amount",
+			as:         []*Posting{posting("a1", 1, 700, "LN-1", "")},
+			bs:         []*Posting{posting("b1", 1, -700, "", ""), posting("b2", 3, -700, " ln-1 ", "")},
+			tolerance:  3,
+			matched:    []string{"a1-b2 reference"},
+			unmatchedB: []string{"b1"},
+		},
+		{
+			name:       "amount, closest date first",
+			as:         []*Posting{posting("a1", 10, 300, "", "")},
+			bs:         []*Posting{posting("b1", 7, -300, "", ""), posting("b2", 11, -300, "", "")},
+			tolerance:  3,
+			matched:    []string{"a1-b2 amount"},
+			unmatchedB: []string{"b1"},
+		},
+		{
+			name:       "amount outside the tolerance",
+			as:         []*Posting{posting("a1", 1, 300, "", "")},
+			bs:         []*Posting{posting("b1", 9, -300, "", "")},
+			tolerance:  3,
+			unmatchedA: []string{"a1"},
+			unmatchedB: []string{"b1"},
+		},
+		{
+			name:       "same sign does not match",
+			as:         []*Posting{posting("a1", 1, 300, "", "")},
+			bs:         []*Posting{posting("b1", 1, 300, "", "")},
+			unmatchedA: []string{"a1"},
+			unmatchedB: []string{"b1"},
+		},
+		{
+			name:       "mirror with a different amount",
+			as:         []*Posting{posting("a1", 1, 5000, "", "")},
+			bs:         []*Posting{posting("b1", 1, -4500, "", "a1")},
+			mismatched: []string{"a1-b1 mirror: amounts differ by 500"},
+		},
+		{
+			name:       "reference with a different amount and date",
+			as:         []*Posting{posting("a1", 1, 5000, "INV 9", "")},
+			bs:         []*Posting{posting("b1", 12, -5200, "inv  9", "")},
+			tolerance:  2,
+			mismatched: []string{"a1-b1 reference: amounts differ by -200; dated 11 days apart"},
+		},
+		{
+			name:       "reference outside the tolerance",
+			as:         []*Posting{posting("a1", 1, 5000, "INV 9", "")},
+			bs:         []*Posting{posting("b1", 12, -5000, "INV 9", "")},
+			tolerance:  2,
+			mismatched: []string{"a1-b1 reference: dated 11 days apart"},
+		},
+		{
+			name: "each posting matches once",
+			as: []*Posting{posting("a1", 1, 100, "", ""), posting("a2", 2, 100, "", ""),
+				posting("a3", 3, 100, "", "")},
+			bs:         []*Posting{posting("b1", 2, -100, "", ""), posting("b2", 3, -100, "", "")},
+			tolerance:  5,
+			matched:    []string{"a1-b1 amount", "a2-b2 amount"},
+			unmatchedA: []string{"a3"},
+		},
+		{
+			name: "stronger bases are taken first",
+			as: []*Posting{posting("a1", 5, 100, "", ""), posting("a2", 5, 100, "REF", ""),
+				posting("a3", 5, 100, "", "")},
+			bs: []*Posting{posting("b1", 5, -100, "", "a3"), posting("b2", 5, -100, "ref", ""),
+				posting("b3", 5, -100, "", "")},
+			matched: []string{"a3-b1 mirror", "a2-b2 reference", "a1-b3 amount"},
+		},
+		{name: "nothing on either side"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Report{Matched: []*Match{}, Mismatched: []*Match{}}
+			r.match(tt.as, tt.bs, tt.tolerance)
+			if got := describe(r.Matched); !reflect.DeepEqual(got, tt.matched) {
+				t.Errorf("matched = %q, want %q", got, tt.matched)
+			}
+			if got := describe(r.Mismatched); !reflect.DeepEqual(got, tt.mismatched) {
+				t.Errorf("mismatched = %q, want %q", got, tt.mismatched)
+			}
+			if got := ids(r.UnmatchedA); !reflect.DeepEqual(got, tt.unmatchedA) {
+				t.Errorf("unmatched a = %v, want %v", got, tt.unmatchedA)
+			}
+			if got := ids(r.UnmatchedB); !reflect.DeepEqual(got, tt.unmatchedB) {
+				t.Errorf("unmatched b = %v, want %v", got, tt.unmatchedB)
+			}
+		})
+	}
+}
+
+func TestParseData(t *testing.T) {
+	tests := []struct {
+		data          string
+		ref, mirrorOf string
+	}{
+		{data: `{"reference":" LN-2025-01 "}`, ref: "LN-2025-01"},
+		{data: `{"intercompany":{"pair":"alpha-beta","sourceTransaction":"ab12"}}`, mirrorOf: "ab12"},
+		{data: `{"reference":"R1","intercompany":{"sourceTransaction":"ab12"}}`, ref: "R1", mirrorOf: "ab12"},
+		{data: `Loan repayment`},
+		{data: ``},
+		{data: `{"reference":`},
+	}
+	for _, tt := range tests {
+		ref, mirrorOf := parseData(tt.data)
+		if ref != tt.ref || mirrorOf != tt.mirrorOf {
+			t.Errorf("parseData(%q) = %q, %q; want %q, %q", tt.data, ref, mirrorOf, tt.ref, tt.mirrorOf)
+		}
+	}
+}
+
+func TestClean(t *testing.T) {
+	p := posting("a1", 1, 100, "", "")
+	tests := []struct {
+		name string
+		r    Report
+		want bool
+	}{
+		{name: "empty", r: Report{}, want: true},
+		{name: "balances cancel", r: Report{BalanceA: 500, BalanceB: -500}, want: true},
+		{name: "balances differ", r: Report{BalanceA: 500, BalanceB: -400}},
+		{name: "mismatch", r: Report{Mismatched: []*Match{{A: p, B: p}}}},
+		{name: "unmatched", r: Report{UnmatchedB: []*Posting{p}}},
+	}
+	for _, tt := range tests {
+		if got := tt.r.Clean(); got != tt.want {
+			t.Errorf("%s: Clean = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package intercompany
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+)
+
+// Mirror statuses.
+const (
+	MirrorPlanned = "planned" // dry run
+	MirrorPosted  = "posted"
+	MirrorFailed  = "failed"
+	MirrorSkipped = "skipped"
+)
+
+// Poster posts OA transactions; *oa.Client is one.
+type Poster interface {
+	PostTransaction(ctx context.Context, orgID string, t *oa.Transaction) error
+}
+
+// MirrorResult is what happened to one unmatched posting.
+type MirrorResult struct {
+	Source              *Posting `json:"source"`
+	MirrorOrganization  string   `json:"mirrorOrganization"`
+	MirrorTransactionID string   `json:"mirrorTransactionId,omitempty"`
+	Status              string   `json:"status"`
+	Note                string   `json:"note,omitempty"`
+}
+
+// Mirror posts, for every unmatched posting of a report on side ("a", "b"
+// or "" for both), the missing transaction in the other org: the opposite
+// amount on that org's pair account against its mirror account, with the
+// same date and reference. With a nil poster nothing is written and the
+// results are only planned.
+//
+// Each mirror is logged in intercompany_mirrors before it is posted, under
+// an id chosen up front, so running it again after a failure retries the
+// same transaction instead of creating a second one.
+func Mirror(ctx context.Context, cf *sql.DB, oadb cashflow.Querier, poster Poster, p *Pair, r *Report, side, by string) ([]*MirrorResult, error) {
+	a, b := p.Sides()
+	var out []*MirrorResult
+	for _, run := range []struct {
+		source, target Side
+		postings       []*Posting
+	}{{a, b, r.UnmatchedA}, {b, a, r.UnmatchedB}} {
+		if side != "" && side != run.source.Label || len(run.postings) == 0 {
+			continue
+		}
+		t := run.target
+		if t.MirrorAccount == "" {
+			return nil, fmt.Errorf("pair %s has no mirror account on side %s", p.Name, t.Label)
+		}
+		orgID, err := oa.OrgForOrganization(ctx, cf, t.OrganizationID)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkCurrency(ctx, oadb, orgID, t.AccountID, t.MirrorAccount); err != nil {
+			return nil, fmt.Errorf("side %s: %w", t.Label, err)
+		}
+
+		for _, src := range run.postings {
+			res := &MirrorResult{Source: src, MirrorOrganization: t.OrganizationID, Status: MirrorPlanned}
+			out = append(out, res)
+			if poster == nil {
+				continue
+			}
+			if err := mirrorOne(ctx, cf, oadb, poster, p, run.source, t, orgID, src, by, res); err != nil {
+				return out, err
+			}
+		}
+	}
+	return out, nil
+}
+
+func mirrorOne(ctx context.Context, cf *sql.DB, oadb cashflow.Querier, poster Poster, p *Pair, source, target Side,
+	orgID string, src *Posting, by string, res *MirrorResult) error {
+	claimed, status, err := claim(ctx, cf, p, source, target, src, by, res)
+	if err != nil {
+		return err
+	}
+	if !claimed && status == MirrorPosted {
+		res.Status, res.Note = MirrorSkipped, "already mirrored"
+		return nil
+	}
+	if !claimed {
+		// A retry: the earlier post may have reached OA before failing.
+		var n int
+		if err := oadb.QueryRowContext(ctx, `SELECT COUNT(*) FROM transaction WHERE id = UNHEX(?)`,
+			res.MirrorTransactionID).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			res.Status, res.Note = MirrorPosted, "found in OA from an earlier attempt"
+			return finish(ctx, cf, p.Name, src.TransactionID, MirrorPosted, "")
+		}
+	}
+
+	data, _ := json.Marshal(mirrorData{
+		Reference:    src.Reference,
+		Intercompany: &mirrorRef{Pair: p.Name, SourceOrganization: source.OrganizationID, SourceTransaction: src.TransactionID},
+	})
+	tx := &oa.Transaction{
+		ID:          res.MirrorTransactionID,
+		Date:        src.Date,
+		Description: "Intercompany: " + src.Description,
+		Data:        string(data),
+		Splits: []*oa.TxSplit{
+			{AccountID: target.AccountID, Amount: -src.Amount, NativeAmount: -src.Amount},
+			{AccountID: target.MirrorAccount, Amount: src.Amount, NativeAmount: src.Amount},
+		},
+	}
+	if err := poster.PostTransaction(ctx, orgID, tx); err != nil {
+		res.Status, res.Note = MirrorFailed, err.Error()
+		return finish(ctx, cf, p.Name, src.TransactionID, MirrorFailed, err.Error())
+	}
+	res.Status = MirrorPosted
+	return finish(ctx, cf, p.Name, src.TransactionID, MirrorPosted, "")
+}
+
+// claim logs a mirror before it is posted. When the source already has
+// one, its transaction id is reused and its status returned.
+func claim(ctx context.Context, cf *sql.DB, p *Pair, source, target Side, src *Posting, by string, res *MirrorResult) (bool, string, error) {
+	res.MirrorTransactionID = oa.NewTransactionID()
+	result, err := cf.ExecContext(ctx, `
+		INSERT IGNORE INTO intercompany_mirrors (id, pair_name, source_organization, source_transaction_id,
+		  mirror_organization, mirror_transaction_id, amount, status, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 'pending', ?, ?)`,
+		cashflow.NewID("icm"), p.Name, source.OrganizationID, src.TransactionID, target.OrganizationID,
+		res.MirrorTransactionID, -src.Amount, cashflow.NullString(by), time.Now())
+	if err != nil {
+		return false, "", err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 1 {
+		return err == nil, "", err
+	}
+
+	var status string
+	err = cf.QueryRowContext(ctx, `
+		SELECT mirror_transaction_id, status FROM intercompany_mirrors WHERE pair_name = ? AND source_transaction_id = ?`,
+		p.Name, src.TransactionID).Scan(&res.MirrorTransactionID, &status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, "", fmt.Errorf("mirror of %s: %w", src.TransactionID, cashflow.ErrNotFound)
+	}
+	return false, status, err
+}
+
+func finish(ctx context.Context, cf *sql.DB, pair, sourceTransactionID, status, message string) error {
+	var postedAt any
+	if status == MirrorPosted {
+		postedAt = time.Now()
+	}
+	_, err := cf.ExecContext(ctx, `
+		UPDATE intercompany_mirrors SET status = ?, error = ?, posted_at = ?
+		WHERE pair_name = ? AND source_transaction_id = ?`,
+		status, cashflow.NullString(message), postedAt, pair, sourceTransactionID)
+	return err
+}
+
+// checkCurrency refuses mirrors into accounts kept in another currency
+// than their org: the native amount of such a split needs a rate.
+func checkCurrency(ctx context.Context, oadb cashflow.Querier, orgID string, accounts ...string) error {
+	org, err := oa.GetOrg(ctx, oadb, orgID)
+	if err != nil {
+		return err
+	}
+	chart, err := oa.LoadChart(ctx, oadb, orgID)
+	if err != nil {
+		return err
+	}
+	for _, id := range accounts {
+		a := chart.Accounts[id]
+		if a == nil {
+			return fmt.Errorf("account %s is not in org %s", id, org.Name)
+		}
+		if a.Currency != org.Currency {
+			return fmt.Errorf("%s is kept in %s, not %s; post its mirror by hand", chart.FullName(id), a.Currency, org.Currency)
+		}
+	}
+	return nil
+}
+
+// Mirrors returns a pair's mirror log, newest first.
+func Mirrors(ctx context.Context, q cashflow.Querier, pair string, limit int) ([]*MirrorLog, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT source_organization, source_transaction_id, mirror_organization, mirror_transaction_id, amount,
+		       status, COALESCE(error, ''), COALESCE(created_by, ''), created_at
+		FROM intercompany_mirrors WHERE pair_name = ?
+		ORDER BY created_at DESC LIMIT ?`, pair, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*MirrorLog
+	for rows.Next() {
+		m := &MirrorLog{Pair: pair}
+		if err := rows.Scan(&m.SourceOrganization, &m.SourceTransactionID, &m.MirrorOrganization, &m.MirrorTransactionID,
+			&m.Amount, &m.Status, &m.Error, &m.CreatedBy, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// MirrorLog is an intercompany_mirrors row.
+type MirrorLog struct {
+	Pair                string
+	SourceOrganization  string
+	SourceTransactionID string
+	MirrorOrganization  string
+	MirrorTransactionID string
+	Amount              int64 // on the mirror org's pair account
+	Status              string
+	Error               string
+	CreatedBy           string
+	CreatedAt           time.Time
+}
@@ -0,0 +1,229 @@
+// Package intercompany reconciles the two sides of transactions between
+// companies of one group kept in separate OA orgs: a loan or sale booked
+// in one org should appear, with the opposite sign, in the counterparty
+// account of the other.
+//
+// A pair names the two accounts that mirror each other, say "Loan to
+// Beta" in Alpha's org and "Loan from Alpha" in Beta's. Matching reads
+// both accounts' postings over a period and pairs them by mirror link,
+// reference, amount and date; what is left is reported as mismatched or
+// unmatched, and a missing side can be posted from the other.
+package intercompany
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Tables are the cashflowdb tables owned by the intercompany module.
+var Tables = []schema.Table{
+	{
+		Name: "intercompany_pairs",
+		Create: `CREATE TABLE IF NOT EXISTS intercompany_pairs (
+  name VARCHAR(100) NOT NULL,
+  organization_a VARCHAR(191) NOT NULL,
+  account_a CHAR(32) NOT NULL,
+  mirror_account_a CHAR(32) NULL,
+  organization_b VARCHAR(191) NOT NULL,
+  account_b CHAR(32) NOT NULL,
+  mirror_account_b CHAR(32) NULL,
+  date_tolerance_days INT NOT NULL,
+  created_by VARCHAR(191) NULL,
+  created_at DATETIME(3) NOT NULL,
+  updated_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (name),
+  INDEX intercompany_pairs_org_a_idx (organization_a),
+  INDEX intercompany_pairs_org_b_idx (organization_b)
+) ENGINE=InnoDB`,
+	},
+	{
+		Name: "intercompany_mirrors",
+		Create: `CREATE TABLE IF NOT EXISTS intercompany_mirrors (
+  id VARCHAR(191) NOT NULL,
+  pair_name VARCHAR(100) NOT NULL,
+  source_organization VARCHAR(191) NOT NULL,
+  source_transaction_id CHAR(32) NOT NULL,
+  mirror_organization VARCHAR(191) NOT NULL,
+  mirror_transaction_id CHAR(32) NOT NULL,
+  amount BIGINT NOT NULL,
+  status VARCHAR(20) NOT NULL,
+  error TEXT NULL,
+  created_by VARCHAR(191) NULL,
+  created_at DATETIME(3) NOT NULL,
+  posted_at DATETIME(3) NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY intercompany_mirrors_source_key (pair_name, source_transaction_id)
+) ENGINE=InnoDB`,
+	},
+}
+
+// Pair is an intercompany_pairs row. Accounts are OA account ids; the
+// mirror accounts take the other leg of a mirror posting in their org,
+// typically a bank or clearing account, and are only needed to post
+// mirrors into that side.
+type Pair struct {
+	Name           string
+	OrganizationA  string // cashflowdb organization
+	AccountA       string
+	MirrorAccountA string
+	OrganizationB  string
+	AccountB       string
+	MirrorAccountB string
+	// DateTolerance is how many days apart the two sides may be dated.
+	DateTolerance int
+	CreatedBy     string
+}
+
+// Side is one org's half of a pair.
+type Side struct {
+	Label          string // "a" or "b"
+	OrganizationID string
+	AccountID      string
+	MirrorAccount  string
+}
+
+// Sides returns the pair's two sides.
+func (p *Pair) Sides() (a, b Side) {
+	return Side{"a", p.OrganizationA, p.AccountA, p.MirrorAccountA},
+		Side{"b", p.OrganizationB, p.AccountB, p.MirrorAccountB}
+}
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,99}$`)
+
+// Define creates or updates a pair. Account references are resolved
+// against each org's chart: an OA account id, or a full name such as
+// "Assets:Loan to Beta". The two accounts must share a currency, since
+// their amounts are compared as they are.
+func Define(ctx context.Context, cf, oadb cashflow.Querier, p *Pair) error {
+	if !namePattern.MatchString(p.Name) {
+		return fmt.Errorf("pair name %q must be lower case letters, digits, '.', '_' or '-'", p.Name)
+	}
+	if p.OrganizationA == p.OrganizationB {
+		return errors.New("a pair needs two different organizations")
+	}
+	if p.DateTolerance < 0 {
+		return errors.New("date tolerance cannot be negative")
+	}
+
+	charts := map[string]*oa.Chart{}
+	for _, org := range []string{p.OrganizationA, p.OrganizationB} {
+		orgID, err := oa.OrgForOrganization(ctx, cf, org)
+		if err != nil {
+			return err
+		}
+		if charts[org], err = oa.LoadChart(ctx, oadb, orgID); err != nil {
+			return err
+		}
+	}
+	var err error
+	for _, ref := range []struct {
+		org  string
+		dest *string
+		what string
+	}{
+		{p.OrganizationA, &p.AccountA, "account a"},
+		{p.OrganizationA, &p.MirrorAccountA, "mirror account a"},
+		{p.OrganizationB, &p.AccountB, "account b"},
+		{p.OrganizationB, &p.MirrorAccountB, "mirror account b"},
+	} {
+		if *ref.dest == "" {
+			continue
+		}
+		if *ref.dest, err = ResolveAccount(charts[ref.org], *ref.dest); err != nil {
+			return fmt.Errorf("%s: %w", ref.what, err)
+		}
+	}
+	if p.AccountA == "" || p.AccountB == "" {
+		return errors.New("both accounts are required")
+	}
+	a, b := charts[p.OrganizationA].Accounts[p.AccountA], charts[p.OrganizationB].Accounts[p.AccountB]
+	if a.Currency != b.Currency || a.Precision != b.Precision {
+		return fmt.Errorf("accounts are in %s and %s; intercompany pairs must share a currency", a.Currency, b.Currency)
+	}
+
+	now := time.Now()
+	_, err = cf.ExecContext(ctx, `
+		INSERT INTO intercompany_pairs (name, organization_a, account_a, mirror_account_a, organization_b, account_b,
+		  mirror_account_b, date_tolerance_days, created_by, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE organization_a = VALUES(organization_a), account_a = VALUES(account_a),
+		  mirror_account_a = VALUES(mirror_account_a), organization_b = VALUES(organization_b),
+		  account_b = VALUES(account_b), mirror_account_b = VALUES(mirror_account_b),
+		  date_tolerance_days = VALUES(date_tolerance_days), updated_at = VALUES(updated_at)`,
+		p.Name, p.OrganizationA, p.AccountA, cashflow.NullString(p.MirrorAccountA), p.OrganizationB, p.AccountB,
+		cashflow.NullString(p.MirrorAccountB), p.DateTolerance, cashflow.NullString(p.CreatedBy), now, now)
+	return err
+}
+
+// ResolveAccount returns the id of a chart account given its id or its
+// full name. Only leaf accounts carry splits, so parents are refused.
+func ResolveAccount(chart *oa.Chart, ref string) (string, error) {
+	id := ""
+	if a := chart.Accounts[oa.NormalizeID(ref)]; a != nil {
+		id = a.ID
+	} else {
+		for accountID := range chart.Accounts {
+			if strings.EqualFold(chart.FullName(accountID), strings.TrimSpace(ref)) {
+				id = accountID
+				break
+			}
+		}
+	}
+	if id == "" {
+		return "", fmt.Errorf("no account %q", ref)
+	}
+	if !chart.Leaf(id) {
+		return "", fmt.Errorf("%s has sub-accounts; pick a leaf account", chart.FullName(id))
+	}
+	return id, nil
+}
+
+const pairColumns = `name, organization_a, account_a, COALESCE(mirror_account_a, ''), organization_b, account_b,
+	COALESCE(mirror_account_b, ''), date_tolerance_days, COALESCE(created_by, '')`
+
+func scanPair(row interface{ Scan(...any) error }) (*Pair, error) {
+	p := &Pair{}
+	err := row.Scan(&p.Name, &p.OrganizationA, &p.AccountA, &p.MirrorAccountA, &p.OrganizationB, &p.AccountB,
+		&p.MirrorAccountB, &p.DateTolerance, &p.CreatedBy)
+	return p, err
+}
+
+// GetPair loads a pair by name.
+func GetPair(ctx context.Context, q cashflow.Querier, name string) (*Pair, error) {
+	p, err := scanPair(q.QueryRowContext(ctx, `SELECT `+pairColumns+` FROM intercompany_pairs WHERE name = ?`, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("intercompany pair %s: %w", name, cashflow.ErrNotFound)
+	}
+	return p, err
+}
+
+// Pairs returns every pair, or those involving an organization.
+func Pairs(ctx context.Context, q cashflow.Querier, organizationID string) ([]*Pair, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+pairColumns+` FROM intercompany_pairs
+		WHERE ? = '' OR organization_a = ? OR organization_b = ?
+		ORDER BY name`, organizationID, organizationID, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Pair
+	for rows.Next() {
+		p, err := scanPair(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
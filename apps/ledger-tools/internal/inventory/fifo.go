@@ -0,0 +1,220 @@
+// Package inventory consumes and receives stock in cashflowdb's FIFO layers,
+// following InventoryService.processOutbound/processInbound: layers are
+// consumed oldest first, each consumption writes an 'out' movement, and the
+// layer's quantityRemaining is reduced.
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// QuantityEpsilon absorbs DECIMAL(12,4) rounding when comparing quantities.
+const QuantityEpsilon = 0.00005
+
+// Outbound describes stock leaving a warehouse.
+type Outbound struct {
+	ItemID       string
+	WarehouseID  string
+	Quantity     float64
+	MovementType string // sale, adjustment, transfer_out, ...
+	SourceType   string
+	SourceID     string
+	JournalID    string
+	Reference    string
+	Date         time.Time // only layers created on or before Date are consumed
+	// AllowShortfall consumes what is available instead of failing when the
+	// warehouse holds less than Quantity.
+	AllowShortfall bool
+}
+
+// Consumption is one layer drawn down by an outbound.
+type Consumption struct {
+	LayerID    string
+	MovementID string
+	Quantity   float64
+	UnitCost   float64
+	TotalCost  money.Amount
+}
+
+// OutboundResult summarizes an outbound.
+type OutboundResult struct {
+	Consumed  []Consumption
+	Quantity  float64
+	TotalCost money.Amount
+	Shortfall float64
+}
+
+// ErrInsufficient is returned when a warehouse cannot cover an outbound.
+type ErrInsufficient struct {
+	ItemID      string
+	WarehouseID string
+	Available   float64
+	Required    float64
+}
+
+func (e *ErrInsufficient) Error() string {
+	return fmt.Sprintf("insufficient inventory for item %s in warehouse %s. Available: %g, Required: %g",
+		e.ItemID, e.WarehouseID, e.Available, e.Required)
+}
+
+type layer struct {
+	id        string
+	remaining float64
+	unitCost  float64
+}
+
+// ConsumeFIFO draws an outbound from the oldest layers. q must be a
+// transaction; the layers are locked for update.
+func ConsumeFIFO(ctx context.Context, q cashflow.Querier, out Outbound) (*OutboundResult, error) {
+	if out.Quantity <= 0 {
+		return nil, fmt.Errorf("outbound quantity must be positive, got %g", out.Quantity)
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, quantityRemaining, unitCost FROM inventory_layers
+		WHERE itemId = ? AND warehouseId = ? AND quantityRemaining > 0 AND createdAt <= ?
+		ORDER BY createdAt, id
+		FOR UPDATE`, out.ItemID, out.WarehouseID, out.Date)
+	if err != nil {
+		return nil, err
+	}
+	var layers []layer
+	var available float64
+	for rows.Next() {
+		var l layer
+		if err := rows.Scan(&l.id, &l.remaining, &l.unitCost); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		layers = append(layers, l)
+		available += l.remaining
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if available+QuantityEpsilon < out.Quantity && !out.AllowShortfall {
+		return nil, &ErrInsufficient{ItemID: out.ItemID, WarehouseID: out.WarehouseID, Available: available, Required: out.Quantity}
+	}
+
+	draws, shortfall := drawFIFO(layers, out.Quantity)
+	result := &OutboundResult{Shortfall: shortfall}
+	now := time.Now()
+	for _, d := range draws {
+		l := d.layer
+		cost := money.FromFloat(d.take * l.unitCost)
+
+		if _, err := q.ExecContext(ctx, `
+			UPDATE inventory_layers SET quantityRemaining = ?, updatedAt = ? WHERE id = ?`,
+			round4(l.remaining-d.take), now, l.id); err != nil {
+			return nil, err
+		}
+
+		movementID := cashflow.NewID("movement")
+		if _, err := q.ExecContext(ctx, `
+			INSERT INTO inventory_movements (id, itemId, warehouseId, layerId, direction, quantity, unitCost, totalValue,
+			  movementType, sourceType, sourceId, journalId, reference, createdAt, updatedAt)
+			VALUES (?, ?, ?, ?, 'out', ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			movementID, out.ItemID, out.WarehouseID, l.id, round4(d.take), l.unitCost, cost,
+			out.MovementType, cashflow.NullString(out.SourceType), cashflow.NullString(out.SourceID), cashflow.NullString(out.JournalID),
+			cashflow.NullString(out.Reference), out.Date, now); err != nil {
+			return nil, err
+		}
+
+		result.Consumed = append(result.Consumed, Consumption{
+			LayerID: l.id, MovementID: movementID, Quantity: d.take, UnitCost: l.unitCost, TotalCost: cost,
+		})
+		result.Quantity += d.take
+		result.TotalCost += cost
+	}
+	return result, nil
+}
+
+// draw is the quantity an outbound takes from one layer.
+type draw struct {
+	layer layer
+	take  float64
+}
+
+// drawFIFO takes qty from the layers in order and returns the draws and
+// the quantity they could not cover.
+func drawFIFO(layers []layer, qty float64) ([]draw, float64) {
+	var draws []draw
+	remaining := qty
+	for _, l := range layers {
+		if remaining <= QuantityEpsilon {
+			break
+		}
+		take := math.Min(l.remaining, remaining)
+		draws = append(draws, draw{layer: l, take: take})
+		remaining -= take
+	}
+	if remaining <= QuantityEpsilon {
+		remaining = 0
+	}
+	return draws, remaining
+}
+
+// Inbound describes stock arriving in a warehouse.
+type Inbound struct {
+	ItemID       string
+	WarehouseID  string
+	Quantity     float64
+	UnitCost     float64
+	MovementType string
+	SourceType   string
+	SourceID     string
+	JournalID    string
+	Reference    string
+	Date         time.Time
+}
+
+// Receive creates a new layer and its 'in' movement.
+func Receive(ctx context.Context, q cashflow.Querier, in Inbound) (layerID string, err error) {
+	if in.Quantity <= 0 {
+		return "", fmt.Errorf("inbound quantity must be positive, got %g", in.Quantity)
+	}
+	now := time.Now()
+	layerID = cashflow.NewID("layer")
+	if _, err := q.ExecContext(ctx, `
+		INSERT INTO inventory_layers (id, itemId, warehouseId, quantityRemaining, unitCost, sourceType, sourceId, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		layerID, in.ItemID, in.WarehouseID, round4(in.Quantity), in.UnitCost, in.SourceType, cashflow.NullString(in.SourceID), in.Date, now); err != nil {
+		return "", err
+	}
+	if _, err := q.ExecContext(ctx, `
+		INSERT INTO inventory_movements (id, itemId, warehouseId, layerId, direction, quantity, unitCost, totalValue,
+		  movementType, sourceType, sourceId, journalId, reference, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, 'in', ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		cashflow.NewID("movement"), in.ItemID, in.WarehouseID, layerID, round4(in.Quantity), in.UnitCost,
+		money.FromFloat(in.Quantity*in.UnitCost), in.MovementType, cashflow.NullString(in.SourceType), cashflow.NullString(in.SourceID),
+		cashflow.NullString(in.JournalID), cashflow.NullString(in.Reference), in.Date, now); err != nil {
+		return "", err
+	}
+	return layerID, nil
+}
+
+// LinkJournal sets the journal of movements written before their journal
+// existed, such as sales whose COGS is only known after consumption.
+func LinkJournal(ctx context.Context, q cashflow.Querier, journalID string, movementIDs []string) error {
+	now := time.Now()
+	for _, id := range movementIDs {
+		if _, err := q.ExecContext(ctx, `
+			UPDATE inventory_movements SET journalId = ?, updatedAt = ? WHERE id = ? AND journalId IS NULL`,
+			journalID, now, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func round4(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
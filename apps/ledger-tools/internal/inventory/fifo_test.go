@@ -0,0 +1,49 @@
+package inventory
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDrawFIFO(t *testing.T) {
+	layers := []layer{
+		{id: "l1", remaining: 2, unitCost: 10},
+		{id: "l2", remaining: 3, unitCost: 12},
+		{id: "l3", remaining: 5, unitCost: 11},
+	}
+	tests := []struct {
+		name      string
+		layers    []layer
+		qty       float64
+		want      map[string]float64
+		shortfall float64
+	}{
+		{name: "within the oldest layer", layers: layers, qty: 1.5, want: map[string]float64{"l1": 1.5}},
+		{name: "exactly the oldest layer", layers: layers, qty: 2, want: map[string]float64{"l1": 2}},
+		{name: "across layers", layers: layers, qty: 6, want: map[string]float64{"l1": 2, "l2": 3, "l3": 1}},
+		{name: "everything", layers: layers, qty: 10, want: map[string]float64{"l1": 2, "l2": 3, "l3": 5}},
+		{name: "short", layers: layers, qty: 12.5, want: map[string]float64{"l1": 2, "l2": 3, "l3": 5}, shortfall: 2.5},
+		{name: "no layers", qty: 4, want: map[string]float64{}, shortfall: 4},
+		{name: "rounding is not a shortfall", layers: []layer{{id: "l1", remaining: 0.99996, unitCost: 1}}, qty: 1,
+			want: map[string]float64{"l1": 0.99996}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			draws, shortfall := drawFIFO(tt.layers, tt.qty)
+			if len(draws) != len(tt.want) {
+				t.Fatalf("drew from %d layers, want %d: %+v", len(draws), len(tt.want), draws)
+			}
+			for i, d := range draws {
+				if d.layer != tt.layers[i] {
+					t.Errorf("draw %d is from %s, want layers in order", i, d.layer.id)
+				}
+				if math.Abs(d.take-tt.want[d.layer.id]) > 1e-9 {
+					t.Errorf("took %g from %s, want %g", d.take, d.layer.id, tt.want[d.layer.id])
+				}
+			}
+			if math.Abs(shortfall-tt.shortfall) > 1e-9 {
+				t.Errorf("shortfall = %g, want %g", shortfall, tt.shortfall)
+			}
+		})
+	}
+}
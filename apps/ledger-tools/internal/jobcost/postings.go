@@ -0,0 +1,430 @@
+package jobcost
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/dimensions"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/inventory"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// journalPrefix numbers the journals job costing posts itself. Report takes
+// labor and materials from their own tables and leaves these journals out
+// of the tagged costs so nothing is counted twice.
+const journalPrefix = "JOB-"
+
+// costAccount is where a project's labor and issued stock are debited: its
+// own account, else the first cost of goods sold or expense account.
+func costAccount(ctx context.Context, q cashflow.Querier, p *Project) (string, error) {
+	if p.CostAccountID != "" {
+		return p.CostAccountID, nil
+	}
+	return cashflow.AccountByType(ctx, q, p.OrganizationID, "cost_of_goods_sold", "expense")
+}
+
+func checkOpen(p *Project) error {
+	if p.Status != StatusOpen {
+		return fmt.Errorf("project %s is %s", p.Code, p.Status)
+	}
+	return nil
+}
+
+// tag marks every entry of a job costing journal with the project.
+func tag(ctx context.Context, q cashflow.Querier, p *Project, journalID string) error {
+	return dimensions.TagJournal(ctx, q, p.OrganizationID, journalID, dimensions.Origin{}, dimensions.Tags{Dimension: p.Code})
+}
+
+// Issue describes stock taken from a warehouse for a project.
+type Issue struct {
+	Item      string // product id or SKU
+	Warehouse string // warehouse id or code
+	Quantity  float64
+	Date      time.Time
+	Reference string
+}
+
+// IssueResult is what IssueStock consumed and posted.
+type IssueResult struct {
+	ItemName  string
+	Quantity  float64
+	Cost      money.Amount
+	JournalID string
+}
+
+// IssueStock consumes FIFO layers for a project, writing 'out' movements
+// with sourceType "project", and moves their cost from the inventory
+// account to the project's cost account. q must be a transaction.
+func IssueStock(ctx context.Context, q cashflow.Querier, p *Project, is Issue) (*IssueResult, error) {
+	if err := checkOpen(p); err != nil {
+		return nil, err
+	}
+	var itemID, itemName, inventoryAccount string
+	var tracked bool
+	err := q.QueryRowContext(ctx, `
+		SELECT id, name, trackInventory, COALESCE(inventoryAccountId, '') FROM products
+		WHERE organizationId = ? AND (id = ? OR sku = ?) AND isActive = true
+		ORDER BY id = ? DESC LIMIT 1`, p.OrganizationID, is.Item, is.Item, is.Item).
+		Scan(&itemID, &itemName, &tracked, &inventoryAccount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("item %s: %w", is.Item, cashflow.ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+	if !tracked {
+		return nil, fmt.Errorf("item %s does not track inventory", itemName)
+	}
+	var warehouseID string
+	err = q.QueryRowContext(ctx, `
+		SELECT id FROM warehouses WHERE organizationId = ? AND (id = ? OR code = ?) AND isActive = true`,
+		p.OrganizationID, is.Warehouse, is.Warehouse).Scan(&warehouseID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("warehouse %s: %w", is.Warehouse, cashflow.ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+
+	res, err := inventory.ConsumeFIFO(ctx, q, inventory.Outbound{
+		ItemID:       itemID,
+		WarehouseID:  warehouseID,
+		Quantity:     is.Quantity,
+		MovementType: "project_issue",
+		SourceType:   SourceType,
+		SourceID:     p.ID,
+		Reference:    is.Reference,
+		Date:         is.Date,
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := &IssueResult{ItemName: itemName, Quantity: res.Quantity, Cost: res.TotalCost}
+	if res.TotalCost == 0 {
+		return out, nil
+	}
+
+	debit, err := costAccount(ctx, q, p)
+	if err != nil {
+		return nil, err
+	}
+	if inventoryAccount == "" {
+		if inventoryAccount, err = cashflow.AccountByType(ctx, q, p.OrganizationID, "stock"); err != nil {
+			return nil, err
+		}
+	}
+	desc := fmt.Sprintf("%g x %s issued to %s", res.Quantity, itemName, p.Code)
+	j := &cashflow.Journal{
+		OrganizationID: p.OrganizationID,
+		Number:         journalPrefix + cashflow.NewID("issue"),
+		Date:           is.Date,
+		Reference:      is.Reference,
+		Notes:          fmt.Sprintf("Stock issued to project %s", p.Code),
+		Lines: []cashflow.JournalLine{
+			{AccountID: debit, Description: desc, Debit: res.TotalCost},
+			{AccountID: inventoryAccount, Description: desc, Credit: res.TotalCost},
+		},
+	}
+	if err := cashflow.PostJournal(ctx, q, j); err != nil {
+		return nil, err
+	}
+	movements := make([]string, len(res.Consumed))
+	for i, c := range res.Consumed {
+		movements[i] = c.MovementID
+	}
+	if err := inventory.LinkJournal(ctx, q, j.ID, movements); err != nil {
+		return nil, err
+	}
+	out.JournalID = j.ID
+	return out, tag(ctx, q, p, j.ID)
+}
+
+// TimeEntry is a time_entries row.
+type TimeEntry struct {
+	ID          string
+	ProjectID   string
+	Date        time.Time
+	Worker      string
+	Hours       float64
+	CostRate    money.Amount
+	BillRate    money.Amount
+	Description string
+	JournalID   string
+	InvoiceID   string
+}
+
+// Cost is the entry's cost at its cost rate.
+func (e *TimeEntry) Cost() money.Amount { return e.CostRate.Mul(e.Hours) }
+
+// Value is the entry's billable value at its bill rate.
+func (e *TimeEntry) Value() money.Amount { return e.BillRate.Mul(e.Hours) }
+
+// LogTime records hours worked on a project. A costed entry moves its cost
+// from creditAccountID (payroll expense or accrued wages) to the project's
+// cost account.
+func LogTime(ctx context.Context, q cashflow.Querier, p *Project, e *TimeEntry, creditAccountID string) error {
+	if err := checkOpen(p); err != nil {
+		return err
+	}
+	if e.Hours <= 0 || e.Worker == "" {
+		return errors.New("time entries need a worker and positive hours")
+	}
+	e.ID = cashflow.NewID("time")
+	e.ProjectID = p.ID
+
+	if cost := e.Cost(); cost > 0 {
+		if creditAccountID == "" {
+			return errors.New("a costed time entry needs an account to credit")
+		}
+		if _, err := cashflow.GetLedgerAccount(ctx, q, p.OrganizationID, creditAccountID); err != nil {
+			return err
+		}
+		debit, err := costAccount(ctx, q, p)
+		if err != nil {
+			return err
+		}
+		desc := fmt.Sprintf("%s, %g h on %s", e.Worker, e.Hours, p.Code)
+		j := &cashflow.Journal{
+			OrganizationID: p.OrganizationID,
+			Number:         journalPrefix + e.ID,
+			Date:           e.Date,
+			Notes:          fmt.Sprintf("Labor charged to project %s", p.Code),
+			Lines: []cashflow.JournalLine{
+				{AccountID: debit, Description: desc, Debit: cost},
+				{AccountID: creditAccountID, Description: desc, Credit: cost},
+			},
+		}
+		if err := cashflow.PostJournal(ctx, q, j); err != nil {
+			return err
+		}
+		if err := tag(ctx, q, p, j.ID); err != nil {
+			return err
+		}
+		e.JournalID = j.ID
+	}
+
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO time_entries (id, organization_id, project_id, work_date, worker, hours, cost_rate, bill_rate,
+		  description, journal_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, p.OrganizationID, p.ID, e.Date.Format(time.DateOnly), e.Worker, math.Round(e.Hours*100)/100,
+		e.CostRate, e.BillRate, cashflow.NullString(e.Description), cashflow.NullString(e.JournalID), time.Now())
+	return err
+}
+
+// TimeEntries returns a project's time entries by date. With unbilled set,
+// only entries not yet on an invoice are returned, locked for update.
+func TimeEntries(ctx context.Context, q cashflow.Querier, projectID string, unbilled bool) ([]*TimeEntry, error) {
+	query := `
+		SELECT id, project_id, work_date, worker, hours, cost_rate, bill_rate, COALESCE(description, ''),
+		       COALESCE(journal_id, ''), COALESCE(invoice_id, '')
+		FROM time_entries WHERE project_id = ?`
+	if unbilled {
+		query += ` AND invoice_id IS NULL ORDER BY work_date, id FOR UPDATE`
+	} else {
+		query += ` ORDER BY work_date, id`
+	}
+	rows, err := q.QueryContext(ctx, query, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*TimeEntry
+	for rows.Next() {
+		e := &TimeEntry{}
+		if err := rows.Scan(&e.ID, &e.ProjectID, &e.Date, &e.Worker, &e.Hours, &e.CostRate, &e.BillRate,
+			&e.Description, &e.JournalID, &e.InvoiceID); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// Billing describes an invoice raised for a project.
+type Billing struct {
+	Number           string // defaults to <code>-<n>
+	Date             time.Time
+	DueDate          time.Time // defaults to Date
+	Amount           money.Amount
+	Description      string // for Amount, e.g. "Progress claim 2"
+	IncludeTime      bool   // bill unbilled time entries at their bill rates
+	RevenueAccountID string
+	Currency         string // defaults to the organization's base currency
+}
+
+type billLine struct {
+	name, description string
+	quantity          float64
+	rate, amount      money.Amount
+}
+
+// Bill raises a confirmed invoice to the project's customer, posts it (DR
+// receivables, CR revenue), links it to the project and marks the billed
+// time entries. q must be a transaction.
+func Bill(ctx context.Context, q cashflow.Querier, p *Project, b Billing) (*cashflow.Invoice, error) {
+	if err := checkOpen(p); err != nil {
+		return nil, err
+	}
+	if p.CustomerID == "" {
+		return nil, fmt.Errorf("project %s has no customer", p.Code)
+	}
+
+	var lines []billLine
+	var entries []*TimeEntry
+	if b.IncludeTime {
+		var err error
+		if entries, err = TimeEntries(ctx, q, p.ID, true); err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Value() == 0 {
+				continue
+			}
+			desc := e.Date.Format(time.DateOnly)
+			if e.Description != "" {
+				desc += " " + e.Description
+			}
+			lines = append(lines, billLine{name: e.Worker, description: desc, quantity: e.Hours, rate: e.BillRate, amount: e.Value()})
+		}
+	}
+	if b.Amount != 0 {
+		name := b.Description
+		if name == "" {
+			name = p.Name
+		}
+		lines = append(lines, billLine{name: name, quantity: 1, rate: b.Amount, amount: b.Amount})
+	}
+	var total money.Amount
+	for _, l := range lines {
+		total += l.amount
+	}
+	if total <= 0 {
+		return nil, errors.New("nothing to bill: no unbilled time and no amount")
+	}
+
+	if b.Number == "" {
+		var n int
+		if err := q.QueryRowContext(ctx, `SELECT COUNT(*) FROM project_invoices WHERE project_id = ?`, p.ID).Scan(&n); err != nil {
+			return nil, err
+		}
+		b.Number = fmt.Sprintf("%s-%d", p.Code, n+1)
+	}
+	if b.DueDate.IsZero() {
+		b.DueDate = b.Date
+	}
+	if b.Currency == "" {
+		if err := q.QueryRowContext(ctx, `SELECT baseCurrency FROM organizations WHERE id = ?`, p.OrganizationID).Scan(&b.Currency); err != nil {
+			return nil, err
+		}
+	}
+	revenue := b.RevenueAccountID
+	if revenue == "" {
+		var err error
+		if revenue, err = cashflow.AccountByType(ctx, q, p.OrganizationID, "income"); err != nil {
+			return nil, err
+		}
+	}
+	receivable, err := cashflow.AccountByType(ctx, q, p.OrganizationID, "accounts_receivable")
+	if err != nil {
+		return nil, err
+	}
+
+	j := &cashflow.Journal{
+		OrganizationID: p.OrganizationID,
+		Number:         "J-" + b.Number,
+		Date:           b.Date,
+		Reference:      b.Number,
+		Notes:          fmt.Sprintf("Invoice %s for project %s", b.Number, p.Code),
+		Lines: []cashflow.JournalLine{
+			{AccountID: receivable, Description: "Invoice - " + b.Number, Debit: total},
+			{AccountID: revenue, Description: p.Code + " " + p.Name, Credit: total},
+		},
+	}
+	if err := cashflow.PostJournal(ctx, q, j); err != nil {
+		return nil, err
+	}
+
+	inv := &cashflow.Invoice{
+		ID:             cashflow.NewID("invoice"),
+		OrganizationID: p.OrganizationID,
+		InvoiceNumber:  b.Number,
+		CustomerID:     p.CustomerID,
+		Status:         "confirmed",
+		Currency:       b.Currency,
+		IssueDate:      b.Date,
+		TotalAmount:    total,
+		BalanceDue:     total,
+	}
+	now := time.Now()
+	_, err = q.ExecContext(ctx, `
+		INSERT INTO invoices (id, organizationId, invoiceNumber, customerId, issueDate, dueDate, status,
+		  subtotal, taxAmount, totalAmount, paidAmount, balanceDue, currency, subject, journalId, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 0, ?, 0, ?, ?, ?, ?, ?, ?)`,
+		inv.ID, inv.OrganizationID, inv.InvoiceNumber, inv.CustomerID, b.Date, b.DueDate, inv.Status,
+		total, total, total, inv.Currency, fmt.Sprintf("Project %s %s", p.Code, p.Name), j.ID, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("insert invoice %s: %w", b.Number, err)
+	}
+	for i, l := range lines {
+		_, err := q.ExecContext(ctx, `
+			INSERT INTO invoice_items (id, invoiceId, itemName, description, quantity, rate, amount, salesAccountId,
+			  createdAt, updatedAt)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			fmt.Sprintf("invoice_item_%d_%d", now.UnixMilli(), i), inv.ID, l.name, cashflow.NullString(l.description),
+			math.Round(l.quantity*100)/100, l.rate, l.amount, revenue, now, now)
+		if err != nil {
+			return nil, fmt.Errorf("insert invoice item %s: %w", l.name, err)
+		}
+	}
+	for _, e := range entries {
+		if _, err := q.ExecContext(ctx, `UPDATE time_entries SET invoice_id = ? WHERE id = ?`, inv.ID, e.ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := link(ctx, q, p, inv.ID); err != nil {
+		return nil, err
+	}
+	return inv, tag(ctx, q, p, j.ID)
+}
+
+// LinkInvoice attributes an invoice raised elsewhere (for example in the
+// BFF) to a project and tags its journal.
+func LinkInvoice(ctx context.Context, q cashflow.Querier, p *Project, invoiceID string) (*cashflow.Invoice, error) {
+	inv, err := cashflow.GetInvoice(ctx, q, p.OrganizationID, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	if p.CustomerID != "" && inv.CustomerID != p.CustomerID {
+		return nil, fmt.Errorf("invoice %s is for another customer than project %s", inv.InvoiceNumber, p.Code)
+	}
+	if err := link(ctx, q, p, inv.ID); err != nil {
+		return nil, err
+	}
+	var journalID sql.NullString
+	if err := q.QueryRowContext(ctx, `SELECT journalId FROM invoices WHERE id = ?`, inv.ID).Scan(&journalID); err != nil {
+		return nil, err
+	}
+	if journalID.Valid && p.Status == StatusOpen {
+		return inv, tag(ctx, q, p, journalID.String)
+	}
+	return inv, nil
+}
+
+func link(ctx context.Context, q cashflow.Querier, p *Project, invoiceID string) error {
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO project_invoices (invoice_id, project_id, linked_at) VALUES (?, ?, ?)`,
+		invoiceID, p.ID, time.Now())
+	if isDuplicate(err) {
+		var other string
+		q.QueryRowContext(ctx, `
+			SELECT p.code FROM project_invoices pi JOIN projects p ON p.id = pi.project_id
+			WHERE pi.invoice_id = ?`, invoiceID).Scan(&other)
+		return fmt.Errorf("invoice %s is already linked to project %s", invoiceID, other)
+	}
+	return err
+}
@@ -0,0 +1,283 @@
+// Package jobcost keeps the cost and revenue of customer projects (jobs).
+//
+// A project is also a value of the organization's "project" dimension, so
+// any posting tagged project=<code> counts towards it. On top of tagged
+// postings a project collects approved AP bills, stock issued to it
+// (inventory_movements with sourceType "project") and time entries, and it
+// bills revenue through invoices linked in project_invoices. Report compares
+// budget and actual per cost category and derives work in progress and
+// margin.
+package jobcost
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/dimensions"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Dimension is the dimension code projects are tagged under.
+const Dimension = "project"
+
+// SourceType marks inventory movements issued to a project.
+const SourceType = "project"
+
+// Project statuses.
+const (
+	StatusOpen   = "open"
+	StatusClosed = "closed"
+)
+
+// Cost categories.
+const (
+	CategoryLabor     = "labor"
+	CategoryMaterials = "materials"
+	CategoryBills     = "bills"
+	CategoryOther     = "other"
+)
+
+// Categories lists the cost categories in report order.
+var Categories = []string{CategoryLabor, CategoryMaterials, CategoryBills, CategoryOther}
+
+// Tables are the cashflowdb tables owned by job costing.
+var Tables = []schema.Table{
+	{
+		Name: "projects",
+		Create: `CREATE TABLE IF NOT EXISTS projects (
+  id VARCHAR(191) NOT NULL,
+  organization_id VARCHAR(191) NOT NULL,
+  code VARCHAR(191) NOT NULL,
+  name VARCHAR(191) NOT NULL,
+  customer_id VARCHAR(191) NULL,
+  status VARCHAR(20) NOT NULL,
+  contract_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+  cost_account_id VARCHAR(191) NULL,
+  created_at DATETIME(3) NOT NULL,
+  updated_at DATETIME(3) NOT NULL,
+  closed_at DATETIME(3) NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY projects_org_code_unique (organization_id, code)
+) ENGINE=InnoDB`,
+	},
+	{
+		Name: "project_budgets",
+		Create: `CREATE TABLE IF NOT EXISTS project_budgets (
+  project_id VARCHAR(191) NOT NULL,
+  category VARCHAR(20) NOT NULL,
+  amount DECIMAL(12,2) NOT NULL,
+  updated_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (project_id, category)
+) ENGINE=InnoDB`,
+	},
+	{
+		Name: "project_invoices",
+		Create: `CREATE TABLE IF NOT EXISTS project_invoices (
+  invoice_id VARCHAR(191) NOT NULL,
+  project_id VARCHAR(191) NOT NULL,
+  linked_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (invoice_id),
+  INDEX project_invoices_project_idx (project_id)
+) ENGINE=InnoDB`,
+	},
+	{
+		Name: "time_entries",
+		Create: `CREATE TABLE IF NOT EXISTS time_entries (
+  id VARCHAR(191) NOT NULL,
+  organization_id VARCHAR(191) NOT NULL,
+  project_id VARCHAR(191) NOT NULL,
+  work_date DATE NOT NULL,
+  worker VARCHAR(191) NOT NULL,
+  hours DECIMAL(8,2) NOT NULL,
+  cost_rate DECIMAL(12,2) NOT NULL,
+  bill_rate DECIMAL(12,2) NOT NULL,
+  description TEXT NULL,
+  journal_id VARCHAR(191) NULL,
+  invoice_id VARCHAR(191) NULL,
+  created_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (id),
+  INDEX time_entries_project_idx (project_id, work_date)
+) ENGINE=InnoDB`,
+	},
+}
+
+// Project is a projects row.
+type Project struct {
+	ID             string
+	OrganizationID string
+	Code           string
+	Name           string
+	CustomerID     string
+	Status         string
+	Contract       money.Amount // agreed revenue
+	CostAccountID  string       // where issued stock and labor are debited
+	CreatedAt      time.Time
+	ClosedAt       *time.Time
+}
+
+// Create adds a project and registers its code as a value of the project
+// dimension, defining the dimension on first use.
+func Create(ctx context.Context, q cashflow.Querier, p *Project) error {
+	if p.Code == "" || p.Name == "" {
+		return errors.New("project code and name are required")
+	}
+	if p.CostAccountID != "" {
+		if _, err := cashflow.GetLedgerAccount(ctx, q, p.OrganizationID, p.CostAccountID); err != nil {
+			return err
+		}
+	}
+	if _, err := dimensions.Get(ctx, q, p.OrganizationID, Dimension); errors.Is(err, cashflow.ErrNotFound) {
+		err = dimensions.Define(ctx, q, &dimensions.Dimension{
+			OrganizationID: p.OrganizationID, Code: Dimension, Name: "Project", Active: true,
+		})
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	p.ID = cashflow.NewID("project")
+	p.Status = StatusOpen
+	p.CreatedAt = now
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO projects (id, organization_id, code, name, customer_id, status, contract_amount,
+		  cost_account_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.ID, p.OrganizationID, p.Code, p.Name, cashflow.NullString(p.CustomerID), p.Status, p.Contract,
+		cashflow.NullString(p.CostAccountID), now, now)
+	if isDuplicate(err) {
+		return fmt.Errorf("project %s already exists", p.Code)
+	} else if err != nil {
+		return err
+	}
+	return dimensions.SetValue(ctx, q, p.OrganizationID, &dimensions.Value{
+		Dimension: Dimension, Code: p.Code, Name: p.Name, Active: true,
+	})
+}
+
+const projectColumns = `id, organization_id, code, name, COALESCE(customer_id, ''), status, contract_amount,
+	COALESCE(cost_account_id, ''), created_at, closed_at FROM projects`
+
+func scanProject(row interface{ Scan(...any) error }) (*Project, error) {
+	p := &Project{}
+	var closed sql.NullTime
+	err := row.Scan(&p.ID, &p.OrganizationID, &p.Code, &p.Name, &p.CustomerID, &p.Status, &p.Contract,
+		&p.CostAccountID, &p.CreatedAt, &closed)
+	if closed.Valid {
+		p.ClosedAt = &closed.Time
+	}
+	return p, err
+}
+
+// Get loads a project by id or code.
+func Get(ctx context.Context, q cashflow.Querier, organizationID, idOrCode string) (*Project, error) {
+	p, err := scanProject(q.QueryRowContext(ctx, `SELECT `+projectColumns+`
+		WHERE organization_id = ? AND (id = ? OR code = ?)`, organizationID, idOrCode, idOrCode))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("project %s: %w", idOrCode, cashflow.ErrNotFound)
+	}
+	return p, err
+}
+
+// List returns an organization's projects by code. An empty status lists
+// every status.
+func List(ctx context.Context, q cashflow.Querier, organizationID, status string) ([]*Project, error) {
+	rows, err := q.QueryContext(ctx, `SELECT `+projectColumns+`
+		WHERE organization_id = ? AND (? = '' OR status = ?) ORDER BY code`, organizationID, status, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Project
+	for rows.Next() {
+		p, err := scanProject(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// SetBudget sets the budgeted cost of one category.
+func SetBudget(ctx context.Context, q cashflow.Querier, p *Project, category string, amount money.Amount) error {
+	if !validCategory(category) {
+		return fmt.Errorf("unknown cost category %q; use one of %v", category, Categories)
+	}
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO project_budgets (project_id, category, amount, updated_at) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE amount = VALUES(amount), updated_at = VALUES(updated_at)`,
+		p.ID, category, amount, time.Now())
+	return err
+}
+
+// SetContract changes the agreed revenue.
+func SetContract(ctx context.Context, q cashflow.Querier, p *Project, amount money.Amount) error {
+	p.Contract = amount
+	_, err := q.ExecContext(ctx, `UPDATE projects SET contract_amount = ?, updated_at = ? WHERE id = ?`,
+		amount, time.Now(), p.ID)
+	return err
+}
+
+// Budgets returns the budgeted cost per category.
+func Budgets(ctx context.Context, q cashflow.Querier, projectID string) (map[string]money.Amount, error) {
+	rows, err := q.QueryContext(ctx, `SELECT category, amount FROM project_budgets WHERE project_id = ?`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]money.Amount{}
+	for rows.Next() {
+		var category string
+		var amount money.Amount
+		if err := rows.Scan(&category, &amount); err != nil {
+			return nil, err
+		}
+		out[category] = amount
+	}
+	return out, rows.Err()
+}
+
+// Close stops new postings to a project. Its dimension value is made
+// inactive so later journals cannot be tagged with it.
+func Close(ctx context.Context, q cashflow.Querier, p *Project) error {
+	if p.Status == StatusClosed {
+		return fmt.Errorf("project %s is already closed", p.Code)
+	}
+	now := time.Now()
+	p.Status = StatusClosed
+	p.ClosedAt = &now
+	if _, err := q.ExecContext(ctx, `
+		UPDATE projects SET status = ?, closed_at = ?, updated_at = ? WHERE id = ?`,
+		p.Status, now, now, p.ID); err != nil {
+		return err
+	}
+	return dimensions.SetValue(ctx, q, p.OrganizationID, &dimensions.Value{
+		Dimension: Dimension, Code: p.Code, Name: p.Name, Active: false,
+	})
+}
+
+func validCategory(c string) bool {
+	for _, known := range Categories {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+func isDuplicate(err error) bool {
+	var myErr *mysql.MySQLError
+	return errors.As(err, &myErr) && myErr.Number == 1062
+}
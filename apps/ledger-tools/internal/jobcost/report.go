@@ -0,0 +1,196 @@
+package jobcost
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/dimensions"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Summary is a project's position up to a date.
+type Summary struct {
+	Project      *Project
+	AsOf         time.Time // exclusive
+	Budget       map[string]money.Amount
+	Actual       map[string]money.Amount
+	Hours        float64
+	Billed       money.Amount // linked invoices, net of tax
+	Collected    money.Amount
+	UnbilledTime money.Amount // unbilled hours at bill rates
+}
+
+// BudgetCost is the total budgeted cost.
+func (s *Summary) BudgetCost() money.Amount { return sum(s.Budget) }
+
+// ActualCost is the total cost incurred.
+func (s *Summary) ActualCost() money.Amount { return sum(s.Actual) }
+
+// PercentComplete is actual over budgeted cost, capped at 100. It is not
+// known without a cost budget.
+func (s *Summary) PercentComplete() (float64, bool) {
+	budget := s.BudgetCost()
+	if budget <= 0 {
+		return 0, false
+	}
+	return min(100, 100*s.ActualCost().Float()/budget.Float()), true
+}
+
+// Earned is the revenue earned so far on the cost-to-cost method. Without a
+// cost budget or contract, revenue is earned as billed.
+func (s *Summary) Earned() money.Amount {
+	pct, ok := s.PercentComplete()
+	if !ok || s.Project.Contract == 0 {
+		return s.Billed
+	}
+	return s.Project.Contract.Mul(pct / 100)
+}
+
+// WIP is earned revenue not yet billed (underbilling); negative values are
+// billings in excess of revenue earned.
+func (s *Summary) WIP() money.Amount { return s.Earned() - s.Billed }
+
+// Margin is earned revenue less cost to date.
+func (s *Summary) Margin() money.Amount { return s.Earned() - s.ActualCost() }
+
+// ProjectedMargin is the contract less the budgeted cost.
+func (s *Summary) ProjectedMargin() money.Amount { return s.Project.Contract - s.BudgetCost() }
+
+func sum(m map[string]money.Amount) money.Amount {
+	var total money.Amount
+	for _, v := range m {
+		total += v
+	}
+	return total
+}
+
+// Summarize collects a project's costs and billings dated before asOf.
+// Tagged postings come from the cashflow ledger: entries on expense
+// accounts or the project's cost account, counted as bills when they belong
+// to a BILL- journal and as other costs otherwise.
+func Summarize(ctx context.Context, q cashflow.Querier, p *Project, asOf time.Time) (*Summary, error) {
+	s := &Summary{Project: p, AsOf: asOf, Actual: map[string]money.Amount{}}
+	var err error
+	if s.Budget, err = Budgets(ctx, q, p.ID); err != nil {
+		return nil, err
+	}
+
+	var labor money.Amount
+	err = q.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(ROUND(hours * cost_rate, 2)), 0), COALESCE(SUM(hours), 0),
+		       COALESCE(SUM(CASE WHEN invoice_id IS NULL THEN ROUND(hours * bill_rate, 2) ELSE 0 END), 0)
+		FROM time_entries WHERE project_id = ? AND work_date < ?`, p.ID, asOf).
+		Scan(&labor, &s.Hours, &s.UnbilledTime)
+	if err != nil {
+		return nil, fmt.Errorf("time entries: %w", err)
+	}
+	s.Actual[CategoryLabor] = labor
+
+	// Stock returned from the job comes back as an 'in' movement.
+	var materials money.Amount
+	err = q.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(CASE direction WHEN 'out' THEN totalValue ELSE -totalValue END), 0)
+		FROM inventory_movements WHERE sourceType = ? AND sourceId = ? AND createdAt < ?`,
+		SourceType, p.ID, asOf).Scan(&materials)
+	if err != nil {
+		return nil, fmt.Errorf("inventory movements: %w", err)
+	}
+	s.Actual[CategoryMaterials] = materials
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT CASE WHEN j.journalNumber LIKE 'BILL-%' THEN ? ELSE ? END, SUM(je.debitAmount - je.creditAmount)
+		FROM split_dimensions sd
+		JOIN journal_entries je ON je.id = sd.split_id
+		JOIN journals j ON j.id = je.journalId
+		JOIN ledger_accounts la ON la.id = je.accountId
+		WHERE sd.ledger = ? AND sd.organization_id = ? AND sd.dimension = ? AND sd.value = ?
+		  AND j.status IN `+cashflow.LiveJournalStatuses+` AND j.journalDate < ?
+		  AND j.journalNumber NOT LIKE '`+journalPrefix+`%'
+		  AND (la.type IN ('expense', 'cost_of_goods_sold', 'other_expense') OR la.id = ?)
+		GROUP BY 1`,
+		CategoryBills, CategoryOther, dimensions.LedgerCashflow, p.OrganizationID, Dimension, p.Code, asOf, p.CostAccountID)
+	if err != nil {
+		return nil, fmt.Errorf("tagged postings: %w", err)
+	}
+	for rows.Next() {
+		var category string
+		var amount money.Amount
+		if err := rows.Scan(&category, &amount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		s.Actual[category] = amount
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = q.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(i.totalAmount - i.taxAmount), 0), COALESCE(SUM(i.paidAmount), 0)
+		FROM project_invoices pi JOIN invoices i ON i.id = pi.invoice_id
+		WHERE pi.project_id = ? AND i.status NOT IN ('draft', 'void') AND i.issueDate < ?`, p.ID, asOf).
+		Scan(&s.Billed, &s.Collected)
+	if err != nil {
+		return nil, fmt.Errorf("invoices: %w", err)
+	}
+	return s, nil
+}
+
+// Print writes a project's budget against actual, WIP and margin.
+func (s *Summary) Print(w io.Writer) {
+	p := s.Project
+	fmt.Fprintf(w, "Project %s %s (%s), as of %s\n\n", p.Code, p.Name, p.Status, s.AsOf.AddDate(0, 0, -1).Format(time.DateOnly))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COST\tBUDGET\tACTUAL\tREMAINING\t")
+	for _, c := range Categories {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", c, s.Budget[c], s.Actual[c], s.Budget[c]-s.Actual[c])
+	}
+	fmt.Fprintf(tw, "Total\t%s\t%s\t%s\t\n", s.BudgetCost(), s.ActualCost(), s.BudgetCost()-s.ActualCost())
+	tw.Flush()
+
+	pct := "n/a (no cost budget)"
+	if v, ok := s.PercentComplete(); ok {
+		pct = fmt.Sprintf("%.1f%%", v)
+	}
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Hours logged\t%g\t\n", s.Hours)
+	fmt.Fprintf(tw, "Contract\t%s\t\n", p.Contract)
+	fmt.Fprintf(tw, "Complete\t%s\t\n", pct)
+	fmt.Fprintf(tw, "Revenue earned\t%s\t\n", s.Earned())
+	fmt.Fprintf(tw, "Billed\t%s\t\n", s.Billed)
+	fmt.Fprintf(tw, "Collected\t%s\t\n", s.Collected)
+	fmt.Fprintf(tw, "WIP (under/over billed)\t%s\t\n", s.WIP())
+	fmt.Fprintf(tw, "Unbilled time\t%s\t\n", s.UnbilledTime)
+	fmt.Fprintf(tw, "Margin to date\t%s\t%s\t\n", s.Margin(), percentOf(s.Margin(), s.Earned()))
+	fmt.Fprintf(tw, "Projected margin\t%s\t%s\t\n", s.ProjectedMargin(), percentOf(s.ProjectedMargin(), p.Contract))
+	tw.Flush()
+}
+
+// PrintSummaries writes one line per project.
+func PrintSummaries(w io.Writer, summaries []*Summary) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROJECT\tSTATUS\tBUDGET COST\tACTUAL COST\tCOMPLETE\tEARNED\tBILLED\tWIP\tMARGIN\t")
+	for _, s := range summaries {
+		pct := "-"
+		if v, ok := s.PercentComplete(); ok {
+			pct = fmt.Sprintf("%.1f%%", v)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", s.Project.Code, s.Project.Status,
+			s.BudgetCost(), s.ActualCost(), pct, s.Earned(), s.Billed, s.WIP(), s.Margin())
+	}
+	tw.Flush()
+}
+
+func percentOf(part, whole money.Amount) string {
+	if whole == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.1f%%", 100*part.Float()/whole.Float())
+}
@@ -0,0 +1,105 @@
+package jobcost
+
+import (
+	"math"
+	"testing"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+func TestSummary(t *testing.T) {
+	mm := money.MustParse
+	budget := map[string]money.Amount{CategoryLabor: mm("40000"), CategoryMaterials: mm("60000")}
+
+	tests := []struct {
+		name      string
+		contract  money.Amount
+		budget    map[string]money.Amount
+		actual    map[string]money.Amount
+		billed    money.Amount
+		pct       float64
+		known     bool
+		earned    money.Amount
+		wip       money.Amount
+		margin    money.Amount
+		projected money.Amount
+	}{
+		{
+			name: "underbilled", contract: mm("150000"), budget: budget,
+			actual: map[string]money.Amount{CategoryLabor: mm("10000"), CategoryMaterials: mm("20000")}, billed: mm("30000"),
+			pct: 30, known: true, earned: mm("45000"), wip: mm("15000"), margin: mm("15000"), projected: mm("50000"),
+		},
+		{
+			name: "overbilled", contract: mm("150000"), budget: budget,
+			actual: map[string]money.Amount{CategoryLabor: mm("5000")}, billed: mm("20000"),
+			pct: 5, known: true, earned: mm("7500"), wip: mm("-12500"), margin: mm("2500"), projected: mm("50000"),
+		},
+		{
+			name: "over budget is capped at complete", contract: mm("150000"), budget: budget,
+			actual: map[string]money.Amount{CategoryMaterials: mm("90000"), CategoryBills: mm("30000")}, billed: mm("150000"),
+			pct: 100, known: true, earned: mm("150000"), wip: 0, margin: mm("30000"), projected: mm("50000"),
+		},
+		{
+			name: "no budget earns as billed", contract: mm("150000"),
+			actual: map[string]money.Amount{CategoryOther: mm("1000")}, billed: mm("4000"),
+			earned: mm("4000"), wip: 0, margin: mm("3000"), projected: mm("150000"),
+		},
+		{
+			name: "no contract earns as billed", budget: budget,
+			actual: map[string]money.Amount{CategoryLabor: mm("10000")}, billed: mm("12000"),
+			pct: 10, known: true, earned: mm("12000"), wip: 0, margin: mm("2000"), projected: mm("-100000"),
+		},
+		{
+			name: "thirds round to the cent", contract: mm("100"), budget: map[string]money.Amount{CategoryLabor: mm("3")},
+			actual: map[string]money.Amount{CategoryLabor: mm("1")},
+			pct:    100.0 / 3, known: true, earned: mm("33.33"), wip: mm("33.33"), margin: mm("32.33"), projected: mm("97"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Summary{Project: &Project{Contract: tt.contract}, Budget: tt.budget, Actual: tt.actual, Billed: tt.billed}
+			pct, known := s.PercentComplete()
+			if known != tt.known || math.Abs(pct-tt.pct) > 1e-9 {
+				t.Errorf("PercentComplete = %v, %v; want %v, %v", pct, known, tt.pct, tt.known)
+			}
+			if got := s.Earned(); got != tt.earned {
+				t.Errorf("Earned = %s, want %s", got, tt.earned)
+			}
+			if got := s.WIP(); got != tt.wip {
+				t.Errorf("WIP = %s, want %s", got, tt.wip)
+			}
+			if got := s.Margin(); got != tt.margin {
+				t.Errorf("Margin = %s, want %s", got, tt.margin)
+			}
+			if got := s.ProjectedMargin(); got != tt.projected {
+				t.Errorf("ProjectedMargin = %s, want %s", got, tt.projected)
+			}
+		})
+	}
+}
+
+func TestTimeEntryValues(t *testing.T) {
+	e := &TimeEntry{Hours: 2.5, CostRate: money.MustParse("12.33"), BillRate: money.MustParse("40")}
+	if got := e.Cost(); got != money.MustParse("30.83") {
+		t.Errorf("Cost = %s, want 30.83", got)
+	}
+	if got := e.Value(); got != money.MustParse("100") {
+		t.Errorf("Value = %s, want 100.00", got)
+	}
+}
+
+func TestPercentOf(t *testing.T) {
+	tests := []struct {
+		part, whole money.Amount
+		want        string
+	}{
+		{part: money.MustParse("25"), whole: money.MustParse("200"), want: "12.5%"},
+		{part: money.MustParse("-10"), whole: money.MustParse("30"), want: "-33.3%"},
+		{part: money.MustParse("10"), whole: 0, want: ""},
+	}
+	for _, tt := range tests {
+		if got := percentOf(tt.part, tt.whole); got != tt.want {
+			t.Errorf("percentOf(%s, %s) = %q, want %q", tt.part, tt.whole, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Package ledgerlock installs MySQL triggers that enforce lock dates, closed
+// accounting periods and immutability of posted rows at the database level,
+// so direct SQL and buggy code paths cannot bypass the checks the BFF makes
+// in fiscal-year-service.js.
+//
+// OA `transaction`/`split` and cashflow `journal_entries`/
+// `inventory_movements` are covered. A row may only get past the triggers
+// through Bypass, which requires a reason and is written to
+// ledger_lock_bypass_log.
+package ledgerlock
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Targets are the connections objects are installed into. OA may be nil
+// when only the cashflow ledger is managed.
+type Targets struct {
+	OA             *sql.DB
+	Cashflow       *sql.DB
+	CashflowSchema string
+}
+
+func (t Targets) conn(d Database) *sql.DB {
+	if d == OA {
+		return t.OA
+	}
+	return t.Cashflow
+}
+
+func (t Targets) objects() []Object {
+	var out []Object
+	for _, o := range Objects(t.CashflowSchema) {
+		if t.conn(o.Database) != nil {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// Install creates the tables, functions and triggers, replacing older
+// definitions. It is safe to run repeatedly.
+func Install(ctx context.Context, t Targets) error {
+	if t.Cashflow == nil {
+		return errors.New("cashflow database is required: lock dates and the bypass log live there")
+	}
+	for _, o := range t.objects() {
+		conn := t.conn(o.Database)
+		if drop := o.Drop(); drop != "" {
+			if _, err := conn.ExecContext(ctx, drop); err != nil {
+				return fmt.Errorf("drop %s %s: %w", strings.ToLower(o.Kind), o.Name, err)
+			}
+		}
+		if _, err := conn.ExecContext(ctx, o.Create); err != nil {
+			return fmt.Errorf("create %s %s: %w", strings.ToLower(o.Kind), o.Name, err)
+		}
+	}
+	return nil
+}
+
+// Uninstall drops the triggers and functions. Lock dates and the bypass log
+// are kept.
+func Uninstall(ctx context.Context, t Targets) error {
+	objects := t.objects()
+	for i := len(objects) - 1; i >= 0; i-- {
+		o := objects[i]
+		drop := o.Drop()
+		if drop == "" {
+			continue
+		}
+		if _, err := t.conn(o.Database).ExecContext(ctx, drop); err != nil {
+			return fmt.Errorf("drop %s %s: %w", strings.ToLower(o.Kind), o.Name, err)
+		}
+	}
+	return nil
+}
+
+// ObjectStatus reports whether one managed object is present and current.
+type ObjectStatus struct {
+	Database Database
+	Kind     string
+	Name     string
+	Table    string
+	State    string // installed, stale, missing or unmanaged
+}
+
+// Status compares the installed triggers and functions with the expected
+// definitions.
+func Status(ctx context.Context, t Targets) ([]ObjectStatus, error) {
+	var report []ObjectStatus
+	for _, d := range []Database{Cashflow, OA} {
+		conn := t.conn(d)
+		if conn == nil {
+			continue
+		}
+
+		installed, err := installedBodies(ctx, conn)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", d, err)
+		}
+
+		for _, o := range t.objects() {
+			if o.Database != d || o.Kind == "TABLE" {
+				continue
+			}
+			state := "missing"
+			if body, ok := installed[o.Name]; ok {
+				state = "installed"
+				if normalize(body) != normalize(bodyOf(o.Create)) {
+					state = "stale"
+				}
+				delete(installed, o.Name)
+			}
+			report = append(report, ObjectStatus{Database: d, Kind: o.Kind, Name: o.Name, Table: o.Table, State: state})
+		}
+
+		for name := range installed {
+			report = append(report, ObjectStatus{Database: d, Name: name, State: "unmanaged"})
+		}
+	}
+	return report, nil
+}
+
+func installedBodies(ctx context.Context, conn *sql.DB) (map[string]string, error) {
+	bodies := map[string]string{}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT TRIGGER_NAME, ACTION_STATEMENT FROM information_schema.TRIGGERS
+		WHERE TRIGGER_SCHEMA = DATABASE() AND TRIGGER_NAME LIKE 'ledger\_lock\_%'
+		UNION ALL
+		SELECT ROUTINE_NAME, ROUTINE_DEFINITION FROM information_schema.ROUTINES
+		WHERE ROUTINE_SCHEMA = DATABASE() AND ROUTINE_NAME LIKE 'ledger\_lock\_%'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var body sql.NullString
+		if err := rows.Scan(&name, &body); err != nil {
+			return nil, err
+		}
+		bodies[name] = body.String
+	}
+	return bodies, rows.Err()
+}
+
+var (
+	bodyStart  = regexp.MustCompile(`(?is)^.*?\b(FOR EACH ROW|READS SQL DATA)\s*`)
+	whitespace = regexp.MustCompile(`\s+`)
+)
+
+// bodyOf strips the CREATE header so a definition can be compared with what
+// information_schema reports.
+func bodyOf(create string) string {
+	return bodyStart.ReplaceAllString(create, "")
+}
+
+func normalize(s string) string {
+	return strings.TrimSpace(whitespace.ReplaceAllString(s, " "))
+}
+
+// LockDate is an organization's configured lock date.
+type LockDate struct {
+	OrganizationID string
+	LockDate       time.Time
+	UpdatedAt      time.Time
+	UpdatedBy      string
+}
+
+// SetLockDate sets or moves an organization's lock date. Entries dated
+// before it can no longer be inserted, changed or deleted.
+func SetLockDate(ctx context.Context, cashflow *sql.DB, organizationID string, date time.Time, by string) error {
+	_, err := cashflow.ExecContext(ctx, `
+		INSERT INTO ledger_lock_dates (organization_id, lock_date, updated_at, updated_by)
+		VALUES (?, ?, NOW(3), ?)
+		ON DUPLICATE KEY UPDATE lock_date = VALUES(lock_date), updated_at = VALUES(updated_at), updated_by = VALUES(updated_by)`,
+		organizationID, date.Format("2006-01-02"), nullString(by))
+	return err
+}
+
+// ClearLockDate removes an organization's lock date.
+func ClearLockDate(ctx context.Context, cashflow *sql.DB, organizationID string) error {
+	_, err := cashflow.ExecContext(ctx, `DELETE FROM ledger_lock_dates WHERE organization_id = ?`, organizationID)
+	return err
+}
+
+// LockDates lists every configured lock date.
+func LockDates(ctx context.Context, cashflow *sql.DB) ([]LockDate, error) {
+	rows, err := cashflow.QueryContext(ctx, `
+		SELECT organization_id, lock_date, updated_at, COALESCE(updated_by, '')
+		FROM ledger_lock_dates ORDER BY organization_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []LockDate
+	for rows.Next() {
+		var ld LockDate
+		if err := rows.Scan(&ld.OrganizationID, &ld.LockDate, &ld.UpdatedAt, &ld.UpdatedBy); err != nil {
+			return nil, err
+		}
+		out = append(out, ld)
+	}
+	return out, rows.Err()
+}
+
+// Bypass runs fn in a transaction on a dedicated connection with the bypass
+// variable set to reason, so a reviewed reversal can touch locked or posted
+// rows. Every row affected is recorded in ledger_lock_bypass_log.
+func Bypass(ctx context.Context, conn *sql.DB, reason string, fn func(*sql.Tx) error) (err error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return errors.New("a reason is required to bypass the ledger lock")
+	}
+
+	c, err := conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if _, err := c.ExecContext(ctx, "SET "+bypassVar+" = ?", reason); err != nil {
+		return err
+	}
+	defer func() {
+		// Reset before the connection returns to the pool; if that fails the
+		// connection must not be reused.
+		if _, resetErr := c.ExecContext(context.Background(), "SET "+bypassVar+" = NULL"); resetErr != nil {
+			c.Raw(func(any) error { return driver.ErrBadConn })
+			if err == nil {
+				err = resetErr
+			}
+		}
+	}()
+
+	tx, err := c.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,292 @@
+package ledgerlock
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+)
+
+// Database identifies which schema an object is installed into.
+type Database string
+
+const (
+	OA       Database = "oa"
+	Cashflow Database = "cashflow"
+)
+
+// Object is a table, function or trigger managed by this package.
+type Object struct {
+	Database Database
+	Kind     string // TABLE, FUNCTION or TRIGGER
+	Name     string
+	Table    string // trigger target table
+	Create   string
+}
+
+// Drop returns the statement that removes the object. Tables are never
+// dropped because they hold lock dates and the bypass log.
+func (o Object) Drop() string {
+	switch o.Kind {
+	case "FUNCTION":
+		return "DROP FUNCTION IF EXISTS " + o.Name
+	case "TRIGGER":
+		return "DROP TRIGGER IF EXISTS " + o.Name
+	}
+	return ""
+}
+
+// immutableStatuses are the journal statuses whose entries may not change:
+// the live statuses, which the BFF writes as 'active' and the ledger tools
+// as 'posted', and the statuses a live journal may move on to. A journal
+// never leaves this set, so it cannot be made editable again.
+var immutableStatuses = strings.TrimSuffix(cashflow.LiveJournalStatuses, ")") + ", 'reversed', 'void')"
+
+// bypassVar is the session variable that lets a reviewed reversal through.
+// It must hold a non-empty reason; every row it lets through is logged.
+const bypassVar = "@ledger_lock_bypass"
+
+// Objects returns every object to install. cashflowSchema qualifies the
+// cashflow tables the OA triggers read; pass "" when both ledgers share one
+// schema.
+func Objects(cashflowSchema string) []Object {
+	cf := ""
+	if cashflowSchema != "" {
+		cf = "`" + cashflowSchema + "`."
+	}
+
+	objects := []Object{
+		{
+			Database: Cashflow,
+			Kind:     "TABLE",
+			Name:     "ledger_lock_dates",
+			Create: `CREATE TABLE IF NOT EXISTS ledger_lock_dates (
+  organization_id VARCHAR(191) NOT NULL,
+  lock_date DATE NOT NULL,
+  updated_at DATETIME(3) NOT NULL,
+  updated_by VARCHAR(191) NULL,
+  PRIMARY KEY (organization_id)
+) ENGINE=InnoDB`,
+		},
+		{
+			Database: Cashflow,
+			Kind:     "TABLE",
+			Name:     "ledger_lock_bypass_log",
+			Create: `CREATE TABLE IF NOT EXISTS ledger_lock_bypass_log (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  table_name VARCHAR(64) NOT NULL,
+  operation VARCHAR(10) NOT NULL,
+  row_ref VARCHAR(191) NOT NULL,
+  reason VARCHAR(500) NOT NULL,
+  db_user VARCHAR(191) NOT NULL,
+  created_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (id),
+  INDEX ledger_lock_bypass_log_created_at_idx (created_at)
+) ENGINE=InnoDB`,
+		},
+		{
+			Database: Cashflow,
+			Kind:     "FUNCTION",
+			Name:     "ledger_lock_check",
+			Create: `CREATE FUNCTION ledger_lock_check(p_org VARCHAR(191), p_date DATE) RETURNS VARCHAR(255)
+READS SQL DATA
+BEGIN
+  DECLARE v_lock DATE DEFAULT NULL;
+  DECLARE v_period VARCHAR(255) DEFAULT NULL;
+  DECLARE CONTINUE HANDLER FOR NOT FOUND BEGIN END;
+
+  IF p_org IS NULL OR p_date IS NULL THEN
+    RETURN NULL;
+  END IF;
+
+  SELECT lock_date INTO v_lock FROM ledger_lock_dates WHERE organization_id = p_org;
+  IF v_lock IS NOT NULL AND p_date < v_lock THEN
+    RETURN CONCAT('Ledger is locked before ', DATE_FORMAT(v_lock, '%Y-%m-%d'), '; cannot change entries dated ', DATE_FORMAT(p_date, '%Y-%m-%d'));
+  END IF;
+
+  SELECT period_name INTO v_period FROM accounting_periods
+   WHERE organization_id = p_org
+     AND p_date BETWEEN start_date AND end_date
+     AND status IN ('closed', 'soft_closed')
+   LIMIT 1;
+  IF v_period IS NOT NULL THEN
+    RETURN CONCAT('Cannot post to closed period ', v_period);
+  END IF;
+
+  RETURN NULL;
+END`,
+		},
+		{
+			Database: OA,
+			Kind:     "FUNCTION",
+			Name:     "ledger_lock_oa_check",
+			Create: fmt.Sprintf(`CREATE FUNCTION ledger_lock_oa_check(p_org BINARY(16), p_date BIGINT UNSIGNED) RETURNS VARCHAR(255)
+READS SQL DATA
+BEGIN
+  DECLARE v_org VARCHAR(191) DEFAULT NULL;
+  DECLARE CONTINUE HANDLER FOR NOT FOUND BEGIN END;
+
+  SELECT id INTO v_org FROM %[1]sorganizations WHERE oaOrganizationId = LOWER(HEX(p_org)) LIMIT 1;
+  IF v_org IS NULL THEN
+    RETURN NULL;
+  END IF;
+
+  RETURN %[1]sledger_lock_check(v_org, DATE(FROM_UNIXTIME(p_date / 1000)));
+END`, cf),
+		},
+	}
+
+	objects = append(objects, oaTriggers(cf)...)
+	objects = append(objects, cashflowTriggers()...)
+	return objects
+}
+
+// Names returns the names of objects of the given kind, in install order.
+func Names(objects []Object, kind string) []string {
+	var names []string
+	for _, o := range objects {
+		if o.Kind == kind {
+			names = append(names, o.Name)
+		}
+	}
+	return names
+}
+
+func oaTriggers(cf string) []Object {
+	txLookup := `SELECT orgId INTO v_org FROM transaction WHERE id = %s.transactionId;`
+
+	return []Object{
+		trigger(OA, "transaction", "INSERT", "NEW.id", cf,
+			`SET v_msg = ledger_lock_oa_check(NEW.orgId, NEW.date);`),
+		trigger(OA, "transaction", "UPDATE", "OLD.id", cf,
+			`IF NOT (NEW.orgId <=> OLD.orgId AND NEW.userId <=> OLD.userId AND NEW.date <=> OLD.date
+      AND NEW.description <=> OLD.description AND NEW.data <=> OLD.data AND NEW.inserted <=> OLD.inserted) THEN
+    SET v_msg = 'Posted transactions are immutable; record a reversal instead';
+  ELSE
+    SET v_msg = ledger_lock_oa_check(OLD.orgId, OLD.date);
+  END IF;`),
+		trigger(OA, "transaction", "DELETE", "OLD.id", cf,
+			`SET v_msg = ledger_lock_oa_check(OLD.orgId, OLD.date);`),
+		trigger(OA, "split", "INSERT", "NEW.id", cf,
+			fmt.Sprintf(txLookup, "NEW")+`
+  SET v_msg = ledger_lock_oa_check(v_org, NEW.date);`),
+		trigger(OA, "split", "UPDATE", "OLD.id", cf,
+			fmt.Sprintf(txLookup, "OLD")+`
+  IF NOT (NEW.transactionId <=> OLD.transactionId AND NEW.accountId <=> OLD.accountId AND NEW.date <=> OLD.date
+      AND NEW.amount <=> OLD.amount AND NEW.nativeAmount <=> OLD.nativeAmount AND NEW.inserted <=> OLD.inserted) THEN
+    SET v_msg = 'Posted splits are immutable; record a reversal instead';
+  ELSE
+    SET v_msg = ledger_lock_oa_check(v_org, OLD.date);
+  END IF;`),
+		trigger(OA, "split", "DELETE", "OLD.id", cf,
+			fmt.Sprintf(txLookup, "OLD")+`
+  SET v_msg = ledger_lock_oa_check(v_org, OLD.date);`),
+	}
+}
+
+func cashflowTriggers() []Object {
+	journalLookup := `SELECT organizationId, DATE(journalDate), status INTO v_org, v_date, v_status FROM journals WHERE id = %s.journalId;`
+	warehouseLookup := `SELECT organizationId INTO v_org FROM warehouses WHERE id = %s.warehouseId;`
+
+	return []Object{
+		trigger(Cashflow, "journals", "UPDATE", "OLD.id", "",
+			`IF OLD.status IN `+immutableStatuses+` AND NOT (NEW.organizationId <=> OLD.organizationId
+      AND NEW.journalNumber <=> OLD.journalNumber AND NEW.journalDate <=> OLD.journalDate
+      AND NEW.status IN `+immutableStatuses+`) THEN
+    SET v_msg = 'Posted journals are immutable; record a reversal instead';
+  ELSE
+    SET v_msg = ledger_lock_check(OLD.organizationId, DATE(OLD.journalDate));
+    IF v_msg IS NULL THEN
+      SET v_msg = ledger_lock_check(NEW.organizationId, DATE(NEW.journalDate));
+    END IF;
+  END IF;`),
+		trigger(Cashflow, "journals", "DELETE", "OLD.id", "",
+			`IF OLD.status IN `+immutableStatuses+` THEN
+    SET v_msg = 'Posted journals are immutable; record a reversal instead';
+  ELSE
+    SET v_msg = ledger_lock_check(OLD.organizationId, DATE(OLD.journalDate));
+  END IF;`),
+		trigger(Cashflow, "journal_entries", "INSERT", "NEW.id", "",
+			fmt.Sprintf(journalLookup, "NEW")+`
+  SET v_msg = ledger_lock_check(v_org, v_date);`),
+		trigger(Cashflow, "journal_entries", "UPDATE", "OLD.id", "",
+			fmt.Sprintf(journalLookup, "OLD")+`
+  IF v_status IN `+immutableStatuses+` AND NOT (NEW.journalId <=> OLD.journalId AND NEW.accountId <=> OLD.accountId
+      AND NEW.debitAmount <=> OLD.debitAmount AND NEW.creditAmount <=> OLD.creditAmount) THEN
+    SET v_msg = 'Entries of posted journals are immutable; record a reversal instead';
+  ELSE
+    SET v_msg = ledger_lock_check(v_org, v_date);
+    IF v_msg IS NULL AND NOT (NEW.journalId <=> OLD.journalId) THEN
+      `+fmt.Sprintf(journalLookup, "NEW")+`
+      IF v_status IN `+immutableStatuses+` THEN
+        SET v_msg = 'Entries of posted journals are immutable; record a reversal instead';
+      ELSE
+        SET v_msg = ledger_lock_check(v_org, v_date);
+      END IF;
+    END IF;
+  END IF;`),
+		trigger(Cashflow, "journal_entries", "DELETE", "OLD.id", "",
+			fmt.Sprintf(journalLookup, "OLD")+`
+  IF v_status IN `+immutableStatuses+` THEN
+    SET v_msg = 'Entries of posted journals are immutable; record a reversal instead';
+  ELSE
+    SET v_msg = ledger_lock_check(v_org, v_date);
+  END IF;`),
+		trigger(Cashflow, "inventory_movements", "INSERT", "NEW.id", "",
+			fmt.Sprintf(warehouseLookup, "NEW")+`
+  SET v_msg = ledger_lock_check(v_org, DATE(NEW.createdAt));`),
+		trigger(Cashflow, "inventory_movements", "UPDATE", "OLD.id", "",
+			fmt.Sprintf(warehouseLookup, "OLD")+`
+  IF OLD.journalId IS NOT NULL AND NOT (NEW.itemId <=> OLD.itemId AND NEW.warehouseId <=> OLD.warehouseId
+      AND NEW.layerId <=> OLD.layerId AND NEW.direction <=> OLD.direction AND NEW.quantity <=> OLD.quantity
+      AND NEW.unitCost <=> OLD.unitCost AND NEW.totalValue <=> OLD.totalValue AND NEW.journalId <=> OLD.journalId
+      AND NEW.createdAt <=> OLD.createdAt) THEN
+    SET v_msg = 'Posted inventory movements are immutable; record a reversal instead';
+  ELSE
+    SET v_msg = ledger_lock_check(v_org, DATE(OLD.createdAt));
+  END IF;`),
+		trigger(Cashflow, "inventory_movements", "DELETE", "OLD.id", "",
+			fmt.Sprintf(warehouseLookup, "OLD")+`
+  SET v_msg = ledger_lock_check(v_org, DATE(OLD.createdAt));`),
+	}
+}
+
+// trigger wraps a check in the common BEFORE trigger shell: when the bypass
+// variable carries a reason the row is logged and let through, otherwise a
+// non-NULL v_msg aborts the statement.
+func trigger(database Database, table, event, rowRef, cf, check string) Object {
+	name := fmt.Sprintf("ledger_lock_%s_%s", table, map[string]string{
+		"INSERT": "bi",
+		"UPDATE": "bu",
+		"DELETE": "bd",
+	}[event])
+
+	body := fmt.Sprintf(`CREATE TRIGGER %[1]s BEFORE %[2]s ON `+"`%[3]s`"+` FOR EACH ROW
+BEGIN
+  DECLARE v_org VARCHAR(191) DEFAULT NULL;
+  DECLARE v_date DATE DEFAULT NULL;
+  DECLARE v_status VARCHAR(191) DEFAULT NULL;
+  DECLARE v_msg VARCHAR(255) DEFAULT NULL;
+  DECLARE CONTINUE HANDLER FOR NOT FOUND BEGIN END;
+
+  IF COALESCE(%[4]s, '') <> '' THEN
+    INSERT INTO %[5]sledger_lock_bypass_log (table_name, operation, row_ref, reason, db_user, created_at)
+    VALUES ('%[3]s', '%[2]s', CAST(%[6]s AS CHAR), LEFT(%[4]s, 500), CURRENT_USER(), NOW(3));
+  ELSE
+  %[7]s
+    IF v_msg IS NOT NULL THEN
+      SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = v_msg;
+    END IF;
+  END IF;
+END`, name, event, table, bypassVar, cf, rowRefExpr(table, rowRef), check)
+
+	return Object{Database: database, Kind: "TRIGGER", Name: name, Table: table, Create: body}
+}
+
+// rowRefExpr renders OA BINARY(16) ids as hex so the bypass log is readable.
+func rowRefExpr(table, ref string) string {
+	if table == "transaction" {
+		return "LOWER(HEX(" + ref + "))"
+	}
+	return ref
+}
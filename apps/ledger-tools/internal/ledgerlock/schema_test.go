@@ -0,0 +1,226 @@
+package ledgerlock
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func find(objects []Object, name string) *Object {
+	for i := range objects {
+		if objects[i].Name == name {
+			return &objects[i]
+		}
+	}
+	return nil
+}
+
+func TestObjects(t *testing.T) {
+	objects := Objects("")
+
+	if got, want := Names(objects, "TABLE"), []string{"ledger_lock_dates", "ledger_lock_bypass_log"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tables = %v, want %v", got, want)
+	}
+	if got, want := Names(objects, "FUNCTION"), []string{"ledger_lock_check", "ledger_lock_oa_check"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("functions = %v, want %v", got, want)
+	}
+	// Tables and functions must exist before the triggers that use them.
+	seenTrigger := false
+	for _, o := range objects {
+		if o.Kind == "TRIGGER" {
+			seenTrigger = true
+		} else if seenTrigger {
+			t.Errorf("%s %s is installed after a trigger", o.Kind, o.Name)
+		}
+	}
+	seen := map[string]bool{}
+	for _, o := range objects {
+		if seen[o.Name] {
+			t.Errorf("duplicate object %s", o.Name)
+		}
+		seen[o.Name] = true
+	}
+
+	tests := []struct {
+		name     string
+		database Database
+		table    string
+		create   []string
+	}{
+		{name: "ledger_lock_transaction_bi", database: OA, table: "transaction", create: []string{
+			"CREATE TRIGGER ledger_lock_transaction_bi BEFORE INSERT ON `transaction` FOR EACH ROW",
+			"VALUES ('transaction', 'INSERT', CAST(LOWER(HEX(NEW.id)) AS CHAR)",
+			"ledger_lock_oa_check(NEW.orgId, NEW.date)",
+		}},
+		{name: "ledger_lock_transaction_bu", database: OA, table: "transaction", create: []string{
+			"BEFORE UPDATE ON `transaction`",
+			"CAST(LOWER(HEX(OLD.id)) AS CHAR)",
+			"'Posted transactions are immutable; record a reversal instead'",
+		}},
+		{name: "ledger_lock_transaction_bd", database: OA, table: "transaction", create: []string{
+			"BEFORE DELETE ON `transaction`",
+			"ledger_lock_oa_check(OLD.orgId, OLD.date)",
+		}},
+		{name: "ledger_lock_split_bi", database: OA, table: "split", create: []string{
+			"BEFORE INSERT ON `split`",
+			"CAST(NEW.id AS CHAR)",
+			"FROM transaction WHERE id = NEW.transactionId",
+		}},
+		{name: "ledger_lock_split_bu", database: OA, table: "split", create: []string{
+			"FROM transaction WHERE id = OLD.transactionId",
+			"'Posted splits are immutable; record a reversal instead'",
+		}},
+		{name: "ledger_lock_split_bd", database: OA, table: "split", create: []string{
+			"BEFORE DELETE ON `split`",
+			"ledger_lock_oa_check(v_org, OLD.date)",
+		}},
+		{name: "ledger_lock_journals_bu", database: Cashflow, table: "journals", create: []string{
+			"BEFORE UPDATE ON `journals`",
+			"IF OLD.status IN " + immutableStatuses + " AND NOT (",
+			"AND NEW.status IN " + immutableStatuses + ") THEN",
+			"ledger_lock_check(NEW.organizationId, DATE(NEW.journalDate))",
+			"'Posted journals are immutable; record a reversal instead'",
+		}},
+		{name: "ledger_lock_journals_bd", database: Cashflow, table: "journals", create: []string{
+			"BEFORE DELETE ON `journals`",
+			"IF OLD.status IN " + immutableStatuses + " THEN",
+			"ledger_lock_check(OLD.organizationId, DATE(OLD.journalDate))",
+		}},
+		{name: "ledger_lock_journal_entries_bi", database: Cashflow, table: "journal_entries", create: []string{
+			"BEFORE INSERT ON `journal_entries`",
+			"FROM journals WHERE id = NEW.journalId",
+			"ledger_lock_check(v_org, v_date)",
+		}},
+		{name: "ledger_lock_journal_entries_bu", database: Cashflow, table: "journal_entries", create: []string{
+			"FROM journals WHERE id = OLD.journalId",
+			"FROM journals WHERE id = NEW.journalId",
+			"'Entries of posted journals are immutable; record a reversal instead'",
+		}},
+		{name: "ledger_lock_journal_entries_bd", database: Cashflow, table: "journal_entries", create: []string{
+			"BEFORE DELETE ON `journal_entries`",
+			"IF v_status IN " + immutableStatuses + " THEN",
+		}},
+		{name: "ledger_lock_inventory_movements_bi", database: Cashflow, table: "inventory_movements", create: []string{
+			"FROM warehouses WHERE id = NEW.warehouseId",
+			"ledger_lock_check(v_org, DATE(NEW.createdAt))",
+		}},
+		{name: "ledger_lock_inventory_movements_bu", database: Cashflow, table: "inventory_movements", create: []string{
+			"OLD.journalId IS NOT NULL",
+			"'Posted inventory movements are immutable; record a reversal instead'",
+		}},
+		{name: "ledger_lock_inventory_movements_bd", database: Cashflow, table: "inventory_movements", create: []string{
+			"ledger_lock_check(v_org, DATE(OLD.createdAt))",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := find(objects, tt.name)
+			if o == nil {
+				t.Fatalf("no trigger %s", tt.name)
+			}
+			if o.Kind != "TRIGGER" || o.Database != tt.database || o.Table != tt.table {
+				t.Errorf("%s is a %s on %s.%s, want a TRIGGER on %s.%s", o.Name, o.Kind, o.Database, o.Table, tt.database, tt.table)
+			}
+			for _, want := range append([]string{
+				"IF COALESCE(@ledger_lock_bypass, '') <> '' THEN",
+				"INSERT INTO ledger_lock_bypass_log (",
+				"LEFT(@ledger_lock_bypass, 500)",
+				"SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = v_msg;",
+			}, tt.create...) {
+				if !strings.Contains(o.Create, want) {
+					t.Errorf("%s does not contain %q:\n%s", o.Name, want, o.Create)
+				}
+			}
+			if got, want := o.Drop(), "DROP TRIGGER IF EXISTS "+tt.name; got != want {
+				t.Errorf("Drop = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestObjectsCashflowSchema(t *testing.T) {
+	for _, o := range Objects("cashflow_db") {
+		qualified := strings.Contains(o.Create, "`cashflow_db`.")
+		switch {
+		case o.Database == OA && !qualified:
+			t.Errorf("OA %s %s does not qualify the cashflow schema", o.Kind, o.Name)
+		case o.Database == Cashflow && qualified:
+			t.Errorf("cashflow %s %s qualifies its own schema", o.Kind, o.Name)
+		}
+	}
+
+	o := find(Objects("cashflow_db"), "ledger_lock_oa_check")
+	for _, want := range []string{
+		"FROM `cashflow_db`.organizations WHERE oaOrganizationId = LOWER(HEX(p_org))",
+		"RETURN `cashflow_db`.ledger_lock_check(v_org,",
+	} {
+		if !strings.Contains(o.Create, want) {
+			t.Errorf("ledger_lock_oa_check does not contain %q", want)
+		}
+	}
+	if o := find(Objects("cashflow_db"), "ledger_lock_split_bd"); !strings.Contains(o.Create, "INSERT INTO `cashflow_db`.ledger_lock_bypass_log") {
+		t.Errorf("ledger_lock_split_bd does not log bypasses to the cashflow schema")
+	}
+}
+
+func TestImmutableStatuses(t *testing.T) {
+	want := "('active', 'posted', 'reversed', 'void')"
+	if immutableStatuses != want {
+		t.Errorf("immutableStatuses = %s, want %s", immutableStatuses, want)
+	}
+	for _, o := range Objects("") {
+		if o.Kind == "TRIGGER" && strings.Contains(o.Create, "v_status = 'posted'") {
+			t.Errorf("%s only guards 'posted' journals", o.Name)
+		}
+	}
+}
+
+func TestDrop(t *testing.T) {
+	tests := []struct {
+		object Object
+		want   string
+	}{
+		{object: Object{Kind: "TABLE", Name: "ledger_lock_dates"}, want: ""},
+		{object: Object{Kind: "FUNCTION", Name: "ledger_lock_check"}, want: "DROP FUNCTION IF EXISTS ledger_lock_check"},
+		{object: Object{Kind: "TRIGGER", Name: "ledger_lock_split_bi"}, want: "DROP TRIGGER IF EXISTS ledger_lock_split_bi"},
+	}
+	for _, tt := range tests {
+		if got := tt.object.Drop(); got != tt.want {
+			t.Errorf("Drop(%s %s) = %q, want %q", tt.object.Kind, tt.object.Name, got, tt.want)
+		}
+	}
+}
+
+func TestBodyOf(t *testing.T) {
+	tests := []struct {
+		name   string
+		create string
+		want   string
+	}{
+		{
+			name:   "trigger",
+			create: "CREATE TRIGGER t BEFORE INSERT ON `x` FOR EACH ROW\nBEGIN\n  SET @a = 1;\nEND",
+			want:   "BEGIN SET @a = 1; END",
+		},
+		{
+			name:   "function",
+			create: "CREATE FUNCTION f(p INT) RETURNS INT\nreads sql data\nBEGIN\n  RETURN p;\nEND",
+			want:   "BEGIN RETURN p; END",
+		},
+		{name: "no header", create: "BEGIN\tEND  ", want: "BEGIN END"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalize(bodyOf(tt.create)); got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Every generated object must compare equal to itself once installed.
+	for _, o := range Objects("") {
+		if o.Kind != "TABLE" && !strings.HasPrefix(normalize(bodyOf(o.Create)), "BEGIN ") {
+			t.Errorf("%s: body does not start with BEGIN", o.Name)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Package masterdata loads customers, vendors and products from spreadsheets
+// into cashflowdb, and applies product price sheets.
+//
+// Master data files are CSVs whose first column, entity, says what each row
+// is (customer, vendor or product). Rows update the existing record with the
+// same SKU, email or name, and insert one otherwise. Blank cells leave the
+// stored value alone.
+package masterdata
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Entities.
+const (
+	EntityCustomer = "customer"
+	EntityVendor   = "vendor"
+	EntityProduct  = "product"
+)
+
+// Record is one master data row, keyed by lower-cased column name.
+type Record struct {
+	Line   int
+	Entity string
+	Fields map[string]string
+}
+
+// Result summarizes a load.
+type Result struct {
+	Inserted map[string]int `json:"inserted"`
+	Updated  map[string]int `json:"updated"`
+}
+
+// DetectRecords reports whether a CSV header is a master data file.
+func DetectRecords(head []byte) bool {
+	cols := headerColumns(head)
+	return len(cols) > 1 && cols[0] == "entity"
+}
+
+// ParseRecords reads a master data CSV.
+func ParseRecords(r io.Reader) ([]Record, error) {
+	var out []Record
+	err := readCSV(r, func(line int, row map[string]string) error {
+		entity := strings.ToLower(row["entity"])
+		switch entity {
+		case EntityCustomer, EntityVendor, EntityProduct:
+		default:
+			return fmt.Errorf("line %d: unknown entity %q", line, row["entity"])
+		}
+		if row["name"] == "" {
+			return fmt.Errorf("line %d: name is required", line)
+		}
+		out = append(out, Record{Line: line, Entity: entity, Fields: row})
+		return nil
+	})
+	return out, err
+}
+
+// LoadRecords applies the records in one transaction. A row that cannot be
+// applied fails the whole file, so a sheet is never half loaded.
+func LoadRecords(ctx context.Context, conn *sql.DB, organizationID string, records []Record) (*Result, error) {
+	res := &Result{Inserted: map[string]int{}, Updated: map[string]int{}}
+	err := db.InTx(ctx, conn, func(tx *sql.Tx) error {
+		for _, rec := range records {
+			var inserted bool
+			var err error
+			switch rec.Entity {
+			case EntityCustomer:
+				inserted, err = upsertParty(ctx, tx, "customers", organizationID, rec.Fields)
+			case EntityVendor:
+				inserted, err = upsertParty(ctx, tx, "vendors", organizationID, rec.Fields)
+			case EntityProduct:
+				inserted, err = upsertProduct(ctx, tx, organizationID, rec.Fields)
+			}
+			if err != nil {
+				return fmt.Errorf("line %d (%s %s): %w", rec.Line, rec.Entity, rec.Fields["name"], err)
+			}
+			if inserted {
+				res.Inserted[rec.Entity]++
+			} else {
+				res.Updated[rec.Entity]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// partyColumns are the customer and vendor columns a sheet may set.
+var partyColumns = map[string]string{
+	"display_name":  "displayName",
+	"email":         "email",
+	"phone":         "phone",
+	"mobile":        "mobile",
+	"company_name":  "companyName",
+	"currency":      "currency",
+	"payment_terms": "paymentTerms",
+	"notes":         "notes",
+}
+
+// upsertParty matches a customer or vendor by email, then by name.
+func upsertParty(ctx context.Context, tx *sql.Tx, table, org string, f map[string]string) (bool, error) {
+	var id string
+	err := tx.QueryRowContext(ctx, `
+		SELECT id FROM `+table+`
+		WHERE organizationId = ? AND ((? <> '' AND email = ?) OR name = ?)
+		ORDER BY (email = ?) DESC, createdAt LIMIT 1 FOR UPDATE`,
+		org, f["email"], f["email"], f["name"], f["email"]).Scan(&id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	cols := map[string]any{"name": f["name"]}
+	for key, col := range partyColumns {
+		if v := f[key]; v != "" {
+			cols[col] = v
+		}
+	}
+	if table == "vendors" && f["tax_id"] != "" {
+		cols["taxId"] = f["tax_id"]
+	}
+
+	prefix := "cust"
+	if table == "vendors" {
+		prefix = "vendor"
+	}
+	return upsert(ctx, tx, table, org, id, prefix, cols)
+}
+
+// upsertProduct matches a product by SKU, then by name.
+func upsertProduct(ctx context.Context, tx *sql.Tx, org string, f map[string]string) (bool, error) {
+	var id string
+	err := tx.QueryRowContext(ctx, `
+		SELECT id FROM products
+		WHERE organizationId = ? AND ((? <> '' AND sku = ?) OR (? = '' AND name = ?))
+		ORDER BY createdAt LIMIT 1 FOR UPDATE`,
+		org, f["sku"], f["sku"], f["sku"], f["name"]).Scan(&id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	cols := map[string]any{"name": f["name"]}
+	for key, col := range map[string]string{"sku": "sku", "unit": "unit", "category": "category", "brand": "brand", "description": "description", "currency": "currency", "type": "type"} {
+		if v := f[key]; v != "" {
+			cols[col] = v
+		}
+	}
+	for key, col := range map[string]string{"selling_price": "sellingPrice", "cost_price": "costPrice"} {
+		if v := f[key]; v != "" {
+			a, err := money.Parse(v)
+			if err != nil {
+				return false, fmt.Errorf("%s: %w", key, err)
+			}
+			cols[col] = a
+		}
+	}
+	if v := f["track_inventory"]; v != "" {
+		b, err := strconv.ParseBool(strings.ToLower(v))
+		if err != nil {
+			return false, fmt.Errorf("track_inventory: %w", err)
+		}
+		cols["trackInventory"] = b
+	}
+	if id == "" {
+		// Required on insert.
+		for _, col := range []string{"sellingPrice", "costPrice"} {
+			if _, ok := cols[col]; !ok {
+				cols[col] = money.Amount(0)
+			}
+		}
+	}
+	return upsert(ctx, tx, "products", org, id, "prod", cols)
+}
+
+// upsert updates row id with cols, or inserts a new row when id is empty.
+func upsert(ctx context.Context, tx *sql.Tx, table, org, id, prefix string, cols map[string]any) (bool, error) {
+	now := time.Now()
+	names := make([]string, 0, len(cols))
+	for c := range cols {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+
+	if id != "" {
+		set := make([]string, 0, len(names)+1)
+		args := make([]any, 0, len(names)+2)
+		for _, c := range names {
+			set = append(set, c+" = ?")
+			args = append(args, cols[c])
+		}
+		set = append(set, "updatedAt = ?")
+		args = append(args, now, id)
+		_, err := tx.ExecContext(ctx, `UPDATE `+table+` SET `+strings.Join(set, ", ")+` WHERE id = ?`, args...)
+		return false, err
+	}
+
+	names = append(names, "id", "organizationId", "createdAt", "updatedAt")
+	args := make([]any, 0, len(names))
+	for _, c := range names[:len(names)-4] {
+		args = append(args, cols[c])
+	}
+	args = append(args, cashflow.NewID(prefix), org, now, now)
+	_, err := tx.ExecContext(ctx, `INSERT INTO `+table+` (`+strings.Join(names, ", ")+`)
+		VALUES (?`+strings.Repeat(", ?", len(names)-1)+`)`, args...)
+	return true, err
+}
+
+func headerColumns(head []byte) []string {
+	line, _, _ := strings.Cut(strings.TrimPrefix(string(head), "\ufeff"), "\n")
+	cols, err := csv.NewReader(strings.NewReader(line)).Read()
+	if err != nil {
+		return nil
+	}
+	for i := range cols {
+		cols[i] = normalizeColumn(cols[i])
+	}
+	return cols
+}
+
+func normalizeColumn(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(s)
+}
+
+// readCSV yields each non-empty row keyed by normalized column name.
+func readCSV(r io.Reader, fn func(line int, row map[string]string) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	for i := range header {
+		header[i] = normalizeColumn(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		row := make(map[string]string, len(header))
+		empty := true
+		for i, v := range rec {
+			if i < len(header) {
+				row[header[i]] = strings.TrimSpace(v)
+				empty = empty && row[header[i]] == ""
+			}
+		}
+		if empty {
+			continue
+		}
+		if err := fn(line, row); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package masterdata
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+func TestParseRecords(t *testing.T) {
+	input := "\ufeffEntity,Name,Email,SKU,Selling Price\n" +
+		"customer,Aung Co,buy@aung.example,,\n" +
+		",,,,\n" +
+		"PRODUCT,Green tea,,TEA-01,2500\n"
+	got, err := ParseRecords(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseRecords: %v", err)
+	}
+	want := []Record{
+		{Line: 2, Entity: EntityCustomer, Fields: map[string]string{
+			"entity": "customer", "name": "Aung Co", "email": "buy@aung.example", "sku": "", "selling_price": ""}},
+		{Line: 4, Entity: EntityProduct, Fields: map[string]string{
+			"entity": "PRODUCT", "name": "Green tea", "email": "", "sku": "TEA-01", "selling_price": "2500"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseRecords =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseRecordsErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "entity,name\nsupplier,Aung Co\n", want: `line 2: unknown entity "supplier"`},
+		{input: "entity,name,email\nvendor,,a@b.example\n", want: "line 2: name is required"},
+		{input: "", want: "read header"},
+	}
+	for _, tt := range tests {
+		_, err := ParseRecords(strings.NewReader(tt.input))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseRecords(%q) error = %v, want %q", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestParsePrices(t *testing.T) {
+	price := func(s string) *money.Amount {
+		a := money.MustParse(s)
+		return &a
+	}
+	input := "SKU,Retail Price,Cost\n" +
+		"TEA-01,2500,1200.5\n" +
+		"TEA-02,,900\n" +
+		"TEA-03,3000,\n"
+	got, err := ParsePrices(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParsePrices: %v", err)
+	}
+	want := []PriceRow{
+		{Line: 2, SKU: "TEA-01", Selling: price("2500"), Cost: price("1200.50")},
+		{Line: 3, SKU: "TEA-02", Cost: price("900")},
+		{Line: 4, SKU: "TEA-03", Selling: price("3000")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParsePrices =\n%+v\nwant\n%+v", got, want)
+	}
+
+	for input, want := range map[string]string{
+		"sku,price\n,10\n":      "line 2: sku is required",
+		"sku,price\nTEA,-1\n":   "line 2: price is negative",
+		"sku,cost\nTEA,cheap\n": "line 2: cost: invalid amount",
+	} {
+		if _, err := ParsePrices(strings.NewReader(input)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParsePrices(%q) error = %v, want %q", input, err, want)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		head            string
+		records, prices bool
+	}{
+		{head: "entity,name,email\ncustomer,A,", records: true},
+		{head: "\ufeff Entity ,Name", records: true},
+		{head: "entity\n"},
+		{head: "sku,selling-price\nTEA,1", prices: true},
+		{head: "SKU,Cost Price", prices: true},
+		{head: "sku,name"},
+		{head: "sku,price,quantity"},
+		{head: "batch_id,receipt,sku,price"},
+	}
+	for _, tt := range tests {
+		if got := DetectRecords([]byte(tt.head)); got != tt.records {
+			t.Errorf("DetectRecords(%q) = %v, want %v", tt.head, got, tt.records)
+		}
+		if got := DetectPrices([]byte(tt.head)); got != tt.prices {
+			t.Errorf("DetectPrices(%q) = %v, want %v", tt.head, got, tt.prices)
+		}
+	}
+}
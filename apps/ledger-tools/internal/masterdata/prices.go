@@ -0,0 +1,148 @@
+package masterdata
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// priceColumns maps the headers price sheets use to product columns.
+var priceColumns = map[string]string{
+	"selling_price": "sellingPrice",
+	"price":         "sellingPrice",
+	"retail_price":  "sellingPrice",
+	"cost_price":    "costPrice",
+	"cost":          "costPrice",
+}
+
+// PriceChange is one product whose price a sheet changed.
+type PriceChange struct {
+	SKU         string        `json:"sku"`
+	ProductID   string        `json:"productId"`
+	SellingFrom *money.Amount `json:"sellingFrom,omitempty"`
+	SellingTo   *money.Amount `json:"sellingTo,omitempty"`
+	CostFrom    *money.Amount `json:"costFrom,omitempty"`
+	CostTo      *money.Amount `json:"costTo,omitempty"`
+}
+
+// PriceResult summarizes a price sheet.
+type PriceResult struct {
+	Rows      int           `json:"rows"`
+	Changed   []PriceChange `json:"changed"`
+	Unchanged int           `json:"unchanged"`
+	Unknown   []string      `json:"unknownSkus,omitempty"`
+}
+
+// PriceRow is one price sheet row; nil prices are left as they are.
+type PriceRow struct {
+	Line    int
+	SKU     string
+	Selling *money.Amount
+	Cost    *money.Amount
+}
+
+// DetectPrices reports whether a CSV header is a price sheet: a SKU column
+// and at least one price column, and nothing that looks like sales.
+func DetectPrices(head []byte) bool {
+	cols := headerColumns(head)
+	var sku, price bool
+	for _, c := range cols {
+		switch {
+		case c == "sku":
+			sku = true
+		case priceColumns[c] != "":
+			price = true
+		case c == "entity" || c == "quantity" || c == "receipt" || c == "record_type":
+			return false
+		}
+	}
+	return sku && price
+}
+
+// ParsePrices reads a price sheet.
+func ParsePrices(r io.Reader) ([]PriceRow, error) {
+	var out []PriceRow
+	err := readCSV(r, func(line int, row map[string]string) error {
+		pr := PriceRow{Line: line, SKU: row["sku"]}
+		if pr.SKU == "" {
+			return fmt.Errorf("line %d: sku is required", line)
+		}
+		for key, col := range priceColumns {
+			v := row[key]
+			if v == "" {
+				continue
+			}
+			a, err := money.Parse(v)
+			if err != nil {
+				return fmt.Errorf("line %d: %s: %w", line, key, err)
+			}
+			if a < 0 {
+				return fmt.Errorf("line %d: %s is negative", line, key)
+			}
+			if col == "sellingPrice" {
+				pr.Selling = &a
+			} else {
+				pr.Cost = &a
+			}
+		}
+		out = append(out, pr)
+		return nil
+	})
+	return out, err
+}
+
+// ApplyPrices updates product prices by SKU in one transaction. Unknown SKUs
+// are reported, not created; master data files create products.
+func ApplyPrices(ctx context.Context, conn *sql.DB, organizationID string, rows []PriceRow) (*PriceResult, error) {
+	res := &PriceResult{Rows: len(rows)}
+	err := db.InTx(ctx, conn, func(tx *sql.Tx) error {
+		now := time.Now()
+		for _, r := range rows {
+			var id string
+			var selling, cost money.Amount
+			err := tx.QueryRowContext(ctx, `
+				SELECT id, sellingPrice, costPrice FROM products
+				WHERE organizationId = ? AND sku = ? ORDER BY createdAt LIMIT 1 FOR UPDATE`,
+				organizationID, r.SKU).Scan(&id, &selling, &cost)
+			if errors.Is(err, sql.ErrNoRows) {
+				res.Unknown = append(res.Unknown, r.SKU)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			change := PriceChange{SKU: r.SKU, ProductID: id}
+			newSelling, newCost := selling, cost
+			if r.Selling != nil && *r.Selling != selling {
+				change.SellingFrom, change.SellingTo = &selling, r.Selling
+				newSelling = *r.Selling
+			}
+			if r.Cost != nil && *r.Cost != cost {
+				change.CostFrom, change.CostTo = &cost, r.Cost
+				newCost = *r.Cost
+			}
+			if change.SellingTo == nil && change.CostTo == nil {
+				res.Unchanged++
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE products SET sellingPrice = ?, costPrice = ?, updatedAt = ? WHERE id = ?`,
+				newSelling, newCost, now, id); err != nil {
+				return fmt.Errorf("line %d: update %s: %w", r.Line, r.SKU, err)
+			}
+			res.Changed = append(res.Changed, change)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
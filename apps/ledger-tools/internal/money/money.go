@@ -0,0 +1,236 @@
+// Package money represents amounts in hundredths, matching the
+// DECIMAL(12,2) columns of cashflowdb and OA's precision-2 accounts.
+package money
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Amount is a monetary value in hundredths of the currency unit.
+type Amount int64
+
+// Parse reads a decimal string such as "1234.5" or "-0.75". More than two
+// fractional digits are rounded half away from zero.
+func Parse(in string) (Amount, error) {
+	s := strings.TrimSpace(strings.ReplaceAll(in, ",", ""))
+	if s == "" {
+		return 0, nil
+	}
+
+	neg := false
+	switch s[0] {
+	case '-':
+		neg = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" {
+		whole = "0"
+	}
+	// ParseInt accepts a sign of its own, which would let "--5" through.
+	if !digits(whole) || !digits(frac) {
+		return 0, fmt.Errorf("invalid amount %q", in)
+	}
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", in)
+	}
+
+	frac += "000"
+	cents, _ := strconv.ParseInt(frac[:2], 10, 64)
+	if frac[2] >= '5' {
+		cents++
+	}
+
+	a := Amount(units*100 + cents)
+	if neg {
+		a = -a
+	}
+	return a, nil
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// MustParse is Parse for constants; it panics on invalid input.
+func MustParse(s string) Amount {
+	a, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// FromFloat converts a float, rounding to the nearest hundredth.
+func FromFloat(f float64) Amount {
+	return Amount(math.Round(f * 100))
+}
+
+// FromMinor converts an amount in minor units of the given precision, as
+// stored in OA split.amount.
+func FromMinor(v int64, precision int) Amount {
+	switch {
+	case precision == 2:
+		return Amount(v)
+	case precision < 2:
+		return Amount(v * pow10(2-precision))
+	default:
+		d := pow10(precision - 2)
+		q, r := v/d, v%d
+		if 2*abs(r) >= d {
+			if v < 0 {
+				q--
+			} else {
+				q++
+			}
+		}
+		return Amount(q)
+	}
+}
+
+// Minor converts the amount to minor units of the given precision.
+func (a Amount) Minor(precision int) int64 {
+	if precision >= 2 {
+		return int64(a) * pow10(precision-2)
+	}
+	return int64(FromMinor(int64(a), 2-precision+2))
+}
+
+// Float returns the amount as a float for display and ratios.
+func (a Amount) Float() float64 {
+	return float64(a) / 100
+}
+
+// Abs returns the absolute value.
+func (a Amount) Abs() Amount {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// Mul multiplies by a quantity, rounding to the nearest hundredth.
+func (a Amount) Mul(q float64) Amount {
+	return Amount(math.Round(float64(a) * q))
+}
+
+// String formats the amount with two decimals.
+func (a Amount) String() string {
+	sign := ""
+	v := int64(a)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+// Value stores the amount as a DECIMAL string.
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// Scan reads DECIMAL, integer and float columns.
+func (a *Amount) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+	case []byte:
+		p, err := Parse(string(v))
+		if err != nil {
+			return err
+		}
+		*a = p
+	case string:
+		p, err := Parse(v)
+		if err != nil {
+			return err
+		}
+		*a = p
+	case int64:
+		*a = Amount(v * 100)
+	case float64:
+		*a = FromFloat(v)
+	default:
+		return fmt.Errorf("money: cannot scan %T", src)
+	}
+	return nil
+}
+
+// MarshalText lets amounts appear as plain decimals in JSON and CSV.
+func (a Amount) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText accepts the same formats as Parse.
+func (a *Amount) UnmarshalText(b []byte) error {
+	p, err := Parse(string(b))
+	if err != nil {
+		return err
+	}
+	*a = p
+	return nil
+}
+
+// UnmarshalJSON accepts JSON numbers as well as strings, since exports from
+// other systems rarely quote their amounts.
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "null" {
+		return nil
+	}
+	return a.UnmarshalText([]byte(s))
+}
+
+// Allocate splits a into parts proportional to weights, distributing the
+// rounding remainder so the parts always sum to a.
+func (a Amount) Allocate(weights []float64) []Amount {
+	out := make([]Amount, len(weights))
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	if total == 0 || len(weights) == 0 {
+		return out
+	}
+
+	var allocated Amount
+	largest := 0
+	for i, w := range weights {
+		out[i] = Amount(math.Round(float64(a) * w / total))
+		allocated += out[i]
+		if math.Abs(w) > math.Abs(weights[largest]) {
+			largest = i
+		}
+	}
+	out[largest] += a - allocated
+	return out
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,127 @@
+package money
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "0", want: 0},
+		{in: "1234.5", want: 123450},
+		{in: "1,234.56", want: 123456},
+		{in: " 12 ", want: 1200},
+		{in: "-0.75", want: -75},
+		{in: "+3.10", want: 310},
+		{in: ".5", want: 50},
+		{in: "7.", want: 700},
+		{in: "1.005", want: 101},
+		{in: "1.004", want: 100},
+		{in: "1.995", want: 200},
+		{in: "-1.005", want: -101},
+		{in: "abc", wantErr: true},
+		{in: "1.2x", wantErr: true},
+		{in: "1-2", wantErr: true},
+		{in: "--5", wantErr: true},
+		{in: "-+5", wantErr: true},
+		{in: "+-5", wantErr: true},
+		{in: "- 5", wantErr: true},
+		{in: "5.-1", wantErr: true},
+		{in: "99999999999999999999", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, want an error", tt.in, got)
+			} else if !strings.Contains(err.Error(), strconv.Quote(tt.in)) {
+				t.Errorf("Parse(%q) error = %v, want it to quote the input", tt.in, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	for _, a := range []Amount{0, 1, -1, 99, 100, -105, 123456789} {
+		got, err := Parse(a.String())
+		if err != nil || got != a {
+			t.Errorf("Parse(%q) = %v, %v, want %v", a.String(), got, err, a)
+		}
+	}
+}
+
+func TestFromMinor(t *testing.T) {
+	tests := []struct {
+		v         int64
+		precision int
+		want      Amount
+	}{
+		{v: 12345, precision: 2, want: 12345},
+		{v: 12, precision: 0, want: 1200},
+		{v: 123, precision: 1, want: 1230},
+		{v: 12345, precision: 3, want: 1235},
+		{v: 12344, precision: 3, want: 1234},
+		{v: -12345, precision: 3, want: -1235},
+	}
+	for _, tt := range tests {
+		if got := FromMinor(tt.v, tt.precision); got != tt.want {
+			t.Errorf("FromMinor(%d, %d) = %v, want %v", tt.v, tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		a       Amount
+		weights []float64
+		want    []Amount
+	}{
+		{name: "even", a: 300, weights: []float64{1, 1, 1}, want: []Amount{100, 100, 100}},
+		{name: "remainder to the first of equal weights", a: 100, weights: []float64{1, 1, 1}, want: []Amount{34, 33, 33}},
+		{name: "remainder to the heaviest", a: 100, weights: []float64{1, 2, 1}, want: []Amount{25, 50, 25}},
+		{name: "odd cents", a: 101, weights: []float64{1, 2}, want: []Amount{34, 67}},
+		{name: "proportional", a: 1000, weights: []float64{3, 1}, want: []Amount{750, 250}},
+		{name: "negative amount", a: -100, weights: []float64{1, 2}, want: []Amount{-33, -67}},
+		{name: "zero weight", a: 500, weights: []float64{0, 1}, want: []Amount{0, 500}},
+		{name: "zero total", a: 500, weights: []float64{0, 0}, want: []Amount{0, 0}},
+		{name: "no weights", a: 500, weights: nil, want: []Amount{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.a.Allocate(tt.weights)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Allocate(%v) = %v, want %v", tt.weights, got, tt.want)
+			}
+			var total float64
+			for _, w := range tt.weights {
+				total += w
+			}
+			if total == 0 {
+				return
+			}
+			var sum Amount
+			for _, p := range got {
+				sum += p
+			}
+			if sum != tt.a {
+				t.Errorf("parts sum to %v, want %v", sum, tt.a)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package oa
+
+import (
+	"context"
+	"strings"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+)
+
+// Account is an OA account row.
+type Account struct {
+	ID           string
+	Parent       string
+	Name         string
+	Currency     string
+	Precision    int
+	DebitBalance bool
+}
+
+// Chart is an org's account tree.
+type Chart struct {
+	Accounts map[string]*Account
+	children map[string][]string
+}
+
+// LoadChart loads every account of an org.
+func LoadChart(ctx context.Context, q cashflow.Querier, orgID string) (*Chart, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT LOWER(HEX(id)), LOWER(HEX(parent)), name, currency, `+"`precision`"+`, debitBalance
+		FROM account WHERE orgId = UNHEX(?)`, NormalizeID(orgID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	c := &Chart{Accounts: map[string]*Account{}, children: map[string][]string{}}
+	for rows.Next() {
+		a := &Account{}
+		if err := rows.Scan(&a.ID, &a.Parent, &a.Name, &a.Currency, &a.Precision, &a.DebitBalance); err != nil {
+			return nil, err
+		}
+		c.Accounts[a.ID] = a
+		c.children[a.Parent] = append(c.children[a.Parent], a.ID)
+	}
+	return c, rows.Err()
+}
+
+// Path returns the account's ancestors from the top-level category down to
+// the account itself, excluding OA's Root account.
+func (c *Chart) Path(id string) []*Account {
+	var path []*Account
+	for a, ok := c.Accounts[id]; ok && len(path) < 64; a, ok = c.Accounts[a.Parent] {
+		path = append([]*Account{a}, path...)
+	}
+	if len(path) > 1 && c.Accounts[path[0].Parent] == nil && strings.EqualFold(path[0].Name, "Root") {
+		path = path[1:]
+	}
+	return path
+}
+
+// Class maps an account to a statement class through the top-level
+// category OA creates for every org: Assets, Liabilities, Equity, Income
+// and Expenses.
+func (c *Chart) Class(id string) string {
+	path := c.Path(id)
+	if len(path) == 0 {
+		return ""
+	}
+	name := strings.ToLower(path[0].Name)
+	switch {
+	case strings.HasPrefix(name, "asset"):
+		return cashflow.ClassAsset
+	case strings.HasPrefix(name, "liabilit"):
+		return cashflow.ClassLiability
+	case strings.HasPrefix(name, "equity"):
+		return cashflow.ClassEquity
+	case strings.HasPrefix(name, "income"), strings.HasPrefix(name, "revenue"):
+		return cashflow.ClassIncome
+	case strings.HasPrefix(name, "expense"):
+		return cashflow.ClassExpense
+	}
+	return ""
+}
+
+// FullName joins the account's path with colons, as OA displays it.
+func (c *Chart) FullName(id string) string {
+	var names []string
+	for _, a := range c.Path(id) {
+		names = append(names, a.Name)
+	}
+	return strings.Join(names, ":")
+}
+
+// Leaf reports whether an account has no children; only leaves carry splits.
+func (c *Chart) Leaf(id string) bool {
+	return len(c.children[id]) == 0
+}
@@ -0,0 +1,118 @@
+// Package oa reads the Open Accounting database: organizations, the account
+// tree and posted splits. OA ids are BINARY(16) and appear here as 32-digit
+// lowercase hex strings, the form the OA REST API uses; dates are BIGINT
+// milliseconds.
+package oa
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+)
+
+// Org is an OA org row.
+type Org struct {
+	ID        string
+	Name      string
+	Currency  string
+	Precision int
+	Timezone  string
+}
+
+// GetOrg loads an organization.
+func GetOrg(ctx context.Context, q cashflow.Querier, id string) (*Org, error) {
+	o := &Org{}
+	err := q.QueryRowContext(ctx, `
+		SELECT LOWER(HEX(id)), name, currency, `+"`precision`"+`, timezone FROM org WHERE id = UNHEX(?)`, NormalizeID(id)).
+		Scan(&o.ID, &o.Name, &o.Currency, &o.Precision, &o.Timezone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("OA org %s: %w", id, cashflow.ErrNotFound)
+	}
+	return o, err
+}
+
+// NormalizeID turns a UUID-formatted or upper-case id into the 32-digit
+// lowercase hex form.
+func NormalizeID(id string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(id), "-", ""))
+}
+
+// OrgForOrganization returns the OA org id linked to a cashflowdb
+// organization (organizations.oaOrganizationId).
+func OrgForOrganization(ctx context.Context, cf cashflow.Querier, organizationID string) (string, error) {
+	var id string
+	err := cf.QueryRowContext(ctx, `SELECT oaOrganizationId FROM organizations WHERE id = ?`, organizationID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("organization %s: %w", organizationID, cashflow.ErrNotFound)
+	}
+	return NormalizeID(id), err
+}
+
+// Millis converts a time to OA's millisecond timestamps.
+func Millis(t time.Time) int64 { return t.UnixMilli() }
+
+// Split is a posted split with its transaction date.
+type Split struct {
+	ID            int64
+	TransactionID string
+	AccountID     string
+	Date          time.Time
+	Inserted      time.Time
+	Amount        int64 // nativeAmount: minor units of the org currency
+}
+
+// Splits calls fn for every live split of an org dated in [from, to). A zero
+// from starts at the beginning.
+func Splits(ctx context.Context, q cashflow.Querier, orgID string, from, to time.Time, fn func(*Split) error) error {
+	var fromMs int64
+	if !from.IsZero() {
+		fromMs = Millis(from)
+	}
+	rows, err := q.QueryContext(ctx, `
+		SELECT s.id, LOWER(HEX(s.transactionId)), LOWER(HEX(s.accountId)), s.date, s.inserted, s.nativeAmount
+		FROM split s JOIN transaction t ON t.id = s.transactionId
+		WHERE t.orgId = UNHEX(?) AND t.deleted = false AND s.deleted = false AND s.date >= ? AND s.date < ?
+		ORDER BY s.date, s.id`, NormalizeID(orgID), fromMs, Millis(to))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s := &Split{}
+		var ms, inserted int64
+		if err := rows.Scan(&s.ID, &s.TransactionID, &s.AccountID, &ms, &inserted, &s.Amount); err != nil {
+			return err
+		}
+		s.Date, s.Inserted = time.UnixMilli(ms), time.UnixMilli(inserted)
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// TransactionSplits returns the ids of a transaction's live splits.
+func TransactionSplits(ctx context.Context, q cashflow.Querier, transactionID string) ([]int64, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id FROM split WHERE transactionId = UNHEX(?) AND deleted = false ORDER BY id`, NormalizeID(transactionID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
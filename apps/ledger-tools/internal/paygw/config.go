@@ -0,0 +1,73 @@
+package paygw
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Config is the receiver configuration file.
+type Config struct {
+	Listen    string     `json:"listen"`
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+// Endpoint binds one callback URL, /callbacks/<name>, to a provider adapter,
+// a merchant secret and the organization and accounts payments post to.
+type Endpoint struct {
+	Name             string `json:"name"`
+	Provider         string `json:"provider"`
+	OrganizationID   string `json:"organizationId"`
+	Secret           string `json:"secret"` // literal, or env:VAR_NAME
+	DepositAccountID string `json:"depositAccountId"`
+	FeeAccountID     string `json:"feeAccountId,omitempty"`
+	PaymentMode      string `json:"paymentMode,omitempty"`
+}
+
+// LoadConfig reads and validates a configuration file.
+func LoadConfig(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{Listen: ":8090"}
+	if err := json.Unmarshal(raw, cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	seen := map[string]bool{}
+	for i := range cfg.Endpoints {
+		ep := &cfg.Endpoints[i]
+		if ep.Name == "" || ep.OrganizationID == "" || ep.DepositAccountID == "" {
+			return nil, fmt.Errorf("endpoint %d: name, organizationId and depositAccountId are required", i)
+		}
+		if seen[ep.Name] {
+			return nil, fmt.Errorf("endpoint %q is defined twice", ep.Name)
+		}
+		seen[ep.Name] = true
+		if _, err := Lookup(ep.Provider); err != nil {
+			return nil, fmt.Errorf("endpoint %q: %w", ep.Name, err)
+		}
+		if name, ok := strings.CutPrefix(ep.Secret, "env:"); ok {
+			ep.Secret = os.Getenv(name)
+		}
+		if ep.Secret == "" {
+			return nil, fmt.Errorf("endpoint %q: secret is empty", ep.Name)
+		}
+		if ep.PaymentMode == "" {
+			ep.PaymentMode = ep.Provider
+		}
+	}
+	return cfg, nil
+}
+
+// Endpoint returns the endpoint with the given name.
+func (c *Config) Endpoint(name string) (*Endpoint, bool) {
+	for i := range c.Endpoints {
+		if c.Endpoints[i].Name == name {
+			return &c.Endpoints[i], true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,88 @@
+package paygw
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/correlation"
+)
+
+const maxBody = 1 << 20
+
+// Handler serves POST /callbacks/<endpoint>. Signature failures return 401,
+// malformed payloads 400, and storage errors 500 so the gateway retries;
+// everything that was stored, including unmatched and duplicate callbacks,
+// is acknowledged in the provider's expected format. Each callback gets a
+// correlation id, taken from X-Request-ID when the gateway sends one, that
+// prefixes its log lines and keeps them in job_logs.
+func Handler(cfg *Config, svc *Service, logger *log.Logger) http.Handler {
+	mux := http.NewServeMux()
+	jobs := &correlation.Log{DB: svc.DB, Job: "paygw", Logger: logger}
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	mux.HandleFunc("/callbacks/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/callbacks/"), "/")
+		ep, ok := cfg.Endpoint(name)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		provider, err := Lookup(ep.Provider)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBody))
+		if err != nil {
+			http.Error(w, "read body", http.StatusBadRequest)
+			return
+		}
+
+		cb, err := provider.Parse(r.Header, body, ep.Secret)
+		if errors.Is(err, ErrSignature) {
+			jobs.Printf(r.Context(), "%s: rejected callback from %s: %v", ep.Name, r.RemoteAddr, err)
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			jobs.Printf(r.Context(), "%s: malformed callback: %v", ep.Name, err)
+			http.Error(w, "malformed callback", http.StatusBadRequest)
+			return
+		}
+
+		outcome, err := svc.Process(r.Context(), ep, cb, body)
+		if err != nil {
+			jobs.Printf(r.Context(), "%s: txn %s: %v", ep.Name, cb.TransactionID, err)
+			http.Error(w, "could not process callback", http.StatusInternalServerError)
+			return
+		}
+
+		switch {
+		case outcome.Duplicate:
+			jobs.Printf(r.Context(), "%s: txn %s already processed (%s)", ep.Name, cb.TransactionID, outcome.Status)
+		case outcome.Status == EventRecorded:
+			jobs.Printf(r.Context(), "%s: txn %s recorded %s against invoice %s (payment %s, journal %s)",
+				ep.Name, cb.TransactionID, cb.Amount, outcome.InvoiceNumber, outcome.PaymentID, outcome.JournalID)
+		default:
+			jobs.Printf(r.Context(), "%s: txn %s %s: %s", ep.Name, cb.TransactionID, outcome.Status, outcome.Reason)
+		}
+
+		contentType, ack := provider.Ack()
+		w.Header().Set("Content-Type", contentType)
+		w.Write(ack)
+	})
+
+	return correlation.Middleware(mux)
+}
@@ -0,0 +1,133 @@
+package paygw
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+func init() {
+	Register(hmacProvider{})
+}
+
+// hmacProvider is a generic JSON webhook signed with HMAC-SHA256 over
+// "<timestamp>.<body>", the scheme most card and wallet aggregators use.
+// Callbacks older than maxSkew are rejected to prevent replays.
+type hmacProvider struct{}
+
+const maxSkew = 5 * time.Minute
+
+type hmacPayload struct {
+	TransactionID string `json:"transactionId"`
+	Reference     string `json:"reference"`
+	Amount        string `json:"amount"`
+	Fee           string `json:"fee,omitempty"`
+	Currency      string `json:"currency"`
+	Status        string `json:"status"`
+	PaidAt        string `json:"paidAt"`
+	Payer         string `json:"payer,omitempty"`
+}
+
+func (hmacProvider) Name() string { return "hmac" }
+
+func (hmacProvider) Parse(header http.Header, body []byte, secret string) (*Callback, error) {
+	ts := header.Get("X-Timestamp")
+	sig := strings.TrimPrefix(header.Get("X-Signature"), "sha256=")
+	if ts == "" || sig == "" {
+		return nil, fmt.Errorf("%w: missing X-Timestamp or X-Signature", ErrSignature)
+	}
+	if !hmac.Equal([]byte(sig), []byte(hmacSign(secret, ts, body))) {
+		return nil, ErrSignature
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad timestamp", ErrSignature)
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > maxSkew || skew < -maxSkew {
+		return nil, fmt.Errorf("%w: timestamp outside allowed window", ErrSignature)
+	}
+
+	var p hmacPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("decode callback: %w", err)
+	}
+	amount, err := money.Parse(p.Amount)
+	if err != nil {
+		return nil, err
+	}
+	fee, err := money.Parse(p.Fee)
+	if err != nil {
+		return nil, err
+	}
+	paidAt := time.Now()
+	if p.PaidAt != "" {
+		if paidAt, err = time.Parse(time.RFC3339, p.PaidAt); err != nil {
+			return nil, fmt.Errorf("paidAt: %w", err)
+		}
+	}
+
+	status := StatusPending
+	switch strings.ToLower(p.Status) {
+	case "success", "succeeded", "paid", "completed":
+		status = StatusSuccess
+	case "failed", "cancelled", "canceled", "expired":
+		status = StatusFailed
+	}
+
+	return &Callback{
+		TransactionID: p.TransactionID,
+		Reference:     p.Reference,
+		Amount:        amount,
+		Fee:           fee,
+		Currency:      p.Currency,
+		Status:        status,
+		PaidAt:        paidAt,
+		Payer:         p.Payer,
+	}, nil
+}
+
+func (hmacProvider) Sign(cb *Callback, secret string) (http.Header, []byte, error) {
+	p := hmacPayload{
+		TransactionID: cb.TransactionID,
+		Reference:     cb.Reference,
+		Amount:        cb.Amount.String(),
+		Currency:      cb.Currency,
+		Status:        cb.Status,
+		PaidAt:        cb.PaidAt.Format(time.RFC3339),
+		Payer:         cb.Payer,
+	}
+	if cb.Fee != 0 {
+		p.Fee = cb.Fee.String()
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	h := http.Header{}
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Timestamp", ts)
+	h.Set("X-Signature", "sha256="+hmacSign(secret, ts, body))
+	return h, body, nil
+}
+
+func (hmacProvider) Ack() (string, []byte) {
+	return "application/json", []byte(`{"received":true}`)
+}
+
+func hmacSign(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
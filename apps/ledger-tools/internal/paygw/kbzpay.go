@@ -0,0 +1,149 @@
+package paygw
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+func init() {
+	Register(kbzpayProvider{})
+}
+
+// kbzpayProvider handles KBZPay notify callbacks: a {"Request": {...}}
+// envelope whose "sign" is the upper-case SHA256 of the other non-empty
+// fields sorted by key, joined as k=v&..., followed by "&key=<app key>".
+type kbzpayProvider struct{}
+
+func (kbzpayProvider) Name() string { return "kbzpay" }
+
+func (kbzpayProvider) Parse(_ http.Header, body []byte, secret string) (*Callback, error) {
+	var envelope struct {
+		Request map[string]any `json:"Request"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("decode callback: %w", err)
+	}
+	fields := map[string]string{}
+	for k, v := range envelope.Request {
+		switch t := v.(type) {
+		case string:
+			fields[k] = t
+		case float64:
+			fields[k] = strconv.FormatFloat(t, 'f', -1, 64)
+		case nil:
+		default:
+			fields[k] = fmt.Sprint(t)
+		}
+	}
+
+	sign := fields["sign"]
+	if sign == "" || subtle.ConstantTimeCompare([]byte(strings.ToUpper(sign)), []byte(kbzpaySign(fields, secret))) != 1 {
+		return nil, ErrSignature
+	}
+
+	amount, err := money.Parse(fields["total_amount"])
+	if err != nil {
+		return nil, err
+	}
+
+	paidAt := time.Now()
+	if ts := fields["trans_end_time"]; ts != "" {
+		if sec, err := strconv.ParseInt(ts, 10, 64); err == nil {
+			paidAt = time.Unix(sec, 0)
+		}
+	}
+
+	status := StatusPending
+	switch fields["trade_status"] {
+	case "PAY_SUCCESS":
+		status = StatusSuccess
+	case "PAY_FAILED", "ORDER_CLOSED", "ORDER_EXPIRED":
+		status = StatusFailed
+	}
+
+	return &Callback{
+		TransactionID: fields["mm_order_id"],
+		Reference:     fields["merch_order_id"],
+		Amount:        amount,
+		Currency:      fields["trans_currency"],
+		Status:        status,
+		PaidAt:        paidAt,
+		Payer:         fields["customer_msisdn"],
+	}, nil
+}
+
+func (kbzpayProvider) Sign(cb *Callback, secret string) (http.Header, []byte, error) {
+	status := "PAY_SUCCESS"
+	switch cb.Status {
+	case StatusFailed:
+		status = "PAY_FAILED"
+	case StatusPending:
+		status = "WAIT_PAY"
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+
+	fields := map[string]string{
+		"notify_time":     strconv.FormatInt(time.Now().Unix(), 10),
+		"merch_order_id":  cb.Reference,
+		"mm_order_id":     cb.TransactionID,
+		"trans_currency":  cb.Currency,
+		"total_amount":    cb.Amount.String(),
+		"trade_status":    status,
+		"trans_end_time":  strconv.FormatInt(cb.PaidAt.Unix(), 10),
+		"customer_msisdn": cb.Payer,
+		"nonce_str":       strings.ToUpper(hex.EncodeToString(nonce)),
+		"sign_type":       "SHA256",
+	}
+	fields["sign"] = kbzpaySign(fields, secret)
+
+	body, err := json.Marshal(map[string]any{"Request": fields})
+	if err != nil {
+		return nil, nil, err
+	}
+	h := http.Header{}
+	h.Set("Content-Type", "application/json")
+	return h, body, nil
+}
+
+func (kbzpayProvider) Ack() (string, []byte) {
+	return "text/plain", []byte("success")
+}
+
+func kbzpaySign(fields map[string]string, secret string) string {
+	keys := make([]string, 0, len(fields))
+	for k, v := range fields {
+		if k == "sign" || k == "sign_type" || v == "" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(fields[k])
+	}
+	b.WriteString("&key=")
+	b.WriteString(secret)
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,77 @@
+// Package paygw receives payment gateway callbacks (mobile wallets, card
+// acquirers) and records them as invoice payments in cashflowdb.
+//
+// Each gateway is a Provider adapter that verifies the callback signature
+// and normalizes the payload into a Callback. Adapters also know how to sign
+// a Callback so the local simulator can exercise the receiver end to end.
+package paygw
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Callback statuses after normalization.
+const (
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+	StatusPending = "pending"
+)
+
+// ErrSignature is returned when a callback fails signature verification.
+var ErrSignature = errors.New("invalid callback signature")
+
+// Callback is a provider notification normalized across gateways.
+type Callback struct {
+	TransactionID string       // provider's unique transaction id
+	Reference     string       // merchant reference, expected to be an invoice number
+	Amount        money.Amount // gross amount paid
+	Fee           money.Amount // provider fee withheld, if reported
+	Currency      string
+	Status        string
+	PaidAt        time.Time
+	Payer         string
+}
+
+// Provider adapts one gateway's callback format.
+type Provider interface {
+	// Name is the adapter name used in configuration.
+	Name() string
+	// Parse verifies the signature and normalizes the callback body.
+	Parse(header http.Header, body []byte, secret string) (*Callback, error)
+	// Sign encodes a callback the way the gateway would send it.
+	Sign(cb *Callback, secret string) (http.Header, []byte, error)
+	// Ack is the response body the gateway expects on success.
+	Ack() (contentType string, body []byte)
+}
+
+var providers = map[string]Provider{}
+
+// Register makes an adapter available by name.
+func Register(p Provider) {
+	providers[p.Name()] = p
+}
+
+// Lookup returns a registered adapter.
+func Lookup(name string) (Provider, error) {
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown payment provider %q (available: %v)", name, Providers())
+	}
+	return p, nil
+}
+
+// Providers lists the registered adapter names.
+func Providers() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,214 @@
+package paygw
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "s3cret"
+
+func testCallback() *Callback {
+	return &Callback{
+		TransactionID: "TXN-1001",
+		Reference:     "INV-000123",
+		Amount:        150000,
+		Currency:      "MMK",
+		Status:        StatusSuccess,
+		PaidAt:        time.Date(2025, 3, 14, 9, 30, 0, 0, time.UTC),
+		Payer:         "09420000000",
+	}
+}
+
+func TestKBZPaySign(t *testing.T) {
+	fields := map[string]string{
+		"merch_order_id": "INV-1",
+		"total_amount":   "100.00",
+		"appid":          "kp123",
+		"sign_type":      "SHA256",
+		"sign":           "ignored",
+		"payer":          "",
+	}
+	sum := sha256.Sum256([]byte("appid=kp123&merch_order_id=INV-1&total_amount=100.00&key=" + testSecret))
+	want := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if got := kbzpaySign(fields, testSecret); got != want {
+		t.Errorf("kbzpaySign = %s, want %s", got, want)
+	}
+}
+
+func TestKBZPayParse(t *testing.T) {
+	p := kbzpayProvider{}
+	_, body, err := p.Sign(testCallback(), testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := string(body)
+	sign := signField(t, signed)
+
+	tests := []struct {
+		name    string
+		body    string
+		secret  string
+		wantErr error
+	}{
+		{name: "valid", body: signed, secret: testSecret},
+		{name: "lower-case sign", body: strings.Replace(signed, sign, strings.ToLower(sign), 1), secret: testSecret},
+		{name: "wrong secret", body: signed, secret: "other", wantErr: ErrSignature},
+		{name: "tampered amount", body: strings.Replace(signed, `"1500.00"`, `"15.00"`, 1), secret: testSecret, wantErr: ErrSignature},
+		{name: "no sign", body: strings.Replace(signed, `"sign":"`+sign+`"`, `"sign":""`, 1), secret: testSecret, wantErr: ErrSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb, err := p.Parse(nil, []byte(tt.body), tt.secret)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Parse error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			assertCallback(t, cb, testCallback())
+		})
+	}
+}
+
+func TestKBZPayStatus(t *testing.T) {
+	p := kbzpayProvider{}
+	for _, status := range []string{StatusSuccess, StatusFailed, StatusPending} {
+		cb := testCallback()
+		cb.Status = status
+		_, body, err := p.Sign(cb, testSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := p.Parse(nil, body, testSecret)
+		if err != nil {
+			t.Fatalf("%s: Parse: %v", status, err)
+		}
+		if got.Status != status {
+			t.Errorf("status %s came back as %s", status, got.Status)
+		}
+	}
+}
+
+func TestHMACParse(t *testing.T) {
+	p := hmacProvider{}
+	cb := testCallback()
+	cb.Fee = 1500
+	header, body, err := p.Sign(cb, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signedAt := func(at time.Time) http.Header {
+		ts := strconv.FormatInt(at.Unix(), 10)
+		h := http.Header{}
+		h.Set("X-Timestamp", ts)
+		h.Set("X-Signature", "sha256="+hmacSign(testSecret, ts, body))
+		return h
+	}
+	without := func(key string) http.Header {
+		h := header.Clone()
+		h.Del(key)
+		return h
+	}
+	badSignature := header.Clone()
+	badSignature.Set("X-Signature", "sha256="+strings.Repeat("0", 64))
+	badTimestamp := http.Header{}
+	badTimestamp.Set("X-Timestamp", "yesterday")
+	badTimestamp.Set("X-Signature", hmacSign(testSecret, "yesterday", body))
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		body    []byte
+		secret  string
+		wantErr bool
+	}{
+		{name: "valid", header: header, body: body, secret: testSecret},
+		{name: "signature without prefix", header: http.Header{
+			"X-Timestamp": header["X-Timestamp"],
+			"X-Signature": {strings.TrimPrefix(header.Get("X-Signature"), "sha256=")},
+		}, body: body, secret: testSecret},
+		{name: "wrong secret", header: header, body: body, secret: "other", wantErr: true},
+		{name: "tampered body", header: header, body: []byte(strings.Replace(string(body), "1500.00", "15.00", 1)),
+			secret: testSecret, wantErr: true},
+		{name: "bad signature", header: badSignature, body: body, secret: testSecret, wantErr: true},
+		{name: "no timestamp", header: without("X-Timestamp"), body: body, secret: testSecret, wantErr: true},
+		{name: "no signature", header: without("X-Signature"), body: body, secret: testSecret, wantErr: true},
+		{name: "bad timestamp", header: badTimestamp, body: body, secret: testSecret, wantErr: true},
+		{name: "replayed", header: signedAt(time.Now().Add(-maxSkew - time.Minute)), body: body, secret: testSecret, wantErr: true},
+		{name: "from the future", header: signedAt(time.Now().Add(maxSkew + time.Minute)), body: body, secret: testSecret, wantErr: true},
+		{name: "within the window", header: signedAt(time.Now().Add(-maxSkew + time.Minute)), body: body, secret: testSecret},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.Parse(tt.header, tt.body, tt.secret)
+			if tt.wantErr {
+				if !errors.Is(err, ErrSignature) {
+					t.Fatalf("Parse error = %v, want %v", err, ErrSignature)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			assertCallback(t, got, cb)
+			if got.Fee != cb.Fee {
+				t.Errorf("fee = %v, want %v", got.Fee, cb.Fee)
+			}
+		})
+	}
+}
+
+func TestHMACStatus(t *testing.T) {
+	tests := map[string]string{
+		"success": StatusSuccess, "PAID": StatusSuccess, "completed": StatusSuccess,
+		"failed": StatusFailed, "Canceled": StatusFailed, "expired": StatusFailed,
+		"processing": StatusPending, "": StatusPending,
+	}
+	p := hmacProvider{}
+	for in, want := range tests {
+		body := []byte(`{"transactionId":"T1","reference":"INV-1","amount":"10.00","currency":"MMK","status":"` + in + `"}`)
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		h := http.Header{}
+		h.Set("X-Timestamp", ts)
+		h.Set("X-Signature", hmacSign(testSecret, ts, body))
+		cb, err := p.Parse(h, body, testSecret)
+		if err != nil {
+			t.Fatalf("%q: Parse: %v", in, err)
+		}
+		if cb.Status != want {
+			t.Errorf("status %q = %s, want %s", in, cb.Status, want)
+		}
+	}
+}
+
+// signField returns the "sign" value of a signed KBZPay body.
+func signField(t *testing.T, body string) string {
+	t.Helper()
+	_, rest, ok := strings.Cut(body, `"sign":"`)
+	if !ok {
+		t.Fatalf("no sign in %s", body)
+	}
+	sign, _, _ := strings.Cut(rest, `"`)
+	return sign
+}
+
+func assertCallback(t *testing.T, got, want *Callback) {
+	t.Helper()
+	if got.TransactionID != want.TransactionID || got.Reference != want.Reference || got.Amount != want.Amount ||
+		got.Currency != want.Currency || got.Status != want.Status || got.Payer != want.Payer {
+		t.Errorf("callback = %+v, want %+v", got, want)
+	}
+	if !got.PaidAt.Equal(want.PaidAt) {
+		t.Errorf("paidAt = %v, want %v", got.PaidAt, want.PaidAt)
+	}
+}
@@ -0,0 +1,281 @@
+package paygw
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/dimensions"
+)
+
+// Service turns callbacks into invoice payments.
+type Service struct {
+	DB *sql.DB
+}
+
+// Outcome reports what happened to a callback.
+type Outcome struct {
+	EventID       string
+	Status        string
+	Reason        string
+	InvoiceNumber string
+	PaymentID     string
+	JournalID     string
+	Duplicate     bool
+}
+
+// Process stores a verified callback and, when it is a successful payment
+// that matches exactly one open invoice, records the payment and its
+// journal in the same transaction. Anything that cannot be matched safely is
+// queued for review. A callback seen before returns the earlier outcome; a
+// success for a transaction whose earlier callbacks were pending or failed
+// upgrades the stored event.
+func (s *Service) Process(ctx context.Context, ep *Endpoint, cb *Callback, payload []byte) (*Outcome, error) {
+	if cb.TransactionID == "" {
+		return nil, errors.New("callback has no provider transaction id")
+	}
+
+	event := &Event{
+		ID:               cashflow.NewID("pge"),
+		OrganizationID:   ep.OrganizationID,
+		Endpoint:         ep.Name,
+		Provider:         ep.Provider,
+		ProviderTxnID:    cb.TransactionID,
+		DepositAccountID: ep.DepositAccountID,
+		FeeAccountID:     ep.FeeAccountID,
+		PaymentMode:      ep.PaymentMode,
+	}
+	setCallback(event, cb)
+
+	var outcome *Outcome
+	err := db.InTx(ctx, s.DB, func(tx *sql.Tx) error {
+		event.Status = EventUnmatched
+		if err := insertEvent(ctx, tx, event, payload); err != nil {
+			return err
+		}
+		var err error
+		outcome, err = process(ctx, tx, event)
+		return err
+	})
+
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) && myErr.Number == 1062 {
+		return s.redelivered(ctx, ep, cb, payload)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return outcome, nil
+}
+
+// redelivered handles a callback for a transaction that already has an
+// event. It is a duplicate when the event was recorded or dismissed, or
+// when the callback repeats the stored status and payload. A pending or
+// failed callback arriving after the success is not applied. Anything else,
+// such as the success after a pending callback, replaces the stored
+// callback and is processed again.
+func (s *Service) redelivered(ctx context.Context, ep *Endpoint, cb *Callback, payload []byte) (*Outcome, error) {
+	var outcome *Outcome
+	err := db.InTx(ctx, s.DB, func(tx *sql.Tx) error {
+		event, stored, err := eventByTxnForUpdate(ctx, tx, ep.Provider, cb.TransactionID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case event.Status == EventRecorded || event.Status == EventDismissed,
+			event.CallbackStatus == cb.Status && stored == string(payload):
+			outcome = outcomeOf(event, "")
+			outcome.Duplicate = true
+			return nil
+		case event.CallbackStatus == StatusSuccess && cb.Status != StatusSuccess:
+			outcome = outcomeOf(event, "")
+			outcome.Reason = fmt.Sprintf("%s callback after success not applied", cb.Status)
+			return nil
+		}
+
+		setCallback(event, cb)
+		event.Reason = ""
+		if err := updateCallback(ctx, tx, event, payload); err != nil {
+			return err
+		}
+		outcome, err = process(ctx, tx, event)
+		return err
+	})
+	return outcome, err
+}
+
+// setCallback copies what the gateway reported into the event.
+func setCallback(event *Event, cb *Callback) {
+	event.Reference = cb.Reference
+	event.Amount = cb.Amount
+	event.Fee = cb.Fee
+	event.Currency = cb.Currency
+	event.CallbackStatus = cb.Status
+	event.PaidAt = cb.PaidAt
+	event.Payer = cb.Payer
+	event.ReceivedAt = time.Now()
+}
+
+// process ignores a callback that is not a success, and otherwise records
+// the payment against its invoice or leaves it unmatched for review.
+func process(ctx context.Context, tx *sql.Tx, event *Event) (*Outcome, error) {
+	if event.CallbackStatus != StatusSuccess {
+		event.Status = EventIgnored
+		event.Reason = "callback status " + event.CallbackStatus
+		return outcomeOf(event, ""), updateEvent(ctx, tx, event, "")
+	}
+
+	event.Status = EventUnmatched
+	inv, reason, err := matchInvoice(ctx, tx, event)
+	if err != nil {
+		return nil, err
+	}
+	if inv == nil {
+		event.Reason = reason
+		return outcomeOf(event, ""), updateEvent(ctx, tx, event, "")
+	}
+
+	if err := record(ctx, tx, event, inv); err != nil {
+		return nil, err
+	}
+	return outcomeOf(event, inv.InvoiceNumber), updateEvent(ctx, tx, event, "")
+}
+
+// Assign records a queued payment against an invoice chosen by a reviewer.
+func (s *Service) Assign(ctx context.Context, eventID, invoiceNumber, by string) (*Outcome, error) {
+	var outcome *Outcome
+	err := db.InTx(ctx, s.DB, func(tx *sql.Tx) error {
+		event, err := eventForUpdate(ctx, tx, eventID)
+		if err != nil {
+			return fmt.Errorf("event %s: %w", eventID, err)
+		}
+		if event.Status != EventUnmatched {
+			return fmt.Errorf("event %s is %s, not in the review queue", eventID, event.Status)
+		}
+
+		invoices, err := cashflow.FindInvoicesByReference(ctx, tx, event.OrganizationID, invoiceNumber)
+		if err != nil {
+			return err
+		}
+		if len(invoices) != 1 {
+			return fmt.Errorf("invoice %s: %d matches in organization %s", invoiceNumber, len(invoices), event.OrganizationID)
+		}
+		inv := invoices[0]
+		if reason := rejectReason(event, inv); reason != "" {
+			return errors.New(reason)
+		}
+
+		if err := record(ctx, tx, event, inv); err != nil {
+			return err
+		}
+		event.Reason = "assigned by " + by
+		outcome = outcomeOf(event, inv.InvoiceNumber)
+		return updateEvent(ctx, tx, event, by)
+	})
+	return outcome, err
+}
+
+// Dismiss takes an event out of the review queue without posting it, for
+// example when the payment was refunded at the gateway.
+func (s *Service) Dismiss(ctx context.Context, eventID, by, note string) error {
+	return db.InTx(ctx, s.DB, func(tx *sql.Tx) error {
+		event, err := eventForUpdate(ctx, tx, eventID)
+		if err != nil {
+			return fmt.Errorf("event %s: %w", eventID, err)
+		}
+		if event.Status != EventUnmatched {
+			return fmt.Errorf("event %s is %s, not in the review queue", eventID, event.Status)
+		}
+		event.Status = EventDismissed
+		event.Reason = strings.TrimSpace(note)
+		return updateEvent(ctx, tx, event, by)
+	})
+}
+
+func matchInvoice(ctx context.Context, tx *sql.Tx, event *Event) (*cashflow.Invoice, string, error) {
+	if strings.TrimSpace(event.Reference) == "" {
+		return nil, "callback has no merchant reference", nil
+	}
+	invoices, err := cashflow.FindInvoicesByReference(ctx, tx, event.OrganizationID, event.Reference)
+	if err != nil {
+		return nil, "", err
+	}
+	switch len(invoices) {
+	case 0:
+		return nil, fmt.Sprintf("no invoice matches reference %q", event.Reference), nil
+	case 1:
+	default:
+		return nil, fmt.Sprintf("reference %q matches %d invoices", event.Reference, len(invoices)), nil
+	}
+
+	if reason := rejectReason(event, invoices[0]); reason != "" {
+		return nil, reason, nil
+	}
+	return invoices[0], "", nil
+}
+
+// rejectReason explains why a payment cannot be applied to an invoice
+// automatically, or returns "".
+func rejectReason(event *Event, inv *cashflow.Invoice) string {
+	switch inv.Status {
+	case "draft", "void", "cancelled":
+		return fmt.Sprintf("invoice %s is %s", inv.InvoiceNumber, inv.Status)
+	}
+	if event.Currency != "" && !strings.EqualFold(event.Currency, inv.Currency) {
+		return fmt.Sprintf("payment currency %s does not match invoice currency %s", event.Currency, inv.Currency)
+	}
+	if inv.BalanceDue <= 0 {
+		return fmt.Sprintf("invoice %s is already paid", inv.InvoiceNumber)
+	}
+	if event.Amount > inv.BalanceDue {
+		return fmt.Sprintf("payment %s exceeds balance due %s on invoice %s", event.Amount, inv.BalanceDue, inv.InvoiceNumber)
+	}
+	if event.Fee > 0 && event.FeeAccountID == "" {
+		return "gateway fee reported but no fee account is configured"
+	}
+	return ""
+}
+
+func record(ctx context.Context, tx *sql.Tx, event *Event, inv *cashflow.Invoice) error {
+	result, err := cashflow.RecordInvoicePayment(ctx, tx, inv, cashflow.Payment{
+		PaymentNumber: fmt.Sprintf("PG-%s-%s", strings.ToUpper(event.Provider), event.ProviderTxnID),
+		Date:          event.PaidAt,
+		Amount:        event.Amount,
+		BankCharges:   event.Fee,
+		Mode:          event.PaymentMode,
+		DepositTo:     event.DepositAccountID,
+		ChargesTo:     event.FeeAccountID,
+		Reference:     event.ProviderTxnID,
+		Notes:         fmt.Sprintf("Received via %s (%s)", event.Provider, event.Endpoint),
+	})
+	if err != nil {
+		return err
+	}
+	origin := dimensions.Origin{WarehouseID: inv.Warehouse, BranchID: inv.BranchID, SalespersonID: inv.SalespersonID}
+	if err := dimensions.TagJournal(ctx, tx, inv.OrganizationID, result.JournalID, origin, nil); err != nil {
+		return err
+	}
+	event.Status = EventRecorded
+	event.InvoiceID = inv.ID
+	event.PaymentID = result.PaymentID
+	event.JournalID = result.JournalID
+	return nil
+}
+
+func outcomeOf(e *Event, invoiceNumber string) *Outcome {
+	return &Outcome{
+		EventID:       e.ID,
+		Status:        e.Status,
+		Reason:        e.Reason,
+		InvoiceNumber: invoiceNumber,
+		PaymentID:     e.PaymentID,
+		JournalID:     e.JournalID,
+	}
+}
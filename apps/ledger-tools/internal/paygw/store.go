@@ -0,0 +1,177 @@
+package paygw
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Event statuses.
+const (
+	EventRecorded  = "recorded"  // payment and journal created
+	EventUnmatched = "unmatched" // waiting in the review queue
+	EventIgnored   = "ignored"   // failed or pending callback, nothing to post
+	EventDismissed = "dismissed" // reviewed and deliberately not posted
+)
+
+// Tables are the cashflowdb tables owned by the receiver. The unique key on
+// (provider, provider_txn_id) is what makes callbacks idempotent.
+var Tables = []schema.Table{
+	{
+		Name: "payment_gateway_events",
+		Create: `CREATE TABLE IF NOT EXISTS payment_gateway_events (
+  id VARCHAR(191) NOT NULL,
+  organization_id VARCHAR(191) NOT NULL,
+  endpoint VARCHAR(100) NOT NULL,
+  provider VARCHAR(50) NOT NULL,
+  provider_txn_id VARCHAR(191) NOT NULL,
+  reference VARCHAR(191) NULL,
+  amount DECIMAL(12,2) NOT NULL,
+  fee DECIMAL(12,2) NOT NULL DEFAULT 0.00,
+  currency VARCHAR(10) NULL,
+  callback_status VARCHAR(20) NOT NULL,
+  paid_at DATETIME(3) NOT NULL,
+  payer VARCHAR(191) NULL,
+  deposit_account_id VARCHAR(191) NOT NULL,
+  fee_account_id VARCHAR(191) NULL,
+  payment_mode VARCHAR(50) NOT NULL,
+  status VARCHAR(20) NOT NULL,
+  reason TEXT NULL,
+  invoice_id VARCHAR(191) NULL,
+  payment_id VARCHAR(191) NULL,
+  journal_id VARCHAR(191) NULL,
+  payload TEXT NOT NULL,
+  received_at DATETIME(3) NOT NULL,
+  resolved_at DATETIME(3) NULL,
+  resolved_by VARCHAR(191) NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY payment_gateway_events_provider_txn_unique (provider, provider_txn_id),
+  INDEX payment_gateway_events_org_status_idx (organization_id, status),
+  INDEX payment_gateway_events_invoice_idx (invoice_id)
+) ENGINE=InnoDB`,
+	},
+}
+
+// Event is a stored callback.
+type Event struct {
+	ID               string
+	OrganizationID   string
+	Endpoint         string
+	Provider         string
+	ProviderTxnID    string
+	Reference        string
+	Amount           money.Amount
+	Fee              money.Amount
+	Currency         string
+	CallbackStatus   string
+	PaidAt           time.Time
+	Payer            string
+	DepositAccountID string
+	FeeAccountID     string
+	PaymentMode      string
+	Status           string
+	Reason           string
+	InvoiceID        string
+	PaymentID        string
+	JournalID        string
+	ReceivedAt       time.Time
+}
+
+const eventColumns = `id, organization_id, endpoint, provider, provider_txn_id, COALESCE(reference, ''),
+	amount, fee, COALESCE(currency, ''), callback_status, paid_at, COALESCE(payer, ''),
+	deposit_account_id, COALESCE(fee_account_id, ''), payment_mode, status, COALESCE(reason, ''),
+	COALESCE(invoice_id, ''), COALESCE(payment_id, ''), COALESCE(journal_id, ''), received_at`
+
+func scanEvent(row interface{ Scan(...any) error }) (*Event, error) {
+	e := &Event{}
+	err := row.Scan(&e.ID, &e.OrganizationID, &e.Endpoint, &e.Provider, &e.ProviderTxnID, &e.Reference,
+		&e.Amount, &e.Fee, &e.Currency, &e.CallbackStatus, &e.PaidAt, &e.Payer,
+		&e.DepositAccountID, &e.FeeAccountID, &e.PaymentMode, &e.Status, &e.Reason,
+		&e.InvoiceID, &e.PaymentID, &e.JournalID, &e.ReceivedAt)
+	return e, err
+}
+
+func insertEvent(ctx context.Context, q cashflow.Querier, e *Event, payload []byte) error {
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO payment_gateway_events (id, organization_id, endpoint, provider, provider_txn_id, reference,
+		  amount, fee, currency, callback_status, paid_at, payer, deposit_account_id, fee_account_id,
+		  payment_mode, status, payload, received_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.OrganizationID, e.Endpoint, e.Provider, e.ProviderTxnID, cashflow.NullString(e.Reference),
+		e.Amount, e.Fee, cashflow.NullString(e.Currency), e.CallbackStatus, e.PaidAt, cashflow.NullString(e.Payer),
+		e.DepositAccountID, cashflow.NullString(e.FeeAccountID), e.PaymentMode, e.Status, string(payload), e.ReceivedAt)
+	return err
+}
+
+func updateEvent(ctx context.Context, q cashflow.Querier, e *Event, resolvedBy string) error {
+	var resolvedAt sql.NullTime
+	if resolvedBy != "" {
+		resolvedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+	_, err := q.ExecContext(ctx, `
+		UPDATE payment_gateway_events
+		SET status = ?, reason = ?, invoice_id = ?, payment_id = ?, journal_id = ?,
+		    resolved_at = COALESCE(?, resolved_at), resolved_by = COALESCE(?, resolved_by)
+		WHERE id = ?`,
+		e.Status, cashflow.NullString(e.Reason), cashflow.NullString(e.InvoiceID), cashflow.NullString(e.PaymentID), cashflow.NullString(e.JournalID),
+		resolvedAt, cashflow.NullString(resolvedBy), e.ID)
+	return err
+}
+
+// eventByTxnForUpdate locks the event of a provider transaction and returns
+// it with its stored payload.
+func eventByTxnForUpdate(ctx context.Context, q cashflow.Querier, provider, txnID string) (*Event, string, error) {
+	e := &Event{}
+	var payload string
+	err := q.QueryRowContext(ctx, `SELECT `+eventColumns+`, payload FROM payment_gateway_events
+		WHERE provider = ? AND provider_txn_id = ? FOR UPDATE`, provider, txnID).
+		Scan(&e.ID, &e.OrganizationID, &e.Endpoint, &e.Provider, &e.ProviderTxnID, &e.Reference,
+			&e.Amount, &e.Fee, &e.Currency, &e.CallbackStatus, &e.PaidAt, &e.Payer,
+			&e.DepositAccountID, &e.FeeAccountID, &e.PaymentMode, &e.Status, &e.Reason,
+			&e.InvoiceID, &e.PaymentID, &e.JournalID, &e.ReceivedAt, &payload)
+	return e, payload, err
+}
+
+// updateCallback replaces the stored callback of an event that a later
+// callback for the same transaction supersedes.
+func updateCallback(ctx context.Context, q cashflow.Querier, e *Event, payload []byte) error {
+	_, err := q.ExecContext(ctx, `
+		UPDATE payment_gateway_events
+		SET reference = ?, amount = ?, fee = ?, currency = ?, callback_status = ?, paid_at = ?, payer = ?,
+		    payload = ?, received_at = ?
+		WHERE id = ?`,
+		cashflow.NullString(e.Reference), e.Amount, e.Fee, cashflow.NullString(e.Currency), e.CallbackStatus, e.PaidAt,
+		cashflow.NullString(e.Payer), string(payload), e.ReceivedAt, e.ID)
+	return err
+}
+
+func eventForUpdate(ctx context.Context, q cashflow.Querier, id string) (*Event, error) {
+	return scanEvent(q.QueryRowContext(ctx, `SELECT `+eventColumns+` FROM payment_gateway_events
+		WHERE id = ? FOR UPDATE`, id))
+}
+
+// Unmatched lists the review queue, oldest first. An empty organization id
+// lists every organization.
+func Unmatched(ctx context.Context, q cashflow.Querier, organizationID string) ([]*Event, error) {
+	rows, err := q.QueryContext(ctx, `SELECT `+eventColumns+` FROM payment_gateway_events
+		WHERE status = ? AND (? = '' OR organization_id = ?)
+		ORDER BY received_at`, EventUnmatched, organizationID, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Event
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
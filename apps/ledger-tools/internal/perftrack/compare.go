@@ -0,0 +1,221 @@
+package perftrack
+
+import (
+	"math"
+	"sort"
+)
+
+// Verdicts of a comparison.
+const (
+	VerdictRegression  = "regression"
+	VerdictImprovement = "improvement"
+	VerdictUnchanged   = "unchanged"
+	VerdictNew         = "new"     // no baseline result
+	VerdictMissing     = "missing" // no result in the new run
+	VerdictFailed      = "failed"  // the workload errored on either side
+)
+
+// Thresholds decide when a difference is reported. A change must be both
+// significant, p below Alpha, and large, the median moving by at least
+// MinChange, since with enough samples a tiny shift is significant too.
+type Thresholds struct {
+	Alpha     float64
+	MinChange float64 // fraction of the baseline median
+}
+
+// DefaultThresholds flag changes of 10% or more at p < 0.01.
+var DefaultThresholds = Thresholds{Alpha: 0.01, MinChange: 0.10}
+
+// Change compares one workload between two runs.
+type Change struct {
+	Workload    string   `json:"workload"`
+	Verdict     string   `json:"verdict"`
+	BaseMedian  float64  `json:"baseMedianMs"`
+	HeadMedian  float64  `json:"headMedianMs"`
+	Ratio       float64  `json:"ratio"` // head over base median
+	P           float64  `json:"p"`
+	PlanChanged bool     `json:"planChanged"`
+	PlanDiff    []string `json:"planDiff,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// Compare compares every workload of two runs, in suite order with
+// workloads no longer in the suite last.
+func Compare(base, head *Run, th Thresholds) []*Change {
+	var names []string
+	seen := map[string]bool{}
+	for _, w := range workloads {
+		names = append(names, w.Name)
+		seen[w.Name] = true
+	}
+	for _, r := range append(append([]*Result{}, head.Results...), base.Results...) {
+		if !seen[r.Workload] {
+			names = append(names, r.Workload)
+			seen[r.Workload] = true
+		}
+	}
+
+	var out []*Change
+	for _, name := range names {
+		b, h := base.Result(name), head.Result(name)
+		if b == nil && h == nil {
+			continue
+		}
+		c := &Change{Workload: name}
+		out = append(out, c)
+		switch {
+		case h == nil:
+			c.Verdict = VerdictMissing
+			continue
+		case b == nil:
+			c.Verdict, c.HeadMedian = VerdictNew, Median(h.Samples)
+			continue
+		case b.Error != "" || h.Error != "":
+			c.Verdict, c.Error = VerdictFailed, h.Error
+			if c.Error == "" {
+				c.Error = "baseline: " + b.Error
+			}
+			continue
+		}
+
+		c.BaseMedian, c.HeadMedian = Median(b.Samples), Median(h.Samples)
+		if c.BaseMedian > 0 {
+			c.Ratio = c.HeadMedian / c.BaseMedian
+		}
+		c.P = MannWhitney(b.Samples, h.Samples)
+		c.Verdict = VerdictUnchanged
+		if c.P < th.Alpha && c.BaseMedian > 0 {
+			switch {
+			case c.Ratio >= 1+th.MinChange:
+				c.Verdict = VerdictRegression
+			case c.Ratio <= 1/(1+th.MinChange):
+				c.Verdict = VerdictImprovement
+			}
+		}
+		if diff := DiffLines(b.Plan, h.Plan); diff != nil {
+			c.PlanChanged, c.PlanDiff = true, diff
+		}
+	}
+	return out
+}
+
+// MannWhitney returns the two-sided p-value of the Mann-Whitney U test
+// that a and b come from the same distribution, using the normal
+// approximation with a tie correction. Timings are skewed and have
+// outliers, which a rank test tolerates where a t-test does not.
+func MannWhitney(a, b []float64) float64 {
+	n1, n2 := float64(len(a)), float64(len(b))
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	type sample struct {
+		v     float64
+		first bool
+	}
+	all := make([]sample, 0, len(a)+len(b))
+	for _, v := range a {
+		all = append(all, sample{v, true})
+	}
+	for _, v := range b {
+		all = append(all, sample{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	var rankSum, ties float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2 // average of ranks i+1..j
+		for k := i; k < j; k++ {
+			if all[k].first {
+				rankSum += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	n := n1 + n2
+	u := rankSum - n1*(n1+1)/2
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+	z := (math.Abs(u-mean) - 0.5) / math.Sqrt(variance) // continuity correction
+	if z < 0 {
+		z = 0
+	}
+	return math.Erfc(z / math.Sqrt2)
+}
+
+// Median returns the median of samples, 0 for none.
+func Median(v []float64) float64 {
+	if len(v) == 0 {
+		return 0
+	}
+	s := append([]float64{}, v...)
+	sort.Float64s(s)
+	if len(s)%2 == 1 {
+		return s[len(s)/2]
+	}
+	return (s[len(s)/2-1] + s[len(s)/2]) / 2
+}
+
+// DiffLines returns a line diff of two plans, each line prefixed with
+// "- ", "+ " or two spaces, or nil when they are equal.
+func DiffLines(a, b []string) []string {
+	if equalLines(a, b) {
+		return nil
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out = append(out, "  "+a[i])
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "- "+a[i])
+			i++
+		default:
+			out = append(out, "+ "+b[j])
+			j++
+		}
+	}
+	return out
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,171 @@
+package perftrack
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestMannWhitney(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float64
+		want float64
+	}{
+		{name: "separated", a: []float64{1, 2, 3, 4, 5}, b: []float64{6, 7, 8, 9, 10}, want: 0.0121858},
+		{name: "ties", a: []float64{1, 2, 2, 3, 3, 3}, b: []float64{2, 3, 4, 4, 5}, want: 0.0887137},
+		{name: "interleaved", a: []float64{10.1, 9.8, 10.3, 10.0, 9.9, 10.2},
+			b: []float64{10.05, 9.95, 10.25, 10.15, 9.85, 10.12}, want: 0.9361863},
+		{name: "same values", a: []float64{3, 1, 2}, b: []float64{2, 3, 1}, want: 1},
+		{name: "all tied", a: []float64{5, 5, 5}, b: []float64{5, 5}, want: 1},
+		{name: "no baseline", a: nil, b: []float64{1, 2}, want: 1},
+		{name: "no samples", a: []float64{1, 2}, b: nil, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MannWhitney(tt.a, tt.b); math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("MannWhitney = %.7f, want %.7f", got, tt.want)
+			}
+			if got, swapped := MannWhitney(tt.a, tt.b), MannWhitney(tt.b, tt.a); math.Abs(got-swapped) > 1e-12 {
+				t.Errorf("MannWhitney is not symmetric: %v and %v", got, swapped)
+			}
+		})
+	}
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		in   []float64
+		want float64
+	}{
+		{in: nil, want: 0},
+		{in: []float64{4}, want: 4},
+		{in: []float64{9, 1, 5}, want: 5},
+		{in: []float64{4, 1, 3, 2}, want: 2.5},
+	}
+	for _, tt := range tests {
+		if got := Median(tt.in); got != tt.want {
+			t.Errorf("Median(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []string
+	}{
+		{name: "equal", a: []string{"x", "y"}, b: []string{"x", "y"}, want: nil},
+		{name: "both empty", a: nil, b: []string{}, want: nil},
+		{name: "changed line", a: []string{"scan a", "ref b", "sort"}, b: []string{"scan a", "ALL b", "sort"},
+			want: []string{"  scan a", "- ref b", "+ ALL b", "  sort"}},
+		{name: "added", a: []string{"x"}, b: []string{"x", "y"}, want: []string{"  x", "+ y"}},
+		{name: "removed", a: []string{"x", "y"}, b: []string{"y"}, want: []string{"- x", "  y"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffLines(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffLines = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// samples returns n timings from start, step apart.
+func samples(start, step float64, n int) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = start + float64(i)*step
+	}
+	return out
+}
+
+func TestCompare(t *testing.T) {
+	base := &Run{Results: []*Result{
+		{Workload: "cf.trial_balance", Samples: samples(100, 1, 20)},
+		{Workload: "cf.journal_list", Samples: samples(100, 1, 20)},
+		{Workload: "cf.account_ledger", Samples: samples(100, 0.01, 20)},
+		{Workload: "cf.ar_aging", Samples: samples(100, 1, 20), Plan: []string{"ALL invoices"}},
+		{Workload: "oa.balances_as_of", Samples: samples(100, 1, 20)},
+		{Workload: "cf.inventory_valuation", Error: "table missing"},
+		{Workload: "retired.workload", Samples: samples(1, 1, 5)},
+	}}
+	head := &Run{Results: []*Result{
+		{Workload: "added.workload", Samples: samples(10, 1, 5)},
+		{Workload: "cf.trial_balance", Samples: samples(150, 1.5, 20)},
+		{Workload: "cf.journal_list", Samples: samples(50, 0.5, 20)},
+		{Workload: "cf.account_ledger", Samples: samples(105, 0.01, 20)},
+		{Workload: "cf.ar_aging", Samples: samples(100.5, 1, 20), Plan: []string{"ref invoices"}},
+		{Workload: "cf.inventory_valuation", Samples: samples(100, 1, 20)},
+	}}
+
+	got := map[string]*Change{}
+	var order []string
+	for _, c := range Compare(base, head, DefaultThresholds) {
+		got[c.Workload] = c
+		order = append(order, c.Workload)
+	}
+
+	wantOrder := []string{"cf.trial_balance", "cf.profit_and_loss_monthly", "cf.account_ledger", "cf.journal_list",
+		"cf.ar_aging", "cf.inventory_valuation", "oa.balances_as_of", "added.workload", "retired.workload"}
+	var present []string
+	for _, name := range wantOrder {
+		if got[name] != nil {
+			present = append(present, name)
+		}
+	}
+	if !reflect.DeepEqual(order, present) {
+		t.Errorf("order = %v, want %v", order, present)
+	}
+	if got["cf.profit_and_loss_monthly"] != nil {
+		t.Errorf("a workload in neither run was compared")
+	}
+
+	tests := []struct {
+		workload    string
+		verdict     string
+		planChanged bool
+		err         string
+	}{
+		{workload: "cf.trial_balance", verdict: VerdictRegression},
+		{workload: "cf.journal_list", verdict: VerdictImprovement},
+		// Significant but below MinChange.
+		{workload: "cf.account_ledger", verdict: VerdictUnchanged},
+		{workload: "cf.ar_aging", verdict: VerdictUnchanged, planChanged: true},
+		{workload: "cf.inventory_valuation", verdict: VerdictFailed, err: "baseline: table missing"},
+		{workload: "oa.balances_as_of", verdict: VerdictMissing},
+		{workload: "added.workload", verdict: VerdictNew},
+		{workload: "retired.workload", verdict: VerdictMissing},
+	}
+	for _, tt := range tests {
+		c := got[tt.workload]
+		if c == nil {
+			t.Errorf("%s: not compared", tt.workload)
+			continue
+		}
+		if c.Verdict != tt.verdict || c.PlanChanged != tt.planChanged || c.Error != tt.err {
+			t.Errorf("%s: verdict %s, plan changed %v, error %q; want %s, %v, %q",
+				tt.workload, c.Verdict, c.PlanChanged, c.Error, tt.verdict, tt.planChanged, tt.err)
+		}
+	}
+
+	if c := got["cf.trial_balance"]; c.BaseMedian != 109.5 || c.HeadMedian != 164.25 || math.Abs(c.Ratio-1.5) > 1e-9 || c.P >= 0.01 {
+		t.Errorf("cf.trial_balance: base %v, head %v, ratio %v, p %v", c.BaseMedian, c.HeadMedian, c.Ratio, c.P)
+	}
+	if c := got["added.workload"]; c.HeadMedian != 12 {
+		t.Errorf("added.workload: head median %v, want 12", c.HeadMedian)
+	}
+	if diff := got["cf.ar_aging"].PlanDiff; !reflect.DeepEqual(diff, []string{"- ALL invoices", "+ ref invoices"}) {
+		t.Errorf("cf.ar_aging plan diff = %q", diff)
+	}
+}
+
+func TestCompareHeadError(t *testing.T) {
+	base := &Run{Results: []*Result{{Workload: "cf.trial_balance", Samples: samples(100, 1, 20)}}}
+	head := &Run{Results: []*Result{{Workload: "cf.trial_balance", Error: "deadlock"}}}
+	changes := Compare(base, head, DefaultThresholds)
+	if len(changes) != 1 || changes[0].Verdict != VerdictFailed || changes[0].Error != "deadlock" {
+		t.Fatalf("Compare = %+v, want one failed change with the head error", changes)
+	}
+}
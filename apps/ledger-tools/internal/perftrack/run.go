@@ -0,0 +1,144 @@
+package perftrack
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Options control how often each workload runs.
+type Options struct {
+	Warmup int // unrecorded iterations first, to fill the buffer pool
+	Runs   int // recorded iterations
+}
+
+// Result is one workload's timings in a run.
+type Result struct {
+	Workload string    `json:"workload"`
+	Samples  []float64 `json:"samples"` // milliseconds
+	Rows     int       `json:"rows"`    // rows read per iteration
+	Plan     []string  `json:"plan,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Measure runs a workload Warmup plus Runs times on conn and records the
+// wall time of the recorded ones. Read workloads time the query and
+// reading every row; posting workloads time the writes only, not the
+// rollback. A failing workload returns a Result with Error set, so one
+// broken query does not end the run.
+func Measure(ctx context.Context, conn *sql.DB, w *Workload, t *Target, opts Options) *Result {
+	r := &Result{Workload: w.Name}
+	if w.Query != "" {
+		plan, err := Explain(ctx, conn, w.Query, w.Args(t)...)
+		if err != nil {
+			r.Error = fmt.Sprintf("explain: %v", err)
+			return r
+		}
+		r.Plan = plan
+	}
+	for i := 0; i < opts.Warmup+opts.Runs; i++ {
+		var elapsed time.Duration
+		var err error
+		if w.Query != "" {
+			elapsed, r.Rows, err = timeQuery(ctx, conn, w.Query, w.Args(t))
+		} else {
+			elapsed, err = timePost(ctx, conn, w, t, i)
+		}
+		if err != nil {
+			r.Error = err.Error()
+			r.Samples = nil
+			return r
+		}
+		if i >= opts.Warmup {
+			r.Samples = append(r.Samples, float64(elapsed.Microseconds())/1000)
+		}
+	}
+	return r
+}
+
+func timeQuery(ctx context.Context, conn *sql.DB, query string, args []any) (time.Duration, int, error) {
+	start := time.Now()
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, 0, err
+	}
+	dest := make([]any, len(cols))
+	for i := range dest {
+		dest[i] = new(sql.RawBytes)
+	}
+	n := 0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return 0, 0, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	return time.Since(start), n, nil
+}
+
+func timePost(ctx context.Context, conn *sql.DB, w *Workload, t *Target, i int) (time.Duration, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	start := time.Now()
+	if err := w.Post(ctx, tx, t, i); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// planColumns are the EXPLAIN columns a plan line keeps. rows and
+// filtered are the optimizer's estimates; they drift with the statistics
+// and would make every plan look changed.
+var planColumns = []string{"select_type", "table", "type", "possible_keys", "key", "key_len", "ref", "Extra"}
+
+// Explain returns a query's plan, one line per EXPLAIN row.
+func Explain(ctx context.Context, conn *sql.DB, query string, args ...any) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, "EXPLAIN "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]sql.NullString, len(cols))
+	dest := make([]any, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	var plan []string
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		byName := map[string]string{}
+		for i, c := range cols {
+			v := "-"
+			if values[i].Valid && values[i].String != "" {
+				v = values[i].String
+			}
+			byName[c] = v
+		}
+		parts := make([]string, 0, len(planColumns))
+		for _, c := range planColumns {
+			parts = append(parts, strings.ToLower(c)+"="+byName[c])
+		}
+		plan = append(plan, strings.Join(parts, " "))
+	}
+	return plan, rows.Err()
+}
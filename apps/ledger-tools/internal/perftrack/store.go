@@ -0,0 +1,138 @@
+package perftrack
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Run is one benchmark run of the suite at a revision.
+type Run struct {
+	Revision  string    `json:"revision"`
+	Dirty     bool      `json:"dirty,omitempty"` // uncommitted changes in the tree
+	Dataset   string    `json:"dataset"`
+	StartedAt time.Time `json:"startedAt"`
+	Host      string    `json:"host"`
+	Options   Options   `json:"options"`
+	Results   []*Result `json:"results"`
+}
+
+// Label is the run's revision, marked when the tree was dirty.
+func (r *Run) Label() string {
+	if r.Dirty {
+		return r.Revision + "+dirty"
+	}
+	return r.Revision
+}
+
+// Result returns the run's result for a workload, or nil.
+func (r *Run) Result(workload string) *Result {
+	for _, res := range r.Results {
+		if res.Workload == workload {
+			return res
+		}
+	}
+	return nil
+}
+
+// Append adds a run to a results file, one JSON object per line, creating
+// the file and its directory.
+func Append(path string, r *Run) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load reads every run in a results file, oldest first. A missing file
+// has no runs.
+func Load(path string) ([]*Run, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var runs []*Run
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 1<<20), 64<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		r := &Run{}
+		if err := json.Unmarshal(sc.Bytes(), r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		runs = append(runs, r)
+	}
+	return runs, sc.Err()
+}
+
+// Latest returns the newest run of a revision, or of any revision when it
+// is empty. Revisions match by prefix, so short hashes work.
+func Latest(runs []*Run, revision string) *Run {
+	for i := len(runs) - 1; i >= 0; i-- {
+		if revision == "" || strings.HasPrefix(runs[i].Revision, revision) {
+			return runs[i]
+		}
+	}
+	return nil
+}
+
+// Previous returns the newest run of a revision other than head's, the
+// default baseline.
+func Previous(runs []*Run, head *Run) *Run {
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].Revision != head.Revision {
+			return runs[i]
+		}
+	}
+	return nil
+}
+
+// Revision returns the git commit checked out in dir and whether the tree
+// has uncommitted changes.
+func Revision(dir string) (string, bool, error) {
+	out, err := git(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", false, err
+	}
+	status, err := git(dir, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return "", false, err
+	}
+	return out, status != "", nil
+}
+
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,256 @@
+// Package perftrack benchmarks a fixed set of database workloads, the
+// report queries and postings the apps run most, against a seeded
+// organization, keeps the timings per git revision, and flags revisions
+// that made a workload significantly slower, with the EXPLAIN plans of
+// both sides.
+package perftrack
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/seed"
+)
+
+// Databases a workload runs against.
+const (
+	DBCashflow = "cashflow"
+	DBOA       = "oa"
+)
+
+// Target is the seeded organization the workloads read and post to.
+type Target struct {
+	Dataset        string
+	OrganizationID string
+	OAOrgID        string
+	BankAccountID  string // cashflowdb ledger account
+	SalesAccountID string
+	OABank         string
+	OASales        string
+	OAUserID       string // author of posted OA transactions
+	// From and To are the year of history ending on the last seeded
+	// journal date.
+	From, To time.Time
+}
+
+// NewTarget resolves a seeded dataset in the databases, failing when it
+// has not been applied.
+func NewTarget(ctx context.Context, cf cashflow.Querier, s *seed.Seed) (*Target, error) {
+	t := &Target{Dataset: s.Dataset.Name, OrganizationID: s.OrganizationID, OAOrgID: s.OAOrgID}
+	if len(s.Users) > 0 {
+		t.OAUserID = s.Users[0].OAID
+	}
+	for _, a := range s.Accounts {
+		switch a.Code {
+		case "1000":
+			t.BankAccountID, t.OABank = a.ID, a.OAID
+		case "4000":
+			t.SalesAccountID, t.OASales = a.ID, a.OAID
+		}
+	}
+	if t.BankAccountID == "" || t.SalesAccountID == "" {
+		return nil, fmt.Errorf("dataset %s has no bank or sales account", t.Dataset)
+	}
+
+	var last sql.NullTime
+	if err := cf.QueryRowContext(ctx, `SELECT MAX(journalDate) FROM journals WHERE organizationId = ?`, t.OrganizationID).
+		Scan(&last); err != nil {
+		return nil, err
+	}
+	if !last.Valid {
+		return nil, fmt.Errorf("dataset %s is not applied; run seed apply -dataset %s", t.Dataset, t.Dataset)
+	}
+	t.To = last.Time
+	t.From = t.To.AddDate(-1, 0, 0)
+	return t, nil
+}
+
+// Workload is one benchmarked operation. Read workloads are a query whose
+// rows are all read, and whose plan is recorded; posting workloads write
+// in a transaction that is rolled back after each iteration.
+type Workload struct {
+	Name        string
+	DB          string
+	Description string
+	Query       string
+	Args        func(t *Target) []any
+	Post        func(ctx context.Context, tx *sql.Tx, t *Target, i int) error
+}
+
+// Workloads returns the fixed suite. Names are stable: results are
+// compared by name across revisions, so a changed workload gets a new one.
+func Workloads() []*Workload {
+	return workloads
+}
+
+// Lookup returns a workload by name.
+func Lookup(name string) (*Workload, error) {
+	for _, w := range workloads {
+		if w.Name == name {
+			return w, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown workload %q", name)
+}
+
+var workloads = []*Workload{
+	{
+		Name: "cf.trial_balance", DB: DBCashflow,
+		Description: "debit and credit totals per ledger account up to a date",
+		Query: `
+			SELECT je.accountId, SUM(je.debitAmount), SUM(je.creditAmount)
+			FROM journal_entries je JOIN journals j ON j.id = je.journalId
+			WHERE j.organizationId = ? AND j.status IN ` + cashflow.LiveJournalStatuses + ` AND j.journalDate <= ?
+			GROUP BY je.accountId`,
+		Args: func(t *Target) []any { return []any{t.OrganizationID, t.To} },
+	},
+	{
+		Name: "cf.profit_and_loss_monthly", DB: DBCashflow,
+		Description: "income and expense accounts by month over a year",
+		Query: `
+			SELECT DATE_FORMAT(j.journalDate, '%Y-%m') AS month, a.id, a.type,
+			       SUM(je.creditAmount - je.debitAmount)
+			FROM journal_entries je
+			JOIN journals j ON j.id = je.journalId
+			JOIN ledger_accounts a ON a.id = je.accountId
+			WHERE j.organizationId = ? AND j.status IN ` + cashflow.LiveJournalStatuses + `
+			  AND j.journalDate >= ? AND j.journalDate <= ?
+			  AND a.type IN ('income', 'other_income', 'expense', 'cost_of_goods_sold', 'other_expense')
+			GROUP BY month, a.id, a.type
+			ORDER BY month`,
+		Args: func(t *Target) []any { return []any{t.OrganizationID, t.From, t.To} },
+	},
+	{
+		Name: "cf.account_ledger", DB: DBCashflow,
+		Description: "the bank account's entries over a year, in date order",
+		Query: `
+			SELECT j.journalDate, j.journalNumber, je.description, je.debitAmount, je.creditAmount
+			FROM journal_entries je JOIN journals j ON j.id = je.journalId
+			WHERE je.accountId = ? AND j.status IN ` + cashflow.LiveJournalStatuses + `
+			  AND j.journalDate >= ? AND j.journalDate <= ?
+			ORDER BY j.journalDate, j.journalNumber`,
+		Args: func(t *Target) []any { return []any{t.BankAccountID, t.From, t.To} },
+	},
+	{
+		Name: "cf.journal_list", DB: DBCashflow,
+		Description: "the first page of the journal list",
+		Query: `
+			SELECT id, journalNumber, journalDate, reference, totalDebit, status
+			FROM journals WHERE organizationId = ?
+			ORDER BY journalDate DESC, createdAt DESC LIMIT 50`,
+		Args: func(t *Target) []any { return []any{t.OrganizationID} },
+	},
+	{
+		Name: "cf.ar_aging", DB: DBCashflow,
+		Description: "open invoices bucketed by days past due",
+		Query: `
+			SELECT customerId,
+			       SUM(CASE WHEN DATEDIFF(?, dueDate) <= 0 THEN balanceDue ELSE 0 END),
+			       SUM(CASE WHEN DATEDIFF(?, dueDate) BETWEEN 1 AND 30 THEN balanceDue ELSE 0 END),
+			       SUM(CASE WHEN DATEDIFF(?, dueDate) BETWEEN 31 AND 60 THEN balanceDue ELSE 0 END),
+			       SUM(CASE WHEN DATEDIFF(?, dueDate) > 60 THEN balanceDue ELSE 0 END)
+			FROM invoices
+			WHERE organizationId = ? AND balanceDue > 0 AND status NOT IN ('draft', 'void')
+			GROUP BY customerId`,
+		Args: func(t *Target) []any { return []any{t.To, t.To, t.To, t.To, t.OrganizationID} },
+	},
+	{
+		Name: "cf.inventory_valuation", DB: DBCashflow,
+		Description: "remaining FIFO layer value per product and warehouse",
+		Query: `
+			SELECT l.itemId, l.warehouseId, SUM(l.quantityRemaining), SUM(l.quantityRemaining * l.unitCost)
+			FROM inventory_layers l JOIN products p ON p.id = l.itemId
+			WHERE p.organizationId = ? AND l.quantityRemaining > 0
+			GROUP BY l.itemId, l.warehouseId`,
+		Args: func(t *Target) []any { return []any{t.OrganizationID} },
+	},
+	{
+		Name: "oa.balances_as_of", DB: DBOA,
+		Description: "every account's split total up to a date",
+		Query: `
+			SELECT LOWER(HEX(s.accountId)), SUM(s.nativeAmount)
+			FROM split s JOIN transaction t ON t.id = s.transactionId
+			WHERE t.orgId = UNHEX(?) AND t.deleted = false AND s.deleted = false AND s.date < ?
+			GROUP BY s.accountId`,
+		Args: func(t *Target) []any { return []any{t.OAOrgID, oa.Millis(t.To.AddDate(0, 0, 1))} },
+	},
+	{
+		Name: "oa.period_movements", DB: DBOA,
+		Description: "every account's split total over a year",
+		Query: `
+			SELECT LOWER(HEX(s.accountId)), SUM(s.nativeAmount)
+			FROM split s JOIN transaction t ON t.id = s.transactionId
+			WHERE t.orgId = UNHEX(?) AND t.deleted = false AND s.deleted = false AND s.date >= ? AND s.date < ?
+			GROUP BY s.accountId`,
+		Args: func(t *Target) []any {
+			return []any{t.OAOrgID, oa.Millis(t.From), oa.Millis(t.To.AddDate(0, 0, 1))}
+		},
+	},
+	{
+		Name: "oa.account_register", DB: DBOA,
+		Description: "the latest 100 splits of the bank account with their transactions",
+		Query: `
+			SELECT LOWER(HEX(t.id)), t.date, t.description, s.amount
+			FROM split s JOIN transaction t ON t.id = s.transactionId
+			WHERE s.accountId = UNHEX(?) AND t.deleted = false AND s.deleted = false
+			ORDER BY s.date DESC, s.id DESC LIMIT 100`,
+		Args: func(t *Target) []any { return []any{t.OABank} },
+	},
+	{
+		Name: "oa.recent_transactions", DB: DBOA,
+		Description: "the first page of the transaction list",
+		Query: `
+			SELECT LOWER(HEX(id)), date, description, data
+			FROM transaction WHERE orgId = UNHEX(?) AND deleted = false
+			ORDER BY date DESC, inserted DESC LIMIT 50`,
+		Args: func(t *Target) []any { return []any{t.OAOrgID} },
+	},
+	{
+		Name: "cf.post_journal", DB: DBCashflow,
+		Description: "a two-line journal through cashflow.PostJournal",
+		Post: func(ctx context.Context, tx *sql.Tx, t *Target, i int) error {
+			amount := money.FromFloat(1000)
+			return cashflow.PostJournal(ctx, tx, &cashflow.Journal{
+				OrganizationID: t.OrganizationID,
+				Number:         fmt.Sprintf("PERF-%d-%d", time.Now().UnixNano(), i),
+				Date:           t.To,
+				Notes:          "perftrack",
+				Lines: []cashflow.JournalLine{
+					{AccountID: t.BankAccountID, Debit: amount},
+					{AccountID: t.SalesAccountID, Credit: amount},
+				},
+			})
+		},
+	},
+	{
+		Name: "oa.post_transaction", DB: DBOA,
+		Description: "an OA transaction with two splits, as the OA server writes it",
+		Post: func(ctx context.Context, tx *sql.Tx, t *Target, i int) error {
+			id := oa.NewTransactionID()
+			date, now := oa.Millis(t.To), oa.Millis(time.Now())
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO transaction (id, orgId, userId, date, inserted, updated, description, data, deleted)
+				VALUES (UNHEX(?), UNHEX(?), UNHEX(?), ?, ?, ?, 'perftrack', '', false)`,
+				id, t.OAOrgID, t.OAUserID, date, now, now); err != nil {
+				return err
+			}
+			for _, s := range []struct {
+				account string
+				amount  int64
+			}{{t.OABank, 100000}, {t.OASales, -100000}} {
+				if _, err := tx.ExecContext(ctx, `
+					INSERT INTO split (transactionId, accountId, date, inserted, updated, amount, nativeAmount, deleted)
+					VALUES (UNHEX(?), UNHEX(?), ?, ?, ?, ?, ?, false)`,
+					id, s.account, date, now, now, s.amount, s.amount); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
@@ -0,0 +1,478 @@
+// Package pos imports end-of-day POS exports into cashflowdb: one summarized
+// sales journal or invoice per batch and branch, FIFO stock consumption per
+// warehouse, payment-method splits to bank and cash accounts, and a variance
+// report comparing what the POS declared with what was posted.
+package pos
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Batch is one POS close: a branch's sales for a business day, aggregated
+// per SKU and payment method whatever the file layout was.
+type Batch struct {
+	ID        string
+	Branch    string // POS branch code
+	Warehouse string // POS warehouse code, optional
+	Date      time.Time
+	Receipts  int
+	Lines     []Line
+	Payments  []Tender
+	// Declared holds the totals the POS reported itself, when the export
+	// carries them.
+	Declared *Totals
+}
+
+// Line is the day's sales of one SKU.
+type Line struct {
+	SKU      string
+	Quantity float64
+	Amount   money.Amount // net of tax
+	Tax      money.Amount
+}
+
+// Tender is the day's takings of one payment method.
+type Tender struct {
+	Method string
+	Amount money.Amount
+}
+
+// Totals are batch-level totals.
+type Totals struct {
+	Sales    money.Amount `json:"sales"`
+	Tax      money.Amount `json:"tax"`
+	Payments money.Amount `json:"payments"`
+	Receipts int          `json:"receipts"`
+}
+
+// Computed returns the totals of the batch's lines and tenders.
+func (b *Batch) Computed() Totals {
+	t := Totals{Receipts: b.Receipts}
+	for _, l := range b.Lines {
+		t.Sales += l.Amount
+		t.Tax += l.Tax
+	}
+	for _, p := range b.Payments {
+		t.Payments += p.Amount
+	}
+	return t
+}
+
+// Hash fingerprints the batch content so a re-sent batch id with different
+// figures is caught instead of silently skipped.
+func (b *Batch) Hash() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s|%s|%s|%d\n", b.ID, b.Branch, b.Warehouse, b.Date.Format(time.DateOnly), b.Receipts)
+	for _, l := range b.Lines {
+		fmt.Fprintf(h, "L|%s|%.4f|%s|%s\n", l.SKU, l.Quantity, l.Amount, l.Tax)
+	}
+	for _, p := range b.Payments {
+		fmt.Fprintf(h, "P|%s|%s\n", p.Method, p.Amount)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Formats understood by Parse.
+const (
+	FormatReceiptCSV = "receipt-csv"
+	FormatSummaryCSV = "summary-csv"
+	FormatJSON       = "json"
+)
+
+// DetectFormat picks a format from the file name and its first bytes.
+func DetectFormat(name string, head []byte) (string, error) {
+	trimmed := strings.TrimSpace(string(head))
+	if strings.EqualFold(filepath.Ext(name), ".json") || strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		return FormatJSON, nil
+	}
+	firstLine, _, _ := strings.Cut(trimmed, "\n")
+	header := strings.ToLower(firstLine)
+	switch {
+	case strings.Contains(header, "record_type"):
+		return FormatSummaryCSV, nil
+	case strings.Contains(header, "receipt"):
+		return FormatReceiptCSV, nil
+	}
+	return "", fmt.Errorf("%s: cannot tell the POS export format from its header", name)
+}
+
+// Parse reads a POS export. A file may hold several batches, for example one
+// per branch.
+func Parse(format string, r io.Reader) ([]*Batch, error) {
+	switch format {
+	case FormatReceiptCSV:
+		return parseReceiptCSV(r)
+	case FormatSummaryCSV:
+		return parseSummaryCSV(r)
+	case FormatJSON:
+		return parseJSON(r)
+	}
+	return nil, fmt.Errorf("unknown POS format %q", format)
+}
+
+// builder accumulates one batch while a file is read.
+type builder struct {
+	batch    *Batch
+	lines    map[string]*Line
+	tenders  map[string]*Tender
+	receipts map[string]bool
+	declared *Totals
+}
+
+func newBuilder(id, branch, warehouse string, date time.Time) *builder {
+	return &builder{
+		batch:    &Batch{ID: id, Branch: branch, Warehouse: warehouse, Date: date},
+		lines:    map[string]*Line{},
+		tenders:  map[string]*Tender{},
+		receipts: map[string]bool{},
+	}
+}
+
+func (b *builder) addLine(sku string, qty float64, amount, tax money.Amount) {
+	l, ok := b.lines[sku]
+	if !ok {
+		l = &Line{SKU: sku}
+		b.lines[sku] = l
+	}
+	l.Quantity += qty
+	l.Amount += amount
+	l.Tax += tax
+}
+
+func (b *builder) addTender(method string, amount money.Amount) {
+	method = strings.ToUpper(strings.TrimSpace(method))
+	t, ok := b.tenders[method]
+	if !ok {
+		t = &Tender{Method: method}
+		b.tenders[method] = t
+	}
+	t.Amount += amount
+}
+
+func (b *builder) declare() *Totals {
+	if b.declared == nil {
+		b.declared = &Totals{}
+	}
+	return b.declared
+}
+
+func (b *builder) build() *Batch {
+	out := b.batch
+	for _, l := range b.lines {
+		out.Lines = append(out.Lines, *l)
+	}
+	sort.Slice(out.Lines, func(i, j int) bool { return out.Lines[i].SKU < out.Lines[j].SKU })
+	for _, t := range b.tenders {
+		out.Payments = append(out.Payments, *t)
+	}
+	sort.Slice(out.Payments, func(i, j int) bool { return out.Payments[i].Method < out.Payments[j].Method })
+	if len(b.receipts) > 0 {
+		out.Receipts = len(b.receipts)
+	}
+	out.Declared = b.declared
+	return out
+}
+
+// batchSet keeps builders in file order, keyed by batch id.
+type batchSet struct {
+	order    []string
+	builders map[string]*builder
+}
+
+func (s *batchSet) get(id, branch, warehouse string, date time.Time, line int) (*builder, error) {
+	if id == "" || branch == "" {
+		return nil, fmt.Errorf("line %d: batch_id and branch are required", line)
+	}
+	if s.builders == nil {
+		s.builders = map[string]*builder{}
+	}
+	b, ok := s.builders[id]
+	if !ok {
+		b = newBuilder(id, branch, warehouse, date)
+		s.builders[id] = b
+		s.order = append(s.order, id)
+		return b, nil
+	}
+	if b.batch.Branch != branch || !b.batch.Date.Equal(date) {
+		return nil, fmt.Errorf("line %d: batch %s mixes branches or business dates", line, id)
+	}
+	return b, nil
+}
+
+func (s *batchSet) batches() []*Batch {
+	out := make([]*Batch, 0, len(s.order))
+	for _, id := range s.order {
+		out = append(out, s.builders[id].build())
+	}
+	return out
+}
+
+// csvRows reads a CSV with a header row and yields each row as a map keyed
+// by lower-cased column name.
+func csvRows(r io.Reader, required []string, fn func(line int, row map[string]string) error) error {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff")))
+	}
+	for _, col := range required {
+		if !contains(header, col) {
+			return fmt.Errorf("missing column %q", col)
+		}
+	}
+
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		row := make(map[string]string, len(header))
+		empty := true
+		for i, v := range rec {
+			if i < len(header) {
+				row[header[i]] = strings.TrimSpace(v)
+				empty = empty && row[header[i]] == ""
+			}
+		}
+		if empty {
+			continue
+		}
+		if err := fn(line, row); err != nil {
+			return err
+		}
+	}
+}
+
+// parseReceiptCSV reads one row per receipt line:
+//
+//	batch_id,branch,warehouse,date,receipt,sku,quantity,amount,tax,payment_method
+//
+// Each row's amount plus tax is attributed to its payment method; split
+// tenders are exported as separate rows.
+func parseReceiptCSV(r io.Reader) ([]*Batch, error) {
+	var set batchSet
+	err := csvRows(r, []string{"batch_id", "branch", "date", "receipt", "sku", "quantity", "amount", "payment_method"},
+		func(line int, row map[string]string) error {
+			date, err := parseDate(row["date"])
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			b, err := set.get(row["batch_id"], row["branch"], row["warehouse"], date, line)
+			if err != nil {
+				return err
+			}
+			qty, amount, tax, err := parseFigures(row["quantity"], row["amount"], row["tax"])
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			if row["sku"] == "" || row["payment_method"] == "" {
+				return fmt.Errorf("line %d: sku and payment_method are required", line)
+			}
+			b.addLine(row["sku"], qty, amount, tax)
+			b.addTender(row["payment_method"], amount+tax)
+			b.receipts[row["receipt"]] = true
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return set.batches(), nil
+}
+
+// parseSummaryCSV reads a day summary with a record_type column:
+//
+//	batch_id,branch,warehouse,date,record_type,key,quantity,amount,tax
+//
+// item rows carry a SKU in key, payment rows a payment method, and total
+// rows one of sales, tax, payments or receipts with the figure in amount.
+func parseSummaryCSV(r io.Reader) ([]*Batch, error) {
+	var set batchSet
+	err := csvRows(r, []string{"batch_id", "branch", "date", "record_type", "key", "amount"},
+		func(line int, row map[string]string) error {
+			date, err := parseDate(row["date"])
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			b, err := set.get(row["batch_id"], row["branch"], row["warehouse"], date, line)
+			if err != nil {
+				return err
+			}
+			qty, amount, tax, err := parseFigures(row["quantity"], row["amount"], row["tax"])
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			key := row["key"]
+			switch strings.ToLower(row["record_type"]) {
+			case "item":
+				b.addLine(key, qty, amount, tax)
+			case "payment":
+				b.addTender(key, amount)
+			case "total":
+				d := b.declare()
+				switch strings.ToLower(key) {
+				case "sales":
+					d.Sales = amount
+				case "tax":
+					d.Tax = amount
+				case "payments":
+					d.Payments = amount
+				case "receipts":
+					d.Receipts = int(amount.Float())
+					b.batch.Receipts = d.Receipts
+				default:
+					return fmt.Errorf("line %d: unknown total %q", line, key)
+				}
+			default:
+				return fmt.Errorf("line %d: unknown record_type %q", line, row["record_type"])
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return set.batches(), nil
+}
+
+type jsonBatch struct {
+	BatchID   string `json:"batchId"`
+	Branch    string `json:"branch"`
+	Warehouse string `json:"warehouse"`
+	Date      string `json:"date"`
+	Receipts  []struct {
+		Number   string       `json:"number"`
+		Lines    []jsonLine   `json:"lines"`
+		Payments []jsonTender `json:"payments"`
+	} `json:"receipts"`
+	Items    []jsonLine   `json:"items"`
+	Payments []jsonTender `json:"payments"`
+	Totals   *Totals      `json:"totals"`
+}
+
+type jsonLine struct {
+	SKU      string       `json:"sku"`
+	Quantity float64      `json:"quantity"`
+	Amount   money.Amount `json:"amount"`
+	Tax      money.Amount `json:"tax"`
+}
+
+type jsonTender struct {
+	Method string       `json:"method"`
+	Amount money.Amount `json:"amount"`
+}
+
+// parseJSON reads either a single batch object or {"batches": [...]}. A
+// batch lists receipts with their lines and payments, or day-level items
+// and payments, plus optional declared totals.
+func parseJSON(r io.Reader) ([]*Batch, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Batches []jsonBatch `json:"batches"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Batches) == 0 {
+		var single jsonBatch
+		if err := json.Unmarshal(raw, &single); err != nil {
+			return nil, err
+		}
+		doc.Batches = []jsonBatch{single}
+	}
+
+	var set batchSet
+	for i, jb := range doc.Batches {
+		date, err := parseDate(jb.Date)
+		if err != nil {
+			return nil, fmt.Errorf("batch %d: %w", i+1, err)
+		}
+		b, err := set.get(jb.BatchID, jb.Branch, jb.Warehouse, date, i+1)
+		if err != nil {
+			return nil, err
+		}
+		for _, rc := range jb.Receipts {
+			b.receipts[rc.Number] = true
+			for _, l := range rc.Lines {
+				b.addLine(l.SKU, l.Quantity, l.Amount, l.Tax)
+			}
+			for _, p := range rc.Payments {
+				b.addTender(p.Method, p.Amount)
+			}
+		}
+		for _, l := range jb.Items {
+			b.addLine(l.SKU, l.Quantity, l.Amount, l.Tax)
+		}
+		for _, p := range jb.Payments {
+			b.addTender(p.Method, p.Amount)
+		}
+		if jb.Totals != nil {
+			d := *jb.Totals
+			b.declared = &d
+			if len(jb.Receipts) == 0 {
+				b.batch.Receipts = d.Receipts
+			}
+		}
+	}
+	return set.batches(), nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{time.DateOnly, "2006/01/02", "02/01/2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid business date %q", s)
+}
+
+func parseFigures(qty, amount, tax string) (float64, money.Amount, money.Amount, error) {
+	var q float64
+	if qty != "" {
+		v, err := strconv.ParseFloat(qty, 64)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid quantity %q", qty)
+		}
+		q = v
+	}
+	a, err := money.Parse(amount)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid amount %q", amount)
+	}
+	t, err := money.Parse(tax)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid tax %q", tax)
+	}
+	return q, a, t, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
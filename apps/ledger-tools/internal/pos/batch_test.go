@@ -0,0 +1,198 @@
+package pos
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+var mm = money.MustParse
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want string
+	}{
+		{name: "close.json", head: "", want: FormatJSON},
+		{name: "close.txt", head: "  {\"batchId\": \"B1\"}", want: FormatJSON},
+		{name: "close.txt", head: "[]", want: FormatJSON},
+		{name: "close.csv", head: "batch_id,branch,date,record_type,key,amount\nB1,...", want: FormatSummaryCSV},
+		{name: "close.csv", head: "Batch_ID,Branch,Date,Receipt,SKU,Quantity,Amount\n", want: FormatReceiptCSV},
+	}
+	for _, tt := range tests {
+		got, err := DetectFormat(tt.name, []byte(tt.head))
+		if err != nil || got != tt.want {
+			t.Errorf("DetectFormat(%q, %q) = %q, %v; want %q", tt.name, tt.head, got, err, tt.want)
+		}
+	}
+	if _, err := DetectFormat("close.csv", []byte("sku,qty\n")); err == nil {
+		t.Errorf("DetectFormat of an unknown header succeeded")
+	}
+}
+
+func TestParse(t *testing.T) {
+	day := time.Date(2025, 3, 14, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		want   []*Batch
+	}{
+		{
+			name:   "receipt lines aggregate per SKU and tender",
+			format: FormatReceiptCSV,
+			input: "\ufeffbatch_id,branch,warehouse,date,receipt,sku,quantity,amount,tax,payment_method\n" +
+				"B1,YGN1,W1,2025-03-14,R1,TEA,2,2000,100,cash\n" +
+				"B1,YGN1,W1,2025-03-14,R1,CAKE,1,3000,150,Cash\n" +
+				",,,,,,,,,\n" +
+				"B1,YGN1,W1,2025-03-14,R2,TEA,1,1000,50,KBZPAY\n",
+			want: []*Batch{{
+				ID: "B1", Branch: "YGN1", Warehouse: "W1", Date: day, Receipts: 2,
+				Lines: []Line{
+					{SKU: "CAKE", Quantity: 1, Amount: mm("3000"), Tax: mm("150")},
+					{SKU: "TEA", Quantity: 3, Amount: mm("3000"), Tax: mm("150")},
+				},
+				Payments: []Tender{{Method: "CASH", Amount: mm("5250")}, {Method: "KBZPAY", Amount: mm("1050")}},
+			}},
+		},
+		{
+			name:   "summary with declared totals",
+			format: FormatSummaryCSV,
+			input: "batch_id,branch,date,record_type,key,quantity,amount,tax\n" +
+				"B2,MDY1,14/03/2025,item,TEA,4,4000,200\n" +
+				"B2,MDY1,14/03/2025,payment,visa,,4200,\n" +
+				"B2,MDY1,14/03/2025,total,sales,,4000,\n" +
+				"B2,MDY1,14/03/2025,total,tax,,200,\n" +
+				"B2,MDY1,14/03/2025,total,payments,,4200,\n" +
+				"B2,MDY1,14/03/2025,total,receipts,,3,\n",
+			want: []*Batch{{
+				ID: "B2", Branch: "MDY1", Date: day, Receipts: 3,
+				Lines:    []Line{{SKU: "TEA", Quantity: 4, Amount: mm("4000"), Tax: mm("200")}},
+				Payments: []Tender{{Method: "VISA", Amount: mm("4200")}},
+				Declared: &Totals{Sales: mm("4000"), Tax: mm("200"), Payments: mm("4200"), Receipts: 3},
+			}},
+		},
+		{
+			name:   "one file, two branches",
+			format: FormatSummaryCSV,
+			input: "batch_id,branch,date,record_type,key,quantity,amount\n" +
+				"B3,YGN1,2025/03/14,item,TEA,1,1000\n" +
+				"B4,MDY1,2025/03/14,item,TEA,2,2000\n",
+			want: []*Batch{
+				{ID: "B3", Branch: "YGN1", Date: day, Lines: []Line{{SKU: "TEA", Quantity: 1, Amount: mm("1000")}}},
+				{ID: "B4", Branch: "MDY1", Date: day, Lines: []Line{{SKU: "TEA", Quantity: 2, Amount: mm("2000")}}},
+			},
+		},
+		{
+			name:   "json receipts",
+			format: FormatJSON,
+			input: `{"batchId": "B5", "branch": "YGN1", "date": "2025-03-14", "receipts": [
+				{"number": "R1", "lines": [{"sku": "TEA", "quantity": 2, "amount": 2000, "tax": "100"}],
+				 "payments": [{"method": "cash", "amount": 2100}]},
+				{"number": "R2", "lines": [{"sku": "TEA", "quantity": -1, "amount": -1000}],
+				 "payments": [{"method": "CASH", "amount": -1000}]}],
+				"totals": {"sales": 1000, "receipts": 9}}`,
+			want: []*Batch{{
+				ID: "B5", Branch: "YGN1", Date: day, Receipts: 2,
+				Lines:    []Line{{SKU: "TEA", Quantity: 1, Amount: mm("1000"), Tax: mm("100")}},
+				Payments: []Tender{{Method: "CASH", Amount: mm("1100")}},
+				Declared: &Totals{Sales: mm("1000"), Receipts: 9},
+			}},
+		},
+		{
+			name:   "json day items in a batch list",
+			format: FormatJSON,
+			input: `{"batches": [{"batchId": "B6", "branch": "YGN1", "date": "2025-03-14",
+				"items": [{"sku": "CAKE", "quantity": 1, "amount": 3000}],
+				"payments": [{"method": "CASH", "amount": 3000}], "totals": {"receipts": 4}}]}`,
+			want: []*Batch{{
+				ID: "B6", Branch: "YGN1", Date: day, Receipts: 4,
+				Lines:    []Line{{SKU: "CAKE", Quantity: 1, Amount: mm("3000")}},
+				Payments: []Tender{{Method: "CASH", Amount: mm("3000")}},
+				Declared: &Totals{Receipts: 4},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.format, strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse =\n%+v\nwant\n%+v", deref(got), deref(tt.want))
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		want   string
+	}{
+		{name: "missing column", format: FormatReceiptCSV, input: "batch_id,branch,date,receipt,sku,quantity,amount\n",
+			want: `missing column "payment_method"`},
+		{name: "bad date", format: FormatReceiptCSV,
+			input: "batch_id,branch,date,receipt,sku,quantity,amount,payment_method\nB1,YGN1,March 14,R1,TEA,1,10,CASH\n",
+			want:  `line 2: invalid business date "March 14"`},
+		{name: "bad amount", format: FormatReceiptCSV,
+			input: "batch_id,branch,date,receipt,sku,quantity,amount,payment_method\nB1,YGN1,2025-03-14,R1,TEA,1,ten,CASH\n",
+			want:  `line 2: invalid amount "ten"`},
+		{name: "no tender", format: FormatReceiptCSV,
+			input: "batch_id,branch,date,receipt,sku,quantity,amount,payment_method\nB1,YGN1,2025-03-14,R1,TEA,1,10,\n",
+			want:  "line 2: sku and payment_method are required"},
+		{name: "mixed dates", format: FormatSummaryCSV,
+			input: "batch_id,branch,date,record_type,key,amount\nB1,YGN1,2025-03-14,item,TEA,1\nB1,YGN1,2025-03-15,item,TEA,1\n",
+			want:  "line 3: batch B1 mixes branches or business dates"},
+		{name: "unknown record type", format: FormatSummaryCSV,
+			input: "batch_id,branch,date,record_type,key,amount\nB1,YGN1,2025-03-14,discount,TEA,1\n",
+			want:  `line 2: unknown record_type "discount"`},
+		{name: "unknown total", format: FormatSummaryCSV,
+			input: "batch_id,branch,date,record_type,key,amount\nB1,YGN1,2025-03-14,total,cash,1\n",
+			want:  `line 2: unknown total "cash"`},
+		{name: "json without branch", format: FormatJSON, input: `{"batchId": "B1", "date": "2025-03-14"}`,
+			want: "line 1: batch_id and branch are required"},
+		{name: "unknown format", format: "xml", want: `unknown POS format "xml"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.format, strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHash(t *testing.T) {
+	parse := func(amount string) *Batch {
+		batches, err := Parse(FormatSummaryCSV, strings.NewReader(
+			"batch_id,branch,date,record_type,key,quantity,amount\nB1,YGN1,2025-03-14,item,TEA,1,"+amount+"\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return batches[0]
+	}
+	if parse("1000").Hash() != parse(`"1,000.00"`).Hash() {
+		t.Errorf("the same figures hash differently")
+	}
+	if parse("1000").Hash() == parse("1000.01").Hash() {
+		t.Errorf("different figures hash the same")
+	}
+}
+
+func deref(batches []*Batch) []Batch {
+	out := make([]Batch, len(batches))
+	for i, b := range batches {
+		out[i] = *b
+	}
+	return out
+}
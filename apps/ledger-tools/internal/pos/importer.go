@@ -0,0 +1,530 @@
+package pos
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/dimensions"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/inventory"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Importer posts POS batches for one organization.
+type Importer struct {
+	DB      *sql.DB
+	Mapping *Mapping
+	// AllowShortfall posts sales of stock the warehouse does not hold,
+	// costing the missing quantity at the product's cost price. Warehouses
+	// with allowNegativeInventory always allow it.
+	AllowShortfall bool
+	// DryRun runs the whole import and rolls it back.
+	DryRun     bool
+	SourceFile string
+	ImportedBy string
+}
+
+var errDryRun = errors.New("dry run")
+
+// Import posts one batch in a single transaction and returns its report.
+// A batch id that was already imported with the same content is reported as
+// a duplicate and not posted again; with different content it is a
+// conflict. The report is returned even when err is not nil.
+func (im *Importer) Import(ctx context.Context, b *Batch) (*Report, error) {
+	m := im.Mapping
+	rep := &Report{
+		BatchID:  b.ID,
+		Branch:   b.Branch,
+		Date:     b.Date.Format(time.DateOnly),
+		Mode:     m.Mode,
+		Receipts: b.Receipts,
+		Tenders:  b.Payments,
+		Declared: b.Declared,
+	}
+
+	if prev, err := GetRecord(ctx, im.DB, m.OrganizationID, b.ID); err == nil {
+		return im.previous(prev, b, rep)
+	} else if !errors.Is(err, cashflow.ErrNotFound) {
+		return rep, err
+	}
+
+	err := db.InTx(ctx, im.DB, func(tx *sql.Tx) error {
+		rec, branchID, warehouseID, err := im.post(ctx, tx, b, rep)
+		if err != nil {
+			return err
+		}
+		if im.DryRun {
+			return errDryRun
+		}
+		rep.Status = StatusPosted
+		return insertRecord(ctx, tx, rec, branchID, warehouseID)
+	})
+
+	var myErr *mysql.MySQLError
+	switch {
+	case err == nil:
+		return rep, nil
+	case errors.Is(err, errDryRun):
+		rep.Status = StatusDryRun
+		return rep, nil
+	case errors.As(err, &myErr) && myErr.Number == 1062:
+		// Another import of the same batch committed first.
+		if prev, lookupErr := GetRecord(ctx, im.DB, m.OrganizationID, b.ID); lookupErr == nil {
+			return im.previous(prev, b, rep)
+		}
+	}
+	rep.Status = StatusFailed
+	rep.Error = err.Error()
+	return rep, err
+}
+
+func (im *Importer) previous(prev *Record, b *Batch, rep *Report) (*Report, error) {
+	if prev.ContentHash != b.Hash() {
+		rep.Status = StatusConflict
+		rep.Error = fmt.Sprintf("batch %s was imported on %s with different content", b.ID, prev.ImportedAt.Format(time.DateTime))
+		return rep, errors.New(rep.Error)
+	}
+	out := *prev.Report
+	out.Status = StatusDuplicate
+	return &out, nil
+}
+
+type warehouse struct {
+	id            string
+	allowNegative bool
+}
+
+type product struct {
+	id                 string
+	name               string
+	trackInventory     bool
+	salesAccountID     string
+	inventoryAccountID string
+	costPrice          float64
+}
+
+// post writes the batch's documents, stock movements and variance report.
+func (im *Importer) post(ctx context.Context, tx *sql.Tx, b *Batch, rep *Report) (*Record, string, string, error) {
+	m := im.Mapping
+	org := m.OrganizationID
+
+	bm, ok := m.Branches[b.Branch]
+	if !ok {
+		return nil, "", "", fmt.Errorf("POS branch %q is not in the mapping", b.Branch)
+	}
+	wh, err := resolveWarehouse(ctx, tx, org, b.Warehouse, bm)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	rec := &Record{
+		ID:             cashflow.NewID("posb"),
+		OrganizationID: org,
+		BatchID:        b.ID,
+		BranchCode:     b.Branch,
+		BusinessDate:   b.Date,
+		Mode:           m.Mode,
+		ContentHash:    b.Hash(),
+		SourceFile:     im.SourceFile,
+		ImportedAt:     time.Now(),
+		ImportedBy:     im.ImportedBy,
+		Report:         rep,
+	}
+	reference := "POS " + b.Branch + " " + b.ID
+	// Layers received during the business day are available to its sales.
+	cutoff := b.Date.Add(24*time.Hour - time.Millisecond)
+
+	accounts := &accountResolver{ctx: ctx, q: tx, org: org}
+	revenue := map[string]money.Amount{}
+	cogs := map[string]money.Amount{}
+	var items []invoiceItem
+	var movements []string
+	shortfalls := 0
+
+	for _, l := range b.Lines {
+		rep.Sales += l.Amount
+		rep.Tax += l.Tax
+
+		p, err := productBySKU(ctx, tx, org, l.SKU)
+		if err != nil && !errors.Is(err, cashflow.ErrNotFound) {
+			return nil, "", "", err
+		}
+		if p == nil {
+			rep.add(VarianceUnknownSKU, l.SKU, l.Amount, "%g sold for %s; posted to the default revenue account without stock", l.Quantity, l.Amount)
+			p = &product{name: l.SKU}
+		}
+
+		revenueAccount, err := accounts.pick(m.RevenueAccountID, p.salesAccountID, "income")
+		if err != nil {
+			return nil, "", "", err
+		}
+		revenue[revenueAccount] += l.Amount
+		items = append(items, invoiceItem{line: l, product: p, accountID: revenueAccount})
+
+		if !p.trackInventory {
+			continue
+		}
+		if l.Quantity <= 0 {
+			if l.Quantity < 0 {
+				rep.add(VarianceReturn, l.SKU, 0, "net quantity %g returned; restock it with an adjustment", l.Quantity)
+			}
+			continue
+		}
+		if wh == nil {
+			return nil, "", "", fmt.Errorf("branch %s has no warehouse to consume %s from", b.Branch, l.SKU)
+		}
+
+		res, err := inventory.ConsumeFIFO(ctx, tx, inventory.Outbound{
+			ItemID:         p.id,
+			WarehouseID:    wh.id,
+			Quantity:       l.Quantity,
+			MovementType:   "sale",
+			SourceType:     "pos",
+			SourceID:       rec.ID,
+			Reference:      reference,
+			Date:           cutoff,
+			AllowShortfall: im.AllowShortfall || wh.allowNegative,
+		})
+		var short *inventory.ErrInsufficient
+		if errors.As(err, &short) {
+			rep.add(VarianceStockShortfall, l.SKU, 0, "sold %g, warehouse holds %g", short.Required, short.Available)
+			shortfalls++
+			continue
+		}
+		if err != nil {
+			return nil, "", "", fmt.Errorf("consume %s: %w", l.SKU, err)
+		}
+
+		cost := res.TotalCost
+		if res.Shortfall > 0 {
+			missing := money.FromFloat(res.Shortfall * p.costPrice)
+			cost += missing
+			rep.add(VarianceStockShortfall, l.SKU, missing, "%g sold without stock, costed at %g", res.Shortfall, p.costPrice)
+		}
+		for _, c := range res.Consumed {
+			movements = append(movements, c.MovementID)
+		}
+		inventoryAccount, err := accounts.pick(m.InventoryAccountID, p.inventoryAccountID, "stock")
+		if err != nil {
+			return nil, "", "", err
+		}
+		cogs[inventoryAccount] += cost
+		rep.COGS += cost
+	}
+	for _, t := range b.Payments {
+		rep.Payments += t.Amount
+		if _, ok := m.PaymentAccounts[t.Method]; !ok {
+			return nil, "", "", fmt.Errorf("payment method %q has no account in the mapping", t.Method)
+		}
+	}
+	rep.OverShort = rep.Payments - rep.Sales - rep.Tax
+	if rep.OverShort != 0 && m.OverShortAccountID == "" {
+		return nil, "", "", fmt.Errorf("tenders differ from sales by %s and the mapping has no overShortAccountId", rep.OverShort)
+	}
+	if rep.OverShort.Abs() > m.Tolerance {
+		rep.add(VarianceOverShort, "", rep.OverShort, "tenders %s against sales and tax %s", rep.Payments, rep.Sales+rep.Tax)
+	}
+	rep.compareDeclared(m.Tolerance)
+
+	if shortfalls > 0 {
+		return nil, "", "", fmt.Errorf("%d item(s) short of stock; import with -allow-shortfall to post anyway", shortfalls)
+	}
+
+	var taxAccount, cogsAccount string
+	if rep.Tax != 0 {
+		if taxAccount, err = accounts.pick(m.TaxAccountID, "", "output_tax"); err != nil {
+			return nil, "", "", err
+		}
+	}
+	if rep.COGS != 0 {
+		if cogsAccount, err = accounts.pick(m.COGSAccountID, "", "cost_of_goods_sold"); err != nil {
+			return nil, "", "", err
+		}
+	}
+
+	lines := sharedLines(b.Branch, rep, revenue, cogs, postingAccounts{tax: taxAccount, cogs: cogsAccount, overShort: m.OverShortAccountID})
+	warehouseID := ""
+	if wh != nil {
+		warehouseID = wh.id
+	}
+	number := fmt.Sprintf("POS-%s-%s", b.Branch, b.ID)
+	switch m.Mode {
+	case ModeJournal:
+		lines = append(lines, tenderLines(b.Branch, b.Payments, m.PaymentAccounts)...)
+		journal := &cashflow.Journal{
+			OrganizationID: org,
+			Number:         number,
+			Date:           b.Date,
+			Reference:      reference,
+			Notes:          fmt.Sprintf("POS daily sales %s, %d receipts", rep.Date, b.Receipts),
+			Lines:          lines,
+		}
+		if err := cashflow.PostJournal(ctx, tx, journal); err != nil {
+			return nil, "", "", err
+		}
+		origin := dimensions.Origin{WarehouseID: warehouseID, BranchID: bm.BranchID}
+		if err := dimensions.TagJournal(ctx, tx, org, journal.ID, origin, nil); err != nil {
+			return nil, "", "", err
+		}
+		rec.JournalID = journal.ID
+	case ModeInvoice:
+		inv, journalID, err := im.postInvoice(ctx, tx, b, rep, number, bm.BranchID, warehouseID, items, lines)
+		if err != nil {
+			return nil, "", "", err
+		}
+		rec.JournalID, rec.InvoiceID = journalID, inv.ID
+	}
+	if err := inventory.LinkJournal(ctx, tx, rec.JournalID, movements); err != nil {
+		return nil, "", "", err
+	}
+	rep.JournalID, rep.InvoiceID = rec.JournalID, rec.InvoiceID
+	return rec, bm.BranchID, warehouseID, nil
+}
+
+type invoiceItem struct {
+	line      Line
+	product   *product
+	accountID string
+}
+
+// postInvoice raises a confirmed walk-in invoice for the batch, posts its
+// journal (DR receivables against the shared lines), then settles it with
+// one payment per tender. Over/short goes on the invoice as an adjustment
+// so the tenders always clear it.
+func (im *Importer) postInvoice(ctx context.Context, tx *sql.Tx, b *Batch, rep *Report, number, branchID, warehouseID string,
+	items []invoiceItem, lines []cashflow.JournalLine) (*cashflow.Invoice, string, error) {
+	m := im.Mapping
+	arAccount, err := cashflow.AccountByType(ctx, tx, m.OrganizationID, "accounts_receivable")
+	if err != nil {
+		return nil, "", err
+	}
+	total := rep.Sales + rep.Tax + rep.OverShort
+
+	journal := &cashflow.Journal{
+		OrganizationID: m.OrganizationID,
+		Number:         "J-" + number,
+		Date:           b.Date,
+		Reference:      number,
+		Notes:          fmt.Sprintf("POS daily sales %s, %d receipts", rep.Date, b.Receipts),
+		Lines:          append([]cashflow.JournalLine{signed(arAccount, "POS invoice - "+number, total)}, lines...),
+	}
+	if err := cashflow.PostJournal(ctx, tx, journal); err != nil {
+		return nil, "", err
+	}
+	origin := dimensions.Origin{WarehouseID: warehouseID, BranchID: branchID}
+	if err := dimensions.TagJournal(ctx, tx, m.OrganizationID, journal.ID, origin, nil); err != nil {
+		return nil, "", err
+	}
+
+	inv := &cashflow.Invoice{
+		ID:             cashflow.NewID("invoice"),
+		OrganizationID: m.OrganizationID,
+		InvoiceNumber:  number,
+		CustomerID:     m.CustomerID,
+		Status:         "confirmed",
+		Currency:       m.Currency,
+		IssueDate:      b.Date,
+		TotalAmount:    total,
+		BalanceDue:     total,
+		BranchID:       branchID,
+		Warehouse:      warehouseID,
+	}
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO invoices (id, organizationId, invoiceNumber, customerId, issueDate, dueDate, status,
+		  subtotal, taxAmount, adjustment, totalAmount, paidAmount, balanceDue, currency, warehouse, branchId,
+		  subject, journalId, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		inv.ID, inv.OrganizationID, inv.InvoiceNumber, inv.CustomerID, b.Date, b.Date, inv.Status,
+		rep.Sales, rep.Tax, rep.OverShort, total, total, inv.Currency, cashflow.NullString(warehouseID), branchID,
+		fmt.Sprintf("POS daily sales %s", rep.Date), journal.ID, now, now)
+	if err != nil {
+		return nil, "", fmt.Errorf("insert invoice %s: %w", number, err)
+	}
+
+	for i, it := range items {
+		rate := it.line.Amount
+		if it.line.Quantity != 0 {
+			rate = money.FromFloat(it.line.Amount.Float() / it.line.Quantity)
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO invoice_items (id, invoiceId, productId, itemName, description, quantity, rate,
+			  taxAmount, amount, salesAccountId, createdAt, updatedAt)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			fmt.Sprintf("invoice_item_%d_%d", now.UnixMilli(), i), inv.ID, cashflow.NullString(it.product.id),
+			it.product.name, it.line.SKU, math.Round(it.line.Quantity*100)/100, rate, it.line.Tax, it.line.Amount,
+			it.accountID, now, now)
+		if err != nil {
+			return nil, "", fmt.Errorf("insert invoice item %s: %w", it.line.SKU, err)
+		}
+	}
+
+	for _, t := range b.Payments {
+		if t.Amount < 0 {
+			return nil, "", fmt.Errorf("invoice mode cannot settle a negative %s tender; use journal mode", t.Method)
+		}
+		if t.Amount == 0 {
+			continue
+		}
+		paid, err := cashflow.RecordInvoicePayment(ctx, tx, inv, cashflow.Payment{
+			PaymentNumber: fmt.Sprintf("%s-%s", number, t.Method),
+			Date:          b.Date,
+			Amount:        t.Amount,
+			Mode:          t.Method,
+			DepositTo:     m.PaymentAccounts[t.Method],
+			Reference:     number,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		if err := dimensions.TagJournal(ctx, tx, m.OrganizationID, paid.JournalID, origin, nil); err != nil {
+			return nil, "", err
+		}
+	}
+	return inv, journal.ID, nil
+}
+
+// postingAccounts are the accounts of the lines shared by both modes.
+type postingAccounts struct {
+	tax       string
+	cogs      string
+	overShort string
+}
+
+// sharedLines returns the lines posted in both modes: revenue per account,
+// tax, over/short and COGS against the inventory accounts. Without the
+// tenders or the receivable they are out of balance by the takings.
+func sharedLines(branch string, rep *Report, revenue, cogs map[string]money.Amount, acct postingAccounts) []cashflow.JournalLine {
+	var lines []cashflow.JournalLine
+	for _, id := range sortedKeys(revenue) {
+		lines = append(lines, signed(id, "POS sales - "+branch, -revenue[id]))
+	}
+	if rep.Tax != 0 {
+		lines = append(lines, signed(acct.tax, "POS output tax - "+branch, -rep.Tax))
+	}
+	if rep.OverShort != 0 {
+		lines = append(lines, signed(acct.overShort, "POS cash over/short - "+branch, -rep.OverShort))
+	}
+	if rep.COGS != 0 {
+		lines = append(lines, cashflow.JournalLine{AccountID: acct.cogs, Description: "Cost of goods sold - " + branch, Debit: rep.COGS})
+		for _, id := range sortedKeys(cogs) {
+			lines = append(lines, cashflow.JournalLine{AccountID: id, Description: "Inventory sold - " + branch, Credit: cogs[id]})
+		}
+	}
+	return lines
+}
+
+// tenderLines debits each tender to its payment account; journal mode
+// posts them instead of an invoice.
+func tenderLines(branch string, tenders []Tender, accounts map[string]string) []cashflow.JournalLine {
+	var lines []cashflow.JournalLine
+	for _, t := range tenders {
+		if t.Amount == 0 {
+			continue
+		}
+		lines = append(lines, signed(accounts[t.Method], "POS takings "+t.Method+" - "+branch, t.Amount))
+	}
+	return lines
+}
+
+// signed returns a debit line for a positive amount and a credit line for a
+// negative one.
+func signed(accountID, description string, amount money.Amount) cashflow.JournalLine {
+	l := cashflow.JournalLine{AccountID: accountID, Description: description}
+	if amount >= 0 {
+		l.Debit = amount
+	} else {
+		l.Credit = -amount
+	}
+	return l
+}
+
+func sortedKeys(m map[string]money.Amount) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// accountResolver picks the product's own account, then the mapped default,
+// then the organization's first account of a type, caching type lookups.
+type accountResolver struct {
+	ctx    context.Context
+	q      cashflow.Querier
+	org    string
+	byType map[string]string
+}
+
+func (r *accountResolver) pick(mapped, own, accountType string) (string, error) {
+	if own != "" {
+		return own, nil
+	}
+	if mapped != "" {
+		return mapped, nil
+	}
+	if id, ok := r.byType[accountType]; ok {
+		return id, nil
+	}
+	id, err := cashflow.AccountByType(r.ctx, r.q, r.org, accountType)
+	if err != nil {
+		return "", err
+	}
+	if r.byType == nil {
+		r.byType = map[string]string{}
+	}
+	r.byType[accountType] = id
+	return id, nil
+}
+
+func resolveWarehouse(ctx context.Context, q cashflow.Querier, org, code string, bm BranchMapping) (*warehouse, error) {
+	wh := &warehouse{}
+	var err error
+	switch {
+	case code != "":
+		err = q.QueryRowContext(ctx, `
+			SELECT id, allowNegativeInventory FROM warehouses
+			WHERE organizationId = ? AND (code = ? OR id = ?) AND isActive = true`, org, code, code).
+			Scan(&wh.id, &wh.allowNegative)
+	case bm.WarehouseID != "":
+		err = q.QueryRowContext(ctx, `
+			SELECT id, allowNegativeInventory FROM warehouses
+			WHERE organizationId = ? AND id = ? AND isActive = true`, org, bm.WarehouseID).
+			Scan(&wh.id, &wh.allowNegative)
+	default:
+		err = q.QueryRowContext(ctx, `
+			SELECT id, allowNegativeInventory FROM warehouses
+			WHERE organizationId = ? AND branchId = ? AND isActive = true
+			ORDER BY isDefault DESC, isPrimary DESC, createdAt LIMIT 1`, org, bm.BranchID).
+			Scan(&wh.id, &wh.allowNegative)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("warehouse %s%s: %w", code, bm.WarehouseID, cashflow.ErrNotFound)
+	}
+	return wh, err
+}
+
+func productBySKU(ctx context.Context, q cashflow.Querier, org, sku string) (*product, error) {
+	p := &product{}
+	err := q.QueryRowContext(ctx, `
+		SELECT id, name, trackInventory, COALESCE(salesAccountId, ''), COALESCE(inventoryAccountId, ''), costPrice
+		FROM products WHERE organizationId = ? AND sku = ? AND isActive = true
+		ORDER BY createdAt LIMIT 1`, org, sku).
+		Scan(&p.id, &p.name, &p.trackInventory, &p.salesAccountID, &p.inventoryAccountID, &p.costPrice)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, cashflow.ErrNotFound
+	}
+	return p, err
+}
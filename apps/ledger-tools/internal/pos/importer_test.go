@@ -0,0 +1,93 @@
+package pos
+
+import (
+	"testing"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+func TestJournalLinesBalance(t *testing.T) {
+	acct := postingAccounts{tax: "tax", cogs: "cogs", overShort: "overshort"}
+	payments := map[string]string{"CASH": "cash", "KBZPAY": "bank"}
+
+	tests := []struct {
+		name    string
+		rep     *Report
+		revenue map[string]money.Amount
+		cogs    map[string]money.Amount
+		tenders []Tender
+		lines   int
+	}{
+		{
+			name:    "exact takings",
+			rep:     &Report{Sales: mm("5000"), Tax: mm("250"), COGS: mm("3100")},
+			revenue: map[string]money.Amount{"sales": mm("3000"), "cakes": mm("2000")},
+			cogs:    map[string]money.Amount{"stock": mm("2000"), "stock2": mm("1100")},
+			tenders: []Tender{{Method: "CASH", Amount: mm("4000")}, {Method: "KBZPAY", Amount: mm("1250")}},
+			lines:   8,
+		},
+		{
+			name:    "till over",
+			rep:     &Report{Sales: mm("1000"), Payments: mm("1000.50"), OverShort: mm("0.50")},
+			revenue: map[string]money.Amount{"sales": mm("1000")},
+			tenders: []Tender{{Method: "CASH", Amount: mm("1000.50")}, {Method: "KBZPAY"}},
+			lines:   3,
+		},
+		{
+			name:    "till short",
+			rep:     &Report{Sales: mm("1000"), Tax: mm("50"), OverShort: mm("-20")},
+			revenue: map[string]money.Amount{"sales": mm("1000")},
+			tenders: []Tender{{Method: "CASH", Amount: mm("1030")}},
+			lines:   4,
+		},
+		{
+			name:    "refund day",
+			rep:     &Report{Sales: mm("-500"), COGS: mm("0")},
+			revenue: map[string]money.Amount{"sales": mm("-500")},
+			tenders: []Tender{{Method: "CASH", Amount: mm("-500")}},
+			lines:   2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := append(sharedLines("YGN1", tt.rep, tt.revenue, tt.cogs, acct), tenderLines("YGN1", tt.tenders, payments)...)
+			if len(lines) != tt.lines {
+				t.Errorf("got %d lines, want %d: %+v", len(lines), tt.lines, lines)
+			}
+			var debit, credit money.Amount
+			for _, l := range lines {
+				if l.Debit < 0 || l.Credit < 0 || (l.Debit != 0) == (l.Credit != 0) {
+					t.Errorf("line %+v is not one positive side", l)
+				}
+				debit += l.Debit
+				credit += l.Credit
+			}
+			if debit != credit {
+				t.Errorf("debits %s, credits %s", debit, credit)
+			}
+		})
+	}
+}
+
+func TestSharedLinesOrder(t *testing.T) {
+	rep := &Report{Sales: mm("30"), Tax: mm("3"), COGS: mm("12")}
+	revenue := map[string]money.Amount{"b-sales": mm("10"), "a-sales": mm("20")}
+	cogs := map[string]money.Amount{"stock": mm("12")}
+	lines := sharedLines("YGN1", rep, revenue, cogs, postingAccounts{tax: "tax", cogs: "cogs"})
+	want := []cashflow.JournalLine{
+		{AccountID: "a-sales", Description: "POS sales - YGN1", Credit: mm("20")},
+		{AccountID: "b-sales", Description: "POS sales - YGN1", Credit: mm("10")},
+		{AccountID: "tax", Description: "POS output tax - YGN1", Credit: mm("3")},
+		{AccountID: "cogs", Description: "Cost of goods sold - YGN1", Debit: mm("12")},
+		{AccountID: "stock", Description: "Inventory sold - YGN1", Credit: mm("12")},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("lines = %+v, want %+v", lines, want)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, lines[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package pos
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Posting modes.
+const (
+	ModeJournal = "journal" // one summarized journal per batch
+	ModeInvoice = "invoice" // one summarized invoice plus a payment per method
+)
+
+// Mapping ties an organization's POS codes to cashflow records. The account
+// ids are defaults: a product's own sales and inventory accounts win, and
+// empty ones fall back to the first active ledger account of the usual type.
+type Mapping struct {
+	OrganizationID string `json:"organizationId"`
+	Mode           string `json:"mode"`
+	Currency       string `json:"currency"`
+	// CustomerID is the walk-in customer invoices are raised against in
+	// invoice mode.
+	CustomerID string `json:"customerId"`
+
+	Branches map[string]BranchMapping `json:"branches"`
+	// PaymentAccounts maps a POS payment method (CASH, KBZPAY, VISA, ...) to
+	// the ledger account its takings are deposited to.
+	PaymentAccounts map[string]string `json:"paymentAccounts"`
+
+	RevenueAccountID   string `json:"revenueAccountId,omitempty"`
+	TaxAccountID       string `json:"taxAccountId,omitempty"`
+	COGSAccountID      string `json:"cogsAccountId,omitempty"`
+	InventoryAccountID string `json:"inventoryAccountId,omitempty"`
+	// OverShortAccountID receives the difference between tenders and sales.
+	OverShortAccountID string `json:"overShortAccountId,omitempty"`
+	// Tolerance is the over/short and declared-total difference below which
+	// the variance report stays quiet.
+	Tolerance money.Amount `json:"tolerance"`
+}
+
+// BranchMapping resolves a POS branch code.
+type BranchMapping struct {
+	BranchID    string `json:"branchId"`
+	WarehouseID string `json:"warehouseId,omitempty"`
+}
+
+// LoadMapping reads and validates a mapping file.
+func LoadMapping(path string) (*Mapping, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mapping{Mode: ModeJournal, Currency: "MMK"}
+	if err := json.Unmarshal(raw, m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	if m.OrganizationID == "" {
+		return nil, fmt.Errorf("%s: organizationId is required", path)
+	}
+	switch m.Mode {
+	case ModeJournal:
+	case ModeInvoice:
+		if m.CustomerID == "" {
+			return nil, fmt.Errorf("%s: invoice mode needs a customerId for walk-in sales", path)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unknown mode %q", path, m.Mode)
+	}
+	if len(m.Branches) == 0 {
+		return nil, fmt.Errorf("%s: no branches mapped", path)
+	}
+	for code, b := range m.Branches {
+		if b.BranchID == "" {
+			return nil, fmt.Errorf("%s: branch %q has no branchId", path, code)
+		}
+	}
+
+	accounts := make(map[string]string, len(m.PaymentAccounts))
+	for method, id := range m.PaymentAccounts {
+		accounts[strings.ToUpper(strings.TrimSpace(method))] = id
+	}
+	m.PaymentAccounts = accounts
+	return m, nil
+}
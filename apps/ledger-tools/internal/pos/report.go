@@ -0,0 +1,125 @@
+package pos
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Import statuses.
+const (
+	StatusPosted    = "posted"
+	StatusDuplicate = "duplicate" // same batch id and content already imported
+	StatusConflict  = "conflict"  // same batch id imported with different content
+	StatusFailed    = "failed"
+	StatusDryRun    = "dry-run"
+)
+
+// Variance kinds.
+const (
+	VarianceDeclaredSales    = "declared_sales"
+	VarianceDeclaredTax      = "declared_tax"
+	VarianceDeclaredPayments = "declared_payments"
+	VarianceDeclaredReceipts = "declared_receipts"
+	VarianceOverShort        = "over_short"
+	VarianceUnknownSKU       = "unknown_sku"
+	VarianceStockShortfall   = "stock_shortfall"
+	VarianceReturn           = "return"
+)
+
+// Report is the outcome of importing one batch. It is printed, optionally
+// written as JSON, and stored with the batch.
+type Report struct {
+	BatchID   string       `json:"batchId"`
+	Branch    string       `json:"branch"`
+	Date      string       `json:"date"`
+	Mode      string       `json:"mode"`
+	Status    string       `json:"status"`
+	Error     string       `json:"error,omitempty"`
+	JournalID string       `json:"journalId,omitempty"`
+	InvoiceID string       `json:"invoiceId,omitempty"`
+	Receipts  int          `json:"receipts"`
+	Sales     money.Amount `json:"sales"`
+	Tax       money.Amount `json:"tax"`
+	Payments  money.Amount `json:"payments"`
+	// OverShort is payments minus sales and tax: positive when the till
+	// holds more than was sold.
+	OverShort money.Amount `json:"overShort"`
+	COGS      money.Amount `json:"cogs"`
+	Tenders   []Tender     `json:"tenders"`
+	Declared  *Totals      `json:"declared,omitempty"`
+	Variances []Variance   `json:"variances"`
+}
+
+// Variance is one discrepancy found while importing.
+type Variance struct {
+	Kind       string       `json:"kind"`
+	Subject    string       `json:"subject,omitempty"`
+	Difference money.Amount `json:"difference,omitempty"`
+	Detail     string       `json:"detail"`
+}
+
+func (r *Report) add(kind, subject string, diff money.Amount, format string, args ...any) {
+	r.Variances = append(r.Variances, Variance{Kind: kind, Subject: subject, Difference: diff, Detail: fmt.Sprintf(format, args...)})
+}
+
+// compareDeclared flags declared totals that differ from the computed ones
+// by more than the tolerance. Totals the POS left out (zero) are skipped.
+func (r *Report) compareDeclared(tolerance money.Amount) {
+	d := r.Declared
+	if d == nil {
+		return
+	}
+	check := func(kind string, declared, computed money.Amount) {
+		if declared == 0 {
+			return
+		}
+		if diff := computed - declared; diff.Abs() > tolerance {
+			r.add(kind, "", diff, "POS declared %s, lines add up to %s", declared, computed)
+		}
+	}
+	check(VarianceDeclaredSales, d.Sales, r.Sales)
+	check(VarianceDeclaredTax, d.Tax, r.Tax)
+	check(VarianceDeclaredPayments, d.Payments, r.Payments)
+	if d.Receipts != 0 && r.Receipts != 0 && d.Receipts != r.Receipts {
+		r.add(VarianceDeclaredReceipts, "", 0, "POS declared %d receipts, export holds %d", d.Receipts, r.Receipts)
+	}
+}
+
+// Print writes the report as text.
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Batch %s  branch %s  %s  [%s]\n", r.BatchID, r.Branch, r.Date, r.Status)
+	if r.Error != "" {
+		fmt.Fprintf(w, "  error: %s\n", r.Error)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "  Receipts\t%d\t\n", r.Receipts)
+	fmt.Fprintf(tw, "  Sales\t%s\t\n", r.Sales)
+	fmt.Fprintf(tw, "  Tax\t%s\t\n", r.Tax)
+	for _, t := range r.Tenders {
+		fmt.Fprintf(tw, "  Paid %s\t%s\t\n", t.Method, t.Amount)
+	}
+	fmt.Fprintf(tw, "  Over/short\t%s\t\n", r.OverShort)
+	fmt.Fprintf(tw, "  COGS\t%s\t\n", r.COGS)
+	tw.Flush()
+	if r.JournalID != "" {
+		fmt.Fprintf(w, "  journal %s\n", r.JournalID)
+	}
+	if r.InvoiceID != "" {
+		fmt.Fprintf(w, "  invoice %s\n", r.InvoiceID)
+	}
+	if len(r.Variances) == 0 {
+		fmt.Fprintln(w, "  no variances")
+		return
+	}
+	fmt.Fprintln(w, "  variances:")
+	for _, v := range r.Variances {
+		subject := v.Kind
+		if v.Subject != "" {
+			subject += " " + v.Subject
+		}
+		fmt.Fprintf(w, "    %-28s %s\n", subject, v.Detail)
+	}
+}
@@ -0,0 +1,133 @@
+package pos
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Tables are the cashflowdb tables owned by the importer. The unique key on
+// (organization_id, batch_id) is what makes imports idempotent per batch.
+var Tables = []schema.Table{
+	{
+		Name: "pos_import_batches",
+		Create: `CREATE TABLE IF NOT EXISTS pos_import_batches (
+  id VARCHAR(191) NOT NULL,
+  organization_id VARCHAR(191) NOT NULL,
+  batch_id VARCHAR(191) NOT NULL,
+  branch_code VARCHAR(100) NOT NULL,
+  branch_id VARCHAR(191) NOT NULL,
+  warehouse_id VARCHAR(191) NULL,
+  business_date DATE NOT NULL,
+  mode VARCHAR(20) NOT NULL,
+  content_hash CHAR(64) NOT NULL,
+  source_file VARCHAR(500) NULL,
+  receipts INT NOT NULL DEFAULT 0,
+  sales DECIMAL(12,2) NOT NULL,
+  tax DECIMAL(12,2) NOT NULL,
+  payments DECIMAL(12,2) NOT NULL,
+  over_short DECIMAL(12,2) NOT NULL,
+  cogs DECIMAL(12,2) NOT NULL,
+  journal_id VARCHAR(191) NULL,
+  invoice_id VARCHAR(191) NULL,
+  report TEXT NOT NULL,
+  imported_at DATETIME(3) NOT NULL,
+  imported_by VARCHAR(191) NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY pos_import_batches_org_batch_unique (organization_id, batch_id),
+  INDEX pos_import_batches_org_date_idx (organization_id, business_date)
+) ENGINE=InnoDB`,
+	},
+}
+
+// Record is a stored import.
+type Record struct {
+	ID             string
+	OrganizationID string
+	BatchID        string
+	BranchCode     string
+	BusinessDate   time.Time
+	Mode           string
+	ContentHash    string
+	SourceFile     string
+	JournalID      string
+	InvoiceID      string
+	Report         *Report
+	ImportedAt     time.Time
+	ImportedBy     string
+}
+
+func insertRecord(ctx context.Context, q cashflow.Querier, rec *Record, branchID, warehouseID string) error {
+	report, err := json.Marshal(rec.Report)
+	if err != nil {
+		return err
+	}
+	r := rec.Report
+	_, err = q.ExecContext(ctx, `
+		INSERT INTO pos_import_batches (id, organization_id, batch_id, branch_code, branch_id, warehouse_id,
+		  business_date, mode, content_hash, source_file, receipts, sales, tax, payments, over_short, cogs,
+		  journal_id, invoice_id, report, imported_at, imported_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rec.ID, rec.OrganizationID, rec.BatchID, rec.BranchCode, branchID, cashflow.NullString(warehouseID),
+		rec.BusinessDate, rec.Mode, rec.ContentHash, cashflow.NullString(rec.SourceFile), r.Receipts,
+		r.Sales, r.Tax, r.Payments, r.OverShort, r.COGS,
+		cashflow.NullString(rec.JournalID), cashflow.NullString(rec.InvoiceID), string(report), rec.ImportedAt,
+		cashflow.NullString(rec.ImportedBy))
+	return err
+}
+
+const recordColumns = `id, organization_id, batch_id, branch_code, business_date, mode, content_hash,
+	COALESCE(source_file, ''), COALESCE(journal_id, ''), COALESCE(invoice_id, ''), report, imported_at,
+	COALESCE(imported_by, '')`
+
+func scanRecord(row interface{ Scan(...any) error }) (*Record, error) {
+	rec := &Record{}
+	var report string
+	err := row.Scan(&rec.ID, &rec.OrganizationID, &rec.BatchID, &rec.BranchCode, &rec.BusinessDate, &rec.Mode,
+		&rec.ContentHash, &rec.SourceFile, &rec.JournalID, &rec.InvoiceID, &report, &rec.ImportedAt, &rec.ImportedBy)
+	if err != nil {
+		return nil, err
+	}
+	rec.Report = &Report{}
+	if err := json.Unmarshal([]byte(report), rec.Report); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// GetRecord returns the stored import of a batch, or cashflow.ErrNotFound.
+func GetRecord(ctx context.Context, q cashflow.Querier, organizationID, batchID string) (*Record, error) {
+	rec, err := scanRecord(q.QueryRowContext(ctx, `SELECT `+recordColumns+` FROM pos_import_batches
+		WHERE organization_id = ? AND batch_id = ?`, organizationID, batchID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, cashflow.ErrNotFound
+	}
+	return rec, err
+}
+
+// Records lists an organization's imports between two business dates,
+// newest first.
+func Records(ctx context.Context, q cashflow.Querier, organizationID string, from, to time.Time) ([]*Record, error) {
+	rows, err := q.QueryContext(ctx, `SELECT `+recordColumns+` FROM pos_import_batches
+		WHERE organization_id = ? AND business_date BETWEEN ? AND ?
+		ORDER BY business_date DESC, branch_code`, organizationID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Record
+	for rows.Next() {
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,181 @@
+package prismacheck
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Diff compares the schema the recorded migrations produce with the live
+// one. Tables in known, such as the ledger tools' own side tables and
+// _prisma_migrations, and triggers in knownTriggers are not reported as
+// created outside the migrations.
+func Diff(expected, actual *Schema, known, knownTriggers map[string]bool) []Finding {
+	var out []Finding
+	for _, name := range tableNames(expected.Tables) {
+		if actual.Tables[name] == nil {
+			out = append(out, Finding{MissingRecorded, "table " + name, "created by the migrations, not in the database"})
+			continue
+		}
+		out = append(out, diffTable(expected.Tables[name], actual.Tables[name])...)
+	}
+	for _, name := range tableNames(actual.Tables) {
+		if expected.Tables[name] == nil && !known[name] {
+			out = append(out, Finding{AppliedMissing, "table " + name, "in the database, not created by any recorded migration"})
+		}
+	}
+
+	for _, name := range keys(expected.Triggers) {
+		if _, ok := actual.Triggers[name]; !ok {
+			out = append(out, Finding{MissingRecorded, "trigger " + name, "on " + expected.Triggers[name]})
+		}
+	}
+	for _, name := range keys(actual.Triggers) {
+		if _, ok := expected.Triggers[name]; !ok && !knownTriggers[name] {
+			out = append(out, Finding{AppliedMissing, "trigger " + name, "on " + actual.Triggers[name]})
+		}
+	}
+	return out
+}
+
+func diffTable(want, got *Table) []Finding {
+	var out []Finding
+	t := want.Name
+
+	for _, name := range columnNames(want.Columns) {
+		w, g := want.Columns[name], got.Columns[name]
+		obj := "column " + t + "." + name
+		if g == nil {
+			out = append(out, Finding{MissingRecorded, obj, w.String()})
+			continue
+		}
+		if w.String() != g.String() {
+			out = append(out, Finding{Drifted, obj, fmt.Sprintf("migrations: %s; database: %s", w, g)})
+		}
+	}
+	for _, name := range columnNames(got.Columns) {
+		if want.Columns[name] == nil {
+			out = append(out, Finding{AppliedMissing, "column " + t + "." + name, got.Columns[name].String()})
+		}
+	}
+
+	out = append(out, diffNamed("index", t, indexDefs(want.Indexes), indexDefs(got.Indexes))...)
+	out = append(out, diffNamed("foreign key", t, foreignKeyDefs(want.ForeignKeys), foreignKeyDefs(got.ForeignKeys))...)
+	return out
+}
+
+// diffNamed compares indexes or foreign keys by name. One that exists under
+// another name with the same definition is reported as drifted, since
+// ad-hoc scripts rarely use Prisma's naming.
+func diffNamed(kind, table string, want, got map[string]string) []Finding {
+	var out []Finding
+	var missing, extra []string
+	for _, name := range keys(want) {
+		g, ok := got[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		if want[name] != g {
+			out = append(out, Finding{Drifted, kind + " " + table + "." + name, fmt.Sprintf("migrations: %s; database: %s", want[name], g)})
+		}
+	}
+	for _, name := range keys(got) {
+		if _, ok := want[name]; !ok {
+			extra = append(extra, name)
+		}
+	}
+
+	renamed := map[string]bool{}
+	for _, name := range missing {
+		def := want[name]
+		for _, other := range extra {
+			if !renamed[other] && got[other] == def {
+				renamed[other] = true
+				out = append(out, Finding{Drifted, kind + " " + table + "." + name, fmt.Sprintf("named %s in the database", other)})
+				def = ""
+				break
+			}
+		}
+		if def != "" {
+			out = append(out, Finding{MissingRecorded, kind + " " + table + "." + name, def})
+		}
+	}
+	for _, name := range extra {
+		if !renamed[name] {
+			out = append(out, Finding{AppliedMissing, kind + " " + table + "." + name, got[name]})
+		}
+	}
+	return out
+}
+
+func (c *Column) String() string {
+	s := c.Type + " not null"
+	if c.Nullable {
+		s = c.Type + " null"
+	}
+	if c.Default != nil {
+		s += " default " + *c.Default
+	}
+	if c.Extra != "" {
+		s += " " + c.Extra
+	}
+	return s
+}
+
+func (ix *Index) String() string {
+	s := "(" + strings.Join(ix.Columns, ", ") + ")"
+	if ix.Unique {
+		s = "unique " + s
+	}
+	return s
+}
+
+func (fk *ForeignKey) String() string {
+	return fmt.Sprintf("(%s) references %s (%s) on update %s on delete %s",
+		strings.Join(fk.Columns, ", "), fk.RefTable, strings.Join(fk.RefColumns, ", "),
+		strings.ToLower(fk.OnUpdate), strings.ToLower(fk.OnDelete))
+}
+
+func indexDefs(m map[string]*Index) map[string]string {
+	out := map[string]string{}
+	for name, ix := range m {
+		out[name] = ix.String()
+	}
+	return out
+}
+
+func foreignKeyDefs(m map[string]*ForeignKey) map[string]string {
+	out := map[string]string{}
+	for name, fk := range m {
+		out[name] = fk.String()
+	}
+	return out
+}
+
+func tableNames(m map[string]*Table) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func columnNames(m map[string]*Column) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func keys(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
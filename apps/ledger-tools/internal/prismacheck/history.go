@@ -0,0 +1,200 @@
+// Package prismacheck verifies cashflowdb against the BFF's Prisma
+// migrations: it compares apps/bff/prisma/migrations with the
+// _prisma_migrations table, replays the recorded migrations into a scratch
+// database and diffs the schema they produce with the live one.
+package prismacheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+)
+
+// Finding kinds.
+const (
+	// History.
+	Unrecorded     = "unrecorded-migration" // in the directory, not in _prisma_migrations
+	NotLocal       = "missing-migration"    // in _prisma_migrations, not in the directory
+	ChecksumChange = "checksum-mismatch"    // edited after it was applied
+	Failed         = "failed-migration"     // started but never finished
+	// Schema.
+	AppliedMissing  = "applied-but-missing"  // in the database, created outside the recorded migrations
+	MissingRecorded = "missing-but-recorded" // created by a recorded migration, not in the database
+	Drifted         = "drifted"              // in both, but different
+)
+
+// Finding is one difference between the migration history, the recorded
+// migrations and the database.
+type Finding struct {
+	Kind   string `json:"kind"`
+	Object string `json:"object"`
+	Detail string `json:"detail"`
+}
+
+// Migration is one migration directory.
+type Migration struct {
+	Name     string
+	SQL      string
+	Checksum string // sha256 of migration.sql, as Prisma records it
+}
+
+// ReadDir loads the migrations under dir in the order Prisma applies them.
+func ReadDir(dir string) ([]*Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []*Migration
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name(), "migration.sql"))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		out = append(out, &Migration{Name: e.Name(), SQL: string(data), Checksum: hex.EncodeToString(sum[:])})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// matches reports whether checksum was recorded for this migration. Prisma
+// also accepts a script whose only change is CRLF line endings.
+func (m *Migration) matches(checksum string) bool {
+	if checksum == m.Checksum {
+		return true
+	}
+	for _, data := range [][]byte{
+		bytes.ReplaceAll([]byte(m.SQL), []byte("\r\n"), []byte("\n")),
+		bytes.ReplaceAll([]byte(m.SQL), []byte("\n"), []byte("\r\n")),
+	} {
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) == checksum {
+			return true
+		}
+	}
+	return false
+}
+
+// Applied is a _prisma_migrations row.
+type Applied struct {
+	Name         string
+	Checksum     string
+	StartedAt    time.Time
+	FinishedAt   *time.Time
+	RolledBackAt *time.Time
+	Steps        int
+}
+
+// LoadApplied reads _prisma_migrations, oldest first. A database without
+// the table has no history.
+func LoadApplied(ctx context.Context, q cashflow.Querier) ([]*Applied, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT migration_name, checksum, started_at, finished_at, rolled_back_at, applied_steps_count
+		FROM _prisma_migrations ORDER BY started_at, migration_name`)
+	var me *mysql.MySQLError
+	if errors.As(err, &me) && me.Number == 1146 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Applied
+	for rows.Next() {
+		a := &Applied{}
+		var finished, rolledBack sql.NullTime
+		if err := rows.Scan(&a.Name, &a.Checksum, &a.StartedAt, &finished, &rolledBack, &a.Steps); err != nil {
+			return nil, err
+		}
+		if finished.Valid {
+			a.FinishedAt = &finished.Time
+		}
+		if rolledBack.Valid {
+			a.RolledBackAt = &rolledBack.Time
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// History pairs the migration directory with _prisma_migrations.
+type History struct {
+	Migrations []*Migration
+	Applied    []*Applied
+	// Recorded are the local migrations _prisma_migrations says finished,
+	// in directory order: the ones replayed to build the expected schema.
+	Recorded []*Migration
+	Findings []Finding
+}
+
+// Check compares the directory with the recorded history. A migration that
+// was rolled back and applied again counts by its latest row.
+func Check(migrations []*Migration, applied []*Applied) *History {
+	h := &History{Migrations: migrations, Applied: applied}
+	latest := map[string]*Applied{}
+	var names []string
+	for _, a := range applied {
+		if _, ok := latest[a.Name]; !ok {
+			names = append(names, a.Name)
+		}
+		if prev := latest[a.Name]; prev == nil || prev.RolledBackAt != nil || a.RolledBackAt == nil {
+			latest[a.Name] = a
+		}
+	}
+
+	local := map[string]bool{}
+	for _, m := range migrations {
+		local[m.Name] = true
+		a := latest[m.Name]
+		switch {
+		case a == nil:
+			h.Findings = append(h.Findings, Finding{Unrecorded, "migration " + m.Name, "not in _prisma_migrations: pending, or applied by hand"})
+			continue
+		case a.RolledBackAt != nil:
+			h.Findings = append(h.Findings, Finding{Unrecorded, "migration " + m.Name,
+				"rolled back " + a.RolledBackAt.UTC().Format(time.RFC3339) + " and not applied again"})
+			continue
+		case a.FinishedAt == nil:
+			h.Findings = append(h.Findings, Finding{Failed, "migration " + m.Name,
+				fmt.Sprintf("started %s, %d steps applied, never finished", a.StartedAt.UTC().Format(time.RFC3339), a.Steps)})
+			continue
+		}
+		if !m.matches(a.Checksum) {
+			h.Findings = append(h.Findings, Finding{ChecksumChange, "migration " + m.Name,
+				fmt.Sprintf("recorded %s, file is %s; migration.sql was edited after it was applied", short(a.Checksum), short(m.Checksum))})
+		}
+		h.Recorded = append(h.Recorded, m)
+	}
+	for _, name := range names {
+		if !local[name] && latest[name].RolledBackAt == nil {
+			h.Findings = append(h.Findings, Finding{NotLocal, "migration " + name, "recorded in _prisma_migrations, no directory in the repository"})
+		}
+	}
+	return h
+}
+
+func short(sum string) string {
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	return sum
+}
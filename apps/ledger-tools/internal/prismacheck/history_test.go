@@ -0,0 +1,126 @@
+package prismacheck
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func migration(name, sql string) *Migration {
+	sum := sha256.Sum256([]byte(sql))
+	return &Migration{Name: name, SQL: sql, Checksum: hex.EncodeToString(sum[:])}
+}
+
+func TestCheck(t *testing.T) {
+	started := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	later := started.Add(time.Hour)
+	finished := func(m *Migration) *Applied {
+		return &Applied{Name: m.Name, Checksum: m.Checksum, StartedAt: started, FinishedAt: &later, Steps: 1}
+	}
+
+	initial := migration("20250101000000_init", "CREATE TABLE a (id INT);\n")
+	items := migration("20250201000000_items", "CREATE TABLE b (id INT);\nCREATE TABLE c (id INT);\n")
+	crlf := migration("20250301000000_crlf", "CREATE TABLE d (id INT);\nDROP TABLE c;\n")
+	crlfApplied := finished(crlf)
+	crlfApplied.Checksum = migration(crlf.Name, strings.ReplaceAll(crlf.SQL, "\n", "\r\n")).Checksum
+
+	tests := []struct {
+		name       string
+		migrations []*Migration
+		applied    []*Applied
+		recorded   []string
+		findings   []Finding
+	}{
+		{
+			name:       "in sync",
+			migrations: []*Migration{initial, items},
+			applied:    []*Applied{finished(initial), finished(items)},
+			recorded:   []string{initial.Name, items.Name},
+		},
+		{
+			name:       "no history",
+			migrations: []*Migration{initial},
+			findings: []Finding{{Unrecorded, "migration " + initial.Name,
+				"not in _prisma_migrations: pending, or applied by hand"}},
+		},
+		{
+			name:       "line endings changed",
+			migrations: []*Migration{crlf},
+			applied:    []*Applied{crlfApplied},
+			recorded:   []string{crlf.Name},
+		},
+		{
+			name:       "edited after it was applied",
+			migrations: []*Migration{initial, items},
+			applied: []*Applied{finished(initial), {Name: items.Name, Checksum: strings.Repeat("ab", 32),
+				StartedAt: started, FinishedAt: &later}},
+			recorded: []string{initial.Name, items.Name},
+			findings: []Finding{{ChecksumChange, "migration " + items.Name,
+				"recorded abababababab, file is " + items.Checksum[:12] + "; migration.sql was edited after it was applied"}},
+		},
+		{
+			name:       "failed",
+			migrations: []*Migration{initial, items},
+			applied:    []*Applied{finished(initial), {Name: items.Name, Checksum: items.Checksum, StartedAt: started, Steps: 1}},
+			recorded:   []string{initial.Name},
+			findings: []Finding{{Failed, "migration " + items.Name,
+				"started 2025-03-01T09:00:00Z, 1 steps applied, never finished"}},
+		},
+		{
+			name:       "rolled back",
+			migrations: []*Migration{initial, items},
+			applied: []*Applied{finished(initial), {Name: items.Name, Checksum: items.Checksum, StartedAt: started,
+				RolledBackAt: &later}},
+			recorded: []string{initial.Name},
+			findings: []Finding{{Unrecorded, "migration " + items.Name,
+				"rolled back 2025-03-01T10:00:00Z and not applied again"}},
+		},
+		{
+			name:       "rolled back and applied again",
+			migrations: []*Migration{initial, items},
+			applied: []*Applied{finished(initial), {Name: items.Name, Checksum: items.Checksum, StartedAt: started,
+				RolledBackAt: &later}, finished(items)},
+			recorded: []string{initial.Name, items.Name},
+		},
+		{
+			name:       "a later rolled back row does not hide the applied one",
+			migrations: []*Migration{initial},
+			applied: []*Applied{finished(initial), {Name: initial.Name, Checksum: initial.Checksum, StartedAt: later,
+				RolledBackAt: &later}},
+			recorded: []string{initial.Name},
+		},
+		{
+			name:       "missing directory",
+			migrations: []*Migration{initial},
+			applied:    []*Applied{finished(initial), finished(items)},
+			recorded:   []string{initial.Name},
+			findings: []Finding{{NotLocal, "migration " + items.Name,
+				"recorded in _prisma_migrations, no directory in the repository"}},
+		},
+		{
+			name:       "missing directory that was rolled back",
+			migrations: []*Migration{initial},
+			applied: []*Applied{finished(initial), {Name: items.Name, Checksum: items.Checksum, StartedAt: started,
+				RolledBackAt: &later}},
+			recorded: []string{initial.Name},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Check(tt.migrations, tt.applied)
+			var recorded []string
+			for _, m := range h.Recorded {
+				recorded = append(recorded, m.Name)
+			}
+			if !reflect.DeepEqual(recorded, tt.recorded) {
+				t.Errorf("recorded = %v, want %v", recorded, tt.recorded)
+			}
+			if !reflect.DeepEqual(h.Findings, tt.findings) {
+				t.Errorf("findings =\n%+v\nwant\n%+v", h.Findings, tt.findings)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package prismacheck
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+)
+
+// Replay applies migrations, in order, to a new database on the scratch
+// server and returns the schema they produce. The database is dropped
+// afterwards unless keep is set; its name is returned either way.
+func Replay(ctx context.Context, scratchDSN string, migrations []*Migration, keep bool) (*Schema, string, error) {
+	cfg, err := mysql.ParseDSN(scratchDSN)
+	if err != nil {
+		return nil, "", fmt.Errorf("scratch DSN: %w", err)
+	}
+	var suffix [4]byte
+	rand.Read(suffix[:])
+	name := "prismacheck_" + hex.EncodeToString(suffix[:])
+
+	cfg.DBName = ""
+	server, err := db.Open(ctx, "scratch", cfg.FormatDSN())
+	if err != nil {
+		return nil, "", err
+	}
+	defer server.Close()
+	if _, err := server.ExecContext(ctx, "CREATE DATABASE `"+name+"` CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci"); err != nil {
+		return nil, "", fmt.Errorf("create scratch database: %w", err)
+	}
+	if !keep {
+		defer server.ExecContext(context.Background(), "DROP DATABASE IF EXISTS `"+name+"`")
+	}
+
+	cfg.DBName = name
+	conn, err := db.Open(ctx, "scratch", cfg.FormatDSN())
+	if err != nil {
+		return nil, name, err
+	}
+	defer conn.Close()
+	// One connection, so session settings in a migration carry over to its
+	// later statements as they do under prisma migrate.
+	conn.SetMaxOpenConns(1)
+
+	for _, m := range migrations {
+		for i, stmt := range Statements(m.SQL) {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return nil, name, fmt.Errorf("replay %s statement %d: %w", m.Name, i+1, err)
+			}
+		}
+	}
+	s, err := Load(ctx, conn)
+	return s, name, err
+}
+
+// Statements splits a migration script on semicolons outside quotes,
+// backticks and comments, dropping comments and empty statements.
+func Statements(script string) []string {
+	var out []string
+	var b strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(b.String()); s != "" {
+			out = append(out, s)
+		}
+		b.Reset()
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '-' && strings.HasPrefix(script[i:], "--"), c == '#':
+			for i < len(script) && script[i] != '\n' {
+				i++
+			}
+			b.WriteByte('\n')
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+			b.WriteByte(' ')
+		case c == '\'' || c == '"' || c == '`':
+			b.WriteByte(c)
+			for i++; i < len(script); i++ {
+				b.WriteByte(script[i])
+				if script[i] == '\\' && c != '`' && i+1 < len(script) {
+					i++
+					b.WriteByte(script[i])
+					continue
+				}
+				if script[i] == c {
+					break
+				}
+			}
+		case c == ';':
+			flush()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	flush()
+	return out
+}
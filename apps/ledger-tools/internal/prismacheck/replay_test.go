@@ -0,0 +1,68 @@
+package prismacheck
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{name: "empty", script: "  \n-- only a comment\n", want: nil},
+		{
+			name:   "split on semicolons",
+			script: "CREATE TABLE a (id INT);\nCREATE TABLE b (id INT);\n",
+			want:   []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"},
+		},
+		{
+			name:   "last statement without a semicolon",
+			script: "DROP TABLE a;\nDROP TABLE b",
+			want:   []string{"DROP TABLE a", "DROP TABLE b"},
+		},
+		{
+			name:   "empty statements",
+			script: ";;\nDROP TABLE a;;",
+			want:   []string{"DROP TABLE a"},
+		},
+		{
+			name:   "line comments",
+			script: "-- CreateTable\nCREATE TABLE a (id INT); -- trailing; comment\n# hash; comment\nDROP TABLE b;",
+			want:   []string{"CREATE TABLE a (id INT)", "DROP TABLE b"},
+		},
+		{
+			name:   "block comments",
+			script: "/* header; with a semicolon */CREATE TABLE a (/* inline */id INT);\n/* unterminated; ",
+			want:   []string{"CREATE TABLE a ( id INT)"},
+		},
+		{
+			name:   "semicolons in quotes",
+			script: "INSERT INTO t VALUES ('a;b', \"c;d\");\nALTER TABLE `odd;name` ADD COLUMN x INT;",
+			want:   []string{"INSERT INTO t VALUES ('a;b', \"c;d\")", "ALTER TABLE `odd;name` ADD COLUMN x INT"},
+		},
+		{
+			name:   "comment markers in quotes",
+			script: "INSERT INTO t VALUES ('-- not a comment', '/* nor this */', '# nor this');",
+			want:   []string{"INSERT INTO t VALUES ('-- not a comment', '/* nor this */', '# nor this')"},
+		},
+		{
+			name:   "escaped and doubled quotes",
+			script: `INSERT INTO t VALUES ('it\'s; fine', 'it''s; fine');SELECT 1;`,
+			want:   []string{`INSERT INTO t VALUES ('it\'s; fine', 'it''s; fine')`, "SELECT 1"},
+		},
+		{
+			name:   "backslash in backticks",
+			script: "CREATE TABLE `a\\` (id INT);SELECT 1;",
+			want:   []string{"CREATE TABLE `a\\` (id INT)", "SELECT 1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Statements(tt.script); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Statements =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
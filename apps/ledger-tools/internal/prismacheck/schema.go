@@ -0,0 +1,204 @@
+package prismacheck
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+)
+
+// Schema is the part of INFORMATION_SCHEMA the migrations define.
+type Schema struct {
+	Tables   map[string]*Table
+	Triggers map[string]string // trigger name to table
+}
+
+// Table is a base table with its columns, indexes and foreign keys.
+type Table struct {
+	Name        string
+	Columns     map[string]*Column
+	Indexes     map[string]*Index
+	ForeignKeys map[string]*ForeignKey
+}
+
+// Column is a column definition, normalized so that MySQL versions and
+// MariaDB report the same thing.
+type Column struct {
+	Type     string
+	Nullable bool
+	Default  *string
+	Extra    string
+}
+
+// Index is an index, including the primary key.
+type Index struct {
+	Unique  bool
+	Columns []string
+}
+
+// ForeignKey is a foreign key constraint.
+type ForeignKey struct {
+	Columns    []string
+	RefTable   string
+	RefColumns []string
+	OnUpdate   string
+	OnDelete   string
+}
+
+// Load reads the schema of the connection's current database.
+func Load(ctx context.Context, q cashflow.Querier) (*Schema, error) {
+	s := &Schema{Tables: map[string]*Table{}, Triggers: map[string]string{}}
+
+	err := each(ctx, q, `
+		SELECT TABLE_NAME FROM INFORMATION_SCHEMA.TABLES
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_TYPE = 'BASE TABLE'`, func(rows *sql.Rows) error {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		s.Tables[name] = &Table{Name: name, Columns: map[string]*Column{}, Indexes: map[string]*Index{}, ForeignKeys: map[string]*ForeignKey{}}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = each(ctx, q, `
+		SELECT TABLE_NAME, COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, COLUMN_DEFAULT, EXTRA
+		FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE()`, func(rows *sql.Rows) error {
+		var table, name, typ, nullable, extra string
+		var def sql.NullString
+		if err := rows.Scan(&table, &name, &typ, &nullable, &def, &extra); err != nil {
+			return err
+		}
+		if t := s.Tables[table]; t != nil {
+			t.Columns[name] = &Column{Type: normalizeType(typ), Nullable: nullable == "YES", Default: normalizeDefault(def), Extra: normalizeExtra(extra)}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = each(ctx, q, `
+		SELECT TABLE_NAME, INDEX_NAME, NON_UNIQUE, COLUMN_NAME
+		FROM INFORMATION_SCHEMA.STATISTICS WHERE TABLE_SCHEMA = DATABASE()
+		ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX`, func(rows *sql.Rows) error {
+		var table, name string
+		var nonUnique int
+		var column sql.NullString // NULL for functional key parts
+		if err := rows.Scan(&table, &name, &nonUnique, &column); err != nil {
+			return err
+		}
+		t := s.Tables[table]
+		if t == nil {
+			return nil
+		}
+		ix := t.Indexes[name]
+		if ix == nil {
+			ix = &Index{Unique: nonUnique == 0}
+			t.Indexes[name] = ix
+		}
+		ix.Columns = append(ix.Columns, column.String)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = each(ctx, q, `
+		SELECT k.TABLE_NAME, k.CONSTRAINT_NAME, k.COLUMN_NAME, k.REFERENCED_TABLE_NAME, k.REFERENCED_COLUMN_NAME,
+		  r.UPDATE_RULE, r.DELETE_RULE
+		FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE k
+		JOIN INFORMATION_SCHEMA.REFERENTIAL_CONSTRAINTS r
+		  ON r.CONSTRAINT_SCHEMA = k.CONSTRAINT_SCHEMA AND r.CONSTRAINT_NAME = k.CONSTRAINT_NAME AND r.TABLE_NAME = k.TABLE_NAME
+		WHERE k.TABLE_SCHEMA = DATABASE() AND k.REFERENCED_TABLE_NAME IS NOT NULL
+		ORDER BY k.TABLE_NAME, k.CONSTRAINT_NAME, k.ORDINAL_POSITION`, func(rows *sql.Rows) error {
+		var table, name, column, refTable, refColumn, onUpdate, onDelete string
+		if err := rows.Scan(&table, &name, &column, &refTable, &refColumn, &onUpdate, &onDelete); err != nil {
+			return err
+		}
+		t := s.Tables[table]
+		if t == nil {
+			return nil
+		}
+		fk := t.ForeignKeys[name]
+		if fk == nil {
+			fk = &ForeignKey{RefTable: refTable, OnUpdate: onUpdate, OnDelete: onDelete}
+			t.ForeignKeys[name] = fk
+		}
+		fk.Columns = append(fk.Columns, column)
+		fk.RefColumns = append(fk.RefColumns, refColumn)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = each(ctx, q, `
+		SELECT TRIGGER_NAME, EVENT_OBJECT_TABLE FROM INFORMATION_SCHEMA.TRIGGERS
+		WHERE TRIGGER_SCHEMA = DATABASE()`, func(rows *sql.Rows) error {
+		var name, table string
+		if err := rows.Scan(&name, &table); err != nil {
+			return err
+		}
+		s.Triggers[name] = table
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func each(ctx context.Context, q cashflow.Querier, query string, fn func(*sql.Rows) error) error {
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// intWidth matches the display width MySQL 5.7 and MariaDB print for
+// integer types and MySQL 8 leaves out; tinyint(1) is kept because both
+// use it for BOOLEAN.
+var intWidth = regexp.MustCompile(`^(smallint|mediumint|int|bigint|tinyint)\(\d+\)`)
+
+func normalizeType(t string) string {
+	t = strings.ToLower(strings.TrimSpace(t))
+	if strings.HasPrefix(t, "tinyint(1)") {
+		return t
+	}
+	return intWidth.ReplaceAllString(t, "$1")
+}
+
+// normalizeDefault undoes MariaDB's quoting of literal defaults and its
+// 'NULL' for no default.
+func normalizeDefault(d sql.NullString) *string {
+	if !d.Valid || d.String == "NULL" {
+		return nil
+	}
+	v := d.String
+	if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+		v = strings.ReplaceAll(v[1:len(v)-1], "''", "'")
+	}
+	if l := strings.ToLower(v); strings.HasPrefix(l, "current_timestamp") {
+		v = strings.ReplaceAll(l, "()", "")
+	}
+	return &v
+}
+
+// normalizeExtra drops MySQL 8's DEFAULT_GENERATED marker, which only says
+// the default is an expression.
+func normalizeExtra(e string) string {
+	e = strings.ToLower(strings.TrimSpace(strings.ReplaceAll(e, "DEFAULT_GENERATED", "")))
+	return strings.Join(strings.Fields(e), " ")
+}
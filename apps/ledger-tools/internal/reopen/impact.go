@@ -0,0 +1,243 @@
+package reopen
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Journal changes.
+const (
+	ChangeInserted = "inserted"
+	ChangeEdited   = "edited"
+	ChangeDeleted  = "deleted"
+)
+
+// Report is the impact of a reopen: what changed in the period between
+// opening and closing it.
+type Report struct {
+	ReopenID string    `json:"reopenId"`
+	Period   string    `json:"period"`
+	Start    string    `json:"start"`
+	End      string    `json:"end"`
+	Reason   string    `json:"reason"`
+	OpenedAt time.Time `json:"openedAt"`
+	ClosedAt time.Time `json:"closedAt"`
+	// Journals are the changed journals, inserted first, then edited, then
+	// deleted, each by number.
+	Journals []*JournalChange `json:"journals"`
+	// Accounts are the accounts whose period balance changed, by code.
+	Accounts []*AccountChange `json:"accounts"`
+
+	// OAChecked is false when the report was built without the OA
+	// database; the OA fields are then empty, whatever was posted there.
+	OAChecked bool `json:"oaChecked"`
+	// OAOrgID is the organization's OA org, empty if it has none.
+	OAOrgID string `json:"oaOrgId,omitempty"`
+	// OATransactions are the OA transactions dated in the period that
+	// changed, inserted first, then edited, then deleted, each by date.
+	OATransactions []*OATransactionChange `json:"oaTransactions"`
+	// OAAccounts are the OA accounts whose period balance changed, by
+	// name.
+	OAAccounts []*OAAccountChange `json:"oaAccounts"`
+}
+
+// Counts returns the number of inserted, edited and deleted journals.
+func (r *Report) Counts() (inserted, edited, deleted int) {
+	for _, j := range r.Journals {
+		switch j.Change {
+		case ChangeInserted:
+			inserted++
+		case ChangeEdited:
+			edited++
+		case ChangeDeleted:
+			deleted++
+		}
+	}
+	return inserted, edited, deleted
+}
+
+// JournalChange is one journal that differs from the snapshot.
+type JournalChange struct {
+	Change string        `json:"change"`
+	Before *JournalState `json:"before,omitempty"`
+	After  *JournalState `json:"after,omitempty"`
+	// Details say what was edited, or where a journal dated out of the
+	// period went.
+	Details []string `json:"details,omitempty"`
+}
+
+// Number returns the journal's number after the change, or before it.
+func (c *JournalChange) Number() string {
+	if c.After != nil {
+		return c.After.Number
+	}
+	return c.Before.Number
+}
+
+// AccountChange is the change of an account's net movement over the
+// period, debits positive.
+type AccountChange struct {
+	AccountID string       `json:"accountId"`
+	Code      string       `json:"code"`
+	Name      string       `json:"name"`
+	Before    money.Amount `json:"before"`
+	After     money.Amount `json:"after"`
+	Change    money.Amount `json:"change"`
+}
+
+// impact compares the period's journals now with the reopen's snapshot,
+// and, unless oadb is nil, adds the OA transactions changed since the
+// period was opened.
+func impact(ctx context.Context, q, oadb cashflow.Querier, r *Reopen, now time.Time) (*Report, error) {
+	start, err := time.Parse(time.DateOnly, r.Start)
+	if err != nil {
+		return nil, err
+	}
+	end, err := time.Parse(time.DateOnly, r.End)
+	if err != nil {
+		return nil, err
+	}
+	before, err := loadSnapshot(ctx, q, r.ID)
+	if err != nil {
+		return nil, err
+	}
+	after, err := journals(ctx, q, r.OrganizationID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{ReopenID: r.ID, Period: r.PeriodName, Start: r.Start, End: r.End, Reason: r.Reason,
+		OpenedAt: r.DecidedAt.Time, ClosedAt: now, Journals: []*JournalChange{}, Accounts: []*AccountChange{},
+		OATransactions: []*OATransactionChange{}, OAAccounts: []*OAAccountChange{}}
+	for _, id := range sortedIDs(after) {
+		a, b := after[id], before[id]
+		if b != nil {
+			if details := edits(b, a); len(details) > 0 {
+				report.Journals = append(report.Journals, &JournalChange{Change: ChangeEdited, Before: b, After: a, Details: details})
+			}
+			continue
+		}
+		// New to the period: inserted, or re-dated into it.
+		c := &JournalChange{Change: ChangeInserted, After: a}
+		var created time.Time
+		if err := q.QueryRowContext(ctx, `SELECT createdAt FROM journals WHERE id = ?`, id).Scan(&created); err != nil {
+			return nil, err
+		}
+		if r.DecidedAt.Valid && created.Before(r.DecidedAt.Time) {
+			c.Details = []string{fmt.Sprintf("created %s, re-dated into the period", created.Format(time.DateOnly))}
+		}
+		report.Journals = append(report.Journals, c)
+	}
+	for _, id := range sortedIDs(before) {
+		if after[id] != nil {
+			continue
+		}
+		b := before[id]
+		// Gone from the period: deleted, or re-dated out of it.
+		var date string
+		err := q.QueryRowContext(ctx, `SELECT DATE_FORMAT(journalDate, '%Y-%m-%d') FROM journals WHERE id = ?`, id).Scan(&date)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			report.Journals = append(report.Journals, &JournalChange{Change: ChangeDeleted, Before: b})
+		case err != nil:
+			return nil, err
+		default:
+			report.Journals = append(report.Journals, &JournalChange{Change: ChangeDeleted, Before: b,
+				Details: []string{fmt.Sprintf("re-dated %s, out of the period", date)}})
+		}
+	}
+	sortJournals(report.Journals)
+
+	for _, c := range accountChanges(before, after) {
+		if a, err := cashflow.GetLedgerAccount(ctx, q, r.OrganizationID, c.AccountID); err == nil {
+			c.Code, c.Name = a.Code, a.Name
+		} else if !errors.Is(err, cashflow.ErrNotFound) {
+			return nil, err
+		}
+		report.Accounts = append(report.Accounts, c)
+	}
+	sort.Slice(report.Accounts, func(i, j int) bool {
+		a, b := report.Accounts[i], report.Accounts[j]
+		if a.Code != b.Code {
+			return a.Code < b.Code
+		}
+		return a.AccountID < b.AccountID
+	})
+
+	if oadb != nil {
+		if err := oaImpact(ctx, q, oadb, r, report, start, end); err != nil {
+			return nil, fmt.Errorf("OA: %w", err)
+		}
+	}
+	return report, nil
+}
+
+// sortJournals orders changes inserted first, then edited, then deleted,
+// each by number.
+func sortJournals(js []*JournalChange) {
+	order := map[string]int{ChangeInserted: 0, ChangeEdited: 1, ChangeDeleted: 2}
+	sort.SliceStable(js, func(i, j int) bool {
+		a, b := js[i], js[j]
+		if order[a.Change] != order[b.Change] {
+			return order[a.Change] < order[b.Change]
+		}
+		return a.Number() < b.Number()
+	})
+}
+
+// accountChanges returns the accounts whose net movement over the live
+// journals differs between two sets of journal states, in no order.
+func accountChanges(before, after map[string]*JournalState) []*AccountChange {
+	movements := map[string][2]money.Amount{}
+	for i, set := range []map[string]*JournalState{before, after} {
+		for _, j := range set {
+			if !j.Live() {
+				continue
+			}
+			for _, e := range j.Entries {
+				m := movements[e.AccountID]
+				m[i] += e.Debit - e.Credit
+				movements[e.AccountID] = m
+			}
+		}
+	}
+	var out []*AccountChange
+	for account, m := range movements {
+		if m[0] != m[1] {
+			out = append(out, &AccountChange{AccountID: account, Before: m[0], After: m[1], Change: m[1] - m[0]})
+		}
+	}
+	return out
+}
+
+// edits lists what differs between two states of a journal.
+func edits(b, a *JournalState) []string {
+	var out []string
+	if b.Number != a.Number {
+		out = append(out, fmt.Sprintf("number %s -> %s", b.Number, a.Number))
+	}
+	if b.Date != a.Date {
+		out = append(out, fmt.Sprintf("date %s -> %s", b.Date, a.Date))
+	}
+	if b.Status != a.Status {
+		out = append(out, fmt.Sprintf("status %s -> %s", b.Status, a.Status))
+	}
+	if b.Notes != a.Notes {
+		out = append(out, "notes changed")
+	}
+	if b.entriesKey() != a.entriesKey() {
+		if bt, at := b.Total(), a.Total(); bt != at {
+			out = append(out, fmt.Sprintf("total %s -> %s", bt, at))
+		} else {
+			out = append(out, "entries changed")
+		}
+	}
+	return out
+}
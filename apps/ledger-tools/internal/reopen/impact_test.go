@@ -0,0 +1,108 @@
+package reopen
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+var mm = money.MustParse
+
+func journal(id, number, date, status string, entries ...EntryState) *JournalState {
+	return &JournalState{ID: id, Number: number, Date: date, Status: status, Entries: entries}
+}
+
+func entry(account, debit, credit string) EntryState {
+	return EntryState{AccountID: account, Debit: mm(debit), Credit: mm(credit)}
+}
+
+func TestEdits(t *testing.T) {
+	base := journal("j1", "JV-1", "2025-03-10", "posted", entry("cash", "100", "0"), entry("sales", "0", "100"))
+	tests := []struct {
+		name  string
+		after *JournalState
+		want  []string
+	}{
+		{name: "unchanged", after: journal("j1", "JV-1", "2025-03-10", "posted", entry("cash", "100", "0"), entry("sales", "0", "100"))},
+		{name: "entries reordered", after: journal("j1", "JV-1", "2025-03-10", "posted", entry("sales", "0", "100"), entry("cash", "100", "0"))},
+		{name: "header", after: &JournalState{ID: "j1", Number: "JV-1A", Date: "2025-03-11", Status: "void", Notes: "fixed",
+			Entries: base.Entries},
+			want: []string{"number JV-1 -> JV-1A", "date 2025-03-10 -> 2025-03-11", "status posted -> void", "notes changed"}},
+		{name: "total", after: journal("j1", "JV-1", "2025-03-10", "posted", entry("cash", "120", "0"), entry("sales", "0", "120")),
+			want: []string{"total 100.00 -> 120.00"}},
+		{name: "account swapped", after: journal("j1", "JV-1", "2025-03-10", "posted", entry("bank", "100", "0"), entry("sales", "0", "100")),
+			want: []string{"entries changed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := edits(base, tt.after); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("edits = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccountChanges(t *testing.T) {
+	before := map[string]*JournalState{
+		"j1": journal("j1", "JV-1", "2025-03-10", "posted", entry("cash", "100", "0"), entry("sales", "0", "100")),
+		"j2": journal("j2", "JV-2", "2025-03-12", "active", entry("rent", "50", "0"), entry("cash", "0", "50")),
+		"j3": journal("j3", "JV-3", "2025-03-15", "draft", entry("fees", "10", "0"), entry("cash", "0", "10")),
+	}
+	after := map[string]*JournalState{
+		// Edited: 100 -> 130.
+		"j1": journal("j1", "JV-1", "2025-03-10", "posted", entry("cash", "130", "0"), entry("sales", "0", "130")),
+		// Voided.
+		"j2": journal("j2", "JV-2", "2025-03-12", "void", entry("rent", "50", "0"), entry("cash", "0", "50")),
+		// Still a draft, so still not counted.
+		"j3": journal("j3", "JV-3", "2025-03-15", "draft", entry("fees", "20", "0"), entry("cash", "0", "20")),
+		// Inserted, moving cash between accounts it nets to zero on.
+		"j4": journal("j4", "JV-4", "2025-03-20", "posted", entry("bank", "40", "0"), entry("bank", "0", "40")),
+	}
+	got := accountChanges(before, after)
+	sort.Slice(got, func(i, j int) bool { return got[i].AccountID < got[j].AccountID })
+	want := []*AccountChange{
+		{AccountID: "cash", Before: mm("50"), After: mm("130"), Change: mm("80")},
+		{AccountID: "rent", Before: mm("50"), After: 0, Change: mm("-50")},
+		{AccountID: "sales", Before: mm("-100"), After: mm("-130"), Change: mm("-30")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("accountChanges =")
+		for _, c := range got {
+			t.Errorf("  %+v", *c)
+		}
+	}
+}
+
+func TestSortJournals(t *testing.T) {
+	js := []*JournalChange{
+		{Change: ChangeDeleted, Before: journal("a", "JV-2", "", "")},
+		{Change: ChangeEdited, After: journal("b", "JV-9", "", "")},
+		{Change: ChangeInserted, After: journal("c", "JV-5", "", "")},
+		{Change: ChangeDeleted, Before: journal("d", "JV-1", "", "")},
+		{Change: ChangeInserted, After: journal("e", "JV-3", "", "")},
+	}
+	sortJournals(js)
+	var got []string
+	for _, j := range js {
+		got = append(got, j.Change+" "+j.Number())
+	}
+	want := []string{"inserted JV-3", "inserted JV-5", "edited JV-9", "deleted JV-1", "deleted JV-2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %q, want %q", got, want)
+	}
+
+	r := &Report{Journals: js}
+	if i, e, d := r.Counts(); i != 2 || e != 1 || d != 2 {
+		t.Errorf("Counts = %d, %d, %d", i, e, d)
+	}
+}
+
+func TestLive(t *testing.T) {
+	for status, want := range map[string]bool{"active": true, "posted": true, "draft": false, "void": false, "": false} {
+		if got := (&JournalState{Status: status}).Live(); got != want {
+			t.Errorf("Live(%q) = %v, want %v", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package reopen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+)
+
+// OATransactionChange is one OA transaction dated in the period that was
+// inserted, edited or deleted while the period was open.
+type OATransactionChange struct {
+	Change        string `json:"change"`
+	TransactionID string `json:"transactionId"`
+	Date          string `json:"date"`
+	Description   string `json:"description"`
+	// Total is the sum of the transaction's debits, after the change or,
+	// for a deleted transaction, before it.
+	Total   money.Amount `json:"total"`
+	Details []string     `json:"details,omitempty"`
+}
+
+// OAAccountChange is the change of an OA account's net movement over the
+// period, debits positive.
+type OAAccountChange struct {
+	AccountID string       `json:"accountId"`
+	Name      string       `json:"name"`
+	Change    money.Amount `json:"change"`
+}
+
+// oaSplit is a split of an OA transaction with the timestamps that tell
+// whether it was live when the period was opened and when it was closed.
+type oaSplit struct {
+	accountID         string
+	inserted, removed int64 // removed is 0 while the split is live
+	amount            int64
+}
+
+type oaTransaction struct {
+	id, description string
+	date            int64
+	deleted         bool
+	splits          []*oaSplit
+}
+
+// oaImpact adds the OA side to a report: the transactions of the
+// organization's OA org dated in the period whose rows were inserted,
+// updated or deleted between opening and closing it. OA keeps no
+// snapshot: its splits are never rewritten, only inserted and flagged
+// deleted, and carry millisecond inserted/updated stamps, which is
+// enough to rebuild which splits were live at either time. A changed
+// transaction is read with all of its splits.
+func oaImpact(ctx context.Context, cf, oadb cashflow.Querier, r *Reopen, report *Report, start, end time.Time) error {
+	orgID, err := oa.OrgForOrganization(ctx, cf, r.OrganizationID)
+	if err != nil && !errors.Is(err, cashflow.ErrNotFound) {
+		return err
+	}
+	report.OAChecked = true
+	if orgID == "" {
+		return nil
+	}
+	report.OAOrgID = orgID
+	org, err := oa.GetOrg(ctx, oadb, orgID)
+	if err != nil {
+		return err
+	}
+
+	opened, closed := oa.Millis(r.DecidedAt.Time), oa.Millis(report.ClosedAt)
+	rows, err := oadb.QueryContext(ctx, `
+		SELECT LOWER(HEX(t.id)), t.date, t.description, t.updated, t.deleted,
+		  LOWER(HEX(s.accountId)), s.inserted, s.updated, s.deleted, s.nativeAmount
+		FROM transaction t JOIN split s ON s.transactionId = t.id
+		WHERE t.orgId = UNHEX(?) AND t.date >= ? AND t.date < ? AND (t.updated >= ?
+		    OR EXISTS (SELECT 1 FROM split c WHERE c.transactionId = t.id AND c.updated >= ?))
+		ORDER BY t.date, t.id, s.id`,
+		orgID, oa.Millis(start), oa.Millis(end.AddDate(0, 0, 1)), opened, opened)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var txns []*oaTransaction
+	for rows.Next() {
+		var (
+			t                  oaTransaction
+			s                  oaSplit
+			tUpdated, sUpdated int64
+			sDeleted           bool
+		)
+		if err := rows.Scan(&t.id, &t.date, &t.description, &tUpdated, &t.deleted,
+			&s.accountID, &s.inserted, &sUpdated, &sDeleted, &s.amount); err != nil {
+			return err
+		}
+		// A split is gone from when it, or its transaction, was deleted,
+		// whichever came first.
+		if sDeleted {
+			s.removed = sUpdated
+		}
+		if t.deleted && (s.removed == 0 || tUpdated < s.removed) {
+			s.removed = tUpdated
+		}
+		if n := len(txns); n == 0 || txns[n-1].id != t.id {
+			txns = append(txns, &t)
+		}
+		last := txns[len(txns)-1]
+		last.splits = append(last.splits, &s)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	changes, movements := oaChanges(txns, opened, closed, org.Precision)
+	report.OATransactions = append(report.OATransactions, changes...)
+
+	var chart *oa.Chart
+	for account, m := range movements {
+		if m == 0 {
+			continue
+		}
+		if chart == nil {
+			if chart, err = oa.LoadChart(ctx, oadb, orgID); err != nil {
+				return err
+			}
+		}
+		c := &OAAccountChange{AccountID: account, Name: chart.FullName(account), Change: money.FromMinor(m, org.Precision)}
+		report.OAAccounts = append(report.OAAccounts, c)
+	}
+	sort.Slice(report.OAAccounts, func(i, j int) bool {
+		a, b := report.OAAccounts[i], report.OAAccounts[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.AccountID < b.AccountID
+	})
+	return nil
+}
+
+// oaChanges works out which transactions changed between opened and
+// closed, in the order the report lists them, and each account's change
+// of movement in minor units.
+func oaChanges(txns []*oaTransaction, opened, closed int64, precision int) ([]*OATransactionChange, map[string]int64) {
+	liveAt := func(s *oaSplit, at int64) bool {
+		return s.inserted < at && (s.removed == 0 || s.removed >= at)
+	}
+	var out []*OATransactionChange
+	movements := map[string]int64{}
+	for _, t := range txns {
+		var before, after, added, removed int
+		var totalBefore, totalAfter int64
+		for _, s := range t.splits {
+			was, is := liveAt(s, opened), liveAt(s, closed+1)
+			if was {
+				before++
+				if s.amount > 0 {
+					totalBefore += s.amount
+				}
+			}
+			if is {
+				after++
+				if s.amount > 0 {
+					totalAfter += s.amount
+				}
+			}
+			switch {
+			case was && !is:
+				removed++
+				movements[s.accountID] -= s.amount
+			case !was && is:
+				added++
+				movements[s.accountID] += s.amount
+			}
+		}
+
+		c := &OATransactionChange{TransactionID: t.id, Description: t.description,
+			Date: time.UnixMilli(t.date).UTC().Format(time.DateOnly), Total: money.FromMinor(totalAfter, precision)}
+		switch {
+		case before == 0 && after > 0:
+			c.Change = ChangeInserted
+		case before > 0 && after == 0:
+			c.Change, c.Total = ChangeDeleted, money.FromMinor(totalBefore, precision)
+		case added > 0 || removed > 0:
+			c.Change = ChangeEdited
+			c.Details = []string{fmt.Sprintf("%d splits added, %d removed", added, removed)}
+			if totalBefore != totalAfter {
+				c.Details = append(c.Details, fmt.Sprintf("total %s -> %s",
+					money.FromMinor(totalBefore, precision), money.FromMinor(totalAfter, precision)))
+			}
+		default:
+			// Inserted and deleted again while open, or touched without
+			// changing its splits.
+			continue
+		}
+		out = append(out, c)
+	}
+	order := map[string]int{ChangeInserted: 0, ChangeEdited: 1, ChangeDeleted: 2}
+	sort.SliceStable(out, func(i, j int) bool {
+		return order[out[i].Change] < order[out[j].Change]
+	})
+	return out, movements
+}
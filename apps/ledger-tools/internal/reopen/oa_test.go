@@ -0,0 +1,67 @@
+package reopen
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOAChanges(t *testing.T) {
+	const opened, closed = 1000, 2000
+	day := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC).UnixMilli()
+	split := func(account string, inserted, removed, amount int64) *oaSplit {
+		return &oaSplit{accountID: account, inserted: inserted, removed: removed, amount: amount}
+	}
+	txns := []*oaTransaction{
+		{id: "t1", description: "posted while open", date: day, splits: []*oaSplit{
+			split("cash", 1500, 0, 300), split("sales", 1500, 0, -300)}},
+		{id: "t2", description: "deleted while open", date: day, deleted: true, splits: []*oaSplit{
+			split("cash", 500, 1600, 700), split("sales", 500, 1600, -700)}},
+		{id: "t3", description: "edited while open", date: day, splits: []*oaSplit{
+			split("cash", 500, 1200, 100), split("sales", 500, 1200, -100),
+			split("cash", 1200, 0, 150), split("sales", 1200, 0, -150)}},
+		{id: "t4", description: "posted and deleted while open", date: day, splits: []*oaSplit{
+			split("cash", 1200, 1300, 50), split("fees", 1200, 1300, -50)}},
+		{id: "t5", description: "touched", date: day, splits: []*oaSplit{
+			split("rent", 500, 0, 80), split("cash", 500, 0, -80)}},
+		{id: "t6", description: "posted after closing", date: day, splits: []*oaSplit{
+			split("cash", 2500, 0, 20), split("fees", 2500, 0, -20)}},
+		{id: "t7", description: "posted as it closed", date: day, splits: []*oaSplit{
+			split("rent", closed, 0, 10), split("cash", closed, 0, -10)}},
+		{id: "t8", description: "posted as it opened", date: day, splits: []*oaSplit{
+			split("rent", opened, 0, 5), split("cash", opened, 0, -5)}},
+	}
+
+	changes, movements := oaChanges(txns, opened, closed, 2)
+	var got []string
+	for _, c := range changes {
+		s := c.Change + " " + c.TransactionID + " " + c.Total.String()
+		for _, d := range c.Details {
+			s += "; " + d
+		}
+		got = append(got, s)
+	}
+	want := []string{
+		"inserted t1 3.00",
+		"inserted t7 0.10",
+		"inserted t8 0.05",
+		"edited t3 1.50; 2 splits added, 2 removed; total 1.00 -> 1.50",
+		"deleted t2 7.00",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes =\n%q\nwant\n%q", got, want)
+	}
+	if changes[0].Date != "2025-03-10" {
+		t.Errorf("date = %s", changes[0].Date)
+	}
+
+	wantMovements := map[string]int64{"cash": 300 - 700 - 100 + 150 - 10 - 5, "sales": -300 + 700 + 100 - 150, "rent": 15}
+	for account, m := range movements {
+		if m == 0 {
+			delete(movements, account)
+		}
+	}
+	if !reflect.DeepEqual(movements, wantMovements) {
+		t.Errorf("movements = %v, want %v", movements, wantMovements)
+	}
+}
@@ -0,0 +1,388 @@
+// Package reopen governs reopening closed accounting periods.
+//
+// fiscal-year-service.js reopens a period by flipping its status and
+// stamping reopened_by/reopened_at, which says nothing of what changed
+// while it was open. Here a reopen is requested with a reason and opened
+// only once someone else approves it; opening snapshots every journal
+// dated in the period, and closing the period again compares the journals
+// with that snapshot and keeps the impact report: journals inserted,
+// edited or deleted, and the net change per account. The report covers
+// the linked OA org the same way, from the OA rows' own timestamps.
+package reopen
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Tables are the cashflowdb tables owned by the reopen module.
+var Tables = []schema.Table{
+	{
+		Name: "period_reopens",
+		Create: `CREATE TABLE IF NOT EXISTS period_reopens (
+  id VARCHAR(191) NOT NULL,
+  organization_id VARCHAR(191) NOT NULL,
+  period_id VARCHAR(191) NOT NULL,
+  period_name VARCHAR(191) NOT NULL,
+  start_date CHAR(10) NOT NULL,
+  end_date CHAR(10) NOT NULL,
+  reason TEXT NOT NULL,
+  status VARCHAR(20) NOT NULL,
+  requested_by VARCHAR(191) NOT NULL,
+  requested_at DATETIME(3) NOT NULL,
+  approved_by VARCHAR(191) NULL,
+  decided_at DATETIME(3) NULL,
+  decision_note TEXT NULL,
+  closed_by VARCHAR(191) NULL,
+  closed_at DATETIME(3) NULL,
+  report MEDIUMTEXT NULL,
+  PRIMARY KEY (id),
+  INDEX period_reopens_org_idx (organization_id, requested_at),
+  INDEX period_reopens_period_idx (period_id, status)
+) ENGINE=InnoDB`,
+	},
+	{
+		Name: "period_reopen_journals",
+		Create: `CREATE TABLE IF NOT EXISTS period_reopen_journals (
+  reopen_id VARCHAR(191) NOT NULL,
+  journal_id VARCHAR(191) NOT NULL,
+  journal_number VARCHAR(191) NOT NULL,
+  journal_date CHAR(10) NOT NULL,
+  status VARCHAR(191) NOT NULL,
+  notes TEXT NOT NULL,
+  PRIMARY KEY (reopen_id, journal_id)
+) ENGINE=InnoDB`,
+	},
+	{
+		Name: "period_reopen_entries",
+		Create: `CREATE TABLE IF NOT EXISTS period_reopen_entries (
+  reopen_id VARCHAR(191) NOT NULL,
+  journal_id VARCHAR(191) NOT NULL,
+  line INT NOT NULL,
+  account_id VARCHAR(191) NOT NULL,
+  debit DECIMAL(12,2) NOT NULL,
+  credit DECIMAL(12,2) NOT NULL,
+  PRIMARY KEY (reopen_id, journal_id, line)
+) ENGINE=InnoDB`,
+	},
+}
+
+// Reopen statuses. A request is approved or rejected; approval opens the
+// period, and closing it again produces the report.
+const (
+	StatusRequested = "requested"
+	StatusRejected  = "rejected"
+	StatusOpen      = "open"
+	StatusClosed    = "closed"
+)
+
+// Reopen is a period_reopens row.
+type Reopen struct {
+	ID             string
+	OrganizationID string
+	PeriodID       string
+	PeriodName     string
+	Start, End     string // inclusive days
+	Reason         string
+	Status         string
+	RequestedBy    string
+	RequestedAt    time.Time
+	ApprovedBy     string // or rejected
+	DecidedAt      sql.NullTime
+	DecisionNote   string
+	ClosedBy       string
+	ClosedAt       sql.NullTime
+	Report         *Report
+}
+
+// Period is the part of an accounting_periods row a reopen needs.
+type Period struct {
+	ID             string
+	OrganizationID string
+	Name           string
+	FiscalYear     int
+	Number         int
+	Start, End     time.Time
+	Status         string
+}
+
+func getPeriod(ctx context.Context, q cashflow.Querier, id string, lock bool) (*Period, error) {
+	query := `
+		SELECT id, organization_id, period_name, fiscal_year, period_number, start_date, end_date, status
+		FROM accounting_periods WHERE id = ?`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	p := &Period{}
+	err := q.QueryRowContext(ctx, query, id).
+		Scan(&p.ID, &p.OrganizationID, &p.Name, &p.FiscalYear, &p.Number, &p.Start, &p.End, &p.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("accounting period %s: %w", id, cashflow.ErrNotFound)
+	}
+	return p, err
+}
+
+// Request asks to reopen a closed or soft-closed period. The reason is
+// required; nothing changes until Approve.
+func Request(ctx context.Context, cf *sql.DB, periodID, reason, by string) (*Reopen, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, errors.New("a reason is required")
+	}
+	if by == "" {
+		return nil, errors.New("the requester is required")
+	}
+	r := &Reopen{ID: cashflow.NewID("reopen"), Reason: strings.TrimSpace(reason), Status: StatusRequested,
+		RequestedBy: by, RequestedAt: time.Now()}
+	err := db.InTx(ctx, cf, func(tx *sql.Tx) error {
+		p, err := getPeriod(ctx, tx, periodID, true)
+		if err != nil {
+			return err
+		}
+		if p.Status == "open" {
+			return fmt.Errorf("period %s is already open", p.Name)
+		}
+		var pending string
+		err = tx.QueryRowContext(ctx, `
+			SELECT id FROM period_reopens WHERE period_id = ? AND status IN (?, ?) LIMIT 1`,
+			p.ID, StatusRequested, StatusOpen).Scan(&pending)
+		if err == nil {
+			return fmt.Errorf("period %s already has reopen %s in progress", p.Name, pending)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		r.OrganizationID, r.PeriodID, r.PeriodName = p.OrganizationID, p.ID, p.Name
+		r.Start, r.End = p.Start.Format(time.DateOnly), p.End.Format(time.DateOnly)
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO period_reopens (id, organization_id, period_id, period_name, start_date, end_date, reason,
+			  status, requested_by, requested_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			r.ID, r.OrganizationID, r.PeriodID, r.PeriodName, r.Start, r.End, r.Reason, r.Status, r.RequestedBy, r.RequestedAt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Approve approves a request and opens the period. The approver must not
+// be the requester. The period's journals are snapshotted in the same
+// transaction that opens it, so nothing can be posted in between.
+func Approve(ctx context.Context, cf *sql.DB, id, by, note string) (int, error) {
+	journalCount := 0
+	err := db.InTx(ctx, cf, func(tx *sql.Tx) error {
+		r, err := decide(ctx, tx, id, by)
+		if err != nil {
+			return err
+		}
+		p, err := getPeriod(ctx, tx, r.PeriodID, true)
+		if err != nil {
+			return err
+		}
+		if p.Status == "open" {
+			return fmt.Errorf("period %s was opened outside this workflow", p.Name)
+		}
+
+		states, err := journals(ctx, tx, p.OrganizationID, p.Start, p.End)
+		if err != nil {
+			return err
+		}
+		if err := snapshot(ctx, tx, r.ID, states); err != nil {
+			return err
+		}
+		journalCount = len(states)
+
+		now := time.Now()
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE accounting_periods SET status = 'open', reopened_at = ?, reopened_by = ?, updated_at = ?
+			WHERE id = ?`, now, r.RequestedBy, now, p.ID); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE period_reopens SET status = ?, approved_by = ?, decided_at = ?, decision_note = ? WHERE id = ?`,
+			StatusOpen, by, now, cashflow.NullString(note), r.ID)
+		return err
+	})
+	return journalCount, err
+}
+
+// Reject turns a request down. Like approval it needs someone other than
+// the requester.
+func Reject(ctx context.Context, cf *sql.DB, id, by, note string) error {
+	return db.InTx(ctx, cf, func(tx *sql.Tx) error {
+		r, err := decide(ctx, tx, id, by)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE period_reopens SET status = ?, approved_by = ?, decided_at = ?, decision_note = ? WHERE id = ?`,
+			StatusRejected, by, time.Now(), cashflow.NullString(note), r.ID)
+		return err
+	})
+}
+
+// decide locks a pending request for a decision by someone else.
+func decide(ctx context.Context, tx *sql.Tx, id, by string) (*Reopen, error) {
+	r, err := get(ctx, tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	if r.Status != StatusRequested {
+		return nil, fmt.Errorf("reopen %s is %s, not %s", id, r.Status, StatusRequested)
+	}
+	if by == "" {
+		return nil, errors.New("the approver is required")
+	}
+	if strings.EqualFold(by, r.RequestedBy) {
+		return nil, fmt.Errorf("%s requested reopen %s and cannot decide it", by, id)
+	}
+	return r, nil
+}
+
+// Close closes the period again, soft-closed if soft, and stores the
+// impact report. A hard close is refused while earlier periods are open,
+// as closePeriod does. The OA side of the report is left out when oadb is
+// nil.
+func Close(ctx context.Context, cf *sql.DB, oadb cashflow.Querier, id, by string, soft bool) (*Report, error) {
+	if by == "" {
+		return nil, errors.New("who is closing the period is required")
+	}
+	var report *Report
+	err := db.InTx(ctx, cf, func(tx *sql.Tx) error {
+		r, err := get(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+		if r.Status != StatusOpen {
+			return fmt.Errorf("reopen %s is %s, not %s", id, r.Status, StatusOpen)
+		}
+		p, err := getPeriod(ctx, tx, r.PeriodID, true)
+		if err != nil {
+			return err
+		}
+		if !soft {
+			var prior int
+			if err := tx.QueryRowContext(ctx, `
+				SELECT COUNT(*) FROM accounting_periods
+				WHERE organization_id = ? AND status = 'open'
+				  AND (fiscal_year < ? OR (fiscal_year = ? AND period_number < ?))`,
+				p.OrganizationID, p.FiscalYear, p.FiscalYear, p.Number).Scan(&prior); err != nil {
+				return err
+			}
+			if prior > 0 {
+				return errors.New("cannot close period while prior periods are still open; close them first or soft close")
+			}
+		}
+
+		now := time.Now()
+		if report, err = impact(ctx, tx, oadb, r, now); err != nil {
+			return err
+		}
+		data, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+		status := "closed"
+		if soft {
+			status = "soft_closed"
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE accounting_periods SET status = ?, closed_at = ?, closed_by = ?, updated_at = ? WHERE id = ?`,
+			status, now, by, now, p.ID); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE period_reopens SET status = ?, closed_by = ?, closed_at = ?, report = ? WHERE id = ?`,
+			StatusClosed, by, now, string(data), r.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Preview builds the impact report of a reopen still open, without
+// closing the period.
+func Preview(ctx context.Context, cf *sql.DB, oadb cashflow.Querier, id string) (*Report, error) {
+	r, err := Get(ctx, cf, id)
+	if err != nil {
+		return nil, err
+	}
+	if r.Status != StatusOpen {
+		return nil, fmt.Errorf("reopen %s is %s; only open reopens have a preview", id, r.Status)
+	}
+	return impact(ctx, cf, oadb, r, time.Now())
+}
+
+const reopenColumns = `id, organization_id, period_id, period_name, start_date, end_date, reason, status, requested_by,
+	requested_at, COALESCE(approved_by, ''), decided_at, COALESCE(decision_note, ''), COALESCE(closed_by, ''), closed_at,
+	COALESCE(report, '')`
+
+func scanReopen(row interface{ Scan(...any) error }) (*Reopen, error) {
+	r := &Reopen{}
+	var report string
+	err := row.Scan(&r.ID, &r.OrganizationID, &r.PeriodID, &r.PeriodName, &r.Start, &r.End, &r.Reason, &r.Status,
+		&r.RequestedBy, &r.RequestedAt, &r.ApprovedBy, &r.DecidedAt, &r.DecisionNote, &r.ClosedBy, &r.ClosedAt, &report)
+	if err != nil {
+		return nil, err
+	}
+	if report != "" {
+		r.Report = &Report{}
+		if err := json.Unmarshal([]byte(report), r.Report); err != nil {
+			return nil, fmt.Errorf("reopen %s report: %w", r.ID, err)
+		}
+	}
+	return r, nil
+}
+
+func get(ctx context.Context, q cashflow.Querier, id string, lock bool) (*Reopen, error) {
+	query := `SELECT ` + reopenColumns + ` FROM period_reopens WHERE id = ?`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	r, err := scanReopen(q.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("reopen %s: %w", id, cashflow.ErrNotFound)
+	}
+	return r, err
+}
+
+// Get loads a reopen with its report, if closed.
+func Get(ctx context.Context, q cashflow.Querier, id string) (*Reopen, error) {
+	return get(ctx, q, id, false)
+}
+
+// List returns an organization's reopens, newest first, optionally only
+// those in one status. Reports are not loaded.
+func List(ctx context.Context, q cashflow.Querier, organizationID, status string) ([]*Reopen, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+strings.Replace(reopenColumns, "COALESCE(report, '')", "''", 1)+`
+		FROM period_reopens WHERE organization_id = ? AND (? = '' OR status = ?)
+		ORDER BY requested_at DESC`, organizationID, status, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Reopen
+	for rows.Next() {
+		r, err := scanReopen(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,150 @@
+package reopen
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// JournalState is a journal as it stood at a point in time.
+type JournalState struct {
+	ID      string       `json:"id"`
+	Number  string       `json:"number"`
+	Date    string       `json:"date"`
+	Status  string       `json:"status"`
+	Notes   string       `json:"notes,omitempty"`
+	Entries []EntryState `json:"entries"`
+}
+
+// EntryState is one journal_entries row of a JournalState.
+type EntryState struct {
+	AccountID string       `json:"accountId"`
+	Debit     money.Amount `json:"debit"`
+	Credit    money.Amount `json:"credit"`
+}
+
+// Live reports whether the journal counts towards balances.
+func (j *JournalState) Live() bool {
+	return strings.Contains(cashflow.LiveJournalStatuses, "'"+j.Status+"'")
+}
+
+// Total returns the journal's debit total.
+func (j *JournalState) Total() money.Amount {
+	var t money.Amount
+	for _, e := range j.Entries {
+		t += e.Debit
+	}
+	return t
+}
+
+// entriesKey identifies a journal's entries regardless of their order.
+func (j *JournalState) entriesKey() string {
+	keys := make([]string, len(j.Entries))
+	for i, e := range j.Entries {
+		keys[i] = fmt.Sprintf("%s:%d:%d", e.AccountID, e.Debit, e.Credit)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// journals reads the organization's journals dated in [from, to], by id.
+func journals(ctx context.Context, q cashflow.Querier, organizationID string, from, to time.Time) (map[string]*JournalState, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT j.id, j.journalNumber, j.journalDate, j.status, COALESCE(j.notes, ''),
+		       COALESCE(e.accountId, ''), COALESCE(e.debitAmount, 0), COALESCE(e.creditAmount, 0)
+		FROM journals j LEFT JOIN journal_entries e ON e.journalId = j.id
+		WHERE j.organizationId = ? AND DATE(j.journalDate) BETWEEN ? AND ?
+		ORDER BY j.id, e.id`, organizationID, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]*JournalState{}
+	for rows.Next() {
+		var j JournalState
+		var date time.Time
+		var e EntryState
+		if err := rows.Scan(&j.ID, &j.Number, &date, &j.Status, &j.Notes, &e.AccountID, &e.Debit, &e.Credit); err != nil {
+			return nil, err
+		}
+		cur := out[j.ID]
+		if cur == nil {
+			j.Date = date.Format(time.DateOnly)
+			cur = &j
+			out[j.ID] = cur
+		}
+		if e.AccountID != "" {
+			cur.Entries = append(cur.Entries, e)
+		}
+	}
+	return out, rows.Err()
+}
+
+// snapshot stores the period's journals for a reopen.
+func snapshot(ctx context.Context, tx *sql.Tx, reopenID string, states map[string]*JournalState) error {
+	for _, id := range sortedIDs(states) {
+		j := states[id]
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO period_reopen_journals (reopen_id, journal_id, journal_number, journal_date, status, notes)
+			VALUES (?, ?, ?, ?, ?, ?)`, reopenID, j.ID, j.Number, j.Date, j.Status, j.Notes); err != nil {
+			return err
+		}
+		for i, e := range j.Entries {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO period_reopen_entries (reopen_id, journal_id, line, account_id, debit, credit)
+				VALUES (?, ?, ?, ?, ?, ?)`, reopenID, j.ID, i, e.AccountID, e.Debit, e.Credit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadSnapshot reads the journals stored for a reopen.
+func loadSnapshot(ctx context.Context, q cashflow.Querier, reopenID string) (map[string]*JournalState, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT j.journal_id, j.journal_number, j.journal_date, j.status, j.notes,
+		       COALESCE(e.account_id, ''), COALESCE(e.debit, 0), COALESCE(e.credit, 0)
+		FROM period_reopen_journals j
+		LEFT JOIN period_reopen_entries e ON e.reopen_id = j.reopen_id AND e.journal_id = j.journal_id
+		WHERE j.reopen_id = ?
+		ORDER BY j.journal_id, e.line`, reopenID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]*JournalState{}
+	for rows.Next() {
+		var j JournalState
+		var e EntryState
+		if err := rows.Scan(&j.ID, &j.Number, &j.Date, &j.Status, &j.Notes, &e.AccountID, &e.Debit, &e.Credit); err != nil {
+			return nil, err
+		}
+		cur := out[j.ID]
+		if cur == nil {
+			cur = &j
+			out[j.ID] = cur
+		}
+		if e.AccountID != "" {
+			cur.Entries = append(cur.Entries, e)
+		}
+	}
+	return out, rows.Err()
+}
+
+func sortedIDs(m map[string]*JournalState) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
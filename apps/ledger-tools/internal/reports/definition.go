@@ -0,0 +1,381 @@
+// Package reports evaluates custom report definitions against OA split data.
+//
+// A definition is a YAML or JSON document: rows select accounts or combine
+// other rows with formulas, columns pick periods, budgets, dimension values
+// or formulas over other columns. Evaluating a definition gives a Table,
+// which renders as text, CSV, XLSX or PDF. Definitions are stored per
+// organization in report_definitions; every change is a new version.
+//
+//	code: mgmt-pl
+//	title: Management P&L
+//	columns:
+//	  - {id: actual, period: {type: month}}
+//	  - {id: budget, type: budget, period: {type: month}}
+//	  - {id: variance, type: formula, formula: actual - budget}
+//	rows:
+//	  - {id: revenue, label: Revenue, accounts: [Income]}
+//	  - {id: cogs, label: Cost of sales, accounts: ["Expenses:Cost of Goods Sold"]}
+//	  - {id: gross, label: Gross profit, formula: revenue - cogs, style: subtotal}
+//	  - {id: margin, label: Gross margin, formula: gross / revenue, format: percent}
+package reports
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Source formats.
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+)
+
+// Column types.
+const (
+	ColumnActual  = "actual"
+	ColumnBudget  = "budget"
+	ColumnFormula = "formula"
+)
+
+// Row types.
+const (
+	RowAccounts = "accounts"
+	RowFormula  = "formula"
+	RowHeader   = "header"
+	RowBlank    = "blank"
+)
+
+// Row amounts.
+const (
+	AmountActivity = "activity" // movement in the column's period
+	AmountBalance  = "balance"  // balance at the end of the period
+	AmountOpening  = "opening"  // balance at the start of the period
+)
+
+// Row signs.
+const (
+	SignNatural = "natural" // each account on its normal side
+	SignDebit   = "debit"
+	SignCredit  = "credit"
+)
+
+// Period types. Quarters and years follow the organization's fiscal year.
+const (
+	PeriodMonth   = "month"
+	PeriodQuarter = "quarter"
+	PeriodYear    = "year"
+	PeriodMTD     = "mtd"
+	PeriodQTD     = "qtd"
+	PeriodYTD     = "ytd"
+	PeriodCustom  = "custom"
+)
+
+// Definition is a report definition.
+type Definition struct {
+	Code     string    `json:"code"`
+	Title    string    `json:"title"`
+	Subtitle string    `json:"subtitle,omitempty"`
+	Columns  []*Column `json:"columns"`
+	Rows     []*Row    `json:"rows"`
+	Format   Format    `json:"format,omitempty"`
+}
+
+// Period is relative to the date a report runs as of, or custom dates.
+type Period struct {
+	Type   string `json:"type,omitempty"`
+	Offset int    `json:"offset,omitempty"` // -1 for the previous period
+	From   string `json:"from,omitempty"`   // custom: first day
+	To     string `json:"to,omitempty"`     // custom: last day
+}
+
+// Column is a report column.
+type Column struct {
+	ID      string            `json:"id"`
+	Label   string            `json:"label,omitempty"` // "{period}" and "{value}" are filled in
+	Type    string            `json:"type,omitempty"`
+	Period  *Period           `json:"period,omitempty"`
+	Filter  map[string]string `json:"filter,omitempty"` // dimension code: value code
+	Each    string            `json:"each,omitempty"`   // one column per value of a dimension
+	Formula string            `json:"formula,omitempty"`
+	Format  string            `json:"format,omitempty"` // "percent"
+	Hidden  bool              `json:"hidden,omitempty"`
+}
+
+// Row is a report row.
+type Row struct {
+	ID       string   `json:"id,omitempty"`
+	Label    string   `json:"label,omitempty"`
+	Type     string   `json:"type,omitempty"`
+	Accounts []string `json:"accounts,omitempty"` // account selectors, see Selector
+	Amount   string   `json:"amount,omitempty"`
+	Sign     string   `json:"sign,omitempty"`
+	Formula  string   `json:"formula,omitempty"`
+	Expand   bool     `json:"expand,omitempty"` // one line per account, then a total
+	Style    string   `json:"style,omitempty"`  // "bold", "subtotal" or "total"
+	Indent   int      `json:"indent,omitempty"`
+	Format   string   `json:"format,omitempty"` // "percent"
+	Hidden   bool     `json:"hidden,omitempty"`
+}
+
+// Format controls how numbers are shown.
+type Format struct {
+	Decimals        *int    `json:"decimals,omitempty"` // default: the org currency's precision
+	PercentDecimals *int    `json:"percent_decimals,omitempty"`
+	Thousands       *bool   `json:"thousands,omitempty"` // default true
+	Negative        string  `json:"negative,omitempty"`  // "minus" (default) or "parens"
+	Scale           float64 `json:"scale,omitempty"`     // divide amounts, e.g. 1000
+	Zero            string  `json:"zero,omitempty"`      // shown instead of zero, e.g. "-"
+	ShowZeroLines   bool    `json:"show_zero_lines,omitempty"`
+}
+
+// Parse reads a definition in the given format (FormatYAML or FormatJSON)
+// and validates it.
+func Parse(data []byte, format string) (*Definition, error) {
+	switch format {
+	case FormatJSON:
+	case FormatYAML:
+		v, err := parseYAML(data)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown definition format %q", format)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	d := &Definition{}
+	if err := dec.Decode(d); err != nil {
+		return nil, fmt.Errorf("definition: %w", err)
+	}
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// FormatOf guesses a source format from a file name.
+func FormatOf(name string) string {
+	if strings.HasSuffix(strings.ToLower(name), ".json") {
+		return FormatJSON
+	}
+	return FormatYAML
+}
+
+var (
+	codePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,99}$`)
+	idPattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Validate fills in defaults and checks ids, types and formulas.
+func (d *Definition) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+
+	if !codePattern.MatchString(d.Code) {
+		fail("code %q must be lower-case letters, digits, - and _", d.Code)
+	}
+	if d.Title == "" {
+		d.Title = d.Code
+	}
+	if len(d.Columns) == 0 || len(d.Rows) == 0 {
+		fail("a report needs at least one column and one row")
+	}
+	switch d.Format.Negative {
+	case "", "minus", "parens":
+	default:
+		fail("format.negative %q must be minus or parens", d.Format.Negative)
+	}
+	if d.Format.Scale < 0 {
+		fail("format.scale must be positive")
+	}
+
+	columns := map[string]*Column{}
+	for i, c := range d.Columns {
+		where := name("column", i, c.ID)
+		if !idPattern.MatchString(c.ID) {
+			fail("%s: id must be a letter or _ followed by letters, digits or _", where)
+		} else if columns[c.ID] != nil {
+			fail("%s: duplicate id", where)
+		}
+		columns[c.ID] = c
+		if c.Type == "" {
+			c.Type = ColumnActual
+			if c.Formula != "" {
+				c.Type = ColumnFormula
+			}
+		}
+		switch c.Type {
+		case ColumnActual, ColumnBudget:
+			if c.Period == nil {
+				c.Period = &Period{Type: PeriodMonth}
+			}
+			if err := c.Period.validate(); err != nil {
+				fail("%s: %v", where, err)
+			}
+			if c.Formula != "" {
+				fail("%s: only formula columns have a formula", where)
+			}
+			if c.Type == ColumnBudget && (len(c.Filter) > 0 || c.Each != "") {
+				fail("%s: budgets are not kept by dimension", where)
+			}
+		case ColumnFormula:
+			if c.Period != nil || len(c.Filter) > 0 || c.Each != "" {
+				fail("%s: a formula column has no period or dimensions of its own", where)
+			}
+		default:
+			fail("%s: unknown type %q", where, c.Type)
+		}
+		if c.Format != "" && c.Format != "percent" {
+			fail("%s: unknown format %q", where, c.Format)
+		}
+	}
+	for _, c := range d.Columns {
+		if c.Type != ColumnFormula {
+			continue
+		}
+		e, err := parseExpr(c.Formula)
+		if err != nil {
+			fail("column %s: %v", c.ID, err)
+			continue
+		}
+		for _, ref := range e.refs(nil) {
+			if columns[ref] == nil {
+				fail("column %s: formula refers to unknown column %s", c.ID, ref)
+			} else if columns[ref].Each != "" {
+				fail("column %s: formula refers to %s, which expands by %s", c.ID, ref, columns[ref].Each)
+			}
+		}
+	}
+
+	rows := map[string]*Row{}
+	for i, r := range d.Rows {
+		where := name("row", i, r.ID)
+		if r.ID != "" {
+			if !idPattern.MatchString(r.ID) {
+				fail("%s: id must be a letter or _ followed by letters, digits or _", where)
+			} else if rows[r.ID] != nil || columns[r.ID] != nil {
+				fail("%s: duplicate id", where)
+			}
+			rows[r.ID] = r
+		}
+		if r.Type == "" {
+			switch {
+			case r.Formula != "":
+				r.Type = RowFormula
+			case len(r.Accounts) > 0:
+				r.Type = RowAccounts
+			default:
+				r.Type = RowHeader
+			}
+		}
+		switch r.Type {
+		case RowAccounts:
+			if len(r.Accounts) == 0 {
+				fail("%s: no account selectors", where)
+			}
+			for _, s := range r.Accounts {
+				if _, err := ParseSelector(s); err != nil {
+					fail("%s: %v", where, err)
+				}
+			}
+		case RowFormula:
+			if r.Expand {
+				fail("%s: a formula row cannot expand", where)
+			}
+		case RowHeader, RowBlank:
+			if r.Formula != "" || len(r.Accounts) > 0 {
+				fail("%s: a %s row has no amounts", where, r.Type)
+			}
+		default:
+			fail("%s: unknown type %q", where, r.Type)
+		}
+		if r.Amount == "" {
+			r.Amount = AmountActivity
+		}
+		switch r.Amount {
+		case AmountActivity, AmountBalance, AmountOpening:
+		default:
+			fail("%s: unknown amount %q", where, r.Amount)
+		}
+		if r.Sign == "" {
+			r.Sign = SignNatural
+		}
+		switch r.Sign {
+		case SignNatural, SignDebit, SignCredit:
+		default:
+			fail("%s: unknown sign %q", where, r.Sign)
+		}
+		switch r.Style {
+		case "", "bold", "subtotal", "total":
+		default:
+			fail("%s: unknown style %q", where, r.Style)
+		}
+		if r.Format != "" && r.Format != "percent" {
+			fail("%s: unknown format %q", where, r.Format)
+		}
+	}
+	for i, r := range d.Rows {
+		if r.Type != RowFormula {
+			continue
+		}
+		where := name("row", i, r.ID)
+		e, err := parseExpr(r.Formula)
+		if err != nil {
+			fail("%s: %v", where, err)
+			continue
+		}
+		for _, ref := range e.refs(nil) {
+			if rows[ref] == nil {
+				fail("%s: formula refers to unknown row %s", where, ref)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// name is how validation errors refer to a row or column.
+func name(kind string, i int, id string) string {
+	if id != "" {
+		return kind + " " + id
+	}
+	return fmt.Sprintf("%s %d", kind, i+1)
+}
+
+func (p *Period) validate() error {
+	if p.Type == "" {
+		p.Type = PeriodMonth
+		if p.From != "" || p.To != "" {
+			p.Type = PeriodCustom
+		}
+	}
+	switch p.Type {
+	case PeriodMonth, PeriodQuarter, PeriodYear, PeriodMTD, PeriodQTD, PeriodYTD:
+		if p.From != "" || p.To != "" {
+			return fmt.Errorf("a %s period has no from/to dates", p.Type)
+		}
+	case PeriodCustom:
+		from, err := time.Parse(time.DateOnly, p.From)
+		if err != nil {
+			return fmt.Errorf("period from %q: use YYYY-MM-DD", p.From)
+		}
+		to, err := time.Parse(time.DateOnly, p.To)
+		if err != nil {
+			return fmt.Errorf("period to %q: use YYYY-MM-DD", p.To)
+		}
+		if to.Before(from) {
+			return fmt.Errorf("period ends before it starts")
+		}
+	default:
+		return fmt.Errorf("unknown period type %q", p.Type)
+	}
+	return nil
+}
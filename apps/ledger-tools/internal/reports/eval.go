@@ -0,0 +1,432 @@
+package reports
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/dimensions"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+)
+
+// Table is an evaluated report. Values are in the org currency's major
+// units, before the definition's scale; NaN is an empty cell.
+type Table struct {
+	Title    string
+	Subtitle string
+	Code     string
+	Version  int
+	Currency string
+	AsOf     time.Time
+	Columns  []TableColumn
+	Lines    []*Line
+	Format   Format // with defaults filled in
+}
+
+// TableColumn is a visible column.
+type TableColumn struct {
+	ID      string
+	Label   string
+	Percent bool
+}
+
+// Line is a rendered row. Headers and blanks have no values.
+type Line struct {
+	Label   string
+	Indent  int
+	Style   string // "", "header", "bold", "subtotal" or "total"
+	Percent bool
+	Values  []float64
+}
+
+// column is a definition column after dimension expansion.
+type column struct {
+	def      *Column
+	id       string
+	label    string
+	filter   map[string]string
+	from, to time.Time
+	sums     map[string]*sums // by OA account
+}
+
+type sums struct{ opening, activity money.Amount }
+
+// Evaluate runs a definition against the organization's OA ledger as of a
+// day.
+func Evaluate(ctx context.Context, cf, oadb cashflow.Querier, organizationID string, d *Definition, asOf time.Time) (*Table, error) {
+	orgID, err := oa.OrgForOrganization(ctx, cf, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	org, err := oa.GetOrg(ctx, oadb, orgID)
+	if err != nil {
+		return nil, err
+	}
+	chart, err := oa.LoadChart(ctx, oadb, orgID)
+	if err != nil {
+		return nil, err
+	}
+	fy, err := fiscalYear(ctx, cf, organizationID, asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	cols, err := expand(ctx, cf, organizationID, d.Columns)
+	if err != nil {
+		return nil, err
+	}
+	var end time.Time
+	for _, c := range cols {
+		if c.def.Type == ColumnFormula {
+			if c.label == "" {
+				c.label = c.id
+			}
+			continue
+		}
+		var period string
+		c.from, c.to, period = c.def.Period.resolve(asOf, fy)
+		c.label = strings.ReplaceAll(c.label, "{period}", period)
+		if c.label == "" {
+			c.label = period
+		}
+		if c.to.After(end) {
+			end = c.to
+		}
+	}
+
+	// Accounts of every account row, by full path.
+	accounts := map[*Row][]string{}
+	for _, r := range d.Rows {
+		if r.Type != RowAccounts {
+			continue
+		}
+		var sels []*Selector
+		for _, s := range r.Accounts {
+			sel, _ := ParseSelector(s)
+			sels = append(sels, sel)
+		}
+		for id := range chart.Accounts {
+			if chart.Leaf(id) && matchAll(sels, chart.FullName(id), chart.Class(id)) {
+				accounts[r] = append(accounts[r], id)
+			}
+		}
+		sort.Slice(accounts[r], func(i, j int) bool {
+			return chart.FullName(accounts[r][i]) < chart.FullName(accounts[r][j])
+		})
+	}
+
+	if err := load(ctx, cf, oadb, organizationID, org, cols, end); err != nil {
+		return nil, err
+	}
+	budget, err := budgets(ctx, oadb, orgID, chart)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Table{
+		Title: d.Title, Subtitle: d.Subtitle, Code: d.Code, Currency: org.Currency, AsOf: asOf, Format: d.Format,
+	}
+	if t.Format.Decimals == nil {
+		t.Format.Decimals = new(int)
+		*t.Format.Decimals = min(org.Precision, 2)
+	}
+	if t.Format.PercentDecimals == nil {
+		t.Format.PercentDecimals = new(int)
+		*t.Format.PercentDecimals = 1
+	}
+	if t.Format.Thousands == nil {
+		t.Format.Thousands = new(bool)
+		*t.Format.Thousands = true
+	}
+	if t.Format.Scale == 0 {
+		t.Format.Scale = 1
+	}
+	for _, c := range cols {
+		if !c.def.Hidden {
+			t.Columns = append(t.Columns, TableColumn{ID: c.id, Label: c.label, Percent: c.def.Format == "percent"})
+		}
+	}
+
+	// cell is one account's amount in a data column, on the row's side.
+	cell := func(r *Row, c *column, account string) float64 {
+		var amount money.Amount
+		if c.def.Type == ColumnBudget {
+			if r.Amount != AmountActivity {
+				return math.NaN()
+			}
+			amount = budget[account].Mul(yearFraction(c.from, c.to))
+		} else if s := c.sums[account]; s != nil {
+			switch r.Amount {
+			case AmountActivity:
+				amount = s.activity
+			case AmountOpening:
+				amount = s.opening
+			case AmountBalance:
+				amount = s.opening + s.activity
+			}
+		}
+		switch r.Sign {
+		case SignCredit:
+			amount = -amount
+		case SignNatural:
+			if !cashflow.DebitNormal(chart.Class(account)) {
+				amount = -amount
+			}
+		}
+		return amount.Float()
+	}
+
+	// Row values by row id and column id, for row formulas.
+	rows := map[string]*Row{}
+	for _, r := range d.Rows {
+		if r.ID != "" {
+			rows[r.ID] = r
+		}
+	}
+	values := map[*Row]map[string]float64{}
+	var rowValue func(r *Row, c *column, seen map[*Row]bool) (float64, error)
+	rowValue = func(r *Row, c *column, seen map[*Row]bool) (float64, error) {
+		if v, ok := values[r][c.id]; ok {
+			return v, nil
+		}
+		var v float64
+		switch r.Type {
+		case RowAccounts:
+			for _, a := range accounts[r] {
+				v += cell(r, c, a)
+			}
+		case RowFormula:
+			if seen[r] {
+				return 0, fmt.Errorf("row %s: formula refers to itself", r.ID)
+			}
+			seen[r] = true
+			e, _ := parseExpr(r.Formula)
+			var err error
+			v, err = e.eval(func(id string) (float64, error) { return rowValue(rows[id], c, seen) })
+			delete(seen, r)
+			if err != nil {
+				return 0, err
+			}
+		}
+		if values[r] == nil {
+			values[r] = map[string]float64{}
+		}
+		values[r][c.id] = v
+		return v, nil
+	}
+
+	colByID := map[string]*column{}
+	for _, c := range cols {
+		colByID[c.id] = c
+	}
+	// line computes a line's data columns with data, then its formula
+	// columns from them.
+	line := func(data func(c *column) (float64, error)) ([]float64, error) {
+		byID := map[string]float64{}
+		done := map[string]bool{}
+		var colValue func(c *column, seen map[string]bool) (float64, error)
+		colValue = func(c *column, seen map[string]bool) (float64, error) {
+			if done[c.id] {
+				return byID[c.id], nil
+			}
+			var v float64
+			var err error
+			if c.def.Type == ColumnFormula {
+				if seen[c.id] {
+					return 0, fmt.Errorf("column %s: formula refers to itself", c.id)
+				}
+				seen[c.id] = true
+				e, _ := parseExpr(c.def.Formula)
+				v, err = e.eval(func(id string) (float64, error) { return colValue(colByID[id], seen) })
+				delete(seen, c.id)
+			} else {
+				v, err = data(c)
+			}
+			if err != nil {
+				return 0, err
+			}
+			byID[c.id], done[c.id] = v, true
+			return v, nil
+		}
+		var out []float64
+		for _, c := range cols {
+			v, err := colValue(c, map[string]bool{})
+			if err != nil {
+				return nil, err
+			}
+			if !c.def.Hidden {
+				out = append(out, v)
+			}
+		}
+		return out, nil
+	}
+
+	zero := func(vs []float64) bool {
+		for _, v := range vs {
+			if v != 0 && !math.IsNaN(v) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, r := range d.Rows {
+		if r.Hidden {
+			continue
+		}
+		l := &Line{Label: r.Label, Indent: r.Indent, Style: r.Style, Percent: r.Format == "percent"}
+		switch r.Type {
+		case RowHeader:
+			l.Style = "header"
+			t.Lines = append(t.Lines, l)
+			continue
+		case RowBlank:
+			t.Lines = append(t.Lines, &Line{})
+			continue
+		}
+		if r.Expand {
+			t.Lines = append(t.Lines, &Line{Label: r.Label, Indent: r.Indent, Style: "header"})
+			for _, a := range accounts[r] {
+				vs, err := line(func(c *column) (float64, error) { return cell(r, c, a), nil })
+				if err != nil {
+					return nil, err
+				}
+				if zero(vs) && !t.Format.ShowZeroLines {
+					continue
+				}
+				t.Lines = append(t.Lines, &Line{Label: chart.Accounts[a].Name, Indent: r.Indent + 1, Percent: l.Percent, Values: vs})
+			}
+			l.Label = "Total " + r.Label
+			if l.Style == "" {
+				l.Style = "subtotal"
+			}
+		}
+		vs, err := line(func(c *column) (float64, error) { return rowValue(r, c, map[*Row]bool{}) })
+		if err != nil {
+			return nil, err
+		}
+		l.Values = vs
+		t.Lines = append(t.Lines, l)
+	}
+	return t, nil
+}
+
+// expand turns columns with each into one column per dimension value.
+func expand(ctx context.Context, q cashflow.Querier, organizationID string, defs []*Column) ([]*column, error) {
+	var out []*column
+	for _, d := range defs {
+		if d.Each == "" {
+			out = append(out, &column{def: d, id: d.ID, label: d.Label, filter: d.Filter})
+			continue
+		}
+		values, err := dimensions.Values(ctx, q, organizationID, d.Each)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("column %s: dimension %s has no values", d.ID, d.Each)
+		}
+		for _, v := range values {
+			filter := map[string]string{d.Each: v.Code}
+			for k, val := range d.Filter {
+				filter[k] = val
+			}
+			label := d.Label
+			if label == "" {
+				label = "{value}"
+			}
+			out = append(out, &column{
+				def: d, id: d.ID + "[" + v.Code + "]", label: strings.ReplaceAll(label, "{value}", v.Name), filter: filter,
+			})
+		}
+	}
+	return out, nil
+}
+
+// load adds up OA splits before end into each actual column's sums,
+// applying the column's dimension filter.
+func load(ctx context.Context, cf, oadb cashflow.Querier, organizationID string, org *oa.Org, cols []*column, end time.Time) error {
+	tags := map[string]map[string]string{}
+	var data []*column
+	for _, c := range cols {
+		if c.def.Type != ColumnActual {
+			continue
+		}
+		c.sums = map[string]*sums{}
+		data = append(data, c)
+		for dim := range c.filter {
+			if tags[dim] != nil {
+				continue
+			}
+			values, err := dimensions.SplitValues(ctx, cf, organizationID, dimensions.LedgerOA, dim)
+			if err != nil {
+				return err
+			}
+			tags[dim] = values
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	return oa.Splits(ctx, oadb, org.ID, time.Time{}, end, func(s *oa.Split) error {
+		amount := money.FromMinor(s.Amount, org.Precision)
+		id := strconv.FormatInt(s.ID, 10)
+	columns:
+		for _, c := range data {
+			if !s.Date.Before(c.to) {
+				continue
+			}
+			for dim, value := range c.filter {
+				if tags[dim][id] != value {
+					continue columns
+				}
+			}
+			sum := c.sums[s.AccountID]
+			if sum == nil {
+				sum = &sums{}
+				c.sums[s.AccountID] = sum
+			}
+			if s.Date.Before(c.from) {
+				sum.opening += amount
+			} else {
+				sum.activity += amount
+			}
+		}
+		return nil
+	})
+}
+
+// budgets reads the org's current OA budget: the budgetitem rows saved
+// last, one annual amount per account in the account's minor units, signed
+// like splits.
+func budgets(ctx context.Context, q cashflow.Querier, orgID string, chart *oa.Chart) (map[string]money.Amount, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT LOWER(HEX(accountId)), amount FROM budgetitem
+		WHERE orgId = UNHEX(?) AND inserted = (SELECT MAX(inserted) FROM budgetitem WHERE orgId = UNHEX(?))`,
+		oa.NormalizeID(orgID), oa.NormalizeID(orgID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]money.Amount{}
+	for rows.Next() {
+		var account string
+		var amount int64
+		if err := rows.Scan(&account, &amount); err != nil {
+			return nil, err
+		}
+		precision := 2
+		if a := chart.Accounts[account]; a != nil {
+			precision = a.Precision
+		}
+		out[account] += money.FromMinor(amount, precision)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,230 @@
+package reports
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expr is a parsed formula: numbers, row or column ids, + - * /,
+// parentheses and the functions sum, abs, min and max. Division by zero
+// gives NaN, which renders as an empty cell.
+type expr interface {
+	eval(lookup func(id string) (float64, error)) (float64, error)
+	refs(into []string) []string
+}
+
+type number float64
+
+type ref string
+
+type unary struct{ x expr }
+
+type binary struct {
+	op   byte
+	x, y expr
+}
+
+type call struct {
+	fn   string
+	args []expr
+}
+
+func (n number) eval(func(string) (float64, error)) (float64, error) { return float64(n), nil }
+func (n number) refs(into []string) []string                         { return into }
+
+func (r ref) eval(lookup func(string) (float64, error)) (float64, error) { return lookup(string(r)) }
+func (r ref) refs(into []string) []string                                { return append(into, string(r)) }
+
+func (u unary) eval(lookup func(string) (float64, error)) (float64, error) {
+	v, err := u.x.eval(lookup)
+	return -v, err
+}
+func (u unary) refs(into []string) []string { return u.x.refs(into) }
+
+func (b binary) eval(lookup func(string) (float64, error)) (float64, error) {
+	x, err := b.x.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+	y, err := b.y.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+	switch b.op {
+	case '+':
+		return x + y, nil
+	case '-':
+		return x - y, nil
+	case '*':
+		return x * y, nil
+	}
+	if y == 0 {
+		return math.NaN(), nil
+	}
+	return x / y, nil
+}
+func (b binary) refs(into []string) []string { return b.y.refs(b.x.refs(into)) }
+
+func (c call) eval(lookup func(string) (float64, error)) (float64, error) {
+	vals := make([]float64, len(c.args))
+	for i, a := range c.args {
+		v, err := a.eval(lookup)
+		if err != nil {
+			return 0, err
+		}
+		vals[i] = v
+	}
+	out := vals[0]
+	switch c.fn {
+	case "abs":
+		return math.Abs(out), nil
+	case "sum":
+		for _, v := range vals[1:] {
+			out += v
+		}
+	case "min":
+		for _, v := range vals[1:] {
+			out = math.Min(out, v)
+		}
+	case "max":
+		for _, v := range vals[1:] {
+			out = math.Max(out, v)
+		}
+	}
+	return out, nil
+}
+func (c call) refs(into []string) []string {
+	for _, a := range c.args {
+		into = a.refs(into)
+	}
+	return into
+}
+
+var functions = map[string]bool{"sum": true, "abs": true, "min": true, "max": true}
+
+// parseExpr parses a formula.
+func parseExpr(s string) (expr, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("empty formula")
+	}
+	p := &exprParser{src: s}
+	e, err := p.sum()
+	if err != nil {
+		return nil, fmt.Errorf("formula %q: %w", s, err)
+	}
+	if p.skip(); p.pos < len(p.src) {
+		return nil, fmt.Errorf("formula %q: unexpected %q", s, p.src[p.pos:])
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	src string
+	pos int
+}
+
+func (p *exprParser) skip() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *exprParser) peek() byte {
+	if p.skip(); p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) sum() (expr, error) {
+	x, err := p.product()
+	for err == nil && (p.peek() == '+' || p.peek() == '-') {
+		op := p.src[p.pos]
+		p.pos++
+		var y expr
+		if y, err = p.product(); err == nil {
+			x = binary{op: op, x: x, y: y}
+		}
+	}
+	return x, err
+}
+
+func (p *exprParser) product() (expr, error) {
+	x, err := p.factor()
+	for err == nil && (p.peek() == '*' || p.peek() == '/') {
+		op := p.src[p.pos]
+		p.pos++
+		var y expr
+		if y, err = p.factor(); err == nil {
+			x = binary{op: op, x: x, y: y}
+		}
+	}
+	return x, err
+}
+
+func (p *exprParser) factor() (expr, error) {
+	switch c := p.peek(); {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end")
+	case c == '-':
+		p.pos++
+		x, err := p.factor()
+		return unary{x: x}, err
+	case c == '(':
+		p.pos++
+		x, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return x, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] == '.' || (p.src[p.pos] >= '0' && p.src[p.pos] <= '9')) {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		return number(v), err
+	case c == '_' || unicode.IsLetter(rune(c)):
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		if p.peek() != '(' {
+			return ref(name), nil
+		}
+		if !functions[name] {
+			return nil, fmt.Errorf("unknown function %s", name)
+		}
+		p.pos++
+		var args []expr
+		for {
+			a, err := p.sum()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+			if p.peek() == ',' {
+				p.pos++
+				continue
+			}
+			if p.peek() != ')' {
+				return nil, fmt.Errorf("missing ) after %s arguments", name)
+			}
+			p.pos++
+			if name == "abs" && len(args) != 1 {
+				return nil, fmt.Errorf("abs takes one argument")
+			}
+			return call{fn: name, args: args}, nil
+		}
+	default:
+		return nil, fmt.Errorf("unexpected %q", string(c))
+	}
+}
@@ -0,0 +1,123 @@
+package reports
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	values := map[string]float64{"revenue": 1000, "cogs": 400, "opex": 250, "zero": 0, "row_2": 7}
+	lookup := func(id string) (float64, error) {
+		v, ok := values[id]
+		if !ok {
+			return 0, fmt.Errorf("unknown row %s", id)
+		}
+		return v, nil
+	}
+
+	tests := []struct {
+		in   string
+		want float64
+		refs []string
+	}{
+		{in: "42", want: 42},
+		{in: ".5", want: 0.5},
+		{in: "revenue - cogs", want: 600, refs: []string{"revenue", "cogs"}},
+		{in: "revenue - cogs - opex", want: 350, refs: []string{"revenue", "cogs", "opex"}},
+		{in: "revenue - cogs * 2", want: 200, refs: []string{"revenue", "cogs"}},
+		{in: "(revenue - cogs) * 2", want: 1200, refs: []string{"revenue", "cogs"}},
+		{in: "revenue / cogs / 2", want: 1.25, refs: []string{"revenue", "cogs"}},
+		{in: "-cogs + revenue", want: 600, refs: []string{"cogs", "revenue"}},
+		{in: "--cogs", want: 400, refs: []string{"cogs"}},
+		{in: "  (revenue-cogs)/revenue*100 ", want: 60, refs: []string{"revenue", "cogs", "revenue"}},
+		{in: "sum(revenue, -cogs, -opex)", want: 350, refs: []string{"revenue", "cogs", "opex"}},
+		{in: "sum(opex)", want: 250, refs: []string{"opex"}},
+		{in: "abs(cogs - revenue)", want: 600, refs: []string{"cogs", "revenue"}},
+		{in: "min(revenue, cogs, opex)", want: 250, refs: []string{"revenue", "cogs", "opex"}},
+		{in: "max(revenue, cogs) - min(1, 2)", want: 999, refs: []string{"revenue", "cogs"}},
+		{in: "row_2 * 3", want: 21, refs: []string{"row_2"}},
+	}
+	for _, tt := range tests {
+		e, err := parseExpr(tt.in)
+		if err != nil {
+			t.Errorf("parseExpr(%q): %v", tt.in, err)
+			continue
+		}
+		got, err := e.eval(lookup)
+		if err != nil {
+			t.Errorf("%q: eval: %v", tt.in, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%q = %v, want %v", tt.in, got, tt.want)
+		}
+		if refs := e.refs(nil); !reflect.DeepEqual(refs, tt.refs) {
+			t.Errorf("%q refs = %v, want %v", tt.in, refs, tt.refs)
+		}
+	}
+}
+
+func TestExprDivisionByZero(t *testing.T) {
+	for _, in := range []string{"revenue / zero", "1 / 0", "abs(1 / (revenue - revenue))"} {
+		e, err := parseExpr(in)
+		if err != nil {
+			t.Fatalf("parseExpr(%q): %v", in, err)
+		}
+		got, err := e.eval(func(id string) (float64, error) {
+			if id == "zero" {
+				return 0, nil
+			}
+			return 5, nil
+		})
+		if err != nil {
+			t.Fatalf("%q: eval: %v", in, err)
+		}
+		if !math.IsNaN(got) {
+			t.Errorf("%q = %v, want NaN", in, got)
+		}
+	}
+}
+
+func TestExprLookupError(t *testing.T) {
+	e, err := parseExpr("revenue + missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = e.eval(func(id string) (float64, error) {
+		if id == "missing" {
+			return 0, fmt.Errorf("unknown row %s", id)
+		}
+		return 1, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "unknown row missing") {
+		t.Errorf("eval error = %v, want the lookup error", err)
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "", want: "empty formula"},
+		{in: "   ", want: "empty formula"},
+		{in: "revenue +", want: "unexpected end"},
+		{in: "(revenue - cogs", want: "missing )"},
+		{in: "revenue cogs", want: `unexpected "cogs"`},
+		{in: "avg(revenue, cogs)", want: "unknown function avg"},
+		{in: "abs(revenue, cogs)", want: "abs takes one argument"},
+		{in: "sum(revenue, cogs", want: "missing ) after sum arguments"},
+		{in: "revenue % 2", want: `unexpected "% 2"`},
+		{in: "* 2", want: `unexpected "*"`},
+		{in: "1.2.3", want: "invalid syntax"},
+	}
+	for _, tt := range tests {
+		_, err := parseExpr(tt.in)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseExpr(%q) error = %v, want %q", tt.in, err, tt.want)
+		}
+	}
+}
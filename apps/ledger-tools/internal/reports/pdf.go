@@ -0,0 +1,125 @@
+package reports
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// PDF page layout in points. Text is set in Courier, whose glyphs are 0.6
+// of the font size wide, so the text layout carries over unchanged.
+const (
+	pdfMargin     = 36
+	pdfLineHeight = 11
+	pdfCharWidth  = 0.6
+)
+
+// pdf writes the text layout as a PDF using the standard Courier fonts,
+// on A4 portrait or, for wide reports, landscape. Column headings repeat
+// on every page.
+func (t *Table) pdf(w io.Writer) error {
+	lines := t.text()
+	width := 0
+	for _, l := range lines {
+		width = max(width, utf8.RuneCountInString(l.text))
+	}
+	pageW, pageH := 595.0, 842.0
+	size := 9.0
+	if float64(width)*pdfCharWidth*size > pageW-2*pdfMargin {
+		pageW, pageH = pageH, pageW
+	}
+	// Shrink the font rather than cut columns off.
+	size = min(size, (pageW-2*pdfMargin)/(float64(max(width, 1))*pdfCharWidth))
+	lineHeight := pdfLineHeight * size / 9
+	perPage := int((pageH - 2*pdfMargin) / lineHeight)
+
+	var header []textLine
+	for _, l := range lines {
+		if l.header {
+			header = append(header, l)
+		}
+	}
+	var pages [][]textLine
+	var page []textLine
+	for _, l := range lines {
+		if len(page) == perPage {
+			pages = append(pages, page)
+			page = append([]textLine(nil), header...)
+		}
+		page = append(page, l)
+	}
+	pages = append(pages, page)
+
+	// Objects: 1 catalog, 2 page tree, 3 and 4 fonts, then a page and its
+	// content stream for each page.
+	var objects []string
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold /Encoding /WinAnsiEncoding >>",
+	)
+	for i, p := range pages {
+		var content bytes.Buffer
+		y := pageH - pdfMargin - size
+		for _, l := range p {
+			font := "F1"
+			if l.bold {
+				font = "F2"
+			}
+			if l.text != "" {
+				fmt.Fprintf(&content, "BT /%s %.2f Tf %d %.2f Td (%s) Tj ET\n", font, size, pdfMargin, y, pdfString(l.text))
+			}
+			y -= lineHeight
+		}
+		footer := fmt.Sprintf("Page %d of %d", i+1, len(pages))
+		fmt.Fprintf(&content, "BT /F1 7 Tf %d %d Td (%s) Tj ET\n", pdfMargin, pdfMargin/2, footer)
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+				pageW, pageH, 6+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, o := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// pdfString escapes text for a PDF string in WinAnsi; characters outside
+// Latin-1 print as ?.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 255:
+			b.WriteByte('?')
+		case r > 126:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,85 @@
+package reports
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/equity"
+)
+
+// fiscalYear returns the organization's fiscal year containing day.
+func fiscalYear(ctx context.Context, q cashflow.Querier, organizationID string, day time.Time) (equity.Year, error) {
+	years, err := equity.Years(ctx, q, organizationID, day.Year()-1, day.Year())
+	if err != nil {
+		return equity.Year{}, err
+	}
+	for _, y := range years {
+		if !day.Before(y.Start) && day.Before(y.End) {
+			return y, nil
+		}
+	}
+	return equity.Year{}, fmt.Errorf("no fiscal year contains %s", day.Format(time.DateOnly))
+}
+
+// resolve turns a period into dates [from, to) and a label for a report
+// run as of asOf, whose fiscal year is fy.
+func (p *Period) resolve(asOf time.Time, fy equity.Year) (from, to time.Time, label string) {
+	asOf = time.Date(asOf.Year(), asOf.Month(), asOf.Day(), 0, 0, 0, 0, time.Local)
+	next := asOf.AddDate(0, 0, 1)
+	month := time.Date(asOf.Year(), asOf.Month(), 1, 0, 0, 0, 0, time.Local)
+
+	// Fiscal quarters count from the start of the fiscal year.
+	elapsed := (asOf.Year()-fy.Start.Year())*12 + int(asOf.Month()) - int(fy.Start.Month())
+	if asOf.Day() < fy.Start.Day() {
+		elapsed--
+	}
+	quarter := elapsed / 3
+
+	switch p.Type {
+	case PeriodMonth:
+		from = month.AddDate(0, p.Offset, 0)
+		return from, from.AddDate(0, 1, 0), from.Format("Jan 2006")
+	case PeriodMTD:
+		from = month.AddDate(0, p.Offset, 0)
+		to = next.AddDate(0, p.Offset, 0)
+		return from, to, "MTD " + to.AddDate(0, 0, -1).Format("2 Jan 2006")
+	case PeriodQuarter, PeriodQTD:
+		k := quarter + p.Offset
+		from = fy.Start.AddDate(0, 3*k, 0)
+		year, q := fy.FiscalYear+floorDiv(k, 4), k-4*floorDiv(k, 4)+1
+		if p.Type == PeriodQTD {
+			to = next.AddDate(0, 3*p.Offset, 0)
+			return from, to, fmt.Sprintf("Q%d FY%d to date", q, year)
+		}
+		return from, from.AddDate(0, 3, 0), fmt.Sprintf("Q%d FY%d", q, year)
+	case PeriodYear:
+		from = fy.Start.AddDate(p.Offset, 0, 0)
+		return from, fy.End.AddDate(p.Offset, 0, 0), fmt.Sprintf("FY%d", fy.FiscalYear+p.Offset)
+	case PeriodYTD:
+		from = fy.Start.AddDate(p.Offset, 0, 0)
+		to = next.AddDate(p.Offset, 0, 0)
+		return from, to, fmt.Sprintf("FY%d YTD", fy.FiscalYear+p.Offset)
+	}
+	from, _ = time.ParseInLocation(time.DateOnly, p.From, time.Local)
+	to, _ = time.ParseInLocation(time.DateOnly, p.To, time.Local)
+	return from, to.AddDate(0, 0, 1), p.From + " to " + p.To
+}
+
+func floorDiv(a, b int) int {
+	if a < 0 && a%b != 0 {
+		return a/b - 1
+	}
+	return a / b
+}
+
+// yearFraction is the share of a year a period covers: whole months count
+// as twelfths, anything else by days.
+func yearFraction(from, to time.Time) float64 {
+	if from.Day() == 1 && to.Day() == 1 {
+		months := (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month())
+		return float64(months) / 12
+	}
+	return to.Sub(from).Hours() / 24 / 365
+}
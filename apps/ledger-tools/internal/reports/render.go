@@ -0,0 +1,226 @@
+package reports
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Output formats.
+const (
+	OutputText = "text"
+	OutputCSV  = "csv"
+	OutputXLSX = "xlsx"
+	OutputPDF  = "pdf"
+)
+
+// Render writes the table in an output format.
+func (t *Table) Render(w io.Writer, format string) error {
+	switch format {
+	case OutputText:
+		for _, l := range t.text() {
+			if _, err := fmt.Fprintln(w, l.text); err != nil {
+				return err
+			}
+		}
+		return nil
+	case OutputCSV:
+		return t.csv(w)
+	case OutputXLSX:
+		return t.xlsx(w)
+	case OutputPDF:
+		return t.pdf(w)
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+// Extension is the file extension of an output format.
+func Extension(format string) string {
+	if format == OutputText {
+		return "txt"
+	}
+	return format
+}
+
+// ContentType is the MIME type of an output format.
+func ContentType(format string) string {
+	switch format {
+	case OutputCSV:
+		return "text/csv; charset=utf-8"
+	case OutputXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case OutputPDF:
+		return "application/pdf"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// heading is the title block every format starts with.
+func (t *Table) heading() []string {
+	out := []string{t.Title}
+	if t.Subtitle != "" {
+		out = append(out, t.Subtitle)
+	}
+	info := fmt.Sprintf("As of %s, %s", t.AsOf.Format(time.DateOnly), t.Currency)
+	if t.Format.Scale != 1 {
+		info += fmt.Sprintf(" in %gs", t.Format.Scale)
+	}
+	if t.Version > 0 {
+		info += fmt.Sprintf(" (%s v%d)", t.Code, t.Version)
+	}
+	return append(out, info)
+}
+
+func (t *Table) percent(l *Line, i int) bool { return l.Percent || t.Columns[i].Percent }
+
+// scaled is a cell's value as shown: amounts divided by the scale,
+// percentages times 100, both rounded to their decimals.
+func (t *Table) scaled(v float64, percent bool) (float64, int) {
+	if percent {
+		return v * 100, *t.Format.PercentDecimals
+	}
+	return v / t.Format.Scale, *t.Format.Decimals
+}
+
+// number formats a cell; NaN is empty.
+func (t *Table) number(v float64, percent bool) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return ""
+	}
+	v, decimals := t.scaled(v, percent)
+	s := strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+	if strings.Trim(s, "0.") == "" {
+		if t.Format.Zero != "" {
+			return t.Format.Zero
+		}
+		v = 0
+	}
+	if *t.Format.Thousands {
+		whole, frac, _ := strings.Cut(s, ".")
+		var b strings.Builder
+		for i, c := range whole {
+			if i > 0 && (len(whole)-i)%3 == 0 {
+				b.WriteByte(',')
+			}
+			b.WriteRune(c)
+		}
+		s = b.String()
+		if frac != "" {
+			s += "." + frac
+		}
+	}
+	if percent {
+		s += "%"
+	}
+	if v < 0 {
+		if t.Format.Negative == "parens" {
+			return "(" + s + ")"
+		}
+		return "-" + s
+	}
+	return s
+}
+
+type textLine struct {
+	text   string
+	bold   bool
+	header bool // repeated at the top of every PDF page
+}
+
+// text lays the table out in fixed-width lines.
+func (t *Table) text() []textLine {
+	labels := make([]string, len(t.Lines))
+	cells := make([][]string, len(t.Lines))
+	labelWidth := 0
+	widths := make([]int, len(t.Columns))
+	for i, c := range t.Columns {
+		widths[i] = utf8.RuneCountInString(c.Label)
+	}
+	for i, l := range t.Lines {
+		labels[i] = strings.Repeat("  ", l.Indent) + l.Label
+		labelWidth = max(labelWidth, utf8.RuneCountInString(labels[i]))
+		for j, v := range l.Values {
+			s := t.number(v, t.percent(l, j))
+			if t.Format.Negative == "parens" && !strings.HasSuffix(s, ")") {
+				s += " " // keep digits lined up with bracketed negatives
+			}
+			cells[i] = append(cells[i], s)
+			widths[j] = max(widths[j], utf8.RuneCountInString(s))
+		}
+	}
+
+	pad := func(s string, n int, right bool) string {
+		gap := strings.Repeat(" ", max(0, n-utf8.RuneCountInString(s)))
+		if right {
+			return gap + s
+		}
+		return s + gap
+	}
+	rule := func(c string) string {
+		s := strings.Repeat(" ", labelWidth)
+		for _, w := range widths {
+			s += "  " + strings.Repeat(c, w)
+		}
+		return s
+	}
+
+	var out []textLine
+	for _, h := range t.heading() {
+		out = append(out, textLine{text: h, bold: true})
+	}
+	out = append(out, textLine{})
+	header := pad("", labelWidth, false)
+	for i, c := range t.Columns {
+		header += "  " + pad(c.Label, widths[i], true)
+	}
+	out = append(out, textLine{text: header, bold: true, header: true}, textLine{text: rule("-"), header: true})
+
+	for i, l := range t.Lines {
+		if l.Style == "subtotal" || l.Style == "total" {
+			out = append(out, textLine{text: rule("-")})
+		}
+		s := pad(labels[i], labelWidth, false)
+		for j, c := range cells[i] {
+			s += "  " + pad(c, widths[j], true)
+		}
+		out = append(out, textLine{text: strings.TrimRight(s, " "), bold: l.Style != ""})
+		if l.Style == "total" {
+			out = append(out, textLine{text: rule("=")})
+		}
+	}
+	return out
+}
+
+// csv writes one record per line with plain numbers: no grouping, minus
+// signs, percentages as percent.
+func (t *Table) csv(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := []string{""}
+	for _, c := range t.Columns {
+		header = append(header, c.Label)
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, l := range t.Lines {
+		record := []string{l.Label}
+		for j, v := range l.Values {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				record = append(record, "")
+				continue
+			}
+			v, decimals := t.scaled(v, t.percent(l, j))
+			record = append(record, strconv.FormatFloat(v, 'f', decimals, 64))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
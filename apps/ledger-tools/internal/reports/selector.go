@@ -0,0 +1,88 @@
+package reports
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+)
+
+// Selector picks OA accounts for a row by their full path, as OA displays
+// it (Expenses:Office:Rent):
+//
+//	Expenses:Office       the account and everything below it
+//	Expenses:*:Rent       a glob over the full path; * stops at colons
+//	class:income          every account of a statement class
+//	!Income:Interest      excludes what the rest of the row selects
+type Selector struct {
+	Exclude bool
+	Class   string
+	Pattern string
+	Glob    bool
+}
+
+var classes = map[string]bool{
+	cashflow.ClassAsset: true, cashflow.ClassLiability: true, cashflow.ClassEquity: true,
+	cashflow.ClassIncome: true, cashflow.ClassExpense: true,
+}
+
+// ParseSelector parses one account selector.
+func ParseSelector(s string) (*Selector, error) {
+	sel := &Selector{}
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "!") {
+		sel.Exclude, s = true, strings.TrimSpace(s[1:])
+	}
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("empty account selector")
+	case strings.HasPrefix(s, "class:"):
+		sel.Class = strings.ToLower(strings.TrimPrefix(s, "class:"))
+		if !classes[sel.Class] {
+			return nil, fmt.Errorf("unknown account class %q", sel.Class)
+		}
+	default:
+		sel.Pattern = strings.ToLower(s)
+		if strings.ContainsAny(s, "*?[") {
+			sel.Glob = true
+			// Colons stand in for path.Match's slashes so * stays in one level.
+			if _, err := path.Match(strings.ReplaceAll(sel.Pattern, ":", "/"), ""); err != nil {
+				return nil, fmt.Errorf("account selector %q: %w", s, err)
+			}
+		}
+	}
+	return sel, nil
+}
+
+// Match reports whether an account with the given full path and class is
+// selected.
+func (s *Selector) Match(fullName, class string) bool {
+	if s.Class != "" {
+		return class == s.Class
+	}
+	name := strings.ToLower(fullName)
+	if s.Glob {
+		ok, _ := path.Match(strings.ReplaceAll(s.Pattern, ":", "/"), strings.ReplaceAll(name, ":", "/"))
+		return ok
+	}
+	return name == s.Pattern || strings.HasPrefix(name, s.Pattern+":")
+}
+
+// matchAll applies a row's selectors: an account is in the row when an
+// including selector matches it and no excluding one does. A row of only
+// exclusions starts from every account.
+func matchAll(sels []*Selector, fullName, class string) bool {
+	in, includes := false, false
+	for _, s := range sels {
+		if s.Exclude {
+			if s.Match(fullName, class) {
+				return false
+			}
+			continue
+		}
+		includes = true
+		in = in || s.Match(fullName, class)
+	}
+	return in || !includes
+}
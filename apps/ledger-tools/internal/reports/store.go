@@ -0,0 +1,155 @@
+package reports
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Tables are the cashflowdb tables owned by the report builder.
+var Tables = []schema.Table{
+	{
+		Name: "report_definitions",
+		Create: `CREATE TABLE IF NOT EXISTS report_definitions (
+  organization_id VARCHAR(191) NOT NULL,
+  code VARCHAR(100) NOT NULL,
+  version INT NOT NULL,
+  title VARCHAR(255) NOT NULL,
+  format VARCHAR(10) NOT NULL,
+  source MEDIUMTEXT NOT NULL,
+  checksum CHAR(64) NOT NULL,
+  created_by VARCHAR(191) NULL,
+  created_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (organization_id, code, version)
+) ENGINE=InnoDB`,
+	},
+}
+
+// Stored is one version of a stored definition. Source is kept as written
+// so comments and layout survive.
+type Stored struct {
+	OrganizationID string
+	Code           string
+	Version        int
+	Title          string
+	Format         string
+	Source         string
+	Checksum       string
+	CreatedBy      string
+	CreatedAt      time.Time
+}
+
+// Definition parses the stored source.
+func (s *Stored) Definition() (*Definition, error) {
+	d, err := Parse([]byte(s.Source), s.Format)
+	if err != nil {
+		return nil, fmt.Errorf("report %s v%d: %w", s.Code, s.Version, err)
+	}
+	return d, nil
+}
+
+// Save validates a definition and stores it as the next version of its
+// code. Saving the same source as the latest version stores nothing and
+// returns that version. Run it inside a transaction.
+func Save(ctx context.Context, q cashflow.Querier, organizationID, source, format, by string) (*Stored, error) {
+	d, err := Parse([]byte(source), format)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(source))
+	s := &Stored{
+		OrganizationID: organizationID, Code: d.Code, Title: d.Title, Format: format, Source: source,
+		Checksum: hex.EncodeToString(sum[:]), CreatedBy: by, CreatedAt: time.Now(),
+	}
+
+	var latest int
+	var checksum string
+	err = q.QueryRowContext(ctx, `
+		SELECT version, checksum FROM report_definitions WHERE organization_id = ? AND code = ?
+		ORDER BY version DESC LIMIT 1 FOR UPDATE`, organizationID, d.Code).Scan(&latest, &checksum)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if checksum == s.Checksum {
+		return Get(ctx, q, organizationID, d.Code, latest)
+	}
+	s.Version = latest + 1
+
+	_, err = q.ExecContext(ctx, `
+		INSERT INTO report_definitions (organization_id, code, version, title, format, source, checksum, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.OrganizationID, s.Code, s.Version, s.Title, s.Format, s.Source, s.Checksum, cashflow.NullString(by), s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+const storedColumns = `organization_id, code, version, title, format, source, checksum, COALESCE(created_by, ''), created_at
+	FROM report_definitions`
+
+func scanStored(row interface{ Scan(...any) error }) (*Stored, error) {
+	s := &Stored{}
+	err := row.Scan(&s.OrganizationID, &s.Code, &s.Version, &s.Title, &s.Format, &s.Source, &s.Checksum, &s.CreatedBy, &s.CreatedAt)
+	return s, err
+}
+
+// Get loads a version of a definition; version 0 is the latest.
+func Get(ctx context.Context, q cashflow.Querier, organizationID, code string, version int) (*Stored, error) {
+	query, args := `SELECT `+storedColumns+` WHERE organization_id = ? AND code = ? ORDER BY version DESC LIMIT 1`,
+		[]any{organizationID, code}
+	if version > 0 {
+		query, args = `SELECT `+storedColumns+` WHERE organization_id = ? AND code = ? AND version = ?`,
+			[]any{organizationID, code, version}
+	}
+	s, err := scanStored(q.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		if version > 0 {
+			return nil, fmt.Errorf("report %s v%d: %w", code, version, cashflow.ErrNotFound)
+		}
+		return nil, fmt.Errorf("report %s: %w", code, cashflow.ErrNotFound)
+	}
+	return s, err
+}
+
+// List returns the latest version of every definition of an organization.
+func List(ctx context.Context, q cashflow.Querier, organizationID string) ([]*Stored, error) {
+	return list(ctx, q, `SELECT `+storedColumns+` WHERE organization_id = ? AND (code, version) IN (
+		  SELECT code, MAX(version) FROM report_definitions WHERE organization_id = ? GROUP BY code)
+		ORDER BY code`, organizationID, organizationID)
+}
+
+// Versions returns every version of a definition, newest first.
+func Versions(ctx context.Context, q cashflow.Querier, organizationID, code string) ([]*Stored, error) {
+	out, err := list(ctx, q, `SELECT `+storedColumns+` WHERE organization_id = ? AND code = ? ORDER BY version DESC`,
+		organizationID, code)
+	if err == nil && len(out) == 0 {
+		err = fmt.Errorf("report %s: %w", code, cashflow.ErrNotFound)
+	}
+	return out, err
+}
+
+func list(ctx context.Context, q cashflow.Querier, query string, args ...any) ([]*Stored, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Stored
+	for rows.Next() {
+		s, err := scanStored(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
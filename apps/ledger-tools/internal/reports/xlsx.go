@@ -0,0 +1,192 @@
+package reports
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Cell styles in xlsxStyles' cellXfs.
+const (
+	xfText = iota
+	xfBold
+	xfAmount
+	xfAmountBold
+	xfPercent
+	xfPercentBold
+)
+
+// xlsx writes the table as a one-sheet workbook. Amounts are numbers with
+// the definition's number format, so they stay usable in formulas.
+func (t *Table) xlsx(w io.Writer) error {
+	var sheet bytes.Buffer
+	sheet.WriteString(xml.Header)
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+
+	widths := make([]int, len(t.Columns)+1)
+	for _, l := range t.Lines {
+		widths[0] = max(widths[0], 2*l.Indent+utf8.RuneCountInString(l.Label))
+	}
+	for i, c := range t.Columns {
+		widths[i+1] = max(14, utf8.RuneCountInString(c.Label)+2)
+	}
+	sheet.WriteString(`<cols>`)
+	for i, n := range widths {
+		fmt.Fprintf(&sheet, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, i+1, i+1, max(n, 10)+2)
+	}
+	sheet.WriteString(`</cols><sheetData>`)
+
+	row := 0
+	newRow := func() { row++; fmt.Fprintf(&sheet, `<row r="%d">`, row) }
+	text := func(col int, s string, xf int) {
+		if s == "" {
+			return
+		}
+		fmt.Fprintf(&sheet, `<c r="%s%d" t="inlineStr" s="%d"><is><t xml:space="preserve">%s</t></is></c>`,
+			xlsxColumn(col), row, xf, xmlEscape(s))
+	}
+
+	for _, h := range t.heading() {
+		newRow()
+		text(0, h, xfBold)
+		sheet.WriteString(`</row>`)
+	}
+	newRow()
+	sheet.WriteString(`</row>`)
+	newRow()
+	for i, c := range t.Columns {
+		text(i+1, c.Label, xfBold)
+	}
+	sheet.WriteString(`</row>`)
+
+	for _, l := range t.Lines {
+		bold := l.Style != ""
+		newRow()
+		xf := xfText
+		if bold {
+			xf = xfBold
+		}
+		text(0, strings.Repeat("  ", l.Indent)+l.Label, xf)
+		for j, v := range l.Values {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			xf := xfAmount
+			if t.percent(l, j) {
+				xf = xfPercent
+			} else {
+				v /= t.Format.Scale
+			}
+			if bold {
+				xf++
+			}
+			fmt.Fprintf(&sheet, `<c r="%s%d" s="%d"><v>%s</v></c>`, xlsxColumn(j+1), row, xf, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		sheet.WriteString(`</row>`)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+
+	title := t.Title
+	if utf8.RuneCountInString(title) > 31 {
+		title = string([]rune(title)[:31])
+	}
+	title = strings.NewReplacer("[", "(", "]", ")", ":", " ", "*", " ", "?", " ", "/", " ", `\`, " ").Replace(title)
+
+	files := []struct{ name, body string }{
+		{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+			`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="` + xmlEscape(title) + `" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+			`</Relationships>`},
+		{"xl/styles.xml", t.xlsxStyles()},
+		{"xl/worksheets/sheet1.xml", sheet.String()},
+	}
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, f.body); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// xlsxStyles builds the number formats from the definition's Format.
+func (t *Table) xlsxStyles() string {
+	amount := "0"
+	if *t.Format.Thousands {
+		amount = "#,##0"
+	}
+	if d := *t.Format.Decimals; d > 0 {
+		amount += "." + strings.Repeat("0", d)
+	}
+	percent := "0"
+	if d := *t.Format.PercentDecimals; d > 0 {
+		percent += "." + strings.Repeat("0", d)
+	}
+	percent += "%"
+	sections := func(format, zero string) string {
+		pos, neg := format, "-"+format
+		if t.Format.Negative == "parens" {
+			pos, neg = format+"_)", "("+format+")"
+		}
+		if zero == "" {
+			return pos + ";" + neg
+		}
+		return pos + ";" + neg + `;"` + strings.ReplaceAll(zero, `"`, "") + `"`
+	}
+	amount, percent = sections(amount, t.Format.Zero), sections(percent, "")
+
+	xf := func(numFmt int, bold bool) string {
+		font := 0
+		if bold {
+			font = 1
+		}
+		return fmt.Sprintf(`<xf numFmtId="%d" fontId="%d" fillId="0" borderId="0" xfId="0" applyNumberFormat="1" applyFont="1"/>`, numFmt, font)
+	}
+	return xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<numFmts count="2"><numFmt numFmtId="164" formatCode="` + xmlEscape(amount) + `"/>` +
+		`<numFmt numFmtId="165" formatCode="` + xmlEscape(percent) + `"/></numFmts>` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="6">` + xf(0, false) + xf(0, true) + xf(164, false) + xf(164, true) + xf(165, false) + xf(165, true) + `</cellXfs>` +
+		`</styleSheet>`
+}
+
+// xlsxColumn is a zero-based column index as letters: A, B, ..., AA.
+func xlsxColumn(i int) string {
+	s := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		s = string(rune('A'+(i-1)%26)) + s
+	}
+	return s
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
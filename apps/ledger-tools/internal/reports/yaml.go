@@ -0,0 +1,382 @@
+package reports
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML reads the YAML report definitions use into maps, slices and
+// scalars: block mappings and sequences, one-line flow collections
+// ([a, b], {k: v}), quoted and plain scalars and comments. Anchors, tags,
+// multi-document streams and block scalars (| and >) are not supported.
+func parseYAML(data []byte) (any, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, " \r")
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("yaml line %d: indent with spaces, not tabs", i+1)
+		}
+		indent := len(raw) - len(text)
+		text = stripComment(text)
+		if text == "" || text == "---" {
+			continue
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: indent, text: text})
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("yaml: empty document")
+	}
+	p := &yamlParser{lines: lines}
+	v, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return v, nil
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) errorf(format string, args ...any) error {
+	num := p.lines[len(p.lines)-1].num
+	if p.pos < len(p.lines) {
+		num = p.lines[p.pos].num
+	}
+	return fmt.Errorf("yaml line %d: %s", num, fmt.Sprintf(format, args...))
+}
+
+func isDash(text string) bool { return text == "-" || strings.HasPrefix(text, "- ") }
+
+func (p *yamlParser) block(indent int) (any, error) {
+	if isDash(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) sequence(indent int) ([]any, error) {
+	out := []any{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isDash(p.lines[p.pos].text) {
+		l := p.lines[p.pos]
+		rest := strings.TrimLeft(l.text[1:], " ")
+		switch {
+		case rest == "":
+			p.pos++
+			var v any
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				var err error
+				if v, err = p.block(p.lines[p.pos].indent); err != nil {
+					return nil, err
+				}
+			}
+			out = append(out, v)
+		case isDash(rest) || isMapEntry(rest):
+			// "- key: value" opens a mapping (or nested sequence) whose
+			// entries line up with key.
+			col := indent + len(l.text) - len(rest)
+			p.lines[p.pos] = yamlLine{num: l.num, indent: col, text: rest}
+			v, err := p.block(col)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		default:
+			v, err := scalar(rest)
+			if err != nil {
+				return nil, p.errorf("%v", err)
+			}
+			p.pos++
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	out := map[string]any{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		l := p.lines[p.pos]
+		if isDash(l.text) {
+			return nil, p.errorf("list item where a key was expected")
+		}
+		key, rest, ok := splitKey(l.text)
+		if !ok {
+			return nil, p.errorf("expected key: value")
+		}
+		if _, dup := out[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		p.pos++
+
+		var v any
+		var err error
+		switch {
+		case rest == "|" || rest == ">" || strings.HasPrefix(rest, "|") || strings.HasPrefix(rest, ">"):
+			p.pos--
+			return nil, p.errorf("block scalars are not supported; quote the string")
+		case rest != "":
+			if v, err = scalar(rest); err != nil {
+				p.pos--
+				return nil, p.errorf("%v", err)
+			}
+		case p.pos < len(p.lines) && p.lines[p.pos].indent > indent:
+			v, err = p.block(p.lines[p.pos].indent)
+		case p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isDash(p.lines[p.pos].text):
+			v, err = p.sequence(indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[key] = v
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return out, nil
+}
+
+// stripComment removes a # comment outside quotes.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" :[{,", s[i-1]) >= 0):
+			// Only a quote opening a value starts a string; Owner's is plain.
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return strings.TrimRight(s[:i], " ")
+		}
+	}
+	return s
+}
+
+// splitKey splits "key: value" at the first colon outside quotes that is
+// followed by a space or the end of the line.
+func splitKey(s string) (key, rest string, ok bool) {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 {
+				quote = c
+			}
+		case c == '[' || c == '{':
+			if i == 0 {
+				return "", "", false
+			}
+		case c == ':' && (i+1 == len(s) || s[i+1] == ' '):
+			k, err := scalar(strings.TrimSpace(s[:i]))
+			if err != nil {
+				return "", "", false
+			}
+			return fmt.Sprint(k), strings.TrimSpace(s[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+func isMapEntry(s string) bool {
+	_, _, ok := splitKey(s)
+	return ok
+}
+
+// scalar parses a plain, quoted or flow value.
+func scalar(s string) (any, error) {
+	f := &flow{src: s}
+	v, err := f.value()
+	if err != nil {
+		return nil, err
+	}
+	if f.skip(); f.pos < len(s) {
+		return nil, fmt.Errorf("unexpected %q", s[f.pos:])
+	}
+	return v, nil
+}
+
+// flow parses one-line flow collections and scalars.
+type flow struct {
+	src string
+	pos int
+	// inFlow is set inside [ ] or { }, where , ] and } end plain scalars.
+	inFlow int
+}
+
+func (f *flow) skip() {
+	for f.pos < len(f.src) && f.src[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *flow) value() (any, error) {
+	f.skip()
+	if f.pos >= len(f.src) {
+		return nil, nil
+	}
+	switch f.src[f.pos] {
+	case '[':
+		return f.list()
+	case '{':
+		return f.object()
+	case '"':
+		return f.doubleQuoted()
+	case '\'':
+		return f.singleQuoted()
+	}
+	start := f.pos
+	for f.pos < len(f.src) {
+		c := f.src[f.pos]
+		if f.inFlow > 0 && (c == ',' || c == ']' || c == '}') {
+			break
+		}
+		if f.inFlow > 0 && c == ':' && (f.pos+1 == len(f.src) || f.src[f.pos+1] == ' ') {
+			break
+		}
+		f.pos++
+	}
+	return plain(strings.TrimSpace(f.src[start:f.pos])), nil
+}
+
+func (f *flow) list() ([]any, error) {
+	f.pos++
+	f.inFlow++
+	defer func() { f.inFlow-- }()
+	out := []any{}
+	for {
+		if f.skip(); f.pos < len(f.src) && f.src[f.pos] == ']' {
+			f.pos++
+			return out, nil
+		}
+		v, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+		f.skip()
+		if f.pos >= len(f.src) {
+			return nil, fmt.Errorf("missing ]")
+		}
+		switch f.src[f.pos] {
+		case ',':
+			f.pos++
+		case ']':
+		default:
+			return nil, fmt.Errorf("expected , or ] at %q", f.src[f.pos:])
+		}
+	}
+}
+
+func (f *flow) object() (map[string]any, error) {
+	f.pos++
+	f.inFlow++
+	defer func() { f.inFlow-- }()
+	out := map[string]any{}
+	for {
+		if f.skip(); f.pos < len(f.src) && f.src[f.pos] == '}' {
+			f.pos++
+			return out, nil
+		}
+		k, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		if f.skip(); f.pos >= len(f.src) || f.src[f.pos] != ':' {
+			return nil, fmt.Errorf("expected : after key %v", k)
+		}
+		f.pos++
+		v, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		out[fmt.Sprint(k)] = v
+		f.skip()
+		if f.pos >= len(f.src) {
+			return nil, fmt.Errorf("missing }")
+		}
+		switch f.src[f.pos] {
+		case ',':
+			f.pos++
+		case '}':
+		default:
+			return nil, fmt.Errorf("expected , or } at %q", f.src[f.pos:])
+		}
+	}
+}
+
+func (f *flow) doubleQuoted() (string, error) {
+	start := f.pos
+	for f.pos++; f.pos < len(f.src); f.pos++ {
+		switch f.src[f.pos] {
+		case '\\':
+			f.pos++
+		case '"':
+			f.pos++
+			return strconv.Unquote(f.src[start:f.pos])
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+func (f *flow) singleQuoted() (string, error) {
+	var b strings.Builder
+	for f.pos++; f.pos < len(f.src); f.pos++ {
+		if f.src[f.pos] == '\'' {
+			if f.pos+1 < len(f.src) && f.src[f.pos+1] == '\'' {
+				b.WriteByte('\'')
+				f.pos++
+				continue
+			}
+			f.pos++
+			return b.String(), nil
+		}
+		b.WriteByte(f.src[f.pos])
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// plain types an unquoted scalar: null, booleans and numbers; anything else
+// is a string.
+func plain(s string) any {
+	switch s {
+	case "", "~", "null":
+		return nil
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if !strings.ContainsAny(s[:1], "0123456789+-.") {
+		return s
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return v
+	}
+	return s
+}
@@ -0,0 +1,146 @@
+package reports
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want any
+	}{
+		{
+			name: "scalars",
+			in: `name: Profit and loss
+count: 12
+ratio: 0.5
+negative: -3
+enabled: true
+disabled: false
+missing: null
+tilde: ~
+empty:
+version: 1.2.3`,
+			want: map[string]any{
+				"name": "Profit and loss", "count": int64(12), "ratio": 0.5, "negative": int64(-3),
+				"enabled": true, "disabled": false, "missing": nil, "tilde": nil, "empty": nil, "version": "1.2.3",
+			},
+		},
+		{
+			name: "quoted strings and comments",
+			in: `# a comment line
+title: "Owner's equity # not a comment" # a comment
+note: 'it''s quoted'
+owner: Owner's draw
+escaped: "tab\tand \"quotes\""
+colon: "a: b"`,
+			want: map[string]any{
+				"title": "Owner's equity # not a comment", "note": "it's quoted", "owner": "Owner's draw",
+				"escaped": "tab\tand \"quotes\"", "colon": "a: b",
+			},
+		},
+		{
+			name: "nested mappings",
+			in: `period:
+  type: month
+  offset: -1
+format:
+  currency:
+    symbol: K`,
+			want: map[string]any{
+				"period": map[string]any{"type": "month", "offset": int64(-1)},
+				"format": map[string]any{"currency": map[string]any{"symbol": "K"}},
+			},
+		},
+		{
+			name: "sequences",
+			in: `columns:
+  - id: actual
+    source: actual
+  - id: budget
+    source: budget
+tags:
+- a
+- b
+nested:
+  -
+    - 1
+    - 2
+  - - 3`,
+			want: map[string]any{
+				"columns": []any{
+					map[string]any{"id": "actual", "source": "actual"},
+					map[string]any{"id": "budget", "source": "budget"},
+				},
+				"tags":   []any{"a", "b"},
+				"nested": []any{[]any{int64(1), int64(2)}, []any{int64(3)}},
+			},
+		},
+		{
+			name: "flow collections",
+			in: `accounts: [Income, "Cost of Sales", 4000]
+style: {bold: true, indent: 2, label: 'Net: total'}
+empty: []
+none: {}
+deep: [{a: 1}, [x, y]]`,
+			want: map[string]any{
+				"accounts": []any{"Income", "Cost of Sales", int64(4000)},
+				"style":    map[string]any{"bold": true, "indent": int64(2), "label": "Net: total"},
+				"empty":    []any{},
+				"none":     map[string]any{},
+				"deep":     []any{map[string]any{"a": int64(1)}, []any{"x", "y"}},
+			},
+		},
+		{
+			name: "top-level sequence and document marker",
+			in:   "---\n- one\n- 2\n",
+			want: []any{"one", int64(2)},
+		},
+		{
+			name: "url with colon",
+			in:   "url: https://example.com/hook",
+			want: map[string]any{"url": "https://example.com/hook"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("parseYAML: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseYAML =\n%#v\nwant\n%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "empty", in: "# nothing\n", want: "empty document"},
+		{name: "tabs", in: "a:\n\tb: 1", want: "line 2: indent with spaces"},
+		{name: "duplicate key", in: "a: 1\nb: 2\na: 3", want: `line 3: duplicate key "a"`},
+		{name: "block scalar", in: "a: |\n  text", want: "line 1: block scalars are not supported"},
+		{name: "bad indentation", in: "a: 1\n  b: 2", want: "line 2: unexpected indentation"},
+		{name: "list item in a mapping", in: "a: 1\n- b", want: "line 2: list item where a key was expected"},
+		{name: "not a key", in: "just text", want: "line 1: expected key: value"},
+		{name: "unterminated string", in: `a: "open`, want: "line 1: unterminated string"},
+		{name: "unclosed flow list", in: "a: [1, 2", want: "line 1: missing ]"},
+		{name: "trailing text", in: `a: "x" y`, want: `line 1: unexpected "y"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseYAML([]byte(tt.in))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseYAML error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
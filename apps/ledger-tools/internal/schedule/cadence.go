@@ -0,0 +1,141 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cadence kinds.
+const (
+	Daily   = "daily"
+	Weekly  = "weekly"
+	Monthly = "monthly"
+)
+
+// Cadence is when a subscription runs, in the organization's timezone:
+//
+//	daily 07:00
+//	weekly mon 08:00          (or mon,thu)
+//	monthly 1 08:00           (day 1-28, or last)
+type Cadence struct {
+	Kind     string
+	Weekdays []time.Weekday // weekly
+	Day      int            // monthly; 0 is the last day
+	Hour     int
+	Minute   int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseCadence reads a cadence like "weekly mon 08:00".
+func ParseCadence(s string) (*Cadence, error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty cadence")
+	}
+	c := &Cadence{Kind: fields[0]}
+	want := 2
+	if c.Kind == Weekly || c.Kind == Monthly {
+		want = 3
+	}
+	if len(fields) != want {
+		return nil, fmt.Errorf("cadence %q: want daily HH:MM, weekly <days> HH:MM or monthly <day|last> HH:MM", s)
+	}
+
+	switch c.Kind {
+	case Daily:
+	case Weekly:
+		seen := map[time.Weekday]bool{}
+		for _, name := range strings.Split(fields[1], ",") {
+			d, ok := weekdays[name]
+			if !ok {
+				return nil, fmt.Errorf("cadence %q: unknown weekday %q", s, name)
+			}
+			if !seen[d] {
+				seen[d] = true
+				c.Weekdays = append(c.Weekdays, d)
+			}
+		}
+	case Monthly:
+		if fields[1] != "last" {
+			day, err := strconv.Atoi(fields[1])
+			if err != nil || day < 1 || day > 28 {
+				return nil, fmt.Errorf("cadence %q: day must be 1-28 or last", s)
+			}
+			c.Day = day
+		}
+	default:
+		return nil, fmt.Errorf("cadence %q: unknown kind %q", s, c.Kind)
+	}
+
+	at, err := time.Parse("15:04", fields[len(fields)-1])
+	if err != nil {
+		return nil, fmt.Errorf("cadence %q: time must be HH:MM", s)
+	}
+	c.Hour, c.Minute = at.Hour(), at.Minute()
+	return c, nil
+}
+
+// String is the canonical form ParseCadence reads.
+func (c *Cadence) String() string {
+	at := fmt.Sprintf("%02d:%02d", c.Hour, c.Minute)
+	switch c.Kind {
+	case Weekly:
+		names := make([]string, len(c.Weekdays))
+		for i, d := range c.Weekdays {
+			names[i] = strings.ToLower(d.String()[:3])
+		}
+		return fmt.Sprintf("weekly %s %s", strings.Join(names, ","), at)
+	case Monthly:
+		day := "last"
+		if c.Day > 0 {
+			day = strconv.Itoa(c.Day)
+		}
+		return fmt.Sprintf("monthly %s %s", day, at)
+	}
+	return "daily " + at
+}
+
+// Next is the first run strictly after t, in loc. A time skipped by a
+// daylight saving change runs that much later: 02:30 becomes 03:30.
+func (c *Cadence) Next(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	for i := 0; ; i++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+i, 0, 0, 0, 0, loc)
+		if !c.matches(day) {
+			continue
+		}
+		run := time.Date(day.Year(), day.Month(), day.Day(), c.Hour, c.Minute, 0, 0, loc)
+		if run.Hour() != c.Hour || run.Minute() != c.Minute {
+			_, before := run.Zone()
+			_, after := run.Add(3 * time.Hour).Zone()
+			run = run.Add(time.Duration(after-before) * time.Second)
+		}
+		if run.After(t) {
+			return run
+		}
+	}
+}
+
+func (c *Cadence) matches(day time.Time) bool {
+	switch c.Kind {
+	case Weekly:
+		for _, d := range c.Weekdays {
+			if day.Weekday() == d {
+				return true
+			}
+		}
+		return false
+	case Monthly:
+		if c.Day == 0 {
+			return day.AddDate(0, 0, 1).Day() == 1
+		}
+		return day.Day() == c.Day
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package schedule
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseCadence(t *testing.T) {
+	tests := []struct {
+		in   string
+		want *Cadence
+		str  string
+	}{
+		{in: "daily 07:00", want: &Cadence{Kind: Daily, Hour: 7}, str: "daily 07:00"},
+		{in: "  Daily   7:05 ", want: &Cadence{Kind: Daily, Hour: 7, Minute: 5}, str: "daily 07:05"},
+		{in: "weekly mon 08:00", want: &Cadence{Kind: Weekly, Weekdays: []time.Weekday{time.Monday}, Hour: 8},
+			str: "weekly mon 08:00"},
+		{in: "weekly MON,thu,mon 18:30", want: &Cadence{Kind: Weekly, Weekdays: []time.Weekday{time.Monday, time.Thursday},
+			Hour: 18, Minute: 30}, str: "weekly mon,thu 18:30"},
+		{in: "monthly 1 08:00", want: &Cadence{Kind: Monthly, Day: 1, Hour: 8}, str: "monthly 1 08:00"},
+		{in: "monthly 28 23:59", want: &Cadence{Kind: Monthly, Day: 28, Hour: 23, Minute: 59}, str: "monthly 28 23:59"},
+		{in: "monthly last 00:00", want: &Cadence{Kind: Monthly}, str: "monthly last 00:00"},
+	}
+	for _, tt := range tests {
+		got, err := ParseCadence(tt.in)
+		if err != nil {
+			t.Errorf("ParseCadence(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseCadence(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if s := got.String(); s != tt.str {
+			t.Errorf("ParseCadence(%q).String() = %q, want %q", tt.in, s, tt.str)
+		}
+		if again, err := ParseCadence(got.String()); err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("ParseCadence(%q) = %+v, %v; want it to read back", got.String(), again, err)
+		}
+	}
+}
+
+func TestParseCadenceErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "", want: "empty cadence"},
+		{in: "daily", want: "want daily HH:MM"},
+		{in: "weekly 08:00", want: "want daily HH:MM"},
+		{in: "daily mon 08:00", want: "want daily HH:MM"},
+		{in: "hourly 5 08:00", want: "want daily HH:MM"},
+		{in: "yearly 08:00", want: `unknown kind "yearly"`},
+		{in: "weekly monday 08:00", want: `unknown weekday "monday"`},
+		{in: "weekly mon, 08:00", want: `unknown weekday ""`},
+		{in: "monthly 29 08:00", want: "day must be 1-28 or last"},
+		{in: "monthly 0 08:00", want: "day must be 1-28 or last"},
+		{in: "monthly first 08:00", want: "day must be 1-28 or last"},
+		{in: "daily 24:00", want: "time must be HH:MM"},
+		{in: "daily 8am", want: "time must be HH:MM"},
+	}
+	for _, tt := range tests {
+		_, err := ParseCadence(tt.in)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseCadence(%q) error = %v, want %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	yangon := mustLoad(t, "Asia/Yangon")
+	newYork := mustLoad(t, "America/New_York")
+	lordHowe := mustLoad(t, "Australia/Lord_Howe")
+
+	tests := []struct {
+		name    string
+		cadence string
+		loc     *time.Location
+		after   time.Time
+		want    time.Time
+	}{
+		{name: "daily later today", cadence: "daily 07:00", loc: yangon,
+			after: time.Date(2025, 3, 14, 6, 59, 0, 0, yangon), want: time.Date(2025, 3, 14, 7, 0, 0, 0, yangon)},
+		{name: "daily strictly after", cadence: "daily 07:00", loc: yangon,
+			after: time.Date(2025, 3, 14, 7, 0, 0, 0, yangon), want: time.Date(2025, 3, 15, 7, 0, 0, 0, yangon)},
+		{name: "daily in the organization's timezone", cadence: "daily 07:00", loc: yangon,
+			after: time.Date(2025, 3, 14, 1, 0, 0, 0, time.UTC), want: time.Date(2025, 3, 15, 7, 0, 0, 0, yangon)},
+		{name: "daily across the year end", cadence: "daily 07:00", loc: yangon,
+			after: time.Date(2025, 12, 31, 8, 0, 0, 0, yangon), want: time.Date(2026, 1, 1, 7, 0, 0, 0, yangon)},
+		{name: "weekly next listed day", cadence: "weekly mon,thu 08:00", loc: yangon,
+			after: time.Date(2025, 3, 11, 12, 0, 0, 0, yangon), want: time.Date(2025, 3, 13, 8, 0, 0, 0, yangon)},
+		{name: "weekly wraps to next week", cadence: "weekly mon,thu 08:00", loc: yangon,
+			after: time.Date(2025, 3, 13, 9, 0, 0, 0, yangon), want: time.Date(2025, 3, 17, 8, 0, 0, 0, yangon)},
+		{name: "monthly day this month", cadence: "monthly 15 08:00", loc: yangon,
+			after: time.Date(2025, 3, 14, 9, 0, 0, 0, yangon), want: time.Date(2025, 3, 15, 8, 0, 0, 0, yangon)},
+		{name: "monthly day next month", cadence: "monthly 1 08:00", loc: yangon,
+			after: time.Date(2025, 3, 1, 8, 0, 0, 0, yangon), want: time.Date(2025, 4, 1, 8, 0, 0, 0, yangon)},
+		{name: "monthly last in February", cadence: "monthly last 18:00", loc: yangon,
+			after: time.Date(2025, 2, 10, 0, 0, 0, 0, yangon), want: time.Date(2025, 2, 28, 18, 0, 0, 0, yangon)},
+		{name: "monthly last in a leap February", cadence: "monthly last 18:00", loc: yangon,
+			after: time.Date(2024, 2, 28, 19, 0, 0, 0, yangon), want: time.Date(2024, 2, 29, 18, 0, 0, 0, yangon)},
+		{name: "monthly last after the last day", cadence: "monthly last 18:00", loc: yangon,
+			after: time.Date(2025, 4, 30, 18, 0, 0, 0, yangon), want: time.Date(2025, 5, 31, 18, 0, 0, 0, yangon)},
+		{name: "skipped by daylight saving runs an hour later", cadence: "daily 02:30", loc: newYork,
+			after: time.Date(2025, 3, 8, 12, 0, 0, 0, newYork), want: time.Date(2025, 3, 9, 3, 30, 0, 0, newYork)},
+		{name: "day after the change is back to normal", cadence: "daily 02:30", loc: newYork,
+			after: time.Date(2025, 3, 9, 12, 0, 0, 0, newYork), want: time.Date(2025, 3, 10, 2, 30, 0, 0, newYork)},
+		{name: "half-hour change runs half an hour later", cadence: "daily 02:15", loc: lordHowe,
+			after: time.Date(2025, 10, 4, 12, 0, 0, 0, lordHowe), want: time.Date(2025, 10, 5, 2, 45, 0, 0, lordHowe)},
+		{name: "repeated hour runs once", cadence: "daily 01:30", loc: newYork,
+			after: time.Date(2025, 11, 2, 1, 30, 0, 0, newYork), want: time.Date(2025, 11, 3, 1, 30, 0, 0, newYork)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCadence(tt.cadence)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := c.Next(tt.after, tt.loc)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.after, got, tt.want)
+			}
+			if got.Location() != tt.loc {
+				t.Errorf("Next(%v) is in %v, want %v", tt.after, got.Location(), tt.loc)
+			}
+		})
+	}
+}
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
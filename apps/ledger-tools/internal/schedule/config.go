@@ -0,0 +1,84 @@
+package schedule
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/tenantlimit"
+)
+
+// Config is the scheduler configuration file.
+type Config struct {
+	// Interval is how often due subscriptions and deliveries are checked.
+	Interval config.Duration `json:"interval"`
+	// AttachmentDir is the root of the attachment store, where generated
+	// reports are kept.
+	AttachmentDir string `json:"attachmentDir"`
+	// FileRoot is the directory file subscriptions drop reports under.
+	FileRoot string `json:"fileRoot,omitempty"`
+	SMTP     SMTP   `json:"smtp"`
+	// MaxAttempts is how often a delivery is tried before it fails; the
+	// wait between attempts starts at RetryBackoff and doubles.
+	MaxAttempts  int             `json:"maxAttempts"`
+	RetryBackoff config.Duration `json:"retryBackoff"`
+	// Timeout bounds one generation and send.
+	Timeout config.Duration `json:"timeout"`
+	// TenantLimits, when set, runs each generation and send as batch
+	// traffic under its organization's query budget, so a large report
+	// cannot crowd out the organization's interactive queries or other
+	// organizations.
+	TenantLimits *tenantlimit.Config `json:"tenantLimits,omitempty"`
+	// MetricsListen is where run serves the limiter's per-organization
+	// metrics, at /metrics.
+	MetricsListen string `json:"metricsListen,omitempty"`
+}
+
+// SMTP is the outgoing mail server.
+type SMTP struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"` // literal, or env:VAR_NAME
+	From     string `json:"from"`
+}
+
+// LoadConfig reads and validates a configuration file.
+func LoadConfig(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{
+		Interval: config.Duration(time.Minute), MaxAttempts: 5, RetryBackoff: config.Duration(5 * time.Minute),
+		Timeout: config.Duration(5 * time.Minute), SMTP: SMTP{Port: 587},
+	}
+	if err := json.Unmarshal(raw, cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if cfg.AttachmentDir == "" {
+		return nil, fmt.Errorf("%s: attachmentDir is required", path)
+	}
+	if cfg.MaxAttempts < 1 {
+		return nil, fmt.Errorf("%s: maxAttempts must be at least 1", path)
+	}
+	if cfg.SMTP.Host != "" {
+		if _, err := mail.ParseAddress(cfg.SMTP.From); err != nil {
+			return nil, fmt.Errorf("%s: smtp.from: %w", path, err)
+		}
+	}
+	cfg.SMTP.Password = secret(cfg.SMTP.Password)
+	return cfg, nil
+}
+
+// secret resolves an env:VAR_NAME reference.
+func secret(s string) string {
+	if name, ok := strings.CutPrefix(s, "env:"); ok {
+		return os.Getenv(name)
+	}
+	return s
+}
@@ -0,0 +1,238 @@
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+)
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"  // recorded, not attempted yet
+	DeliveryRetrying  = "retrying" // failed, attempted again at next_attempt_at
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed" // gave up after the last attempt
+)
+
+// Delivery is one run of a subscription.
+type Delivery struct {
+	ID             string
+	SubscriptionID string
+	OrganizationID string
+	ScheduledFor   time.Time
+	AsOf           *time.Time
+	ReportVersion  int
+	Status         string
+	Attempts       int
+	NextAttemptAt  *time.Time
+	AttachmentID   string
+	Error          string
+	CreatedAt      time.Time
+	DeliveredAt    *time.Time
+
+	// Subscription is filled in by History.
+	Subscription string
+}
+
+const deliveryColumns = `d.id, d.subscription_id, d.organization_id, d.scheduled_for, d.as_of, COALESCE(d.report_version, 0),
+	d.status, d.attempts, d.next_attempt_at, COALESCE(d.attachment_id, ''), COALESCE(d.error, ''), d.created_at,
+	d.delivered_at, s.name
+	FROM report_deliveries d JOIN report_subscriptions s ON s.id = d.subscription_id`
+
+func scanDelivery(row interface{ Scan(...any) error }) (*Delivery, error) {
+	d := &Delivery{}
+	var asOf, next, delivered sql.NullTime
+	err := row.Scan(&d.ID, &d.SubscriptionID, &d.OrganizationID, &d.ScheduledFor, &asOf, &d.ReportVersion,
+		&d.Status, &d.Attempts, &next, &d.AttachmentID, &d.Error, &d.CreatedAt, &delivered, &d.Subscription)
+	if asOf.Valid {
+		d.AsOf = &asOf.Time
+	}
+	if next.Valid {
+		d.NextAttemptAt = &next.Time
+	}
+	if delivered.Valid {
+		d.DeliveredAt = &delivered.Time
+	}
+	return d, err
+}
+
+// GetDelivery loads a delivery.
+func GetDelivery(ctx context.Context, q cashflow.Querier, id string) (*Delivery, error) {
+	d, err := scanDelivery(q.QueryRowContext(ctx, `SELECT `+deliveryColumns+` WHERE d.id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("delivery %s: %w", id, cashflow.ErrNotFound)
+	}
+	return d, err
+}
+
+// History returns an organization's deliveries, newest first, optionally
+// for one subscription and status.
+func History(ctx context.Context, q cashflow.Querier, organizationID, subscriptionID, status string, limit int) ([]*Delivery, error) {
+	query := `SELECT ` + deliveryColumns + ` WHERE d.organization_id = ?`
+	args := []any{organizationID}
+	if subscriptionID != "" {
+		query += ` AND d.subscription_id = ?`
+		args = append(args, subscriptionID)
+	}
+	if status != "" {
+		query += ` AND d.status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY d.scheduled_for DESC, d.created_at DESC LIMIT ?`
+	return listDeliveries(ctx, q, query, append(args, limit)...)
+}
+
+// dueDeliveries returns the deliveries waiting for an attempt.
+func dueDeliveries(ctx context.Context, q cashflow.Querier, now time.Time) ([]*Delivery, error) {
+	return listDeliveries(ctx, q, `SELECT `+deliveryColumns+`
+		WHERE d.status IN (?, ?) AND d.next_attempt_at <= ? ORDER BY d.next_attempt_at`,
+		DeliveryPending, DeliveryRetrying, now.UTC())
+}
+
+func listDeliveries(ctx context.Context, q cashflow.Querier, query string, args ...any) ([]*Delivery, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Delivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// Enqueue records the due run of a subscription and advances it to its
+// next run after now. It returns nil when another scheduler got there
+// first. Run it inside a transaction.
+func Enqueue(ctx context.Context, q cashflow.Querier, s *Subscription, loc *time.Location, now time.Time) (*Delivery, error) {
+	var next sql.NullTime
+	var active bool
+	err := q.QueryRowContext(ctx, `SELECT active, next_run_at FROM report_subscriptions WHERE id = ? FOR UPDATE`, s.ID).
+		Scan(&active, &next)
+	if err != nil {
+		return nil, err
+	}
+	if !active || !next.Valid || next.Time.After(now) {
+		return nil, nil
+	}
+	c, err := ParseCadence(s.Cadence)
+	if err != nil {
+		return nil, err
+	}
+
+	// A scheduler that was down runs the latest missed slot once and
+	// skips the rest.
+	d, err := insertDelivery(ctx, q, s, next.Time, now)
+	if err != nil {
+		return nil, err
+	}
+	_, err = q.ExecContext(ctx, `UPDATE report_subscriptions SET next_run_at = ?, last_run_at = ? WHERE id = ?`,
+		c.Next(now, loc).UTC(), next.Time, s.ID)
+	return d, err
+}
+
+// SendNow records an extra run of a subscription for now, outside its
+// cadence.
+func SendNow(ctx context.Context, q cashflow.Querier, s *Subscription, now time.Time) (*Delivery, error) {
+	return insertDelivery(ctx, q, s, now, now)
+}
+
+func insertDelivery(ctx context.Context, q cashflow.Querier, s *Subscription, scheduledFor, now time.Time) (*Delivery, error) {
+	// DATETIME(3) rounds; truncating keeps the delivery due at now.
+	now = now.Truncate(time.Millisecond)
+	d := &Delivery{
+		ID: cashflow.NewID("rdel"), SubscriptionID: s.ID, OrganizationID: s.OrganizationID,
+		ScheduledFor: scheduledFor.UTC(), Status: DeliveryPending, NextAttemptAt: &now, CreatedAt: now,
+		Subscription: s.Name,
+	}
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO report_deliveries (id, subscription_id, organization_id, scheduled_for, status, attempts,
+		  next_attempt_at, created_at)
+		VALUES (?, ?, ?, ?, ?, 0, ?, ?)`,
+		d.ID, d.SubscriptionID, d.OrganizationID, d.ScheduledFor, d.Status, now.UTC(), d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ErrClaimed is returned for a delivery another scheduler is attempting
+// or has finished.
+var ErrClaimed = errors.New("delivery is not due or is being attempted elsewhere")
+
+// claim takes a due delivery for one attempt. The attempt holds it until
+// lease runs out, so a scheduler that dies mid-send leaves it to be tried
+// again. It returns false when another scheduler claimed it.
+func claim(ctx context.Context, q cashflow.Querier, d *Delivery, now time.Time, lease time.Duration) (bool, error) {
+	res, err := q.ExecContext(ctx, `
+		UPDATE report_deliveries SET attempts = attempts + 1, next_attempt_at = ?
+		WHERE id = ? AND status IN (?, ?) AND next_attempt_at <= ?`,
+		now.Add(lease).UTC(), d.ID, DeliveryPending, DeliveryRetrying, now.UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if n == 1 {
+		d.Attempts++
+	}
+	return n == 1, err
+}
+
+// generated records the report a delivery sends, so retries resend the
+// same file.
+func generated(ctx context.Context, q cashflow.Querier, d *Delivery) error {
+	_, err := q.ExecContext(ctx, `UPDATE report_deliveries SET as_of = ?, report_version = ?, attachment_id = ? WHERE id = ?`,
+		d.AsOf.Format(time.DateOnly), d.ReportVersion, d.AttachmentID, d.ID)
+	return err
+}
+
+func delivered(ctx context.Context, q cashflow.Querier, d *Delivery, now time.Time) error {
+	d.Status, d.Error, d.NextAttemptAt, d.DeliveredAt = DeliveryDelivered, "", nil, &now
+	_, err := q.ExecContext(ctx, `
+		UPDATE report_deliveries SET status = ?, error = NULL, next_attempt_at = NULL, delivered_at = ? WHERE id = ?`,
+		d.Status, now, d.ID)
+	return err
+}
+
+// failed records a failed attempt: retried after backoff, doubling each
+// time, until maxAttempts.
+func failed(ctx context.Context, q cashflow.Querier, d *Delivery, cause error, now time.Time, maxAttempts int, backoff time.Duration) error {
+	d.Status, d.Error, d.NextAttemptAt = DeliveryFailed, cause.Error(), nil
+	var next sql.NullTime
+	if d.Attempts < maxAttempts {
+		at := now.Add(backoff << (d.Attempts - 1))
+		d.Status, d.NextAttemptAt = DeliveryRetrying, &at
+		next = sql.NullTime{Time: at.UTC(), Valid: true}
+	}
+	_, err := q.ExecContext(ctx, `UPDATE report_deliveries SET status = ?, error = ?, next_attempt_at = ? WHERE id = ?`,
+		d.Status, d.Error, next, d.ID)
+	return err
+}
+
+// Retry queues a failed delivery for another attempt now, with a fresh
+// set of attempts. It resends the file already generated.
+func Retry(ctx context.Context, q cashflow.Querier, id string, now time.Time) error {
+	res, err := q.ExecContext(ctx, `
+		UPDATE report_deliveries SET status = ?, attempts = 0, next_attempt_at = ? WHERE id = ? AND status = ?`,
+		DeliveryRetrying, now.Truncate(time.Millisecond).UTC(), id, DeliveryFailed)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := GetDelivery(ctx, q, id); err != nil {
+			return err
+		}
+		return fmt.Errorf("delivery %s has not failed", id)
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+// Package schedule generates stored report definitions on a cadence and
+// delivers them by email, webhook or file drop.
+//
+// A subscription names a report, its parameters, a cadence in the
+// organization's timezone, an output format and where to send it. When a
+// subscription is due the scheduler records a delivery for that run and
+// advances the subscription; deliveries are then generated, stored as
+// attachments and sent, with failed sends retried on a backoff. Every run
+// stays in report_deliveries as the delivery history.
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/reports"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Tables are the cashflowdb tables owned by the scheduler. The unique key
+// on (subscription_id, scheduled_for) keeps a run from being recorded
+// twice when two schedulers race.
+var Tables = []schema.Table{
+	{
+		Name: "report_subscriptions",
+		Create: `CREATE TABLE IF NOT EXISTS report_subscriptions (
+  id VARCHAR(191) NOT NULL,
+  organization_id VARCHAR(191) NOT NULL,
+  name VARCHAR(191) NOT NULL,
+  report_code VARCHAR(100) NOT NULL,
+  parameters TEXT NOT NULL,
+  cadence VARCHAR(100) NOT NULL,
+  format VARCHAR(10) NOT NULL,
+  channel VARCHAR(20) NOT NULL,
+  recipients TEXT NOT NULL,
+  secret VARCHAR(500) NULL,
+  active BOOLEAN NOT NULL DEFAULT TRUE,
+  next_run_at DATETIME(3) NULL,
+  last_run_at DATETIME(3) NULL,
+  created_by VARCHAR(191) NULL,
+  created_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY report_subscriptions_org_name_unique (organization_id, name),
+  INDEX report_subscriptions_due_idx (active, next_run_at)
+) ENGINE=InnoDB`,
+	},
+	{
+		Name: "report_deliveries",
+		Create: `CREATE TABLE IF NOT EXISTS report_deliveries (
+  id VARCHAR(191) NOT NULL,
+  subscription_id VARCHAR(191) NOT NULL,
+  organization_id VARCHAR(191) NOT NULL,
+  scheduled_for DATETIME(3) NOT NULL,
+  as_of DATE NULL,
+  report_version INT NULL,
+  status VARCHAR(20) NOT NULL,
+  attempts INT NOT NULL DEFAULT 0,
+  next_attempt_at DATETIME(3) NULL,
+  attachment_id VARCHAR(191) NULL,
+  error TEXT NULL,
+  created_at DATETIME(3) NOT NULL,
+  delivered_at DATETIME(3) NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY report_deliveries_run_unique (subscription_id, scheduled_for),
+  INDEX report_deliveries_due_idx (status, next_attempt_at),
+  INDEX report_deliveries_org_idx (organization_id, created_at)
+) ENGINE=InnoDB`,
+	},
+}
+
+// Delivery channels.
+const (
+	ChannelSMTP    = "smtp"
+	ChannelWebhook = "webhook"
+	ChannelFile    = "file"
+)
+
+// As-of rules: the report date relative to the run's day in the
+// organization's timezone.
+const (
+	AsOfToday     = "today"
+	AsOfYesterday = "yesterday" // default
+	AsOfWeekEnd   = "week-end"  // the Sunday before the run
+	AsOfMonthEnd  = "month-end" // the last day of the previous month
+)
+
+// Params are the report parameters of a subscription.
+type Params struct {
+	AsOf    string `json:"asOf,omitempty"`
+	Version int    `json:"version,omitempty"` // 0 runs the latest version
+}
+
+// Subscription is a report_subscriptions row. Recipients are email
+// addresses for smtp, a URL for webhook and a directory under the file
+// root for file.
+type Subscription struct {
+	ID             string
+	OrganizationID string
+	Name           string
+	ReportCode     string
+	Params         Params
+	Cadence        string
+	Format         string
+	Channel        string
+	Recipients     []string
+	Secret         string // webhook signing secret, literal or env:VAR_NAME
+	Active         bool
+	NextRunAt      *time.Time
+	LastRunAt      *time.Time
+	CreatedBy      string
+	CreatedAt      time.Time
+}
+
+// asOf returns the report date for a run at t (in the org's timezone) as
+// a local date, the form reports.Evaluate takes.
+func (p Params) asOf(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	switch p.AsOf {
+	case AsOfToday:
+		return day
+	case AsOfWeekEnd:
+		back := int(day.Weekday())
+		if back == 0 {
+			back = 7
+		}
+		return day.AddDate(0, 0, -back)
+	case AsOfMonthEnd:
+		return day.AddDate(0, 0, -day.Day())
+	}
+	return day.AddDate(0, 0, -1)
+}
+
+// Validate checks a subscription and normalizes its cadence and recipients.
+func (s *Subscription) Validate() error {
+	if s.OrganizationID == "" || s.Name == "" || s.ReportCode == "" {
+		return fmt.Errorf("organization, name and report are required")
+	}
+	c, err := ParseCadence(s.Cadence)
+	if err != nil {
+		return err
+	}
+	s.Cadence = c.String()
+	switch s.Params.AsOf {
+	case "":
+		s.Params.AsOf = AsOfYesterday
+	case AsOfToday, AsOfYesterday, AsOfWeekEnd, AsOfMonthEnd:
+	default:
+		return fmt.Errorf("unknown as-of rule %q", s.Params.AsOf)
+	}
+	switch s.Format {
+	case reports.OutputText, reports.OutputCSV, reports.OutputXLSX, reports.OutputPDF:
+	default:
+		return fmt.Errorf("unknown format %q", s.Format)
+	}
+
+	var recipients []string
+	for _, r := range s.Recipients {
+		if r = strings.TrimSpace(r); r != "" {
+			recipients = append(recipients, r)
+		}
+	}
+	s.Recipients = recipients
+	if len(recipients) == 0 {
+		return fmt.Errorf("recipients are required")
+	}
+	switch s.Channel {
+	case ChannelSMTP:
+		for i, r := range recipients {
+			addr, err := mail.ParseAddress(r)
+			if err != nil {
+				return fmt.Errorf("recipient %q: %w", r, err)
+			}
+			recipients[i] = addr.Address
+		}
+	case ChannelWebhook:
+		if len(recipients) != 1 {
+			return fmt.Errorf("a webhook subscription has one URL")
+		}
+		u, err := url.Parse(recipients[0])
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("webhook URL %q must be http or https", recipients[0])
+		}
+	case ChannelFile:
+		if len(recipients) != 1 {
+			return fmt.Errorf("a file subscription has one directory")
+		}
+		if _, err := dropDir("", recipients[0]); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown channel %q", s.Channel)
+	}
+	return nil
+}
+
+// Location returns an organization's timezone from its OA org, UTC when
+// the org has none.
+func Location(ctx context.Context, cf, oadb cashflow.Querier, organizationID string) (*time.Location, error) {
+	orgID, err := oa.OrgForOrganization(ctx, cf, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	org, err := oa.GetOrg(ctx, oadb, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if org.Timezone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(org.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("org %s timezone: %w", org.Name, err)
+	}
+	return loc, nil
+}
+
+// Subscribe validates and stores a new subscription, scheduling its first
+// run after now in loc. The report must exist.
+func Subscribe(ctx context.Context, q cashflow.Querier, s *Subscription, loc *time.Location, now time.Time) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if _, err := reports.Get(ctx, q, s.OrganizationID, s.ReportCode, s.Params.Version); err != nil {
+		return err
+	}
+	c, _ := ParseCadence(s.Cadence)
+	next := c.Next(now, loc).UTC()
+	s.ID = cashflow.NewID("rsub")
+	s.Active = true
+	s.NextRunAt = &next
+	s.CreatedAt = now
+
+	params, err := json.Marshal(s.Params)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, `
+		INSERT INTO report_subscriptions (id, organization_id, name, report_code, parameters, cadence, format, channel,
+		  recipients, secret, active, next_run_at, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, true, ?, ?, ?)`,
+		s.ID, s.OrganizationID, s.Name, s.ReportCode, string(params), s.Cadence, s.Format, s.Channel,
+		strings.Join(s.Recipients, ","), cashflow.NullString(s.Secret), next, cashflow.NullString(s.CreatedBy), s.CreatedAt)
+	return err
+}
+
+const subscriptionColumns = `id, organization_id, name, report_code, parameters, cadence, format, channel, recipients,
+	COALESCE(secret, ''), active, next_run_at, last_run_at, COALESCE(created_by, ''), created_at
+	FROM report_subscriptions`
+
+func scanSubscription(row interface{ Scan(...any) error }) (*Subscription, error) {
+	s := &Subscription{}
+	var params, recipients string
+	var next, last sql.NullTime
+	err := row.Scan(&s.ID, &s.OrganizationID, &s.Name, &s.ReportCode, &params, &s.Cadence, &s.Format, &s.Channel,
+		&recipients, &s.Secret, &s.Active, &next, &last, &s.CreatedBy, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(params), &s.Params); err != nil {
+		return nil, fmt.Errorf("subscription %s parameters: %w", s.ID, err)
+	}
+	s.Recipients = strings.Split(recipients, ",")
+	if next.Valid {
+		s.NextRunAt = &next.Time
+	}
+	if last.Valid {
+		s.LastRunAt = &last.Time
+	}
+	return s, nil
+}
+
+// Get loads a subscription by id.
+func Get(ctx context.Context, q cashflow.Querier, id string) (*Subscription, error) {
+	s, err := scanSubscription(q.QueryRowContext(ctx, `SELECT `+subscriptionColumns+` WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("subscription %s: %w", id, cashflow.ErrNotFound)
+	}
+	return s, err
+}
+
+// ByName loads an organization's subscription by name.
+func ByName(ctx context.Context, q cashflow.Querier, organizationID, name string) (*Subscription, error) {
+	s, err := scanSubscription(q.QueryRowContext(ctx, `SELECT `+subscriptionColumns+`
+		WHERE organization_id = ? AND name = ?`, organizationID, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("subscription %q: %w", name, cashflow.ErrNotFound)
+	}
+	return s, err
+}
+
+// List returns an organization's subscriptions by name.
+func List(ctx context.Context, q cashflow.Querier, organizationID string) ([]*Subscription, error) {
+	return listSubscriptions(ctx, q, `SELECT `+subscriptionColumns+` WHERE organization_id = ? ORDER BY name`, organizationID)
+}
+
+// Due returns the active subscriptions whose next run is at or before now.
+func Due(ctx context.Context, q cashflow.Querier, now time.Time) ([]*Subscription, error) {
+	return listSubscriptions(ctx, q, `SELECT `+subscriptionColumns+`
+		WHERE active = true AND next_run_at <= ? ORDER BY next_run_at`, now.UTC())
+}
+
+func listSubscriptions(ctx context.Context, q cashflow.Querier, query string, args ...any) ([]*Subscription, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Subscription
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// SetActive pauses or resumes a subscription. Resuming schedules the next
+// run after now rather than catching up on the runs missed while paused.
+func SetActive(ctx context.Context, q cashflow.Querier, s *Subscription, active bool, loc *time.Location, now time.Time) error {
+	next := sql.NullTime{}
+	if active {
+		c, err := ParseCadence(s.Cadence)
+		if err != nil {
+			return err
+		}
+		next = sql.NullTime{Time: c.Next(now, loc).UTC(), Valid: true}
+	}
+	_, err := q.ExecContext(ctx, `UPDATE report_subscriptions SET active = ?, next_run_at = ? WHERE id = ?`,
+		active, next, s.ID)
+	if err == nil {
+		s.Active = active
+		s.NextRunAt = nil
+		if next.Valid {
+			s.NextRunAt = &next.Time
+		}
+	}
+	return err
+}
@@ -0,0 +1,219 @@
+package schedule
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/attachments"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/correlation"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/reports"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/tenantlimit"
+)
+
+// OwnerDelivery is the attachments owner type of generated reports.
+const OwnerDelivery = "report_delivery"
+
+// Scheduler runs due subscriptions and sends their deliveries.
+type Scheduler struct {
+	Config *Config
+	DB     *sql.DB // cashflowdb
+	OA     *sql.DB
+	Store  attachments.Store
+	Logger *log.Logger
+}
+
+// Run checks for due work every Interval until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(s.Config.Interval))
+	defer ticker.Stop()
+	for {
+		s.Tick(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick records the runs of due subscriptions, then attempts every delivery
+// that is due.
+func (s *Scheduler) Tick(ctx context.Context, now time.Time) {
+	subs, err := Due(ctx, s.DB, now)
+	if err != nil {
+		s.Logger.Printf("due subscriptions: %v", err)
+		return
+	}
+	for _, sub := range subs {
+		if ctx.Err() != nil {
+			return
+		}
+		loc, err := Location(ctx, s.DB, s.OA, sub.OrganizationID)
+		if err != nil {
+			s.Logger.Printf("%s/%s: %v", sub.OrganizationID, sub.Name, err)
+			continue
+		}
+		err = db.InTx(ctx, s.DB, func(tx *sql.Tx) error {
+			_, err := Enqueue(ctx, tx, sub, loc, now)
+			return err
+		})
+		if err != nil {
+			s.Logger.Printf("%s/%s: record run: %v", sub.OrganizationID, sub.Name, err)
+		}
+	}
+
+	due, err := dueDeliveries(ctx, s.DB, now)
+	if err != nil {
+		s.Logger.Printf("due deliveries: %v", err)
+		return
+	}
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		actx, _ := correlation.Ensure(ctx)
+		if err := s.Attempt(actx, d, now); err != nil && !errors.Is(err, ErrClaimed) {
+			s.jobs().Printf(actx, "%s/%s: delivery %s attempt %d: %v", d.OrganizationID, d.Subscription, d.ID, d.Attempts, err)
+		}
+	}
+}
+
+// Attempt claims a delivery and tries it once: the report is generated on
+// the first attempt and resent as stored after that. A failed attempt is
+// recorded for retry and returned; ErrClaimed means it was not attempted.
+// The attempt runs under ctx's correlation id, or a new one, which webhook
+// deliveries send as X-Request-ID, and as batch traffic of the delivery's
+// organization when the connections are tenant limited.
+func (s *Scheduler) Attempt(ctx context.Context, d *Delivery, now time.Time) error {
+	ctx, _ = correlation.Ensure(ctx)
+	timeout := time.Duration(s.Config.Timeout)
+	ok, err := claim(ctx, s.DB, d, now, 2*timeout)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrClaimed
+	}
+	s.jobs().Printf(ctx, "%s/%s: delivery %s attempt %d", d.OrganizationID, d.Subscription, d.ID, d.Attempts)
+
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	attemptCtx = tenantlimit.WithPriority(tenantlimit.WithTenant(attemptCtx, d.OrganizationID), tenantlimit.Batch)
+	sendErr := s.attempt(attemptCtx, d)
+	if sendErr == nil {
+		s.jobs().Printf(ctx, "%s/%s: delivery %s sent attachment %s", d.OrganizationID, d.Subscription, d.ID, d.AttachmentID)
+		return delivered(ctx, s.DB, d, time.Now())
+	}
+	if err := failed(ctx, s.DB, d, sendErr, time.Now(), s.Config.MaxAttempts, time.Duration(s.Config.RetryBackoff)); err != nil {
+		return fmt.Errorf("%v (recording failure: %v)", sendErr, err)
+	}
+	return sendErr
+}
+
+// jobs logs delivery attempts by correlation id.
+func (s *Scheduler) jobs() *correlation.Log {
+	return &correlation.Log{DB: s.DB, Job: "reportd", Logger: s.Logger}
+}
+
+func (s *Scheduler) attempt(ctx context.Context, d *Delivery) error {
+	sub, err := Get(ctx, s.DB, d.SubscriptionID)
+	if err != nil {
+		return err
+	}
+	r, err := s.report(ctx, sub, d)
+	if err != nil {
+		return err
+	}
+	return s.send(ctx, sub, d, r)
+}
+
+// report generates the delivery's report, or loads it when an earlier
+// attempt already did.
+func (s *Scheduler) report(ctx context.Context, sub *Subscription, d *Delivery) (*Report, error) {
+	orgID, err := oa.OrgForOrganization(ctx, s.DB, sub.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	org, err := oa.GetOrg(ctx, s.OA, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.AttachmentID != "" {
+		a, err := attachments.Get(ctx, s.DB, d.AttachmentID)
+		if err != nil {
+			return nil, err
+		}
+		rc, err := s.Store.Open(ctx, a.StorageKey)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			return nil, err
+		}
+		stored, err := reports.Get(ctx, s.DB, sub.OrganizationID, sub.ReportCode, d.ReportVersion)
+		if err != nil {
+			return nil, err
+		}
+		return &Report{
+			Title: stored.Title, OrgName: org.Name, AsOf: *d.AsOf, FileName: a.FileName,
+			ContentType: a.ContentType, Data: data,
+		}, nil
+	}
+
+	loc, err := Location(ctx, s.DB, s.OA, sub.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	asOf := sub.Params.asOf(d.ScheduledFor.In(loc))
+	stored, err := reports.Get(ctx, s.DB, sub.OrganizationID, sub.ReportCode, sub.Params.Version)
+	if err != nil {
+		return nil, err
+	}
+	def, err := stored.Definition()
+	if err != nil {
+		return nil, err
+	}
+	t, err := reports.Evaluate(ctx, s.DB, s.OA, sub.OrganizationID, def, asOf)
+	if err != nil {
+		return nil, err
+	}
+	t.Version = stored.Version
+	var buf bytes.Buffer
+	if err := t.Render(&buf, sub.Format); err != nil {
+		return nil, err
+	}
+
+	r := &Report{
+		Title: t.Title, OrgName: org.Name, AsOf: asOf,
+		FileName:    fmt.Sprintf("%s-%s.%s", def.Code, asOf.Format(time.DateOnly), reports.Extension(sub.Format)),
+		ContentType: reports.ContentType(sub.Format), Data: buf.Bytes(),
+	}
+	a := &attachments.Attachment{
+		OrganizationID: sub.OrganizationID, OwnerType: OwnerDelivery, OwnerID: d.ID,
+		FileName: r.FileName, ContentType: r.ContentType,
+	}
+	d.AsOf, d.ReportVersion = &asOf, stored.Version
+	err = db.InTx(ctx, s.DB, func(tx *sql.Tx) error {
+		if err := attachments.Save(ctx, tx, s.Store, a, r.Data); err != nil {
+			return err
+		}
+		d.AttachmentID = a.ID
+		return generated(ctx, tx, d)
+	})
+	if err != nil {
+		d.AttachmentID = ""
+		return nil, err
+	}
+	return r, nil
+}
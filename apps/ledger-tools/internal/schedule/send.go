@@ -0,0 +1,178 @@
+package schedule
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/correlation"
+)
+
+// Report is a generated report ready to send.
+type Report struct {
+	Title       string
+	OrgName     string
+	AsOf        time.Time
+	FileName    string
+	ContentType string
+	Data        []byte
+}
+
+// send delivers a report over the subscription's channel.
+func (s *Scheduler) send(ctx context.Context, sub *Subscription, d *Delivery, r *Report) error {
+	switch sub.Channel {
+	case ChannelSMTP:
+		return s.sendMail(sub, r)
+	case ChannelWebhook:
+		return sendWebhook(ctx, sub, d, r)
+	case ChannelFile:
+		return s.dropFile(sub, r)
+	}
+	return fmt.Errorf("unknown channel %q", sub.Channel)
+}
+
+func (s *Scheduler) sendMail(sub *Subscription, r *Report) error {
+	cfg := s.Config.SMTP
+	if cfg.Host == "" {
+		return fmt.Errorf("smtp is not configured")
+	}
+	msg, err := mailMessage(cfg.From, sub.Recipients, r)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	return smtp.SendMail(addr, auth, cfg.From, sub.Recipients, msg)
+}
+
+// mailMessage builds a multipart message with a short note and the report
+// attached.
+func mailMessage(from string, to []string, r *Report) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	var id [12]byte
+	rand.Read(id[:])
+	_, domain, _ := strings.Cut(from, "@")
+
+	subject := fmt.Sprintf("%s as of %s", r.Title, r.AsOf.Format("2 Jan 2006"))
+	if r.OrgName != "" {
+		subject = r.OrgName + ": " + subject
+	}
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id[:]), domain)
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", mw.Boundary())
+
+	body, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(body, "%s as of %s is attached (%s).\r\n", r.Title, r.AsOf.Format("2 January 2006"), r.FileName)
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {r.ContentType},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": r.FileName})},
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(r.Data)
+	for len(encoded) > 76 {
+		io.WriteString(part, encoded[:76]+"\r\n")
+		encoded = encoded[76:]
+	}
+	io.WriteString(part, encoded+"\r\n")
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sendWebhook posts the report file. With a secret, the body is signed
+// like the payment gateway callbacks: X-Signature is sha256= and the
+// HMAC-SHA256 of "<X-Timestamp>.<body>".
+func sendWebhook(ctx context.Context, sub *Subscription, d *Delivery, r *Report) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Recipients[0], bytes.NewReader(r.Data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", r.ContentType)
+	req.Header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": r.FileName}))
+	req.Header.Set("X-Report-Code", sub.ReportCode)
+	req.Header.Set("X-Report-As-Of", r.AsOf.Format(time.DateOnly))
+	req.Header.Set("X-Delivery-Id", d.ID)
+	if key := secret(sub.Secret); key != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(ts + "."))
+		mac.Write(r.Data)
+		req.Header.Set("X-Timestamp", ts)
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := correlation.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// dropFile writes the report into the subscription's directory under the
+// file root, atomically; a resend replaces the earlier file.
+func (s *Scheduler) dropFile(sub *Subscription, r *Report) error {
+	if s.Config.FileRoot == "" {
+		return fmt.Errorf("fileRoot is not configured")
+	}
+	dir, err := dropDir(s.Config.FileRoot, sub.Recipients[0])
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, r.FileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, r.Data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// dropDir resolves a file subscription's directory, which must stay under
+// root.
+func dropDir(root, dir string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(dir))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("directory %q must be relative to the file root", dir)
+	}
+	return filepath.Join(root, clean), nil
+}
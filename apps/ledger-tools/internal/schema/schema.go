@@ -0,0 +1,28 @@
+// Package schema creates the side tables owned by the ledger tools.
+//
+// These tables live next to the Prisma-managed cashflow tables but are not
+// part of the Prisma schema; each subsystem declares its own and applies
+// them from its migrate subcommand.
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Table is a CREATE TABLE IF NOT EXISTS statement owned by a subsystem.
+type Table struct {
+	Name   string
+	Create string
+}
+
+// Ensure creates any of the tables that do not exist yet.
+func Ensure(ctx context.Context, conn *sql.DB, tables []Table) error {
+	for _, t := range tables {
+		if _, err := conn.ExecContext(ctx, t.Create); err != nil {
+			return fmt.Errorf("create table %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,852 @@
+package seed
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+const (
+	currency  = "MMK"
+	precision = 2
+	timezone  = "Asia/Yangon"
+)
+
+// oaClasses are OA's top-level categories under Root, by ledger class.
+var oaClasses = []struct{ class, name string }{
+	{cashflow.ClassAsset, "Assets"},
+	{cashflow.ClassLiability, "Liabilities"},
+	{cashflow.ClassEquity, "Equity"},
+	{cashflow.ClassIncome, "Income"},
+	{cashflow.ClassExpense, "Expenses"},
+}
+
+// Event ordering within a day: stock arrives before it is moved or sold.
+const (
+	orderPurchase = iota
+	orderTransfer
+	orderInvoice
+	orderPayment
+	orderExpense
+)
+
+type builder struct {
+	d    *Dataset
+	p    *profile
+	seed *Seed
+	rng  *rand.Rand
+	cf   *rowSet
+	oa   *rowSet
+
+	opened time.Time // setup rows and opening balances, the day before history
+	start  time.Time // first day of history
+
+	accounts   map[string]*Account // by code
+	oaAccounts map[string][]byte   // by code
+	oaOrg      []byte
+	oaAuthor   []byte // owner, the user OA transactions are recorded under
+	taxID      string
+
+	branches     []string
+	warehouses   []*warehouse
+	products     []*product
+	customers    []*contact
+	vendors      []*contact
+	salespersons []string
+
+	events    []*event
+	invoices  []*invoice
+	layers    map[string][]*layer // open layers by product and warehouse
+	allLayers []*layer
+	transfers map[int]int // transfers per year
+	seq       map[string]int
+}
+
+type warehouse struct {
+	id, name, branchID string
+	primary            bool
+}
+
+type product struct {
+	id, sku, name, unit string
+	price, cost         float64
+}
+
+type contact struct {
+	id, name string
+}
+
+type layer struct {
+	id, itemID, warehouseID string
+	sourceType, sourceID    string
+	remaining, unitCost     float64
+	created                 time.Time
+}
+
+type event struct {
+	date  time.Time
+	order int
+	run   func(time.Time)
+}
+
+type invoice struct {
+	id, number, customerID, branchID, warehouseID, salespersonID, journalID string
+	issued                                                                  time.Time
+	subtotal, tax, total, paid                                              money.Amount
+}
+
+type line struct {
+	code          string
+	debit, credit money.Amount
+	description   string
+}
+
+func newBuilder(d *Dataset, through time.Time) *builder {
+	through = time.Date(through.Year(), through.Month(), through.Day(), 0, 0, 0, 0, time.UTC)
+	p := &d.profile
+	start := time.Date(through.Year(), through.Month()-time.Month(p.months)+1, 1, 0, 0, 0, 0, time.UTC)
+	if p.months == 0 {
+		start = through.AddDate(0, 0, 1)
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(d.Name))
+	b := &builder{
+		d:          d,
+		p:          p,
+		rng:        rand.New(rand.NewSource(int64(h.Sum64()))),
+		cf:         newRowSet(),
+		oa:         newRowSet(),
+		opened:     start.AddDate(0, 0, -1),
+		start:      start,
+		accounts:   map[string]*Account{},
+		oaAccounts: map[string][]byte{},
+		layers:     map[string][]*layer{},
+		transfers:  map[int]int{},
+		seq:        map[string]int{},
+	}
+	b.oaOrg = b.oaID("org")
+	b.seed = &Seed{
+		Dataset:        d,
+		Through:        through,
+		OrganizationID: b.id("org", "organization"),
+		OAOrgID:        hex.EncodeToString(b.oaOrg),
+		cashflow:       b.cf,
+		oa:             b.oa,
+	}
+	return b
+}
+
+// id derives a cashflowdb id in the BFF's prefix_ style from the dataset
+// and a key.
+func (b *builder) id(prefix, key string) string {
+	sum := sha256.Sum256([]byte(b.d.Name + "/" + prefix + "/" + key))
+	return prefix + "_seed_" + hex.EncodeToString(sum[:8])
+}
+
+// oaID derives an OA BINARY(16) id from the dataset and a key.
+func (b *builder) oaID(key string) []byte {
+	sum := sha256.Sum256([]byte("oa/" + b.d.Name + "/" + key))
+	return sum[:16]
+}
+
+// number returns the next document number of a series, e.g. INV-DR-00001.
+func (b *builder) number(series string) string {
+	b.seq[series]++
+	return fmt.Sprintf("%s-%s-%05d", series, b.p.prefix, b.seq[series])
+}
+
+func (b *builder) build() {
+	b.organization()
+	b.users()
+	b.ledger()
+	b.setup()
+	b.contacts()
+	b.opening()
+	b.schedule()
+	// Events may schedule later ones, such as a bill's payment, as they run.
+	for i := 0; i < len(b.events); i++ {
+		b.events[i].run(b.events[i].date)
+	}
+	b.finish()
+}
+
+func (b *builder) organization() {
+	p := b.p
+	b.cf.add("organizations",
+		[]string{"id", "name", "slug", "oaOrganizationId", "baseCurrency", "fiscalYearStart", "timezone", "city", "country",
+			"email", "subscriptionStatus", "createdAt", "updatedAt"},
+		b.seed.OrganizationID, p.orgName, p.slug, b.seed.OAOrgID, currency, "01-01", timezone, p.branches[0].city, "Myanmar",
+		"accounts@"+b.d.Name+".seed.test", "active", b.opened, b.opened)
+	b.cf.add("organization_settings",
+		[]string{"id", "organizationId", "invoicePrefix", "defaultPaymentTerms", "defaultTaxRate", "createdAt", "updatedAt"},
+		b.id("settings", "organization"), b.seed.OrganizationID, "INV-"+p.prefix, "Net 30", 0.05, b.opened, b.opened)
+
+	ms := oaMillis(b.opened)
+	b.oa.add("org", []string{"id", "inserted", "updated", "name", "currency", "precision", "timezone"},
+		b.oaOrg, ms, ms, p.orgName, currency, precision, timezone)
+}
+
+func (b *builder) users() {
+	ms := oaMillis(b.opened)
+	for _, spec := range b.p.users {
+		u := &User{
+			Email: spec.local + "@" + b.d.Name + ".seed.test",
+			Role:  spec.role,
+			ID:    b.id("user", spec.local),
+		}
+		oaID := b.oaID("user/" + spec.local)
+		u.OAID = hex.EncodeToString(oaID)
+		b.seed.Users = append(b.seed.Users, u)
+		if b.oaAuthor == nil {
+			b.oaAuthor = oaID
+		}
+
+		b.cf.add("users",
+			[]string{"id", "email", "name", "emailVerified", "defaultCurrency", "timezone", "locale", "createdAt", "updatedAt"},
+			u.ID, u.Email, spec.name, b.opened, currency, timezone, "en", b.opened, b.opened)
+		b.cf.add("organization_members",
+			[]string{"id", "organizationId", "userId", "role", "status", "joinedAt", "updatedAt"},
+			b.id("member", spec.local), b.seed.OrganizationID, u.ID, spec.role, "active", b.opened, b.opened)
+
+		first, last := spec.name, ""
+		if i := strings.LastIndex(spec.name, " "); i > 0 {
+			first, last = spec.name[:i], spec.name[i+1:]
+		}
+		b.oa.add("user",
+			[]string{"id", "inserted", "updated", "firstName", "lastName", "email", "passwordHash", "agreeToTerms",
+				"passwordReset", "emailVerified", "emailVerifyCode", "signupSource"},
+			oaID, ms, ms, first, last, u.Email, "", true, "", true, "", "seed")
+		b.oa.add("userorg", []string{"userId", "orgId", "admin"},
+			oaID, b.oaOrg, spec.role == "owner" || spec.role == "admin")
+	}
+}
+
+// ledger writes the chart to both databases: ledger accounts in cashflowdb
+// and, in OA, Root, the five categories and an account of the same name
+// under its category for each ledger account.
+func (b *builder) ledger() {
+	ms := oaMillis(b.opened)
+	oaCols := []string{"id", "orgId", "inserted", "updated", "name", "parent", "currency", "precision", "debitBalance"}
+	root := b.oaID("account/root")
+	b.oa.add("account", oaCols, root, b.oaOrg, ms, ms, "Root", make([]byte, 16), currency, precision, true)
+	categories := map[string][]byte{}
+	for _, c := range oaClasses {
+		id := b.oaID("account/" + c.class)
+		categories[c.class] = id
+		b.oa.add("account", oaCols, id, b.oaOrg, ms, ms, c.name, root, currency, precision, cashflow.DebitNormal(c.class))
+	}
+
+	for _, c := range chart {
+		class := cashflow.AccountClass(c.typ)
+		oaID := b.oaID("account/" + c.code)
+		a := &Account{Code: c.code, Name: c.name, Type: c.typ, ID: b.id("acc", c.code), OAID: hex.EncodeToString(oaID)}
+		b.accounts[c.code] = a
+		b.oaAccounts[c.code] = oaID
+		b.seed.Accounts = append(b.seed.Accounts, a)
+
+		b.cf.add("ledger_accounts",
+			[]string{"id", "organizationId", "code", "name", "type", "isActive", "createdAt", "updatedAt"},
+			a.ID, b.seed.OrganizationID, a.Code, a.Name, a.Type, true, b.opened, b.opened)
+		b.oa.add("account", oaCols, oaID, b.oaOrg, ms, ms, a.Name, categories[class], currency, precision, cashflow.DebitNormal(class))
+	}
+}
+
+// setup writes taxes, branches, warehouses and salespersons.
+func (b *builder) setup() {
+	for i, t := range taxes {
+		id := b.id("tax", t.name)
+		if i == 0 {
+			b.taxID = id
+		}
+		b.cf.add("taxes",
+			[]string{"id", "name", "rate", "type", "isCompound", "organizationId", "isActive", "createdAt", "updatedAt"},
+			id, t.name, t.rate, t.typ, t.compound, b.seed.OrganizationID, true, b.opened, b.opened)
+	}
+
+	for i, br := range b.p.branches {
+		branchID := b.id("branch", br.name)
+		b.branches = append(b.branches, branchID)
+		b.cf.add("branches",
+			[]string{"id", "organizationId", "name", "city", "country", "isDefault", "isActive", "createdAt", "updatedAt"},
+			branchID, b.seed.OrganizationID, br.name, br.city, "Myanmar", i == 0, true, b.opened, b.opened)
+
+		for j, ws := range br.warehouses {
+			w := &warehouse{id: b.id("warehouse", ws.code), name: ws.name, branchID: branchID, primary: j == 0}
+			b.warehouses = append(b.warehouses, w)
+			b.cf.add("warehouses",
+				[]string{"id", "organizationId", "branchId", "name", "code", "city", "country", "warehouseType",
+					"isDefault", "isActive", "isPrimary", "createdAt", "updatedAt"},
+				w.id, b.seed.OrganizationID, branchID, ws.name, b.p.prefix+"-"+ws.code, br.city, "Myanmar", ws.kind,
+				i == 0 && j == 0, true, w.primary, b.opened, b.opened)
+		}
+
+		id := b.id("salesperson", br.name)
+		b.salespersons = append(b.salespersons, id)
+		b.cf.add("salespersons", []string{"id", "organizationId", "name", "email", "status", "createdAt", "updatedAt"},
+			id, b.seed.OrganizationID, br.city+" Sales",
+			fmt.Sprintf("sales%d@%s.seed.test", i+1, b.d.Name), "active", b.opened, b.opened)
+	}
+}
+
+// contacts sets up products, customers and vendors. Product rows are
+// written by finish, once their stock is known.
+func (b *builder) contacts() {
+	for i := 0; i < b.p.products && i < len(catalog); i++ {
+		c := catalog[i]
+		b.products = append(b.products, &product{
+			id: b.id("product", fmt.Sprint(i)), sku: fmt.Sprintf("%s-%03d", b.p.prefix, i+1), name: c.name, unit: c.unit, price: c.price, cost: c.cost,
+		})
+	}
+	for i := 0; i < b.p.customers && i < len(customerNames); i++ {
+		c := &contact{id: b.id("customer", fmt.Sprint(i)), name: customerNames[i]}
+		b.customers = append(b.customers, c)
+		b.cf.add("customers",
+			[]string{"id", "organizationId", "name", "displayName", "email", "customerType", "companyName", "currency",
+				"paymentTerms", "isActive", "createdAt", "updatedAt"},
+			c.id, b.seed.OrganizationID, c.name, c.name, fmt.Sprintf("customer%d@%s.seed.test", i+1, b.d.Name), "business",
+			c.name, currency, "Net 30", true, b.opened, b.opened)
+	}
+	for i := 0; i < b.p.vendors && i < len(vendorNames); i++ {
+		c := &contact{id: b.id("vendor", fmt.Sprint(i)), name: vendorNames[i]}
+		b.vendors = append(b.vendors, c)
+		b.cf.add("vendors",
+			[]string{"id", "organizationId", "name", "displayName", "email", "vendorType", "companyName", "currency",
+				"paymentTerms", "isActive", "createdAt", "updatedAt"},
+			c.id, b.seed.OrganizationID, c.name, c.name, fmt.Sprintf("vendor%d@%s.seed.test", i+1, b.d.Name), "supplier",
+			c.name, currency, "net30", true, b.opened, b.opened)
+	}
+}
+
+// opening posts the opening bank balance and stocks every primary
+// warehouse, one journal per opening balance as the BFF does.
+func (b *builder) opening() {
+	if b.p.openingCash > 0 {
+		amount := money.FromFloat(b.p.openingCash)
+		b.journal(b.number("OB"), b.opened, "Opening Balance", "Opening bank balance",
+			line{code: codeBank, debit: amount, description: "Opening bank balance"},
+			line{code: codeOpening, credit: amount, description: "Opening balance equity"})
+	}
+	if b.p.openingStock == 0 {
+		return
+	}
+	for _, w := range b.warehouses {
+		if !w.primary {
+			continue
+		}
+		for _, p := range b.products {
+			qty := float64(b.p.openingStock)
+			value := money.FromFloat(qty * p.cost)
+			obID := b.id("opening", p.id+"/"+w.id)
+			journalID := b.journal(b.number("OB"), b.opened, "Opening Balance - "+p.name,
+				"Opening inventory balance for "+p.name+" in warehouse",
+				line{code: codeInventory, debit: value, description: "Opening inventory - " + p.name},
+				line{code: codeOpening, credit: value, description: "Opening balance equity - " + p.name})
+			b.cf.add("inventory_opening_balances",
+				[]string{"id", "itemId", "warehouseId", "quantity", "unitCost", "totalValue", "asOfDate", "journalId", "createdAt", "updatedAt"},
+				obID, p.id, w.id, qty, p.cost, value, b.opened, journalID, b.opened, b.opened)
+			b.receive(p, w, qty, p.cost, b.opened, receipt{
+				key: "opening", layerSource: "opening", movementType: "opening", sourceType: "opening_balance",
+				sourceID: obID, journalID: journalID, reference: "Opening Balance",
+			})
+		}
+	}
+}
+
+// schedule lays out the history as dated events.
+func (b *builder) schedule() {
+	through := b.seed.Through
+	for m := b.start; !m.After(through); m = m.AddDate(0, 1, 0) {
+		end := m.AddDate(0, 1, -1)
+		if end.After(through) {
+			end = through
+		}
+		days := int(end.Sub(m).Hours()/24) + 1
+		if b.p.purchases && days >= 5 {
+			b.at(m.AddDate(0, 0, 4), orderPurchase, b.restock)
+		}
+		if b.p.expenses {
+			b.at(m, orderExpense, b.expense(codeRent, "Office and shop rent", 1500000, 0))
+			if days >= 10 {
+				b.at(m.AddDate(0, 0, 9), orderExpense, b.expense(codeUtility, "Electricity and internet", 250000, 150000))
+			}
+			if days >= 25 {
+				b.at(m.AddDate(0, 0, 24), orderExpense, b.expense(codeSalary, "Monthly salaries", 4800000, 0))
+			}
+		}
+		for i := 0; i < b.p.invoicesPerMonth; i++ {
+			b.scheduleInvoice(m.AddDate(0, 0, b.rng.Intn(days)))
+		}
+	}
+
+	// Transfers are spread evenly over the history.
+	span := int(through.Sub(b.start).Hours()/24) + 1
+	for i := 0; i < b.p.transfers && span > 0; i++ {
+		b.at(b.start.AddDate(0, 0, (i*span+span/2)/b.p.transfers), orderTransfer, b.transfer)
+	}
+}
+
+// at schedules an event, keeping the events in date and then order
+// sequence; events of the same date and order run as scheduled.
+func (b *builder) at(date time.Time, order int, run func(time.Time)) {
+	i := sort.Search(len(b.events), func(i int) bool {
+		e := b.events[i]
+		return e.date.After(date) || e.date.Equal(date) && e.order > order
+	})
+	b.events = append(b.events, nil)
+	copy(b.events[i+1:], b.events[i:])
+	b.events[i] = &event{date: date, order: order, run: run}
+}
+
+// scheduleInvoice schedules an invoice and, for the paid share, its payment.
+func (b *builder) scheduleInvoice(date time.Time) {
+	inv := &invoice{}
+	b.at(date, orderInvoice, func(d time.Time) { b.sell(inv, d) })
+	if b.rng.Float64() >= b.p.paidShare {
+		return
+	}
+	paid := date.AddDate(0, 0, 2+b.rng.Intn(20))
+	if paid.After(b.seed.Through) {
+		return
+	}
+	mode := "bank_transfer"
+	if b.rng.Intn(3) == 0 {
+		mode = "cash"
+	}
+	b.at(paid, orderPayment, func(d time.Time) { b.pay(inv, d, mode) })
+}
+
+// sell issues an invoice from one warehouse, drawing cost of sales from its
+// FIFO layers. Lines are trimmed to the stock on hand; an invoice nothing
+// can be sold on is dropped.
+func (b *builder) sell(inv *invoice, date time.Time) {
+	var stocked []*warehouse
+	for _, w := range b.warehouses {
+		if b.onHand(w, nil) > 0 {
+			stocked = append(stocked, w)
+		}
+	}
+	if len(stocked) == 0 || len(b.customers) == 0 {
+		return
+	}
+	w := stocked[b.rng.Intn(len(stocked))]
+	customer := b.customers[b.rng.Intn(len(b.customers))]
+	lines := 1 + b.rng.Intn(3)
+
+	inv.id = b.id("invoice", fmt.Sprint(len(b.invoices)))
+	inv.customerID = customer.id
+	inv.branchID = w.branchID
+	inv.warehouseID = w.id
+	inv.issued = date
+	inv.salespersonID = b.salespersons[b.branchIndex(w.branchID)]
+
+	type sale struct {
+		p       *product
+		qty     float64
+		consume []consumption
+	}
+	var sales []sale
+	var cost money.Amount
+	seen := map[*product]bool{}
+	for i := 0; i < lines; i++ {
+		p := b.products[b.rng.Intn(len(b.products))]
+		qty := float64(1 + b.rng.Intn(5))
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		qty = math.Min(qty, b.onHand(w, p))
+		if qty <= 0 {
+			continue
+		}
+		s := sale{p: p, qty: qty, consume: b.consume(p, w, qty)}
+		for _, c := range s.consume {
+			cost += c.cost
+		}
+		sales = append(sales, s)
+	}
+	if len(sales) == 0 {
+		return
+	}
+
+	inv.number = b.number("INV")
+	itemCols := []string{"id", "invoiceId", "productId", "itemName", "quantity", "unit", "rate", "taxId", "taxPercent",
+		"taxAmount", "amount", "salesAccountId", "createdAt", "updatedAt"}
+	for i, s := range sales {
+		amount := money.FromFloat(s.qty * s.p.price)
+		tax := money.FromFloat(amount.Float() * taxes[0].rate / 100)
+		inv.subtotal += amount
+		inv.tax += tax
+		b.cf.add("invoice_items", itemCols,
+			b.id("item", fmt.Sprintf("%s/%d", inv.id, i)), inv.id, s.p.id, s.p.name, s.qty, s.p.unit, s.p.price, b.taxID,
+			taxes[0].rate, tax, amount, b.accounts[codeSales].ID, date, date)
+	}
+	inv.total = inv.subtotal + inv.tax
+
+	customerName := customer.name
+	inv.journalID = b.journal(b.number("J"), date, inv.number, "Invoice "+inv.number+" - "+customerName,
+		line{code: codeAR, debit: inv.total, description: "Invoice " + inv.number},
+		line{code: codeSales, credit: inv.subtotal, description: "Sales - " + inv.number},
+		line{code: codeOutputVAT, credit: inv.tax, description: "Output VAT - " + inv.number},
+		line{code: codeCOGS, debit: cost, description: "Cost of goods sold - " + inv.number},
+		line{code: codeInventory, credit: cost, description: "Inventory - " + inv.number})
+	for _, s := range sales {
+		for _, c := range s.consume {
+			b.movement(s.p.id, w.id, c.layer.id, "out", c.qty, c.layer.unitCost, c.cost, "sale", "invoice", inv.id,
+				inv.journalID, inv.number, date)
+		}
+	}
+	b.invoices = append(b.invoices, inv)
+}
+
+// pay records full payment of an invoice into the bank account.
+func (b *builder) pay(inv *invoice, date time.Time, mode string) {
+	if inv.number == "" {
+		return
+	}
+	number := b.number("PAY")
+	journalID := b.journal(b.number("J"), date, number, "Payment for invoice "+inv.number,
+		line{code: codeBank, debit: inv.total, description: "Payment " + number},
+		line{code: codeAR, credit: inv.total, description: "Invoice " + inv.number})
+	b.cf.add("invoice_payments",
+		[]string{"id", "invoiceId", "paymentNumber", "paymentDate", "amountReceived", "paymentMode", "depositTo",
+			"reference", "journalId", "createdAt", "updatedAt"},
+		b.id("payment", inv.id), inv.id, number, date, inv.total, mode, b.accounts[codeBank].ID,
+		inv.number, journalID, date, date)
+	inv.paid = inv.total
+}
+
+// restock buys each primary warehouse back up to its opening stock from a
+// vendor on account, paid fifteen days later.
+func (b *builder) restock(date time.Time) {
+	if len(b.vendors) == 0 {
+		return
+	}
+	target := float64(b.p.openingStock)
+	for _, w := range b.warehouses {
+		if !w.primary {
+			continue
+		}
+		var lines []line
+		var received []*layer
+		var total money.Amount
+		bill := ""
+		for _, p := range b.products {
+			have := b.onHand(w, p)
+			if have >= target/2 {
+				continue
+			}
+			if bill == "" {
+				bill = b.number("BILL")
+			}
+			qty := target - have
+			unitCost := math.Round(p.cost * (1 + float64(b.rng.Intn(7)-2)/100))
+			value := money.FromFloat(qty * unitCost)
+			total += value
+			lines = append(lines, line{code: codeInventory, debit: value, description: "Purchase - " + p.name})
+			received = append(received, b.receive(p, w, qty, unitCost, date, receipt{
+				key: bill, layerSource: "bill", movementType: "purchase", sourceType: "bill", sourceID: bill, reference: bill,
+			}))
+		}
+		if bill == "" {
+			continue
+		}
+		vendor := b.vendors[b.rng.Intn(len(b.vendors))]
+		lines = append(lines, line{code: codeAP, credit: total, description: vendor.name})
+		journalID := b.journal(b.number("J"), date, bill, "Bill "+bill+" - "+vendor.name+" ("+w.name+")", lines...)
+		for _, l := range received {
+			b.linkReceipt(l, journalID)
+		}
+
+		due := date.AddDate(0, 0, 15)
+		if !due.After(b.seed.Through) {
+			b.at(due, orderPayment, func(d time.Time) {
+				b.journal(b.number("J"), d, bill, "Payment of bill "+bill+" - "+vendor.name,
+					line{code: codeAP, debit: total, description: vendor.name},
+					line{code: codeBank, credit: total, description: "Payment of bill " + bill})
+			})
+		}
+	}
+}
+
+// expense returns an event posting a monthly expense paid from the bank;
+// spread adds up to that much random variation.
+func (b *builder) expense(code, description string, amount, spread float64) func(time.Time) {
+	return func(date time.Time) {
+		a := money.FromFloat(amount + math.Round(b.rng.Float64()*spread))
+		b.journal(b.number("J"), date, date.Format("Jan 2006"), description,
+			line{code: code, debit: a, description: description},
+			line{code: codeBank, credit: a, description: description})
+	}
+}
+
+// transfer moves a few products from the best stocked primary warehouse to
+// another warehouse, completed the same day. Transfers within the
+// organization carry no journal.
+func (b *builder) transfer(date time.Time) {
+	if len(b.warehouses) < 2 {
+		return
+	}
+	var from *warehouse
+	for _, w := range b.warehouses {
+		if w.primary && (from == nil || b.onHand(w, nil) > b.onHand(from, nil)) {
+			from = w
+		}
+	}
+	others := make([]*warehouse, 0, len(b.warehouses)-1)
+	for _, w := range b.warehouses {
+		if w != from {
+			others = append(others, w)
+		}
+	}
+	to := others[b.rng.Intn(len(others))]
+
+	type move struct {
+		p        *product
+		qty      float64
+		unitCost float64
+		value    money.Amount
+		consume  []consumption
+	}
+	var moves []move
+	var total money.Amount
+	for _, i := range b.rng.Perm(len(b.products))[:2+b.rng.Intn(2)] {
+		p := b.products[i]
+		qty := math.Min(float64(5+b.rng.Intn(16)), math.Floor(b.onHand(from, p)/3))
+		if qty <= 0 {
+			continue
+		}
+		m := move{p: p, qty: qty, consume: b.consume(p, from, qty)}
+		for _, c := range m.consume {
+			m.value += c.cost
+		}
+		m.unitCost = round4(m.value.Float() / qty)
+		total += m.value
+		moves = append(moves, m)
+	}
+	if len(moves) == 0 {
+		return
+	}
+
+	b.transfers[date.Year()]++
+	number := fmt.Sprintf("TRF-%s-%d-%04d", b.p.prefix, date.Year(), b.transfers[date.Year()])
+	id := b.id("transfer", number)
+	b.cf.add("inventory_transfers",
+		[]string{"id", "organizationId", "transferNumber", "fromWarehouseId", "toWarehouseId", "status", "transferDate",
+			"expectedDate", "completedDate", "notes", "totalValue", "createdBy", "approvedBy", "createdAt", "updatedAt"},
+		id, b.seed.OrganizationID, number, from.id, to.id, "completed", date, date, date,
+		"Transfer from "+from.name+" to "+to.name, total, b.seed.Users[0].ID, b.seed.Users[0].ID, date, date)
+	for i, m := range moves {
+		b.cf.add("inventory_transfer_items",
+			[]string{"id", "transferId", "itemId", "quantity", "unitCost", "totalValue", "createdAt", "updatedAt"},
+			b.id("transferitem", fmt.Sprintf("%s/%d", number, i)), id, m.p.id, m.qty, m.unitCost, m.value, date, date)
+		for _, c := range m.consume {
+			b.movement(m.p.id, from.id, c.layer.id, "out", c.qty, c.layer.unitCost, c.cost, "adjustment", "transfer_out", id,
+				"", number, date)
+		}
+		b.receive(m.p, to, m.qty, m.unitCost, date, receipt{
+			key: number, layerSource: "transfer_in", movementType: "adjustment", sourceType: "transfer_in", sourceID: id, reference: number,
+		})
+	}
+}
+
+type consumption struct {
+	layer *layer
+	qty   float64
+	cost  money.Amount
+}
+
+// consume draws qty from the oldest layers of a product in a warehouse.
+// The caller has checked the stock.
+func (b *builder) consume(p *product, w *warehouse, qty float64) []consumption {
+	key := p.id + "/" + w.id
+	var out []consumption
+	for _, l := range b.layers[key] {
+		if qty <= 0 {
+			break
+		}
+		if l.remaining <= 0 {
+			continue
+		}
+		take := math.Min(l.remaining, qty)
+		l.remaining = round4(l.remaining - take)
+		qty = round4(qty - take)
+		out = append(out, consumption{layer: l, qty: take, cost: money.FromFloat(take * l.unitCost)})
+	}
+	return out
+}
+
+// receipt describes stock arriving, with the source types the BFF gives
+// its layer and movement.
+type receipt struct {
+	key          string // distinguishes the layer from others of the product and warehouse
+	layerSource  string
+	movementType string
+	sourceType   string
+	sourceID     string
+	journalID    string
+	reference    string
+}
+
+// receive opens a layer and writes its 'in' movement.
+func (b *builder) receive(p *product, w *warehouse, qty, unitCost float64, date time.Time, r receipt) *layer {
+	key := p.id + "/" + w.id
+	l := &layer{
+		id: b.id("layer", r.key+"/"+key), itemID: p.id, warehouseID: w.id,
+		sourceType: r.layerSource, sourceID: r.sourceID, remaining: qty, unitCost: unitCost, created: date,
+	}
+	b.layers[key] = append(b.layers[key], l)
+	b.allLayers = append(b.allLayers, l)
+	b.movement(p.id, w.id, l.id, "in", qty, unitCost, money.FromFloat(qty*unitCost), r.movementType, r.sourceType, r.sourceID,
+		r.journalID, r.reference, date)
+	return l
+}
+
+var movementCols = []string{"id", "itemId", "warehouseId", "layerId", "direction", "quantity", "unitCost", "totalValue",
+	"movementType", "sourceType", "sourceId", "journalId", "reference", "createdAt", "updatedAt"}
+
+func (b *builder) movement(itemID, warehouseID, layerID, direction string, qty, unitCost float64, value money.Amount,
+	movementType, sourceType, sourceID, journalID, reference string, date time.Time) {
+	id := b.id("movement", fmt.Sprint(b.cf.count("inventory_movements")))
+	b.cf.add("inventory_movements", movementCols,
+		id, itemID, warehouseID, layerID, direction, round4(qty), unitCost, value, movementType, sourceType, sourceID,
+		cashflow.NullString(journalID), reference, date, date)
+}
+
+// linkReceipt sets the journal of a layer's 'in' movement, written before
+// the journal it belongs to.
+func (b *builder) linkReceipt(l *layer, journalID string) {
+	for _, row := range b.cf.byName["inventory_movements"].rows {
+		if row[3] == l.id && row[4] == "in" {
+			row[11] = cashflow.NullString(journalID)
+		}
+	}
+}
+
+// onHand returns the stock of a product in a warehouse, or of every
+// product when p is nil.
+func (b *builder) onHand(w *warehouse, p *product) float64 {
+	var qty float64
+	for _, pp := range b.products {
+		if p != nil && pp != p {
+			continue
+		}
+		for _, l := range b.layers[pp.id+"/"+w.id] {
+			qty += l.remaining
+		}
+	}
+	return round4(qty)
+}
+
+func (b *builder) branchIndex(id string) int {
+	for i, br := range b.branches {
+		if br == id {
+			return i
+		}
+	}
+	return 0
+}
+
+// journal writes a balanced journal to cashflowdb and mirrors it as an OA
+// transaction, skipping zero lines. It returns the journal id.
+func (b *builder) journal(number string, date time.Time, reference, notes string, lines ...line) string {
+	id := b.id("journal", number)
+	var total money.Amount
+	for _, l := range lines {
+		total += l.debit
+	}
+	b.cf.add("journals",
+		[]string{"id", "organizationId", "journalNumber", "journalDate", "reference", "notes", "totalDebit", "totalCredit",
+			"status", "createdAt", "updatedAt"},
+		id, b.seed.OrganizationID, number, date, reference, notes, total, total, "active", date, date)
+
+	txID := b.oaID("transaction/" + number)
+	ms := oaMillis(date)
+	data, _ := json.Marshal(map[string]string{"journalId": id, "journalNumber": number})
+	description := notes
+	if len(description) > 300 {
+		description = description[:300]
+	}
+	b.oa.add("transaction",
+		[]string{"id", "orgId", "userId", "date", "inserted", "updated", "description", "data", "deleted"},
+		txID, b.oaOrg, b.oaAuthor, ms, ms, ms, description, string(data), false)
+
+	for i, l := range lines {
+		if l.debit == 0 && l.credit == 0 {
+			continue
+		}
+		b.cf.add("journal_entries",
+			[]string{"id", "journalId", "accountId", "description", "debitAmount", "creditAmount", "createdAt", "updatedAt"},
+			b.id("entry", fmt.Sprintf("%s/%d", number, i)), id, b.accounts[l.code].ID, l.description, l.debit, l.credit, date, date)
+		amount := (l.debit - l.credit).Minor(precision)
+		b.oa.add("split",
+			[]string{"transactionId", "accountId", "date", "inserted", "updated", "amount", "nativeAmount", "deleted"},
+			txID, b.oaAccounts[l.code], ms, ms, ms, amount, amount, false)
+	}
+	return id
+}
+
+// finish writes what is only known once the history has run: invoices with
+// their payment status, products with their stock, layers with what is
+// left of them and the transfer number sequences.
+func (b *builder) finish() {
+	for _, inv := range b.invoices {
+		status := "confirmed"
+		if inv.paid == inv.total {
+			status = "paid"
+		}
+		b.cf.add("invoices",
+			[]string{"id", "organizationId", "invoiceNumber", "customerId", "issueDate", "dueDate", "terms", "status",
+				"subtotal", "taxAmount", "totalAmount", "paidAmount", "balanceDue", "currency", "warehouse",
+				"salespersonId", "branchId", "journalId", "createdAt", "updatedAt"},
+			inv.id, b.seed.OrganizationID, inv.number, inv.customerID, inv.issued, inv.issued.AddDate(0, 0, 30), "Net 30",
+			status, inv.subtotal, inv.tax, inv.total, inv.paid, inv.total-inv.paid, currency, inv.warehouseID,
+			inv.salespersonID, inv.branchID, inv.journalID, inv.issued, inv.issued)
+	}
+
+	for _, p := range b.products {
+		var stock float64
+		for _, w := range b.warehouses {
+			stock += b.onHand(w, p)
+		}
+		b.cf.add("products",
+			[]string{"id", "organizationId", "name", "sku", "type", "unit", "sellingPrice", "costPrice", "currency",
+				"salesAccountId", "purchaseAccountId", "inventoryAccountId", "trackInventory", "currentStock",
+				"lowStockAlert", "isActive", "createdAt", "updatedAt"},
+			p.id, b.seed.OrganizationID, p.name, p.sku, "goods",
+			p.unit, p.price, p.cost, currency, b.accounts[codeSales].ID, b.accounts[codeCOGS].ID,
+			b.accounts[codeInventory].ID, true, stock, 10, true, b.opened, b.opened)
+	}
+
+	for _, l := range b.allLayers {
+		b.cf.add("inventory_layers",
+			[]string{"id", "itemId", "warehouseId", "quantityRemaining", "unitCost", "sourceType", "sourceId", "createdAt", "updatedAt"},
+			l.id, l.itemID, l.warehouseID, l.remaining, l.unitCost, l.sourceType, l.sourceID, l.created, l.created)
+	}
+
+	years := make([]int, 0, len(b.transfers))
+	for y := range b.transfers {
+		years = append(years, y)
+	}
+	sort.Ints(years)
+	for _, y := range years {
+		b.cf.add("transfer_number_sequence", []string{"organizationId", "year", "lastNumber", "createdAt", "updatedAt"},
+			b.seed.OrganizationID, y, b.transfers[y], b.seed.Through, b.seed.Through)
+	}
+}
+
+func oaMillis(t time.Time) int64 { return t.UnixMilli() }
+
+func round4(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
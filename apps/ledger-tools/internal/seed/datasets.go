@@ -0,0 +1,249 @@
+package seed
+
+// profile is what a dataset contains. Counts are targets: sales are
+// trimmed to the stock on hand rather than driving inventory negative.
+type profile struct {
+	prefix   string // document number prefix; numbers are unique across organizations
+	orgName  string
+	slug     string
+	branches []branchSpec
+	users    []userSpec
+
+	products  int
+	customers int
+	vendors   int
+
+	openingCash  float64 // opening bank balance, against Opening Balance Equity
+	openingStock int     // units of each product in each primary warehouse; 0 for none
+
+	months           int // months of history up to the through date
+	invoicesPerMonth int
+	paidShare        float64 // share of invoices paid by the through date
+	transfers        int     // completed transfers between warehouses
+	expenses         bool    // monthly rent, salaries and utilities
+	purchases        bool    // monthly restocking from vendors, paid on account
+}
+
+type branchSpec struct {
+	name       string
+	city       string
+	warehouses []warehouseSpec // the first is the branch's primary warehouse
+}
+
+type warehouseSpec struct {
+	name string
+	code string
+	kind string
+}
+
+type userSpec struct {
+	local string // email local part
+	name  string
+	role  string
+}
+
+// warehouses has a unique (branchId, isPrimary) key, so a branch holds at
+// most one primary and one secondary warehouse.
+var datasets = map[string]*Dataset{
+	"minimal": {
+		Name:        "minimal",
+		Description: "one branch and warehouse, chart, taxes and a few contacts; no transactions",
+		profile: profile{
+			prefix:  "MIN",
+			orgName: "Minimal Trading",
+			slug:    "seed-minimal",
+			branches: []branchSpec{
+				{name: "Head Office", city: "Yangon", warehouses: []warehouseSpec{{"Main Warehouse", "MAIN", "standard"}}},
+			},
+			users:     []userSpec{{"owner", "Minimal Owner", "owner"}},
+			products:  3,
+			customers: 2,
+			vendors:   1,
+		},
+	},
+	"demo-retail": {
+		Name:        "demo-retail",
+		Description: "a single-shop retailer with three months of invoices and payments",
+		profile: profile{
+			prefix:  "DR",
+			orgName: "Demo Retail Co.",
+			slug:    "seed-demo-retail",
+			branches: []branchSpec{
+				{name: "Head Office", city: "Yangon", warehouses: []warehouseSpec{{"Main Warehouse", "MAIN", "standard"}}},
+			},
+			users: []userSpec{
+				{"owner", "Demo Owner", "owner"},
+				{"accountant", "Demo Accountant", "admin"},
+				{"viewer", "Demo Viewer", "member"},
+			},
+			products:         16,
+			customers:        12,
+			vendors:          5,
+			openingCash:      20000000,
+			openingStock:     60,
+			months:           3,
+			invoicesPerMonth: 25,
+			paidShare:        0.7,
+		},
+	},
+	"multi-branch-inventory": {
+		Name:        "multi-branch-inventory",
+		Description: "three branches and five warehouses with stock transfers and a month of sales",
+		profile: profile{
+			prefix:  "MB",
+			orgName: "Multi Branch Distribution",
+			slug:    "seed-multi-branch-inventory",
+			branches: []branchSpec{
+				{name: "Head Office", city: "Yangon", warehouses: []warehouseSpec{
+					{"Main Warehouse", "YGN-MAIN", "standard"},
+					{"Cold Storage Warehouse", "YGN-COLD", "cold_storage"},
+				}},
+				{name: "Branch Office", city: "Mandalay", warehouses: []warehouseSpec{
+					{"Mandalay Warehouse", "MDY-MAIN", "standard"},
+					{"Distribution Center", "MDY-DIST", "distribution"},
+				}},
+				{name: "Naypyitaw Office", city: "Naypyitaw", warehouses: []warehouseSpec{
+					{"Naypyitaw Warehouse", "NPT-MAIN", "standard"},
+				}},
+			},
+			users: []userSpec{
+				{"owner", "Multi Branch Owner", "owner"},
+				{"warehouse", "Warehouse Manager", "member"},
+			},
+			products:         12,
+			customers:        10,
+			vendors:          4,
+			openingCash:      50000000,
+			openingStock:     120,
+			months:           1,
+			invoicesPerMonth: 30,
+			paidShare:        0.5,
+			transfers:        10,
+		},
+	},
+	"year-of-history": {
+		Name:        "year-of-history",
+		Description: "twelve months of sales, purchases, payments and expenses for reports and closing",
+		profile: profile{
+			prefix:  "YH",
+			orgName: "Year of History Ltd.",
+			slug:    "seed-year-of-history",
+			branches: []branchSpec{
+				{name: "Head Office", city: "Yangon", warehouses: []warehouseSpec{{"Main Warehouse", "MAIN", "standard"}}},
+				{name: "Branch Office", city: "Mandalay", warehouses: []warehouseSpec{{"Mandalay Warehouse", "MDY", "standard"}}},
+			},
+			users: []userSpec{
+				{"owner", "History Owner", "owner"},
+				{"accountant", "History Accountant", "admin"},
+			},
+			products:         14,
+			customers:        20,
+			vendors:          6,
+			openingCash:      30000000,
+			openingStock:     80,
+			months:           12,
+			invoicesPerMonth: 30,
+			paidShare:        0.85,
+			transfers:        6,
+			expenses:         true,
+			purchases:        true,
+		},
+	},
+}
+
+// chart is the BFF's default chart of accounts (apps/bff/prisma/seed.js)
+// plus the Opening Balance Equity account seed-inventory.js adds.
+var chart = []struct{ code, name, typ string }{
+	{"1000", "Business Bank Account", "bank"},
+	{"1100", "Accounts Receivable", "accounts_receivable"},
+	{"1200", "Input VAT Receivable", "input_tax"},
+	{"1500", "Property, Plant, Equipment", "fixed_asset"},
+	{"1800", "Inventory", "stock"},
+	{"2000", "Accounts Payable", "accounts_payable"},
+	{"2100", "Output VAT Payable", "output_tax"},
+	{"2200", "Loans Payable", "other_current_liability"},
+	{"3000", "Retained Earnings", "equity"},
+	{"3900", "Opening Balance Equity", "equity"},
+	{"4000", "Sales Revenue", "income"},
+	{"4100", "Shipping Revenue", "income"},
+	{"5000", "Cost of Goods Sold", "cost_of_goods_sold"},
+	{"5100", "Depreciation Expense", "expense"},
+	{"5200", "FX Bank Revaluation (Gains)/Loss", "expense"},
+	{"5210", "FX Realized Currency (Gains)/Loss", "expense"},
+	{"5220", "FX Rounding (Gains)/Loss", "expense"},
+	{"5230", "FX Unrealized Currency (Gains)/Loss", "expense"},
+	{"5300", "Income Tax Expense", "expense"},
+	{"5400", "Rent Expense", "expense"},
+	{"5410", "Repair & Maintenance Expense", "expense"},
+	{"5500", "Salary & Payroll Expense", "expense"},
+	{"5600", "Selling, General & Administrative Expense", "expense"},
+	{"5700", "Shipping Expense", "expense"},
+	{"5710", "Transaction Fees & Charges", "expense"},
+	{"5800", "Utility Expense", "expense"},
+}
+
+// Account codes the generated documents post to.
+const (
+	codeBank      = "1000"
+	codeAR        = "1100"
+	codeInventory = "1800"
+	codeAP        = "2000"
+	codeOutputVAT = "2100"
+	codeOpening   = "3900"
+	codeSales     = "4000"
+	codeCOGS      = "5000"
+	codeRent      = "5400"
+	codeSalary    = "5500"
+	codeUtility   = "5800"
+)
+
+// taxes are the BFF's default taxes; invoices charge the first.
+var taxes = []struct {
+	name     string
+	rate     float64
+	typ      string
+	compound bool
+}{
+	{"Commercial", 5, "vat", false},
+	{"Commercial (Compound tax)", 5, "vat", true},
+	{"Income tax", 2, "income", false},
+	{"Myanmar", 7, "vat", true},
+}
+
+// catalog is the product list datasets draw from, in order.
+var catalog = []struct {
+	name, category, unit string
+	price, cost          float64
+}{
+	{"Premium Rice 5kg", "Groceries", "bag", 18500, 14200},
+	{"Cooking Oil 1L", "Groceries", "bottle", 7800, 6100},
+	{"Instant Noodles (Box)", "Groceries", "box", 12000, 9300},
+	{"Green Tea Leaves 250g", "Beverages", "pack", 4500, 3100},
+	{"Coffee Mix 30s", "Beverages", "pack", 9800, 7400},
+	{"Bottled Water 24x500ml", "Beverages", "case", 6500, 4800},
+	{"Laundry Detergent 2kg", "Household", "bag", 11500, 8600},
+	{"Dish Soap 750ml", "Household", "bottle", 3200, 2300},
+	{"Toilet Paper 10 Rolls", "Household", "pack", 8900, 6700},
+	{"Toothpaste 150g", "Personal Care", "tube", 2800, 1900},
+	{"Shampoo 400ml", "Personal Care", "bottle", 7400, 5200},
+	{"Bath Soap 3-Pack", "Personal Care", "pack", 3600, 2500},
+	{"LED Bulb 12W", "Electrical", "piece", 5500, 3800},
+	{"Extension Cord 5m", "Electrical", "piece", 14500, 10900},
+	{"AA Batteries 4-Pack", "Electrical", "pack", 3900, 2700},
+	{"Notebook A4 (5-Pack)", "Stationery", "pack", 6200, 4400},
+	{"Ballpoint Pens (12)", "Stationery", "box", 3400, 2200},
+	{"Plastic Storage Box", "Household", "piece", 9900, 7100},
+}
+
+var customerNames = []string{
+	"Golden Lotus Mart", "Shwe Myint Mo Store", "Aung Mingalar Trading", "Thiri Retail",
+	"Yadanar Supermarket", "Hninsi Mini Mart", "Ocean Breeze Cafe", "Pyae Phyo Enterprises",
+	"Royal Garden Hotel", "Sein Lan So Pyay", "Bagan Traders", "Inle Fresh Foods",
+	"Myitta Grocery", "Zabu Thiri Co.", "Kyaw Family Store", "Mandalay Corner Shop",
+	"Taw Win Wholesale", "Shwe Taung Market", "Ayeyar Distributors", "Nay Pyi Taw Canteen",
+}
+
+var vendorNames = []string{
+	"Myanmar Food Supply Co.", "Golden Harvest Wholesale", "Asia Household Products",
+	"Yangon Electrical Supplies", "Mandalay Paper & Stationery", "Pan Asia Beverages",
+}
@@ -0,0 +1,187 @@
+package seed
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/ledgerlock"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+)
+
+// cashflowChildren are the tables scoped to an organization only through
+// their parent.
+var cashflowChildren = []struct{ table, where string }{
+	{"journal_entries", "journalId IN (SELECT id FROM journals WHERE organizationId = ?)"},
+	{"invoice_items", "invoiceId IN (SELECT id FROM invoices WHERE organizationId = ?)"},
+	{"invoice_payments", "invoiceId IN (SELECT id FROM invoices WHERE organizationId = ?)"},
+	{"inventory_transfer_items", "transferId IN (SELECT id FROM inventory_transfers WHERE organizationId = ?)"},
+	{"inventory_movements", "warehouseId IN (SELECT id FROM warehouses WHERE organizationId = ?)"},
+	{"inventory_layers", "warehouseId IN (SELECT id FROM warehouses WHERE organizationId = ?)"},
+	{"inventory_opening_balances", "warehouseId IN (SELECT id FROM warehouses WHERE organizationId = ?)"},
+	{"warehouse_permissions", "warehouseId IN (SELECT id FROM warehouses WHERE organizationId = ?)"},
+}
+
+// cashflowUserTables hold rows keyed by userId.
+var cashflowUserTables = []string{"sessions", "accounts", "user_preferences", "organization_members", "warehouse_permissions"}
+
+// resetCashflow deletes an organization and every row scoped to it,
+// including the ledger tools' own tables, then the given users. Any table
+// with an organizationId or organization_id column counts, so rows created
+// by hand in a dev database go too.
+func resetCashflow(ctx context.Context, tx *sql.Tx, organizationID string, userIDs []string) error {
+	scoped, err := orgScopedTables(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, c := range cashflowChildren {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM `"+c.table+"` WHERE "+c.where, organizationID); err != nil {
+			return fmt.Errorf("clear %s: %w", c.table, err)
+		}
+	}
+	for _, t := range scoped {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM `"+t[0]+"` WHERE `"+t[1]+"` = ?", organizationID); err != nil {
+			return fmt.Errorf("clear %s: %w", t[0], err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM organizations WHERE id = ?`, organizationID); err != nil {
+		return fmt.Errorf("clear organizations: %w", err)
+	}
+
+	if len(userIDs) == 0 {
+		return nil
+	}
+	in, args := placeholders(userIDs)
+	for _, t := range cashflowUserTables {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM `"+t+"` WHERE userId IN "+in, args...); err != nil {
+			return fmt.Errorf("clear %s: %w", t, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id IN "+in, args...); err != nil {
+		return fmt.Errorf("clear users: %w", err)
+	}
+	return nil
+}
+
+// orgScopedTables returns [table, column] for every base table with an
+// organization column, other than organizations itself.
+func orgScopedTables(ctx context.Context, tx *sql.Tx) ([][2]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT c.TABLE_NAME, c.COLUMN_NAME FROM INFORMATION_SCHEMA.COLUMNS c
+		JOIN INFORMATION_SCHEMA.TABLES t ON t.TABLE_SCHEMA = c.TABLE_SCHEMA AND t.TABLE_NAME = c.TABLE_NAME
+		WHERE c.TABLE_SCHEMA = DATABASE() AND t.TABLE_TYPE = 'BASE TABLE'
+		  AND c.COLUMN_NAME IN ('organizationId', 'organization_id') AND c.TABLE_NAME <> 'organizations'
+		ORDER BY c.TABLE_NAME`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out [][2]string
+	for rows.Next() {
+		var t [2]string
+		if err := rows.Scan(&t[0], &t[1]); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// resetOA deletes an OA org with its accounts, transactions and
+// memberships, then the given users.
+func resetOA(ctx context.Context, tx *sql.Tx, orgID string, userIDs []string) error {
+	org := oa.NormalizeID(orgID)
+	stmts := []string{
+		`DELETE FROM split WHERE transactionId IN (SELECT id FROM transaction WHERE orgId = UNHEX(?))`,
+		`DELETE FROM transaction WHERE orgId = UNHEX(?)`,
+		`DELETE FROM balance WHERE accountId IN (SELECT id FROM account WHERE orgId = UNHEX(?))`,
+		`DELETE FROM budgetitem WHERE orgId = UNHEX(?)`,
+		`DELETE FROM permission WHERE orgId = UNHEX(?)`,
+		`DELETE FROM price WHERE orgId = UNHEX(?)`,
+		`DELETE FROM invite WHERE orgId = UNHEX(?)`,
+		`DELETE FROM account WHERE orgId = UNHEX(?)`,
+		`DELETE FROM token WHERE userOrgId IN (SELECT id FROM userorg WHERE orgId = UNHEX(?))`,
+		`DELETE FROM userorg WHERE orgId = UNHEX(?)`,
+		`DELETE FROM org WHERE id = UNHEX(?)`,
+	}
+	for _, q := range stmts {
+		if _, err := tx.ExecContext(ctx, q, org); err != nil {
+			return err
+		}
+	}
+
+	if len(userIDs) == 0 {
+		return nil
+	}
+	ids := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		ids[i] = oa.NormalizeID(id)
+	}
+	in, args := placeholders(ids)
+	in = strings.ReplaceAll(in, "?", "UNHEX(?)")
+	for _, q := range []string{
+		`DELETE FROM token WHERE userOrgId IN (SELECT id FROM userorg WHERE userId IN ` + in + `)`,
+		`DELETE FROM userorg WHERE userId IN ` + in,
+		`DELETE FROM session WHERE userId IN ` + in,
+		`DELETE FROM apikey WHERE userId IN ` + in,
+		"DELETE FROM `user` WHERE id IN " + in,
+	} {
+		if _, err := tx.ExecContext(ctx, q, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withoutForeignKeys runs fn in a transaction with foreign key checks off,
+// so rows can be removed and written table by table.
+func withoutForeignKeys(ctx context.Context, conn *sql.DB, fn func(*sql.Tx) error) error {
+	c, err := conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, err := c.ExecContext(ctx, `SET FOREIGN_KEY_CHECKS = 0`); err != nil {
+		return err
+	}
+	defer c.ExecContext(context.Background(), `SET FOREIGN_KEY_CHECKS = 1`)
+
+	tx, err := c.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeCashflow runs resetCashflow through the ledger lock bypass with
+// foreign key checks off: a seeded organization's journals are live, so
+// the ledger lock triggers would refuse to delete them, and its lock dates
+// and closed periods guard everything dated before them. The bypass log
+// records what was removed.
+func removeCashflow(ctx context.Context, conn *sql.DB, organizationID string, userIDs []string) error {
+	reason := "seed: remove organization " + organizationID
+	return ledgerlock.Bypass(ctx, conn, reason, func(tx *sql.Tx) (err error) {
+		if _, err := tx.ExecContext(ctx, `SET FOREIGN_KEY_CHECKS = 0`); err != nil {
+			return err
+		}
+		defer func() {
+			if _, resetErr := tx.ExecContext(context.Background(), `SET FOREIGN_KEY_CHECKS = 1`); err == nil {
+				err = resetErr
+			}
+		}()
+		return resetCashflow(ctx, tx, organizationID, userIDs)
+	})
+}
+
+func placeholders(values []string) (string, []any) {
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ") + ")", args
+}
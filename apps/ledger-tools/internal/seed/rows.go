@@ -0,0 +1,80 @@
+package seed
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// batchSize bounds the rows of one multi-row INSERT.
+const batchSize = 500
+
+// table collects the rows of one table in insertion order.
+type table struct {
+	name    string
+	columns []string
+	rows    [][]any
+}
+
+// rowSet is every row a dataset writes to one database, by table, in the
+// order the tables were first used.
+type rowSet struct {
+	tables []*table
+	byName map[string]*table
+}
+
+func newRowSet() *rowSet {
+	return &rowSet{byName: map[string]*table{}}
+}
+
+// add appends a row. Every row of a table must list the same columns in the
+// same order.
+func (s *rowSet) add(name string, columns []string, values ...any) {
+	t := s.byName[name]
+	if t == nil {
+		t = &table{name: name, columns: columns}
+		s.tables = append(s.tables, t)
+		s.byName[name] = t
+	}
+	if len(values) != len(t.columns) {
+		panic(fmt.Sprintf("seed: %s row has %d values for %d columns", name, len(values), len(t.columns)))
+	}
+	t.rows = append(t.rows, values)
+}
+
+// count returns the number of rows for a table.
+func (s *rowSet) count(name string) int {
+	if t := s.byName[name]; t != nil {
+		return len(t.rows)
+	}
+	return 0
+}
+
+// insert writes every table with multi-row INSERTs.
+func (s *rowSet) insert(ctx context.Context, tx *sql.Tx) error {
+	for _, t := range s.tables {
+		cols := make([]string, len(t.columns))
+		for i, c := range t.columns {
+			cols[i] = "`" + c + "`"
+		}
+		row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(t.columns)), ", ") + ")"
+		for start := 0; start < len(t.rows); start += batchSize {
+			end := start + batchSize
+			if end > len(t.rows) {
+				end = len(t.rows)
+			}
+			values := make([]string, 0, end-start)
+			args := make([]any, 0, (end-start)*len(t.columns))
+			for _, r := range t.rows[start:end] {
+				values = append(values, row)
+				args = append(args, r...)
+			}
+			q := "INSERT INTO `" + t.name + "` (" + strings.Join(cols, ", ") + ") VALUES " + strings.Join(values, ", ")
+			if _, err := tx.ExecContext(ctx, q, args...); err != nil {
+				return fmt.Errorf("insert %s: %w", t.name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// Package seed builds named, deterministic development datasets across
+// cashflowdb and the OA database, replacing the BFF's overlapping JS seed
+// scripts. Every id is derived from the dataset name and a key, and every
+// date from the dataset's through date, so a dataset is the same rows each
+// time it is built. Applying one first removes everything belonging to its
+// organization in both databases, which makes reseeding idempotent.
+//
+// The two databases are linked the way the BFF links them:
+// organizations.oaOrganizationId is the OA org, each ledger account has an
+// OA account of the same name under its class, users share their email,
+// and each journal is mirrored by an OA transaction whose data names it.
+package seed
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/db"
+)
+
+// Dataset is a named seed.
+type Dataset struct {
+	Name        string
+	Description string
+	profile     profile
+}
+
+// Datasets lists the available datasets by name.
+func Datasets() []*Dataset {
+	out := make([]*Dataset, 0, len(datasets))
+	for _, d := range datasets {
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Get returns a dataset by name.
+func Get(name string) (*Dataset, error) {
+	d, ok := datasets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown dataset %q", name)
+	}
+	return d, nil
+}
+
+// Seed is a built dataset: the rows for both databases and the ids that
+// link them.
+type Seed struct {
+	Dataset *Dataset
+	Through time.Time
+
+	OrganizationID string // cashflowdb organizations.id
+	OAOrgID        string
+	Users          []*User
+	Accounts       []*Account
+
+	cashflow *rowSet
+	oa       *rowSet
+}
+
+// User is a seeded user in both databases.
+type User struct {
+	Email string
+	Role  string
+	ID    string // cashflowdb users.id
+	OAID  string
+}
+
+// Account is a seeded ledger account and its OA account.
+type Account struct {
+	Code string
+	Name string
+	Type string
+	ID   string // cashflowdb ledger_accounts.id
+	OAID string
+}
+
+// Counts returns the number of rows per table, prefixed with the database.
+func (s *Seed) Counts() map[string]int {
+	out := map[string]int{}
+	for _, t := range s.cashflow.tables {
+		out["cashflow."+t.name] = len(t.rows)
+	}
+	for _, t := range s.oa.tables {
+		out["oa."+t.name] = len(t.rows)
+	}
+	return out
+}
+
+// Build generates the dataset with history ending on through.
+func (d *Dataset) Build(through time.Time) *Seed {
+	b := newBuilder(d, through)
+	b.build()
+	return b.seed
+}
+
+// Apply replaces the dataset's organization in both databases: whatever is
+// there is removed first, then OA is written, since the cashflow
+// organization points at it, then cashflowdb, each in one transaction.
+func (s *Seed) Apply(ctx context.Context, cf, oadb *sql.DB) error {
+	if err := s.Remove(ctx, cf, oadb); err != nil {
+		return err
+	}
+	err := db.InTx(ctx, oadb, func(tx *sql.Tx) error {
+		return s.oa.insert(ctx, tx)
+	})
+	if err != nil {
+		return fmt.Errorf("OA: %w", err)
+	}
+	err = withoutForeignKeys(ctx, cf, func(tx *sql.Tx) error {
+		return s.cashflow.insert(ctx, tx)
+	})
+	if err != nil {
+		return fmt.Errorf("cashflow: %w", err)
+	}
+	return nil
+}
+
+// Remove deletes the dataset's organization, everything scoped to it and
+// its users from both databases. cashflowdb goes first, through the ledger
+// lock bypass, so that its lock dates no longer guard the OA rows being
+// removed.
+func (s *Seed) Remove(ctx context.Context, cf, oadb *sql.DB) error {
+	err := removeCashflow(ctx, cf, s.OrganizationID, s.userIDs())
+	if err != nil {
+		return fmt.Errorf("cashflow: %w", err)
+	}
+	err = db.InTx(ctx, oadb, func(tx *sql.Tx) error {
+		return resetOA(ctx, tx, s.OAOrgID, s.userOAIDs())
+	})
+	if err != nil {
+		return fmt.Errorf("OA: %w", err)
+	}
+	return nil
+}
+
+func (s *Seed) userIDs() []string {
+	ids := make([]string, len(s.Users))
+	for i, u := range s.Users {
+		ids[i] = u.ID
+	}
+	return ids
+}
+
+func (s *Seed) userOAIDs() []string {
+	ids := make([]string, len(s.Users))
+	for i, u := range s.Users {
+		ids[i] = u.OAID
+	}
+	return ids
+}
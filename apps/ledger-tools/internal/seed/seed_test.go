@@ -0,0 +1,191 @@
+package seed
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+var through = time.Date(2025, 6, 15, 0, 0, 0, 0, time.UTC)
+
+// rows returns a table's rows keyed by column name.
+func rows(s *rowSet, name string) []map[string]any {
+	t := s.byName[name]
+	if t == nil {
+		return nil
+	}
+	out := make([]map[string]any, len(t.rows))
+	for i, r := range t.rows {
+		m := map[string]any{}
+		for j, c := range t.columns {
+			m[c] = r[j]
+		}
+		out[i] = m
+	}
+	return out
+}
+
+func TestBuildDeterministic(t *testing.T) {
+	for _, d := range Datasets() {
+		t.Run(d.Name, func(t *testing.T) {
+			a, b := d.Build(through), d.Build(through)
+			if !reflect.DeepEqual(a.cashflow, b.cashflow) || !reflect.DeepEqual(a.oa, b.oa) {
+				t.Fatalf("two builds of %s differ", d.Name)
+			}
+			if later := d.Build(through.AddDate(0, 0, 1)); later.OrganizationID != a.OrganizationID {
+				t.Errorf("organization id depends on the through date")
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	orgs := map[string]string{}
+	for _, d := range Datasets() {
+		t.Run(d.Name, func(t *testing.T) {
+			s := d.Build(through)
+			if other, ok := orgs[s.OrganizationID]; ok {
+				t.Errorf("organization id shared with %s", other)
+			}
+			orgs[s.OrganizationID] = d.Name
+
+			// Every cashflow row id is unique within its table.
+			for _, tbl := range s.cashflow.tables {
+				seen := map[any]bool{}
+				for _, r := range rows(s.cashflow, tbl.name) {
+					id, ok := r["id"]
+					if !ok {
+						break
+					}
+					if seen[id] {
+						t.Errorf("%s: duplicate id %v", tbl.name, id)
+					}
+					seen[id] = true
+				}
+			}
+
+			// Journals balance, match their header totals and mirror to OA
+			// transactions whose splits sum to zero.
+			debits, credits := map[any]money.Amount{}, map[any]money.Amount{}
+			for _, e := range rows(s.cashflow, "journal_entries") {
+				debits[e["journalId"]] += e["debitAmount"].(money.Amount)
+				credits[e["journalId"]] += e["creditAmount"].(money.Amount)
+			}
+			journals := rows(s.cashflow, "journals")
+			for _, j := range journals {
+				id := j["id"]
+				if debits[id] != credits[id] || debits[id] != j["totalDebit"].(money.Amount) {
+					t.Errorf("journal %v: debits %s, credits %s, header %s", j["journalNumber"], debits[id], credits[id], j["totalDebit"])
+				}
+			}
+			txs := rows(s.oa, "transaction")
+			if len(txs) != len(journals) {
+				t.Errorf("%d OA transactions for %d journals", len(txs), len(journals))
+			}
+			sums := map[string]int64{}
+			for _, sp := range rows(s.oa, "split") {
+				sums[string(sp["transactionId"].([]byte))] += sp["amount"].(int64)
+			}
+			for tx, sum := range sums {
+				if sum != 0 {
+					t.Errorf("OA transaction %x: splits sum to %d", tx, sum)
+				}
+			}
+
+			// Stock never goes negative and the products' stock is what the
+			// layers hold.
+			held := map[any]float64{}
+			for _, l := range rows(s.cashflow, "inventory_layers") {
+				q := l["quantityRemaining"].(float64)
+				if q < 0 {
+					t.Errorf("layer %v: %v remaining", l["id"], q)
+				}
+				held[l["itemId"]] += q
+			}
+			for _, p := range rows(s.cashflow, "products") {
+				if got := round4(held[p["id"]]); got != p["currentStock"].(float64) {
+					t.Errorf("product %v: stock %v, layers hold %v", p["sku"], p["currentStock"], got)
+				}
+			}
+
+			// Nothing is dated after the through date.
+			for _, j := range journals {
+				if j["journalDate"].(time.Time).After(s.Through) {
+					t.Errorf("journal %v dated %v", j["journalNumber"], j["journalDate"])
+				}
+			}
+		})
+	}
+}
+
+func TestBuildMinimal(t *testing.T) {
+	d, err := Get("minimal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := d.Build(through)
+	counts := s.Counts()
+	for _, name := range []string{"cashflow.journals", "cashflow.invoices", "oa.transaction"} {
+		if counts[name] != 0 {
+			t.Errorf("%s = %d, want none", name, counts[name])
+		}
+	}
+	if counts["cashflow.ledger_accounts"] != len(chart) || counts["cashflow.products"] != 3 || len(s.Users) != 1 {
+		t.Errorf("counts = %v, users %d", counts, len(s.Users))
+	}
+	if _, err := Get("nope"); err == nil {
+		t.Errorf("Get of an unknown dataset succeeded")
+	}
+}
+
+func TestRowSet(t *testing.T) {
+	s := newRowSet()
+	s.add("b", []string{"id"}, 1)
+	s.add("a", []string{"id", "name"}, 1, "x")
+	s.add("b", []string{"id"}, 2)
+	if s.count("b") != 2 || s.count("a") != 1 || s.count("c") != 0 {
+		t.Errorf("counts b=%d a=%d c=%d", s.count("b"), s.count("a"), s.count("c"))
+	}
+	if s.tables[0].name != "b" || s.tables[1].name != "a" {
+		t.Errorf("tables are not in first-use order")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("a row with the wrong number of values was accepted")
+		}
+	}()
+	s.add("a", []string{"id", "name"}, 2)
+}
+
+func TestPlaceholders(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{in: []string{"a"}, want: "(?)"},
+		{in: []string{"a", "b", "c"}, want: "(?, ?, ?)"},
+	}
+	for _, tt := range tests {
+		got, args := placeholders(tt.in)
+		if got != tt.want || len(args) != len(tt.in) {
+			t.Errorf("placeholders(%v) = %q, %v", tt.in, got, args)
+		}
+	}
+}
+
+func TestIDs(t *testing.T) {
+	a := newBuilder(datasets["minimal"], through)
+	b := newBuilder(datasets["demo-retail"], through)
+	if a.id("user", "owner") == b.id("user", "owner") || bytes.Equal(a.oaID("org"), b.oaID("org")) {
+		t.Errorf("ids are shared between datasets")
+	}
+	if got := a.id("user", "owner"); got != a.id("user", "owner") || got[:10] != "user_seed_" {
+		t.Errorf("id = %q", got)
+	}
+	if n1, n2 := a.number("INV"), a.number("INV"); n1 != "INV-MIN-00001" || n2 != "INV-MIN-00002" {
+		t.Errorf("numbers = %s, %s", n1, n2)
+	}
+}
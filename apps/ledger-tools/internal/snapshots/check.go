@@ -0,0 +1,102 @@
+package snapshots
+
+import (
+	"context"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+)
+
+// Mismatch kinds.
+const (
+	MismatchMovement = "movement" // a day's movement differs from its splits
+	MismatchMissing  = "missing"  // splits move a day that has no snapshot
+	MismatchRunning  = "running"  // balance is not the previous balance plus movement
+)
+
+// Mismatch is one account-day where the snapshots and the splits disagree.
+type Mismatch struct {
+	AccountID string `json:"accountId"`
+	Day       string `json:"day"`
+	Kind      string `json:"kind"`
+	Expected  int64  `json:"expected"`
+	Actual    int64  `json:"actual"`
+}
+
+// Report is the outcome of a consistency check.
+type Report struct {
+	OrganizationID string     `json:"organizationId"`
+	CheckedAt      time.Time  `json:"checkedAt"`
+	Watermark      int64      `json:"watermark"`
+	Splits         int        `json:"splits"`
+	Accounts       int        `json:"accounts"`
+	Days           int        `json:"days"`
+	Mismatches     []Mismatch `json:"mismatches"`
+}
+
+// Check recounts an organization's live splits and compares every stored
+// day with them, and every running balance with the one before it. Splits
+// changed since the last sync show up as mismatches, so sync first.
+func Check(ctx context.Context, cf, oadb cashflow.Querier, organizationID string, now time.Time) (*Report, error) {
+	state, err := GetState(ctx, cf, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	_, loc, err := orgOf(ctx, cf, oadb, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	expected, n, err := countAll(ctx, oadb, state.OAOrgID, loc)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{OrganizationID: organizationID, CheckedAt: now, Watermark: state.Watermark, Splits: n, Mismatches: []Mismatch{}}
+
+	rows, err := cf.QueryContext(ctx, `
+		SELECT account_id, DATE_FORMAT(day, '%Y-%m-%d'), movement, balance
+		FROM balance_snapshots WHERE organization_id = ?
+		ORDER BY account_id, day`, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := map[string]map[string]bool{}
+	var account string
+	var running int64
+	for rows.Next() {
+		var id, day string
+		var movement, balance int64
+		if err := rows.Scan(&id, &day, &movement, &balance); err != nil {
+			return nil, err
+		}
+		if id != account {
+			account, running = id, 0
+			seen[id] = map[string]bool{}
+			r.Accounts++
+		}
+		seen[id][day] = true
+		r.Days++
+		if want := expected[id][day]; want != movement {
+			r.Mismatches = append(r.Mismatches, Mismatch{AccountID: id, Day: day, Kind: MismatchMovement, Expected: want, Actual: movement})
+		}
+		running += movement
+		if running != balance {
+			r.Mismatches = append(r.Mismatches, Mismatch{AccountID: id, Day: day, Kind: MismatchRunning, Expected: running, Actual: balance})
+			running = balance
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range sortedKeys(expected) {
+		days := expected[id]
+		for _, day := range sortedKeys(days) {
+			if days[day] != 0 && !seen[id][day] {
+				r.Mismatches = append(r.Mismatches, Mismatch{AccountID: id, Day: day, Kind: MismatchMissing, Expected: days[day]})
+			}
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,193 @@
+package snapshots
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+)
+
+// Service keeps snapshots current and serves them over HTTP.
+type Service struct {
+	DB *sql.DB // cashflowdb
+	OA *sql.DB
+	// Organizations to keep; every organization linked to an OA org when
+	// empty.
+	Organizations []string
+	Interval      time.Duration
+	Logger        *log.Logger
+
+	wake chan string
+}
+
+// Run syncs every organization each Interval, and an organization at once
+// when Notify is called for it, until ctx is cancelled.
+func (s *Service) Run(ctx context.Context) error {
+	s.init()
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		s.SyncAll(ctx)
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+				waiting = false
+			case id := <-s.wake:
+				s.sync(ctx, id)
+			}
+		}
+	}
+}
+
+// SyncAll syncs every organization once.
+func (s *Service) SyncAll(ctx context.Context) {
+	ids := s.Organizations
+	if len(ids) == 0 {
+		var err error
+		if ids, err = Organizations(ctx, s.DB); err != nil {
+			s.Logger.Printf("organizations: %v", err)
+			return
+		}
+	}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+		s.sync(ctx, id)
+	}
+}
+
+// Notify asks Run to sync an organization without waiting for the next
+// interval, for change-data-capture feeds. Notifications beyond a backlog
+// are dropped; the interval sync picks their changes up.
+func (s *Service) Notify(organizationID string) bool {
+	s.init()
+	select {
+	case s.wake <- organizationID:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Service) init() {
+	if s.wake == nil {
+		s.wake = make(chan string, 64)
+	}
+}
+
+func (s *Service) sync(ctx context.Context, organizationID string) {
+	res, err := Sync(ctx, s.DB, s.OA, organizationID, time.Now())
+	if err != nil {
+		s.Logger.Printf("%s: %v", organizationID, err)
+		return
+	}
+	if res.Rebuilt {
+		s.Logger.Printf("%s: rebuilt %d account-days from %d splits", organizationID, res.Days, res.Splits)
+	} else if res.Changed > 0 {
+		s.Logger.Printf("%s: %d of %d recounted account-days changed", organizationID, res.Changed, res.Days)
+	}
+}
+
+// Handler serves
+//
+//	GET  /orgs/<organizationId>/balances?asOf=2025-09-30[&account=<oaAccountId>]
+//	GET  /orgs/<organizationId>/movements?from=2025-09-01&to=2025-09-30
+//	POST /orgs/<organizationId>/changes
+//
+// The first two read the snapshots as of the last sync; the last is the
+// change-data-capture hook and only schedules a sync.
+func (s *Service) Handler() http.Handler {
+	s.init()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/orgs/", func(w http.ResponseWriter, r *http.Request) {
+		id, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/orgs/"), "/"), "/")
+		if id == "" {
+			http.NotFound(w, r)
+			return
+		}
+		switch action {
+		case "balances", "movements":
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			s.serveRead(w, r, id, action)
+		case "changes":
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if !s.Notify(id) {
+				http.Error(w, "sync backlog is full", http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	return mux
+}
+
+func (s *Service) serveRead(w http.ResponseWriter, r *http.Request, organizationID, action string) {
+	ctx := r.Context()
+	state, err := GetState(ctx, s.DB, organizationID)
+	if errors.Is(err, cashflow.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.Logger.Printf("%s: %v", organizationID, err)
+		http.Error(w, "could not read snapshots", http.StatusInternalServerError)
+		return
+	}
+
+	q := r.URL.Query()
+	out := map[string]any{"organizationId": organizationID, "syncedAt": state.SyncedAt}
+	if action == "balances" {
+		day := q.Get("asOf")
+		if _, err := time.Parse(DayLayout, day); err != nil {
+			http.Error(w, "asOf must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		out["asOf"] = day
+		if account := q.Get("account"); account != "" {
+			account = oa.NormalizeID(account)
+			var balance int64
+			balance, err = AccountBalance(ctx, s.DB, organizationID, account, day)
+			out["balances"] = []Balance{{AccountID: account, Balance: balance}}
+		} else {
+			out["balances"], err = AsOf(ctx, s.DB, organizationID, day)
+		}
+	} else {
+		from, to := q.Get("from"), q.Get("to")
+		_, fromErr := time.Parse(DayLayout, from)
+		_, toErr := time.Parse(DayLayout, to)
+		if fromErr != nil || toErr != nil || to < from {
+			http.Error(w, "from and to must be YYYY-MM-DD, from first", http.StatusBadRequest)
+			return
+		}
+		out["from"], out["to"] = from, to
+		out["movements"], err = Movements(ctx, s.DB, organizationID, from, to)
+	}
+	if err != nil {
+		s.Logger.Printf("%s: %v", organizationID, err)
+		http.Error(w, "could not read snapshots", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
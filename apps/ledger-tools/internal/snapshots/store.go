@@ -0,0 +1,198 @@
+// Package snapshots keeps precomputed per-account, per-day running balances
+// of the OA ledger so dashboards can read a balance as of a date, or the
+// movement over a period, without summing every split.
+//
+// Each organization's rows are built once from its live splits and then
+// kept current from the split and transaction updated stamps: a sync
+// collects the days touched since its watermark, recounts those days from
+// the splits and shifts the running balances after them. Days are
+// calendar days in the OA org's timezone.
+package snapshots
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Tables are the cashflowdb tables owned by the snapshot service.
+var Tables = []schema.Table{
+	{
+		Name: "balance_snapshots",
+		Create: `CREATE TABLE IF NOT EXISTS balance_snapshots (
+  organization_id VARCHAR(191) NOT NULL,
+  account_id CHAR(32) NOT NULL,
+  day DATE NOT NULL,
+  movement BIGINT NOT NULL,
+  balance BIGINT NOT NULL,
+  PRIMARY KEY (organization_id, account_id, day)
+) ENGINE=InnoDB`,
+	},
+	{
+		Name: "balance_snapshot_state",
+		Create: `CREATE TABLE IF NOT EXISTS balance_snapshot_state (
+  organization_id VARCHAR(191) NOT NULL,
+  oa_org_id CHAR(32) NOT NULL,
+  timezone VARCHAR(100) NOT NULL,
+  watermark BIGINT NOT NULL,
+  rebuilt_at DATETIME(3) NOT NULL,
+  synced_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (organization_id)
+) ENGINE=InnoDB`,
+	},
+}
+
+// DayLayout is the form days are read and written in.
+const DayLayout = time.DateOnly
+
+// State is an organization's snapshot bookkeeping. Watermark is the OA
+// millisecond stamp changes have been applied up to.
+type State struct {
+	OrganizationID string    `json:"organizationId"`
+	OAOrgID        string    `json:"oaOrgId"`
+	Timezone       string    `json:"timezone"`
+	Watermark      int64     `json:"watermark"`
+	RebuiltAt      time.Time `json:"rebuiltAt"`
+	SyncedAt       time.Time `json:"syncedAt"`
+}
+
+// GetState loads an organization's state, or ErrNotFound before its first
+// build.
+func GetState(ctx context.Context, q cashflow.Querier, organizationID string) (*State, error) {
+	s := &State{}
+	err := q.QueryRowContext(ctx, `
+		SELECT organization_id, oa_org_id, timezone, watermark, rebuilt_at, synced_at
+		FROM balance_snapshot_state WHERE organization_id = ?`, organizationID).
+		Scan(&s.OrganizationID, &s.OAOrgID, &s.Timezone, &s.Watermark, &s.RebuiltAt, &s.SyncedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("balance snapshots of %s: %w", organizationID, cashflow.ErrNotFound)
+	}
+	return s, err
+}
+
+func saveState(ctx context.Context, q cashflow.Querier, s *State) error {
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO balance_snapshot_state (organization_id, oa_org_id, timezone, watermark, rebuilt_at, synced_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE oa_org_id = VALUES(oa_org_id), timezone = VALUES(timezone),
+		  watermark = VALUES(watermark), rebuilt_at = VALUES(rebuilt_at), synced_at = VALUES(synced_at)`,
+		s.OrganizationID, s.OAOrgID, s.Timezone, s.Watermark, s.RebuiltAt, s.SyncedAt)
+	return err
+}
+
+// Balance is an account's closing balance on a day, in minor units signed
+// like OA splits (debits positive).
+type Balance struct {
+	AccountID string `json:"accountId"`
+	Balance   int64  `json:"balance"`
+}
+
+// AccountBalance returns an account's balance at the end of day: one
+// primary key lookup for the last snapshot on or before it.
+func AccountBalance(ctx context.Context, q cashflow.Querier, organizationID, accountID, day string) (int64, error) {
+	var balance int64
+	err := q.QueryRowContext(ctx, `
+		SELECT balance FROM balance_snapshots
+		WHERE organization_id = ? AND account_id = ? AND day <= ?
+		ORDER BY day DESC LIMIT 1`, organizationID, accountID, day).Scan(&balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return balance, err
+}
+
+// AsOf returns every account's balance at the end of day, leaving out
+// accounts without splits by then. MySQL answers it with one index dive
+// per account, so its cost does not grow with the number of splits.
+func AsOf(ctx context.Context, q cashflow.Querier, organizationID, day string) ([]Balance, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT b.account_id, b.balance
+		FROM balance_snapshots b
+		JOIN (SELECT account_id, MAX(day) AS day FROM balance_snapshots
+		      WHERE organization_id = ? AND day <= ? GROUP BY account_id) l
+		  ON l.account_id = b.account_id AND l.day = b.day
+		WHERE b.organization_id = ?
+		ORDER BY b.account_id`, organizationID, day, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Balance
+	for rows.Next() {
+		var b Balance
+		if err := rows.Scan(&b.AccountID, &b.Balance); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// Movement is an account's net change over a period.
+type Movement struct {
+	AccountID string `json:"accountId"`
+	Opening   int64  `json:"opening"`
+	Movement  int64  `json:"movement"`
+	Closing   int64  `json:"closing"`
+}
+
+// Movements returns each account's movement over the days from and to,
+// both inclusive, as the difference of two AsOf reads. Accounts that did
+// not move are left out.
+func Movements(ctx context.Context, q cashflow.Querier, organizationID, from, to string) ([]Movement, error) {
+	start, err := time.Parse(DayLayout, from)
+	if err != nil {
+		return nil, fmt.Errorf("from: %w", err)
+	}
+	if to < from {
+		return nil, fmt.Errorf("period %s to %s ends before it starts", from, to)
+	}
+	opening, err := AsOf(ctx, q, organizationID, start.AddDate(0, 0, -1).Format(DayLayout))
+	if err != nil {
+		return nil, err
+	}
+	closing, err := AsOf(ctx, q, organizationID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	open := map[string]int64{}
+	for _, b := range opening {
+		open[b.AccountID] = b.Balance
+	}
+	var out []Movement
+	for _, b := range closing {
+		m := Movement{AccountID: b.AccountID, Opening: open[b.AccountID], Closing: b.Balance}
+		m.Movement = m.Closing - m.Opening
+		if m.Movement != 0 {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+// Organizations returns the cashflowdb organizations linked to an OA org.
+func Organizations(ctx context.Context, q cashflow.Querier) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id FROM organizations WHERE oaOrganizationId IS NOT NULL AND oaOrganizationId <> '' ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,363 @@
+package snapshots
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/oa"
+)
+
+// Overlap is how far before its watermark a sync looks for changes, for
+// OA writes that committed after a later stamp was read or a server clock
+// that runs behind. Recounting a day that did not change is a no-op.
+const Overlap = 5 * time.Minute
+
+// insertBatch is the number of rows per INSERT when rebuilding.
+const insertBatch = 500
+
+// forever is past the last date OA splits can carry.
+var forever = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// Result describes a sync or rebuild.
+type Result struct {
+	OrganizationID string `json:"organizationId"`
+	Rebuilt        bool   `json:"rebuilt"`
+	Splits         int    `json:"splits"` // splits read
+	Days           int    `json:"days"`   // account-days written or recounted
+	Changed        int    `json:"changed"`
+	Watermark      int64  `json:"watermark"`
+}
+
+// Sync brings an organization's snapshots up to date. Organizations
+// without snapshots, or whose OA org or timezone changed since they were
+// built, are rebuilt.
+func Sync(ctx context.Context, cf *sql.DB, oadb cashflow.Querier, organizationID string, now time.Time) (*Result, error) {
+	state, err := GetState(ctx, cf, organizationID)
+	if errors.Is(err, cashflow.ErrNotFound) {
+		return Rebuild(ctx, cf, oadb, organizationID, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+	org, loc, err := orgOf(ctx, cf, oadb, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	if org.ID != state.OAOrgID || org.Timezone != state.Timezone {
+		return Rebuild(ctx, cf, oadb, organizationID, now)
+	}
+
+	res := &Result{OrganizationID: organizationID, Watermark: oa.Millis(now)}
+	touched, err := changedDays(ctx, oadb, org.ID, loc, state.Watermark-Overlap.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]map[string]int64{}
+	for account, days := range touched {
+		c, n, err := recount(ctx, oadb, org.ID, account, days, loc)
+		if err != nil {
+			return nil, err
+		}
+		counts[account] = c
+		res.Splits += n
+		res.Days += len(days)
+	}
+
+	tx, err := cf.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var locked string
+	if err := tx.QueryRowContext(ctx, `
+		SELECT organization_id FROM balance_snapshot_state WHERE organization_id = ? FOR UPDATE`, organizationID).Scan(&locked); err != nil {
+		return nil, err
+	}
+	for _, account := range sortedKeys(counts) {
+		for _, day := range sortedKeys(touched[account]) {
+			changed, err := apply(ctx, tx, organizationID, account, day, counts[account][day])
+			if err != nil {
+				return nil, fmt.Errorf("account %s on %s: %w", account, day, err)
+			}
+			if changed {
+				res.Changed++
+			}
+		}
+	}
+	// A slower concurrent sync can write older counts after this one; it
+	// also writes its older watermark, so the next sync recounts them.
+	state.Watermark, state.SyncedAt = res.Watermark, now
+	if err := saveState(ctx, tx, state); err != nil {
+		return nil, err
+	}
+	return res, tx.Commit()
+}
+
+// Rebuild replaces an organization's snapshots with ones counted from all
+// of its live splits.
+func Rebuild(ctx context.Context, cf *sql.DB, oadb cashflow.Querier, organizationID string, now time.Time) (*Result, error) {
+	org, loc, err := orgOf(ctx, cf, oadb, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	// The watermark is taken before reading, so splits changed while the
+	// rebuild runs are picked up by the next sync.
+	res := &Result{OrganizationID: organizationID, Rebuilt: true, Watermark: oa.Millis(now)}
+	counts, n, err := countAll(ctx, oadb, org.ID, loc)
+	if err != nil {
+		return nil, err
+	}
+	res.Splits = n
+
+	tx, err := cf.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM balance_snapshots WHERE organization_id = ?`, organizationID); err != nil {
+		return nil, err
+	}
+	var args []any
+	flush := func() error {
+		if len(args) == 0 {
+			return nil
+		}
+		values := strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?, ?), ", len(args)/5), ", ")
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO balance_snapshots (organization_id, account_id, day, movement, balance) VALUES `+values, args...)
+		args = args[:0]
+		return err
+	}
+	for _, account := range sortedKeys(counts) {
+		days := counts[account]
+		var balance int64
+		for _, day := range sortedKeys(days) {
+			movement := days[day]
+			if movement == 0 {
+				continue
+			}
+			balance += movement
+			args = append(args, organizationID, account, day, movement, balance)
+			res.Days++
+			if len(args)/5 == insertBatch {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	res.Changed = res.Days
+
+	state := &State{OrganizationID: organizationID, OAOrgID: org.ID, Timezone: org.Timezone,
+		Watermark: res.Watermark, RebuiltAt: now, SyncedAt: now}
+	if err := saveState(ctx, tx, state); err != nil {
+		return nil, err
+	}
+	return res, tx.Commit()
+}
+
+// orgOf returns an organization's OA org and the timezone its days are
+// counted in.
+func orgOf(ctx context.Context, cf, oadb cashflow.Querier, organizationID string) (*oa.Org, *time.Location, error) {
+	orgID, err := oa.OrgForOrganization(ctx, cf, organizationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	org, err := oa.GetOrg(ctx, oadb, orgID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if org.Timezone == "" {
+		return org, time.UTC, nil
+	}
+	loc, err := time.LoadLocation(org.Timezone)
+	if err != nil {
+		return nil, nil, fmt.Errorf("org %s timezone: %w", org.Name, err)
+	}
+	return org, loc, nil
+}
+
+// countAll sums an org's live splits by account and day.
+func countAll(ctx context.Context, oadb cashflow.Querier, orgID string, loc *time.Location) (map[string]map[string]int64, int, error) {
+	counts := map[string]map[string]int64{}
+	n := 0
+	err := oa.Splits(ctx, oadb, orgID, time.Time{}, forever, func(s *oa.Split) error {
+		days := counts[s.AccountID]
+		if days == nil {
+			days = map[string]int64{}
+			counts[s.AccountID] = days
+		}
+		days[s.Date.In(loc).Format(DayLayout)] += s.Amount
+		n++
+		return nil
+	})
+	return counts, n, err
+}
+
+// changedDays returns the account-days of splits written, deleted or
+// moved with their transaction since a stamp, live or not. OA edits a
+// transaction by deleting its splits and inserting new ones, so both the
+// old and the new days show up.
+func changedDays(ctx context.Context, oadb cashflow.Querier, orgID string, loc *time.Location, since int64) (map[string]map[string]bool, error) {
+	rows, err := oadb.QueryContext(ctx, `
+		SELECT DISTINCT LOWER(HEX(s.accountId)), s.date
+		FROM split s JOIN transaction t ON t.id = s.transactionId
+		WHERE t.orgId = UNHEX(?) AND (s.updated > ? OR t.updated > ?)`, orgID, since, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]map[string]bool{}
+	for rows.Next() {
+		var account string
+		var ms int64
+		if err := rows.Scan(&account, &ms); err != nil {
+			return nil, err
+		}
+		if out[account] == nil {
+			out[account] = map[string]bool{}
+		}
+		out[account][time.UnixMilli(ms).In(loc).Format(DayLayout)] = true
+	}
+	return out, rows.Err()
+}
+
+// recount sums one account's live splits on the given days.
+func recount(ctx context.Context, oadb cashflow.Querier, orgID, accountID string, days map[string]bool, loc *time.Location) (map[string]int64, int, error) {
+	sorted := sortedKeys(days)
+	from, err := time.ParseInLocation(DayLayout, sorted[0], loc)
+	if err != nil {
+		return nil, 0, err
+	}
+	to, err := time.ParseInLocation(DayLayout, sorted[len(sorted)-1], loc)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := oadb.QueryContext(ctx, `
+		SELECT s.date, s.nativeAmount
+		FROM split s JOIN transaction t ON t.id = s.transactionId
+		WHERE t.orgId = UNHEX(?) AND s.accountId = UNHEX(?) AND t.deleted = false AND s.deleted = false
+		  AND s.date >= ? AND s.date < ?`, orgID, accountID, oa.Millis(from), oa.Millis(to.AddDate(0, 0, 1)))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := map[string]int64{}
+	n := 0
+	for rows.Next() {
+		var ms, amount int64
+		if err := rows.Scan(&ms, &amount); err != nil {
+			return nil, 0, err
+		}
+		if day := time.UnixMilli(ms).In(loc).Format(DayLayout); days[day] {
+			out[day] += amount
+			n++
+		}
+	}
+	return out, n, rows.Err()
+}
+
+// Ways apply changes an account-day's row.
+const (
+	opNone = iota
+	opInsert
+	opUpdate
+	opDelete
+)
+
+// dayChange is what setting an account-day's movement does to the
+// snapshots: op on the day's own row, and delta added to the running
+// balance of every later day and, when updated, of the day itself.
+type dayChange struct {
+	op    int
+	delta int64
+}
+
+// changeDay works out the change from the day's stored movement, if it
+// has a row, to the counted one. Days that no longer move are deleted;
+// the balance before them carries forward.
+func changeDay(old int64, exists bool, movement int64) dayChange {
+	delta := movement - old
+	switch {
+	case delta == 0:
+		return dayChange{op: opNone}
+	case movement == 0:
+		return dayChange{op: opDelete, delta: delta}
+	case exists:
+		return dayChange{op: opUpdate, delta: delta}
+	default:
+		return dayChange{op: opInsert, delta: delta}
+	}
+}
+
+// apply sets an account-day's movement and shifts the running balances
+// from that day on by the difference.
+func apply(ctx context.Context, tx *sql.Tx, organizationID, accountID, day string, movement int64) (bool, error) {
+	var old int64
+	exists := true
+	err := tx.QueryRowContext(ctx, `
+		SELECT movement FROM balance_snapshots
+		WHERE organization_id = ? AND account_id = ? AND day = ? FOR UPDATE`, organizationID, accountID, day).Scan(&old)
+	if errors.Is(err, sql.ErrNoRows) {
+		exists = false
+	} else if err != nil {
+		return false, err
+	}
+	c := changeDay(old, exists, movement)
+
+	switch c.op {
+	case opNone:
+		return false, nil
+	case opDelete:
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM balance_snapshots WHERE organization_id = ? AND account_id = ? AND day = ?`,
+			organizationID, accountID, day)
+	case opUpdate:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE balance_snapshots SET movement = ?, balance = balance + ?
+			WHERE organization_id = ? AND account_id = ? AND day = ?`, movement, c.delta, organizationID, accountID, day)
+	case opInsert:
+		var before int64
+		err = tx.QueryRowContext(ctx, `
+			SELECT balance FROM balance_snapshots
+			WHERE organization_id = ? AND account_id = ? AND day < ?
+			ORDER BY day DESC LIMIT 1`, organizationID, accountID, day).Scan(&before)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = nil
+		}
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO balance_snapshots (organization_id, account_id, day, movement, balance) VALUES (?, ?, ?, ?, ?)`,
+				organizationID, accountID, day, movement, before+movement)
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE balance_snapshots SET balance = balance + ?
+		WHERE organization_id = ? AND account_id = ? AND day > ?`, c.delta, organizationID, accountID, day)
+	return err == nil, err
+}
+
+// sortedKeys returns the keys of m in order, so rows are written and
+// reported in a stable order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
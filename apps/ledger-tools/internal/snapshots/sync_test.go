@@ -0,0 +1,134 @@
+package snapshots
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestChangeDay(t *testing.T) {
+	tests := []struct {
+		name     string
+		old      int64
+		exists   bool
+		movement int64
+		want     dayChange
+	}{
+		{name: "unchanged", old: 500, exists: true, movement: 500, want: dayChange{op: opNone}},
+		{name: "still nothing", movement: 0, want: dayChange{op: opNone}},
+		{name: "new day", movement: 300, want: dayChange{op: opInsert, delta: 300}},
+		{name: "new negative day", movement: -120, want: dayChange{op: opInsert, delta: -120}},
+		{name: "more", old: 500, exists: true, movement: 800, want: dayChange{op: opUpdate, delta: 300}},
+		{name: "less", old: 500, exists: true, movement: -100, want: dayChange{op: opUpdate, delta: -600}},
+		{name: "no longer moves", old: 500, exists: true, movement: 0, want: dayChange{op: opDelete, delta: -500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := changeDay(tt.old, tt.exists, tt.movement); got != tt.want {
+				t.Errorf("changeDay(%d, %v, %d) = %+v, want %+v", tt.old, tt.exists, tt.movement, got, tt.want)
+			}
+		})
+	}
+}
+
+type snapshot struct {
+	movement, balance int64
+}
+
+// applyTo does to one account's snapshots, by day, what apply does to
+// balance_snapshots.
+func applyTo(rows map[string]snapshot, day string, movement int64) bool {
+	cur, exists := rows[day]
+	c := changeDay(cur.movement, exists, movement)
+	switch c.op {
+	case opNone:
+		return false
+	case opDelete:
+		delete(rows, day)
+	case opUpdate:
+		rows[day] = snapshot{movement: movement, balance: cur.balance + c.delta}
+	case opInsert:
+		var before int64
+		last := ""
+		for d, r := range rows {
+			if d < day && d > last {
+				last, before = d, r.balance
+			}
+		}
+		rows[day] = snapshot{movement: movement, balance: before + movement}
+	}
+	for d, r := range rows {
+		if d > day {
+			rows[d] = snapshot{movement: r.movement, balance: r.balance + c.delta}
+		}
+	}
+	return true
+}
+
+// rebuilt is what Rebuild writes for movements by day.
+func rebuilt(movements map[string]int64) map[string]snapshot {
+	days := make([]string, 0, len(movements))
+	for d := range movements {
+		days = append(days, d)
+	}
+	sort.Strings(days)
+	out := map[string]snapshot{}
+	var balance int64
+	for _, d := range days {
+		if movements[d] == 0 {
+			continue
+		}
+		balance += movements[d]
+		out[d] = snapshot{movement: movements[d], balance: balance}
+	}
+	return out
+}
+
+func TestApplyPropagatesDelta(t *testing.T) {
+	start := map[string]int64{"2025-03-01": 1000, "2025-03-05": -200, "2025-03-09": 50}
+	tests := []struct {
+		name    string
+		changes map[string]int64
+		changed int
+	}{
+		{name: "nothing changed", changes: map[string]int64{"2025-03-05": -200}},
+		{name: "earlier day grows", changes: map[string]int64{"2025-03-01": 1500}, changed: 1},
+		{name: "middle day shrinks", changes: map[string]int64{"2025-03-05": -900}, changed: 1},
+		{name: "new first day", changes: map[string]int64{"2025-02-20": 70}, changed: 1},
+		{name: "new day between", changes: map[string]int64{"2025-03-07": 30}, changed: 1},
+		{name: "new last day", changes: map[string]int64{"2025-03-31": -10}, changed: 1},
+		{name: "day cleared", changes: map[string]int64{"2025-03-05": 0}, changed: 1},
+		{name: "first day cleared", changes: map[string]int64{"2025-03-01": 0}, changed: 1},
+		{name: "edit moves a split between days",
+			changes: map[string]int64{"2025-03-01": 600, "2025-03-07": 400, "2025-03-09": 50}, changed: 2},
+		{name: "untouched day with no movement", changes: map[string]int64{"2025-03-03": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := rebuilt(start)
+			want := map[string]int64{}
+			for d, m := range start {
+				want[d] = m
+			}
+			days := make([]string, 0, len(tt.changes))
+			for d, m := range tt.changes {
+				want[d] = m
+				days = append(days, d)
+			}
+			sort.Strings(days)
+
+			changed := 0
+			for _, d := range days {
+				if applyTo(rows, d, tt.changes[d]) {
+					changed++
+				}
+			}
+			if changed != tt.changed {
+				t.Errorf("changed %d days, want %d", changed, tt.changed)
+			}
+			if w := rebuilt(want); !reflect.DeepEqual(rows, w) {
+				t.Errorf("snapshots =\n%v\nrebuilt\n%v", rows, w)
+			}
+		})
+	}
+}
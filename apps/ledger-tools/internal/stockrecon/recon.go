@@ -0,0 +1,673 @@
+// Package stockrecon reconciles the inventory subledger to the Inventory GL
+// item by item. checkInventoryBalance in reconciliation-service.js compares
+// total layer value with the inventory accounts in one number; this package
+// matches every inventory movement to its journal's inventory lines through
+// inventory_movements.journalId and attributes the difference to items,
+// warehouses and source documents:
+//
+//	layers - ledger = (layers - movements)  drift: layers changed without a movement
+//	                + movements without a live journal
+//	                + journals with inventory lines but no movements
+//	                + value mismatches between a journal's movements and lines
+//	                + timing: movement and journal dated across the cut-off
+//	                + rounding below the tolerance
+package stockrecon
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Kinds of journal difference.
+const (
+	KindNoMovements = "no_movements"
+	KindValue       = "value"
+	KindTiming      = "timing"
+)
+
+// DefaultTolerance is the per-journal and per-layer difference treated as
+// rounding.
+var DefaultTolerance = money.MustParse("0.01")
+
+// Params selects what to reconcile.
+type Params struct {
+	OrganizationID string
+	// AsOf is the inclusive cut-off for movement and journal dates.
+	AsOf time.Time
+	// Layers compares the current FIFO layers with the movements too. Layers
+	// have no history, so this only makes sense when AsOf is now.
+	Layers    bool
+	Tolerance money.Amount
+}
+
+// Account is a ledger account counted as inventory.
+type Account struct {
+	ID   string `json:"id"`
+	Code string `json:"code"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Movement is an inventory movement whose value has no live journal.
+type Movement struct {
+	ID          string       `json:"id"`
+	Date        time.Time    `json:"date"`
+	ItemID      string       `json:"itemId"`
+	Item        string       `json:"item"`
+	SKU         string       `json:"sku,omitempty"`
+	WarehouseID string       `json:"warehouseId"`
+	Warehouse   string       `json:"warehouse"`
+	Type        string       `json:"type"`
+	SourceType  string       `json:"sourceType,omitempty"`
+	SourceID    string       `json:"sourceId,omitempty"`
+	Reference   string       `json:"reference,omitempty"`
+	JournalID   string       `json:"journalId,omitempty"`
+	Value       money.Amount `json:"value"` // signed: in is positive
+	// Reason says why the value is not in the ledger: no journal, a
+	// journal that does not exist, or one that is not live.
+	Reason string `json:"reason"`
+
+	accountID     string
+	direction     string
+	journalNumber string
+	journalStatus string
+	journalOrg    string
+	journalDate   sql.NullTime
+}
+
+// source is the movement's source document, or its type when it has none.
+func (m *Movement) source() string {
+	if m.SourceType != "" && m.SourceID != "" {
+		return m.SourceType + " " + m.SourceID
+	}
+	return "movement " + m.Type
+}
+
+// JournalDiff is a journal whose inventory lines on one account differ from
+// the value of the movements linked to it.
+type JournalDiff struct {
+	Kind          string       `json:"kind"`
+	JournalID     string       `json:"journalId"`
+	Number        string       `json:"number"`
+	Date          time.Time    `json:"date"`
+	Reference     string       `json:"reference,omitempty"`
+	AccountID     string       `json:"accountId"`
+	Account       string       `json:"account"`
+	Movements     int          `json:"movements"`
+	MovementValue money.Amount `json:"movementValue"`
+	LedgerValue   money.Amount `json:"ledgerValue"`
+	Difference    money.Amount `json:"difference"` // movements - ledger
+	Items         []string     `json:"items,omitempty"`
+	Warehouses    []string     `json:"warehouses,omitempty"`
+	Source        string       `json:"source"`
+
+	itemID, warehouseID string // set when the movements have a single one
+}
+
+// Drift is an item in a warehouse whose layers are worth something other
+// than its movements add up to.
+type Drift struct {
+	ItemID        string       `json:"itemId"`
+	Item          string       `json:"item"`
+	SKU           string       `json:"sku,omitempty"`
+	WarehouseID   string       `json:"warehouseId"`
+	Warehouse     string       `json:"warehouse"`
+	LayerValue    money.Amount `json:"layerValue"`
+	MovementValue money.Amount `json:"movementValue"`
+	Difference    money.Amount `json:"difference"` // layers - movements
+}
+
+// Attribution is the share of the difference that belongs to one item,
+// warehouse or source document.
+type Attribution struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	// Ledger is its share of movements - ledger, Layers of layers -
+	// movements.
+	Ledger money.Amount `json:"ledger"`
+	Layers money.Amount `json:"layers"`
+}
+
+// Total returns the attribution's share of layers - ledger.
+func (a *Attribution) Total() money.Amount { return a.Ledger + a.Layers }
+
+// Summary totals each cause of the difference.
+type Summary struct {
+	Difference      money.Amount `json:"difference"`      // movements - ledger
+	LayerDifference money.Amount `json:"layerDifference"` // layers - ledger
+	Unlinked        money.Amount `json:"unlinked"`
+	NoMovements     money.Amount `json:"noMovements"`
+	Mismatches      money.Amount `json:"mismatches"`
+	Timing          money.Amount `json:"timing"`
+	Rounding        money.Amount `json:"rounding"`
+	Drift           money.Amount `json:"drift"`
+	// Reconciled is true when nothing but timing and rounding is left.
+	Reconciled bool `json:"reconciled"`
+}
+
+// Report is one reconciliation.
+type Report struct {
+	OrganizationID string       `json:"organizationId"`
+	AsOf           time.Time    `json:"asOf"`
+	RanAt          time.Time    `json:"ranAt"`
+	Tolerance      money.Amount `json:"tolerance"`
+	Accounts       []*Account   `json:"accounts"`
+	LayersChecked  bool         `json:"layersChecked"`
+	LayerValue     money.Amount `json:"layerValue"`
+	MovementValue  money.Amount `json:"movementValue"`
+	LedgerValue    money.Amount `json:"ledgerValue"`
+	Summary        Summary      `json:"summary"`
+	// Unlinked are the movements with no live journal, by date.
+	Unlinked []*Movement `json:"unlinked"`
+	// NoMovements are journals with inventory lines but no movements,
+	// Mismatches journals whose movements and lines differ in value, and
+	// Timing journals whose movements fall on the other side of the
+	// cut-off; each by date and number.
+	NoMovements []*JournalDiff `json:"noMovements"`
+	Mismatches  []*JournalDiff `json:"mismatches"`
+	Timing      []*JournalDiff `json:"timing"`
+	Drift       []*Drift       `json:"drift,omitempty"`
+	// Items, Warehouses and Sources attribute the difference, largest
+	// first. Differences spread over several items or warehouses are
+	// attributed to "".
+	Items      []*Attribution `json:"items"`
+	Warehouses []*Attribution `json:"warehouses"`
+	Sources    []*Attribution `json:"sources"`
+}
+
+// Reconcile compares an organization's inventory movements, and optionally
+// its layers, with the inventory accounts of its ledger.
+func Reconcile(ctx context.Context, q cashflow.Querier, p Params, now time.Time) (*Report, error) {
+	if p.AsOf.IsZero() {
+		p.AsOf = now
+	}
+	r := &Report{OrganizationID: p.OrganizationID, AsOf: p.AsOf, RanAt: now, Tolerance: p.Tolerance, LayersChecked: p.Layers}
+
+	accounts, err := inventoryAccounts(ctx, q, p.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	r.Accounts = accounts
+	defaultAccount, err := cashflow.AccountByType(ctx, q, p.OrganizationID, "stock", "inventory")
+	if err != nil && !errors.Is(err, cashflow.ErrNotFound) {
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, a := range accounts {
+		names[a.ID] = a.Code + " " + a.Name
+	}
+
+	movements, err := loadMovements(ctx, q, p.OrganizationID, defaultAccount)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := loadLines(ctx, q, p.OrganizationID, accounts)
+	if err != nil {
+		return nil, err
+	}
+
+	att := newAttributor()
+	source := func(journalID, number string) (string, error) { return journalSource(ctx, q, journalID, number) }
+	if err := r.compare(movements, lines, names, att, source); err != nil {
+		return nil, err
+	}
+	if p.Layers {
+		if err := r.drift(ctx, q, movements, att); err != nil {
+			return nil, err
+		}
+	}
+	r.finish(att)
+	return r, nil
+}
+
+// compare classifies the movements without a live journal and the
+// differences between each journal's movements and inventory lines, up to
+// r.AsOf, and attributes them. source names the document of a journal
+// without movements.
+func (r *Report) compare(movements []*Movement, lines []*line, names map[string]string, att *attributor,
+	source func(journalID, number string) (string, error)) error {
+	byJournal := groups{}
+	linked := map[string]bool{}
+	for _, m := range movements {
+		dated := !m.Date.After(r.AsOf)
+		if dated {
+			r.MovementValue += m.Value
+		}
+		if reason := m.unlinked(r.OrganizationID); reason != "" {
+			if dated && m.Value != 0 {
+				m.Reason = reason
+				r.Unlinked = append(r.Unlinked, m)
+				r.Summary.Unlinked += m.Value
+				att.ledger(m.ItemID, m.Item, m.WarehouseID, m.Warehouse, m.source(), m.Value)
+			}
+			continue
+		}
+		linked[m.JournalID] = true
+		g := byJournal.get(m.JournalID, m.accountID)
+		g.number, g.date = m.journalNumber, m.journalDate.Time
+		g.movements = append(g.movements, m)
+		g.all += m.Value
+		if dated {
+			g.movementValue += m.Value
+		}
+	}
+	for _, l := range lines {
+		dated := !l.date.After(r.AsOf)
+		if dated {
+			r.LedgerValue += l.value
+		}
+		g := byJournal.get(l.journalID, l.accountID)
+		g.number, g.date, g.reference = l.number, l.date, l.reference
+		g.ledgerAll += l.value
+		if dated {
+			g.ledgerValue += l.value
+		}
+	}
+
+	for _, key := range byJournal.keys() {
+		g := byJournal[key]
+		diff := g.movementValue - g.ledgerValue
+		if diff == 0 {
+			continue
+		}
+		if diff.Abs() <= r.Tolerance {
+			r.Summary.Rounding += diff
+			continue
+		}
+		d := g.diff(key, names[key.accountID])
+		switch {
+		case !linked[key.journalID]:
+			d.Kind = KindNoMovements
+			var err error
+			if d.Source, err = source(key.journalID, g.number); err != nil {
+				return err
+			}
+			r.NoMovements = append(r.NoMovements, d)
+			r.Summary.NoMovements += diff
+		case g.all == g.ledgerAll:
+			d.Kind = KindTiming
+			r.Timing = append(r.Timing, d)
+			r.Summary.Timing += diff
+			continue
+		default:
+			d.Kind = KindValue
+			r.Mismatches = append(r.Mismatches, d)
+			r.Summary.Mismatches += diff
+		}
+		item, warehouse := "", ""
+		if len(d.Items) == 1 {
+			item = d.Items[0]
+		}
+		if len(d.Warehouses) == 1 {
+			warehouse = d.Warehouses[0]
+		}
+		att.ledger(d.itemID, item, d.warehouseID, warehouse, d.Source, diff)
+	}
+	return nil
+}
+
+// finish orders the findings and fills in the summary.
+func (r *Report) finish(att *attributor) {
+	r.Items, r.Warehouses, r.Sources = att.sorted(att.items), att.sorted(att.warehouses), att.sorted(att.sources)
+	sortDiffs(r.NoMovements)
+	sortDiffs(r.Mismatches)
+	sortDiffs(r.Timing)
+	sort.SliceStable(r.Unlinked, func(i, j int) bool { return r.Unlinked[i].Date.Before(r.Unlinked[j].Date) })
+
+	s := &r.Summary
+	s.Difference = r.MovementValue - r.LedgerValue
+	if r.LayersChecked {
+		s.LayerDifference = r.LayerValue - r.LedgerValue
+	}
+	s.Reconciled = len(r.Unlinked) == 0 && len(r.NoMovements) == 0 && len(r.Mismatches) == 0 && len(r.Drift) == 0
+}
+
+// drift compares the current layers with all movements, whatever their date.
+func (r *Report) drift(ctx context.Context, q cashflow.Querier, movements []*Movement, att *attributor) error {
+	rows, err := q.QueryContext(ctx, `
+		SELECT l.itemId, p.name, COALESCE(p.sku, ''), l.warehouseId, COALESCE(w.name, l.warehouseId),
+		  SUM(l.quantityRemaining * l.unitCost)
+		FROM inventory_layers l
+		JOIN products p ON p.id = l.itemId
+		LEFT JOIN warehouses w ON w.id = l.warehouseId
+		WHERE p.organizationId = ? AND l.quantityRemaining > 0
+		GROUP BY l.itemId, p.name, p.sku, l.warehouseId, w.name`, r.OrganizationID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var layers []*Drift
+	for rows.Next() {
+		d := &Drift{}
+		if err := rows.Scan(&d.ItemID, &d.Item, &d.SKU, &d.WarehouseID, &d.Warehouse, &d.LayerValue); err != nil {
+			return err
+		}
+		layers = append(layers, d)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	r.compareLayers(movements, layers, att)
+	return nil
+}
+
+// compareLayers finds the items in warehouses whose layer value, one
+// Drift with LayerValue set per item and warehouse, differs from their
+// movements, and attributes the drift.
+func (r *Report) compareLayers(movements []*Movement, layers []*Drift, att *attributor) {
+	type key struct{ item, warehouse string }
+	byKey := map[key]*Drift{}
+	var order []key
+	get := func(k key) *Drift {
+		d := byKey[k]
+		if d == nil {
+			d = &Drift{ItemID: k.item, WarehouseID: k.warehouse}
+			byKey[k] = d
+			order = append(order, k)
+		}
+		return d
+	}
+	for _, m := range movements {
+		d := get(key{m.ItemID, m.WarehouseID})
+		d.Item, d.SKU, d.Warehouse = m.Item, m.SKU, m.Warehouse
+		d.MovementValue += m.Value
+	}
+	for _, l := range layers {
+		d := get(key{l.ItemID, l.WarehouseID})
+		d.Item, d.SKU, d.Warehouse = l.Item, l.SKU, l.Warehouse
+		d.LayerValue += l.LayerValue
+		r.LayerValue += l.LayerValue
+	}
+
+	for _, k := range order {
+		d := byKey[k]
+		d.Difference = d.LayerValue - d.MovementValue
+		if d.Difference == 0 {
+			continue
+		}
+		if d.Difference.Abs() <= r.Tolerance {
+			r.Summary.Rounding += d.Difference
+			continue
+		}
+		r.Drift = append(r.Drift, d)
+		r.Summary.Drift += d.Difference
+		att.layers(d.ItemID, d.Item, d.WarehouseID, d.Warehouse, d.Difference)
+	}
+	sort.SliceStable(r.Drift, func(i, j int) bool { return r.Drift[i].Difference.Abs() > r.Drift[j].Difference.Abs() })
+}
+
+// unlinked returns why a movement's value cannot be in the organization's
+// ledger, or "" if it has a live journal.
+func (m *Movement) unlinked(organizationID string) string {
+	switch {
+	case m.JournalID == "":
+		return "no journal"
+	case m.journalNumber == "":
+		return fmt.Sprintf("journal %s does not exist", m.JournalID)
+	case m.journalOrg != organizationID:
+		return fmt.Sprintf("journal %s belongs to another organization", m.journalNumber)
+	case !strings.Contains(cashflow.LiveJournalStatuses, "'"+m.journalStatus+"'"):
+		return fmt.Sprintf("journal %s is %s", m.journalNumber, m.journalStatus)
+	}
+	return ""
+}
+
+func inventoryAccounts(ctx context.Context, q cashflow.Querier, organizationID string) ([]*Account, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, code, name, type FROM ledger_accounts
+		WHERE organizationId = ? AND (type IN ('stock', 'inventory') OR id IN (
+		  SELECT inventoryAccountId FROM products WHERE organizationId = ? AND inventoryAccountId IS NOT NULL))
+		ORDER BY code`, organizationID, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*Account
+	for rows.Next() {
+		a := &Account{}
+		if err := rows.Scan(&a.ID, &a.Code, &a.Name, &a.Type); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// loadMovements returns all of the organization's movements. Each is booked
+// to its item's inventory account, or defaultAccount when the item has none.
+func loadMovements(ctx context.Context, q cashflow.Querier, organizationID, defaultAccount string) ([]*Movement, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT m.id, m.createdAt, m.itemId, p.name, COALESCE(p.sku, ''), COALESCE(p.inventoryAccountId, ''),
+		  m.warehouseId, COALESCE(w.name, m.warehouseId), m.direction, m.totalValue, m.movementType,
+		  COALESCE(m.sourceType, ''), COALESCE(m.sourceId, ''), COALESCE(m.reference, ''), COALESCE(m.journalId, ''),
+		  COALESCE(j.journalNumber, ''), COALESCE(j.status, ''), COALESCE(j.organizationId, ''), j.journalDate
+		FROM inventory_movements m
+		JOIN products p ON p.id = m.itemId
+		LEFT JOIN warehouses w ON w.id = m.warehouseId
+		LEFT JOIN journals j ON j.id = m.journalId
+		WHERE p.organizationId = ?
+		ORDER BY m.createdAt, m.id`, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*Movement
+	for rows.Next() {
+		m := &Movement{}
+		if err := rows.Scan(&m.ID, &m.Date, &m.ItemID, &m.Item, &m.SKU, &m.accountID,
+			&m.WarehouseID, &m.Warehouse, &m.direction, &m.Value, &m.Type,
+			&m.SourceType, &m.SourceID, &m.Reference, &m.JournalID,
+			&m.journalNumber, &m.journalStatus, &m.journalOrg, &m.journalDate); err != nil {
+			return nil, err
+		}
+		if m.accountID == "" {
+			m.accountID = defaultAccount
+		}
+		if m.direction == "out" {
+			m.Value = -m.Value
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// line is the net of a live journal's entries on one inventory account.
+type line struct {
+	journalID, number, reference string
+	date                         time.Time
+	accountID                    string
+	value                        money.Amount
+}
+
+func loadLines(ctx context.Context, q cashflow.Querier, organizationID string, accounts []*Account) ([]*line, error) {
+	if len(accounts) == 0 {
+		return nil, nil
+	}
+	args := []any{organizationID}
+	marks := make([]string, len(accounts))
+	for i, a := range accounts {
+		marks[i] = "?"
+		args = append(args, a.ID)
+	}
+	rows, err := q.QueryContext(ctx, `
+		SELECT j.id, j.journalNumber, COALESCE(j.reference, ''), j.journalDate, e.accountId,
+		  SUM(e.debitAmount) - SUM(e.creditAmount)
+		FROM journal_entries e JOIN journals j ON j.id = e.journalId
+		WHERE j.organizationId = ? AND j.status IN `+cashflow.LiveJournalStatuses+`
+		  AND e.accountId IN (`+strings.Join(marks, ", ")+`)
+		GROUP BY j.id, j.journalNumber, j.reference, j.journalDate, e.accountId`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*line
+	for rows.Next() {
+		l := &line{}
+		if err := rows.Scan(&l.journalID, &l.number, &l.reference, &l.date, &l.accountID, &l.value); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// journalSource finds the document a journal without movements was posted
+// for, falling back to the journal itself.
+func journalSource(ctx context.Context, q cashflow.Querier, journalID, number string) (string, error) {
+	var kind, ref string
+	err := q.QueryRowContext(ctx, `
+		SELECT 'invoice', invoiceNumber FROM invoices WHERE journalId = ?
+		UNION ALL SELECT 'payment', paymentNumber FROM invoice_payments WHERE journalId = ?
+		UNION ALL SELECT 'transfer', transferNumber FROM inventory_transfers WHERE journalId = ?
+		UNION ALL SELECT 'opening_balance', id FROM inventory_opening_balances WHERE journalId = ?
+		UNION ALL SELECT 'bank_transaction', id FROM bank_transactions WHERE journalId = ?
+		LIMIT 1`, journalID, journalID, journalID, journalID, journalID).Scan(&kind, &ref)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "journal " + number, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return kind + " " + ref, nil
+}
+
+type groupKey struct{ journalID, accountID string }
+
+// group is a journal's movements and lines on one inventory account. The
+// values are up to the cut-off, all and ledgerAll whatever their date.
+type group struct {
+	number, reference string
+	date              time.Time
+	movements         []*Movement
+	movementValue     money.Amount
+	ledgerValue       money.Amount
+	all, ledgerAll    money.Amount
+}
+
+type groups map[groupKey]*group
+
+func (gs groups) get(journalID, accountID string) *group {
+	k := groupKey{journalID, accountID}
+	g := gs[k]
+	if g == nil {
+		g = &group{}
+		gs[k] = g
+	}
+	return g
+}
+
+func (gs groups) keys() []groupKey {
+	out := make([]groupKey, 0, len(gs))
+	for k := range gs {
+		out = append(out, k)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].journalID != out[j].journalID {
+			return out[i].journalID < out[j].journalID
+		}
+		return out[i].accountID < out[j].accountID
+	})
+	return out
+}
+
+func (g *group) diff(k groupKey, account string) *JournalDiff {
+	d := &JournalDiff{
+		JournalID: k.journalID, Number: g.number, Date: g.date, Reference: g.reference,
+		AccountID: k.accountID, Account: account, Movements: len(g.movements),
+		MovementValue: g.movementValue, LedgerValue: g.ledgerValue, Difference: g.movementValue - g.ledgerValue,
+	}
+	if d.Account == "" {
+		d.Account = k.accountID
+	}
+	if k.accountID == "" {
+		d.Account = "(no inventory account)"
+	}
+	items, warehouses, sources := map[string]bool{}, map[string]bool{}, map[string]bool{}
+	for _, m := range g.movements {
+		if !items[m.ItemID] {
+			items[m.ItemID] = true
+			d.Items = append(d.Items, m.Item)
+			d.itemID = m.ItemID
+		}
+		if !warehouses[m.WarehouseID] {
+			warehouses[m.WarehouseID] = true
+			d.Warehouses = append(d.Warehouses, m.Warehouse)
+			d.warehouseID = m.WarehouseID
+		}
+		sources[m.source()] = true
+		d.Source = m.source()
+	}
+	if len(items) != 1 {
+		d.itemID = ""
+	}
+	if len(warehouses) != 1 {
+		d.warehouseID = ""
+	}
+	if len(sources) != 1 {
+		d.Source = "journal " + g.number
+	}
+	return d
+}
+
+func sortDiffs(ds []*JournalDiff) {
+	sort.SliceStable(ds, func(i, j int) bool {
+		if !ds[i].Date.Equal(ds[j].Date) {
+			return ds[i].Date.Before(ds[j].Date)
+		}
+		return ds[i].Number < ds[j].Number
+	})
+}
+
+// attributor accumulates attributions by item, warehouse and source.
+type attributor struct {
+	items, warehouses, sources map[string]*Attribution
+}
+
+func newAttributor() *attributor {
+	return &attributor{items: map[string]*Attribution{}, warehouses: map[string]*Attribution{}, sources: map[string]*Attribution{}}
+}
+
+func (a *attributor) get(m map[string]*Attribution, key, name string) *Attribution {
+	at := m[key]
+	if at == nil {
+		at = &Attribution{Key: key, Name: name}
+		m[key] = at
+	}
+	return at
+}
+
+func (a *attributor) ledger(itemID, item, warehouseID, warehouse, source string, v money.Amount) {
+	a.get(a.items, itemID, item).Ledger += v
+	a.get(a.warehouses, warehouseID, warehouse).Ledger += v
+	a.get(a.sources, source, source).Ledger += v
+}
+
+func (a *attributor) layers(itemID, item, warehouseID, warehouse string, v money.Amount) {
+	a.get(a.items, itemID, item).Layers += v
+	a.get(a.warehouses, warehouseID, warehouse).Layers += v
+	a.get(a.sources, "layers", "layers").Layers += v
+}
+
+func (a *attributor) sorted(m map[string]*Attribution) []*Attribution {
+	out := make([]*Attribution, 0, len(m))
+	for _, at := range m {
+		if at.Ledger != 0 || at.Layers != 0 {
+			out = append(out, at)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if ti, tj := out[i].Total().Abs(), out[j].Total().Abs(); ti != tj {
+			return ti > tj
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
@@ -0,0 +1,194 @@
+package stockrecon
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+var mm = money.MustParse
+
+func day(d int) time.Time { return time.Date(2025, 3, d, 0, 0, 0, 0, time.UTC) }
+
+// movement is a movement of item in warehouse linked to journal, which is
+// posted on the inventory account unless status says otherwise.
+func movement(id, item, warehouse, value string, date time.Time, journal, status string) *Movement {
+	m := &Movement{ID: id, Date: date, ItemID: item, Item: "Item " + item, WarehouseID: warehouse, Warehouse: "WH " + warehouse,
+		Type: "sale", Value: mm(value), JournalID: journal, accountID: "inv", journalOrg: "org1"}
+	if journal != "" && status != "" {
+		m.journalNumber, m.journalStatus = "JV-"+journal, status
+		m.journalDate = sql.NullTime{Time: date, Valid: true}
+	}
+	return m
+}
+
+func attributions(as []*Attribution) []string {
+	var out []string
+	for _, a := range as {
+		out = append(out, fmt.Sprintf("%s:%s/%s", a.Key, a.Ledger, a.Layers))
+	}
+	return out
+}
+
+func fixture() ([]*Movement, []*line) {
+	m2 := movement("m2", "b", "w1", "50", day(5), "", "")
+	m2.SourceType, m2.SourceID = "invoice", "INV-1"
+	m4 := movement("m4", "a", "w1", "5", day(6), "gone", "")
+	m4.Type = "adjustment"
+	m5 := movement("m5", "a", "w1", "200", day(10), "j2", "posted")
+	m5.SourceType, m5.SourceID = "invoice", "INV-2"
+	other := movement("m9", "a", "w1", "0", day(7), "j9", "posted")
+	other.journalOrg = "org2"
+	movements := []*Movement{
+		movement("m1", "a", "w1", "100", day(2), "j1", "posted"),
+		m2,
+		movement("m3", "a", "w2", "-30", day(3), "jv", "void"),
+		m4,
+		m5,
+		// Received after the cut-off, journal dated before it.
+		movement("m6", "c", "w1", "70", time.Date(2025, 4, 2, 0, 0, 0, 0, time.UTC), "j3", "posted"),
+		movement("m7", "c", "w1", "100", day(20), "j5", "active"),
+		// After the cut-off and unlinked.
+		movement("m8", "b", "w2", "9", time.Date(2025, 4, 3, 0, 0, 0, 0, time.UTC), "", ""),
+		// Zero value with a journal of another organization.
+		other,
+	}
+	lines := []*line{
+		{journalID: "j1", number: "JV-j1", date: day(2), accountID: "inv", value: mm("100")},
+		{journalID: "j2", number: "JV-j2", date: day(10), accountID: "inv", value: mm("180")},
+		{journalID: "j3", number: "JV-j3", date: day(31), accountID: "inv", value: mm("70")},
+		{journalID: "j4", number: "JV-j4", date: day(15), accountID: "inv", value: mm("40")},
+		{journalID: "j5", number: "JV-j5", date: day(20), accountID: "inv", value: mm("100.01")},
+	}
+	return movements, lines
+}
+
+func TestCompare(t *testing.T) {
+	movements, lines := fixture()
+	r := &Report{OrganizationID: "org1", AsOf: day(31), Tolerance: DefaultTolerance}
+	att := newAttributor()
+	source := func(journalID, number string) (string, error) { return "invoice INV-4 (" + number + ")", nil }
+	if err := r.compare(movements, lines, map[string]string{"inv": "1800 Inventory"}, att, source); err != nil {
+		t.Fatal(err)
+	}
+	r.finish(att)
+
+	if r.MovementValue != mm("425") || r.LedgerValue != mm("490.01") {
+		t.Errorf("movements %s, ledger %s", r.MovementValue, r.LedgerValue)
+	}
+	want := Summary{Difference: mm("-65.01"), Unlinked: mm("25"), NoMovements: mm("-40"), Mismatches: mm("20"),
+		Timing: mm("-70"), Rounding: mm("-0.01")}
+	if r.Summary != want {
+		t.Errorf("summary =\n%+v\nwant\n%+v", r.Summary, want)
+	}
+	s := r.Summary
+	if s.Unlinked+s.NoMovements+s.Mismatches+s.Timing+s.Rounding != s.Difference {
+		t.Errorf("causes do not add up to the difference")
+	}
+
+	var unlinked []string
+	for _, m := range r.Unlinked {
+		unlinked = append(unlinked, m.ID+": "+m.Reason)
+	}
+	wantUnlinked := []string{"m3: journal JV-jv is void", "m2: no journal", "m4: journal gone does not exist"}
+	if !reflect.DeepEqual(unlinked, wantUnlinked) {
+		t.Errorf("unlinked = %q, want %q", unlinked, wantUnlinked)
+	}
+
+	diffs := map[string]*JournalDiff{}
+	for _, ds := range [][]*JournalDiff{r.NoMovements, r.Mismatches, r.Timing} {
+		for _, d := range ds {
+			diffs[d.JournalID] = d
+		}
+	}
+	if len(diffs) != 3 {
+		t.Fatalf("%d journal differences, want 3", len(diffs))
+	}
+	if d := diffs["j2"]; d.Kind != KindValue || d.Difference != mm("20") || d.Source != "invoice INV-2" ||
+		d.Account != "1800 Inventory" || !reflect.DeepEqual(d.Items, []string{"Item a"}) {
+		t.Errorf("j2 = %+v", d)
+	}
+	if d := diffs["j3"]; d.Kind != KindTiming || d.Difference != mm("-70") {
+		t.Errorf("j3 = %+v", d)
+	}
+	if d := diffs["j4"]; d.Kind != KindNoMovements || d.Source != "invoice INV-4 (JV-j4)" || d.Movements != 0 {
+		t.Errorf("j4 = %+v", d)
+	}
+
+	tests := []struct {
+		name string
+		got  []*Attribution
+		want []string
+	}{
+		{name: "items", got: r.Items, want: []string{"b:50.00/0.00", ":-40.00/0.00", "a:-5.00/0.00"}},
+		{name: "warehouses", got: r.Warehouses, want: []string{"w1:75.00/0.00", ":-40.00/0.00", "w2:-30.00/0.00"}},
+		{name: "sources", got: r.Sources, want: []string{"invoice INV-1:50.00/0.00", "invoice INV-4 (JV-j4):-40.00/0.00",
+			"movement sale:-30.00/0.00", "invoice INV-2:20.00/0.00", "movement adjustment:5.00/0.00"}},
+	}
+	for _, tt := range tests {
+		if got := attributions(tt.got); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if s.Reconciled {
+		t.Errorf("reconciled with differences left")
+	}
+}
+
+func TestCompareLayers(t *testing.T) {
+	movements, _ := fixture()
+	r := &Report{OrganizationID: "org1", Tolerance: DefaultTolerance, LayersChecked: true}
+	att := newAttributor()
+	layers := []*Drift{
+		{ItemID: "a", Item: "Item a", WarehouseID: "w1", Warehouse: "WH w1", LayerValue: mm("305")},
+		{ItemID: "b", Item: "Item b", WarehouseID: "w1", Warehouse: "WH w1", LayerValue: mm("60")},
+		{ItemID: "c", Item: "Item c", WarehouseID: "w1", Warehouse: "WH w1", LayerValue: mm("170.01")},
+		{ItemID: "d", Item: "Item d", WarehouseID: "w1", Warehouse: "WH w1", LayerValue: mm("25")},
+	}
+	r.compareLayers(movements, layers, att)
+	r.finish(att)
+
+	var drift []string
+	for _, d := range r.Drift {
+		drift = append(drift, fmt.Sprintf("%s/%s %s-%s=%s", d.ItemID, d.WarehouseID, d.LayerValue, d.MovementValue, d.Difference))
+	}
+	want := []string{"a/w2 0.00--30.00=30.00", "d/w1 25.00-0.00=25.00", "b/w1 60.00-50.00=10.00", "b/w2 0.00-9.00=-9.00"}
+	if !reflect.DeepEqual(drift, want) {
+		t.Errorf("drift = %q, want %q", drift, want)
+	}
+	if r.LayerValue != mm("560.01") || r.Summary.Drift != mm("56") || r.Summary.Rounding != mm("0.01") {
+		t.Errorf("layers %s, drift %s, rounding %s", r.LayerValue, r.Summary.Drift, r.Summary.Rounding)
+	}
+	if got, want := attributions(r.Items), []string{"a:0.00/30.00", "d:0.00/25.00", "b:0.00/1.00"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("items = %q, want %q", got, want)
+	}
+	if got, want := attributions(r.Sources), []string{"layers:0.00/56.00"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sources = %q, want %q", got, want)
+	}
+}
+
+func TestUnlinked(t *testing.T) {
+	tests := []struct {
+		name string
+		m    *Movement
+		want string
+	}{
+		{name: "live", m: movement("m", "a", "w", "1", day(1), "j1", "posted")},
+		{name: "active", m: movement("m", "a", "w", "1", day(1), "j1", "active")},
+		{name: "no journal", m: movement("m", "a", "w", "1", day(1), "", ""), want: "no journal"},
+		{name: "missing", m: movement("m", "a", "w", "1", day(1), "j1", ""), want: "journal j1 does not exist"},
+		{name: "draft", m: movement("m", "a", "w", "1", day(1), "j1", "draft"), want: "journal JV-j1 is draft"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.unlinked("org1"); got != tt.want {
+			t.Errorf("%s: unlinked = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if got := movement("m", "a", "w", "1", day(1), "j1", "posted").unlinked("org2"); got != "journal JV-j1 belongs to another organization" {
+		t.Errorf("other organization: unlinked = %q", got)
+	}
+}
@@ -0,0 +1,108 @@
+package stockrecon
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Tables are the cashflowdb tables owned by the stockrecon module.
+var Tables = []schema.Table{
+	{
+		Name: "stockrecon_runs",
+		Create: `CREATE TABLE IF NOT EXISTS stockrecon_runs (
+  id VARCHAR(191) NOT NULL,
+  organization_id VARCHAR(191) NOT NULL,
+  as_of DATETIME(3) NOT NULL,
+  ran_at DATETIME(3) NOT NULL,
+  reconciled BOOLEAN NOT NULL,
+  layer_value DECIMAL(14,2) NULL,
+  movement_value DECIMAL(14,2) NOT NULL,
+  ledger_value DECIMAL(14,2) NOT NULL,
+  unlinked INT NOT NULL,
+  no_movements INT NOT NULL,
+  mismatches INT NOT NULL,
+  drift INT NOT NULL,
+  summary TEXT NOT NULL,
+  PRIMARY KEY (id),
+  INDEX stockrecon_runs_org_idx (organization_id, ran_at)
+) ENGINE=InnoDB`,
+	},
+}
+
+// Run is a saved reconciliation: its totals and how many findings of each
+// kind it had.
+type Run struct {
+	ID             string        `json:"id"`
+	OrganizationID string        `json:"organizationId"`
+	AsOf           time.Time     `json:"asOf"`
+	RanAt          time.Time     `json:"ranAt"`
+	LayerValue     *money.Amount `json:"layerValue,omitempty"`
+	MovementValue  money.Amount  `json:"movementValue"`
+	LedgerValue    money.Amount  `json:"ledgerValue"`
+	Unlinked       int           `json:"unlinked"`
+	NoMovements    int           `json:"noMovements"`
+	Mismatches     int           `json:"mismatches"`
+	Drift          int           `json:"drift"`
+	Summary        Summary       `json:"summary"`
+}
+
+// Save records a report in stockrecon_runs and returns the run.
+func Save(ctx context.Context, q cashflow.Querier, r *Report) (*Run, error) {
+	run := &Run{
+		ID: cashflow.NewID("stockrecon"), OrganizationID: r.OrganizationID, AsOf: r.AsOf, RanAt: r.RanAt,
+		MovementValue: r.MovementValue, LedgerValue: r.LedgerValue,
+		Unlinked: len(r.Unlinked), NoMovements: len(r.NoMovements), Mismatches: len(r.Mismatches), Drift: len(r.Drift),
+		Summary: r.Summary,
+	}
+	if r.LayersChecked {
+		v := r.LayerValue
+		run.LayerValue = &v
+	}
+	summary, err := json.Marshal(run.Summary)
+	if err != nil {
+		return nil, err
+	}
+	_, err = q.ExecContext(ctx, `
+		INSERT INTO stockrecon_runs (id, organization_id, as_of, ran_at, reconciled, layer_value, movement_value, ledger_value,
+		  unlinked, no_movements, mismatches, drift, summary)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.ID, run.OrganizationID, run.AsOf, run.RanAt, run.Summary.Reconciled, run.LayerValue, run.MovementValue, run.LedgerValue,
+		run.Unlinked, run.NoMovements, run.Mismatches, run.Drift, summary)
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// Runs returns an organization's latest runs, newest first.
+func Runs(ctx context.Context, q cashflow.Querier, organizationID string, limit int) ([]*Run, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, organization_id, as_of, ran_at, layer_value, movement_value, ledger_value,
+		  unlinked, no_movements, mismatches, drift, summary
+		FROM stockrecon_runs WHERE organization_id = ?
+		ORDER BY ran_at DESC LIMIT ?`, organizationID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Run
+	for rows.Next() {
+		r := &Run{}
+		var summary []byte
+		if err := rows.Scan(&r.ID, &r.OrganizationID, &r.AsOf, &r.RanAt, &r.LayerValue, &r.MovementValue, &r.LedgerValue,
+			&r.Unlinked, &r.NoMovements, &r.Mismatches, &r.Drift, &summary); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(summary, &r.Summary); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
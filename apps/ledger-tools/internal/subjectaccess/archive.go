@@ -0,0 +1,109 @@
+package subjectaccess
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Manifest is manifest.json: what was searched, what was found and a
+// checksum of every file.
+type Manifest struct {
+	Email       string          `json:"email"`
+	GeneratedAt time.Time       `json:"generatedAt"`
+	OAUserIDs   []string        `json:"oaUserIds"`
+	UserIDs     []string        `json:"userIds"`
+	Files       []ManifestEntry `json:"files"`
+}
+
+// ManifestEntry describes one section file.
+type ManifestEntry struct {
+	File        string `json:"file"`
+	Database    string `json:"database"`
+	Table       string `json:"table"`
+	Description string `json:"description"`
+	Rows        int    `json:"rows"`
+	SHA256      string `json:"sha256"`
+}
+
+// WriteArchive writes the export as a zip: README.txt explains the
+// contents, each section is a JSON file under oa/ or cashflow/, and
+// manifest.json lists them with row counts and checksums.
+func (e *Export) WriteArchive(w io.Writer) error {
+	m := &Manifest{
+		Email: e.Email, GeneratedAt: e.GeneratedAt.UTC(), OAUserIDs: append([]string{}, e.OAUserIDs...),
+		UserIDs: append([]string{}, e.UserIDs...), Files: []ManifestEntry{},
+	}
+	type file struct {
+		name string
+		data []byte
+	}
+	var files []file
+	for _, s := range e.Sections {
+		rows := s.Rows
+		if rows == nil {
+			rows = []*Record{}
+		}
+		data, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.File(), err)
+		}
+		data = append(data, '\n')
+		sum := sha256.Sum256(data)
+		files = append(files, file{s.File(), data})
+		m.Files = append(m.Files, ManifestEntry{
+			File: s.File(), Database: s.Database, Table: s.Table, Description: s.Description,
+			Rows: len(s.Rows), SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	files = append([]file{{"README.txt", e.readme(m)}, {"manifest.json", append(manifest, '\n')}}, files...)
+
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: e.GeneratedAt})
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (e *Export) readme(m *Manifest) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Personal data export for %s\n", e.Email)
+	fmt.Fprintf(&b, "Generated %s\n\n", e.GeneratedAt.UTC().Format(time.RFC1123))
+	if len(e.OAUserIDs) == 0 && len(e.UserIDs) == 0 {
+		b.WriteString("No user account was found for this email; any invitations sent to it are included.\n\n")
+	}
+
+	b.WriteString("This archive holds every record linked to the email address in the\n")
+	b.WriteString("accounting (oa/) and web app (cashflow/) databases, one JSON file per table.\n")
+	b.WriteString("Times are UTC. Transaction amounts are in the smallest unit of the\n")
+	b.WriteString("organization's currency (cents for USD).\n\n")
+
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tRECORDS\tCONTENTS")
+	for _, f := range m.Files {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", f.File, f.Rows, f.Description)
+	}
+	tw.Flush()
+
+	b.WriteString("\nNot included: password hashes, password reset and email verification\n")
+	b.WriteString("codes and session tokens. These are security credentials rather than\n")
+	b.WriteString("information about you; session and API key ids are shortened for the same\n")
+	b.WriteString("reason. manifest.json lists a SHA-256 checksum for every file.\n")
+	return b.Bytes()
+}
@@ -0,0 +1,70 @@
+package subjectaccess
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteArchive(t *testing.T) {
+	e := &Export{
+		Email:       "aung@example.com",
+		GeneratedAt: time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC),
+		OAUserIDs:   []string{"0a1b"},
+		Sections: []*Section{
+			{Database: "oa", Table: "user", Description: "Accounting login",
+				Rows: []*Record{{Columns: []string{"id", "email"}, Values: []any{"0a1b", "aung@example.com"}}}},
+			{Database: "cashflow", Table: "invitations", Description: "Invitations sent to the email"},
+		},
+	}
+	var buf bytes.Buffer
+	if err := e.WriteArchive(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	var names []string
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = data
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, " "); got != "README.txt manifest.json oa/user.json cashflow/invitations.json" {
+		t.Errorf("files = %s", got)
+	}
+	if got := string(files["cashflow/invitations.json"]); got != "[]\n" {
+		t.Errorf("empty section = %q, want an empty array", got)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(files["manifest.json"], &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Email != e.Email || len(m.Files) != 2 || m.Files[0].Rows != 1 || m.Files[1].Rows != 0 || m.UserIDs == nil {
+		t.Errorf("manifest = %+v", m)
+	}
+	for _, f := range m.Files {
+		sum := sha256.Sum256(files[f.File])
+		if f.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s checksum does not match its content", f.File)
+		}
+	}
+	readme := string(files["README.txt"])
+	if !strings.Contains(readme, "oa/user.json") || strings.Contains(readme, "No user account was found") {
+		t.Errorf("README = %s", readme)
+	}
+}
@@ -0,0 +1,353 @@
+// Package subjectaccess collects the personal data linked to one email
+// address across the OA and cashflow databases, for subject access
+// requests.
+//
+// Rows are found through the email itself (OA user and invite, cashflow
+// users and invitations) and then through the user ids those give. Values
+// are made readable on the way: OA ids become hex, OA millisecond
+// timestamps become RFC 3339 times, JSON columns stay JSON. Credentials are
+// never exported; password hashes, reset and verification codes and
+// session tokens are left out, and ids that are themselves secrets (OA
+// session and API key ids) are cut to a short prefix.
+package subjectaccess
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+)
+
+// Section is the rows of one table.
+type Section struct {
+	Database    string // "oa" or "cashflow"
+	Table       string
+	Description string
+	Rows        []*Record
+}
+
+// File is the section's path in the archive.
+func (s *Section) File() string { return s.Database + "/" + s.Table + ".json" }
+
+// Record is a row with its columns in select order.
+type Record struct {
+	Columns []string
+	Values  []any
+}
+
+// Get returns a column's value.
+func (r *Record) Get(column string) any {
+	for i, c := range r.Columns {
+		if c == column {
+			return r.Values[i]
+		}
+	}
+	return nil
+}
+
+// MarshalJSON writes the record as an object in column order.
+func (r *Record) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, c := range r.Columns {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(c)
+		val, err := json.Marshal(r.Values[i])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(val)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// Export is everything found for one email.
+type Export struct {
+	Email       string
+	GeneratedAt time.Time
+	OAUserIDs   []string
+	UserIDs     []string // cashflow users
+	Sections    []*Section
+}
+
+// Rows counts the rows across all sections.
+func (e *Export) Rows() int {
+	n := 0
+	for _, s := range e.Sections {
+		n += len(s.Rows)
+	}
+	return n
+}
+
+// column conversions
+const (
+	plain  = iota
+	millis // OA BIGINT milliseconds
+	rawJSON
+	flag // TINYINT(1) as true/false
+)
+
+// Collect gathers the data linked to an email. Either database may be nil
+// to skip it.
+func Collect(ctx context.Context, oadb, cf cashflow.Querier, email string) (*Export, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !strings.Contains(email, "@") {
+		return nil, fmt.Errorf("%q is not an email address", email)
+	}
+	e := &Export{Email: email, GeneratedAt: time.Now()}
+	if oadb != nil {
+		if err := e.collectOA(ctx, oadb); err != nil {
+			return nil, fmt.Errorf("oa: %w", err)
+		}
+	}
+	if cf != nil {
+		if err := e.collectCashflow(ctx, cf); err != nil {
+			return nil, fmt.Errorf("cashflow: %w", err)
+		}
+	}
+	return e, nil
+}
+
+func (e *Export) collectOA(ctx context.Context, q cashflow.Querier) error {
+	users, err := e.add(ctx, q, "oa", "user", "Your Open Accounting login.", `
+		SELECT LOWER(HEX(id)) AS id, firstName, lastName, email, emailVerified, agreeToTerms, signupSource,
+		  inserted, updated
+		FROM user WHERE LOWER(email) = ?`,
+		[]any{e.Email}, map[string]int{"inserted": millis, "updated": millis, "emailVerified": flag, "agreeToTerms": flag})
+	if err != nil {
+		return err
+	}
+	for _, u := range users.Rows {
+		e.OAUserIDs = append(e.OAUserIDs, u.Get("id").(string))
+	}
+	ids, args := inList(e.OAUserIDs, "UNHEX(?)")
+
+	if _, err := e.add(ctx, q, "oa", "invite", "Invitations to organizations sent to your email.", `
+		SELECT i.id, LOWER(HEX(i.orgId)) AS orgId, o.name AS organization, i.email, i.accepted, i.inserted, i.updated
+		FROM invite i LEFT JOIN org o ON o.id = i.orgId WHERE LOWER(i.email) = ? ORDER BY i.inserted`,
+		[]any{e.Email}, map[string]int{"inserted": millis, "updated": millis, "accepted": flag}); err != nil {
+		return err
+	}
+	if len(e.OAUserIDs) == 0 {
+		return nil
+	}
+
+	if _, err := e.add(ctx, q, "oa", "userorg", "Organizations you belong to.", `
+		SELECT uo.id, LOWER(HEX(uo.orgId)) AS orgId, o.name AS organization, uo.admin
+		FROM userorg uo LEFT JOIN org o ON o.id = uo.orgId WHERE uo.userId IN (`+ids+`) ORDER BY uo.id`,
+		args, map[string]int{"admin": flag}); err != nil {
+		return err
+	}
+	if _, err := e.add(ctx, q, "oa", "session", "Sign-in sessions. Session ids are credentials and are shortened.", `
+		SELECT CONCAT(LEFT(LOWER(HEX(id)), 6), '...') AS id, inserted, updated, `+"`terminated`"+`
+		FROM session WHERE userId IN (`+ids+`) ORDER BY inserted`,
+		args, map[string]int{"inserted": millis, "updated": millis, "terminated": millis}); err != nil {
+		return err
+	}
+	if _, err := e.add(ctx, q, "oa", "apikey", "API keys you created. Keys are credentials and are shortened.", `
+		SELECT CONCAT(LEFT(LOWER(HEX(id)), 6), '...') AS id, label, inserted, updated, deleted
+		FROM apikey WHERE userId IN (`+ids+`) ORDER BY inserted`,
+		args, map[string]int{"inserted": millis, "updated": millis, "deleted": millis}); err != nil {
+		return err
+	}
+	tx, err := e.add(ctx, q, "oa", "transaction", "Transactions you entered, with their splits.", `
+		SELECT LOWER(HEX(t.id)) AS id, LOWER(HEX(t.orgId)) AS orgId, o.name AS organization, t.date, t.description,
+		  t.data, t.deleted, t.inserted, t.updated
+		FROM transaction t LEFT JOIN org o ON o.id = t.orgId WHERE t.userId IN (`+ids+`) ORDER BY t.date, t.inserted`,
+		args, map[string]int{"date": millis, "inserted": millis, "updated": millis, "data": rawJSON, "deleted": flag})
+	if err != nil {
+		return err
+	}
+	return attachSplits(ctx, q, tx)
+}
+
+// attachSplits adds each transaction's splits as a "splits" column.
+func attachSplits(ctx context.Context, q cashflow.Querier, tx *Section) error {
+	byID := map[string]*Record{}
+	var txIDs []string
+	for _, r := range tx.Rows {
+		id := r.Get("id").(string)
+		byID[id] = r
+		txIDs = append(txIDs, id)
+		r.Columns = append(r.Columns, "splits")
+		r.Values = append(r.Values, []*Record{})
+	}
+	// Page through the ids so an author of many transactions stays within
+	// the placeholder limit.
+	for len(txIDs) > 0 {
+		page := txIDs[:min(len(txIDs), 1000)]
+		txIDs = txIDs[len(page):]
+		ids, args := inList(page, "UNHEX(?)")
+		splits, err := query(ctx, q, `
+			SELECT LOWER(HEX(s.transactionId)) AS transactionId, a.name AS account, s.amount, s.nativeAmount, s.deleted
+			FROM split s LEFT JOIN account a ON a.id = s.accountId
+			WHERE s.transactionId IN (`+ids+`) ORDER BY s.id`, args, map[string]int{"deleted": flag})
+		if err != nil {
+			return err
+		}
+		for _, s := range splits {
+			r := byID[s.Get("transactionId").(string)]
+			s.Columns, s.Values = s.Columns[1:], s.Values[1:]
+			last := len(r.Values) - 1
+			r.Values[last] = append(r.Values[last].([]*Record), s)
+		}
+	}
+	return nil
+}
+
+func (e *Export) collectCashflow(ctx context.Context, q cashflow.Querier) error {
+	users, err := e.add(ctx, q, "cashflow", "users", "Your profile in the web app.", `
+		SELECT id, email, name, avatar, emailVerified, googleId, defaultCurrency, timezone, locale,
+		  createdAt, updatedAt, lastLoginAt
+		FROM users WHERE LOWER(email) = ?`, []any{e.Email}, nil)
+	if err != nil {
+		return err
+	}
+	for _, u := range users.Rows {
+		e.UserIDs = append(e.UserIDs, u.Get("id").(string))
+	}
+	ids, args := inList(e.UserIDs, "?")
+
+	if _, err := e.add(ctx, q, "cashflow", "organization_invitations", "Invitations to organizations sent to your email.", `
+		SELECT i.id, i.organizationId, o.name AS organization, i.email, i.role, i.expiresAt, i.acceptedAt,
+		  i.invitedByUserId, i.createdAt, i.updatedAt
+		FROM organization_invitations i LEFT JOIN organizations o ON o.id = i.organizationId
+		WHERE LOWER(i.email) = ? ORDER BY i.createdAt`, []any{e.Email}, nil); err != nil {
+		return err
+	}
+	if len(e.UserIDs) == 0 {
+		return nil
+	}
+
+	if _, err := e.add(ctx, q, "cashflow", "sessions", "Sign-in sessions. Session tokens are not exported.", `
+		SELECT id, expires FROM sessions WHERE userId IN (`+ids+`) ORDER BY expires`, args, nil); err != nil {
+		return err
+	}
+	if _, err := e.add(ctx, q, "cashflow", "user_preferences", "Your app settings.", `
+		SELECT theme, sidebarCollapsed, language, dashboardLayout, favoriteReports, emailNotifications,
+		  pushNotifications, weeklyDigest, createdAt, updatedAt
+		FROM user_preferences WHERE userId IN (`+ids+`)`,
+		args, map[string]int{"dashboardLayout": rawJSON, "favoriteReports": rawJSON, "sidebarCollapsed": flag,
+			"emailNotifications": flag, "pushNotifications": flag, "weeklyDigest": flag}); err != nil {
+		return err
+	}
+	if _, err := e.add(ctx, q, "cashflow", "organization_members", "Organizations you belong to and your role in each.", `
+		SELECT m.organizationId, o.name AS organization, m.role, m.permissions, m.status, m.joinedAt, m.updatedAt
+		FROM organization_members m LEFT JOIN organizations o ON o.id = m.organizationId
+		WHERE m.userId IN (`+ids+`) ORDER BY m.joinedAt`, args, map[string]int{"permissions": rawJSON}); err != nil {
+		return err
+	}
+	if _, err := e.add(ctx, q, "cashflow", "warehouse_permissions", "Warehouses you were given access to.", `
+		SELECT p.warehouseId, w.name AS warehouse, p.permission, p.createdAt, p.updatedAt
+		FROM warehouse_permissions p LEFT JOIN warehouses w ON w.id = p.warehouseId
+		WHERE p.userId IN (`+ids+`) ORDER BY p.createdAt`, args, nil); err != nil {
+		return err
+	}
+	_, err = e.add(ctx, q, "cashflow", "audit_logs", "Actions recorded against your user.", `
+		SELECT l.id, l.organizationId, o.name AS organization, l.action, l.resource, l.resourceId, l.ipAddress,
+		  l.userAgent, l.method, l.url, l.oldValues, l.newValues, l.metadata, l.createdAt
+		FROM audit_logs l LEFT JOIN organizations o ON o.id = l.organizationId
+		WHERE l.userId IN (`+ids+`) ORDER BY l.createdAt`,
+		args, map[string]int{"oldValues": rawJSON, "newValues": rawJSON, "metadata": rawJSON})
+	return err
+}
+
+// add runs a section's query and appends it, even when empty, so the
+// archive shows what was searched.
+func (e *Export) add(ctx context.Context, q cashflow.Querier, database, table, description, sql string, args []any, conv map[string]int) (*Section, error) {
+	rows, err := query(ctx, q, sql, args, conv)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", table, err)
+	}
+	s := &Section{Database: database, Table: table, Description: description, Rows: rows}
+	e.Sections = append(e.Sections, s)
+	return s, nil
+}
+
+func inList(ids []string, placeholder string) (string, []any) {
+	marks := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		marks[i], args[i] = placeholder, id
+	}
+	return strings.Join(marks, ", "), args
+}
+
+// query scans rows into records, converting values for JSON.
+func query(ctx context.Context, q cashflow.Querier, sql string, args []any, conv map[string]int) ([]*Record, error) {
+	rows, err := q.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*Record
+	for rows.Next() {
+		vals := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, c := range cols {
+			vals[i] = value(vals[i], conv[c])
+		}
+		out = append(out, &Record{Columns: cols, Values: vals})
+	}
+	return out, rows.Err()
+}
+
+func value(v any, conv int) any {
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+	switch conv {
+	case millis:
+		var ms int64
+		switch n := v.(type) {
+		case int64:
+			ms = n
+		case uint64:
+			ms = int64(n)
+		case string:
+			ms, _ = strconv.ParseInt(n, 10, 64)
+		default:
+			return v
+		}
+		if ms == 0 {
+			return nil
+		}
+		return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+	case rawJSON:
+		if s, ok := v.(string); ok && json.Valid([]byte(s)) {
+			return json.RawMessage(s)
+		}
+	case flag:
+		switch n := v.(type) {
+		case int64:
+			return n != 0
+		case string:
+			return n != "0" && n != ""
+		}
+	}
+	if t, ok := v.(time.Time); ok {
+		return t.UTC().Format(time.RFC3339)
+	}
+	return v
+}
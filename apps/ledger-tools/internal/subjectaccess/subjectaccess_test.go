@@ -0,0 +1,64 @@
+package subjectaccess
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValue(t *testing.T) {
+	yangon := time.FixedZone("MMT", 6*3600+1800)
+	tests := []struct {
+		name string
+		in   any
+		conv int
+		want any
+	}{
+		{name: "bytes become strings", in: []byte("Aung"), conv: plain, want: "Aung"},
+		{name: "plain numbers stay", in: int64(42), conv: plain, want: int64(42)},
+		{name: "nil stays", in: nil, conv: plain, want: nil},
+		{name: "times are UTC", in: time.Date(2025, 3, 1, 9, 30, 0, 0, yangon), conv: plain, want: "2025-03-01T03:00:00Z"},
+		{name: "milliseconds", in: int64(1740819600000), conv: millis, want: "2025-03-01T09:00:00Z"},
+		{name: "unsigned milliseconds", in: uint64(1740819600000), conv: millis, want: "2025-03-01T09:00:00Z"},
+		{name: "milliseconds as text", in: []byte("1740819600000"), conv: millis, want: "2025-03-01T09:00:00Z"},
+		{name: "zero milliseconds", in: int64(0), conv: millis, want: nil},
+		{name: "null milliseconds", in: nil, conv: millis, want: nil},
+		{name: "JSON column", in: []byte(`{"theme":"dark"}`), conv: rawJSON, want: json.RawMessage(`{"theme":"dark"}`)},
+		{name: "invalid JSON stays text", in: []byte(`{theme`), conv: rawJSON, want: "{theme"},
+		{name: "flag", in: int64(1), conv: flag, want: true},
+		{name: "flag off", in: int64(0), conv: flag, want: false},
+		{name: "flag as text", in: []byte("0"), conv: flag, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := value(tt.in, tt.conv); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("value(%v) = %#v, want %#v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordJSON(t *testing.T) {
+	r := &Record{
+		Columns: []string{"id", "email", "settings", "created_at"},
+		Values:  []any{"u1", "a@b.example", json.RawMessage(`{"a":1}`), nil},
+	}
+	got, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"id":"u1","email":"a@b.example","settings":{"a":1},"created_at":null}`; string(got) != want {
+		t.Errorf("Marshal = %s, want %s", got, want)
+	}
+	if r.Get("email") != "a@b.example" || r.Get("password") != nil {
+		t.Errorf("Get = %v, %v", r.Get("email"), r.Get("password"))
+	}
+}
+
+func TestInList(t *testing.T) {
+	marks, args := inList([]string{"a", "b", "c"}, "UNHEX(?)")
+	if marks != "UNHEX(?), UNHEX(?), UNHEX(?)" || !reflect.DeepEqual(args, []any{"a", "b", "c"}) {
+		t.Errorf("inList = %q, %v", marks, args)
+	}
+}
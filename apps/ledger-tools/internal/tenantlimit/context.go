@@ -0,0 +1,94 @@
+package tenantlimit
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Headers a request names its tenant and priority with. X-Org-Id is the
+// header the BFF already reads the organization from.
+const (
+	TenantHeader   = "X-Org-Id"
+	PriorityHeader = "X-Priority"
+)
+
+// Priority orders waiting queries: interactive ones are served first and
+// may use the slots batch queries cannot.
+type Priority int
+
+const (
+	Interactive Priority = iota
+	Batch
+)
+
+func (p Priority) String() string {
+	if p == Batch {
+		return "batch"
+	}
+	return "interactive"
+}
+
+// ParsePriority reads "interactive" or "batch"; anything else is
+// interactive.
+func ParsePriority(s string) Priority {
+	if strings.EqualFold(strings.TrimSpace(s), "batch") {
+		return Batch
+	}
+	return Interactive
+}
+
+type ctxKey int
+
+const (
+	tenantKey ctxKey = iota
+	priorityKey
+)
+
+// WithTenant returns a context whose queries count against a tenant,
+// normally the cashflowdb organization id.
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey, id)
+}
+
+// TenantFrom returns the context's tenant, or "".
+func TenantFrom(ctx context.Context) string {
+	id, _ := ctx.Value(tenantKey).(string)
+	return id
+}
+
+// WithPriority returns a context whose queries run at a priority.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey, p)
+}
+
+// PriorityFrom returns the context's priority, interactive by default.
+func PriorityFrom(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey).(Priority)
+	return p
+}
+
+// Middleware puts the request's tenant and priority in its context. The
+// tenant is the X-Org-Id header or, for OA-style paths, the id after
+// /orgs/; the priority is the X-Priority header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if id := tenantOf(r); id != "" {
+			ctx = WithTenant(ctx, id)
+		}
+		ctx = WithPriority(ctx, ParsePriority(r.Header.Get(PriorityHeader)))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func tenantOf(r *http.Request) string {
+	if id := strings.TrimSpace(r.Header.Get(TenantHeader)); id != "" {
+		return id
+	}
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/orgs/"); ok {
+		id, _, _ := strings.Cut(rest, "/")
+		return id
+	}
+	return ""
+}
@@ -0,0 +1,327 @@
+package tenantlimit
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"reflect"
+)
+
+// chargeEvery batches row charges so reading a result set does not take
+// the limiter's lock for every row.
+const chargeEvery = 256
+
+// NewConnector wraps a connector so that every query and exec on its
+// connections waits for a slot of its context's tenant, holds it until
+// the result set is closed, and charges the rows it reads or affects.
+// Open the result with sql.OpenDB.
+//
+// A tenant's queries hold slots while their rows are open, so code that
+// runs a query while iterating another of the same tenant needs two slots;
+// with MaxConcurrent 1 it waits until MaxWait and fails.
+func NewConnector(base driver.Connector, l *Limiter) driver.Connector {
+	return &connector{base: base, l: l}
+}
+
+type connector struct {
+	base driver.Connector
+	l    *Limiter
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	dc, err := c.base.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: dc, l: c.l}, nil
+}
+
+func (c *connector) Driver() driver.Driver { return c.base.Driver() }
+
+type conn struct {
+	driver.Conn
+	l *Limiter
+	// skipped is the ticket of a query the driver passed on with
+	// driver.ErrSkip. database/sql prepares that query next on the same
+	// connection, and the statement runs on this ticket instead of taking
+	// a second one.
+	skipped *Ticket
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	ticket := c.skipped
+	c.skipped = nil
+	var s driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		s, err = p.PrepareContext(ctx, query)
+	} else {
+		s, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		ticket.Release()
+		return nil, err
+	}
+	return &stmt{Stmt: s, l: c.l, ticket: ticket}, nil
+}
+
+// acquire takes a ticket for a query run directly on the connection.
+func (c *conn) acquire(ctx context.Context) (*Ticket, error) {
+	c.skipped.Release()
+	c.skipped = nil
+	return c.l.Acquire(ctx)
+}
+
+func (c *conn) Close() error {
+	c.skipped.Release()
+	c.skipped = nil
+	return c.Conn.Close()
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+// QueryContext and ExecContext return driver.ErrSkip when the driver
+// cannot run the statement directly, for instance the MySQL driver with
+// arguments and without interpolateParams; database/sql then prepares it
+// and the statement runs on the ticket already taken.
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ticket, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r, err := q.QueryContext(ctx, query, args)
+	if err == driver.ErrSkip {
+		c.skipped = ticket
+		return nil, err
+	}
+	return limitRows(ticket, r, err)
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ticket, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := e.ExecContext(ctx, query, args)
+	if err == driver.ErrSkip {
+		c.skipped = ticket
+		return nil, err
+	}
+	return limitResult(ticket, res, err)
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	c.skipped.Release()
+	c.skipped = nil
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *conn) CheckNamedValue(v *driver.NamedValue) error {
+	if ch, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(v)
+	}
+	return driver.ErrSkip
+}
+
+type stmt struct {
+	driver.Stmt
+	l *Limiter
+	// ticket is the skipped query's ticket, used by the first run.
+	ticket *Ticket
+}
+
+func (s *stmt) acquire(ctx context.Context) (*Ticket, error) {
+	if ticket := s.ticket; ticket != nil {
+		s.ticket = nil
+		return ticket, nil
+	}
+	return s.l.Acquire(ctx)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	ticket, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var r driver.Rows
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		r, err = q.QueryContext(ctx, args)
+	} else {
+		r, err = s.Stmt.Query(values(args))
+	}
+	return limitRows(ticket, r, err)
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	ticket, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var res driver.Result
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		res, err = e.ExecContext(ctx, args)
+	} else {
+		res, err = s.Stmt.Exec(values(args))
+	}
+	return limitResult(ticket, res, err)
+}
+
+func (s *stmt) Close() error {
+	s.ticket.Release()
+	s.ticket = nil
+	return s.Stmt.Close()
+}
+
+func (s *stmt) CheckNamedValue(v *driver.NamedValue) error {
+	if ch, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(v)
+	}
+	return driver.ErrSkip
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	out := make([]driver.Value, len(args))
+	for i, a := range args {
+		out[i] = a.Value
+	}
+	return out
+}
+
+// limitRows hands the ticket to the result set, which releases it on Close.
+func limitRows(ticket *Ticket, r driver.Rows, err error) (driver.Rows, error) {
+	if err != nil {
+		ticket.Release()
+		return nil, err
+	}
+	return &rows{Rows: r, ticket: ticket}, nil
+}
+
+// limitResult charges the rows affected and releases the ticket.
+func limitResult(ticket *Ticket, res driver.Result, err error) (driver.Result, error) {
+	defer ticket.Release()
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil {
+		ticket.Charge(n)
+	}
+	return res, nil
+}
+
+// rows counts the rows read and releases the slot on Close.
+type rows struct {
+	driver.Rows
+	ticket  *Ticket
+	pending int64
+	err     error
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.err != nil {
+		return r.err
+	}
+	if err := r.Rows.Next(dest); err != nil {
+		if err == io.EOF {
+			r.flush()
+		}
+		return err
+	}
+	r.pending++
+	if r.pending >= chargeEvery {
+		r.flush()
+	}
+	return r.err
+}
+
+func (r *rows) flush() {
+	if err := r.ticket.Charge(r.pending); err != nil && r.err == nil {
+		r.err = err
+	}
+	r.pending = 0
+}
+
+func (r *rows) Close() error {
+	r.flush()
+	err := r.Rows.Close()
+	r.ticket.Release()
+	return err
+}
+
+func (r *rows) HasNextResultSet() bool {
+	if m, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return m.HasNextResultSet()
+	}
+	return false
+}
+
+func (r *rows) NextResultSet() error {
+	if m, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return m.NextResultSet()
+	}
+	return io.EOF
+}
+
+func (r *rows) ColumnTypeDatabaseTypeName(i int) string {
+	if t, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return t.ColumnTypeDatabaseTypeName(i)
+	}
+	return ""
+}
+
+func (r *rows) ColumnTypeScanType(i int) reflect.Type {
+	if t, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return t.ColumnTypeScanType(i)
+	}
+	return reflect.TypeOf(new(any)).Elem()
+}
+
+func (r *rows) ColumnTypeNullable(i int) (nullable, ok bool) {
+	if t, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return t.ColumnTypeNullable(i)
+	}
+	return false, false
+}
+
+func (r *rows) ColumnTypeLength(i int) (length int64, ok bool) {
+	if t, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return t.ColumnTypeLength(i)
+	}
+	return 0, false
+}
+
+func (r *rows) ColumnTypePrecisionScale(i int) (precision, scale int64, ok bool) {
+	if t, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return t.ColumnTypePrecisionScale(i)
+	}
+	return 0, 0, false
+}
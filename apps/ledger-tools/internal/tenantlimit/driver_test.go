@@ -0,0 +1,97 @@
+package tenantlimit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+)
+
+// skipDriver passes every direct query and exec on with driver.ErrSkip, as
+// the MySQL driver does for arguments without interpolateParams, so
+// database/sql prepares them instead. Statements report the tenant's
+// stats as they run.
+type skipDriver struct {
+	l    *Limiter
+	seen []Stats
+}
+
+func (d *skipDriver) Connect(context.Context) (driver.Conn, error) { return &skipConn{d: d}, nil }
+func (d *skipDriver) Driver() driver.Driver                        { return nil }
+
+type skipConn struct{ d *skipDriver }
+
+func (c *skipConn) Prepare(string) (driver.Stmt, error) { return &skipStmt{d: c.d}, nil }
+func (c *skipConn) Close() error                        { return nil }
+func (c *skipConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func (c *skipConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c *skipConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+
+type skipStmt struct{ d *skipDriver }
+
+func (s *skipStmt) Close() error  { return nil }
+func (s *skipStmt) NumInput() int { return -1 }
+
+func (s *skipStmt) Exec([]driver.Value) (driver.Result, error) {
+	s.d.seen = append(s.d.seen, s.d.l.Stats()[0])
+	return driver.RowsAffected(3), nil
+}
+
+func (s *skipStmt) Query([]driver.Value) (driver.Rows, error) {
+	s.d.seen = append(s.d.seen, s.d.l.Stats()[0])
+	return &skipRows{n: 2}, nil
+}
+
+type skipRows struct{ n int }
+
+func (r *skipRows) Columns() []string { return []string{"n"} }
+func (r *skipRows) Close() error      { return nil }
+
+func (r *skipRows) Next(dest []driver.Value) error {
+	if r.n == 0 {
+		return io.EOF
+	}
+	r.n--
+	dest[0] = int64(r.n)
+	return nil
+}
+
+func TestConnectorSkippedQuery(t *testing.T) {
+	l := New(&Config{Default: Limits{MaxConcurrent: 1, MaxQueued: 1}})
+	d := &skipDriver{l: l}
+	db := sql.OpenDB(NewConnector(d, l))
+	defer db.Close()
+	ctx := tenantCtx("org_a", Interactive)
+
+	rows, err := db.QueryContext(ctx, "SELECT n FROM t WHERE id = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM t WHERE id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.seen) != 2 {
+		t.Fatalf("statements ran %d times, want 2", len(d.seen))
+	}
+	for i, s := range d.seen {
+		if s.InFlight != 1 || s.Queries != int64(i+1) {
+			t.Errorf("statement %d ran with %d in flight after %d queries, want 1 after %d", i, s.InFlight, s.Queries, i+1)
+		}
+	}
+	if s := l.Stats()[0]; s.InFlight != 0 || s.Queries != 2 || s.Rows != 5 {
+		t.Errorf("after both statements: %+v", s)
+	}
+}
@@ -0,0 +1,364 @@
+// Package tenantlimit keeps one organization from monopolizing the shared
+// MySQL server. Every query runs under its tenant's budget: a number of
+// queries in flight, part of which only interactive traffic may use, and a
+// refilling budget of rows read. Queries over budget wait in a per-tenant
+// queue, interactive ones first, and fail with ErrSaturated when the queue
+// is full or the wait too long.
+//
+// The limiter sits below database/sql as a driver.Connector (see
+// NewConnector), so existing code is limited without changes; the tenant
+// and priority travel in the context (WithTenant, WithPriority, Middleware).
+// Queries without a tenant are not limited.
+package tenantlimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+)
+
+// ErrSaturated is returned when a query cannot get a slot: the tenant's
+// queue is full or the query waited longer than MaxWait.
+var ErrSaturated = errors.New("tenant query limit reached")
+
+// ErrRowLimit is returned by a result set that reads past MaxRowsPerQuery.
+var ErrRowLimit = errors.New("query read more rows than the tenant's per-query limit")
+
+// Limits are one tenant's budgets.
+type Limits struct {
+	// MaxConcurrent is the number of queries the tenant may have in flight,
+	// counting from execution until the result set is closed.
+	MaxConcurrent int `json:"maxConcurrent"`
+	// InteractiveReserve of those slots are kept for interactive queries.
+	InteractiveReserve int `json:"interactiveReserve"`
+	// MaxQueued is the number of queries that may wait for a slot.
+	MaxQueued int             `json:"maxQueued"`
+	MaxWait   config.Duration `json:"maxWait"`
+	// RowsPerSecond refills the tenant's row budget, up to RowBurst; 0
+	// disables it. Rows read and affected are charged against it, and
+	// batch queries wait while it is used up.
+	RowsPerSecond float64 `json:"rowsPerSecond,omitempty"`
+	RowBurst      int64   `json:"rowBurst,omitempty"`
+	// MaxRowsPerQuery fails a result set that reads more rows, checked
+	// every few hundred rows; 0 for no limit.
+	MaxRowsPerQuery int64 `json:"maxRowsPerQuery,omitempty"`
+}
+
+// Config is the default limits and per-tenant overrides, keyed by
+// organization id. An override replaces the default as a whole.
+type Config struct {
+	Default Limits            `json:"default"`
+	Tenants map[string]Limits `json:"tenants,omitempty"`
+}
+
+// DefaultLimits apply to fields a configuration leaves at zero.
+var DefaultLimits = Limits{MaxConcurrent: 4, InteractiveReserve: 1, MaxQueued: 64, MaxWait: config.Duration(30 * time.Second)}
+
+// LoadConfig reads a configuration file.
+func LoadConfig(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(raw, cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// For returns a tenant's limits with defaults filled in.
+func (c *Config) For(tenant string) Limits {
+	limits, ok := c.Tenants[tenant]
+	if !ok {
+		limits = c.Default
+	}
+	return limits.withDefaults()
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxConcurrent <= 0 {
+		l.MaxConcurrent = DefaultLimits.MaxConcurrent
+	}
+	if l.InteractiveReserve < 0 || l.InteractiveReserve >= l.MaxConcurrent {
+		// Batch queries always keep one slot.
+		l.InteractiveReserve = l.MaxConcurrent - 1
+	}
+	if l.MaxQueued <= 0 {
+		l.MaxQueued = DefaultLimits.MaxQueued
+	}
+	if l.MaxWait <= 0 {
+		l.MaxWait = DefaultLimits.MaxWait
+	}
+	if l.RowsPerSecond > 0 && l.RowBurst <= 0 {
+		l.RowBurst = int64(math.Ceil(l.RowsPerSecond))
+	}
+	return l
+}
+
+// Limiter enforces the limits of every tenant.
+type Limiter struct {
+	cfg     Config
+	mu      sync.Mutex
+	tenants map[string]*tenant
+	now     func() time.Time
+}
+
+// New returns a limiter for a configuration; nil means the defaults for
+// every tenant.
+func New(cfg *Config) *Limiter {
+	l := &Limiter{tenants: map[string]*tenant{}, now: time.Now}
+	if cfg != nil {
+		l.cfg = *cfg
+	}
+	return l
+}
+
+type tenant struct {
+	id     string
+	limits Limits
+
+	inFlight, batchInFlight int
+	waiting                 [2][]*waiter // by priority
+	tokens                  float64
+	refilled                time.Time
+	wake                    *time.Timer
+
+	queries, rejected, timedOut, rowLimited int64
+	rows                                    int64
+	waited                                  time.Duration
+}
+
+type waiter struct {
+	priority Priority
+	ready    chan struct{}
+	granted  bool
+}
+
+func (l *Limiter) tenant(id string) *tenant {
+	t := l.tenants[id]
+	if t == nil {
+		t = &tenant{id: id, limits: l.cfg.For(id), refilled: l.now()}
+		t.tokens = float64(t.limits.RowBurst)
+		l.tenants[id] = t
+	}
+	return t
+}
+
+// refill adds the row budget accrued since the last refill.
+func (l *Limiter) refill(t *tenant) {
+	if t.limits.RowsPerSecond <= 0 {
+		return
+	}
+	now := l.now()
+	t.tokens = math.Min(float64(t.limits.RowBurst), t.tokens+now.Sub(t.refilled).Seconds()*t.limits.RowsPerSecond)
+	t.refilled = now
+}
+
+// admits reports whether a query of the priority may start now.
+func (t *tenant) admits(p Priority) bool {
+	if t.inFlight >= t.limits.MaxConcurrent {
+		return false
+	}
+	if p == Batch {
+		if t.batchInFlight >= t.limits.MaxConcurrent-t.limits.InteractiveReserve {
+			return false
+		}
+		if t.limits.RowsPerSecond > 0 && t.tokens <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *tenant) take(p Priority) {
+	t.inFlight++
+	if p == Batch {
+		t.batchInFlight++
+	}
+	t.queries++
+}
+
+// dispatch hands free slots to waiting queries, interactive ones first,
+// and arranges to be called again when a used-up row budget refills.
+func (l *Limiter) dispatch(t *tenant) {
+	l.refill(t)
+	for _, p := range []Priority{Interactive, Batch} {
+		for len(t.waiting[p]) > 0 && t.admits(p) {
+			w := t.waiting[p][0]
+			t.waiting[p] = t.waiting[p][1:]
+			t.take(p)
+			w.granted = true
+			close(w.ready)
+		}
+	}
+	if len(t.waiting[Batch]) > 0 && t.limits.RowsPerSecond > 0 && t.tokens <= 0 && t.wake == nil {
+		wait := time.Duration((1 - t.tokens) / t.limits.RowsPerSecond * float64(time.Second))
+		t.wake = time.AfterFunc(wait, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			t.wake = nil
+			l.dispatch(t)
+		})
+	}
+}
+
+// Acquire waits for a slot for the context's tenant. The ticket must be
+// released when the query's results are done with; a nil ticket, for
+// queries without a tenant, may be used as well.
+func (l *Limiter) Acquire(ctx context.Context) (*Ticket, error) {
+	id := TenantFrom(ctx)
+	if id == "" {
+		return nil, nil
+	}
+	p := PriorityFrom(ctx)
+
+	l.mu.Lock()
+	t := l.tenant(id)
+	l.refill(t)
+	if len(t.waiting[Interactive]) == 0 && (p == Interactive || len(t.waiting[Batch]) == 0) && t.admits(p) {
+		t.take(p)
+		l.mu.Unlock()
+		return &Ticket{l: l, t: t, priority: p}, nil
+	}
+	if len(t.waiting[Interactive])+len(t.waiting[Batch]) >= t.limits.MaxQueued {
+		t.rejected++
+		l.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s has %d queries waiting", ErrSaturated, id, t.limits.MaxQueued)
+	}
+	w := &waiter{priority: p, ready: make(chan struct{})}
+	t.waiting[p] = append(t.waiting[p], w)
+	l.dispatch(t)
+	maxWait := time.Duration(t.limits.MaxWait)
+	l.mu.Unlock()
+
+	start := l.now()
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = fmt.Errorf("%w: %s waited %s for a %s slot", ErrSaturated, id, maxWait, p)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t.waited += l.now().Sub(start)
+	if err != nil && !w.granted {
+		for i, q := range t.waiting[p] {
+			if q == w {
+				t.waiting[p] = append(t.waiting[p][:i], t.waiting[p][i+1:]...)
+				break
+			}
+		}
+		if errors.Is(err, ErrSaturated) {
+			t.timedOut++
+		}
+		return nil, err
+	}
+	// A slot granted as the wait ended is used rather than given back.
+	return &Ticket{l: l, t: t, priority: p}, nil
+}
+
+// Ticket is a query's slot.
+type Ticket struct {
+	l        *Limiter
+	t        *tenant
+	priority Priority
+	rows     int64
+	once     sync.Once
+}
+
+// Charge counts rows read or affected against the tenant's budget and
+// returns ErrRowLimit once the query has gone past MaxRowsPerQuery.
+func (k *Ticket) Charge(rows int64) error {
+	if k == nil || rows == 0 {
+		return nil
+	}
+	k.rows += rows
+	k.l.mu.Lock()
+	defer k.l.mu.Unlock()
+	k.l.refill(k.t)
+	k.t.rows += rows
+	if k.t.limits.RowsPerSecond > 0 {
+		k.t.tokens -= float64(rows)
+	}
+	if limit := k.t.limits.MaxRowsPerQuery; limit > 0 && k.rows > limit {
+		k.t.rowLimited++
+		return fmt.Errorf("%w (%d rows for %s)", ErrRowLimit, limit, k.t.id)
+	}
+	return nil
+}
+
+// Release frees the slot; later calls do nothing.
+func (k *Ticket) Release() {
+	if k == nil {
+		return
+	}
+	k.once.Do(func() {
+		k.l.mu.Lock()
+		defer k.l.mu.Unlock()
+		k.t.inFlight--
+		if k.priority == Batch {
+			k.t.batchInFlight--
+		}
+		k.l.dispatch(k.t)
+	})
+}
+
+// Stats is a tenant's current use and counters since the limiter started.
+type Stats struct {
+	Tenant             string  `json:"tenant"`
+	InFlight           int     `json:"inFlight"`
+	MaxConcurrent      int     `json:"maxConcurrent"`
+	QueuedInteractive  int     `json:"queuedInteractive"`
+	QueuedBatch        int     `json:"queuedBatch"`
+	Queries            int64   `json:"queries"`
+	Rejected           int64   `json:"rejected"`
+	TimedOut           int64   `json:"timedOut"`
+	RowLimited         int64   `json:"rowLimited"`
+	Rows               int64   `json:"rows"`
+	RowBudget          float64 `json:"rowBudget"` // remaining; meaningless without RowsPerSecond
+	WaitSeconds        float64 `json:"waitSeconds"`
+	Saturation         float64 `json:"saturation"` // in flight over MaxConcurrent
+	RowBudgetExhausted bool    `json:"rowBudgetExhausted"`
+}
+
+// Stats returns every tenant seen so far, by tenant id.
+func (l *Limiter) Stats() []Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]Stats, 0, len(l.tenants))
+	for _, t := range l.tenants {
+		l.refill(t)
+		out = append(out, Stats{
+			Tenant:             t.id,
+			InFlight:           t.inFlight,
+			MaxConcurrent:      t.limits.MaxConcurrent,
+			QueuedInteractive:  len(t.waiting[Interactive]),
+			QueuedBatch:        len(t.waiting[Batch]),
+			Queries:            t.queries,
+			Rejected:           t.rejected,
+			TimedOut:           t.timedOut,
+			RowLimited:         t.rowLimited,
+			Rows:               t.rows,
+			RowBudget:          t.tokens,
+			WaitSeconds:        t.waited.Seconds(),
+			Saturation:         float64(t.inFlight) / float64(t.limits.MaxConcurrent),
+			RowBudgetExhausted: t.limits.RowsPerSecond > 0 && t.tokens <= 0,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Tenant < out[j].Tenant })
+	return out
+}
@@ -0,0 +1,221 @@
+package tenantlimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/config"
+)
+
+func TestWithDefaults(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Limits
+		want Limits
+	}{
+		{name: "zero", in: Limits{},
+			want: Limits{MaxConcurrent: 4, MaxQueued: 64, MaxWait: DefaultLimits.MaxWait}},
+		{name: "kept", in: Limits{MaxConcurrent: 8, InteractiveReserve: 2, MaxQueued: 10, MaxWait: config.Duration(time.Second)},
+			want: Limits{MaxConcurrent: 8, InteractiveReserve: 2, MaxQueued: 10, MaxWait: config.Duration(time.Second)}},
+		{name: "reserve leaves batch one slot", in: Limits{MaxConcurrent: 3, InteractiveReserve: 3},
+			want: Limits{MaxConcurrent: 3, InteractiveReserve: 2, MaxQueued: 64, MaxWait: DefaultLimits.MaxWait}},
+		{name: "negative reserve", in: Limits{MaxConcurrent: 2, InteractiveReserve: -1},
+			want: Limits{MaxConcurrent: 2, InteractiveReserve: 1, MaxQueued: 64, MaxWait: DefaultLimits.MaxWait}},
+		{name: "burst from rate", in: Limits{MaxConcurrent: 1, RowsPerSecond: 2.5},
+			want: Limits{MaxConcurrent: 1, MaxQueued: 64, MaxWait: DefaultLimits.MaxWait, RowsPerSecond: 2.5, RowBurst: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.in.withDefaults(); got != tt.want {
+				t.Errorf("withDefaults = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigFor(t *testing.T) {
+	cfg := &Config{Default: Limits{MaxConcurrent: 6}, Tenants: map[string]Limits{"org_a": {MaxConcurrent: 2}}}
+	if got := cfg.For("org_a").MaxConcurrent; got != 2 {
+		t.Errorf("override MaxConcurrent = %d, want 2", got)
+	}
+	if got := cfg.For("org_b").MaxConcurrent; got != 6 {
+		t.Errorf("default MaxConcurrent = %d, want 6", got)
+	}
+}
+
+func tenantCtx(id string, p Priority) context.Context {
+	return WithPriority(WithTenant(context.Background(), id), p)
+}
+
+// acquireAsync starts an Acquire and waits until it is queued. The
+// channel receives the ticket, or nil when the Acquire failed with
+// ErrSaturated.
+func acquireAsync(t *testing.T, l *Limiter, ctx context.Context, queued func(Stats) bool) <-chan *Ticket {
+	t.Helper()
+	out := make(chan *Ticket, 1)
+	go func() {
+		k, err := l.Acquire(ctx)
+		if err != nil && !errors.Is(err, ErrSaturated) {
+			t.Errorf("Acquire: %v", err)
+		}
+		out <- k
+	}()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if s := l.Stats(); len(s) > 0 && queued(s[0]) {
+			return out
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("query was not queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func received(ch <-chan *Ticket) bool {
+	select {
+	case <-time.After(50 * time.Millisecond):
+		return false
+	case <-ch:
+		return true
+	}
+}
+
+func TestAcquirePriority(t *testing.T) {
+	l := New(&Config{Default: Limits{MaxConcurrent: 2, InteractiveReserve: 1, MaxWait: config.Duration(5 * time.Second)}})
+	interactive, batch := tenantCtx("org_a", Interactive), tenantCtx("org_a", Batch)
+
+	first, err := l.Acquire(interactive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := l.Acquire(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The only batch slot is taken: batch waits, interactive does not.
+	queuedBatch := acquireAsync(t, l, batch, func(s Stats) bool { return s.QueuedBatch == 1 })
+	// Both slots are taken now.
+	queuedInteractive := acquireAsync(t, l, interactive, func(s Stats) bool { return s.QueuedInteractive == 1 })
+
+	first.Release()
+	if !received(queuedInteractive) {
+		t.Fatal("the freed slot did not go to the interactive query")
+	}
+	if received(queuedBatch) {
+		t.Fatal("batch query ran past the interactive reserve")
+	}
+	second.Release()
+	if !received(queuedBatch) {
+		t.Fatal("batch query did not get the freed batch slot")
+	}
+
+	s := l.Stats()[0]
+	if s.Queries != 4 || s.InFlight != 2 || s.QueuedInteractive+s.QueuedBatch != 0 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+func TestAcquireSaturated(t *testing.T) {
+	l := New(&Config{Default: Limits{MaxConcurrent: 1, MaxQueued: 1, MaxWait: config.Duration(20 * time.Millisecond)}})
+	ctx := tenantCtx("org_a", Interactive)
+	held, err := l.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Release()
+
+	// Timed out while queued.
+	if _, err := l.Acquire(ctx); !errors.Is(err, ErrSaturated) {
+		t.Fatalf("queued Acquire error = %v, want ErrSaturated", err)
+	}
+
+	// Queue full.
+	waiting := acquireAsync(t, l, ctx, func(s Stats) bool { return s.QueuedInteractive == 1 })
+	if _, err := l.Acquire(ctx); !errors.Is(err, ErrSaturated) {
+		t.Fatalf("Acquire on a full queue error = %v, want ErrSaturated", err)
+	}
+	if k := <-waiting; k != nil {
+		t.Fatalf("queued query got a slot that was never freed")
+	}
+
+	s := l.Stats()[0]
+	if s.Rejected != 1 || s.TimedOut != 2 || s.QueuedInteractive != 0 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+func TestAcquireCancelled(t *testing.T) {
+	l := New(&Config{Default: Limits{MaxConcurrent: 1}})
+	held, err := l.Acquire(tenantCtx("org_a", Interactive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(tenantCtx("org_a", Interactive))
+	cancel()
+	if _, err := l.Acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Acquire error = %v, want context.Canceled", err)
+	}
+	held.Release()
+	if s := l.Stats()[0]; s.InFlight != 0 || s.QueuedInteractive != 0 || s.TimedOut != 0 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	l := New(&Config{Default: Limits{MaxConcurrent: 1}})
+	if k, err := l.Acquire(context.Background()); k != nil || err != nil {
+		t.Fatalf("Acquire without a tenant = %v, %v", k, err)
+	}
+	var none *Ticket
+	none.Release()
+
+	k, err := l.Acquire(tenantCtx("org_a", Batch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Release()
+	k.Release()
+	if s := l.Stats()[0]; s.InFlight != 0 || s.Queries != 1 {
+		t.Errorf("after releasing twice: %+v", s)
+	}
+}
+
+func TestCharge(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New(&Config{Default: Limits{MaxConcurrent: 2, InteractiveReserve: 1, RowsPerSecond: 100, MaxRowsPerQuery: 150}})
+	l.now = func() time.Time { return now }
+
+	k, err := l.Acquire(tenantCtx("org_a", Batch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Charge(100); err != nil {
+		t.Fatalf("Charge within the limit: %v", err)
+	}
+	if err := k.Charge(60); !errors.Is(err, ErrRowLimit) {
+		t.Fatalf("Charge past MaxRowsPerQuery error = %v, want ErrRowLimit", err)
+	}
+	k.Release()
+
+	s := l.Stats()[0]
+	if s.Rows != 160 || s.RowBudget != -60 || !s.RowBudgetExhausted || s.RowLimited != 1 {
+		t.Errorf("stats = %+v", s)
+	}
+	// The used-up budget holds batch queries back until it refills.
+	if l.tenants["org_a"].admits(Batch) {
+		t.Errorf("batch admitted with no row budget")
+	}
+	if !l.tenants["org_a"].admits(Interactive) {
+		t.Errorf("interactive held back by the row budget")
+	}
+	now = now.Add(time.Second)
+	if s := l.Stats()[0]; s.RowBudget != 40 || s.RowBudgetExhausted {
+		t.Errorf("after a second: %+v", s)
+	}
+	if !l.tenants["org_a"].admits(Batch) {
+		t.Errorf("batch held back after the budget refilled")
+	}
+}
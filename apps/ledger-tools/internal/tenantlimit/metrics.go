@@ -0,0 +1,58 @@
+package tenantlimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// metrics are the per-tenant series ServeHTTP exposes.
+var metrics = []struct {
+	name, kind, help string
+	value            func(s Stats) float64
+}{
+	{"tenantlimit_in_flight", "gauge", "Queries in flight.", func(s Stats) float64 { return float64(s.InFlight) }},
+	{"tenantlimit_max_concurrent", "gauge", "Queries the tenant may have in flight.", func(s Stats) float64 { return float64(s.MaxConcurrent) }},
+	{"tenantlimit_saturation", "gauge", "Queries in flight over the tenant's limit.", func(s Stats) float64 { return s.Saturation }},
+	{"tenantlimit_queued_interactive", "gauge", "Interactive queries waiting for a slot.", func(s Stats) float64 { return float64(s.QueuedInteractive) }},
+	{"tenantlimit_queued_batch", "gauge", "Batch queries waiting for a slot.", func(s Stats) float64 { return float64(s.QueuedBatch) }},
+	{"tenantlimit_row_budget", "gauge", "Rows left in the tenant's budget.", func(s Stats) float64 { return s.RowBudget }},
+	{"tenantlimit_queries_total", "counter", "Queries started.", func(s Stats) float64 { return float64(s.Queries) }},
+	{"tenantlimit_rejected_total", "counter", "Queries rejected because the queue was full.", func(s Stats) float64 { return float64(s.Rejected) }},
+	{"tenantlimit_timed_out_total", "counter", "Queries that gave up waiting for a slot.", func(s Stats) float64 { return float64(s.TimedOut) }},
+	{"tenantlimit_row_limited_total", "counter", "Queries stopped at the per-query row limit.", func(s Stats) float64 { return float64(s.RowLimited) }},
+	{"tenantlimit_rows_total", "counter", "Rows read or affected.", func(s Stats) float64 { return float64(s.Rows) }},
+	{"tenantlimit_wait_seconds_total", "counter", "Time queries spent waiting for a slot.", func(s Stats) float64 { return s.WaitSeconds }},
+}
+
+// ServeHTTP writes the per-tenant metrics in the Prometheus text format,
+// or as JSON with ?format=json.
+func (l *Limiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stats := l.Stats()
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	WriteMetrics(w, stats)
+}
+
+// WriteMetrics writes stats in the Prometheus text format.
+func WriteMetrics(w io.Writer, stats []Stats) {
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, s := range stats {
+			fmt.Fprintf(w, "%s{tenant=%s} %s\n", m.name, quote(s.Tenant), strconv.FormatFloat(m.value(s), 'g', -1, 64))
+		}
+	}
+}
+
+// quote escapes a label value.
+func quote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
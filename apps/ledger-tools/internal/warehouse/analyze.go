@@ -0,0 +1,348 @@
+// Package warehouse decides what can safely be done with a warehouse that
+// is no longer wanted.
+//
+// warehouse-service.js deleteWarehouse hard-deletes once the warehouse has
+// no layers or movements and is not primary; everything else that points
+// at it (transfers, permissions, invoices, opening balances, the default
+// flag) is left dangling or cascaded away. Analyze lists every dependency
+// and recommends deleting, deactivating or merging. Merge moves the stock
+// into another warehouse through a completed transfer with its journal,
+// then re-points the history so the source warehouse is left empty and
+// inactive.
+package warehouse
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/inventory"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+// Recommended actions.
+const (
+	ActionDelete     = "delete"
+	ActionDeactivate = "deactivate"
+	ActionMerge      = "merge"
+)
+
+// openTransferStatuses are the inventory_transfers statuses that still
+// move stock.
+const openTransferStatuses = "('draft', 'in_transit')"
+
+// Warehouse is a warehouses row.
+type Warehouse struct {
+	ID             string `json:"id"`
+	OrganizationID string `json:"organizationId"`
+	BranchID       string `json:"branchId"`
+	Name           string `json:"name"`
+	Code           string `json:"code,omitempty"`
+	IsDefault      bool   `json:"isDefault"`
+	IsPrimary      bool   `json:"isPrimary"`
+	IsActive       bool   `json:"isActive"`
+}
+
+// Get returns a warehouse of an organization.
+func Get(ctx context.Context, q cashflow.Querier, organizationID, id string) (*Warehouse, error) {
+	w := &Warehouse{}
+	var code sql.NullString
+	err := q.QueryRowContext(ctx, `
+		SELECT id, organizationId, branchId, name, code, isDefault, isPrimary, isActive
+		FROM warehouses WHERE id = ? AND organizationId = ?`, id, organizationID).
+		Scan(&w.ID, &w.OrganizationID, &w.BranchID, &w.Name, &code, &w.IsDefault, &w.IsPrimary, &w.IsActive)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("warehouse %s: %w", id, cashflow.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	w.Code = code.String
+	return w, nil
+}
+
+// Analysis is everything that depends on a warehouse.
+type Analysis struct {
+	Warehouse *Warehouse `json:"warehouse"`
+	// Stock is what the warehouse still holds, by item.
+	Stock      []*StockLine `json:"stock"`
+	StockValue money.Amount `json:"stockValue"`
+	// EmptyLayers are consumed layers, kept as history.
+	EmptyLayers     int             `json:"emptyLayers"`
+	Movements       MovementSummary `json:"movements"`
+	OpeningBalances int             `json:"openingBalances"`
+	OpenTransfers   []*Transfer     `json:"openTransfers"`
+	ClosedTransfers int             `json:"closedTransfers"`
+	Permissions     []*Permission   `json:"permissions"`
+	Invoices        InvoiceRefs     `json:"invoices"`
+	Recommendation  *Recommendation `json:"recommendation"`
+}
+
+// StockLine is one item's remaining stock.
+type StockLine struct {
+	ItemID   string       `json:"itemId"`
+	SKU      string       `json:"sku,omitempty"`
+	Name     string       `json:"name"`
+	Layers   int          `json:"layers"`
+	Quantity float64      `json:"quantity"`
+	Value    money.Amount `json:"value"`
+}
+
+// MovementSummary counts a warehouse's inventory movements.
+type MovementSummary struct {
+	Count  int        `json:"count"`
+	Posted int        `json:"posted"` // with a journal
+	First  *time.Time `json:"first,omitempty"`
+	Last   *time.Time `json:"last,omitempty"`
+}
+
+// Transfer is a transfer from or to the warehouse that is not finished.
+type Transfer struct {
+	ID        string    `json:"id"`
+	Number    string    `json:"number"`
+	Direction string    `json:"direction"` // out or in
+	Other     string    `json:"otherWarehouse"`
+	Status    string    `json:"status"`
+	Date      time.Time `json:"date"`
+}
+
+// Permission is a user's permission on the warehouse.
+type Permission struct {
+	UserID     string `json:"userId"`
+	Email      string `json:"email"`
+	Permission string `json:"permission"`
+}
+
+// InvoiceRefs counts the invoices whose warehouse is this one, by id, code
+// or name, since invoices.warehouse is free text.
+type InvoiceRefs struct {
+	Count int `json:"count"`
+	// Open are those not yet paid or voided.
+	Open     int      `json:"open"`
+	Examples []string `json:"examples,omitempty"` // open invoice numbers
+}
+
+// Recommendation is what to do with the warehouse.
+type Recommendation struct {
+	Action  string   `json:"action"`
+	Reasons []string `json:"reasons"`
+	// Blockers must be resolved before the action can be taken.
+	Blockers []string `json:"blockers,omitempty"`
+}
+
+// Analyze lists a warehouse's dependencies and recommends an action.
+func Analyze(ctx context.Context, q cashflow.Querier, organizationID, id string) (*Analysis, error) {
+	w, err := Get(ctx, q, organizationID, id)
+	if err != nil {
+		return nil, err
+	}
+	a := &Analysis{Warehouse: w}
+	if a.Stock, a.StockValue, err = stock(ctx, q, w.ID); err != nil {
+		return nil, err
+	}
+	if err := q.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM inventory_layers WHERE warehouseId = ? AND quantityRemaining <= ?`, w.ID, inventory.QuantityEpsilon).
+		Scan(&a.EmptyLayers); err != nil {
+		return nil, err
+	}
+	var first, last sql.NullTime
+	if err := q.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(journalId), MIN(createdAt), MAX(createdAt)
+		FROM inventory_movements WHERE warehouseId = ?`, w.ID).
+		Scan(&a.Movements.Count, &a.Movements.Posted, &first, &last); err != nil {
+		return nil, err
+	}
+	if first.Valid {
+		a.Movements.First, a.Movements.Last = &first.Time, &last.Time
+	}
+	if err := q.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM inventory_opening_balances WHERE warehouseId = ?`, w.ID).Scan(&a.OpeningBalances); err != nil {
+		return nil, err
+	}
+	if a.OpenTransfers, err = openTransfers(ctx, q, w.ID); err != nil {
+		return nil, err
+	}
+	if err := q.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM inventory_transfers
+		WHERE (fromWarehouseId = ? OR toWarehouseId = ?) AND status NOT IN `+openTransferStatuses, w.ID, w.ID).
+		Scan(&a.ClosedTransfers); err != nil {
+		return nil, err
+	}
+	if a.Permissions, err = permissions(ctx, q, w.ID); err != nil {
+		return nil, err
+	}
+	if a.Invoices, err = invoiceRefs(ctx, q, w); err != nil {
+		return nil, err
+	}
+	a.Recommendation = recommend(a)
+	return a, nil
+}
+
+// HasHistory reports whether anything besides stock and permissions still
+// refers to the warehouse.
+func (a *Analysis) HasHistory() bool {
+	return a.EmptyLayers > 0 || a.Movements.Count > 0 || a.OpeningBalances > 0 || a.ClosedTransfers > 0 ||
+		a.Invoices.Count > 0
+}
+
+// recommend picks the least destructive action that leaves nothing behind:
+// stock has to go somewhere, so it is merged; history has to stay, so the
+// warehouse is deactivated; a warehouse nothing refers to can be deleted.
+func recommend(a *Analysis) *Recommendation {
+	w := a.Warehouse
+	r := &Recommendation{}
+	switch {
+	case len(a.Stock) > 0:
+		r.Action = ActionMerge
+		r.Reasons = append(r.Reasons, fmt.Sprintf("it still holds %d items worth %s; deactivating would strand the stock",
+			len(a.Stock), a.StockValue))
+	case a.HasHistory():
+		r.Action = ActionDeactivate
+		r.Reasons = append(r.Reasons, "it has no stock but its history is referenced; deleting would lose it")
+	default:
+		r.Action = ActionDelete
+		r.Reasons = append(r.Reasons, "nothing refers to it")
+	}
+	if r.Action != ActionMerge && a.Invoices.Open > 0 {
+		r.Reasons = append(r.Reasons, fmt.Sprintf("%d open invoices name it; merge to point them at another warehouse", a.Invoices.Open))
+	}
+	if len(a.Permissions) > 0 {
+		switch r.Action {
+		case ActionMerge:
+			r.Reasons = append(r.Reasons, fmt.Sprintf("%d permissions are copied to the target", len(a.Permissions)))
+		case ActionDelete:
+			r.Reasons = append(r.Reasons, fmt.Sprintf("%d permissions are deleted with it", len(a.Permissions)))
+		}
+	}
+
+	for _, t := range a.OpenTransfers {
+		r.Blockers = append(r.Blockers, fmt.Sprintf("transfer %s %s %s is %s; complete or cancel it",
+			t.Number, map[string]string{"out": "to", "in": "from"}[t.Direction], t.Other, t.Status))
+	}
+	if w.IsPrimary {
+		r.Blockers = append(r.Blockers, "it is its branch's primary warehouse; set another primary first")
+	}
+	if w.IsDefault {
+		if r.Action == ActionMerge {
+			r.Reasons = append(r.Reasons, "the default flag moves to the target")
+		} else {
+			r.Blockers = append(r.Blockers, "it is the organization's default warehouse; set another default first")
+		}
+	}
+	return r
+}
+
+func stock(ctx context.Context, q cashflow.Querier, warehouseID string) ([]*StockLine, money.Amount, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT l.itemId, COALESCE(p.sku, ''), COALESCE(p.name, ''), COUNT(*),
+		       SUM(l.quantityRemaining), SUM(ROUND(l.quantityRemaining * l.unitCost, 2))
+		FROM inventory_layers l LEFT JOIN products p ON p.id = l.itemId
+		WHERE l.warehouseId = ? AND l.quantityRemaining > ?
+		GROUP BY l.itemId, p.sku, p.name
+		ORDER BY p.name, l.itemId`, warehouseID, inventory.QuantityEpsilon)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := []*StockLine{}
+	var total money.Amount
+	for rows.Next() {
+		s := &StockLine{}
+		if err := rows.Scan(&s.ItemID, &s.SKU, &s.Name, &s.Layers, &s.Quantity, &s.Value); err != nil {
+			return nil, 0, err
+		}
+		out = append(out, s)
+		total += s.Value
+	}
+	return out, total, rows.Err()
+}
+
+func openTransfers(ctx context.Context, q cashflow.Querier, warehouseID string) ([]*Transfer, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT t.id, t.transferNumber, IF(t.fromWarehouseId = ?, 'out', 'in'), o.name, t.status, t.transferDate
+		FROM inventory_transfers t
+		JOIN warehouses o ON o.id = IF(t.fromWarehouseId = ?, t.toWarehouseId, t.fromWarehouseId)
+		WHERE (t.fromWarehouseId = ? OR t.toWarehouseId = ?) AND t.status IN `+openTransferStatuses+`
+		ORDER BY t.transferDate, t.transferNumber`, warehouseID, warehouseID, warehouseID, warehouseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []*Transfer{}
+	for rows.Next() {
+		t := &Transfer{}
+		if err := rows.Scan(&t.ID, &t.Number, &t.Direction, &t.Other, &t.Status, &t.Date); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func permissions(ctx context.Context, q cashflow.Querier, warehouseID string) ([]*Permission, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT p.userId, COALESCE(u.email, ''), p.permission
+		FROM warehouse_permissions p LEFT JOIN users u ON u.id = p.userId
+		WHERE p.warehouseId = ?
+		ORDER BY u.email, p.permission`, warehouseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []*Permission{}
+	for rows.Next() {
+		p := &Permission{}
+		if err := rows.Scan(&p.UserID, &p.Email, &p.Permission); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// invoiceMatch is the condition on invoices.warehouse for a warehouse and
+// its arguments.
+func invoiceMatch(w *Warehouse) (string, []any) {
+	cond, args := `i.warehouse IN (?, ?`, []any{w.ID, w.Name}
+	if w.Code != "" {
+		cond += `, ?`
+		args = append(args, w.Code)
+	}
+	return cond + `)`, args
+}
+
+func invoiceRefs(ctx context.Context, q cashflow.Querier, w *Warehouse) (InvoiceRefs, error) {
+	var refs InvoiceRefs
+	cond, args := invoiceMatch(w)
+	args = append([]any{w.OrganizationID}, args...)
+	rows, err := q.QueryContext(ctx, `
+		SELECT i.invoiceNumber, i.status NOT IN ('paid', 'void', 'cancelled')
+		FROM invoices i WHERE i.organizationId = ? AND `+cond+`
+		ORDER BY i.issueDate DESC, i.invoiceNumber`, args...)
+	if err != nil {
+		return refs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var number string
+		var open bool
+		if err := rows.Scan(&number, &open); err != nil {
+			return refs, err
+		}
+		refs.Count++
+		if open {
+			refs.Open++
+			if len(refs.Examples) < 5 {
+				refs.Examples = append(refs.Examples, number)
+			}
+		}
+	}
+	return refs, rows.Err()
+}
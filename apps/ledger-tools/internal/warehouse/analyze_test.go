@@ -0,0 +1,115 @@
+package warehouse
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+)
+
+func TestRecommend(t *testing.T) {
+	stocked := []*StockLine{{ItemID: "p1", Quantity: 4, Value: money.MustParse("40")}, {ItemID: "p2", Quantity: 1, Value: money.MustParse("2.50")}}
+	perms := []*Permission{{UserID: "u1"}, {UserID: "u2"}}
+	open := []*Transfer{
+		{Number: "TRF-7", Direction: "out", Other: "Mandalay", Status: "in_transit"},
+		{Number: "TRF-8", Direction: "in", Other: "Yangon", Status: "draft"},
+	}
+	tests := []struct {
+		name string
+		w    Warehouse
+		a    Analysis
+		want Recommendation
+	}{
+		{
+			name: "unused",
+			want: Recommendation{Action: ActionDelete, Reasons: []string{"nothing refers to it"}},
+		},
+		{
+			name: "unused with permissions",
+			a:    Analysis{Permissions: perms},
+			want: Recommendation{Action: ActionDelete, Reasons: []string{"nothing refers to it", "2 permissions are deleted with it"}},
+		},
+		{
+			name: "history only",
+			a:    Analysis{Movements: MovementSummary{Count: 12}, Permissions: perms},
+			want: Recommendation{Action: ActionDeactivate,
+				Reasons: []string{"it has no stock but its history is referenced; deleting would lose it"}},
+		},
+		{
+			name: "open invoices",
+			a:    Analysis{Invoices: InvoiceRefs{Count: 3, Open: 2}},
+			want: Recommendation{Action: ActionDeactivate, Reasons: []string{
+				"it has no stock but its history is referenced; deleting would lose it",
+				"2 open invoices name it; merge to point them at another warehouse"}},
+		},
+		{
+			name: "stock",
+			w:    Warehouse{IsDefault: true},
+			a:    Analysis{Stock: stocked, StockValue: money.MustParse("42.50"), Permissions: perms, Invoices: InvoiceRefs{Count: 1, Open: 1}},
+			want: Recommendation{Action: ActionMerge, Reasons: []string{
+				"it still holds 2 items worth 42.50; deactivating would strand the stock",
+				"2 permissions are copied to the target",
+				"the default flag moves to the target"}},
+		},
+		{
+			name: "blocked",
+			w:    Warehouse{IsDefault: true, IsPrimary: true},
+			a:    Analysis{EmptyLayers: 1, OpenTransfers: open},
+			want: Recommendation{Action: ActionDeactivate,
+				Reasons: []string{"it has no stock but its history is referenced; deleting would lose it"},
+				Blockers: []string{
+					"transfer TRF-7 to Mandalay is in_transit; complete or cancel it",
+					"transfer TRF-8 from Yangon is draft; complete or cancel it",
+					"it is its branch's primary warehouse; set another primary first",
+					"it is the organization's default warehouse; set another default first"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := tt.a
+			a.Warehouse = &tt.w
+			if got := recommend(&a); !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("recommend =\n%+v\nwant\n%+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasHistory(t *testing.T) {
+	tests := []struct {
+		name string
+		a    Analysis
+		want bool
+	}{
+		{name: "nothing"},
+		{name: "stock and permissions only", a: Analysis{Stock: []*StockLine{{}}, Permissions: []*Permission{{}}}},
+		{name: "empty layers", a: Analysis{EmptyLayers: 1}, want: true},
+		{name: "movements", a: Analysis{Movements: MovementSummary{Count: 1}}, want: true},
+		{name: "opening balances", a: Analysis{OpeningBalances: 1}, want: true},
+		{name: "closed transfers", a: Analysis{ClosedTransfers: 1}, want: true},
+		{name: "invoices", a: Analysis{Invoices: InvoiceRefs{Count: 1}}, want: true},
+	}
+	for _, tt := range tests {
+		if got := tt.a.HasHistory(); got != tt.want {
+			t.Errorf("%s: HasHistory = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInvoiceMatch(t *testing.T) {
+	tests := []struct {
+		w    Warehouse
+		cond string
+		args []any
+	}{
+		{w: Warehouse{ID: "wh1", Name: "Main"}, cond: "i.warehouse IN (?, ?)", args: []any{"wh1", "Main"}},
+		{w: Warehouse{ID: "wh1", Name: "Main", Code: "YGN-MAIN"}, cond: "i.warehouse IN (?, ?, ?)",
+			args: []any{"wh1", "Main", "YGN-MAIN"}},
+	}
+	for _, tt := range tests {
+		cond, args := invoiceMatch(&tt.w)
+		if cond != tt.cond || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("invoiceMatch(%+v) = %q, %v", tt.w, cond, args)
+		}
+	}
+}
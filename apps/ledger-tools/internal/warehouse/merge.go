@@ -0,0 +1,479 @@
+package warehouse
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/cashflow"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/inventory"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/ledgerlock"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/money"
+	"github.com/zayar/openbookkeeping/apps/ledger-tools/internal/schema"
+)
+
+// Tables are the cashflowdb tables owned by the warehouse module.
+var Tables = []schema.Table{
+	{
+		Name: "warehouse_merges",
+		Create: `CREATE TABLE IF NOT EXISTS warehouse_merges (
+  id VARCHAR(191) NOT NULL,
+  organization_id VARCHAR(191) NOT NULL,
+  source_id VARCHAR(191) NOT NULL,
+  source_name VARCHAR(191) NOT NULL,
+  target_id VARCHAR(191) NOT NULL,
+  target_name VARCHAR(191) NOT NULL,
+  transfer_id VARCHAR(191) NULL,
+  journal_id VARCHAR(191) NULL,
+  reason TEXT NOT NULL,
+  merged_by VARCHAR(191) NOT NULL,
+  merged_at DATETIME(3) NOT NULL,
+  summary TEXT NOT NULL,
+  PRIMARY KEY (id),
+  INDEX warehouse_merges_org_idx (organization_id, merged_at)
+) ENGINE=InnoDB`,
+	},
+}
+
+// MergeParams says which warehouse to merge into which.
+type MergeParams struct {
+	OrganizationID string
+	SourceID       string
+	TargetID       string
+	Reason         string
+	By             string
+	// DryRun runs the whole merge and rolls it back.
+	DryRun bool
+}
+
+// Merge is a recorded merge.
+type Merge struct {
+	ID             string       `json:"id"`
+	OrganizationID string       `json:"organizationId"`
+	Source         *Warehouse   `json:"source"`
+	Target         *Warehouse   `json:"target"`
+	TransferID     string       `json:"transferId,omitempty"`
+	TransferNumber string       `json:"transferNumber,omitempty"`
+	JournalID      string       `json:"journalId,omitempty"`
+	Reason         string       `json:"reason"`
+	MergedBy       string       `json:"mergedBy"`
+	MergedAt       time.Time    `json:"mergedAt"`
+	Summary        MergeSummary `json:"summary"`
+	DryRun         bool         `json:"dryRun,omitempty"`
+}
+
+// MergeSummary counts what a merge moved.
+type MergeSummary struct {
+	Items     int          `json:"items"`
+	Layers    int          `json:"layers"`
+	Quantity  float64      `json:"quantity"`
+	Value     money.Amount `json:"value"`
+	Movements int          `json:"movements"` // history re-pointed
+	// EmptyLayers are consumed layers re-pointed with their movements.
+	EmptyLayers     int `json:"emptyLayers"`
+	OpeningBalances int `json:"openingBalances"`
+	// OpeningBalancesKept stay on the source: the target already has one
+	// for the item.
+	OpeningBalancesKept int  `json:"openingBalancesKept"`
+	Invoices            int  `json:"invoices"`
+	Permissions         int  `json:"permissions"` // copied to the target
+	DefaultMoved        bool `json:"defaultMoved"`
+}
+
+var errDryRun = errors.New("dry run")
+
+// MergeWarehouses moves a warehouse's stock and history into another one
+// of the same organization and deactivates it.
+//
+// The remaining stock leaves through a completed inventory transfer: each
+// layer is emptied with a transfer_out movement and re-created in the
+// target with its unit cost, batch, expiry and original date, so FIFO
+// order is kept, plus a transfer_in movement. The transfer's journal moves
+// the value between the items' inventory accounts. Then the source's
+// movements and layers are re-pointed to the target, the transfer's own
+// outgoing half included, so every layer stays with its movements; so are
+// its opening balances, unless the target has one for the item, and the
+// invoices naming it. Permissions are copied and the default flag moves.
+//
+// Posted movements are immutable under the ledger lock, so the merge runs
+// with it bypassed for the reason given; every row touched is logged.
+func MergeWarehouses(ctx context.Context, conn *sql.DB, p MergeParams, now time.Time) (*Merge, error) {
+	if p.SourceID == p.TargetID {
+		return nil, errors.New("a warehouse cannot be merged into itself")
+	}
+	if strings.TrimSpace(p.Reason) == "" {
+		return nil, errors.New("a reason is required to merge warehouses")
+	}
+	m := &Merge{ID: cashflow.NewID("whmerge"), OrganizationID: p.OrganizationID, Reason: p.Reason, MergedBy: p.By,
+		MergedAt: now, DryRun: p.DryRun}
+	reason := fmt.Sprintf("warehouse merge %s: %s", m.ID, p.Reason)
+	err := ledgerlock.Bypass(ctx, conn, reason, func(tx *sql.Tx) error {
+		if err := merge(ctx, tx, m, p, now); err != nil {
+			return err
+		}
+		if p.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+	return m, nil
+}
+
+func merge(ctx context.Context, tx *sql.Tx, m *Merge, p MergeParams, now time.Time) error {
+	// Lock both rows so nothing starts a transfer or flips a flag meanwhile.
+	locked, err := tx.QueryContext(ctx, `SELECT id FROM warehouses WHERE id IN (?, ?) FOR UPDATE`, p.SourceID, p.TargetID)
+	if err != nil {
+		return err
+	}
+	locked.Close()
+	a, err := Analyze(ctx, tx, p.OrganizationID, p.SourceID)
+	if err != nil {
+		return err
+	}
+	target, err := Get(ctx, tx, p.OrganizationID, p.TargetID)
+	if err != nil {
+		return err
+	}
+	source := a.Warehouse
+	m.Source, m.Target = source, target
+	if !target.IsActive {
+		return fmt.Errorf("target warehouse %s is inactive", target.Name)
+	}
+	if len(a.OpenTransfers) > 0 {
+		return fmt.Errorf("%s has %d open transfers; complete or cancel them first", source.Name, len(a.OpenTransfers))
+	}
+	if source.IsPrimary {
+		return fmt.Errorf("%s is its branch's primary warehouse; set another primary first", source.Name)
+	}
+
+	if len(a.Stock) > 0 {
+		if err := transferStock(ctx, tx, m, now); err != nil {
+			return err
+		}
+	}
+	if err := moveHistory(ctx, tx, m, a, now); err != nil {
+		return err
+	}
+
+	notes := fmt.Sprintf("Merged into %s on %s (%s)", target.Name, now.Format(time.DateOnly), m.ID)
+	if source.IsDefault {
+		if _, err := tx.ExecContext(ctx, `UPDATE warehouses SET isDefault = true, updatedAt = ? WHERE id = ?`, now, target.ID); err != nil {
+			return err
+		}
+		m.Summary.DefaultMoved = true
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE warehouses SET isActive = false, isDefault = false,
+		  notes = TRIM(CONCAT(COALESCE(notes, ''), '\n', ?)), updatedAt = ?
+		WHERE id = ?`, notes, now, source.ID); err != nil {
+		return err
+	}
+
+	summary, err := json.Marshal(m.Summary)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO warehouse_merges (id, organization_id, source_id, source_name, target_id, target_name,
+		  transfer_id, journal_id, reason, merged_by, merged_at, summary)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.ID, m.OrganizationID, source.ID, source.Name, target.ID, target.Name,
+		cashflow.NullString(m.TransferID), cashflow.NullString(m.JournalID), m.Reason, m.MergedBy, now, summary)
+	return err
+}
+
+// item is one item's share of the merge transfer.
+type item struct {
+	id, name, account string
+	quantity          float64
+	value             money.Amount
+}
+
+// transferStock moves every layer with stock into the target under a
+// completed transfer and posts its journal.
+func transferStock(ctx context.Context, tx *sql.Tx, m *Merge, now time.Time) error {
+	source, target := m.Source, m.Target
+	m.TransferID = cashflow.NewID("transfer")
+	m.TransferNumber = "MRG-" + strings.TrimPrefix(m.TransferID, "transfer_")
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT l.id, l.itemId, COALESCE(p.name, l.itemId), COALESCE(p.inventoryAccountId, ''),
+		       l.quantityRemaining, l.unitCost, l.batchNumber, l.expiryDate, l.createdAt
+		FROM inventory_layers l LEFT JOIN products p ON p.id = l.itemId
+		WHERE l.warehouseId = ? AND l.quantityRemaining > ?
+		ORDER BY l.itemId, l.createdAt, l.id
+		FOR UPDATE`, source.ID, inventory.QuantityEpsilon)
+	if err != nil {
+		return err
+	}
+	type layer struct {
+		id, itemID    string
+		quantity      float64
+		unitCost      float64
+		batch         sql.NullString
+		expiry        sql.NullTime
+		created       time.Time
+		name, account string
+	}
+	var layers []layer
+	for rows.Next() {
+		var l layer
+		if err := rows.Scan(&l.id, &l.itemID, &l.name, &l.account, &l.quantity, &l.unitCost, &l.batch, &l.expiry, &l.created); err != nil {
+			rows.Close()
+			return err
+		}
+		layers = append(layers, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	items := map[string]*item{}
+	var movements []string
+	for _, l := range layers {
+		value := money.FromFloat(l.quantity * l.unitCost)
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE inventory_layers SET quantityRemaining = 0, updatedAt = ? WHERE id = ?`, now, l.id); err != nil {
+			return err
+		}
+		out := cashflow.NewID("movement")
+		if err := insertMovement(ctx, tx, out, l.itemID, source.ID, l.id, "out", "transfer_out", l.quantity, l.unitCost, value, m, now); err != nil {
+			return err
+		}
+		layerID := cashflow.NewID("layer")
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO inventory_layers (id, itemId, warehouseId, quantityRemaining, unitCost, sourceType, sourceId,
+			  batchNumber, expiryDate, createdAt, updatedAt)
+			VALUES (?, ?, ?, ?, ?, 'transfer', ?, ?, ?, ?, ?)`,
+			layerID, l.itemID, target.ID, l.quantity, l.unitCost, m.TransferID, l.batch, l.expiry, l.created, now); err != nil {
+			return err
+		}
+		in := cashflow.NewID("movement")
+		if err := insertMovement(ctx, tx, in, l.itemID, target.ID, layerID, "in", "transfer_in", l.quantity, l.unitCost, value, m, now); err != nil {
+			return err
+		}
+		movements = append(movements, out, in)
+
+		it := items[l.itemID]
+		if it == nil {
+			it = &item{id: l.itemID, name: l.name, account: l.account}
+			items[l.itemID] = it
+		}
+		it.quantity += l.quantity
+		it.value += value
+		m.Summary.Layers++
+		m.Summary.Quantity += l.quantity
+		m.Summary.Value += value
+	}
+	m.Summary.Items = len(items)
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO inventory_transfers (id, organizationId, transferNumber, fromWarehouseId, toWarehouseId, status,
+		  transferDate, completedDate, notes, totalValue, createdBy, approvedBy, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, ?, 'completed', ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.TransferID, m.OrganizationID, m.TransferNumber, source.ID, target.ID, now, now,
+		fmt.Sprintf("Merge of %s into %s: %s", source.Name, target.Name, m.Reason), m.Summary.Value, m.MergedBy, m.MergedBy, now, now); err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(items))
+	for id := range items {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		it := items[id]
+		unitCost := 0.0
+		if it.quantity > 0 {
+			unitCost = it.value.Float() / it.quantity
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO inventory_transfer_items (id, transferId, itemId, quantity, unitCost, totalValue, createdAt, updatedAt)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			cashflow.NewID("transfer_item"), m.TransferID, it.id, it.quantity, unitCost, it.value, now, now); err != nil {
+			return err
+		}
+	}
+
+	if m.Summary.Value == 0 {
+		return nil
+	}
+	j, err := transferJournal(ctx, tx, m, items, ids, now)
+	if err != nil {
+		return err
+	}
+	m.JournalID = j.ID
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE inventory_transfers SET journalId = ?, updatedAt = ? WHERE id = ?`, j.ID, now, m.TransferID); err != nil {
+		return err
+	}
+	return inventory.LinkJournal(ctx, tx, j.ID, movements)
+}
+
+func insertMovement(ctx context.Context, tx *sql.Tx, id, itemID, warehouseID, layerID, direction, movementType string,
+	quantity, unitCost float64, value money.Amount, m *Merge, now time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO inventory_movements (id, itemId, warehouseId, layerId, direction, quantity, unitCost, totalValue,
+		  movementType, sourceType, sourceId, reference, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 'transfer', ?, ?, ?, ?)`,
+		id, itemID, warehouseID, layerID, direction, quantity, unitCost, value,
+		movementType, m.TransferID, m.TransferNumber, now, now)
+	return err
+}
+
+// transferJournal posts the transfer like createTransferJournal does for
+// warehouses in different cost centres: each item's value is credited out
+// of and debited into its inventory account, falling back to the
+// organization's stock account. Lines are per account and direction.
+func transferJournal(ctx context.Context, tx *sql.Tx, m *Merge, items map[string]*item, ids []string, now time.Time) (*cashflow.Journal, error) {
+	var fallback string
+	totals := map[string]money.Amount{}
+	var accounts []string
+	for _, id := range ids {
+		it := items[id]
+		account := it.account
+		if account == "" {
+			if fallback == "" {
+				var err error
+				if fallback, err = cashflow.AccountByType(ctx, tx, m.OrganizationID, "stock"); err != nil {
+					return nil, fmt.Errorf("%s has no inventory account: %w", it.name, err)
+				}
+			}
+			account = fallback
+		}
+		if _, ok := totals[account]; !ok {
+			accounts = append(accounts, account)
+		}
+		totals[account] += it.value
+	}
+
+	j := &cashflow.Journal{
+		OrganizationID: m.OrganizationID,
+		Number:         "TRF-" + m.TransferNumber,
+		Date:           now,
+		Reference:      "Inventory Transfer " + m.TransferNumber,
+		Notes:          fmt.Sprintf("Transfer from %s to %s (warehouse merge)", m.Source.Name, m.Target.Name),
+	}
+	for _, account := range accounts {
+		v := totals[account]
+		j.Lines = append(j.Lines,
+			cashflow.JournalLine{AccountID: account, Description: "Transfer out - " + m.Source.Name, Credit: v},
+			cashflow.JournalLine{AccountID: account, Description: "Transfer in - " + m.Target.Name, Debit: v})
+	}
+	return j, cashflow.PostJournal(ctx, tx, j)
+}
+
+// moveHistory re-points the source's layers, movements, opening balances
+// and invoices to the target and copies its permissions.
+func moveHistory(ctx context.Context, tx *sql.Tx, m *Merge, a *Analysis, now time.Time) error {
+	source, target := m.Source, m.Target
+	res, err := tx.ExecContext(ctx, `
+		UPDATE inventory_movements SET warehouseId = ?, updatedAt = ? WHERE warehouseId = ?`, target.ID, now, source.ID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	m.Summary.Movements = int(n)
+	res, err = tx.ExecContext(ctx, `
+		UPDATE inventory_layers SET warehouseId = ?, updatedAt = ? WHERE warehouseId = ?`, target.ID, now, source.ID)
+	if err != nil {
+		return err
+	}
+	n, _ = res.RowsAffected()
+	m.Summary.EmptyLayers = int(n)
+
+	// inventory_opening_balances is unique per item and warehouse.
+	rows, err := tx.QueryContext(ctx, `
+		SELECT s.id, t.id IS NOT NULL
+		FROM inventory_opening_balances s
+		LEFT JOIN inventory_opening_balances t ON t.itemId = s.itemId AND t.warehouseId = ?
+		WHERE s.warehouseId = ?`, target.ID, source.ID)
+	if err != nil {
+		return err
+	}
+	var movable []string
+	for rows.Next() {
+		var id string
+		var taken bool
+		if err := rows.Scan(&id, &taken); err != nil {
+			rows.Close()
+			return err
+		}
+		if taken {
+			m.Summary.OpeningBalancesKept++
+			continue
+		}
+		movable = append(movable, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range movable {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE inventory_opening_balances SET warehouseId = ?, updatedAt = ? WHERE id = ?`, target.ID, now, id); err != nil {
+			return err
+		}
+	}
+	m.Summary.OpeningBalances = len(movable)
+
+	// An invoice naming the source by id keeps an id; by code or name, it
+	// gets the target's name.
+	cond, args := invoiceMatch(source)
+	res, err = tx.ExecContext(ctx, `
+		UPDATE invoices i SET i.warehouse = IF(i.warehouse = ?, ?, ?), i.updatedAt = ?
+		WHERE i.organizationId = ? AND `+cond,
+		append([]any{source.ID, target.ID, target.Name, now, m.OrganizationID}, args...)...)
+	if err != nil {
+		return err
+	}
+	n, _ = res.RowsAffected()
+	m.Summary.Invoices = int(n)
+
+	for _, p := range a.Permissions {
+		res, err := tx.ExecContext(ctx, `
+			INSERT IGNORE INTO warehouse_permissions (id, warehouseId, userId, permission, createdAt, updatedAt)
+			VALUES (?, ?, ?, ?, ?, ?)`, cashflow.NewID("whperm"), target.ID, p.UserID, p.Permission, now, now)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		m.Summary.Permissions += int(n)
+	}
+	return nil
+}
+
+// Merges returns an organization's merges, newest first.
+func Merges(ctx context.Context, q cashflow.Querier, organizationID string) ([]*Merge, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, organization_id, source_id, source_name, target_id, target_name, COALESCE(transfer_id, ''),
+		       COALESCE(journal_id, ''), reason, merged_by, merged_at, summary
+		FROM warehouse_merges WHERE organization_id = ? ORDER BY merged_at DESC`, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Merge
+	for rows.Next() {
+		m := &Merge{Source: &Warehouse{}, Target: &Warehouse{}}
+		var summary []byte
+		if err := rows.Scan(&m.ID, &m.OrganizationID, &m.Source.ID, &m.Source.Name, &m.Target.ID, &m.Target.Name,
+			&m.TransferID, &m.JournalID, &m.Reason, &m.MergedBy, &m.MergedAt, &summary); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(summary, &m.Summary); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}